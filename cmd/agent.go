@@ -0,0 +1,331 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+var (
+	agentSocket   string
+	agentLifetime time.Duration
+	agentConfirm  bool
+	agentAskpass  string
+)
+
+// agentCmd represents the agent command
+var agentCmd = &cobra.Command{
+	Use:   "agent [-- command [args...]]",
+	Short: "Run an SSH agent",
+	Long: `Run an SSH agent holding private keys in memory, speaking the ssh-agent
+protocol on a Unix socket, so gossh, ssh and any other SSH client can log in
+with the keys without reading them from disk or asking for their passphrases
+again. Clients add, list and remove keys, and lock the agent with a
+passphrase, as they would with OpenSSH's ssh-agent.
+
+The agent prints the shell commands setting SSH_AUTH_SOCK to point clients at
+it and serves until it is interrupted, or runs a command with SSH_AUTH_SOCK set
+and stops when the command exits. The socket is made in a private directory unless
+--socket names one, and only this user may connect to it.
+
+--lifetime gives keys added without a lifetime one. Keys added with
+confirmation, or every key with --confirm, are only used once the user agrees,
+through the --askpass program (SSH_ASKPASS by default) or else on the terminal
+the agent runs in.
+
+Examples:
+  # Serve on a fixed socket
+  gossh agent --socket ~/.gossh/agent.sock
+
+  # Run a shell with its own agent, forgetting keys after an hour
+  gossh agent --lifetime 1h -- bash
+
+  # Ask before every use of a key
+  gossh agent --confirm --askpass /usr/lib/ssh/ssh-askpass`,
+	Args: cobra.ArbitraryArgs,
+	// The SSH_AUTH_SOCK line goes to stdout, for eval
+	Annotations: map[string]string{machineOutputAnnotation: ""},
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Fprintln(os.Stderr, errorColor("✗ ")+err.Error())
+			os.Exit(1)
+		}
+		log.SetOutput(os.Stderr)
+		if agentLifetime < 0 {
+			fail(errors.New("--lifetime can't be negative"))
+		}
+		if agentAskpass == "" {
+			agentAskpass = os.Getenv("SSH_ASKPASS")
+		}
+
+		listener, socket, err := listenAgent(agentSocket)
+		if err != nil {
+			fail(err)
+		}
+		defer listener.Close()
+		confirmer := &agentConfirmer{askpass: agentAskpass}
+		keyring := newAgentKeyring(agentLifetime, agentConfirm, confirmer.confirm)
+		go serveAgent(listener, keyring)
+		log.Infof("Agent listening on %s", socket)
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if len(args) == 0 {
+			fmt.Printf("SSH_AUTH_SOCK=%s; export SSH_AUTH_SOCK;\n", socket)
+			<-ctx.Done()
+			log.Info("Agent stopped")
+			return
+		}
+		child := exec.Command(args[0], args[1:]...)
+		child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+		child.Env = append(os.Environ(), "SSH_AUTH_SOCK="+socket)
+		err = child.Run()
+		listener.Close()
+		var exitErr *exec.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			os.Exit(exitErr.ExitCode())
+		case err != nil:
+			fail(fmt.Errorf("failed to run %s: %s", args[0], err))
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().StringVarP(&agentSocket, "socket", "a", "", "Unix socket to listen on (default: one in a new private directory)")
+	agentCmd.Flags().DurationVarP(&agentLifetime, "lifetime", "t", 0, "Forget keys added without a lifetime after this long (0 keeps them)")
+	agentCmd.Flags().BoolVarP(&agentConfirm, "confirm", "c", false, "Ask before every use of every key")
+	agentCmd.Flags().StringVar(&agentAskpass, "askpass", "", "Program asked to confirm key use, which agrees by exiting with 0 (default: $SSH_ASKPASS)")
+}
+
+// listenAgent listens on the Unix socket path, or on one in a new private
+// directory when path is empty, which only this user may connect to. The
+// socket, and the directory, are removed when the listener is closed.
+func listenAgent(path string) (net.Listener, string, error) {
+	dir := ""
+	if path == "" {
+		var err error
+		if dir, err = os.MkdirTemp("", "gossh-agent-"); err != nil {
+			return nil, "", fmt.Errorf("could not create agent socket directory: %s", err)
+		}
+		path = filepath.Join(dir, fmt.Sprintf("agent.%d", os.Getpid()))
+	}
+	path = expandHome(path)
+	listener, err := net.Listen("unix", path)
+	if err == nil {
+		err = os.Chmod(path, 0o600)
+	}
+	if err != nil {
+		if listener != nil {
+			listener.Close()
+		}
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+		return nil, "", fmt.Errorf("could not listen on agent socket: %s", err)
+	}
+	if dir == "" {
+		return listener, path, nil
+	}
+	return &agentListener{Listener: listener, dir: dir}, path, nil
+}
+
+// agentListener removes the private directory of its socket once closed
+type agentListener struct {
+	net.Listener
+	dir  string
+	once sync.Once
+}
+
+func (l *agentListener) Close() error {
+	err := l.Listener.Close()
+	l.once.Do(func() { os.RemoveAll(l.dir) })
+	return err
+}
+
+// serveAgent serves the agent protocol for a on each connection to
+// listener, until it is closed
+func serveAgent(listener net.Listener, a agent.Agent) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := agent.ServeAgent(a, conn); err != nil && !errors.Is(err, io.EOF) {
+				log.Debugf("Agent connection ended: %s", err)
+			}
+		}()
+	}
+}
+
+// agentKeyring holds the keys of gossh agent. Keys added without a lifetime
+// get the default one, and keys added with confirmation, or every key when
+// confirmAll is set, are only used for signing once confirm agrees.
+type agentKeyring struct {
+	agent.ExtendedAgent
+	lifetime   time.Duration
+	confirmAll bool
+	confirm    func(key *agent.Key) bool
+
+	mu sync.Mutex
+	// confirmKeys holds the marshaled public keys added with confirmation
+	confirmKeys map[string]bool
+}
+
+// newAgentKeyring returns an empty, unlocked keyring
+func newAgentKeyring(lifetime time.Duration, confirmAll bool, confirm func(key *agent.Key) bool) *agentKeyring {
+	return &agentKeyring{
+		ExtendedAgent: agent.NewKeyring().(agent.ExtendedAgent),
+		lifetime:      lifetime,
+		confirmAll:    confirmAll,
+		confirm:       confirm,
+		confirmKeys:   map[string]bool{},
+	}
+}
+
+func (k *agentKeyring) Add(key agent.AddedKey) error {
+	// A constraint the agent can't enforce must not be silently dropped
+	if len(key.ConstraintExtensions) > 0 {
+		return fmt.Errorf("unsupported key constraint %s", key.ConstraintExtensions[0].ExtensionName)
+	}
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	pub := signer.PublicKey()
+	if key.Certificate != nil {
+		pub = key.Certificate
+	}
+	if key.LifetimeSecs == 0 && k.lifetime > 0 {
+		key.LifetimeSecs = uint32((k.lifetime + time.Second - 1) / time.Second)
+	}
+	if err := k.ExtendedAgent.Add(key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key.ConfirmBeforeUse {
+		k.confirmKeys[string(pub.Marshal())] = true
+	} else {
+		delete(k.confirmKeys, string(pub.Marshal()))
+	}
+	log.Infof("Added %s key %s %s", pub.Type(), ssh.FingerprintSHA256(pub), key.Comment)
+	return nil
+}
+
+func (k *agentKeyring) Remove(key ssh.PublicKey) error {
+	if err := k.ExtendedAgent.Remove(key); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.confirmKeys, string(key.Marshal()))
+	log.Infof("Removed %s key %s", key.Type(), ssh.FingerprintSHA256(key))
+	return nil
+}
+
+func (k *agentKeyring) RemoveAll() error {
+	if err := k.ExtendedAgent.RemoveAll(); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.confirmKeys = map[string]bool{}
+	log.Info("Removed all keys")
+	return nil
+}
+
+func (k *agentKeyring) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return k.SignWithFlags(key, data, 0)
+}
+
+func (k *agentKeyring) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if err := k.confirmUse(key); err != nil {
+		return nil, err
+	}
+	return k.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+// confirmUse asks whether key may sign, when it needs confirmation. Keys the
+// keyring doesn't hold, or holds locked, are left to it to refuse.
+func (k *agentKeyring) confirmUse(key ssh.PublicKey) error {
+	blob := key.Marshal()
+	k.mu.Lock()
+	needed := k.confirmAll || k.confirmKeys[string(blob)]
+	k.mu.Unlock()
+	if !needed {
+		return nil
+	}
+	keys, err := k.ExtendedAgent.List()
+	if err != nil {
+		return err
+	}
+	for _, held := range keys {
+		if !bytes.Equal(held.Blob, blob) {
+			continue
+		}
+		if !k.confirm(held) {
+			log.Warnf("Use of %s key %s refused", held.Type(), ssh.FingerprintSHA256(held))
+			return errors.New("use of the key was not confirmed")
+		}
+		return nil
+	}
+	return nil
+}
+
+// agentConfirmer asks the user whether a key may be used, through an
+// askpass program like ssh-agent does, or else on the terminal
+type agentConfirmer struct {
+	askpass string
+	// mu keeps to one question at a time
+	mu sync.Mutex
+}
+
+// confirm reports whether the user agreed to the use of key
+func (c *agentConfirmer) confirm(key *agent.Key) bool {
+	prompt := fmt.Sprintf("Allow use of key %s?\nKey fingerprint %s.", key.Comment, ssh.FingerprintSHA256(key))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.askpass != "" {
+		cmd := exec.Command(c.askpass, prompt)
+		cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+		out, err := cmd.Output()
+		if err != nil {
+			log.Debugf("%s: %s", c.askpass, err)
+			return false
+		}
+		answer := strings.TrimSpace(string(out))
+		return answer == "" || strings.EqualFold(answer, "yes")
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		log.Warn("No --askpass program or terminal to confirm key use with")
+		return false
+	}
+	fmt.Fprint(os.Stderr, prompt+" (yes/no)? ")
+	answer, _ := readLine()
+	return strings.EqualFold(strings.TrimSpace(answer), "yes")
+}
@@ -0,0 +1,184 @@
+// cmd/agent_test.go
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startAgent serves keyring on a socket in a private directory and returns
+// a client connected to it
+func startAgent(t *testing.T, keyring agent.Agent) agent.ExtendedAgent {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires Unix sockets")
+	}
+	listener, socket, err := listenAgent("")
+	if err != nil {
+		t.Fatalf("listenAgent failed: %v", err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+		if _, err := os.Stat(filepath.Dir(socket)); !os.IsNotExist(err) {
+			t.Errorf("socket directory left behind: %v", err)
+		}
+	})
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v", info.Mode(), err)
+	}
+	go serveAgent(listener, keyring)
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("could not connect to agent: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return agent.NewClient(conn)
+}
+
+// newAgentTestKey returns a new ed25519 private key and its public key
+func newAgentTestKey(t *testing.T) (ed25519.PrivateKey, ssh.PublicKey) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return private, signer.PublicKey()
+}
+
+func TestAgentKeys(t *testing.T) {
+	client := startAgent(t, newAgentKeyring(0, false, nil))
+	private, pub := newAgentTestKey(t)
+	if err := client.Add(agent.AddedKey{PrivateKey: private, Comment: "alice@laptop"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	keys, err := client.List()
+	if err != nil || len(keys) != 1 || keys[0].Comment != "alice@laptop" || string(keys[0].Blob) != string(pub.Marshal()) {
+		t.Fatalf("List = %v, %v", keys, err)
+	}
+	data := []byte("session")
+	sig, err := client.Sign(pub, data)
+	if err != nil || pub.Verify(data, sig) != nil {
+		t.Errorf("Sign = %v, %v", sig, err)
+	}
+
+	if err := client.Lock([]byte("secret")); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if keys, _ := client.List(); len(keys) != 0 {
+		t.Errorf("a locked agent lists %d keys", len(keys))
+	}
+	if _, err := client.Sign(pub, data); err == nil {
+		t.Error("a locked agent should not sign")
+	}
+	if err := client.Unlock([]byte("wrong")); err == nil {
+		t.Error("unlocking with the wrong passphrase should fail")
+	}
+	if err := client.Unlock([]byte("secret")); err != nil {
+		t.Errorf("Unlock failed: %v", err)
+	}
+
+	if err := client.Remove(pub); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+	if keys, _ := client.List(); len(keys) != 0 {
+		t.Errorf("%d keys left after removing the only one", len(keys))
+	}
+	// The agent client can't send constraint extensions, so add directly
+	if err := newAgentKeyring(0, false, nil).Add(agent.AddedKey{PrivateKey: private, ConstraintExtensions: []agent.ConstraintExtension{
+		{ExtensionName: "restrict-destination-v00@openssh.com"},
+	}}); err == nil {
+		t.Error("a key with an unsupported constraint should be refused")
+	}
+}
+
+func TestAgentLifetime(t *testing.T) {
+	client := startAgent(t, newAgentKeyring(time.Second, false, nil))
+	short, _ := newAgentTestKey(t)
+	long, longPub := newAgentTestKey(t)
+	if err := client.Add(agent.AddedKey{PrivateKey: short}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Add(agent.AddedKey{PrivateKey: long, LifetimeSecs: 60}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	keys, err := client.List()
+	if err != nil || len(keys) != 1 || string(keys[0].Blob) != string(longPub.Marshal()) {
+		t.Errorf("keys after the default lifetime = %v, %v", keys, err)
+	}
+}
+
+func TestAgentConfirm(t *testing.T) {
+	answer, asked := false, 0
+	confirm := func(key *agent.Key) bool {
+		asked++
+		return answer
+	}
+	for _, confirmAll := range []bool{false, true} {
+		answer, asked = false, 0
+		client := startAgent(t, newAgentKeyring(0, confirmAll, confirm))
+		plain, plainPub := newAgentTestKey(t)
+		guarded, guardedPub := newAgentTestKey(t)
+		if err := client.Add(agent.AddedKey{PrivateKey: plain}); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Add(agent.AddedKey{PrivateKey: guarded, ConfirmBeforeUse: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := client.Sign(guardedPub, []byte("data")); err == nil {
+			t.Errorf("confirmAll %v: signing with a refused key should fail", confirmAll)
+		}
+		_, err := client.Sign(plainPub, []byte("data"))
+		if confirmAll != (err != nil) {
+			t.Errorf("confirmAll %v: signing with an unconfirmed key = %v", confirmAll, err)
+		}
+		answer = true
+		if _, err := client.Sign(guardedPub, []byte("data")); err != nil {
+			t.Errorf("confirmAll %v: signing with a confirmed key = %v", confirmAll, err)
+		}
+		if want := map[bool]int{false: 2, true: 3}[confirmAll]; asked != want {
+			t.Errorf("confirmAll %v: asked %d times, want %d", confirmAll, asked, want)
+		}
+	}
+}
+
+func TestAgentConfirmer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	_, pub := newAgentTestKey(t)
+	key := &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: "alice@laptop"}
+	dir := t.TempDir()
+	tests := []struct {
+		script string
+		want   bool
+	}{
+		{`test "$SSH_ASKPASS_PROMPT" = confirm`, true},
+		{"echo yes", true},
+		{"echo no", false},
+		{"exit 1", false},
+	}
+	for i, tt := range tests {
+		askpass := filepath.Join(dir, fmt.Sprintf("askpass%d", i))
+		writeFile(t, askpass, "#!/bin/sh\n"+tt.script+"\n", 0o755)
+		c := &agentConfirmer{askpass: askpass}
+		if got := c.confirm(key); got != tt.want {
+			t.Errorf("confirm with %q = %v, want %v", tt.script, got, tt.want)
+		}
+	}
+}
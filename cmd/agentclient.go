@@ -0,0 +1,464 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	agentFingerprints []string
+	agentRemoveAll    bool
+	agentPublicKeys   bool
+)
+
+// defaultAgentKeys are the identity files gossh agent add adds when given
+// none, those of them that exist, like ssh-add
+var defaultAgentKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// agentAddCmd represents the agent add command
+var agentAddCmd = &cobra.Command{
+	Use:   "add [key...]",
+	Short: "Add private keys to an agent",
+	Long: `Add private keys to the agent at SSH_AUTH_SOCK, or at --socket, which may be
+gossh agent or OpenSSH's ssh-agent. Without keys, ~/.ssh/id_ed25519,
+~/.ssh/id_ecdsa and ~/.ssh/id_rsa are added, those that exist. Encrypted keys
+are asked for their passphrase.
+
+A key's <key>-cert.pub certificate is added along with it, as are the --cert
+certificates that certify it, so servers trusting the certificate authority
+accept the agent's key.
+
+Examples:
+  # Add the default keys
+  gossh agent add
+
+  # Add a key for the next 8 hours, confirming each use
+  gossh agent add ~/.ssh/deploy_ed25519 --lifetime 8h --confirm
+
+  # Add a key with a certificate kept elsewhere
+  gossh agent add ~/.ssh/id_ed25519 --cert ~/certs/alice-cert.pub`,
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		if agentLifetime < 0 {
+			fail(errors.New("--lifetime can't be negative"))
+		}
+		paths := args
+		if len(paths) == 0 {
+			var err error
+			if paths, err = existingDefaultAgentKeys(); err != nil {
+				fail(err)
+			}
+		}
+		client, err := dialAgentSocket(agentSocket)
+		if err != nil {
+			fail(err)
+		}
+
+		var keys []*privateKeyFile
+		failed := false
+		for _, path := range paths {
+			key, err := readPrivateKeyFile(path, promptKeyPassphrase)
+			if err != nil {
+				log.Error(err)
+				fmt.Println(errorColor("✗ ") + err.Error())
+				failed = true
+				continue
+			}
+			keys = append(keys, key)
+		}
+		if err := attachAgentCertificates(keys, certPaths); err != nil {
+			fail(err)
+		}
+		for _, key := range keys {
+			if err := key.addTo(client, agentLifetime, agentConfirm); err != nil {
+				log.Error(err)
+				fmt.Println(errorColor("✗ ") + err.Error())
+				failed = true
+				continue
+			}
+			fmt.Println(successColor("✓ ") + fmt.Sprintf("Identity added: %s (%s)", key.path, ssh.FingerprintSHA256(key.signer.PublicKey())))
+			for _, cert := range key.certs {
+				fmt.Println(successColor("✓ ") + fmt.Sprintf("Certificate added: %s (principals: %s)", cert.KeyId, orDash(strings.Join(cert.ValidPrincipals, ","))))
+			}
+		}
+		if failed {
+			os.Exit(1)
+		}
+	},
+}
+
+// agentListCmd represents the agent list command
+var agentListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the keys of an agent",
+	Long: `List the keys held by the agent at SSH_AUTH_SOCK, or at --socket, with their
+type, fingerprint and comment, or with --public-keys as authorized_keys lines.
+
+Examples:
+  # Show the agent's keys
+  gossh agent list
+
+  # Authorize the agent's keys on a server
+  gossh agent list --public-keys >> ~/.ssh/authorized_keys`,
+	Args: cobra.NoArgs,
+	// The keys go to stdout, for authorized_keys
+	Annotations: map[string]string{machineOutputAnnotation: ""},
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		client, err := dialAgentSocket(agentSocket)
+		if err != nil {
+			fail(err)
+		}
+		keys, err := client.List()
+		if err != nil {
+			fail(fmt.Errorf("failed to list keys: %s", err))
+		}
+		if len(keys) == 0 {
+			fmt.Fprintln(os.Stderr, "The agent has no keys")
+			os.Exit(1)
+		}
+		printAgentKeys(os.Stdout, keys, agentPublicKeys)
+	},
+}
+
+// agentRemoveCmd represents the agent remove command
+var agentRemoveCmd = &cobra.Command{
+	Use:   "remove [key...]",
+	Short: "Remove keys from an agent",
+	Long: `Remove keys from the agent at SSH_AUTH_SOCK, or at --socket. Keys are named by
+their public key file, or by the private key file with a .pub file next to it,
+which removes the key's certificates too; by --fingerprint; or all at once
+with --all.
+
+Examples:
+  # Remove a key and its certificates
+  gossh agent remove ~/.ssh/id_ed25519
+
+  # Remove a key seen in gossh agent list
+  gossh agent remove --fingerprint SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s
+
+  # Remove every key
+  gossh agent remove --all`,
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		switch {
+		case agentRemoveAll && (len(args) > 0 || len(agentFingerprints) > 0):
+			fail(errors.New("--all can't be combined with keys or --fingerprint"))
+		case !agentRemoveAll && len(args) == 0 && len(agentFingerprints) == 0:
+			fail(errors.New("name the keys to remove, by file or --fingerprint, or use --all"))
+		}
+		client, err := dialAgentSocket(agentSocket)
+		if err != nil {
+			fail(err)
+		}
+		if agentRemoveAll {
+			if err := client.RemoveAll(); err != nil {
+				fail(fmt.Errorf("failed to remove keys: %s", err))
+			}
+			fmt.Println(successColor("✓ ") + "All keys removed")
+			return
+		}
+
+		held, err := client.List()
+		if err != nil {
+			fail(fmt.Errorf("failed to list keys: %s", err))
+		}
+		keys, err := selectAgentKeys(held, args, agentFingerprints)
+		if err != nil {
+			fail(err)
+		}
+		for _, key := range keys {
+			if err := client.Remove(key); err != nil {
+				fail(fmt.Errorf("failed to remove %s: %s", ssh.FingerprintSHA256(key), err))
+			}
+			fmt.Println(successColor("✓ ") + fmt.Sprintf("Removed %s %s %s", key.Type(), ssh.FingerprintSHA256(key), key.Comment))
+		}
+	},
+}
+
+func init() {
+	agentCmd.AddCommand(agentAddCmd, agentListCmd, agentRemoveCmd)
+
+	for _, cmd := range []*cobra.Command{agentAddCmd, agentListCmd, agentRemoveCmd} {
+		cmd.Flags().StringVarP(&agentSocket, "socket", "a", "", "Agent socket to talk to (default: $SSH_AUTH_SOCK)")
+	}
+	agentAddCmd.Flags().DurationVarP(&agentLifetime, "lifetime", "t", 0, "Have the agent forget the keys after this long (0 keeps them)")
+	agentAddCmd.Flags().BoolVarP(&agentConfirm, "confirm", "c", false, "Have the agent ask before each use of the keys")
+	agentAddCmd.Flags().StringArrayVar(&certPaths, "cert", nil, "OpenSSH certificate to add with the key it certifies (repeatable)")
+	agentListCmd.Flags().BoolVarP(&agentPublicKeys, "public-keys", "L", false, "Print the keys as authorized_keys lines")
+	agentRemoveCmd.Flags().StringArrayVar(&agentFingerprints, "fingerprint", nil, "SHA256 fingerprint of a key to remove (repeatable)")
+	agentRemoveCmd.Flags().BoolVar(&agentRemoveAll, "all", false, "Remove every key")
+}
+
+// dialAgentSocket connects to the agent listening on socket, or on
+// SSH_AUTH_SOCK when it is empty
+func dialAgentSocket(socket string) (agent.ExtendedAgent, error) {
+	if socket == "" {
+		socket = os.Getenv("SSH_AUTH_SOCK")
+	}
+	if socket == "" {
+		return nil, errors.New("no agent: SSH_AUTH_SOCK is not set, and --socket names none")
+	}
+	conn, err := net.Dial("unix", expandHome(socket))
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to agent: %s", err)
+	}
+	return agent.NewClient(conn), nil
+}
+
+// existingDefaultAgentKeys returns the defaultAgentKeys in ~/.ssh that exist
+func existingDefaultAgentKeys() ([]string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("could not locate ~/.ssh: %s", err)
+	}
+	var paths []string
+	for _, name := range defaultAgentKeys {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no keys to add: none of %s in ~/.ssh exist", strings.Join(defaultAgentKeys, ", "))
+	}
+	return paths, nil
+}
+
+// promptKeyPassphrase asks on the terminal for the passphrase of the key
+// at path
+func promptKeyPassphrase(path string) (string, error) {
+	fmt.Printf("Enter passphrase for %s: ", path)
+	passphrase, err := readSecret()
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase: %s", err)
+	}
+	return passphrase, nil
+}
+
+// privateKeyFile is a private key read from a file, with the certificates
+// to add to an agent along with it
+type privateKeyFile struct {
+	path string
+	// comment is that of the .pub file next to the key, or else its path
+	comment string
+	key     interface{}
+	signer  ssh.Signer
+	certs   []*ssh.Certificate
+}
+
+// readPrivateKeyFile reads the private key at path, asking passphrase for
+// the passphrase of an encrypted one
+func readPrivateKeyFile(path string, passphrase func(path string) (string, error)) (*privateKeyFile, error) {
+	data, err := readKeySource(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key: %s", err)
+	}
+	key, err := ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var secret string
+		if secret, err = passphrase(path); err != nil {
+			return nil, err
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(secret))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %s", path, err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %s", path, err)
+	}
+	comment := path
+	if data, err := os.ReadFile(path + ".pub"); err == nil {
+		if _, pubComment, _, _, err := ssh.ParseAuthorizedKey(data); err == nil && pubComment != "" {
+			comment = pubComment
+		}
+	}
+	return &privateKeyFile{path: path, comment: comment, key: key, signer: signer}, nil
+}
+
+// attachAgentCertificates gives keys the certificates of certPaths that
+// certify them, and each its <key>-cert.pub file. A --cert certificate that
+// certifies none of the keys is an error; a mismatched or unreadable
+// adjacent one is skipped.
+func attachAgentCertificates(keys []*privateKeyFile, certPaths []string) error {
+	signers := make([]ssh.Signer, len(keys))
+	for i, key := range keys {
+		signers[i] = key.signer
+	}
+	for _, path := range certPaths {
+		cert, err := readUserCertificate(path)
+		if err != nil {
+			return err
+		}
+		i := certifiedKey(cert, signers)
+		if i < 0 {
+			return fmt.Errorf("certificate %s does not certify any of the keys", path)
+		}
+		keys[i].certs = append(keys[i].certs, cert)
+	}
+	for _, key := range keys {
+		path := key.path + certSuffix
+		cert, err := readUserCertificate(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Warn("Skipping certificate: ", err)
+			continue
+		}
+		if !bytes.Equal(cert.Key.Marshal(), key.signer.PublicKey().Marshal()) {
+			log.Warn("Skipping certificate ", path, ": it does not certify ", key.path)
+			continue
+		}
+		key.certs = append(key.certs, cert)
+	}
+	return nil
+}
+
+// addTo adds the key and its certificates to a, to be forgotten after
+// lifetime unless it is 0, and used only once confirmed when confirm is set
+func (k *privateKeyFile) addTo(a agent.Agent, lifetime time.Duration, confirm bool) error {
+	added := agent.AddedKey{
+		PrivateKey:       k.key,
+		Comment:          k.comment,
+		LifetimeSecs:     uint32((lifetime + time.Second - 1) / time.Second),
+		ConfirmBeforeUse: confirm,
+	}
+	if err := a.Add(added); err != nil {
+		return fmt.Errorf("failed to add %s: %s", k.path, err)
+	}
+	for _, cert := range k.certs {
+		added.Certificate = cert
+		if err := a.Add(added); err != nil {
+			return fmt.Errorf("failed to add the certificate %s of %s: %s", cert.KeyId, k.path, err)
+		}
+	}
+	return nil
+}
+
+// selectAgentKeys returns the keys of held named by the public key files
+// of paths, with their certificates, and by fingerprints. A key that isn't
+// held is an error.
+func selectAgentKeys(held []*agent.Key, paths, fingerprints []string) ([]*agent.Key, error) {
+	var selected []*agent.Key
+	seen := map[*agent.Key]bool{}
+	add := func(key *agent.Key) {
+		if !seen[key] {
+			seen[key] = true
+			selected = append(selected, key)
+		}
+	}
+	for _, path := range paths {
+		pub, _, err := readPublicKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, key := range held {
+			if bytes.Equal(key.Blob, pub.Marshal()) || bytes.Equal(certifiedBlob(key), pub.Marshal()) {
+				add(key)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("the agent doesn't hold the key of %s", path)
+		}
+	}
+	for _, fingerprint := range fingerprints {
+		found := false
+		for _, key := range held {
+			if ssh.FingerprintSHA256(key) == fingerprint {
+				add(key)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("the agent doesn't hold a key with fingerprint %s", fingerprint)
+		}
+	}
+	return selected, nil
+}
+
+// certifiedBlob returns the key a certificate held by an agent certifies,
+// or nil when key is not a certificate
+func certifiedBlob(key *agent.Key) []byte {
+	pub, err := ssh.ParsePublicKey(key.Blob)
+	if err != nil {
+		return nil
+	}
+	if cert, ok := pub.(*ssh.Certificate); ok {
+		return cert.Key.Marshal()
+	}
+	return nil
+}
+
+// readPublicKeyFile reads the public key or certificate in the file at
+// path, or in the .pub file next to it when it is a private key, and its
+// comment
+func readPublicKeyFile(path string) (ssh.PublicKey, string, error) {
+	var errs []error
+	for _, name := range []string{path, path + ".pub"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", name, err))
+			continue
+		}
+		return pub, comment, nil
+	}
+	return nil, "", fmt.Errorf("failed to read the public key of %s: %s", path, errors.Join(errs...))
+}
+
+// printAgentKeys writes a table of keys, or their authorized_keys lines
+// when public is set
+func printAgentKeys(out io.Writer, keys []*agent.Key, public bool) {
+	if public {
+		for _, key := range keys {
+			fmt.Fprintln(out, key.String())
+		}
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tFINGERPRINT\tCOMMENT")
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", key.Type(), ssh.FingerprintSHA256(key), orDash(key.Comment))
+	}
+	w.Flush()
+}
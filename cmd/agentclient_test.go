@@ -0,0 +1,172 @@
+// cmd/agentclient_test.go
+package cmd
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeAgentTestKey writes a new private key to path, encrypted when
+// passphrase isn't empty, with its public key next to it, and returns the
+// public key
+func writeAgentTestKey(t *testing.T, path, passphrase string) ssh.PublicKey {
+	t.Helper()
+	private, pub := newAgentTestKey(t)
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, path, string(pem.EncodeToMemory(block)), 0o600)
+	writeFile(t, path+".pub", string(ssh.MarshalAuthorizedKey(pub)), 0o644)
+	return pub
+}
+
+func TestAgentAddKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	plainPath, lockedPath := filepath.Join(dir, "id_plain"), filepath.Join(dir, "id_locked")
+	plainPub := writeAgentTestKey(t, plainPath, "")
+	lockedPub := writeAgentTestKey(t, lockedPath, "hunter2")
+	ca := newTestSigner(t)
+	writeTestCertificate(t, ca, lockedPub, ssh.UserCert, sshtest.DefaultUser, lockedPath+certSuffix)
+	explicit := filepath.Join(dir, "plain.crt")
+	writeTestCertificate(t, ca, plainPub, ssh.UserCert, "deploy", explicit)
+
+	asked := 0
+	passphrase := func(path string) (string, error) {
+		asked++
+		if path != lockedPath {
+			t.Errorf("asked for the passphrase of %s", path)
+		}
+		return "hunter2", nil
+	}
+	var keys []*privateKeyFile
+	for _, path := range []string{plainPath, lockedPath} {
+		key, err := readPrivateKeyFile(path, passphrase)
+		if err != nil {
+			t.Fatalf("readPrivateKeyFile(%s) failed: %v", path, err)
+		}
+		keys = append(keys, key)
+	}
+	if asked != 1 {
+		t.Errorf("asked for %d passphrases, want 1", asked)
+	}
+	if _, err := readPrivateKeyFile(lockedPath, func(string) (string, error) { return "wrong", nil }); err == nil {
+		t.Error("a wrong passphrase should fail")
+	}
+	if _, err := readPrivateKeyFile(lockedPath, func(string) (string, error) { return "", errors.New("no terminal") }); err == nil {
+		t.Error("a passphrase that can't be read should fail")
+	}
+
+	if err := attachAgentCertificates(keys, []string{explicit}); err != nil {
+		t.Fatalf("attachAgentCertificates failed: %v", err)
+	}
+	if len(keys[0].certs) != 1 || keys[0].certs[0].KeyId != "test-deploy" || len(keys[1].certs) != 1 {
+		t.Errorf("certificates = %v, %v", keys[0].certs, keys[1].certs)
+	}
+	stray := filepath.Join(dir, "stray.crt")
+	writeTestCertificate(t, ca, newTestSigner(t).PublicKey(), ssh.UserCert, "deploy", stray)
+	if err := attachAgentCertificates(keys, []string{stray}); err == nil {
+		t.Error("a certificate for none of the keys should fail")
+	}
+
+	keyring := newAgentKeyring(0, false, nil)
+	client := startAgent(t, keyring)
+	if err := keys[0].addTo(client, 0, false); err != nil {
+		t.Fatalf("addTo failed: %v", err)
+	}
+	if err := keys[1].addTo(client, 0, true); err != nil {
+		t.Fatalf("addTo failed: %v", err)
+	}
+	held, err := client.List()
+	if err != nil || len(held) != 4 {
+		t.Fatalf("List = %v, %v", held, err)
+	}
+	if held[0].Comment != plainPath || !bytes.Equal(held[0].Blob, plainPub.Marshal()) {
+		t.Errorf("first key = %v", held[0])
+	}
+	if !strings.HasSuffix(held[3].Type(), "-cert-v01@openssh.com") || !keyring.confirmKeys[string(held[3].Blob)] || keyring.confirmKeys[string(held[0].Blob)] {
+		t.Errorf("confirmation not requested as asked: %v", keyring.confirmKeys)
+	}
+
+	var out bytes.Buffer
+	printAgentKeys(&out, held[:1], false)
+	if want := "ssh-ed25519  " + ssh.FingerprintSHA256(plainPub) + "  " + plainPath + "\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("key table:\n%s\nwant a row %q", out.String(), want)
+	}
+	out.Reset()
+	printAgentKeys(&out, held[:1], true)
+	if want := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(plainPub))) + " " + plainPath + "\n"; out.String() != want {
+		t.Errorf("public keys = %q, want %q", out.String(), want)
+	}
+}
+
+func TestSelectAgentKeys(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "id_ed25519")
+	pub := writeAgentTestKey(t, path, "")
+	other := newTestSigner(t).PublicKey()
+	ca := newTestSigner(t)
+	certPath := filepath.Join(dir, "id_ed25519"+certSuffix)
+	writeTestCertificate(t, ca, pub, ssh.UserCert, "deploy", certPath)
+	cert, err := readUserCertificate(certPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held := []*agent.Key{
+		{Format: pub.Type(), Blob: pub.Marshal(), Comment: "mine"},
+		{Format: cert.Type(), Blob: cert.Marshal(), Comment: "mine"},
+		{Format: other.Type(), Blob: other.Marshal(), Comment: "other"},
+	}
+
+	tests := []struct {
+		paths        []string
+		fingerprints []string
+		want         []*agent.Key
+		wantErr      bool
+	}{
+		// A private key file names its public key and certificates
+		{paths: []string{path}, want: held[:2]},
+		{paths: []string{path + ".pub"}, want: held[:2]},
+		{paths: []string{certPath}, want: held[1:2]},
+		{fingerprints: []string{ssh.FingerprintSHA256(other)}, want: held[2:]},
+		{paths: []string{path}, fingerprints: []string{ssh.FingerprintSHA256(pub)}, want: held[:2]},
+		{fingerprints: []string{"SHA256:missing"}, wantErr: true},
+		{paths: []string{filepath.Join(dir, "missing")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := selectAgentKeys(held, tt.paths, tt.fingerprints)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("selectAgentKeys(%q, %q) error = %v, want error: %v", tt.paths, tt.fingerprints, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("selectAgentKeys(%q, %q) = %v, want %v", tt.paths, tt.fingerprints, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("selectAgentKeys(%q, %q) = %v, want %v", tt.paths, tt.fingerprints, got, tt.want)
+			}
+		}
+	}
+
+	// A private key is only named through the .pub file next to it
+	if err := os.Remove(path + ".pub"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := selectAgentKeys(held, []string{path}, nil); err == nil {
+		t.Error("a private key without a .pub file should fail")
+	}
+}
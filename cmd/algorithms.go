@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// algorithmKind is one kind of algorithm the client negotiates, as listed
+// by golang.org/x/crypto/ssh, which silently ignores names it doesn't know
+type algorithmKind struct {
+	name string
+	// defaults are the algorithms offered when no flag is given, in
+	// preference order
+	defaults []string
+	// supported adds the algorithms that are only offered on request
+	supported []string
+}
+
+// Algorithms of golang.org/x/crypto/ssh for --ciphers, --kex, --macs and
+// --host-key-algorithms
+var (
+	cipherAlgorithms = algorithmKind{
+		name: "cipher",
+		defaults: []string{
+			"aes128-gcm@openssh.com", "aes256-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+			"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		},
+		supported: []string{"aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour"},
+	}
+	kexAlgorithms = algorithmKind{
+		name: "key exchange",
+		defaults: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1",
+		},
+		supported: []string{
+			"diffie-hellman-group16-sha512", "diffie-hellman-group-exchange-sha256",
+			"diffie-hellman-group-exchange-sha1", "diffie-hellman-group1-sha1",
+		},
+	}
+	macAlgorithms = algorithmKind{
+		name: "MAC",
+		defaults: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+		},
+	}
+	hostKeyAlgorithms = algorithmKind{
+		name: "host key",
+		defaults: []string{
+			ssh.CertAlgoRSASHA256v01, ssh.CertAlgoRSASHA512v01,
+			ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01,
+			ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512,
+			ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+			ssh.KeyAlgoED25519,
+		},
+	}
+)
+
+// all returns every algorithm of the kind
+func (k algorithmKind) all() []string {
+	return append(slices.Clone(k.defaults), k.supported...)
+}
+
+// parse returns the algorithms a flag value selects, in preference order.
+// As in ssh_config, a comma-separated list replaces the defaults, and a list
+// starting with '+' appends to them, '-' removes from them and '^' puts
+// them first. An empty value keeps the defaults, returned as nil.
+func (k algorithmKind) parse(value string) ([]string, error) {
+	if value == "" {
+		return nil, nil
+	}
+	op := value[0]
+	if op == '+' || op == '-' || op == '^' {
+		value = value[1:]
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(k.all(), name) {
+			return nil, fmt.Errorf("unsupported %s algorithm %q (choose from %s)", k.name, name, strings.Join(k.all(), ", "))
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	switch op {
+	case '+':
+		algos := slices.Clone(k.defaults)
+		for _, name := range names {
+			if !slices.Contains(algos, name) {
+				algos = append(algos, name)
+			}
+		}
+		return algos, nil
+	case '-':
+		algos := slices.DeleteFunc(slices.Clone(k.defaults), func(name string) bool {
+			return slices.Contains(names, name)
+		})
+		if len(algos) == 0 {
+			return nil, fmt.Errorf("no %s algorithms left", k.name)
+		}
+		return algos, nil
+	case '^':
+		return append(names, slices.DeleteFunc(slices.Clone(k.defaults), func(name string) bool {
+			return slices.Contains(names, name)
+		})...), nil
+	}
+	return names, nil
+}
+
+// setAlgorithms restricts config to the algorithms chosen with --ciphers,
+// --kex, --macs and --host-key-algorithms
+func setAlgorithms(config *ssh.ClientConfig) error {
+	var err error
+	if config.Ciphers, err = cipherAlgorithms.parse(ciphers); err != nil {
+		return fmt.Errorf("--ciphers: %s", err)
+	}
+	if config.KeyExchanges, err = kexAlgorithms.parse(kexes); err != nil {
+		return fmt.Errorf("--kex: %s", err)
+	}
+	if config.MACs, err = macAlgorithms.parse(macs); err != nil {
+		return fmt.Errorf("--macs: %s", err)
+	}
+	if config.HostKeyAlgorithms, err = hostKeyAlgorithms.parse(hostKeyAlgos); err != nil {
+		return fmt.Errorf("--host-key-algorithms: %s", err)
+	}
+	return nil
+}
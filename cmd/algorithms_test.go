@@ -0,0 +1,75 @@
+// cmd/algorithms_test.go
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+)
+
+func TestAlgorithmKindParse(t *testing.T) {
+	kind := algorithmKind{name: "cipher", defaults: []string{"a", "b", "c"}, supported: []string{"old"}}
+
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"c,a", []string{"c", "a"}, false},
+		{"old, a,old", []string{"old", "a"}, false},
+		{"+old", []string{"a", "b", "c", "old"}, false},
+		{"+b", []string{"a", "b", "c"}, false},
+		{"-a,c", []string{"b"}, false},
+		{"-a,b,c", nil, true},
+		{"^c,old", []string{"c", "old", "a", "b"}, false},
+		{"new", nil, true},
+		{"+", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := kind.parse(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parse(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parse(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSetAlgorithms(t *testing.T) {
+	origCiphers, origKexes := ciphers, kexes
+	defer func() { ciphers, kexes = origCiphers, origKexes }()
+
+	// The server only speaks a cipher the client doesn't offer by default
+	server := sshtest.StartTestServer(t, ssh.ServerOptions{
+		AllowedCommands: []string{"whoami"},
+		Crypto:          ssh.CryptoPolicy{Ciphers: []string{"aes128-cbc"}},
+	})
+	config := *server.ClientConfig
+	ciphers, kexes = "", ""
+	if err := setAlgorithms(&config); err != nil {
+		t.Fatalf("setAlgorithms failed: %v", err)
+	}
+	if _, err := dialSSH("tcp", nil, server.Addr, &config); err == nil {
+		t.Error("the default ciphers should not include aes128-cbc")
+	}
+
+	ciphers, kexes = "+aes128-cbc", "curve25519-sha256"
+	if err := setAlgorithms(&config); err != nil {
+		t.Fatalf("setAlgorithms failed: %v", err)
+	}
+	client, err := dialSSH("tcp", nil, server.Addr, &config)
+	if err != nil {
+		t.Fatalf("dialing with --ciphers +aes128-cbc failed: %v", err)
+	}
+	client.Close()
+
+	ciphers = "aes128-cbc,rot13"
+	if err := setAlgorithms(&config); err == nil {
+		t.Error("an unknown cipher should be rejected")
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+var (
+	replaySpeed   float64
+	replayMaxIdle time.Duration
+)
+
+// auditCmd groups the commands that work with server audit data
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Inspect server audit data",
+	Long: `The audit command works with the audit data written by gossh server,
+such as the session recordings saved with --record-dir.`,
+}
+
+// replayCmd represents the audit replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay <file>",
+	Short: "Play back a recorded session",
+	Long: `The replay command plays an asciicast v2 session recording in the terminal,
+at the original pace or faster.
+
+Examples:
+  # Replay a session as it happened
+  gossh audit replay /var/lib/gossh/recordings/20250101T120000Z-alice-1a2b3c4d.cast
+
+  # Replay at four times the speed, skipping long pauses
+  gossh audit replay session.cast --speed 4 --max-idle 1s`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			fmt.Printf("Failed to open recording: %s\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		if err := ssh.ReplayRecording(f, os.Stdout, replaySpeed, replayMaxIdle); err != nil {
+			fmt.Printf("\nReplay failed: %s\n", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.AddCommand(replayCmd)
+
+	// Define flags for the replay command
+	replayCmd.Flags().Float64Var(&replaySpeed, "speed", 1, "Playback speed multiplier, e.g. 2 for twice as fast")
+	replayCmd.Flags().DurationVar(&replayMaxIdle, "max-idle", 0, "Shorten pauses longer than this, e.g. 2s (0 keeps original pauses)")
+}
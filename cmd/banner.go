@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// bannerCollector keeps the pre-authentication banners servers send, such
+// as legal notices, to show once the connection is made or has failed
+type bannerCollector struct {
+	banners []string
+}
+
+// callback is the ssh.BannerCallback of every hop. Retries often repeat a
+// banner, which is kept once.
+func (b *bannerCollector) callback(message string) error {
+	message = sanitizeBanner(message)
+	if message != "" && !slices.Contains(b.banners, message) {
+		b.banners = append(b.banners, message)
+	}
+	return nil
+}
+
+// String returns the banners in the order they were received
+func (b *bannerCollector) String() string {
+	return strings.Join(b.banners, "")
+}
+
+// print writes the banners to w, as OpenSSH does to stderr
+func (b *bannerCollector) print(w io.Writer) {
+	io.WriteString(w, b.String())
+}
+
+// sanitizeBanner drops the control characters of a banner, other than
+// newlines and tabs, so a server can't send escape sequences to the terminal,
+// and ends it with a newline
+func sanitizeBanner(message string) string {
+	message = strings.ReplaceAll(message, "\r\n", "\n")
+	message = strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, message)
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	return message
+}
@@ -0,0 +1,46 @@
+// cmd/banner_test.go
+package cmd
+
+import (
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+)
+
+func TestSanitizeBanner(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"", ""},
+		{"Authorized use only", "Authorized use only\n"},
+		{"Line 1\r\nLine 2\r\n", "Line 1\nLine 2\n"},
+		{"\x1b[2J\x1b]0;owned\x07Welcome\t!\n", "[2J]0;ownedWelcome\t!\n"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeBanner(tt.message); got != tt.want {
+			t.Errorf("sanitizeBanner(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestBannerCollector(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Banner: "Authorized use only\n"})
+
+	var banners bannerCollector
+	config := *srv.ClientConfig
+	config.BannerCallback = banners.callback
+	// Retried connections show the banner once
+	for range 2 {
+		client, err := dialSSH("tcp", nil, srv.Addr, &config)
+		if err != nil {
+			t.Fatalf("dialSSH failed: %v", err)
+		}
+		client.Close()
+	}
+	banners.callback("Jump host notice")
+	if got, want := banners.String(), "Authorized use only\nJump host notice\n"; got != want {
+		t.Errorf("banners = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+)
+
+// enterBatchMode quiets the client for cron jobs and scripts: decorations
+// and server banners are discarded, colors and the spinner are off, only
+// errors are logged, to stderr, and unknown host keys are refused instead of
+// asked about. The
+// caller keeps the real stdout for the remote command's output.
+func enterBatchMode() {
+	if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+	} else {
+		os.Stdout = os.Stderr
+	}
+	color.NoColor = true
+	noSpinner = true
+	noBanner = true
+	log.SetOutput(os.Stderr)
+	log.SetLevel(logrus.ErrorLevel)
+	log.SetFormatter(&logrus.TextFormatter{
+		FullTimestamp:   true,
+		TimestampFormat: "2006-01-02 15:04:05",
+		DisableColors:   true,
+	})
+	if strictHostKeyChecking == hostKeyAsk {
+		strictHostKeyChecking = hostKeyStrict
+	}
+}
+
+// validateBatchFlags rejects options that need someone at the terminal
+func validateBatchFlags() error {
+	if passwordPrompt {
+		return errors.New("--password-prompt can't be answered in --batch mode")
+	}
+	if keyboardInteractive && challengeScriptPath == "" {
+		return errors.New("--keyboard-interactive needs a --challenge-script in --batch mode")
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+// cmd/batch_test.go
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func TestEnterBatchMode(t *testing.T) {
+	origStdout, origNoColor, origSpinner := os.Stdout, color.NoColor, noSpinner
+	origLevel, origFormatter, origChecking := log.GetLevel(), log.Formatter, strictHostKeyChecking
+	defer func() {
+		os.Stdout, color.NoColor, noSpinner = origStdout, origNoColor, origSpinner
+		log.SetLevel(origLevel)
+		log.SetFormatter(origFormatter)
+		log.SetOutput(origStdout)
+		strictHostKeyChecking = origChecking
+	}()
+
+	strictHostKeyChecking = hostKeyAsk
+	enterBatchMode()
+	if os.Stdout == origStdout {
+		t.Error("decorations should not reach stdout")
+	}
+	if !color.NoColor || !noSpinner || log.GetLevel() != logrus.ErrorLevel {
+		t.Errorf("colors off = %v, spinner off = %v, log level = %v", color.NoColor, noSpinner, log.GetLevel())
+	}
+	if strictHostKeyChecking != hostKeyStrict {
+		t.Errorf("unknown hosts are checked with %q, want %q instead of a prompt", strictHostKeyChecking, hostKeyStrict)
+	}
+}
+
+func TestValidateBatchFlags(t *testing.T) {
+	origPassword, origKeyboard, origScript := passwordPrompt, keyboardInteractive, challengeScriptPath
+	defer func() {
+		passwordPrompt, keyboardInteractive, challengeScriptPath = origPassword, origKeyboard, origScript
+	}()
+
+	tests := []struct {
+		password, keyboard bool
+		script             string
+		wantErr            bool
+	}{
+		{false, false, "", false},
+		{true, false, "", true},
+		{false, true, "", true},
+		{false, true, "answers.txt", false},
+	}
+	for _, tt := range tests {
+		passwordPrompt, keyboardInteractive, challengeScriptPath = tt.password, tt.keyboard, tt.script
+		if err := validateBatchFlags(); (err != nil) != tt.wantErr {
+			t.Errorf("validateBatchFlags(%+v) error = %v, wantErr %v", tt, err, tt.wantErr)
+		}
+	}
+}
+
+func TestMachineOutput(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"--batch"}, true},
+		{[]string{"--output", "json"}, true},
+		{[]string{"--output", "text"}, false},
+	}
+	for _, tt := range tests {
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool("batch", false, "")
+		cmd.Flags().String("output", outputText, "")
+		cmd.Flags().Parse(tt.args)
+		if got := machineOutput(cmd); got != tt.want {
+			t.Errorf("machineOutput(%v) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
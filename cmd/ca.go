@@ -0,0 +1,349 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	caKeyPath        string
+	caKeyType        string
+	caNoPassphrase   bool
+	caForce          bool
+	caPrincipals     []string
+	caUserValidity   string
+	caHostValidity   string
+	caKeyID          string
+	caForceCommand   string
+	caSourceAddress  []string
+	caCertificateOut string
+)
+
+// caKeyComment is the comment of the keys gossh ca init creates
+const caKeyComment = "gossh-ca"
+
+// caCmd groups the certificate authority commands
+var caCmd = &cobra.Command{
+	Use:   "ca",
+	Short: "Run an SSH certificate authority",
+	Long: `The ca commands create a certificate authority key and issue OpenSSH user and
+host certificates with it. Servers that trust the CA accept every user
+certificate it issued for the user logging in, while it is valid, so short-lived
+certificates replace authorized_keys entries; clients that trust it accept
+every host certificate it issued, instead of asking about each new host key.
+
+The CA key is --ca-key, or $GOSSH_CA_KEY, or else ~/.gossh/ca, with its public
+key next to it in a .pub file.
+
+Examples:
+  # Create the CA key, asking for a passphrase to encrypt it with
+  gossh ca init
+
+  # Have a gossh server accept the CA's user certificates
+  gossh server --trusted-user-ca-keys ~/.gossh/ca.pub
+
+  # Let alice log in as alice for the next 8 hours
+  gossh ca sign-user --principal alice --validity 8h alice_ed25519.pub
+
+  # Certify a server's host key for a year
+  gossh ca sign-host --principal web1.example.com --validity 52w ssh_host_ed25519_key.pub`,
+}
+
+// caInitCmd represents the ca init command
+var caInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a certificate authority key",
+	Long: `Create a new certificate authority key pair, and print how to have servers
+and clients trust it. The private key is encrypted with a passphrase unless
+--no-passphrase is given. An existing CA key is only replaced with --force,
+which makes every certificate it issued worthless.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		path, err := resolveCAKeyPath(caKeyPath)
+		if err != nil {
+			fail(err)
+		}
+		if _, err := os.Stat(path); err == nil && !caForce {
+			fail(fmt.Errorf("%s already exists (--force replaces it, invalidating its certificates)", path))
+		}
+		passphrase := ""
+		if !caNoPassphrase {
+			if passphrase, err = promptNewPassphrase(); err != nil {
+				fail(err)
+			}
+		}
+
+		private, public, err := ssh.GenerateCAKey(caKeyType, caKeyComment, passphrase)
+		if err != nil {
+			fail(fmt.Errorf("failed to generate CA key: %s", err))
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			fail(fmt.Errorf("failed to create %s: %s", filepath.Dir(path), err))
+		}
+		if err := os.WriteFile(path, private, 0o600); err != nil {
+			fail(fmt.Errorf("failed to write CA key: %s", err))
+		}
+		if err := os.WriteFile(path+".pub", public, 0o644); err != nil {
+			fail(fmt.Errorf("failed to write CA public key: %s", err))
+		}
+
+		fmt.Println(successColor("✓ ") + "Certificate authority created:")
+		fmt.Printf("Private key: %s\n", path)
+		fmt.Printf("Public key: %s.pub\n", path)
+		fmt.Println()
+		fmt.Println("Trust its user certificates on servers with:")
+		fmt.Printf("  gossh server --trusted-user-ca-keys %s.pub   (or TrustedUserCAKeys in sshd_config)\n", path)
+		fmt.Println("Trust its host certificates on clients with this line in ~/.ssh/known_hosts:")
+		fmt.Printf("  @cert-authority * %s", public)
+	},
+}
+
+// caSignUserCmd represents the ca sign-user command
+var caSignUserCmd = &cobra.Command{
+	Use:   "sign-user <key.pub>...",
+	Short: "Issue user certificates",
+	Long: `Certify user public keys for logging in as --principal users, for --validity
+(8h unless given; units up to d for days and w for weeks). The certificate of
+key.pub is written to key-cert.pub, where clients pick it up next to the
+private key, unless --output names another file.
+
+--force-command and --source-address restrict what the certificate allows,
+like the same authorized_keys options. The key ID, logged by servers on each
+login, is --key-id, or else the comment of the public key, such as
+alice@laptop, or else <principal>-<unix time>.
+
+Examples:
+  # Let alice log in as alice or deploy for 8 hours
+  gossh ca sign-user --principal alice,deploy alice_ed25519.pub
+
+  # A certificate for backups that only runs the backup, from the backup network
+  gossh ca sign-user --principal backup --validity 30d --force-command /usr/local/bin/backup \
+    --source-address 10.0.5.0/24 backup_ed25519.pub`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCASign(cryptossh.UserCert, caUserValidity, args)
+	},
+}
+
+// caSignHostCmd represents the ca sign-host command
+var caSignHostCmd = &cobra.Command{
+	Use:   "sign-host <host_key.pub>...",
+	Short: "Issue host certificates",
+	Long: `Certify host public keys for the host names of --principal, for --validity
+(90d unless given; units up to d for days and w for weeks). The certificate
+of key.pub is written to key-cert.pub unless --output names another file; point
+sshd's HostCertificate at it, or the HostCertificates option of an embedded
+gossh server.
+
+Examples:
+  # Certify a server under its names for a year
+  gossh ca sign-host --principal web1.example.com,web1 --validity 52w /etc/ssh/ssh_host_ed25519_key.pub`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCASign(cryptossh.HostCert, caHostValidity, args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(caCmd)
+	caCmd.AddCommand(caInitCmd, caSignUserCmd, caSignHostCmd)
+
+	for _, cmd := range []*cobra.Command{caInitCmd, caSignUserCmd, caSignHostCmd} {
+		cmd.Flags().StringVar(&caKeyPath, "ca-key", "", "CA private key (default: $GOSSH_CA_KEY, or ~/.gossh/ca)")
+	}
+	caInitCmd.Flags().StringVarP(&caKeyType, "type", "t", "ed25519", "CA key type: ed25519, ecdsa or rsa")
+	caInitCmd.Flags().BoolVar(&caNoPassphrase, "no-passphrase", false, "Leave the CA key unencrypted, for automation")
+	caInitCmd.Flags().BoolVar(&caForce, "force", false, "Replace an existing CA key")
+
+	for _, cmd := range []*cobra.Command{caSignUserCmd, caSignHostCmd} {
+		cmd.Flags().StringVar(&caKeyID, "key-id", "", "Key ID recorded in the certificates (default: the key's comment, or <principal>-<unix time>)")
+		cmd.Flags().StringVarP(&caCertificateOut, "output", "o", "", "Certificate file to write (default: <key>-cert.pub, for a single key)")
+	}
+	caSignUserCmd.Flags().StringSliceVar(&caPrincipals, "principal", nil, "User the certificates are valid for (repeatable)")
+	caSignUserCmd.Flags().StringVar(&caUserValidity, "validity", "8h", "How long the certificates are valid for, such as 15m, 8h or 30d")
+	caSignUserCmd.Flags().StringVar(&caForceCommand, "force-command", "", "Command run whatever the client asks for")
+	caSignUserCmd.Flags().StringSliceVar(&caSourceAddress, "source-address", nil, "Addresses or CIDR ranges logins must come from (repeatable)")
+	caSignUserCmd.MarkFlagRequired("principal")
+	caSignHostCmd.Flags().StringSliceVar(&caPrincipals, "principal", nil, "Host name the certificates are valid for (repeatable)")
+	caSignHostCmd.Flags().StringVar(&caHostValidity, "validity", "90d", "How long the certificates are valid for, such as 30d or 52w")
+	caSignHostCmd.MarkFlagRequired("principal")
+}
+
+// runCASign runs gossh ca sign-user, or sign-host for host certificates
+func runCASign(certType uint32, validity string, pubPaths []string) {
+	errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+	successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+	fail := func(err error) {
+		log.Error(err)
+		fmt.Println(errorColor("✗ ") + err.Error())
+		os.Exit(1)
+	}
+	if err := validatePrincipals(caPrincipals); err != nil {
+		fail(err)
+	}
+	ttl, err := parseValidity(validity)
+	if err != nil {
+		fail(err)
+	}
+	if caCertificateOut != "" && len(pubPaths) > 1 {
+		fail(errors.New("--output takes a single public key"))
+	}
+	options, err := certificateOptions(caForceCommand, caSourceAddress)
+	if err != nil {
+		fail(err)
+	}
+	path, err := resolveCAKeyPath(caKeyPath)
+	if err != nil {
+		fail(err)
+	}
+	ca, err := readPrivateKeyFile(path, promptKeyPassphrase)
+	if err != nil {
+		fail(err)
+	}
+
+	for _, pubPath := range pubPaths {
+		pub, comment, err := readPublicKeyFile(pubPath)
+		if err != nil {
+			fail(err)
+		}
+		if _, ok := pub.(*cryptossh.Certificate); ok {
+			fail(fmt.Errorf("%s is a certificate: sign the public key it certifies", pubPath))
+		}
+		req := ssh.CertificateRequest{
+			PublicKey:       pub,
+			KeyID:           certificateKeyID(caKeyID, comment, caPrincipals[0], time.Now()),
+			Principals:      caPrincipals,
+			TTL:             ttl,
+			CriticalOptions: options,
+		}
+		sign, kind := ssh.SignUserCertificate, "User"
+		if certType == cryptossh.HostCert {
+			sign, kind = ssh.SignHostCertificate, "Host"
+		}
+		cert, err := sign(ca.signer, req)
+		if err != nil {
+			fail(fmt.Errorf("failed to sign %s: %s", pubPath, err))
+		}
+		out := caCertificateOut
+		if out == "" {
+			out = certificatePath(pubPath)
+		}
+		if err := os.WriteFile(out, cryptossh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+			fail(fmt.Errorf("failed to write certificate: %s", err))
+		}
+		fmt.Println(successColor("✓ ") + fmt.Sprintf("%s certificate written to %s", kind, out))
+		fmt.Printf("Key ID: %s, serial %d\n", cert.KeyId, cert.Serial)
+		fmt.Printf("Principals: %s\n", strings.Join(cert.ValidPrincipals, ", "))
+		fmt.Printf("Valid until: %s\n", time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+	}
+}
+
+// resolveCAKeyPath returns the CA key path of --ca-key, or else of
+// $GOSSH_CA_KEY, or else ~/.gossh/ca
+func resolveCAKeyPath(flag string) (string, error) {
+	if flag != "" {
+		return expandHome(flag), nil
+	}
+	if env := os.Getenv("GOSSH_CA_KEY"); env != "" {
+		return expandHome(env), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not locate ~/.gossh: %s", err)
+	}
+	return filepath.Join(home, ".gossh", "ca"), nil
+}
+
+// promptNewPassphrase asks twice for the passphrase to encrypt a new key
+// with, which may be empty
+func promptNewPassphrase() (string, error) {
+	fmt.Print("Enter passphrase (empty for no passphrase): ")
+	passphrase, err := readSecret()
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase: %s", err)
+	}
+	fmt.Print("Enter same passphrase again: ")
+	again, err := readSecret()
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase: %s", err)
+	}
+	if passphrase != again {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// parseValidity parses how long a certificate is valid for: a duration
+// such as 15m or 8h, or a whole number of days (90d) or weeks (52w)
+func parseValidity(s string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour}
+	d, err := time.ParseDuration(s)
+	for suffix, unit := range units {
+		if n, ok := strings.CutSuffix(s, suffix); ok {
+			var count int
+			count, err = strconv.Atoi(n)
+			d = time.Duration(count) * unit
+		}
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid validity %q: use a positive duration such as 8h, 90d or 52w", s)
+	}
+	return d, nil
+}
+
+// certificateOptions returns the critical options of a user certificate
+// that only runs forceCommand, when set, and only from sourceAddresses,
+// when any
+func certificateOptions(forceCommand string, sourceAddresses []string) (map[string]string, error) {
+	options := map[string]string{}
+	if forceCommand != "" {
+		options["force-command"] = forceCommand
+	}
+	for _, addr := range sourceAddresses {
+		if _, _, err := net.ParseCIDR(addr); err != nil && net.ParseIP(addr) == nil {
+			return nil, fmt.Errorf("invalid source address %q: use an IP address or CIDR range", addr)
+		}
+	}
+	if len(sourceAddresses) > 0 {
+		options["source-address"] = strings.Join(sourceAddresses, ",")
+	}
+	return options, nil
+}
+
+// certificateKeyID returns the key ID of a certificate for a public key
+// with comment: keyID when set, or else the comment, which names whose key
+// it is, or else principal and the time
+func certificateKeyID(keyID, comment, principal string, now time.Time) string {
+	if keyID != "" {
+		return keyID
+	}
+	if comment != "" {
+		return comment
+	}
+	return fmt.Sprintf("%s-%d", principal, now.Unix())
+}
+
+// certificatePath returns where the certificate of the public key at
+// pubPath goes: key-cert.pub for key.pub, which OpenSSH clients and servers
+// look for next to the private key
+func certificatePath(pubPath string) string {
+	return strings.TrimSuffix(pubPath, ".pub") + certSuffix
+}
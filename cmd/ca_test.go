@@ -0,0 +1,117 @@
+// cmd/ca_test.go
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseValidity(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "15m", want: 15 * time.Minute},
+		{in: "8h", want: 8 * time.Hour},
+		{in: "1h30m", want: 90 * time.Minute},
+		{in: "90d", want: 90 * 24 * time.Hour},
+		{in: "52w", want: 52 * 7 * 24 * time.Hour},
+		{in: "0d", wantErr: true},
+		{in: "-1h", wantErr: true},
+		{in: "1.5d", wantErr: true},
+		{in: "d", wantErr: true},
+		{in: "forever", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseValidity(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseValidity(%q) error = %v, want error: %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseValidity(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCertificateOptions(t *testing.T) {
+	tests := []struct {
+		forceCommand string
+		sources      []string
+		want         map[string]string
+		wantErr      bool
+	}{
+		{want: map[string]string{}},
+		{forceCommand: "/usr/local/bin/backup", want: map[string]string{"force-command": "/usr/local/bin/backup"}},
+		{sources: []string{"10.0.5.0/24", "192.0.2.7", "2001:db8::/32"}, want: map[string]string{"source-address": "10.0.5.0/24,192.0.2.7,2001:db8::/32"}},
+		{sources: []string{"backup.example.com"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := certificateOptions(tt.forceCommand, tt.sources)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("certificateOptions(%q, %q) error = %v, want error: %v", tt.forceCommand, tt.sources, err, tt.wantErr)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("certificateOptions(%q, %q) = %v, want %v", tt.forceCommand, tt.sources, got, tt.want)
+		}
+		for name, value := range tt.want {
+			if got[name] != value {
+				t.Errorf("certificateOptions(%q, %q) = %v, want %v", tt.forceCommand, tt.sources, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestCertificatePath(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"id_ed25519.pub", "id_ed25519-cert.pub"},
+		{"/etc/ssh/ssh_host_ed25519_key.pub", "/etc/ssh/ssh_host_ed25519_key-cert.pub"},
+		{"alice", "alice-cert.pub"},
+	}
+	for _, tt := range tests {
+		if got := certificatePath(tt.in); got != tt.want {
+			t.Errorf("certificatePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestResolveCAKeyPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("GOSSH_CA_KEY", "")
+	tests := []struct {
+		flag, env, want string
+	}{
+		{want: filepath.Join(home, ".gossh", "ca")},
+		{env: "/srv/ca/key", want: "/srv/ca/key"},
+		{flag: "~/ca", env: "/srv/ca/key", want: filepath.Join(home, "ca")},
+	}
+	for _, tt := range tests {
+		t.Setenv("GOSSH_CA_KEY", tt.env)
+		got, err := resolveCAKeyPath(tt.flag)
+		if err != nil || got != tt.want {
+			t.Errorf("resolveCAKeyPath(%q) with $GOSSH_CA_KEY=%q = %q, %v, want %q", tt.flag, tt.env, got, err, tt.want)
+		}
+	}
+}
+
+func TestCertificateKeyID(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		keyID, comment, want string
+	}{
+		{"", "", "alice-1700000000"},
+		{"", "alice@laptop", "alice@laptop"},
+		{"ticket-42", "alice@laptop", "ticket-42"},
+	}
+	for _, tt := range tests {
+		if got := certificateKeyID(tt.keyID, tt.comment, "alice", now); got != tt.want {
+			t.Errorf("certificateKeyID(%q, %q) = %q, want %q", tt.keyID, tt.comment, got, tt.want)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -14,13 +18,59 @@ import (
 )
 
 var (
-	host          string
-	port          string
-	user          string
-	clientKeyPath string
-	command       string
-	timeout       string
-	noSpinner     bool
+	host            string
+	port            string
+	user            string
+	clientKeyPaths  []string
+	certPaths       []string
+	agentForwarding bool
+	outputFormat    string
+	outputBase64    bool
+	stdoutFile      string
+	stderrFile      string
+	cmdTimeout      time.Duration
+	remoteSignal    string
+	batchMode       bool
+	sessionLogPath  string
+	outputLogPath   string
+	ipv4Only        bool
+	ipv6Only        bool
+	command         string
+	timeout         string
+	noSpinner       bool
+	noBanner        bool
+	vaultRole       string
+	vaultMount      string
+	vaultTTL        time.Duration
+
+	knownHostsFile        string
+	strictHostKeyChecking string
+
+	termType   string
+	forceStdin bool
+	sendEnv    []string
+	setEnv     []string
+
+	serverAliveInterval time.Duration
+	serverAliveCountMax int
+
+	retries      int
+	retryBackoff time.Duration
+
+	noSSHConfig   bool
+	profileName   string
+	jumpHosts     string
+	localForwards []string
+	proxyURL      string
+
+	ciphers      string
+	kexes        string
+	macs         string
+	hostKeyAlgos string
+
+	passwordPrompt      bool
+	keyboardInteractive bool
+	challengeScriptPath string
 )
 
 // clientCmd represents the client command
@@ -36,9 +86,103 @@ Examples:
   # Execute a command
   gossh client --host example.com --user admin --key id_rsa --cmd "ls -la"
 
+  # Feed piped input to the command
+  cat data.json | gossh client --host example.com --user admin --key id_rsa --cmd "jq ."
+
+  # Retry a flaky connection up to 5 times, waiting 5s, 10s, 20s, ... in between
+  gossh client --host example.com --user admin --key id_rsa --cmd backup.sh --retry 5 --retry-backoff 5s
+
+  # Disconnect with exit status 255 after 3 unanswered keepalives, 15 seconds apart
+  gossh client --host example.com --user admin --key id_rsa --server-alive-interval 15s --server-alive-count-max 3
+
+  # Pass the local locale and set a variable for the remote command
+  gossh client --host example.com --user admin --key id_rsa --send-env 'LC_*' --set-env APP_ENV=staging --cmd ./deploy.sh
+
   # Execute with timeout
-  gossh client --host example.com --user admin --key id_rsa --cmd "backup.sh" --timeout 30s`,
+  gossh client --host example.com --user admin --key id_rsa --cmd "backup.sh" --timeout 30s
+
+  # Request the remote PTY as a specific terminal type
+  gossh client --host example.com --user admin --key id_rsa --term tmux-256color
+
+  # Connect over IPv6 only; otherwise all of the host's addresses are tried, IPv6 and IPv4 alternately
+  gossh client --host example.com --user admin --key id_rsa -6
+
+  # Connect from behind a corporate proxy, or over Tor
+  gossh client --host example.com --user admin --key id_rsa --proxy http://corp-proxy:3128
+  gossh client --host example.onion --user admin --key id_rsa --proxy socks5://127.0.0.1:9050
+
+  # Connect to an old switch that only speaks legacy algorithms
+  gossh client --host switch1 --user admin --password-prompt --kex +diffie-hellman-group1-sha1 --ciphers +aes128-cbc
+
+  # Only allow modern crypto
+  gossh client --host example.com --user admin --key id_ed25519 --ciphers chacha20-poly1305@openssh.com,aes256-gcm@openssh.com --kex curve25519-sha256
+
+  # Try several keys in order, then the agent's
+  gossh client --host example.com --user admin --key id_ed25519 --key id_rsa
+
+  # Log in with a certificate from your CA; id_ed25519-cert.pub next to the key is picked up without --cert
+  gossh client --host example.com --user admin --key id_ed25519 --cert ~/certs/admin-cert.pub
+
+  # Forward your agent, so git on the server can use your keys
+  gossh client --host example.com --user admin -A --cmd "git pull"
+
+  # Give up on the command after 10 minutes, exiting with status 124
+  gossh client --host example.com --user admin --key id_rsa --cmd "make test" --cmd-timeout 10m
+
+  # Ctrl+C stops the remote command too; send it KILL instead of INT
+  gossh client --host example.com --user admin --key id_rsa --cmd "./long-job.sh" --signal KILL
+
+  # Print nothing but the command's output, e.g. from cron
+  gossh client --host example.com --user admin --key id_rsa --cmd "df -h /" --batch
+
+  # Record an interactive session as evidence for change management
+  gossh client --host example.com --user admin --key id_rsa --log-session change-1234.cast --log-output change-1234.txt
+
+  # Keep copies of the output for CI artifacts; gossh exits with the command's status
+  gossh client --host example.com --user admin --key id_rsa --cmd "make test" --stdout-file test.log --stderr-file test.err
+
+  # Report the result as JSON for scripts; other output goes to stderr
+  gossh client --host example.com --user admin --key id_rsa --cmd "df -h" --output json | jq .exit_code
+
+  # Log in with a password instead of a key
+  gossh client --host example.com --user admin --password-prompt
+
+  # Answer keyboard-interactive prompts, such as a one-time code, from a script
+  gossh client --host example.com --user admin --key id_rsa --challenge-script answers.txt --cmd uptime
+
+  # Connect to a Host alias from ~/.ssh/config, through its ProxyJump if any
+  gossh client --host myalias
+
+  # Connect with a saved profile (see gossh profiles), overriding its user
+  gossh client --profile prod-db --user root
+
+  # Jump through a bastion and forward a local port to a database behind it
+  gossh client --host db.internal --user admin --key id_rsa -J ops@bastion.example.com -L 5432:localhost:5432
+
+  # Ignore ~/.ssh/config
+  gossh client --host example.com --user admin --key id_rsa --no-ssh-config
+
+  # Only connect to hosts already in known_hosts
+  gossh client --host example.com --user admin --key id_rsa --strict-host-key-checking yes
+
+  # Keep trusted host keys in a file of their own
+  gossh client --host example.com --user admin --key id_rsa --known-hosts-file ./known_hosts
+
+  # Log in with a short-lived certificate signed by Vault's SSH secrets engine
+  gossh client --host example.com --user admin --key id_ed25519 --vault-role dev --vault-ttl 5m`,
 	Run: func(cmd *cobra.Command, args []string) {
+		// Keep stdout for the command's output or the JSON report; everything
+		// else goes to stderr, or nowhere in batch mode
+		realStdout := os.Stdout
+		switch {
+		case batchMode:
+			enterBatchMode()
+		case outputFormat == outputJSON:
+			os.Stdout = os.Stderr
+			log.SetOutput(os.Stderr)
+			noSpinner = true
+		}
+
 		// Create colored output helpers
 		titleColor := color.New(color.FgBlue, color.Bold).SprintFunc()
 		successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
@@ -46,12 +190,54 @@ Examples:
 		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
 		warningColor := color.New(color.FgYellow).SprintFunc()
 
+		// Start from the profile, then resolve --host through ~/.ssh/config,
+		// as ssh does; flags win over both
+		if profileName != "" {
+			p, err := lookupProfile(requireProfilesFile(), profileName)
+			if err != nil {
+				log.Error("Failed to load profile: ", err)
+				fmt.Println(errorColor("✗ Failed to load profile: ") + err.Error())
+				os.Exit(1)
+			}
+			p.apply(cmd.Flags())
+		}
+		sshConf := &sshConfig{}
+		var jumps []jumpHost
+		if !noSSHConfig {
+			path, err := defaultSSHConfigFile()
+			if err == nil {
+				sshConf, err = loadSSHConfig(path)
+			}
+			if err == nil {
+				jumps, err = applySSHConfig(sshConf, cmd.Flags().Changed("port"))
+			}
+			if err != nil {
+				log.Error("Failed to read SSH config: ", err)
+				fmt.Println(errorColor("✗ Failed to read SSH config: ") + err.Error())
+				os.Exit(1)
+			}
+		}
+		if jumpHosts != "" {
+			var err error
+			if jumps, err = parseJumpHosts(jumpHosts, sshConf); err != nil {
+				log.Error("Invalid jump hosts: ", err)
+				fmt.Println(errorColor("✗ ") + err.Error())
+				os.Exit(1)
+			}
+		}
+		if user == "" {
+			user = localUser()
+		}
+
 		// Print header
 		fmt.Println(titleColor("SSH CLIENT CONNECTION"))
 		fmt.Println(infoColor("⟹ ") + fmt.Sprintf("Connecting to %s@%s:%s",
 			color.CyanString(user),
 			color.CyanString(host),
 			color.CyanString(port)))
+		for _, jump := range jumps {
+			fmt.Println(infoColor("⟹ ") + "Jumping through " + color.CyanString(jump.user+"@"+jump.addr))
+		}
 
 		// Log connection details
 		log.Info("Initiating SSH connection")
@@ -59,10 +245,49 @@ Examples:
 			"host":    host,
 			"port":    port,
 			"user":    user,
-			"key":     clientKeyPath,
+			"keys":    clientKeyPaths,
 			"timeout": timeout,
 		}).Debug("Connection parameters")
 
+		if err := validateClientFlags(); err != nil {
+			log.Error("Invalid flags: ", err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+
+		env, err := sessionEnv(sendEnv, setEnv, os.Environ())
+		if err != nil {
+			log.Error("Invalid environment: ", err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		var signalOverride ssh.Signal
+		if remoteSignal != "" {
+			if signalOverride, err = parseRemoteSignal(remoteSignal); err != nil {
+				log.Error("Invalid signal: ", err)
+				fmt.Println(errorColor("✗ ") + err.Error())
+				os.Exit(1)
+			}
+		}
+		var forwards []localForward
+		for _, spec := range localForwards {
+			fwd, err := parseLocalForward(spec)
+			if err != nil {
+				log.Error("Invalid forward: ", err)
+				fmt.Println(errorColor("✗ ") + err.Error())
+				os.Exit(1)
+			}
+			forwards = append(forwards, fwd)
+		}
+		var proxy *url.URL
+		if proxyURL != "" {
+			if proxy, err = parseProxy(proxyURL); err != nil {
+				log.Error("Invalid proxy: ", err)
+				fmt.Println(errorColor("✗ ") + err.Error())
+				os.Exit(1)
+			}
+		}
+
 		// Parse timeout duration
 		timeoutDuration, err := time.ParseDuration(timeout)
 		if err != nil {
@@ -71,39 +296,84 @@ Examples:
 			os.Exit(1)
 		}
 
-		// Read the private key
-		log.Debug("Reading private key from: ", clientKeyPath)
-		privateKeyBytes, err := os.ReadFile(clientKeyPath)
+		// Read the keys, with Vault's certificate and each key's certificates
+		signers, vaultCert, err := loadSigners()
 		if err != nil {
-			log.Error("Failed to load private key: ", err)
-			fmt.Println(errorColor("✗ Failed to load private key: ") + err.Error())
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
 			os.Exit(1)
 		}
+		if vaultCert != nil {
+			fmt.Println(infoColor("⟹ ") + fmt.Sprintf("Vault certificate valid until %s",
+				time.Unix(int64(vaultCert.ValidBefore), 0).Format(time.RFC3339)))
+		}
+
+		// Ask for the password up front, before the spinner starts
+		var password string
+		if passwordPrompt {
+			if password, err = promptPassword(user, host); err != nil {
+				log.Error("Failed to read password: ", err)
+				fmt.Println(errorColor("✗ Failed to read password: ") + err.Error())
+				os.Exit(1)
+			}
+		}
+		var script challengeScript
+		if challengeScriptPath != "" {
+			data, err := os.ReadFile(challengeScriptPath)
+			if err == nil {
+				script, err = parseChallengeScript(data)
+			}
+			if err != nil {
+				log.Error("Failed to load challenge script: ", err)
+				fmt.Println(errorColor("✗ Failed to load challenge script: ") + err.Error())
+				os.Exit(1)
+			}
+		}
 
-		// Parse the private key
-		log.Debug("Parsing private key")
-		signer, err := ssh.ParsePrivateKey(privateKeyBytes)
+		// Offer the agent's keys after the identity files
+		agentClient, err := dialAgent()
 		if err != nil {
-			log.Error("Failed to parse private key: ", err)
-			fmt.Println(errorColor("✗ Failed to parse private key: ") + err.Error())
-			os.Exit(1)
+			log.Debug("Not using the agent: ", err)
 		}
 
-		// Display a connection warning about host key verification
-		fmt.Println(warningColor("⚠ ") + "Warning: Using InsecureIgnoreHostKey() - host won't be verified")
+		// Verify the host key against known_hosts, trusting new hosts on first use
+		var s *spinner.Spinner
+		stopSpinner := func() {
+			if s != nil {
+				s.Stop()
+			}
+		}
+		hostKeyCallback, err := newHostKeyCallback(stopSpinner)
+		if err != nil {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		if strictHostKeyChecking == hostKeyOff {
+			fmt.Println(warningColor("⚠ ") + "Warning: host key checking is off - the host won't be verified")
+		}
 
 		// Set up SSH client configuration
+		auth := &clientAuth{
+			signers:             signers,
+			agent:               agentClient,
+			password:            password,
+			keyboardInteractive: keyboardInteractive,
+			script:              script,
+			stop:                stopSpinner,
+		}
 		config := &ssh.ClientConfig{
-			User: user,
-			Auth: []ssh.AuthMethod{
-				ssh.PublicKeys(signer),
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(), // Note: Not secure for production
+			User:            user,
+			Auth:            auth.methods(),
+			HostKeyCallback: hostKeyCallback,
 			Timeout:         timeoutDuration,
 		}
+		// Already checked by validateClientFlags
+		setAlgorithms(config)
+		var banners bannerCollector
+		config.BannerCallback = banners.callback
 
 		// Start a spinner for connection process
-		var s *spinner.Spinner
 		if !noSpinner {
 			s = spinner.New(spinner.CharSets[14], 100*time.Millisecond)
 			s.Suffix = " Establishing connection..."
@@ -112,24 +382,64 @@ Examples:
 		}
 
 		// Connect to the SSH server
-		addr := fmt.Sprintf("%s:%s", host, port)
+		// IPv6 addresses may be given in brackets, as in URLs
+		addr := net.JoinHostPort(strings.Trim(host, "[]"), port)
 		log.Info("Dialing SSH server at ", addr)
-		client, err := ssh.Dial("tcp", addr, config)
+		if proxy != nil {
+			log.Info("Connecting through proxy ", proxy.Redacted())
+		}
+		client, err := withRetry(func() (*ssh.Client, error) {
+			return dialVia(proxy, jumps, addr, config)
+		}, retries, retryBackoff)
 
 		// Stop the spinner regardless of connection result
 		if !noSpinner {
 			s.Stop()
 		}
+		if !noBanner && outputFormat != outputJSON {
+			banners.print(os.Stderr)
+		}
 
 		if err != nil {
+			var mismatch *hostKeyMismatchError
+			if errors.As(err, &mismatch) {
+				fmt.Println(errorColor("✗ WARNING: POSSIBLE MAN-IN-THE-MIDDLE ATTACK"))
+			}
 			log.Error("Failed to connect: ", err)
 			fmt.Println(errorColor("✗ Connection failed: ") + err.Error())
 			os.Exit(1)
 		}
 		fmt.Println(successColor("✓ ") + "Connected successfully to " + infoColor(addr))
+		log.Debug("Authenticated with ", auth.used)
 
 		defer client.Close()
 
+		// Give up on servers that stop answering, e.g. behind a dead NAT mapping
+		if serverAliveInterval > 0 {
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				if err := serverAlive(client, serverAliveInterval, serverAliveCountMax, done); err != nil {
+					client.Close()
+					log.Error("Server stopped responding: ", err)
+					fmt.Println(errorColor("✗ Server stopped responding: ") + err.Error())
+					os.Exit(exitServerUnresponsive)
+				}
+			}()
+		}
+
+		// Forward local ports through the server for as long as the session lasts
+		for _, fwd := range forwards {
+			listener, err := fwd.listen(client)
+			if err != nil {
+				log.Error("Failed to forward ", fwd.bind, ": ", err)
+				fmt.Println(errorColor("✗ Failed to forward "+fwd.bind+": ") + err.Error())
+				os.Exit(1)
+			}
+			defer listener.Close()
+			fmt.Println(infoColor("⟹ ") + "Forwarding " + color.CyanString(fwd.bind) + " to " + color.CyanString(fwd.dest))
+		}
+
 		// Create a session
 		log.Debug("Creating new SSH session")
 		session, err := client.NewSession()
@@ -140,14 +450,85 @@ Examples:
 		}
 		defer session.Close()
 
-		// Set up I/O
-		session.Stdout = os.Stdout
-		session.Stderr = os.Stderr
+		// Send the selected environment; servers may refuse some variables
+		for _, v := range env {
+			if err := session.Setenv(v.name, v.value); err != nil {
+				log.Warn("Server refused environment variable ", v.name)
+			}
+		}
+
+		if agentForwarding {
+			if err := forwardAgent(client, session); err != nil {
+				log.Warn("Agent forwarding failed: ", err)
+				fmt.Println(warningColor("⚠ ") + "Agent forwarding failed: " + err.Error())
+			} else {
+				log.Debug("Forwarding the agent at ", os.Getenv("SSH_AUTH_SOCK"))
+			}
+		}
+
+		// Set up I/O, copying the streams to --stdout-file and --stderr-file
+		var stdoutTee, stderrTee io.Writer
+		stdoutTee, err = createTeeFile(stdoutFile)
+		if err == nil {
+			stderrTee, err = createTeeFile(stderrFile)
+		}
+		if err != nil {
+			log.Error("Failed to create output file: ", err)
+			fmt.Println(errorColor("✗ Failed to create output file: ") + err.Error())
+			os.Exit(1)
+		}
+
+		// Record the session for --log-session and --log-output
+		var recorder *castRecorder
+		var sessionLog io.Writer
+		if sessionLogPath != "" {
+			columns, rows := terminalSize()
+			if recorder, err = newCastRecorder(sessionLogPath, columns, rows, terminalType(termType), user+"@"+host); err != nil {
+				log.Error("Failed to create session recording: ", err)
+				fmt.Println(errorColor("✗ Failed to create session recording: ") + err.Error())
+				os.Exit(1)
+			}
+			defer recorder.Close()
+			sessionLog = recorder
+		}
+		if outputLogPath != "" {
+			transcript, err := createTeeFile(outputLogPath)
+			if err != nil {
+				log.Error("Failed to create transcript: ", err)
+				fmt.Println(errorColor("✗ Failed to create transcript: ") + err.Error())
+				os.Exit(1)
+			}
+			sessionLog = teeTo(sessionLog, &transcriptWriter{w: transcript})
+		}
+		session.Stdout = teeTo(teeTo(realStdout, stdoutTee), sessionLog)
+		session.Stderr = teeTo(teeTo(os.Stderr, stderrTee), sessionLog)
 
 		if command != "" {
 			// Run a specific command
 			fmt.Println(infoColor("⟹ ") + "Executing command: " + color.HiWhiteString(command))
 			log.Info("Executing command: ", command)
+			if in := commandStdin(stdin, forceStdin, args); in != nil {
+				log.Debug("Sending stdin to the command")
+				session.Stdin = in
+			}
+
+			// Stop the remote command when gossh is interrupted
+			stopSignals := forwardSignals(session, signalOverride)
+			defer stopSignals()
+
+			if outputFormat == outputJSON {
+				session.Stdout, session.Stderr = teeTo(stdoutTee, sessionLog), teeTo(stderrTee, sessionLog)
+				banner := banners.String()
+				if noBanner {
+					banner = ""
+				}
+				result, err := runJSON(session, host, banner, command, cmdTimeout, outputBase64, realStdout)
+				if err != nil {
+					log.Error("Failed to write the result: ", err)
+					os.Exit(1)
+				}
+				os.Exit(result.exitStatus())
+			}
 
 			if !noSpinner {
 				s = spinner.New(spinner.CharSets[14], 100*time.Millisecond)
@@ -156,16 +537,17 @@ Examples:
 				s.Start()
 			}
 
-			err = session.Run(command)
+			err = runWithTimeout(session, command, cmdTimeout)
 
 			if !noSpinner {
 				s.Stop()
 			}
 
+			// Exit with the command's status, so scripts can tell failures apart
 			if err != nil {
 				log.Error("Command execution failed: ", err)
 				fmt.Println(errorColor("✗ Command execution failed: ") + err.Error())
-				os.Exit(1)
+				os.Exit(exitCode(err))
 			}
 			fmt.Println(successColor("✓ ") + "Command executed successfully")
 		} else {
@@ -180,7 +562,8 @@ Examples:
 				ssh.TTY_OP_OSPEED: 14400,
 			}
 
-			if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
+			columns, rows := terminalSize()
+			if err := session.RequestPty(terminalType(termType), rows, columns, modes); err != nil {
 				log.Error("Failed to request PTY: ", err)
 				fmt.Println(errorColor("✗ Failed to request PTY: ") + err.Error())
 				os.Exit(1)
@@ -196,6 +579,14 @@ Examples:
 				os.Exit(1)
 			}
 
+			// Keep the remote PTY the size of the local window
+			var onResize func(columns, rows int)
+			if recorder != nil {
+				onResize = recorder.resize
+			}
+			stopResize := watchWindowSize(session, onResize)
+			defer stopResize()
+
 			if err := session.Wait(); err != nil {
 				if e, ok := err.(*ssh.ExitError); ok {
 					log.Warn("Session ended with exit code: ", e.ExitStatus())
@@ -218,16 +609,99 @@ func init() {
 	rootCmd.AddCommand(clientCmd)
 
 	// Define flags for the client command
-	clientCmd.Flags().StringVarP(&host, "host", "H", "localhost", "SSH server hostname")
+	clientCmd.Flags().StringVarP(&host, "host", "H", "", "SSH server hostname, or a Host alias from ~/.ssh/config (required unless --profile gives it)")
 	clientCmd.Flags().StringVarP(&port, "port", "p", "22", "SSH server port")
-	clientCmd.Flags().StringVarP(&user, "user", "u", "", "SSH username")
-	clientCmd.Flags().StringVarP(&clientKeyPath, "key", "k", "", "Path to private key")
+	clientCmd.Flags().BoolVarP(&ipv4Only, "ipv4", "4", false, "Connect over IPv4 only")
+	clientCmd.Flags().BoolVarP(&ipv6Only, "ipv6", "6", false, "Connect over IPv6 only")
+	clientCmd.Flags().StringVarP(&user, "user", "u", "", "SSH username (default: the SSH config's User, or the local user)")
+	clientCmd.Flags().StringArrayVarP(&clientKeyPaths, "key", "k", nil, "Path to private key, or vault://path#field to read it from Vault (repeatable, tried in order before the agent's keys)")
+	clientCmd.Flags().StringVar(&profileName, "profile", "", "Connect with a saved profile; flags override its settings (see gossh profiles)")
+	clientCmd.Flags().StringVar(&profilesFile, "profiles-file", "", "Profiles file (default: ~/.gossh/config.yaml)")
+	clientCmd.Flags().StringVar(&ciphers, "ciphers", "", "Ciphers to offer, comma-separated; +list adds to the defaults, -list removes from them, ^list prefers them")
+	clientCmd.Flags().StringVar(&kexes, "kex", "", "Key exchange algorithms to offer, comma-separated, with +, - and ^ as for --ciphers")
+	clientCmd.Flags().StringVar(&macs, "macs", "", "MACs to offer, comma-separated, with +, - and ^ as for --ciphers")
+	clientCmd.Flags().StringVar(&hostKeyAlgos, "host-key-algorithms", "", "Host key algorithms to accept, comma-separated, with +, - and ^ as for --ciphers")
+	clientCmd.Flags().StringVar(&proxyURL, "proxy", "", "Connect through an HTTP CONNECT or SOCKS5 proxy, http://[user:password@]host:port or socks5://[user:password@]host:port")
+	clientCmd.Flags().StringVarP(&jumpHosts, "jump", "J", "", "Connect through these jump hosts, [user@]host[:port] separated by commas, instead of the SSH config's ProxyJump")
+	clientCmd.Flags().StringArrayVarP(&localForwards, "local-forward", "L", nil, "Forward a local port through the server, [bind_address:]port:host:hostport (repeatable)")
+	clientCmd.Flags().BoolVar(&noSSHConfig, "no-ssh-config", false, "Don't read HostName, User, Port, IdentityFile and ProxyJump for --host from ~/.ssh/config")
+	clientCmd.Flags().BoolVarP(&agentForwarding, "forward-agent", "A", false, "Forward the agent at SSH_AUTH_SOCK, so commands on the server can log in elsewhere with your keys")
+	clientCmd.Flags().StringArrayVar(&certPaths, "cert", nil, "OpenSSH user certificate for one of the keys (repeatable; <key>-cert.pub next to a key is used automatically)")
 	clientCmd.Flags().StringVarP(&command, "cmd", "c", "", "Command to execute (optional)")
+	clientCmd.Flags().StringVarP(&outputFormat, "output", "o", outputText, "Result format of --cmd: text, or json for host, command, exit code, duration, stdout and stderr on stdout")
+	clientCmd.Flags().BoolVar(&outputBase64, "base64", false, "Base64-encode stdout and stderr in --output json, for binary output")
+	clientCmd.Flags().StringVar(&remoteSignal, "signal", "", "Signal sent to --cmd when gossh is interrupted, e.g. KILL (default: the signal caught, INT or TERM)")
+	clientCmd.Flags().StringVar(&sessionLogPath, "log-session", "", "Record the session's output with its timing to this asciicast file, for replay with asciinema or gossh audit replay")
+	clientCmd.Flags().StringVar(&outputLogPath, "log-output", "", "Write a plain-text transcript of the session's output, without colors or escape sequences, to this file")
+	clientCmd.Flags().StringVar(&stdoutFile, "stdout-file", "", "Also write the remote stdout to this file, e.g. for CI log archiving")
+	clientCmd.Flags().StringVar(&stderrFile, "stderr-file", "", "Also write the remote stderr to this file")
 	clientCmd.Flags().StringVarP(&timeout, "timeout", "t", "10s", "Connection timeout duration")
+	clientCmd.Flags().DurationVar(&cmdTimeout, "cmd-timeout", 0, "Stop --cmd after this long (SIGTERM, then closing the channel) and exit with status 124 (0 disables)")
+	clientCmd.Flags().IntVar(&retries, "retry", 0, "Retry failed connection attempts this many times; refused credentials are not retried")
+	clientCmd.Flags().DurationVar(&retryBackoff, "retry-backoff", 5*time.Second, "Wait before the first retry, doubled after each further failure")
+	clientCmd.Flags().DurationVar(&serverAliveInterval, "server-alive-interval", 0, "Probe the server this often and disconnect when it stops answering (0 disables)")
+	clientCmd.Flags().IntVar(&serverAliveCountMax, "server-alive-count-max", 3, "Unanswered keepalives before disconnecting with exit status 255")
+	clientCmd.Flags().BoolVar(&forceStdin, "stdin", false, "Send stdin to --cmd even from a terminal (also: a - argument); piped stdin is always sent")
+	clientCmd.Flags().StringArrayVar(&sendEnv, "send-env", nil, "Send local environment variables matching this name or pattern, e.g. 'LC_*' (repeatable)")
+	clientCmd.Flags().StringArrayVar(&setEnv, "set-env", nil, "Set an environment variable in the remote session as NAME=VALUE (repeatable)")
+	clientCmd.Flags().StringVar(&termType, "term", "", "Terminal type of the remote PTY (default: $TERM, or xterm-256color)")
 	clientCmd.Flags().BoolVar(&noSpinner, "no-spinner", false, "Disable spinner animation")
+	clientCmd.Flags().BoolVar(&noBanner, "no-banner", false, "Don't show the banner the server sends before login")
+	clientCmd.Flags().BoolVar(&batchMode, "batch", false, "For scripts and cron: print only the remote output, errors to stderr; no banner, spinner, colors or prompts (unknown hosts are refused)")
+	clientCmd.Flags().BoolVar(&passwordPrompt, "password-prompt", false, "Ask for a password and offer it after the key, if any")
+	clientCmd.Flags().BoolVar(&keyboardInteractive, "keyboard-interactive", false, "Answer keyboard-interactive prompts, such as one-time codes, on the terminal")
+	clientCmd.Flags().StringVar(&challengeScriptPath, "challenge-script", "", "Answer keyboard-interactive prompts from this file of prompt=answer lines")
+	clientCmd.Flags().StringVar(&knownHostsFile, "known-hosts-file", "", "known_hosts file to check and save host keys in (default: ~/.ssh/known_hosts, saving to ~/.gossh/known_hosts)")
+	clientCmd.Flags().StringVar(&strictHostKeyChecking, "strict-host-key-checking", hostKeyAsk, "Unknown host keys: ask to trust them (ask), trust them (accept-new), refuse them (yes), or skip checking (no)")
+	clientCmd.Flags().StringVar(&vaultRole, "vault-role", "", "Have Vault's SSH secrets engine sign the key with this role before connecting")
+	clientCmd.Flags().StringVar(&vaultMount, "vault-ssh-mount", "ssh", "Mount path of Vault's SSH secrets engine")
+	clientCmd.Flags().DurationVar(&vaultTTL, "vault-ttl", 0, "Lifetime of the Vault-signed certificate (default: the role's TTL)")
+}
 
-	// Mark required flags
-	clientCmd.MarkFlagRequired("host")
-	clientCmd.MarkFlagRequired("user")
-	clientCmd.MarkFlagRequired("key")
+// validateClientFlags checks that the client knows where to connect, as whom,
+// and has a way to authenticate
+func validateClientFlags() error {
+	if host == "" {
+		return errors.New("host is required (--host, or a --profile that sets it)")
+	}
+	if user == "" {
+		return errors.New("user is required")
+	}
+	if len(clientKeyPaths) == 0 && os.Getenv("SSH_AUTH_SOCK") == "" && !passwordPrompt && !keyboardInteractive && challengeScriptPath == "" {
+		return errors.New("a private key (--key), an agent (SSH_AUTH_SOCK), --password-prompt, --keyboard-interactive or --challenge-script is required")
+	}
+	if len(certPaths) > 0 && len(clientKeyPaths) == 0 {
+		return errors.New("--cert requires the certified key (--key)")
+	}
+	if vaultRole != "" && len(clientKeyPaths) == 0 {
+		return errors.New("--vault-role requires --key")
+	}
+	if outputFormat != outputText && outputFormat != outputJSON {
+		return fmt.Errorf("unknown --output %q, want text or json", outputFormat)
+	}
+	if outputFormat == outputJSON && command == "" {
+		return errors.New("--output json requires --cmd")
+	}
+	if batchMode {
+		if err := validateBatchFlags(); err != nil {
+			return err
+		}
+	}
+	// Unknown algorithm names would only surface as failed handshakes
+	if err := setAlgorithms(&ssh.ClientConfig{}); err != nil {
+		return err
+	}
+	if ipv4Only && ipv6Only {
+		return errors.New("-4 and -6 can't be combined")
+	}
+	if cmdTimeout < 0 {
+		return errors.New("--cmd-timeout must not be negative")
+	}
+	if retries < 0 {
+		return errors.New("--retry must not be negative")
+	}
+	if serverAliveCountMax < 1 {
+		return errors.New("--server-alive-count-max must be at least 1")
+	}
+	return nil
 }
@@ -2,58 +2,70 @@
 package cmd
 
 import (
-	"fmt"
 	"testing"
 	"time"
 )
 
 // TestClientValidation tests the client command validation
 func TestClientValidation(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
 	// Save original flags to restore later
 	origHost := host
 	origUser := user
-	origKeyPath := clientKeyPath
+	origKeyPaths := clientKeyPaths
+	origPasswordPrompt := passwordPrompt
 	defer func() {
 		host = origHost
 		user = origUser
-		clientKeyPath = origKeyPath
+		clientKeyPaths = origKeyPaths
+		passwordPrompt = origPasswordPrompt
 	}()
 
 	// Set up test cases
 	tests := []struct {
-		name    string
-		host    string
-		user    string
-		keyPath string
-		wantErr bool
+		name     string
+		host     string
+		user     string
+		keyPaths []string
+		password bool
+		wantErr  bool
 	}{
 		{
-			name:    "all flags provided",
-			host:    "example.com",
-			user:    "testuser",
-			keyPath: "/path/to/key",
-			wantErr: false,
+			name:     "all flags provided",
+			host:     "example.com",
+			user:     "testuser",
+			keyPaths: []string{"/path/to/key"},
+			wantErr:  false,
+		},
+		{
+			name:     "missing host",
+			host:     "",
+			user:     "testuser",
+			keyPaths: []string{"/path/to/key"},
+			wantErr:  true,
 		},
 		{
-			name:    "missing host",
-			host:    "",
-			user:    "testuser",
-			keyPath: "/path/to/key",
-			wantErr: true,
+			name:     "missing user",
+			host:     "example.com",
+			user:     "",
+			keyPaths: []string{"/path/to/key"},
+			wantErr:  true,
 		},
 		{
-			name:    "missing user",
-			host:    "example.com",
-			user:    "",
-			keyPath: "/path/to/key",
-			wantErr: true,
+			name:     "missing key path",
+			host:     "example.com",
+			user:     "testuser",
+			keyPaths: nil,
+			wantErr:  true,
 		},
 		{
-			name:    "missing key path",
-			host:    "example.com",
-			user:    "testuser",
-			keyPath: "",
-			wantErr: true,
+			name:     "password instead of key",
+			host:     "example.com",
+			user:     "testuser",
+			keyPaths: nil,
+			password: true,
+			wantErr:  false,
 		},
 	}
 
@@ -62,7 +74,8 @@ func TestClientValidation(t *testing.T) {
 			// Set flags for this test case
 			host = tt.host
 			user = tt.user
-			clientKeyPath = tt.keyPath
+			clientKeyPaths = tt.keyPaths
+			passwordPrompt = tt.password
 
 			// Run validation
 			err := validateClientFlags()
@@ -73,20 +86,6 @@ func TestClientValidation(t *testing.T) {
 	}
 }
 
-// Helper function to validate client flags (mimicking what the Run function would do)
-func validateClientFlags() error {
-	if host == "" {
-		return fmt.Errorf("host is required")
-	}
-	if user == "" {
-		return fmt.Errorf("user is required")
-	}
-	if clientKeyPath == "" {
-		return fmt.Errorf("private key path is required")
-	}
-	return nil
-}
-
 // TestTimeoutParsing tests the timeout duration parsing
 func TestTimeoutParsing(t *testing.T) {
 	// Save original timeout to restore later
@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/term"
+)
+
+// challengeAnswer answers the keyboard-interactive prompts containing pattern
+type challengeAnswer struct {
+	pattern string
+	answer  string
+}
+
+// challengeScript answers keyboard-interactive prompts without a terminal
+type challengeScript []challengeAnswer
+
+// parseChallengeScript parses a challenge/response script: one
+// "prompt=answer" line per prompt, where a prompt matches when it contains
+// the text before "=", ignoring case. Blank lines and lines starting with #
+// are skipped.
+func parseChallengeScript(data []byte) (challengeScript, error) {
+	var script challengeScript
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		pattern, answer, found := strings.Cut(line, "=")
+		pattern = strings.TrimSpace(pattern)
+		if !found || pattern == "" {
+			return nil, fmt.Errorf("challenge script line %d: want prompt=answer", i+1)
+		}
+		script = append(script, challengeAnswer{pattern: strings.ToLower(pattern), answer: answer})
+	}
+	if len(script) == 0 {
+		return nil, errors.New("challenge script has no answers")
+	}
+	return script, nil
+}
+
+// challenge answers each question with the first line matching it
+func (s challengeScript) challenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		prompt := strings.ToLower(strings.TrimSpace(question))
+		found := false
+		for _, line := range s {
+			if strings.Contains(prompt, line.pattern) {
+				answers[i], found = line.answer, true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("challenge script has no answer for %q", question)
+		}
+	}
+	return answers, nil
+}
+
+// terminalChallenge answers keyboard-interactive prompts on the terminal,
+// hiding the input of prompts the server does not want echoed
+func terminalChallenge(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if name != "" {
+		fmt.Println(name)
+	}
+	if instruction != "" {
+		fmt.Println(instruction)
+	}
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		fmt.Print(question)
+		var err error
+		if i < len(echos) && echos[i] {
+			answers[i], err = readLine()
+		} else {
+			answers[i], err = readSecret()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read answer: %s", err)
+		}
+	}
+	return answers, nil
+}
+
+// promptPassword asks for the password of user@host on the terminal
+func promptPassword(user, host string) (string, error) {
+	fmt.Printf("%s@%s's password: ", user, host)
+	password, err := readSecret()
+	if err != nil {
+		return "", fmt.Errorf("could not read password: %s", err)
+	}
+	return password, nil
+}
+
+// readSecret reads a line from the terminal without echoing it, or a plain
+// line when stdin is not a terminal
+func readSecret() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine()
+	}
+	secret, err := term.ReadPassword(fd)
+	fmt.Println()
+	return string(secret), err
+}
+
+// stdin is shared by every prompt, so input typed ahead is not lost
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads a line from stdin without its line ending
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// clientAuth offers the client's credentials in OpenSSH's order: the
+// identity files, then the agent's keys, keyboard-interactive and the
+// password. It remembers the last credential the server asked for, which is
+// the one that logged in once the connection succeeds.
+type clientAuth struct {
+	// signers are the identity files, in the order given
+	signers []ssh.Signer
+	// agent holds further keys when SSH_AUTH_SOCK is set
+	agent               agent.Agent
+	password            string
+	keyboardInteractive bool
+	script              challengeScript
+	// stop is called before the terminal is used, so a spinner does not
+	// draw over prompts
+	stop func()
+
+	used string
+}
+
+// methods returns the authentication methods to offer
+func (a *clientAuth) methods() []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	if len(a.signers) > 0 || a.agent != nil {
+		// One method for every key: the client tries each method only once
+		methods = append(methods, ssh.PublicKeysCallback(a.publicKeys))
+	}
+	if a.script != nil {
+		methods = append(methods, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			a.used = "keyboard-interactive (challenge script)"
+			return a.script.challenge(name, instruction, questions, echos)
+		}))
+	} else if a.keyboardInteractive {
+		methods = append(methods, ssh.KeyboardInteractive(func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			a.used = "keyboard-interactive"
+			a.stop()
+			return terminalChallenge(name, instruction, questions, echos)
+		}))
+	}
+	if a.password != "" {
+		methods = append(methods, ssh.PasswordCallback(func() (string, error) {
+			a.used = "password"
+			return a.password, nil
+		}))
+	}
+	return methods
+}
+
+// publicKeys returns the identity files' keys followed by the agent's
+func (a *clientAuth) publicKeys() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for i, signer := range a.signers {
+		signers = append(signers, a.track(signer, fmt.Sprintf("identity file %d", i+1)))
+	}
+	if a.agent != nil {
+		agentSigners, err := a.agent.Signers()
+		if err != nil {
+			log.Debug("Could not list the agent's keys: ", err)
+		}
+		for _, signer := range agentSigners {
+			signers = append(signers, a.track(signer, "agent"))
+		}
+	}
+	return signers, nil
+}
+
+// track wraps signer to record its use. The server only asks for a
+// signature once it has accepted the key.
+func (a *clientAuth) track(signer ssh.Signer, source string) ssh.Signer {
+	algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return signer
+	}
+	name := fmt.Sprintf("publickey %s from %s", ssh.FingerprintSHA256(signer.PublicKey()), source)
+	return trackedSigner{AlgorithmSigner: algorithmSigner, used: func() { a.used = name }}
+}
+
+// trackedSigner calls used whenever it signs
+type trackedSigner struct {
+	ssh.AlgorithmSigner
+	used func()
+}
+
+func (s trackedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.used()
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s trackedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.used()
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+}
+
+// dialAgent connects to the agent at SSH_AUTH_SOCK, if one is running
+func dialAgent() (agent.Agent, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to agent: %s", err)
+	}
+	return agent.NewClient(conn), nil
+}
+
+// forwardAgent serves the server's requests for the agent at SSH_AUTH_SOCK
+// and asks the server to forward it to session (ssh -A), so commands there
+// can authenticate onward with the local keys
+func forwardAgent(client *ssh.Client, session *ssh.Session) error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return errors.New("no agent to forward: SSH_AUTH_SOCK is not set")
+	}
+	if err := agent.ForwardToRemote(client, socket); err != nil {
+		return err
+	}
+	return agent.RequestAgentForwarding(session)
+}
@@ -0,0 +1,171 @@
+// cmd/clientauth_test.go
+package cmd
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestChallengeScript(t *testing.T) {
+	script, err := parseChallengeScript([]byte("# answers for the bastion\nPassword=hunter2\n\nVerification code= 123456\r\n"))
+	if err != nil {
+		t.Fatalf("parseChallengeScript() error = %v", err)
+	}
+
+	tests := []struct {
+		questions []string
+		want      []string
+		wantErr   bool
+	}{
+		{[]string{"Password: "}, []string{"hunter2"}, false},
+		{[]string{"password: ", "Enter verification code: "}, []string{"hunter2", " 123456"}, false},
+		{nil, []string{}, false},
+		{[]string{"PIN: "}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := script.challenge("", "", tt.questions, make([]bool, len(tt.questions)))
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("challenge(%q) = %q, %v, want %q", tt.questions, got, err, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "# only a comment\n", "no separator\n", "=answer\n"} {
+		if _, err := parseChallengeScript([]byte(bad)); err == nil {
+			t.Errorf("parseChallengeScript(%q) should fail", bad)
+		}
+	}
+}
+
+func TestClientAuthFallback(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{})
+	_, strangerKey, _ := ed25519.GenerateKey(rand.Reader)
+	stranger, err := cryptossh.NewSignerFromKey(strangerKey)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		signers  []cryptossh.Signer
+		agentKey bool
+		wantUsed string
+		wantErr  bool
+	}{
+		{"second identity file", []cryptossh.Signer{stranger, srv.ClientSigner}, false, "from identity file 2", false},
+		{"agent after identity files", []cryptossh.Signer{stranger}, true, "from agent", false},
+		{"nothing accepted", []cryptossh.Signer{stranger}, false, "", true},
+	}
+	for _, tt := range tests {
+		auth := &clientAuth{signers: tt.signers, stop: func() {}}
+		if tt.agentKey {
+			auth.agent = signerAgent{signer: srv.ClientSigner}
+		}
+		config := *srv.ClientConfig
+		config.Auth = auth.methods()
+		client, err := cryptossh.Dial("tcp", srv.Addr, &config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Dial error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			client.Close()
+			if !strings.HasSuffix(auth.used, tt.wantUsed) {
+				t.Errorf("%s: authenticated with %q, want %q", tt.name, auth.used, tt.wantUsed)
+			}
+		}
+	}
+}
+
+// signerAgent is an agent offering a single signer, as the harness only
+// hands out the authorized key as a signer
+type signerAgent struct {
+	agent.Agent
+	signer cryptossh.Signer
+}
+
+func (a signerAgent) Signers() ([]cryptossh.Signer, error) {
+	return []cryptossh.Signer{a.signer}, nil
+}
+
+func TestForwardAgent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh", AllowAgentForwarding: true})
+
+	// Serve a local agent holding a key the server has never seen
+	dir, err := os.MkdirTemp("", "gossh-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	keyring := agent.NewKeyring()
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	keyring.Add(agent.AddedKey{PrivateKey: key, Comment: "local"})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	client := srv.Dial(t)
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	defer session.Close()
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if err := forwardAgent(client, session); err == nil {
+		t.Error("forwarding without an agent should fail")
+	}
+	t.Setenv("SSH_AUTH_SOCK", socket)
+	if err := forwardAgent(client, session); err != nil {
+		t.Fatalf("forwardAgent failed: %v", err)
+	}
+
+	// The remote side lists the local agent's key through its own socket
+	stdout, _ := session.StdoutPipe()
+	stdin, _ := session.StdinPipe()
+	if err := session.Start(`echo "$SSH_AUTH_SOCK"; cat`); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	remote, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || strings.TrimSpace(remote) == "" {
+		t.Fatalf("remote SSH_AUTH_SOCK = %q, %v", remote, err)
+	}
+	conn, err := net.Dial("unix", strings.TrimSpace(remote))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	keys, err := agent.NewClient(conn).List()
+	conn.Close()
+	if err != nil || len(keys) != 1 || keys[0].Comment != "local" {
+		t.Errorf("forwarded keys = %v, %v, want the local agent's key", keys, err)
+	}
+	stdin.Close()
+	session.Wait()
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certSuffix names the certificate OpenSSH looks for next to a private key
+const certSuffix = "-cert.pub"
+
+// readUserCertificate reads an OpenSSH user certificate, such as
+// id_ed25519-cert.pub
+func readUserCertificate(path string) (*ssh.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a public key, not a certificate", path)
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("%s is a host certificate, not a user certificate", path)
+	}
+	return cert, nil
+}
+
+// withCertificates returns the signers to offer for keys, each key preceded
+// by the certificates that certify it: those in certPaths and the
+// <key>-cert.pub file next to its identity file. keyPaths are the sources of
+// keys, in the same order. A --cert certificate that certifies none of the
+// keys is an error; a mismatched or unreadable adjacent one is skipped.
+func withCertificates(keys []ssh.Signer, keyPaths, certPaths []string) ([]ssh.Signer, error) {
+	certs := make([][]*ssh.Certificate, len(keys))
+	for _, path := range certPaths {
+		cert, err := readUserCertificate(path)
+		if err != nil {
+			return nil, err
+		}
+		i := certifiedKey(cert, keys)
+		if i < 0 {
+			return nil, fmt.Errorf("certificate %s does not certify any of the keys", path)
+		}
+		certs[i] = append(certs[i], cert)
+	}
+	for i, keyPath := range keyPaths {
+		// Vault-signed keys already present their certificate
+		if _, certified := keys[i].PublicKey().(*ssh.Certificate); certified || strings.HasPrefix(keyPath, vaultScheme) {
+			continue
+		}
+		path := keyPath + certSuffix
+		cert, err := readUserCertificate(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Warn("Skipping certificate: ", err)
+			continue
+		}
+		if certifiedKey(cert, keys) != i {
+			log.Warn("Skipping certificate ", path, ": it does not certify ", keyPath)
+			continue
+		}
+		certs[i] = append(certs[i], cert)
+	}
+
+	var signers []ssh.Signer
+	for i, key := range keys {
+		for _, cert := range certs[i] {
+			if before := cert.ValidBefore; before != ssh.CertTimeInfinity && time.Now().After(time.Unix(int64(before), 0)) {
+				log.Warn("Certificate ", cert.KeyId, " expired at ", time.Unix(int64(before), 0).Format(time.RFC3339))
+			}
+			certSigner, err := ssh.NewCertSigner(cert, key)
+			if err != nil {
+				return nil, err
+			}
+			log.Debug("Offering certificate ", cert.KeyId, " for principals ", cert.ValidPrincipals)
+			signers = append(signers, certSigner)
+		}
+		signers = append(signers, key)
+	}
+	return signers, nil
+}
+
+// certifiedKey returns the index of the key cert certifies, or -1
+func certifiedKey(cert *ssh.Certificate, keys []ssh.Signer) int {
+	for i, key := range keys {
+		if bytes.Equal(cert.Key.Marshal(), key.PublicKey().Marshal()) {
+			return i
+		}
+	}
+	return -1
+}
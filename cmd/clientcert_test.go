@@ -0,0 +1,125 @@
+// cmd/clientcert_test.go
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// newTestSigner returns a new Ed25519 signer
+func newTestSigner(t *testing.T) cryptossh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	signer, err := cryptossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey failed: %v", err)
+	}
+	return signer
+}
+
+// writeTestCertificate has ca certify key as a certType certificate for
+// principal and writes it to path
+func writeTestCertificate(t *testing.T, ca cryptossh.Signer, key cryptossh.PublicKey, certType uint32, principal, path string) {
+	t.Helper()
+	cert := &cryptossh.Certificate{
+		Key:             key,
+		CertType:        certType,
+		KeyId:           "test-" + principal,
+		ValidPrincipals: []string{principal},
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+		Permissions:     cryptossh.Permissions{Extensions: map[string]string{"permit-pty": ""}},
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("SignCert failed: %v", err)
+	}
+	if err := os.WriteFile(path, cryptossh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWithCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestSigner(t)
+	first, second := newTestSigner(t), newTestSigner(t)
+	firstPath, secondPath := filepath.Join(dir, "id_first"), filepath.Join(dir, "id_second")
+
+	// An adjacent certificate for the first key, an explicit one for the second
+	writeTestCertificate(t, ca, first.PublicKey(), cryptossh.UserCert, sshtest.DefaultUser, firstPath+certSuffix)
+	explicit := filepath.Join(dir, "second.crt")
+	writeTestCertificate(t, ca, second.PublicKey(), cryptossh.UserCert, sshtest.DefaultUser, explicit)
+
+	signers, err := withCertificates([]cryptossh.Signer{first, second}, []string{firstPath, secondPath}, []string{explicit})
+	if err != nil {
+		t.Fatalf("withCertificates failed: %v", err)
+	}
+	if len(signers) != 4 {
+		t.Fatalf("got %d signers, want each key after its certificate", len(signers))
+	}
+	for i, want := range []cryptossh.Signer{first, first, second, second} {
+		pub := signers[i].PublicKey()
+		_, isCert := pub.(*cryptossh.Certificate)
+		if isCert != (i%2 == 0) {
+			t.Errorf("signer %d certificate = %v", i, isCert)
+		}
+		if isCert {
+			pub = pub.(*cryptossh.Certificate).Key
+		}
+		if string(pub.Marshal()) != string(want.PublicKey().Marshal()) {
+			t.Errorf("signer %d is for the wrong key", i)
+		}
+	}
+
+	// A certificate for another key is skipped next to a key but refused by --cert
+	writeTestCertificate(t, ca, first.PublicKey(), cryptossh.UserCert, "test", secondPath+certSuffix)
+	if signers, err := withCertificates([]cryptossh.Signer{second}, []string{secondPath}, nil); err != nil || len(signers) != 1 {
+		t.Errorf("a mismatched adjacent certificate should be skipped, got %d signers, %v", len(signers), err)
+	}
+	if _, err := withCertificates([]cryptossh.Signer{second}, []string{secondPath}, []string{firstPath + certSuffix}); err == nil {
+		t.Error("a --cert certificate for another key should be refused")
+	}
+	hostCert := filepath.Join(dir, "host-cert.pub")
+	writeTestCertificate(t, ca, second.PublicKey(), cryptossh.HostCert, "example.com", hostCert)
+	if _, err := withCertificates([]cryptossh.Signer{second}, []string{secondPath}, []string{hostCert}); err == nil {
+		t.Error("a host certificate should be refused")
+	}
+}
+
+func TestCertificateLogin(t *testing.T) {
+	ca := newTestSigner(t)
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{TrustedUserCAKeys: cryptossh.MarshalAuthorizedKey(ca.PublicKey())})
+	key := newTestSigner(t)
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	writeTestCertificate(t, ca, key.PublicKey(), cryptossh.UserCert, sshtest.DefaultUser, keyPath+certSuffix)
+
+	signers, err := withCertificates([]cryptossh.Signer{key}, []string{keyPath}, nil)
+	if err != nil {
+		t.Fatalf("withCertificates failed: %v", err)
+	}
+	auth := &clientAuth{signers: signers, stop: func() {}}
+	config := *srv.ClientConfig
+	config.Auth = auth.methods()
+	client, err := cryptossh.Dial("tcp", srv.Addr, &config)
+	if err != nil {
+		t.Fatalf("certificate login failed: %v", err)
+	}
+	client.Close()
+
+	// The key alone is not authorized
+	config.Auth = []cryptossh.AuthMethod{cryptossh.PublicKeys(key)}
+	if client, err := cryptossh.Dial("tcp", srv.Addr, &config); err == nil {
+		client.Close()
+		t.Error("the uncertified key should be refused")
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// envVar is a variable sent to the remote session
+type envVar struct {
+	name  string
+	value string
+}
+
+// sessionEnv returns the variables sent before the command or shell starts,
+// like OpenSSH's SendEnv and SetEnv: the local variables whose names match a
+// sendEnv pattern (which may use * and ?), then the setEnv NAME=VALUE
+// assignments, which replace local values of the same name
+func sessionEnv(sendEnv, setEnv, environ []string) ([]envVar, error) {
+	for _, pattern := range sendEnv {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid --send-env pattern %q", pattern)
+		}
+	}
+	var vars []envVar
+	index := map[string]int{}
+	add := func(name, value string) {
+		if i, ok := index[name]; ok {
+			vars[i].value = value
+			return
+		}
+		index[name] = len(vars)
+		vars = append(vars, envVar{name, value})
+	}
+	for _, variable := range environ {
+		name, value, _ := strings.Cut(variable, "=")
+		for _, pattern := range sendEnv {
+			if ok, _ := path.Match(pattern, name); ok {
+				add(name, value)
+				break
+			}
+		}
+	}
+	for _, assignment := range setEnv {
+		name, value, found := strings.Cut(assignment, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid --set-env %q, want NAME=VALUE", assignment)
+		}
+		add(name, value)
+	}
+	return vars, nil
+}
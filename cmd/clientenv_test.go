@@ -0,0 +1,35 @@
+// cmd/clientenv_test.go
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSessionEnv(t *testing.T) {
+	environ := []string{"LANG=en_US.UTF-8", "LC_TIME=de_DE.UTF-8", "LC_ALL=C", "HOME=/home/alice", "EMPTY="}
+
+	tests := []struct {
+		name    string
+		sendEnv []string
+		setEnv  []string
+		want    []envVar
+		wantErr bool
+	}{
+		{"nothing", nil, nil, nil, false},
+		{"exact name", []string{"LANG"}, nil, []envVar{{"LANG", "en_US.UTF-8"}}, false},
+		{"wildcard", []string{"LC_*"}, nil, []envVar{{"LC_TIME", "de_DE.UTF-8"}, {"LC_ALL", "C"}}, false},
+		{"unset variable", []string{"MISSING"}, nil, nil, false},
+		{"empty value", []string{"EMPTY"}, nil, []envVar{{"EMPTY", ""}}, false},
+		{"set", nil, []string{"APP_ENV=staging", "DSN=a=b"}, []envVar{{"APP_ENV", "staging"}, {"DSN", "a=b"}}, false},
+		{"set replaces local", []string{"LANG"}, []string{"LANG=C"}, []envVar{{"LANG", "C"}}, false},
+		{"invalid pattern", []string{"LC_["}, nil, nil, true},
+		{"set without value", nil, []string{"APP_ENV"}, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := sessionEnv(tt.sendEnv, tt.setEnv, environ)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: sessionEnv() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// exitCommandTimeout is the exit status when --cmd-timeout expires, the
+// status timeout(1) uses
+const exitCommandTimeout = 124
+
+// commandKillGrace is how long a timed-out command has to exit after SIGTERM
+// before its channel is closed
+const commandKillGrace = 5 * time.Second
+
+// errCommandTimeout is returned when a command outlives --cmd-timeout
+var errCommandTimeout = errors.New("command timed out")
+
+// runWithTimeout runs command on session like session.Run. A command still
+// running after timeout is sent SIGTERM, and its channel is closed if it has
+// not exited within commandKillGrace. A timeout of 0 means none.
+func runWithTimeout(session *ssh.Session, command string, timeout time.Duration) error {
+	if timeout <= 0 {
+		return session.Run(command)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := session.Start(command); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- session.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Debug("Command timed out, sending SIGTERM")
+	session.Signal(ssh.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(commandKillGrace):
+		log.Debug("Command ignored SIGTERM, closing the channel")
+		session.Close()
+	}
+	return fmt.Errorf("%w after %s", errCommandTimeout, timeout)
+}
@@ -0,0 +1,61 @@
+// cmd/cmdtimeout_test.go
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+)
+
+func TestRunWithTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+	client := srv.Dial(t)
+
+	tests := []struct {
+		command     string
+		timeout     time.Duration
+		wantTimeout bool
+		wantCode    int
+	}{
+		{"exit 2", 0, false, 2},
+		{"exit 0", time.Minute, false, 0},
+		{"exec sleep 30", 200 * time.Millisecond, true, exitCommandTimeout},
+	}
+	for _, tt := range tests {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("NewSession failed: %v", err)
+		}
+		start := time.Now()
+		err = runWithTimeout(session, tt.command, tt.timeout)
+		session.Close()
+		if errors.Is(err, errCommandTimeout) != tt.wantTimeout || exitCode(err) != tt.wantCode {
+			t.Errorf("runWithTimeout(%q, %s) = %v, exit code %d, want %d", tt.command, tt.timeout, err, exitCode(err), tt.wantCode)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("runWithTimeout(%q) took %s", tt.command, elapsed)
+		}
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	defer session.Close()
+	var out bytes.Buffer
+	result, err := runJSON(session, "example.com", "", "echo started; exec sleep 30", 200*time.Millisecond, false, &out)
+	if err != nil {
+		t.Fatalf("runJSON failed: %v", err)
+	}
+	if !result.TimedOut || result.exitStatus() != exitCommandTimeout || result.Stdout != "started\n" {
+		t.Errorf("timed out result = %+v, want timed_out with the output so far", result)
+	}
+}
@@ -0,0 +1,325 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// loadSigners reads the --key identity files in order, has Vault certify the
+// first one when --vault-role is set, and puts each key's certificates
+// before it. vaultCert is the certificate Vault signed, if any.
+func loadSigners() (signers []ssh.Signer, vaultCert *ssh.Certificate, err error) {
+	for _, keyPath := range clientKeyPaths {
+		log.Debug("Reading private key from: ", keyPath)
+		key, err := readPrivateKeyFile(keyPath, identityPassphrase)
+		if err != nil {
+			return nil, nil, err
+		}
+		signers = append(signers, key.signer)
+	}
+
+	// Have Vault certify the first key for this login
+	if vaultRole != "" {
+		log.Debug("Requesting a certificate from Vault role: ", vaultRole)
+		certSigner, cert, err := vaultUserSigner(signers[0], vaultMount, vaultRole, user, vaultTTL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to sign key with Vault: %s", err)
+		}
+		signers[0], vaultCert = certSigner, cert
+	}
+
+	// Offer each key's certificates before the key itself
+	if signers, err = withCertificates(signers, clientKeyPaths, certPaths); err != nil {
+		return nil, nil, fmt.Errorf("failed to load certificate: %s", err)
+	}
+	return signers, vaultCert, nil
+}
+
+// identityPassphrase asks on the terminal for the passphrase of the
+// encrypted identity file at path, which --batch mode can't do
+func identityPassphrase(path string) (string, error) {
+	if batchMode {
+		return "", fmt.Errorf("private key %s is encrypted and --batch mode can't ask for its passphrase: add it to an agent with gossh agent add", path)
+	}
+	return promptKeyPassphrase(path)
+}
+
+// newHostKeyCallback verifies host keys against known_hosts as
+// --strict-host-key-checking and --known-hosts-file say, trusting new hosts
+// on first use by default. stop is called before asking about a new host.
+func newHostKeyCallback(stop func()) (ssh.HostKeyCallback, error) {
+	checker := &hostKeyChecker{mode: strictHostKeyChecking, confirm: func(host string, key ssh.PublicKey) bool {
+		stop()
+		return confirmHostKey(host, key)
+	}}
+	if knownHostsFile != "" {
+		checker.files = []string{knownHostsFile}
+		checker.store = knownHostsFile
+	} else {
+		openSSHFile, gosshFile, err := defaultKnownHostsFiles()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate known_hosts: %s", err)
+		}
+		checker.files = []string{openSSHFile, gosshFile}
+		checker.store = gosshFile
+	}
+	callback, err := checker.callback()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up host key checking: %s", err)
+	}
+	return callback, nil
+}
+
+// addConnectionFlags adds the flags gossh scp, sftp and tunnel share with
+// gossh client for reaching and logging in to a server. The short names of
+// --port and --key differ: scp and sftp use OpenSSH's -P and -i.
+func addConnectionFlags(flags *pflag.FlagSet, portShorthand, keyShorthand string) {
+	flags.StringVarP(&port, "port", portShorthand, "22", "SSH server port")
+	flags.BoolVarP(&ipv4Only, "ipv4", "4", false, "Connect over IPv4 only")
+	flags.BoolVarP(&ipv6Only, "ipv6", "6", false, "Connect over IPv6 only")
+	flags.StringArrayVarP(&clientKeyPaths, "key", keyShorthand, nil, "Path to private key, or vault://path#field to read it from Vault (repeatable, tried in order before the agent's keys)")
+	flags.StringArrayVar(&certPaths, "cert", nil, "OpenSSH user certificate for one of the keys (repeatable; <key>-cert.pub next to a key is used automatically)")
+	flags.StringVarP(&jumpHosts, "jump", "J", "", "Connect through these jump hosts, [user@]host[:port] separated by commas, instead of the SSH config's ProxyJump")
+	flags.StringVar(&proxyURL, "proxy", "", "Connect through an HTTP CONNECT or SOCKS5 proxy, http://[user:password@]host:port or socks5://[user:password@]host:port")
+	flags.BoolVar(&noSSHConfig, "no-ssh-config", false, "Don't read HostName, User, Port, IdentityFile and ProxyJump from ~/.ssh/config")
+	flags.StringVarP(&timeout, "timeout", "t", "10s", "Connection timeout duration")
+	flags.IntVar(&retries, "retry", 0, "Retry failed connection attempts this many times; refused credentials are not retried")
+	flags.BoolVar(&passwordPrompt, "password-prompt", false, "Ask for a password and offer it after the key, if any")
+	flags.StringVar(&knownHostsFile, "known-hosts-file", "", "known_hosts file to check and save host keys in (default: ~/.ssh/known_hosts, saving to ~/.gossh/known_hosts)")
+	flags.StringVar(&strictHostKeyChecking, "strict-host-key-checking", hostKeyAsk, "Unknown host keys: ask to trust them (ask), trust them (accept-new), refuse them (yes), or skip checking (no)")
+	flags.BoolVar(&noBanner, "no-banner", false, "Don't show the banner the server sends before login")
+}
+
+// dialServer connects and logs in to host as user, as gossh client does:
+// through ~/.ssh/config, jump hosts and --proxy, with the keys, agent and
+// password of the connection flags, checking the host key against
+// known_hosts. flags are the command's flags, for telling set flags from
+// defaults.
+func dialServer(flags *pflag.FlagSet) (*ssh.Client, error) {
+	dial, err := serverDialer(flags)
+	if err != nil {
+		return nil, err
+	}
+	return dial()
+}
+
+// serverDialer settles everything dialServer needs once, reading the SSH
+// config and keys and asking for the password, and returns a function that
+// connects with it, as often as needed. Banners are shown on the first
+// connection only.
+func serverDialer(flags *pflag.FlagSet) (func() (*ssh.Client, error), error) {
+	c, err := newConnector(flags)
+	if err != nil {
+		return nil, err
+	}
+	target, err := c.resolve(host, user, "")
+	if err != nil {
+		return nil, err
+	}
+	if passwordPrompt {
+		if c.password, err = promptPassword(target.user, target.host); err != nil {
+			return nil, fmt.Errorf("failed to read password: %s", err)
+		}
+	}
+
+	var banners bannerCollector
+	bannerShown := false
+	return func() (*ssh.Client, error) {
+		client, err := c.dial(target, &banners)
+		if !noBanner && !bannerShown {
+			banners.print(os.Stderr)
+			bannerShown = banners.String() != ""
+		}
+		return client, err
+	}, nil
+}
+
+// connector connects to servers with the credentials, proxy and host key
+// checking of the connection flags, resolving each host through
+// ~/.ssh/config. It is safe for concurrent use.
+type connector struct {
+	sshConf *sshConfig
+	// jumps are the --jump hosts, used instead of each host's ProxyJump
+	jumps    []jumpHost
+	jumpsSet bool
+	// port is --port when it was given, overriding the SSH config
+	port            string
+	proxy           *url.URL
+	timeout         time.Duration
+	signers         []ssh.Signer
+	agent           agent.Agent
+	password        string
+	hostKeyCallback ssh.HostKeyCallback
+
+	mu sync.Mutex
+	// identityFiles are the keys read from the SSH config's IdentityFile
+	// entries, by path
+	identityFiles map[string][]ssh.Signer
+}
+
+// connTarget is a server resolved through ~/.ssh/config
+type connTarget struct {
+	user string
+	// host is the HostName to connect to
+	host    string
+	port    string
+	jumps   []jumpHost
+	signers []ssh.Signer
+}
+
+// newConnector reads the SSH config, the --key identity files and the agent
+// for the connection flags. Host key checks are serialized, so only one
+// question about a new host is asked at a time.
+func newConnector(flags *pflag.FlagSet) (*connector, error) {
+	c := &connector{sshConf: &sshConfig{}, identityFiles: map[string][]ssh.Signer{}}
+	if !noSSHConfig {
+		path, err := defaultSSHConfigFile()
+		if err == nil {
+			c.sshConf, err = loadSSHConfig(path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH config: %s", err)
+		}
+	}
+	if jumpHosts != "" {
+		var err error
+		if c.jumps, err = parseJumpHosts(jumpHosts, c.sshConf); err != nil {
+			return nil, err
+		}
+		c.jumpsSet = true
+	}
+	if flags.Changed("port") {
+		c.port = port
+	}
+	if ipv4Only && ipv6Only {
+		return nil, errors.New("-4 and -6 can't be combined")
+	}
+	if proxyURL != "" {
+		var err error
+		if c.proxy, err = parseProxy(proxyURL); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if c.timeout, err = time.ParseDuration(timeout); err != nil {
+		return nil, fmt.Errorf("invalid timeout: %s", err)
+	}
+
+	if c.signers, _, err = loadSigners(); err != nil {
+		return nil, err
+	}
+	if c.agent, err = dialAgent(); err != nil {
+		log.Debug("Not using the agent: ", err)
+	}
+	callback, err := newHostKeyCallback(func() {})
+	if err != nil {
+		return nil, err
+	}
+	var hostKeyMu sync.Mutex
+	c.hostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		hostKeyMu.Lock()
+		defer hostKeyMu.Unlock()
+		return callback(hostname, remote, key)
+	}
+	if strictHostKeyChecking == hostKeyOff {
+		log.Warn("Host key checking is off - the host won't be verified")
+	}
+	return c, nil
+}
+
+// resolve looks alias up in the SSH config. userName and portNumber, when
+// not empty, take precedence over it, as do --port and --jump; the user
+// defaults to the local one. The --key identity files are used for every
+// host, or else the host's IdentityFile entries.
+func (c *connector) resolve(alias, userName, portNumber string) (connTarget, error) {
+	hc := c.sshConf.lookup(alias)
+	t := connTarget{user: userName, host: hc.hostName, port: portNumber}
+	if t.user == "" {
+		t.user = hc.user
+	}
+	if t.user == "" {
+		t.user = localUser()
+	}
+	for _, p := range []string{c.port, hc.port, "22"} {
+		if t.port == "" {
+			t.port = p
+		}
+	}
+	t.jumps = c.jumps
+	if !c.jumpsSet && hc.proxyJump != "" {
+		var err error
+		if t.jumps, err = parseJumpHosts(hc.proxyJump, c.sshConf); err != nil {
+			return connTarget{}, err
+		}
+	}
+	t.signers = c.signers
+	if len(clientKeyPaths) == 0 {
+		var err error
+		if t.signers, err = c.loadIdentityFiles(hc.identityFilesFor(t.user)); err != nil {
+			return connTarget{}, err
+		}
+	}
+	return t, nil
+}
+
+// loadIdentityFiles reads the keys at paths, with their certificates,
+// reading each file once
+func (c *connector) loadIdentityFiles(paths []string) ([]ssh.Signer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var signers []ssh.Signer
+	for _, path := range paths {
+		if _, ok := c.identityFiles[path]; !ok {
+			log.Debug("Reading private key from: ", path)
+			key, err := readPrivateKeyFile(path, identityPassphrase)
+			if err != nil {
+				return nil, err
+			}
+			if c.identityFiles[path], err = withCertificates([]ssh.Signer{key.signer}, []string{path}, nil); err != nil {
+				return nil, fmt.Errorf("failed to load certificate: %s", err)
+			}
+		}
+		signers = append(signers, c.identityFiles[path]...)
+	}
+	return signers, nil
+}
+
+// dial connects and logs in to t, collecting the banners servers send in
+// banners when it is not nil
+func (c *connector) dial(t connTarget, banners *bannerCollector) (*ssh.Client, error) {
+	if len(t.signers) == 0 && c.agent == nil && c.password == "" {
+		return nil, errors.New("a private key (--key), an agent (SSH_AUTH_SOCK) or --password-prompt is required")
+	}
+	auth := &clientAuth{signers: t.signers, agent: c.agent, password: c.password, stop: func() {}}
+	config := &ssh.ClientConfig{
+		User:            t.user,
+		Auth:            auth.methods(),
+		HostKeyCallback: c.hostKeyCallback,
+		Timeout:         c.timeout,
+	}
+	if banners != nil {
+		config.BannerCallback = banners.callback
+	}
+
+	// IPv6 addresses may be given in brackets, as in URLs
+	addr := net.JoinHostPort(strings.Trim(t.host, "[]"), t.port)
+	log.Debug("Dialing SSH server at ", addr)
+	client, err := withRetry(func() (*ssh.Client, error) {
+		return dialVia(c.proxy, t.jumps, addr, config)
+	}, retries, retryBackoff)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("Authenticated with ", auth.used)
+	return client, nil
+}
@@ -0,0 +1,80 @@
+// cmd/connect_test.go
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func TestLoginWithEncryptedKey(t *testing.T) {
+	origKeyPaths, origBatch, origStdin := clientKeyPaths, batchMode, stdin
+	defer func() { clientKeyPaths, batchMode, stdin = origKeyPaths, origBatch, origStdin }()
+
+	// A key as gossh keygen writes it given a passphrase, authorized for
+	// the test user
+	dir := t.TempDir()
+	private, public, err := ssh.GenerateKeys(ssh.KeyOptions{Comment: "alice@laptop", Passphrase: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	writeFile(t, keyPath, string(private), 0o600)
+	keysDir := filepath.Join(dir, "authorized_keys.d")
+	if err := os.Mkdir(keysDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(keysDir, sshtest.DefaultUser), string(public), 0o644)
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{AuthorizedKeysDir: keysDir})
+
+	login := func(signers []cryptossh.Signer) {
+		t.Helper()
+		config := *srv.ClientConfig
+		config.Auth = []cryptossh.AuthMethod{cryptossh.PublicKeys(signers...)}
+		client, err := cryptossh.Dial("tcp", srv.Addr, &config)
+		if err != nil {
+			t.Fatalf("login with the encrypted key failed: %v", err)
+		}
+		client.Close()
+	}
+
+	// --key asks for the passphrase
+	clientKeyPaths, batchMode = []string{keyPath}, false
+	stdin = bufio.NewReader(strings.NewReader("hunter2\n"))
+	signers, _, err := loadSigners()
+	if err != nil {
+		t.Fatalf("loadSigners() failed: %v", err)
+	}
+	login(signers)
+
+	// IdentityFile keys are asked for once, however many hosts use them
+	stdin = bufio.NewReader(strings.NewReader("hunter2\n"))
+	c := &connector{identityFiles: map[string][]cryptossh.Signer{}}
+	for i := 0; i < 2; i++ {
+		if signers, err = c.loadIdentityFiles([]string{keyPath}); err != nil {
+			t.Fatalf("loadIdentityFiles() failed: %v", err)
+		}
+		login(signers)
+	}
+
+	stdin = bufio.NewReader(strings.NewReader("wrong\n"))
+	if _, _, err := loadSigners(); err == nil {
+		t.Error("loadSigners() with a wrong passphrase succeeded")
+	}
+
+	// --batch fails instead of asking
+	batchMode = true
+	stdin = bufio.NewReader(strings.NewReader("hunter2\n"))
+	if _, _, err := loadSigners(); err == nil || !strings.Contains(err.Error(), "--batch") {
+		t.Errorf("loadSigners() in --batch mode = %v, want an error about --batch", err)
+	}
+	if _, err := (&connector{identityFiles: map[string][]cryptossh.Signer{}}).loadIdentityFiles([]string{keyPath}); err == nil {
+		t.Error("loadIdentityFiles() in --batch mode succeeded")
+	}
+}
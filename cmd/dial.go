@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// connectionAttemptDelay is how long one address gets before the next is
+// tried alongside it, the delay RFC 8305 recommends
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// dialNetwork returns the network to dial the server on, as limited by -4
+// or -6
+func dialNetwork() string {
+	switch {
+	case ipv4Only:
+		return "tcp4"
+	case ipv6Only:
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// dialSSH connects to the SSH server at addr like ssh.Dial, through proxy
+// unless it is nil, or else trying all of the host's addresses as dialTCP
+// does
+func dialSSH(network string, proxy *url.URL, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+	if proxy != nil {
+		conn, err = dialProxy(proxy, network, addr, config.Timeout)
+	} else {
+		conn, err = dialTCP(network, addr, config.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// dialTCP connects to host:port over network ("tcp", or "tcp4" or "tcp6"
+// for one address family). When the host has several addresses they are
+// tried Happy Eyeballs style (RFC 8305): alternating between IPv6 and IPv4,
+// each started connectionAttemptDelay after the last or as soon as it
+// fails, and the first connection made wins. A timeout of 0 means none.
+func dialTCP(network, addr string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip"+strings.TrimPrefix(network, "tcp"), host)
+	if err != nil {
+		return nil, err
+	}
+	return dialAddresses(ctx, network, interleaveFamilies(ips), port)
+}
+
+// interleaveFamilies orders ips to alternate between address families,
+// starting with the family of the first, which the resolver prefers
+func interleaveFamilies(ips []net.IP) []net.IP {
+	if len(ips) == 0 {
+		return nil
+	}
+	var first, second []net.IP
+	firstIsV4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) == firstIsV4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+// dialResult is the outcome of one connection attempt
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// dialAddresses races connections to ips on port, as dialTCP describes
+func dialAddresses(ctx context.Context, network string, ips []net.IP, port string) (net.Conn, error) {
+	if len(ips) == 0 {
+		return nil, errors.New("no addresses to connect to")
+	}
+	attemptCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var dialer net.Dialer
+	results := make(chan dialResult, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		target := net.JoinHostPort(ips[next].String(), port)
+		next++
+		pending++
+		go func() {
+			conn, err := dialer.DialContext(attemptCtx, network, target)
+			results <- dialResult{conn, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(connectionAttemptDelay)
+	defer timer.Stop()
+	var failures []error
+	for pending > 0 {
+		var startNext <-chan time.Time
+		if next < len(ips) {
+			startNext = timer.C
+		}
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				// Close the connections that lose the race
+				go func(losers int) {
+					for ; losers > 0; losers-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return result.conn, nil
+			}
+			failures = append(failures, result.err)
+			if next < len(ips) {
+				start()
+				timer.Reset(connectionAttemptDelay)
+			}
+		case <-startNext:
+			start()
+			timer.Reset(connectionAttemptDelay)
+		}
+	}
+	if len(failures) == 1 {
+		return nil, failures[0]
+	}
+	messages := make([]string, len(failures))
+	for i, err := range failures {
+		messages[i] = err.Error()
+	}
+	return nil, fmt.Errorf("all %d addresses failed: %s", len(failures), strings.Join(messages, "; "))
+}
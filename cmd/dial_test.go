@@ -0,0 +1,60 @@
+// cmd/dial_test.go
+package cmd
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInterleaveFamilies(t *testing.T) {
+	v4a, v4b := net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2")
+	v6a, v6b, v6c := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), net.ParseIP("2001:db8::3")
+
+	tests := []struct {
+		name string
+		ips  []net.IP
+		want []net.IP
+	}{
+		{"empty", nil, nil},
+		{"IPv6 first", []net.IP{v6a, v6b, v6c, v4a, v4b}, []net.IP{v6a, v4a, v6b, v4b, v6c}},
+		{"IPv4 first", []net.IP{v4a, v4b, v6a}, []net.IP{v4a, v6a, v4b}},
+		{"one family", []net.IP{v4a, v4b}, []net.IP{v4a, v4b}},
+	}
+	for _, tt := range tests {
+		if got := interleaveFamilies(tt.ips); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: interleaveFamilies = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDialAddresses(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	// Nothing listens on the first address, so the second has to win
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ips := []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")}
+	conn, err := dialAddresses(ctx, "tcp", ips, port)
+	if err != nil {
+		t.Fatalf("dialAddresses failed: %v", err)
+	}
+	conn.Close()
+	if got := conn.RemoteAddr().String(); got != listener.Addr().String() {
+		t.Errorf("connected to %s, want %s", got, listener.Addr())
+	}
+
+	if _, err := dialTCP("tcp6", listener.Addr().String(), time.Second); err == nil {
+		t.Error("dialing an IPv4 address over tcp6 should fail")
+	}
+	if _, err := dialAddresses(ctx, "tcp", nil, port); err == nil {
+		t.Error("dialing no addresses should fail")
+	}
+}
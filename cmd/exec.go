@@ -0,0 +1,446 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	hostsFile     string
+	fleetParallel int
+	hostTimeout   time.Duration
+)
+
+// exitFleetCommandFailed is the exit status of gossh exec when the command
+// exited with a non-zero status on some host
+const exitFleetCommandFailed = 1
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:   "exec",
+	Short: "Run a command on many hosts in parallel",
+	Long: `Run a command on every host of a list, several hosts at a time, like a small
+fleet tool. Each line of output is prefixed with its host, or with --output
+json every host's result is printed as one JSON object per line as it
+finishes.
+
+Hosts come from a --hosts file or from --group groups of an inventory (see
+gossh inventory). {name} in the command is replaced with the host's variable
+of that name, such as {host}, the host's name.
+
+Hosts are connected to as gossh client does, each through its
+~/.ssh/config entry, with the keys, agent and password of the flags. The
+user, port and keys a hosts file line or the inventory gives win over the
+flags'.
+
+gossh exec exits with 0 when the command succeeded everywhere, 255 when a host
+could not be reached or its command ended without an exit status, 124 when a
+host timed out, and 1 when the command failed on some host.
+
+Examples:
+  # Check the uptime of every host, 20 at a time
+  gossh exec --hosts hosts.txt --cmd "uptime" --parallel 20
+
+  # Give each host 30 seconds, and collect the results as JSON lines
+  gossh exec --hosts hosts.txt --cmd "systemctl is-active nginx" --host-timeout 30s --output json
+
+  # Run on the inventory's web group, with each host's role variable
+  gossh exec --group web --cmd "deploy --role {role}"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Fprintln(os.Stderr, errorColor("✗ ")+err.Error())
+			os.Exit(1)
+		}
+		if outputFormat == outputJSON {
+			log.SetOutput(os.Stderr)
+		}
+		if err := validateExecFlags(); err != nil {
+			fail(err)
+		}
+
+		hosts, err := selectFleetHosts()
+		if err != nil {
+			fail(err)
+		}
+
+		c, err := newFleetConnector(cmd.Flags())
+		if err != nil {
+			fail(err)
+		}
+
+		f := &fleet{
+			dial:        fleetDialer(c),
+			command:     command,
+			parallel:    fleetParallel,
+			hostTimeout: hostTimeout,
+			json:        outputFormat == outputJSON,
+			useBase64:   outputBase64,
+			stdout:      os.Stdout,
+			stderr:      os.Stderr,
+		}
+		results := f.run(hosts)
+
+		status, failed := fleetExitStatus(results)
+		if !f.json {
+			if len(failed) == 0 {
+				fmt.Println(successColor("✓ ") + fmt.Sprintf("Succeeded on all %d hosts", len(results)))
+			} else {
+				fmt.Println(errorColor("✗ ") + fmt.Sprintf("Failed on %d of %d hosts: %s", len(failed), len(results), strings.Join(failed, ", ")))
+			}
+		}
+		os.Exit(status)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(execCmd)
+
+	addHostSelectionFlags(execCmd.Flags())
+	execCmd.Flags().StringVarP(&command, "cmd", "c", "", "Command to run on every host")
+	execCmd.Flags().IntVar(&fleetParallel, "parallel", 10, "Number of hosts to run the command on at once")
+	execCmd.Flags().DurationVar(&hostTimeout, "host-timeout", 0, "Give up on a host after this long, connecting included; its command is sent SIGTERM (0 disables)")
+	execCmd.Flags().StringVarP(&outputFormat, "output", "o", outputText, "text for output lines prefixed with their host, or json for one result object per host and line")
+	execCmd.Flags().BoolVar(&outputBase64, "base64", false, "Base64-encode stdout and stderr in --output json, for binary output")
+	execCmd.Flags().StringVarP(&user, "user", "u", "", "SSH username for hosts that don't name one (default: the SSH config's User, or the local user)")
+	addConnectionFlags(execCmd.Flags(), "p", "k")
+}
+
+// validateExecFlags checks the flags of gossh exec
+func validateExecFlags() error {
+	switch {
+	case command == "":
+		return errors.New("--cmd is required")
+	case fleetParallel < 1:
+		return errors.New("--parallel must be at least 1")
+	case hostTimeout < 0:
+		return errors.New("--host-timeout can't be negative")
+	case outputFormat != outputText && outputFormat != outputJSON:
+		return fmt.Errorf("invalid output format %q (use text or json)", outputFormat)
+	}
+	return nil
+}
+
+// fleetHost is a host of a --hosts file or an inventory
+type fleetHost struct {
+	// name is the hosts file line as written, or the inventory name, which
+	// output is labelled with
+	name string
+	user string
+	host string
+	port string
+	// keys are private keys to log in with instead of the usual ones
+	keys []string
+	// vars fill in {name} placeholders in commands
+	vars map[string]string
+}
+
+// parseHostsFile reads one [user@]host[:port] per line, with IPv6
+// addresses in brackets. Blank lines and text after # are skipped.
+func parseHostsFile(r io.Reader) ([]fleetHost, error) {
+	var hosts []fleetHost
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		h := fleetHost{name: text}
+		hostPort := text
+		if at := strings.LastIndex(text, "@"); at >= 0 {
+			h.user, hostPort = text[:at], text[at+1:]
+		}
+		if hostName, portNumber, err := net.SplitHostPort(hostPort); err == nil {
+			h.host, h.port = hostName, portNumber
+		} else {
+			h.host = strings.Trim(hostPort, "[]")
+		}
+		if h.host == "" || strings.ContainsAny(h.host, " \t") {
+			return nil, fmt.Errorf("hosts file line %d: invalid host %q", line, text)
+		}
+		h.vars = map[string]string{"host": h.host}
+		hosts = append(hosts, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read hosts file: %s", err)
+	}
+	if len(hosts) == 0 {
+		return nil, errors.New("the hosts file lists no hosts")
+	}
+	return hosts, nil
+}
+
+// newFleetConnector returns a connector for the connection flags, asking for
+// the --password-prompt password once for all hosts
+func newFleetConnector(flags *pflag.FlagSet) (*connector, error) {
+	c, err := newConnector(flags)
+	if err != nil {
+		return nil, err
+	}
+	if passwordPrompt {
+		fmt.Print("Password for all hosts: ")
+		if c.password, err = readSecret(); err != nil {
+			return nil, fmt.Errorf("failed to read password: %s", err)
+		}
+	}
+	return c, nil
+}
+
+// fleetDialer returns a function connecting to fleet hosts through c, with
+// --user for hosts that don't name a user and the host's own keys when it
+// has some
+func fleetDialer(c *connector) func(fleetHost, *bannerCollector) (*cryptossh.Client, error) {
+	return func(h fleetHost, banners *bannerCollector) (*cryptossh.Client, error) {
+		userName := h.user
+		if userName == "" {
+			userName = user
+		}
+		target, err := c.resolve(h.host, userName, h.port)
+		if err != nil {
+			return nil, err
+		}
+		if len(h.keys) > 0 {
+			if target.signers, err = c.loadIdentityFiles(h.keys); err != nil {
+				return nil, err
+			}
+		}
+		return c.dial(target, banners)
+	}
+}
+
+// fleet runs a command on many hosts at once
+type fleet struct {
+	dial        func(fleetHost, *bannerCollector) (*cryptossh.Client, error)
+	command     string
+	parallel    int
+	hostTimeout time.Duration
+	// json prints a commandResult per host instead of prefixed output
+	json      bool
+	useBase64 bool
+	stdout    io.Writer
+	stderr    io.Writer
+
+	// mu keeps lines and results of different hosts from interleaving
+	mu sync.Mutex
+}
+
+// run runs the command on hosts, at most parallel at a time, and returns
+// their results in the order of hosts
+func (f *fleet) run(hosts []fleetHost) []commandResult {
+	width := 0
+	for _, h := range hosts {
+		width = max(width, len(h.name))
+	}
+	results := make([]commandResult, len(hosts))
+	runParallel(len(hosts), f.parallel, func(i int) {
+		results[i] = f.runHost(hosts[i], width)
+	})
+	return results
+}
+
+// runParallel calls fn with every index below n, from at most parallel
+// goroutines at a time, and waits for all calls to return
+func runParallel(n, parallel int, fn func(i int)) {
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(parallel, n) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				fn(i)
+			}
+		}()
+	}
+	for i := range n {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
+
+// runHost connects to h and runs the command, within --host-timeout
+func (f *fleet) runHost(h fleetHost, width int) commandResult {
+	start := time.Now()
+	command := expandHostVars(f.command, h.vars)
+	failed := func(err error) commandResult {
+		result := newCommandResult(h.name, command, ssh.Result{ExitCode: -1, Duration: time.Since(start)}, err, f.useBase64)
+		f.report(result)
+		return result
+	}
+
+	ctx := context.Background()
+	if f.hostTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.hostTimeout)
+		defer cancel()
+	}
+	var banners bannerCollector
+	client, err := dialContext(ctx, func() (*cryptossh.Client, error) { return f.dial(h, &banners) })
+	if errors.Is(err, errCommandTimeout) {
+		return failed(fmt.Errorf("%w while connecting, after %s", errCommandTimeout, f.hostTimeout))
+	} else if err != nil {
+		return failed(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		return failed(fmt.Errorf("failed to create session: %s", err))
+	}
+	defer session.Close()
+
+	// Text output is streamed line by line as it arrives
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	prefix := color.CyanString("%-*s", width, h.name) + " | "
+	outLines := &prefixWriter{prefix: prefix, w: f.stdout, mu: &f.mu}
+	errLines := &prefixWriter{prefix: prefix, w: f.stderr, mu: &f.mu}
+	if !f.json {
+		session.Stdout = io.MultiWriter(&stdout, outLines)
+		session.Stderr = io.MultiWriter(&stderr, errLines)
+	}
+	timeout := time.Duration(0)
+	if f.hostTimeout > 0 {
+		// At least a moment, so a command isn't stopped before it starts
+		timeout = max(f.hostTimeout-time.Since(start), time.Millisecond)
+	}
+	err = runWithTimeout(session, command, timeout)
+	if errors.Is(err, errCommandTimeout) {
+		// Report the whole --host-timeout rather than what was left of it
+		err = fmt.Errorf("%w after %s", errCommandTimeout, f.hostTimeout)
+	}
+	outLines.Flush()
+	errLines.Flush()
+	res, err := ssh.NewResult(stdout.Bytes(), stderr.Bytes(), time.Since(start), err)
+	result := newCommandResult(h.name, command, res, err, f.useBase64)
+	if !noBanner {
+		result.Banner = banners.String()
+	}
+	f.report(result)
+	return result
+}
+
+// dialContext calls dial, giving up with errCommandTimeout once ctx is
+// done. A connection made after that is closed.
+func dialContext(ctx context.Context, dial func() (*cryptossh.Client, error)) (*cryptossh.Client, error) {
+	if ctx.Done() == nil {
+		return dial()
+	}
+	type dialed struct {
+		client *cryptossh.Client
+		err    error
+	}
+	done := make(chan dialed, 1)
+	go func() {
+		client, err := dial()
+		done <- dialed{client, err}
+	}()
+	select {
+	case d := <-done:
+		return d.client, d.err
+	case <-ctx.Done():
+		go func() {
+			if d := <-done; d.client != nil {
+				d.client.Close()
+			}
+		}()
+		return nil, errCommandTimeout
+	}
+}
+
+// report prints result as a JSON line, or reports a failure to reach the
+// host or a missing exit status, as the output was already printed
+func (f *fleet) report(result commandResult) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.json {
+		line, err := json.Marshal(result)
+		if err != nil {
+			log.Error("Failed to encode result: ", err)
+			return
+		}
+		fmt.Fprintf(f.stdout, "%s\n", line)
+		return
+	}
+	if result.Error != "" {
+		fmt.Fprintf(f.stderr, "%s: %s\n", result.Host, result.Error)
+	} else if result.ExitCode != 0 {
+		fmt.Fprintf(f.stderr, "%s: exit status %d\n", result.Host, result.ExitCode)
+	}
+}
+
+// fleetExitStatus returns the exit status of gossh exec for results and the
+// hosts where the command didn't succeed
+func fleetExitStatus(results []commandResult) (int, []string) {
+	status := 0
+	var failed []string
+	for _, result := range results {
+		hostStatus := result.exitStatus()
+		if !result.TimedOut && result.ExitCode > 0 {
+			// The command's own status, whatever it is
+			hostStatus = exitFleetCommandFailed
+		}
+		if hostStatus != 0 {
+			failed = append(failed, result.Host)
+		}
+		// Unreachable hosts outrank timeouts, which outrank failed commands
+		status = max(status, hostStatus)
+	}
+	return status, failed
+}
+
+// prefixWriter writes whole lines to w, each starting with prefix, holding
+// mu while writing so lines of different writers don't interleave
+type prefixWriter struct {
+	prefix string
+	w      io.Writer
+	mu     *sync.Mutex
+	// partial is the unfinished last line
+	partial []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	end := bytes.LastIndexByte(p.partial, '\n')
+	if end < 0 {
+		return len(b), nil
+	}
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(p.partial[:end+1], []byte("\n")) {
+		if len(line) > 0 {
+			out.WriteString(p.prefix)
+			out.Write(line)
+		}
+	}
+	p.partial = append(p.partial[:0], p.partial[end+1:]...)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(out.Bytes())
+	return len(b), err
+}
+
+// Flush writes an unfinished last line, ending it with a newline
+func (p *prefixWriter) Flush() {
+	if len(p.partial) > 0 {
+		p.Write([]byte("\n"))
+	}
+}
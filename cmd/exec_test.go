@@ -0,0 +1,195 @@
+// cmd/exec_test.go
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	"github.com/fatih/color"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func TestParseHostsFile(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []fleetHost
+		wantErr bool
+	}{
+		{
+			"# web servers\nweb1\n\n  deploy@web2:2222  # staging\n",
+			[]fleetHost{
+				{name: "web1", host: "web1", vars: map[string]string{"host": "web1"}},
+				{name: "deploy@web2:2222", user: "deploy", host: "web2", port: "2222", vars: map[string]string{"host": "web2"}},
+			},
+			false,
+		},
+		{
+			"[::1]:2200\nroot@[fe80::1]\nme@corp@bastion\n",
+			[]fleetHost{
+				{name: "[::1]:2200", host: "::1", port: "2200", vars: map[string]string{"host": "::1"}},
+				{name: "root@[fe80::1]", host: "fe80::1", user: "root", vars: map[string]string{"host": "fe80::1"}},
+				{name: "me@corp@bastion", user: "me@corp", host: "bastion", vars: map[string]string{"host": "bastion"}},
+			},
+			false,
+		},
+		{"# nothing here\n\n", nil, true},
+		{"web1\nuser@\n", nil, true},
+		{"two words\n", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseHostsFile(strings.NewReader(tt.input))
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseHostsFile(%q) = %+v, %v, want %+v (error: %v)", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFleetExitStatus(t *testing.T) {
+	ok := commandResult{Host: "ok"}
+	failed := commandResult{Host: "failed", ExitCode: 124}
+	timedOut := commandResult{Host: "slow", ExitCode: -1, TimedOut: true}
+	unreachable := commandResult{Host: "down", ExitCode: -1, Error: "connection refused"}
+	tests := []struct {
+		results    []commandResult
+		wantStatus int
+		wantFailed []string
+	}{
+		{[]commandResult{ok, ok}, 0, nil},
+		{[]commandResult{ok, failed}, exitFleetCommandFailed, []string{"failed"}},
+		{[]commandResult{failed, timedOut, ok}, exitCommandTimeout, []string{"failed", "slow"}},
+		{[]commandResult{timedOut, unreachable, failed}, exitRemoteFailure, []string{"slow", "down", "failed"}},
+	}
+	for _, tt := range tests {
+		status, failed := fleetExitStatus(tt.results)
+		if status != tt.wantStatus || !reflect.DeepEqual(failed, tt.wantFailed) {
+			t.Errorf("fleetExitStatus(%+v) = %d, %v, want %d, %v", tt.results, status, failed, tt.wantStatus, tt.wantFailed)
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &prefixWriter{prefix: "web1 | ", w: &out, mu: &sync.Mutex{}}
+	for _, chunk := range []string{"one\ntw", "o\n", "", "three\nfour\nfi", "ve"} {
+		w.Write([]byte(chunk))
+	}
+	if got, want := out.String(), "web1 | one\nweb1 | two\nweb1 | three\nweb1 | four\n"; got != want {
+		t.Errorf("written %q, want %q", got, want)
+	}
+	w.Flush()
+	w.Flush()
+	if got := out.String(); !strings.HasSuffix(got, "four\nweb1 | five\n") {
+		t.Errorf("flushed %q", got)
+	}
+}
+
+// startFleet returns a fleet running command on a test server for every host
+// but "down", which can't be reached
+func startFleet(t *testing.T, command string) (*fleet, *bytes.Buffer, *bytes.Buffer) {
+	t.Helper()
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+	var stdout, stderr bytes.Buffer
+	f := &fleet{
+		dial: func(h fleetHost, _ *bannerCollector) (*cryptossh.Client, error) {
+			if h.host == "down" {
+				return nil, errors.New("connection refused")
+			}
+			return cryptossh.Dial("tcp", srv.Addr, srv.ClientConfig)
+		},
+		command:  command,
+		parallel: 2,
+		stdout:   &stdout,
+		stderr:   &stderr,
+	}
+	return f, &stdout, &stderr
+}
+
+func TestFleetRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+	hosts := []fleetHost{
+		{name: "web1", host: "web1", vars: map[string]string{"role": "frontend"}},
+		{name: "db", host: "db"},
+		{name: "down", host: "down"},
+	}
+
+	f, stdout, stderr := startFleet(t, "echo up {role}; printf 'no newline'; echo oops >&2")
+	results := f.run(hosts)
+	if len(results) != 3 || results[0].Host != "web1" || results[2].Host != "down" {
+		t.Fatalf("results = %+v, want one per host in order", results)
+	}
+	if results[1].Stdout != "up {role}\nno newline" || results[1].ExitCode != 0 {
+		t.Errorf("db result = %+v", results[1])
+	}
+	for _, want := range []string{"web1 | up frontend\n", "db   | up {role}\n", "db   | no newline\n"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("stdout is missing %q:\n%s", want, stdout)
+		}
+	}
+	for _, want := range []string{"web1 | oops\n", "down: connection refused\n"} {
+		if !strings.Contains(stderr.String(), want) {
+			t.Errorf("stderr is missing %q:\n%s", want, stderr)
+		}
+	}
+
+	// JSON mode prints one result per line and nothing else
+	f, stdout, stderr = startFleet(t, "exit 3")
+	f.json = true
+	f.run(hosts)
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || stderr.Len() != 0 {
+		t.Fatalf("JSON output = %q, stderr %q, want three lines", stdout, stderr)
+	}
+	exitCodes := map[string]int{}
+	for _, line := range lines {
+		var result commandResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("line is not JSON: %v\n%s", err, line)
+		}
+		exitCodes[result.Host] = result.ExitCode
+	}
+	if want := map[string]int{"web1": 3, "db": 3, "down": -1}; !reflect.DeepEqual(exitCodes, want) {
+		t.Errorf("exit codes = %v, want %v", exitCodes, want)
+	}
+}
+
+func TestFleetHostTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	f, _, stderr := startFleet(t, "exec sleep 30")
+	f.hostTimeout = 200 * time.Millisecond
+	start := time.Now()
+	results := f.run([]fleetHost{{name: "slow", host: "slow"}})
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("run took %s despite the host timeout", elapsed)
+	}
+	if !results[0].TimedOut || !strings.Contains(results[0].Error, "after 200ms") {
+		t.Errorf("result = %+v, want a timeout after 200ms", results[0])
+	}
+	if !strings.Contains(stderr.String(), "slow: command timed out") {
+		t.Errorf("stderr = %q", stderr)
+	}
+
+	// Connecting counts against the timeout too
+	f.dial = func(fleetHost, *bannerCollector) (*cryptossh.Client, error) {
+		time.Sleep(time.Second)
+		return nil, errors.New("too late")
+	}
+	results = f.run([]fleetHost{{name: "hanging", host: "hanging"}})
+	if !results[0].TimedOut || !strings.Contains(results[0].Error, "while connecting") {
+		t.Errorf("result = %+v, want a timeout while connecting", results[0])
+	}
+}
@@ -0,0 +1,288 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// forwardDialer opens connections from the server, as *ssh.Client does
+type forwardDialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// localForward is a local port forwarded through the server (ssh -L)
+type localForward struct {
+	bind string
+	dest string
+}
+
+// parseLocalForward parses [bind_address:]port:host:hostport. The local port
+// binds to localhost unless an address is given; IPv6 addresses go in
+// brackets.
+func parseLocalForward(spec string) (localForward, error) {
+	fields := splitForwardSpec(spec)
+	var bindHost, bindPort, destHost, destPort string
+	switch len(fields) {
+	case 3:
+		bindHost, bindPort, destHost, destPort = "localhost", fields[0], fields[1], fields[2]
+	case 4:
+		bindHost, bindPort, destHost, destPort = fields[0], fields[1], fields[2], fields[3]
+	default:
+		return localForward{}, fmt.Errorf("invalid forward %q, want [bind_address:]port:host:hostport", spec)
+	}
+	if bindPort == "" || destHost == "" || destPort == "" {
+		return localForward{}, fmt.Errorf("invalid forward %q, want [bind_address:]port:host:hostport", spec)
+	}
+	return localForward{
+		bind: net.JoinHostPort(bindHost, bindPort),
+		dest: net.JoinHostPort(destHost, destPort),
+	}, nil
+}
+
+// splitForwardSpec splits spec on colons outside [brackets], dropping the
+// brackets
+func splitForwardSpec(spec string) []string {
+	var fields []string
+	var field strings.Builder
+	bracketed := false
+	for _, r := range spec {
+		switch {
+		case r == '[' && !bracketed:
+			bracketed = true
+		case r == ']' && bracketed:
+			bracketed = false
+		case r == ':' && !bracketed:
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(r)
+		}
+	}
+	return append(fields, field.String())
+}
+
+// listen accepts local connections on the forward's bind address and relays
+// each to its destination through client, until the listener is closed
+func (f localForward) listen(client forwardDialer) (net.Listener, error) {
+	listener, err := net.Listen("tcp", f.bind)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer local.Close()
+				remote, err := client.Dial("tcp", f.dest)
+				if err != nil {
+					log.Warn("Forward to ", f.dest, " failed: ", err)
+					return
+				}
+				defer remote.Close()
+				relay(local, remote)
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// relay copies between two connections until one side is done
+func relay(a, b net.Conn) {
+	go io.Copy(a, b)
+	io.Copy(b, a)
+}
+
+// forwardDialTimeout bounds connecting to the destination of a remote forward
+const forwardDialTimeout = 10 * time.Second
+
+// remoteForward is a server port forwarded back to this machine (ssh -R)
+type remoteForward struct {
+	bind string
+	dest string
+}
+
+// parseRemoteForward parses [bind_address:]port:host:hostport like
+// parseLocalForward; the bind address is on the server, where most servers
+// only allow loopback unless configured otherwise
+func parseRemoteForward(spec string) (remoteForward, error) {
+	fwd, err := parseLocalForward(spec)
+	return remoteForward(fwd), err
+}
+
+// listen asks the server to listen on the forward's bind address and relays
+// each connection it accepts to the destination, dialed from this machine,
+// until the listener or the connection is closed
+func (f remoteForward) listen(client *ssh.Client) (net.Listener, error) {
+	listener, err := client.Listen("tcp", f.bind)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			remote, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer remote.Close()
+				local, err := net.DialTimeout("tcp", f.dest, forwardDialTimeout)
+				if err != nil {
+					log.Warn("Forward to ", f.dest, " failed: ", err)
+					return
+				}
+				defer local.Close()
+				relay(remote, local)
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// dynamicForward is a local SOCKS5 proxy whose connections are made from
+// the server (ssh -D)
+type dynamicForward struct {
+	bind string
+}
+
+// parseDynamicForward parses [bind_address:]port, binding to localhost
+// unless an address is given
+func parseDynamicForward(spec string) (dynamicForward, error) {
+	fields := splitForwardSpec(spec)
+	var bindHost, bindPort string
+	switch len(fields) {
+	case 1:
+		bindHost, bindPort = "localhost", fields[0]
+	case 2:
+		bindHost, bindPort = fields[0], fields[1]
+	default:
+		return dynamicForward{}, fmt.Errorf("invalid dynamic forward %q, want [bind_address:]port", spec)
+	}
+	if _, err := strconv.ParseUint(bindPort, 10, 16); err != nil {
+		return dynamicForward{}, fmt.Errorf("invalid dynamic forward %q, want [bind_address:]port", spec)
+	}
+	return dynamicForward{bind: net.JoinHostPort(bindHost, bindPort)}, nil
+}
+
+// listen serves SOCKS5 on the forward's bind address, connecting each
+// request through client, until the listener is closed
+func (f dynamicForward) listen(client forwardDialer) (net.Listener, error) {
+	listener, err := net.Listen("tcp", f.bind)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		for {
+			local, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer local.Close()
+				local.SetDeadline(time.Now().Add(proxyHandshakeTimeout))
+				dest, err := socks5Accept(local)
+				if err != nil {
+					log.Debug("SOCKS request refused: ", err)
+					return
+				}
+				remote, err := client.Dial("tcp", dest)
+				if err != nil {
+					log.Warn("Forward to ", dest, " failed: ", err)
+					socks5Reply(local, socks5HostUnreachable)
+					return
+				}
+				defer remote.Close()
+				if err := socks5Reply(local, socks5Succeeded); err != nil {
+					return
+				}
+				local.SetDeadline(time.Time{})
+				relay(local, remote)
+			}()
+		}
+	}()
+	return listener, nil
+}
+
+// socks5Accept reads a SOCKS5 client's greeting and CONNECT request on
+// conn, accepting it without authentication, and returns the requested
+// host:port. Other commands are refused.
+func socks5Accept(conn net.Conn) (string, error) {
+	var greeting [2]byte
+	if _, err := io.ReadFull(conn, greeting[:]); err != nil {
+		return "", err
+	}
+	if greeting[0] != socks5Version {
+		return "", fmt.Errorf("not a SOCKS5 client (version %d)", greeting[0])
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	if !slices.Contains(methods, socks5NoAuth) {
+		conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		return "", errors.New("the client doesn't offer connecting without authentication")
+	}
+	if _, err := conn.Write([]byte{socks5Version, socks5NoAuth}); err != nil {
+		return "", err
+	}
+
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return "", err
+	}
+	if req[0] != socks5Version {
+		return "", fmt.Errorf("invalid SOCKS5 request version %d", req[0])
+	}
+	if req[1] != socks5CmdConnect {
+		socks5Reply(conn, socks5CommandNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS5 command %d", req[1])
+	}
+	var host string
+	switch req[3] {
+	case socks5IPv4, socks5IPv6:
+		ip := make(net.IP, net.IPv4len)
+		if req[3] == socks5IPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socks5DomainName:
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		socks5Reply(conn, socks5AddressNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS5 address type %d", req[3])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// socks5Reply answers a SOCKS5 request with code. The bound address is left
+// empty, as the connection is made from the server.
+func socks5Reply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socks5Version, code, 0, socks5IPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
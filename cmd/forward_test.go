@@ -0,0 +1,161 @@
+// cmd/forward_test.go
+package cmd
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+)
+
+func TestParseLocalForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    localForward
+		wantErr bool
+	}{
+		{"5432:db:5432", localForward{"localhost:5432", "db:5432"}, false},
+		{"0.0.0.0:8080:web:80", localForward{"0.0.0.0:8080", "web:80"}, false},
+		{"[::1]:8080:[2001:db8::1]:80", localForward{"[::1]:8080", "[2001:db8::1]:80"}, false},
+		{"5432", localForward{}, true},
+		{"5432:db:", localForward{}, true},
+		{"a:b:c:d:e", localForward{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseLocalForward(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseLocalForward(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+// startEcho starts a TCP server that echoes what it receives and returns
+// its address
+func startEcho(t *testing.T) string {
+	t.Helper()
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { echo.Close() })
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return echo.Addr().String()
+}
+
+// checkEcho sends ping on conn and expects it back
+func checkEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("forwarded echo = %q, %v, want ping", buf, err)
+	}
+}
+
+func TestLocalForwardListen(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{AllowLocalForwarding: true})
+	client := srv.Dial(t)
+
+	listener, err := localForward{"127.0.0.1:0", startEcho(t)}.listen(client)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	checkEcho(t, conn)
+}
+
+func TestParseDynamicForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    dynamicForward
+		wantErr bool
+	}{
+		{"1080", dynamicForward{"localhost:1080"}, false},
+		{"0.0.0.0:1080", dynamicForward{"0.0.0.0:1080"}, false},
+		{"[::1]:1080", dynamicForward{"[::1]:1080"}, false},
+		{"socks", dynamicForward{}, true},
+		{"a:b:1080", dynamicForward{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDynamicForward(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDynamicForward(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestRemoteForwardListen(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{AllowRemoteForwarding: true})
+	client := srv.Dial(t)
+
+	fwd, err := parseRemoteForward("127.0.0.1:0:" + startEcho(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := fwd.listen(client)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	// The test server listens on this machine
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	checkEcho(t, conn)
+}
+
+func TestDynamicForwardListen(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{AllowLocalForwarding: true})
+	client := srv.Dial(t)
+
+	listener, err := dynamicForward{"127.0.0.1:0"}.listen(client)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	_, echoPort, _ := net.SplitHostPort(startEcho(t))
+	for _, dest := range []string{"127.0.0.1:" + echoPort, "localhost:" + echoPort} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		if err := socks5Connect(conn, dest, nil); err != nil {
+			t.Fatalf("SOCKS5 connect to %s failed: %v", dest, err)
+		}
+		checkEcho(t, conn)
+	}
+
+	// Unreachable destinations are reported to the SOCKS client
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if err := socks5Connect(conn, "127.0.0.1:1", nil); err == nil {
+		t.Error("SOCKS5 connect to a closed port should fail")
+	}
+}
@@ -0,0 +1,538 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	inventoryFile   string
+	inventoryGroups []string
+)
+
+// allGroup is the implicit group of every inventory host
+const allGroup = "all"
+
+// hostVarPattern matches the {name} placeholders expandHostVars replaces
+var hostVarPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// inventory is a file of hosts in groups, with variables, that fleet
+// commands select hosts from. The variables address, user, port and key
+// (comma-separated paths) set how a host is connected to; host is its name.
+type inventory struct {
+	// hosts are the host names in the order they first appear
+	hosts    []string
+	hostVars map[string]map[string]string
+	groups   map[string]*inventoryGroup
+	// groupOrder is the order groups first appear in
+	groupOrder []string
+
+	// depths count the parent groups above each group
+	depths  map[string]int
+	members map[string]map[string]bool
+}
+
+// inventoryGroup is a group of hosts, and of the hosts of its children
+type inventoryGroup struct {
+	hosts    []string
+	children []string
+	vars     map[string]string
+}
+
+func newInventory() *inventory {
+	return &inventory{
+		hostVars: map[string]map[string]string{},
+		groups:   map[string]*inventoryGroup{},
+		depths:   map[string]int{},
+		members:  map[string]map[string]bool{},
+	}
+}
+
+// group returns the named group, adding it when it is new
+func (inv *inventory) group(name string) *inventoryGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &inventoryGroup{vars: map[string]string{}}
+		inv.groups[name] = g
+		inv.groupOrder = append(inv.groupOrder, name)
+	}
+	return g
+}
+
+// addHost adds a host to group, or to no group but all when group is empty,
+// with variables of its own
+func (inv *inventory) addHost(group, name string, vars map[string]string) {
+	if _, ok := inv.hostVars[name]; !ok {
+		inv.hosts = append(inv.hosts, name)
+		inv.hostVars[name] = map[string]string{}
+	}
+	maps.Copy(inv.hostVars[name], vars)
+	if group != "" {
+		g := inv.group(group)
+		if !slices.Contains(g.hosts, name) {
+			g.hosts = append(g.hosts, name)
+		}
+	}
+}
+
+// check rejects unknown children, groups that contain themselves and the
+// reserved host variable, and works out the depth of every group
+func (inv *inventory) check() error {
+	parents := map[string][]string{}
+	for _, name := range inv.groupOrder {
+		if _, ok := inv.groups[name].vars["host"]; ok {
+			return fmt.Errorf("group %s: host is the host's name and can't be set (use address)", name)
+		}
+		for _, child := range inv.groups[name].children {
+			if _, ok := inv.groups[child]; !ok || child == allGroup {
+				return fmt.Errorf("group %s: no group named %s", name, child)
+			}
+			if name != allGroup {
+				parents[child] = append(parents[child], name)
+			}
+		}
+	}
+	for _, name := range inv.hosts {
+		if _, ok := inv.hostVars[name]["host"]; ok {
+			return fmt.Errorf("host %s: host is the host's name and can't be set (use address)", name)
+		}
+	}
+
+	var depth func(name string, path []string) (int, error)
+	depth = func(name string, path []string) (int, error) {
+		if slices.Contains(path, name) {
+			return 0, fmt.Errorf("group %s contains itself through %s", name, strings.Join(append(path, name), " > "))
+		}
+		if d, ok := inv.depths[name]; ok {
+			return d, nil
+		}
+		d := 0
+		for _, parent := range parents[name] {
+			pd, err := depth(parent, append(path, name))
+			if err != nil {
+				return 0, err
+			}
+			d = max(d, pd+1)
+		}
+		inv.depths[name] = d
+		return d, nil
+	}
+	for _, name := range inv.groupOrder {
+		if _, err := depth(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupMembers returns the hosts of a group and of its children
+func (inv *inventory) groupMembers(name string) map[string]bool {
+	if members, ok := inv.members[name]; ok {
+		return members
+	}
+	members := map[string]bool{}
+	if name == allGroup {
+		for _, h := range inv.hosts {
+			members[h] = true
+		}
+	} else if g, ok := inv.groups[name]; ok {
+		for _, h := range g.hosts {
+			members[h] = true
+		}
+		for _, child := range g.children {
+			maps.Copy(members, inv.groupMembers(child))
+		}
+	}
+	inv.members[name] = members
+	return members
+}
+
+// hostGroups returns the groups a host is a member of, all excluded, in
+// the order they appear
+func (inv *inventory) hostGroups(name string) []string {
+	var groups []string
+	for _, g := range inv.groupOrder {
+		if g != allGroup && inv.groupMembers(g)[name] {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// vars returns a host's variables: those of all, then of its groups, parent
+// groups before their children and otherwise in file order, then its own.
+// Later ones win.
+func (inv *inventory) vars(name string) map[string]string {
+	groups := inv.hostGroups(name)
+	slices.SortStableFunc(groups, func(a, b string) int { return inv.depths[a] - inv.depths[b] })
+	vars := map[string]string{}
+	if all, ok := inv.groups[allGroup]; ok {
+		maps.Copy(vars, all.vars)
+	}
+	for _, g := range groups {
+		maps.Copy(vars, inv.groups[g].vars)
+	}
+	maps.Copy(vars, inv.hostVars[name])
+	vars["host"] = name
+	return vars
+}
+
+// fleetHost returns the named host, connected to as its variables say
+func (inv *inventory) fleetHost(name string) fleetHost {
+	vars := inv.vars(name)
+	h := fleetHost{name: name, user: vars["user"], host: vars["address"], port: vars["port"], vars: vars}
+	if h.host == "" {
+		h.host = name
+	}
+	for _, key := range strings.Split(vars["key"], ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			h.keys = append(h.keys, expandHome(key))
+		}
+	}
+	return h
+}
+
+// fleetHosts returns the hosts of groups, in inventory order
+func (inv *inventory) fleetHosts(groups []string) ([]fleetHost, error) {
+	selected := map[string]bool{}
+	for _, g := range groups {
+		if _, ok := inv.groups[g]; !ok && g != allGroup {
+			return nil, fmt.Errorf("no group named %q in the inventory", g)
+		}
+		maps.Copy(selected, inv.groupMembers(g))
+	}
+	var hosts []fleetHost
+	for _, name := range inv.hosts {
+		if selected[name] {
+			hosts = append(hosts, inv.fleetHost(name))
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("no hosts in %s", strings.Join(groups, ", "))
+	}
+	return hosts, nil
+}
+
+// parseInventoryINI reads an inventory of [group] sections listing a host
+// per line, followed by its name=value variables, [group:vars] sections of
+// name=value lines and [group:children] sections of group names. Hosts
+// before the first section are in no group. Lines starting with # or ; are
+// comments, as is the rest of a host line from a # on.
+func parseInventoryINI(r io.Reader) (*inventory, error) {
+	inv := newInventory()
+	group, kind := "", ""
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' || text[0] == ';' {
+			continue
+		}
+		if text[0] == '[' {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("inventory line %d: unterminated section %s", line, text)
+			}
+			group, kind, _ = strings.Cut(text[1:len(text)-1], ":")
+			if group == "" || (kind != "" && kind != "vars" && kind != "children") {
+				return nil, fmt.Errorf("inventory line %d: invalid section %s", line, text)
+			}
+			inv.group(group)
+			continue
+		}
+
+		fields, err := splitArgs(text)
+		if err != nil {
+			return nil, fmt.Errorf("inventory line %d: %s", line, err)
+		}
+		if i := slices.IndexFunc(fields, func(f string) bool { return strings.HasPrefix(f, "#") }); i >= 0 {
+			fields = fields[:i]
+		}
+		switch kind {
+		case "vars":
+			vars, err := parseInventoryVars(fields)
+			if err != nil {
+				return nil, fmt.Errorf("inventory line %d: %s", line, err)
+			}
+			maps.Copy(inv.groups[group].vars, vars)
+		case "children":
+			if len(fields) != 1 {
+				return nil, fmt.Errorf("inventory line %d: expected a group name", line)
+			}
+			inv.groups[group].children = append(inv.groups[group].children, fields[0])
+		default:
+			if len(fields) == 0 || strings.Contains(fields[0], "=") {
+				return nil, fmt.Errorf("inventory line %d: expected a host name", line)
+			}
+			vars, err := parseInventoryVars(fields[1:])
+			if err != nil {
+				return nil, fmt.Errorf("inventory line %d: %s", line, err)
+			}
+			inv.addHost(group, fields[0], vars)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return inv, inv.check()
+}
+
+// parseInventoryVars parses name=value fields
+func parseInventoryVars(fields []string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, field := range fields {
+		name, value, ok := strings.Cut(field, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid variable %q: use name=value", field)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// parseInventoryYAML reads an inventory mapping group names to their hosts,
+// vars and children. hosts is a list of names, or maps names to their own
+// variables.
+func parseInventoryYAML(data []byte) (*inventory, error) {
+	inv := newInventory()
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return inv, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("the inventory must map group names to groups")
+	}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name := root.Content[i].Value
+		var g struct {
+			Hosts    yaml.Node         `yaml:"hosts"`
+			Vars     map[string]string `yaml:"vars"`
+			Children []string          `yaml:"children"`
+		}
+		if err := root.Content[i+1].Decode(&g); err != nil {
+			return nil, fmt.Errorf("group %s: %s", name, err)
+		}
+		group := inv.group(name)
+		maps.Copy(group.vars, g.Vars)
+		group.children = append(group.children, g.Children...)
+		switch g.Hosts.Kind {
+		case 0:
+		case yaml.SequenceNode:
+			for _, h := range g.Hosts.Content {
+				if h.Kind != yaml.ScalarNode || h.Value == "" {
+					return nil, fmt.Errorf("group %s: line %d: expected a host name", name, h.Line)
+				}
+				inv.addHost(name, h.Value, nil)
+			}
+		case yaml.MappingNode:
+			for j := 0; j+1 < len(g.Hosts.Content); j += 2 {
+				var vars map[string]string
+				if err := g.Hosts.Content[j+1].Decode(&vars); err != nil {
+					return nil, fmt.Errorf("host %s: %s", g.Hosts.Content[j].Value, err)
+				}
+				inv.addHost(name, g.Hosts.Content[j].Value, vars)
+			}
+		default:
+			return nil, fmt.Errorf("group %s: hosts must be a list or a map", name)
+		}
+	}
+	return inv, inv.check()
+}
+
+// loadInventory reads the inventory at path, as YAML when it ends in .yaml
+// or .yml and as INI otherwise
+func loadInventory(path string) (*inventory, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory: %s", err)
+	}
+	var inv *inventory
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		inv, err = parseInventoryYAML(data)
+	default:
+		inv, err = parseInventoryINI(strings.NewReader(string(data)))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return inv, nil
+}
+
+// defaultInventoryFile returns --inventory, or ~/.gossh/inventory.yaml
+func defaultInventoryFile() (string, error) {
+	if inventoryFile != "" {
+		return inventoryFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".gossh", "inventory.yaml"), nil
+}
+
+// addHostSelectionFlags registers --hosts, --inventory and --group, which
+// selectFleetHosts reads
+func addHostSelectionFlags(flags *pflag.FlagSet) {
+	flags.StringVar(&hostsFile, "hosts", "", "File of hosts, one [user@]host[:port] per line; # starts a comment (- reads stdin)")
+	flags.StringVar(&inventoryFile, "inventory", "", "Inventory file, YAML (.yaml, .yml) or INI (default: ~/.gossh/inventory.yaml)")
+	flags.StringSliceVarP(&inventoryGroups, "group", "g", nil, "Inventory group to select hosts from, all for every host (repeatable)")
+}
+
+// selectFleetHosts returns the hosts of --hosts, or of the --group groups
+// of the inventory
+func selectFleetHosts() ([]fleetHost, error) {
+	switch {
+	case hostsFile != "" && len(inventoryGroups) > 0:
+		return nil, errors.New("--hosts and --group can't be combined")
+	case hostsFile != "":
+		in := os.Stdin
+		if hostsFile != "-" {
+			file, err := os.Open(hostsFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open hosts file: %s", err)
+			}
+			defer file.Close()
+			in = file
+		}
+		return parseHostsFile(in)
+	case len(inventoryGroups) > 0:
+		path, err := defaultInventoryFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to locate the inventory: %s", err)
+		}
+		inv, err := loadInventory(path)
+		if err != nil {
+			return nil, err
+		}
+		return inv.fleetHosts(inventoryGroups)
+	}
+	return nil, errors.New("--hosts or --group is required")
+}
+
+// expandHostVars replaces {name} in s with the host's variable of that
+// name. Braces around other text are kept, so shell syntax passes through.
+func expandHostVars(s string, vars map[string]string) string {
+	return hostVarPattern.ReplaceAllStringFunc(s, func(match string) string {
+		if value, ok := vars[match[1:len(match)-1]]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// inventoryCmd represents the inventory command
+var inventoryCmd = &cobra.Command{
+	Use:   "inventory",
+	Short: "List the hosts of the inventory",
+	Long: `List the hosts of an inventory, or of some of its groups, with the groups and
+variables each ends up with. Inventories group hosts for gossh exec --group
+and set how each is connected to, in YAML:
+
+  all:
+    vars:
+      user: deploy
+  web:
+    hosts:
+      web1:
+        address: 10.0.0.11
+      web2:
+        address: 10.0.0.12
+        port: 2222
+    vars:
+      role: frontend
+  prod:
+    children: [web]
+
+or in INI, with the same meaning:
+
+  [all:vars]
+  user=deploy
+
+  [web]
+  web1 address=10.0.0.11
+  web2 address=10.0.0.12 port=2222
+
+  [web:vars]
+  role=frontend
+
+  [prod:children]
+  web
+
+The variables address, user, port and key (comma-separated private keys) set
+the connection; the rest are for {name} placeholders in commands, as is host,
+the host's name. A host's own variables win over its groups', and a group's
+over its parents'.
+
+Examples:
+  # List every host
+  gossh inventory
+
+  # List the web servers of another inventory
+  gossh inventory --inventory ./hosts.ini --group web`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := defaultInventoryFile()
+		if err != nil {
+			fmt.Printf("Failed to locate the inventory: %s\n", err)
+			os.Exit(1)
+		}
+		inv, err := loadInventory(path)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		groups := inventoryGroups
+		if len(groups) == 0 {
+			groups = []string{allGroup}
+		}
+		hosts, err := inv.fleetHosts(groups)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		printInventory(os.Stdout, inv, hosts)
+	},
+}
+
+// printInventory writes a table of hosts, their connection settings, groups
+// and other variables
+func printInventory(out io.Writer, inv *inventory, hosts []fleetHost) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tADDRESS\tUSER\tPORT\tGROUPS\tVARS")
+	for _, h := range hosts {
+		var vars []string
+		for _, name := range slices.Sorted(maps.Keys(h.vars)) {
+			switch name {
+			case "host", "address", "user", "port":
+			default:
+				vars = append(vars, name+"="+h.vars[name])
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", h.name, h.host, orDash(h.user), orDash(h.port),
+			orDash(strings.Join(inv.hostGroups(h.name), ",")), orDash(strings.Join(vars, " ")))
+	}
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(inventoryCmd)
+
+	inventoryCmd.Flags().StringVar(&inventoryFile, "inventory", "", "Inventory file, YAML (.yaml, .yml) or INI (default: ~/.gossh/inventory.yaml)")
+	inventoryCmd.Flags().StringSliceVarP(&inventoryGroups, "group", "g", nil, "Group to list, all for every host (repeatable)")
+}
@@ -0,0 +1,198 @@
+// cmd/inventory_test.go
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testInventoryYAML = `
+all:
+  vars:
+    user: deploy
+    env: prod
+web:
+  hosts:
+    web1:
+      address: 10.0.0.11
+    web2:
+      address: 10.0.0.12
+      port: 2222
+      user: admin
+  vars:
+    role: frontend
+    env: staging
+canary:
+  hosts: [web2]
+  vars:
+    role: canary
+    canary: "yes"
+db:
+  hosts:
+    db1:
+      key: ~/.ssh/db, /keys/db2
+frontends:
+  children: [web]
+  vars:
+    role: generic
+    tier: 1
+`
+
+const testInventoryINI = `
+# the same inventory as testInventoryYAML
+[all:vars]
+user=deploy
+env=prod
+
+[web]
+web1 address=10.0.0.11
+web2 address=10.0.0.12 port=2222 user=admin  # the canary
+
+[web:vars]
+role=frontend
+env=staging
+
+[canary]
+web2
+
+[canary:vars]
+role=canary
+canary=yes
+
+[db]
+db1 key="~/.ssh/db, /keys/db2"
+
+[frontends:children]
+web
+
+[frontends:vars]
+role=generic
+tier=1
+`
+
+func TestParseInventory(t *testing.T) {
+	// web2's role is web's: web is a child of frontends, so it is more
+	// specific than canary
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	want := []fleetHost{
+		{name: "web1", host: "10.0.0.11", user: "deploy", vars: map[string]string{
+			"host": "web1", "address": "10.0.0.11", "user": "deploy", "env": "staging", "role": "frontend", "tier": "1"}},
+		{name: "web2", host: "10.0.0.12", user: "admin", port: "2222", vars: map[string]string{
+			"host": "web2", "address": "10.0.0.12", "user": "admin", "port": "2222", "env": "staging", "role": "frontend", "tier": "1", "canary": "yes"}},
+		{name: "db1", host: "db1", user: "deploy", keys: []string{filepath.Join(home, ".ssh/db"), "/keys/db2"}, vars: map[string]string{
+			"host": "db1", "user": "deploy", "env": "prod", "key": "~/.ssh/db, /keys/db2"}},
+	}
+
+	yamlInv, err := parseInventoryYAML([]byte(testInventoryYAML))
+	if err != nil {
+		t.Fatalf("parseInventoryYAML failed: %v", err)
+	}
+	iniInv, err := parseInventoryINI(strings.NewReader(testInventoryINI))
+	if err != nil {
+		t.Fatalf("parseInventoryINI failed: %v", err)
+	}
+	for format, inv := range map[string]*inventory{"YAML": yamlInv, "INI": iniInv} {
+		got, err := inv.fleetHosts([]string{allGroup})
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s hosts = %+v, %v\nwant %+v", format, got, err, want)
+		}
+		if got := inv.hostGroups("web2"); !reflect.DeepEqual(got, []string{"web", "canary", "frontends"}) {
+			t.Errorf("%s groups of web2 = %v", format, got)
+		}
+		for groups, wantNames := range map[string][]string{
+			"frontends":  {"web1", "web2"},
+			"db,canary":  {"web2", "db1"},
+			"canary,web": {"web1", "web2"},
+		} {
+			hosts, err := inv.fleetHosts(strings.Split(groups, ","))
+			var names []string
+			for _, h := range hosts {
+				names = append(names, h.name)
+			}
+			if err != nil || !reflect.DeepEqual(names, wantNames) {
+				t.Errorf("%s hosts of %s = %v, %v, want %v", format, groups, names, err, wantNames)
+			}
+		}
+		if _, err := inv.fleetHosts([]string{"missing"}); err == nil {
+			t.Errorf("%s: selecting a missing group should fail", format)
+		}
+	}
+
+	var out bytes.Buffer
+	hosts, _ := yamlInv.fleetHosts([]string{"canary"})
+	printInventory(&out, yamlInv, hosts)
+	if want := "web2  10.0.0.12  admin  2222  web,canary,frontends  canary=yes env=staging role=frontend tier=1\n"; !strings.HasSuffix(out.String(), want) {
+		t.Errorf("printInventory wrote\n%s\nwant a row %q", out.String(), want)
+	}
+}
+
+func TestParseInventoryErrors(t *testing.T) {
+	iniTests := []string{
+		"[web\nweb1\n",
+		"[web:hosts]\nweb1\n",
+		"[web]\nrole=x\n",
+		"[web]\nweb1 role\n",
+		"[web:children]\ndb\n",
+		"[web:children]\nweb\n",
+		"[a:children]\nb\n[b:children]\nc\n[c:children]\na\n",
+		"[web]\nweb1 host=10.0.0.1\n",
+		"[web]\nweb1 \"unterminated\n",
+	}
+	for _, input := range iniTests {
+		if _, err := parseInventoryINI(strings.NewReader(input)); err == nil {
+			t.Errorf("parseInventoryINI(%q) should fail", input)
+		}
+	}
+	yamlTests := []string{
+		"- web1\n",
+		"web:\n  hosts: web1\n",
+		"web:\n  hosts:\n    web1:\n      tags: [a, b]\n",
+		"web:\n  children: [web]\n",
+		"web:\n  vars:\n    host: 10.0.0.1\n",
+	}
+	for _, input := range yamlTests {
+		if _, err := parseInventoryYAML([]byte(input)); err == nil {
+			t.Errorf("parseInventoryYAML(%q) should fail", input)
+		}
+	}
+}
+
+func TestExpandHostVars(t *testing.T) {
+	vars := map[string]string{"host": "web1", "role": "frontend"}
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"deploy --role {role} on {host}", "deploy --role frontend on web1"},
+		{"awk '{print $1}' ${HOME} {missing}", "awk '{print $1}' ${HOME} {missing}"},
+		{"{{role}}", "{frontend}"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := expandHostVars(tt.s, vars); got != tt.want {
+			t.Errorf("expandHostVars(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestLoadInventory(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"inventory.yml": testInventoryYAML, "hosts": testInventoryINI} {
+		path := filepath.Join(dir, name)
+		writeFile(t, path, content, 0o644)
+		inv, err := loadInventory(path)
+		if err != nil || len(inv.hosts) != 3 {
+			t.Errorf("loadInventory(%s) = %v, %v", name, inv, err)
+		}
+	}
+	if _, err := loadInventory(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("loading a missing inventory should fail")
+	}
+}
@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/spf13/cobra"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	issuePrincipals []string
+	issueTTL        string
+	issueCAKey      string
+	issueCAURL      string
+	issueCAToken    string
+	issueKeyID      string
+	issueOutDir     string
+)
+
+// issueCmd represents the issue command
+var issueCmd = &cobra.Command{
+	Use:   "issue",
+	Short: "Issue a short-lived SSH certificate",
+	Long: `The issue command generates a throwaway key pair and has it signed by a gossh CA,
+so automation can authenticate without storing long-lived private keys.
+
+The CA is either a local private key (--ca-key, or $GOSSH_CA_KEY) or a remote
+signing endpoint (--ca-url, or $GOSSH_CA_URL), not both. Requests to the
+endpoint carry --ca-token, or $GOSSH_CA_TOKEN, as a bearer token.
+
+Examples:
+  # Issue a 15 minute certificate for the deploy principal using a local CA key
+  gossh issue --principal deploy --ttl 15m --ca-key ca.pem
+
+  # Ask a remote signing endpoint instead, authenticating with a CI secret
+  GOSSH_CA_TOKEN=$CI_CA_TOKEN gossh issue --principal deploy --ttl 15m --ca-url https://ca.internal/sign`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ttl, err := time.ParseDuration(issueTTL)
+		if err != nil {
+			fmt.Printf("Invalid TTL: %s\n", err)
+			os.Exit(1)
+		}
+		if err := validatePrincipals(issuePrincipals); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		caKey, caURL, err := resolveCASource(issueCAKey, issueCAURL)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if caKey == "" && caURL == "" {
+			fmt.Println("No CA configured: use --ca-key, --ca-url, $GOSSH_CA_KEY or $GOSSH_CA_URL")
+			os.Exit(1)
+		}
+		if caKey != "" && issueCAToken != "" {
+			fmt.Println("--ca-token only applies to a remote signing endpoint (--ca-url)")
+			os.Exit(1)
+		}
+		var endpoint *ssh.SigningEndpoint
+		if caURL != "" {
+			endpoint = &ssh.SigningEndpoint{URL: caURL, Token: issueCAToken}
+			if endpoint.Token == "" {
+				endpoint.Token = os.Getenv("GOSSH_CA_TOKEN")
+			}
+		}
+
+		keyID := issueKeyID
+		if keyID == "" {
+			keyID = fmt.Sprintf("%s-%d", issuePrincipals[0], time.Now().Unix())
+		}
+
+		outDir := issueOutDir
+		if outDir == "" {
+			outDir, err = os.MkdirTemp("", "gossh-issue-")
+			if err != nil {
+				fmt.Printf("Error creating output directory: %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		fmt.Println("Generating ephemeral key pair...")
+		req := ssh.CertificateRequest{
+			KeyID:      keyID,
+			Principals: issuePrincipals,
+			TTL:        ttl,
+		}
+		keyPath, cert, err := issueCertificate(caKey, endpoint, req, outDir)
+		if err != nil {
+			fmt.Printf("Error issuing certificate: %s\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Println("Short-lived certificate issued successfully:")
+		fmt.Printf("Private key: %s\n", keyPath)
+		fmt.Printf("Certificate: %s\n", keyPath+certSuffix)
+		fmt.Printf("Key ID: %s\n", cert.KeyId)
+		fmt.Printf("Valid until: %s\n", time.Unix(int64(cert.ValidBefore), 0).Format(time.RFC3339))
+	},
+}
+
+// resolveCASource picks the CA from flags, falling back to the environment.
+// A local key and a signing endpoint can't both be given.
+func resolveCASource(keyFlag, urlFlag string) (string, string, error) {
+	key, url := keyFlag, urlFlag
+	if key == "" && url == "" {
+		key, url = os.Getenv("GOSSH_CA_KEY"), os.Getenv("GOSSH_CA_URL")
+	}
+	if key != "" && url != "" {
+		return "", "", errors.New("both a CA key and a CA URL are configured: use --ca-key or --ca-url, not both")
+	}
+	return key, url, nil
+}
+
+// issueCertificate generates a throwaway key pair and has the local CA key
+// at caKey, or else endpoint, certify it as req says. The private key and
+// its certificate are written to outDir, and the path of the key returned.
+func issueCertificate(caKey string, endpoint *ssh.SigningEndpoint, req ssh.CertificateRequest, outDir string) (string, *cryptossh.Certificate, error) {
+	privateKey, publicKey, err := ssh.GenerateKeys(ssh.KeyOptions{})
+	if err != nil {
+		return "", nil, fmt.Errorf("generate key: %s", err)
+	}
+	if req.PublicKey, _, _, _, err = cryptossh.ParseAuthorizedKey(publicKey); err != nil {
+		return "", nil, fmt.Errorf("parse generated public key: %s", err)
+	}
+
+	var cert *cryptossh.Certificate
+	if caKey != "" {
+		log.Debug("Signing with local CA key: ", caKey)
+		cert, err = signWithLocalCA(caKey, req)
+	} else {
+		log.Debug("Requesting certificate from: ", endpoint.URL)
+		cert, err = endpoint.RequestUserCertificate(context.Background(), req)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	keyPath := filepath.Join(outDir, "id_"+ssh.DefaultKeyType)
+	if err := os.WriteFile(keyPath, privateKey, 0o600); err != nil {
+		return "", nil, fmt.Errorf("write private key: %s", err)
+	}
+	if err := os.WriteFile(keyPath+certSuffix, cryptossh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		return "", nil, fmt.Errorf("write certificate: %s", err)
+	}
+	return keyPath, cert, nil
+}
+
+// signWithLocalCA loads a CA private key from disk, asking for its
+// passphrase when encrypted, and signs the request with it
+func signWithLocalCA(caKeyPath string, req ssh.CertificateRequest) (*cryptossh.Certificate, error) {
+	ca, err := readPrivateKeyFile(caKeyPath, promptKeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("load CA key: %s", err)
+	}
+	return ssh.SignUserCertificate(ca.signer, req)
+}
+
+// validatePrincipals checks the --principal values of a certificate. An
+// empty --principal "" leaves none, and the first one names the key ID.
+func validatePrincipals(principals []string) error {
+	if len(principals) == 0 {
+		return errors.New("at least one --principal is required")
+	}
+	for _, principal := range principals {
+		if principal == "" {
+			return errors.New("--principal must not be empty")
+		}
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(issueCmd)
+
+	// Define flags for the issue command
+	issueCmd.Flags().StringSliceVar(&issuePrincipals, "principal", nil, "Principal (remote username) the certificate is valid for (repeatable)")
+	issueCmd.Flags().StringVar(&issueTTL, "ttl", "15m", "Certificate lifetime")
+	issueCmd.Flags().StringVar(&issueCAKey, "ca-key", "", "Path to the CA private key used for local signing")
+	issueCmd.Flags().StringVar(&issueCAURL, "ca-url", "", "URL of a remote signing endpoint")
+	issueCmd.Flags().StringVar(&issueCAToken, "ca-token", "", "Bearer token for the signing endpoint (default: $GOSSH_CA_TOKEN)")
+	issueCmd.Flags().StringVar(&issueKeyID, "key-id", "", "Key ID recorded in the certificate (defaults to <principal>-<timestamp>)")
+	issueCmd.Flags().StringVarP(&issueOutDir, "out-dir", "o", "", "Directory for the key and certificate (defaults to a new temp directory)")
+
+	// Mark required flags
+	issueCmd.MarkFlagRequired("principal")
+}
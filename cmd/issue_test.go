@@ -0,0 +1,139 @@
+// cmd/issue_test.go
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// TestResolveCASource tests flag and environment precedence for the CA source
+func TestResolveCASource(t *testing.T) {
+	t.Setenv("GOSSH_CA_KEY", "/etc/gossh/ca.pem")
+	t.Setenv("GOSSH_CA_URL", "")
+
+	tests := []struct {
+		name    string
+		keyFlag string
+		urlFlag string
+		wantKey string
+		wantURL string
+		wantErr bool
+	}{
+		{"environment fallback", "", "", "/etc/gossh/ca.pem", "", false},
+		{"key flag wins", "ca.pem", "", "ca.pem", "", false},
+		{"url flag wins", "", "https://ca.example.com/sign", "", "https://ca.example.com/sign", false},
+		{"key and url flags", "ca.pem", "https://ca.example.com/sign", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, url, err := resolveCASource(tt.keyFlag, tt.urlFlag)
+			if key != tt.wantKey || url != tt.wantURL || (err != nil) != tt.wantErr {
+				t.Errorf("resolveCASource() = (%q, %q, %v), want (%q, %q), error: %v", key, url, err, tt.wantKey, tt.wantURL, tt.wantErr)
+			}
+		})
+	}
+
+	t.Setenv("GOSSH_CA_URL", "https://ca.example.com/sign")
+	if _, _, err := resolveCASource("", ""); err == nil {
+		t.Error("resolveCASource() with $GOSSH_CA_KEY and $GOSSH_CA_URL both set should fail")
+	}
+}
+
+func TestValidatePrincipals(t *testing.T) {
+	tests := []struct {
+		principals []string
+		wantErr    bool
+	}{
+		{[]string{"deploy"}, false},
+		{[]string{"deploy", "root"}, false},
+		{nil, true},
+		{[]string{}, true},
+		{[]string{""}, true},
+		{[]string{"", "deploy"}, true},
+	}
+	for _, tt := range tests {
+		if err := validatePrincipals(tt.principals); (err != nil) != tt.wantErr {
+			t.Errorf("validatePrincipals(%q) error = %v, wantErr %v", tt.principals, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIssueCertificateFromEndpoint(t *testing.T) {
+	ca := newTestSigner(t)
+
+	// A signing endpoint that only serves requests with the right token
+	var principals []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer ci-secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			PublicKey  string   `json:"public_key"`
+			KeyID      string   `json:"key_id"`
+			Principals []string `json:"principals"`
+			TTL        string   `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pub, _, _, _, err := cryptossh.ParseAuthorizedKey([]byte(req.PublicKey))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		principals = req.Principals
+		ttl, _ := time.ParseDuration(req.TTL)
+		cert, err := ssh.SignUserCertificate(ca, ssh.CertificateRequest{PublicKey: pub, KeyID: req.KeyID, Principals: req.Principals, TTL: ttl})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"certificate": string(cryptossh.MarshalAuthorizedKey(cert))})
+	}))
+	defer srv.Close()
+
+	req := ssh.CertificateRequest{KeyID: "ci-job-7", Principals: []string{"deploy"}, TTL: 15 * time.Minute}
+	dir := t.TempDir()
+	keyPath, cert, err := issueCertificate("", &ssh.SigningEndpoint{URL: srv.URL, Token: "ci-secret"}, req, dir)
+	if err != nil {
+		t.Fatalf("issueCertificate() failed: %v", err)
+	}
+	if keyPath != filepath.Join(dir, "id_"+ssh.DefaultKeyType) || cert.KeyId != "ci-job-7" || len(principals) != 1 || principals[0] != "deploy" {
+		t.Errorf("issueCertificate() = %s, certificate %q for %v", keyPath, cert.KeyId, principals)
+	}
+
+	// The certificate written next to the key certifies it
+	key, err := readPrivateKeyFile(keyPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := readUserCertificate(keyPath + certSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written.Key.Marshal()) != string(key.signer.PublicKey().Marshal()) {
+		t.Error("the written certificate does not certify the written key")
+	}
+
+	// Refusals surface with the endpoint's status and leave nothing behind
+	failDir := t.TempDir()
+	_, _, err = issueCertificate("", &ssh.SigningEndpoint{URL: srv.URL, Token: "wrong"}, req, failDir)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("issueCertificate() with a wrong token = %v, want a 401 error", err)
+	}
+	if entries, _ := os.ReadDir(failDir); len(entries) != 0 {
+		t.Errorf("a refused request left %d files behind", len(entries))
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// serverAliveRequest is the global request OpenSSH clients probe servers
+// with. Servers answer it, usually with a failure, without acting on it.
+const serverAliveRequest = "keepalive@openssh.com"
+
+// exitServerUnresponsive is the exit status when the server stops answering
+// keepalives, the status OpenSSH uses for connection failures
+const exitServerUnresponsive = 255
+
+// serverAlive probes the server every interval and returns an error once
+// countMax intervals pass without an answer. It returns nil when done is
+// closed.
+func serverAlive(conn ssh.Conn, interval time.Duration, countMax int, done <-chan struct{}) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	answered := make(chan struct{}, 1)
+	pending, missed := false, 0
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-answered:
+			pending, missed = false, 0
+			continue
+		case <-ticker.C:
+		}
+
+		// Only one request is outstanding at a time; every interval it
+		// stays unanswered counts as a missed response
+		if pending {
+			if missed++; missed >= countMax {
+				return fmt.Errorf("server did not answer %d keepalives", missed)
+			}
+			continue
+		}
+		pending = true
+		go func() {
+			if _, _, err := conn.SendRequest(serverAliveRequest, true, nil); err == nil {
+				answered <- struct{}{}
+			}
+		}()
+	}
+}
@@ -0,0 +1,42 @@
+// cmd/keepalive_test.go
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// silentConn is a connection whose server never answers requests
+type silentConn struct {
+	cryptossh.Conn
+}
+
+func (silentConn) SendRequest(string, bool, []byte) (bool, []byte, error) {
+	select {}
+}
+
+func TestServerAlive(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{})
+	client := srv.Dial(t)
+
+	done := make(chan struct{})
+	result := make(chan error, 1)
+	go func() { result <- serverAlive(client, 10*time.Millisecond, 2, done) }()
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+	if err := <-result; err != nil {
+		t.Errorf("serverAlive() with a responsive server = %v", err)
+	}
+
+	start := time.Now()
+	if err := serverAlive(silentConn{}, 10*time.Millisecond, 3, nil); err == nil {
+		t.Error("serverAlive() with a silent server should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("serverAlive() took %s to give up", elapsed)
+	}
+}
@@ -3,9 +3,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/bxtal-lsn/gossh/pkg/ssh"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var (
@@ -13,6 +15,11 @@ var (
 	publicKeyOut  string
 	keyBits       int
 	keyComment    string
+	keyType       string
+	keyCurve      string
+	keyPassphrase string
+	keyRounds     int
+	keyFormat     string
 )
 
 // keygenCmd represents the keygen command
@@ -21,20 +28,76 @@ var keygenCmd = &cobra.Command{
 	Short: "Generate SSH key pairs",
 	Long: `The keygen utility creates and manages SSH key pairs.
 
+New keys are Ed25519 unless --type asks for ECDSA, on the NIST curve of
+--curve (P-256 by default), or RSA of --bits (4096 by default). The private
+key is written to id_<type> unless --private-key names another file, and the
+public key next to it with a .pub suffix unless --public-key names another
+file. The public key ends with --comment, user@hostname unless given, to tell
+it apart in authorized_keys files.
+
+On a terminal, keygen asks for a passphrase to encrypt the private key with;
+--passphrase gives it instead, and --passphrase "" leaves the key unencrypted.
+Encrypted keys are in OpenSSH format, with the encryption key derived from the
+passphrase in --rounds rounds of bcrypt (16 by default): more rounds slow down
+guessing the passphrase, and loading the key, alike.
+
+Private keys are written in OpenSSH format unless --format asks for pem, the
+legacy PKCS#1 (RSA) or SEC 1 (ECDSA) encoding, or pkcs8, for tools that only
+read those; neither can be encrypted, and Ed25519 keys have no pem encoding.
+
 Examples:
-  # Generate a default key pair (id_rsa and id_rsa.pub)
+  # Generate a default key pair (id_ed25519 and id_ed25519.pub)
   gossh keygen
 
+  # Generate an RSA key pair (id_rsa and id_rsa.pub) for servers without Ed25519
+  gossh keygen --type rsa
+
+  # Generate a 2048-bit RSA key for an old appliance
+  gossh keygen --type rsa --bits 2048 --private-key appliance_rsa
+
+  # Generate an ECDSA key pair on P-384 (id_ecdsa and id_ecdsa.pub)
+  gossh keygen --type ecdsa --curve p384
+
   # Specify output files
   gossh keygen --private-key mykey.pem --public-key mykey.pub
 
+  # Generate an encrypted key from a script, slowing down passphrase guessing
+  gossh keygen --passphrase "$KEY_PASSPHRASE" --rounds 100
+
+  # Generate an RSA key in PKCS#8 for a tool that doesn't read OpenSSH keys
+  gossh keygen --type rsa --format pkcs8 --passphrase ""
+
   # Generate keys with specific parameters
   gossh keygen --private-key server.pem --public-key server.pub --comment "server-key"`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
+		// Only OpenSSH format keys can be encrypted, so only they ask
+		openssh := keyFormat == "" || strings.EqualFold(keyFormat, "openssh")
+		if openssh && !cmd.Flags().Changed("passphrase") && term.IsTerminal(int(os.Stdin.Fd())) {
+			passphrase, err := promptNewPassphrase()
+			if err != nil {
+				fmt.Printf("Error reading passphrase: %s\n", err)
+				os.Exit(1)
+			}
+			keyPassphrase = passphrase
+		}
 		fmt.Println("Generating SSH key pair...")
 
+		privateKeyOut, publicKeyOut := keygenOutputPaths(keyType, privateKeyOut, publicKeyOut)
+		if !cmd.Flags().Changed("comment") {
+			keyComment = defaultKeyComment()
+		}
+
 		// Generate the keys
-		privateKey, publicKey, err := ssh.GenerateKeys()
+		privateKey, publicKey, err := ssh.GenerateKeys(ssh.KeyOptions{
+			Type:       keyType,
+			Curve:      keyCurve,
+			Bits:       keyBits,
+			Comment:    keyComment,
+			Passphrase: keyPassphrase,
+			Rounds:     keyRounds,
+			Format:     keyFormat,
+		})
 		if err != nil {
 			fmt.Printf("Error generating keys: %s\n", err)
 			os.Exit(1)
@@ -62,11 +125,38 @@ func init() {
 	rootCmd.AddCommand(keygenCmd)
 
 	// Define flags for the keygen command
-	keygenCmd.Flags().StringVarP(&privateKeyOut, "private-key", "k", "id_rsa", "Output file for private key")
-	keygenCmd.Flags().StringVarP(&publicKeyOut, "public-key", "p", "id_rsa.pub", "Output file for public key")
-	keygenCmd.Flags().IntVarP(&keyBits, "bits", "b", 4096, "Number of bits in the key")
-	keygenCmd.Flags().StringVarP(&keyComment, "comment", "c", "", "Comment to include in the public key")
+	keygenCmd.Flags().StringVarP(&keyType, "type", "t", ssh.DefaultKeyType, "Key type: ed25519, ecdsa or rsa")
+	keygenCmd.Flags().StringVar(&keyCurve, "curve", "", "Curve of an ecdsa key: p256, p384 or p521 (default: p256)")
+	keygenCmd.Flags().StringVarP(&privateKeyOut, "private-key", "k", "", "Output file for private key (default: id_<type>)")
+	keygenCmd.Flags().StringVarP(&publicKeyOut, "public-key", "p", "", "Output file for public key (default: <private key>.pub)")
+	keygenCmd.Flags().IntVarP(&keyBits, "bits", "b", 0, "Key size: 2048 to 16384 for rsa (default: 4096), or 256, 384 or 521 for ecdsa")
+	keygenCmd.Flags().StringVarP(&keyComment, "comment", "c", "", "Comment to include in the public key (default: user@hostname)")
+	keygenCmd.Flags().StringVarP(&keyPassphrase, "passphrase", "N", "", "Passphrase to encrypt the private key with, empty for none (default: ask on a terminal)")
+	keygenCmd.Flags().IntVarP(&keyRounds, "rounds", "a", 0, "bcrypt rounds deriving the encryption key from the passphrase (default: 16)")
+	keygenCmd.Flags().StringVarP(&keyFormat, "format", "m", "openssh", "Private key format: openssh, pem or pkcs8")
+}
+
+// defaultKeyComment returns the comment of new keys: user@hostname, the
+// user running gossh on this machine
+func defaultKeyComment() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return localUser()
+	}
+	return localUser() + "@" + hostname
+}
 
-	// Note: The current implementation doesn't use keyBits and keyComment yet,
-	// but they are included here for future enhancement
+// keygenOutputPaths returns the private and public key files of a new key of
+// keyType, defaulting to id_<type> and the private key file with .pub
+func keygenOutputPaths(keyType, privatePath, publicPath string) (string, string) {
+	if keyType == "" {
+		keyType = ssh.DefaultKeyType
+	}
+	if privatePath == "" {
+		privatePath = "id_" + keyType
+	}
+	if publicPath == "" {
+		publicPath = privatePath + ".pub"
+	}
+	return privatePath, publicPath
 }
@@ -59,3 +59,21 @@ func TestKeygenOutputFiles(t *testing.T) {
 		t.Errorf("Public key has wrong permissions: %v, expected 0644", pubInfo.Mode().Perm())
 	}
 }
+
+func TestKeygenOutputPaths(t *testing.T) {
+	tests := []struct {
+		keyType, private, public string
+		wantPrivate, wantPublic  string
+	}{
+		{"", "", "", "id_ed25519", "id_ed25519.pub"},
+		{"rsa", "", "", "id_rsa", "id_rsa.pub"},
+		{"ed25519", "deploy", "", "deploy", "deploy.pub"},
+		{"ed25519", "deploy.pem", "deploy.pub", "deploy.pem", "deploy.pub"},
+	}
+	for _, tt := range tests {
+		private, public := keygenOutputPaths(tt.keyType, tt.private, tt.public)
+		if private != tt.wantPrivate || public != tt.wantPublic {
+			t.Errorf("keygenOutputPaths(%q, %q, %q) = %q, %q, want %q, %q", tt.keyType, tt.private, tt.public, private, public, tt.wantPrivate, tt.wantPublic)
+		}
+	}
+}
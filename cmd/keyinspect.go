@@ -0,0 +1,501 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+var (
+	fingerprintHash      string
+	fingerprintRandomart bool
+	pubkeyKeyPath        string
+	pubkeyPassphrase     string
+	pubkeyOutput         string
+)
+
+// keygenFingerprintCmd represents the keygen fingerprint command
+var keygenFingerprintCmd = &cobra.Command{
+	Use:   "fingerprint <key file>...",
+	Short: "Show the fingerprints of keys",
+	Long: `Show the size, fingerprint, comment and type of the keys in each file, one
+line per key like ssh-keygen -l. A file may hold a private key, encrypted or
+not, a public key or certificate, or several keys, like authorized_keys.
+
+Examples:
+  # Compare a key with the fingerprint a server logged
+  gossh keygen fingerprint ~/.ssh/id_ed25519
+
+  # MD5 fingerprints, as older tools show them, with randomart
+  gossh keygen fingerprint --hash md5 --randomart ~/.ssh/authorized_keys`,
+	Args: cobra.MinimumNArgs(1),
+	// The fingerprint lines go to stdout, for scripts
+	Annotations: map[string]string{machineOutputAnnotation: ""},
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Fprintln(os.Stderr, errorColor("✗ ")+err.Error())
+			os.Exit(1)
+		}
+		if fingerprintHash != "sha256" && fingerprintHash != "md5" {
+			fail(fmt.Errorf("invalid --hash %q: use sha256 or md5", fingerprintHash))
+		}
+		for _, path := range args {
+			keys, err := readInspectedKeys(path)
+			if err != nil {
+				fail(err)
+			}
+			for _, key := range keys {
+				fmt.Println(key.fingerprintLine(fingerprintHash))
+				if fingerprintRandomart {
+					fmt.Println(key.randomart())
+				}
+			}
+		}
+	},
+}
+
+// keygenInspectCmd represents the keygen inspect command
+var keygenInspectCmd = &cobra.Command{
+	Use:   "inspect <key file>...",
+	Short: "Show the details of keys and certificates",
+	Long: `Show everything about the keys in each file: whether it holds a private key,
+and if it is encrypted, the algorithm and size, the SHA256 and MD5
+fingerprints, the comment, the randomart picture, and for certificates their
+type, key ID, serial, signing CA, validity, principals, critical options and
+extensions.
+
+The public key of an encrypted OpenSSH format private key is readable without
+its passphrase, so no passphrase is asked for; that of a legacy encrypted PEM
+key is read from the .pub file next to it.
+
+Examples:
+  # Check a certificate before logging in with it
+  gossh keygen inspect ~/.ssh/id_ed25519-cert.pub
+
+  # Inspect a private key
+  gossh keygen inspect ~/.ssh/id_ed25519`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		for i, path := range args {
+			keys, err := readInspectedKeys(path)
+			if err != nil {
+				fail(err)
+			}
+			for j, key := range keys {
+				if i > 0 || j > 0 {
+					fmt.Println()
+				}
+				key.describe(os.Stdout, time.Now())
+			}
+		}
+	},
+}
+
+// keygenPubkeyCmd represents the keygen pubkey command
+var keygenPubkeyCmd = &cobra.Command{
+	Use:   "pubkey",
+	Short: "Derive the public key of a private key",
+	Long: `Print the public key of a private key in authorized_keys format, with the
+key's comment, like ssh-keygen -y, to regenerate a lost .pub file. The
+passphrase of an encrypted key is asked for on the terminal, unless
+--passphrase gives it.
+
+Examples:
+  # Regenerate a lost public key file
+  gossh keygen pubkey --key ~/.ssh/id_ed25519 --output ~/.ssh/id_ed25519.pub
+
+  # Authorize a new key on a server, logging in with the old one
+  gossh keygen pubkey --key ~/.ssh/id_ed25519 |
+    gossh client --host example.com --user admin --key ~/.ssh/id_rsa --cmd "cat >> ~/.ssh/authorized_keys"`,
+	Args: cobra.NoArgs,
+	// The public key goes to stdout, for redirecting
+	Annotations: map[string]string{machineOutputAnnotation: ""},
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Fprintln(os.Stderr, errorColor("✗ ")+err.Error())
+			os.Exit(1)
+		}
+		passphrase := func(path string) (string, error) {
+			if cmd.Flags().Changed("passphrase") {
+				return pubkeyPassphrase, nil
+			}
+			// The prompt goes to stderr, keeping stdout to the public key
+			fmt.Fprintf(os.Stderr, "Enter passphrase for %s: ", path)
+			secret, err := readSecret()
+			fmt.Fprintln(os.Stderr)
+			if err != nil {
+				return "", fmt.Errorf("could not read passphrase: %s", err)
+			}
+			return secret, nil
+		}
+		line, err := derivePublicKey(expandHome(pubkeyKeyPath), passphrase)
+		if err != nil {
+			fail(err)
+		}
+		if pubkeyOutput == "" {
+			os.Stdout.Write(line)
+			return
+		}
+		if err := os.WriteFile(pubkeyOutput, line, 0o644); err != nil {
+			fail(fmt.Errorf("failed to write public key: %s", err))
+		}
+		fmt.Fprintf(os.Stderr, "Public key written to %s\n", pubkeyOutput)
+	},
+}
+
+func init() {
+	keygenCmd.AddCommand(keygenFingerprintCmd, keygenInspectCmd, keygenPubkeyCmd)
+
+	keygenFingerprintCmd.Flags().StringVarP(&fingerprintHash, "hash", "E", "sha256", "Fingerprint hash: sha256 or md5")
+	keygenFingerprintCmd.Flags().BoolVar(&fingerprintRandomart, "randomart", false, "Draw the randomart picture of each key")
+	keygenPubkeyCmd.Flags().StringVarP(&pubkeyKeyPath, "key", "f", "", "Private key to derive the public key of")
+	keygenPubkeyCmd.Flags().StringVarP(&pubkeyPassphrase, "passphrase", "P", "", "Passphrase of the private key (default: ask on the terminal)")
+	keygenPubkeyCmd.Flags().StringVarP(&pubkeyOutput, "output", "o", "", "File to write the public key to (default: stdout)")
+	keygenPubkeyCmd.MarkFlagRequired("key")
+}
+
+// derivePublicKey returns the authorized_keys line of the public key of
+// the private key at path, with the comment the key keeps, asking
+// passphrase for the passphrase of an encrypted key
+func derivePublicKey(path string, passphrase func(path string) (string, error)) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %s", err)
+	}
+	secret := ""
+	signer, err := cryptossh.ParsePrivateKey(data)
+	var missing *cryptossh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		if secret, err = passphrase(path); err != nil {
+			return nil, err
+		}
+		signer, err = cryptossh.ParsePrivateKeyWithPassphrase(data, []byte(secret))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %s", path, err)
+	}
+	line := bytes.TrimSpace(cryptossh.MarshalAuthorizedKey(signer.PublicKey()))
+	if comment := ssh.PrivateKeyComment(data, []byte(secret)); comment != "" {
+		line = append(append(line, ' '), comment...)
+	}
+	return append(line, '\n'), nil
+}
+
+// inspectedKey is a key read from a key file, with what the file says
+// about it
+type inspectedKey struct {
+	path      string
+	pub       cryptossh.PublicKey
+	comment   string
+	private   bool
+	encrypted bool
+}
+
+// readInspectedKeys reads the keys of a private key file, or of a file of
+// public keys and certificates in authorized_keys format
+func readInspectedKeys(path string) ([]*inspectedKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		return readInspectedPrivateKey(path, data)
+	}
+
+	var keys []*inspectedKey
+	for rest := data; len(bytes.TrimSpace(rest)) > 0; {
+		pub, comment, _, next, err := cryptossh.ParseAuthorizedKey(rest)
+		if err != nil {
+			// ParseAuthorizedKey skips lines it can't parse, so only the
+			// last one fails
+			if len(keys) > 0 {
+				break
+			}
+			return nil, fmt.Errorf("%s holds no key: %s", path, err)
+		}
+		keys = append(keys, &inspectedKey{path: path, pub: pub, comment: comment})
+		rest = next
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s holds no key", path)
+	}
+	return keys, nil
+}
+
+// readInspectedPrivateKey reads the public key of the private key data in
+// the file at path, without its passphrase when it is encrypted
+func readInspectedPrivateKey(path string, data []byte) ([]*inspectedKey, error) {
+	key := &inspectedKey{path: path, private: true, comment: ssh.PrivateKeyComment(data, nil)}
+	signer, err := cryptossh.ParsePrivateKey(data)
+	var missing *cryptossh.PassphraseMissingError
+	switch {
+	case err == nil:
+		key.pub = signer.PublicKey()
+	case errors.As(err, &missing):
+		key.encrypted, key.pub = true, missing.PublicKey
+	default:
+		return nil, fmt.Errorf("failed to parse private key %s: %s", path, err)
+	}
+	// The .pub file next to the key has the public key of legacy encrypted
+	// keys, and the comment of keys that don't keep theirs readable
+	if key.pub == nil || key.comment == "" {
+		pub, comment, pubErr := readPublicKeyFile(path + ".pub")
+		switch {
+		case key.pub == nil && pubErr != nil:
+			return nil, fmt.Errorf("%s is encrypted, and its public key isn't in a .pub file next to it", path)
+		case key.pub == nil:
+			key.pub, key.comment = pub, comment
+		case pubErr == nil && bytes.Equal(pub.Marshal(), key.pub.Marshal()):
+			key.comment = comment
+		}
+	}
+	return []*inspectedKey{key}, nil
+}
+
+// plainKey returns the key, or the key it certifies when it is a
+// certificate, which is what fingerprints are taken of, as in OpenSSH
+func (k *inspectedKey) plainKey() cryptossh.PublicKey {
+	if cert, ok := k.pub.(*cryptossh.Certificate); ok {
+		return cert.Key
+	}
+	return k.pub
+}
+
+// fingerprintLine returns the line ssh-keygen -l prints for the key: its
+// size, fingerprint, comment and type
+func (k *inspectedKey) fingerprintLine(hash string) string {
+	fingerprint := cryptossh.FingerprintSHA256(k.plainKey())
+	if hash == "md5" {
+		fingerprint = "MD5:" + cryptossh.FingerprintLegacyMD5(k.plainKey())
+	}
+	comment := k.comment
+	if comment == "" {
+		comment = "no comment"
+	}
+	return fmt.Sprintf("%d %s %s (%s)", publicKeyBits(k.pub), fingerprint, comment, keyTypeName(k.pub))
+}
+
+// randomart returns the randomart picture of the key's SHA256 fingerprint
+func (k *inspectedKey) randomart() string {
+	sum := sha256.Sum256(k.plainKey().Marshal())
+	return randomart(keyTypeName(k.pub), publicKeyBits(k.pub), "SHA256", sum[:])
+}
+
+// describe writes everything known about the key, judging certificate
+// validity at now
+func (k *inspectedKey) describe(out io.Writer, now time.Time) {
+	kind := "public key"
+	switch {
+	case k.private && k.encrypted:
+		kind = "private key, encrypted"
+	case k.private:
+		kind = "private key"
+	}
+	if _, ok := k.pub.(*cryptossh.Certificate); ok {
+		kind = "certificate"
+	}
+	fmt.Fprintf(out, "File: %s (%s)\n", k.path, kind)
+	fmt.Fprintf(out, "Algorithm: %s (%s)\n", keyTypeName(k.pub), k.pub.Type())
+	fmt.Fprintf(out, "Bits: %d\n", publicKeyBits(k.pub))
+	fmt.Fprintf(out, "Fingerprint: %s\n", cryptossh.FingerprintSHA256(k.plainKey()))
+	fmt.Fprintf(out, "MD5 fingerprint: MD5:%s\n", cryptossh.FingerprintLegacyMD5(k.plainKey()))
+	fmt.Fprintf(out, "Comment: %s\n", orDash(k.comment))
+	if cert, ok := k.pub.(*cryptossh.Certificate); ok {
+		describeCertificate(out, cert, now)
+	}
+	fmt.Fprintln(out, k.randomart())
+}
+
+// describeCertificate writes the details of cert, judging its validity at now
+func describeCertificate(out io.Writer, cert *cryptossh.Certificate, now time.Time) {
+	certType := "user certificate"
+	if cert.CertType == cryptossh.HostCert {
+		certType = "host certificate"
+	}
+	fmt.Fprintf(out, "Certificate type: %s\n", certType)
+	fmt.Fprintf(out, "Signing CA: %s %s (using %s)\n", keyTypeName(cert.SignatureKey), cryptossh.FingerprintSHA256(cert.SignatureKey), cert.Signature.Format)
+	fmt.Fprintf(out, "Key ID: %q\n", cert.KeyId)
+	fmt.Fprintf(out, "Serial: %d\n", cert.Serial)
+	fmt.Fprintf(out, "Valid: %s\n", certificateValidity(cert, now))
+	fmt.Fprintf(out, "Principals: %s\n", orDash(strings.Join(cert.ValidPrincipals, ", ")))
+	fmt.Fprintf(out, "Critical options: %s\n", orDash(certificateOptionList(cert.CriticalOptions)))
+	fmt.Fprintf(out, "Extensions: %s\n", orDash(certificateOptionList(cert.Extensions)))
+}
+
+// certificateValidity describes the validity period of cert, and whether
+// now is in it
+func certificateValidity(cert *cryptossh.Certificate, now time.Time) string {
+	if cert.ValidAfter == 0 && cert.ValidBefore == cryptossh.CertTimeInfinity {
+		return "forever"
+	}
+	after := time.Unix(int64(cert.ValidAfter), 0)
+	before := time.Unix(int64(cert.ValidBefore), 0)
+	period := fmt.Sprintf("from %s to %s", after.Format(time.RFC3339), before.Format(time.RFC3339))
+	if cert.ValidBefore == cryptossh.CertTimeInfinity {
+		period = fmt.Sprintf("from %s, forever", after.Format(time.RFC3339))
+	}
+	switch {
+	case now.Before(after):
+		return period + " (not yet valid)"
+	case cert.ValidBefore != cryptossh.CertTimeInfinity && !now.Before(before):
+		return period + " (expired)"
+	}
+	return period
+}
+
+// certificateOptionList lists certificate options or extensions by name,
+// with their values when they have any
+func certificateOptionList(options map[string]string) string {
+	var list []string
+	for name, value := range options {
+		if value != "" {
+			name += " " + value
+		}
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
+
+// keyTypeName returns the short name ssh-keygen gives the type of pub, such
+// as ED25519 or RSA-CERT
+func keyTypeName(pub cryptossh.PublicKey) string {
+	if cert, ok := pub.(*cryptossh.Certificate); ok {
+		return keyTypeName(cert.Key) + "-CERT"
+	}
+	switch t := pub.Type(); {
+	case t == cryptossh.KeyAlgoRSA:
+		return "RSA"
+	case t == cryptossh.KeyAlgoDSA:
+		return "DSA"
+	case t == cryptossh.KeyAlgoED25519:
+		return "ED25519"
+	case t == cryptossh.KeyAlgoSKED25519:
+		return "ED25519-SK"
+	case t == cryptossh.KeyAlgoSKECDSA256:
+		return "ECDSA-SK"
+	case strings.HasPrefix(t, "ecdsa-"):
+		return "ECDSA"
+	default:
+		return strings.ToUpper(t)
+	}
+}
+
+// publicKeyBits returns the size of pub, or of the key a certificate certifies
+func publicKeyBits(pub cryptossh.PublicKey) int {
+	if cert, ok := pub.(*cryptossh.Certificate); ok {
+		pub = cert.Key
+	}
+	switch pub.Type() {
+	case cryptossh.KeyAlgoED25519, cryptossh.KeyAlgoSKED25519, cryptossh.KeyAlgoSKECDSA256:
+		return 256
+	}
+	crypto, ok := pub.(cryptossh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch key := crypto.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize
+	case *dsa.PublicKey:
+		return key.P.BitLen()
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
+
+// Randomart field size, and the characters marking how often the bishop
+// visited a square, ending with its start and end
+const (
+	randomartWidth   = 17
+	randomartHeight  = 9
+	randomartSymbols = " .o+=*BOX@%&#/^SE"
+)
+
+// randomart draws the "drunken bishop" picture of digest that OpenSSH
+// shows for fingerprints, framed with the key type and size on top and the
+// hash at the bottom. The bishop starts in the middle and makes four
+// diagonal moves per byte of digest, two bits each, staying inside the field.
+func randomart(keyType string, bits int, hash string, digest []byte) string {
+	var field [randomartWidth][randomartHeight]int
+	end := len(randomartSymbols) - 1
+	x, y := randomartWidth/2, randomartHeight/2
+	for _, b := range digest {
+		for i := 0; i < 4; i++ {
+			if b&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = max(0, min(x, randomartWidth-1))
+			y = max(0, min(y, randomartHeight-1))
+			if field[x][y] < end-2 {
+				field[x][y]++
+			}
+			b >>= 2
+		}
+	}
+	field[randomartWidth/2][randomartHeight/2] = end - 1
+	field[x][y] = end
+
+	// Like OpenSSH, drop the size when it doesn't fit, then cut the title
+	title := fmt.Sprintf("[%s %d]", keyType, bits)
+	if len(title) > randomartWidth {
+		title = "[" + keyType + "]"
+	}
+	if len(title) > randomartWidth-1 {
+		title = title[:randomartWidth-1]
+	}
+	var out strings.Builder
+	out.WriteString(randomartBorder(title) + "\n")
+	for row := 0; row < randomartHeight; row++ {
+		out.WriteByte('|')
+		for col := 0; col < randomartWidth; col++ {
+			out.WriteByte(randomartSymbols[min(field[col][row], end)])
+		}
+		out.WriteString("|\n")
+	}
+	out.WriteString(randomartBorder("[" + hash + "]"))
+	return out.String()
+}
+
+// randomartBorder returns a border line of the field with label centered
+// in it
+func randomartBorder(label string) string {
+	pad := (randomartWidth - len(label)) / 2
+	return "+" + strings.Repeat("-", pad) + label + strings.Repeat("-", randomartWidth-pad-len(label)) + "+"
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key checking modes of --strict-host-key-checking
+const (
+	// hostKeyAsk asks before trusting an unknown host on first use
+	hostKeyAsk = "ask"
+	// hostKeyAcceptNew trusts unknown hosts without asking
+	hostKeyAcceptNew = "accept-new"
+	// hostKeyStrict refuses hosts that are not already known
+	hostKeyStrict = "yes"
+	// hostKeyOff skips host key checking altogether
+	hostKeyOff = "no"
+)
+
+// hostKeyChecker verifies server host keys against known_hosts files,
+// trusting new hosts on first use and refusing keys that changed
+type hostKeyChecker struct {
+	// files are the known_hosts files consulted; missing ones are skipped
+	files []string
+	// store is the file newly trusted keys are appended to
+	store string
+	mode  string
+	// confirm asks whether to trust the unknown key of host
+	confirm func(host string, key ssh.PublicKey) bool
+}
+
+// hostKeyMismatchError is a host key that differs from the one known for
+// the host, which may be a man-in-the-middle attack
+type hostKeyMismatchError struct {
+	host string
+	key  ssh.PublicKey
+	want knownhosts.KnownKey
+}
+
+func (e *hostKeyMismatchError) Error() string {
+	return fmt.Sprintf("REMOTE HOST IDENTIFICATION HAS CHANGED for %s! Someone could be eavesdropping on you "+
+		"(man-in-the-middle attack), or the host key has just been changed. The server offered %s %s, but %s:%d lists %s. "+
+		"Remove that line if the change is expected",
+		e.host, e.key.Type(), ssh.FingerprintSHA256(e.key), e.want.Filename, e.want.Line, ssh.FingerprintSHA256(e.want.Key))
+}
+
+// defaultKnownHostsFiles returns OpenSSH's known_hosts file and the gossh
+// one, to which newly trusted keys are written
+func defaultKnownHostsFiles() (string, string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", fmt.Errorf("could not locate known_hosts: %s", err)
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), filepath.Join(home, ".gossh", "known_hosts"), nil
+}
+
+// callback returns the HostKeyCallback checking keys in the checker's mode
+func (h *hostKeyChecker) callback() (ssh.HostKeyCallback, error) {
+	switch h.mode {
+	case hostKeyOff:
+		return ssh.InsecureIgnoreHostKey(), nil
+	case hostKeyAsk, hostKeyAcceptNew, hostKeyStrict:
+	default:
+		return nil, fmt.Errorf("invalid host key checking mode %q (use yes, ask, accept-new or no)", h.mode)
+	}
+
+	var existing []string
+	for _, file := range h.files {
+		if _, err := os.Stat(file); err == nil {
+			existing = append(existing, file)
+		}
+	}
+	var known ssh.HostKeyCallback
+	if len(existing) > 0 {
+		var err error
+		if known, err = knownhosts.New(existing...); err != nil {
+			return nil, fmt.Errorf("could not read known_hosts: %s", err)
+		}
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if known != nil {
+			err := known(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if !errors.As(err, &keyErr) {
+				// Known, revoked or unreadable
+				return err
+			}
+			if len(keyErr.Want) > 0 {
+				return &hostKeyMismatchError{host: hostname, key: key, want: keyErr.Want[0]}
+			}
+		}
+		switch h.mode {
+		case hostKeyStrict:
+			return fmt.Errorf("host key %s of %s is not known and strict host key checking is on", ssh.FingerprintSHA256(key), hostname)
+		case hostKeyAsk:
+			if !h.confirm(hostname, key) {
+				return fmt.Errorf("host key of %s not trusted", hostname)
+			}
+		}
+		return appendKnownHost(h.store, hostname, key)
+	}, nil
+}
+
+// confirmHostKey asks on the terminal whether to trust the unknown key of host
+func confirmHostKey(host string, key ssh.PublicKey) bool {
+	fmt.Printf("The authenticity of host %s can't be established.\n", host)
+	fmt.Printf("%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
+	fmt.Print("Are you sure you want to continue connecting (yes/no)? ")
+	answer, _ := readLine()
+	return strings.EqualFold(strings.TrimSpace(answer), "yes")
+}
+
+// appendKnownHost records key as the host key of hostname in file
+func appendKnownHost(file, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return fmt.Errorf("could not save host key: %s", err)
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("could not save host key: %s", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)); err != nil {
+		return fmt.Errorf("could not save host key: %s", err)
+	}
+	return nil
+}
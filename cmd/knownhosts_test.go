@@ -0,0 +1,111 @@
+// cmd/knownhosts_test.go
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("NewPublicKey failed: %v", err)
+	}
+	return key
+}
+
+func TestHostKeyChecker(t *testing.T) {
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 2022}
+	known, other := newHostKey(t), newHostKey(t)
+
+	tests := []struct {
+		name    string
+		mode    string
+		key     ssh.PublicKey
+		trust   bool
+		wantErr bool
+	}{
+		{"known key", hostKeyStrict, known, false, false},
+		{"changed key", hostKeyAcceptNew, other, true, true},
+		{"changed key with checking off", hostKeyOff, other, false, false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		store := filepath.Join(dir, "known_hosts")
+		if err := appendKnownHost(store, "example.com:2022", known); err != nil {
+			t.Fatalf("appendKnownHost failed: %v", err)
+		}
+		checker := &hostKeyChecker{files: []string{store}, store: store, mode: tt.mode,
+			confirm: func(string, ssh.PublicKey) bool { return tt.trust }}
+		callback, err := checker.callback()
+		if err != nil {
+			t.Fatalf("%s: callback() error = %v", tt.name, err)
+		}
+		err = callback("example.com:2022", remote, tt.key)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		var mismatch *hostKeyMismatchError
+		if tt.wantErr && !errors.As(err, &mismatch) {
+			t.Errorf("%s: error = %v, want a host key mismatch", tt.name, err)
+		}
+	}
+
+	unknown := []struct {
+		name      string
+		mode      string
+		trust     bool
+		wantErr   bool
+		wantSaved bool
+	}{
+		{"ask and trust", hostKeyAsk, true, false, true},
+		{"ask and refuse", hostKeyAsk, false, true, false},
+		{"accept new", hostKeyAcceptNew, false, false, true},
+		{"strict", hostKeyStrict, true, true, false},
+	}
+	for _, tt := range unknown {
+		dir := t.TempDir()
+		// The OpenSSH file does not exist yet, and new keys go to the gossh one
+		store := filepath.Join(dir, ".gossh", "known_hosts")
+		checker := &hostKeyChecker{files: []string{filepath.Join(dir, ".ssh", "known_hosts"), store}, store: store, mode: tt.mode,
+			confirm: func(string, ssh.PublicKey) bool { return tt.trust }}
+		callback, err := checker.callback()
+		if err != nil {
+			t.Fatalf("%s: callback() error = %v", tt.name, err)
+		}
+		if err := callback("example.com:2022", remote, known); (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		_, statErr := os.Stat(store)
+		if saved := statErr == nil; saved != tt.wantSaved {
+			t.Errorf("%s: key saved = %v, want %v", tt.name, saved, tt.wantSaved)
+		}
+		if !tt.wantSaved {
+			continue
+		}
+		// A saved key is known on the next connection
+		checker.mode = hostKeyStrict
+		if callback, err = checker.callback(); err != nil {
+			t.Fatalf("%s: callback() error = %v", tt.name, err)
+		}
+		if err := callback("example.com:2022", remote, known); err != nil {
+			t.Errorf("%s: saved key rejected: %v", tt.name, err)
+		}
+	}
+
+	if _, err := (&hostKeyChecker{mode: "sometimes"}).callback(); err == nil {
+		t.Error("an invalid mode should be refused")
+	}
+}
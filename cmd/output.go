@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/spf13/cobra"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// Output formats of gossh client --cmd
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// exitRemoteFailure is the exit status when the remote command ended without
+// one, e.g. killed by a signal, as with OpenSSH
+const exitRemoteFailure = 255
+
+// commandResult is the --output json report of a remote command
+type commandResult struct {
+	Host string `json:"host"`
+	// Banner holds the banners the server sent before login, if any
+	Banner  string `json:"banner,omitempty"`
+	Command string `json:"command"`
+	// ExitCode is -1 when the command ended without an exit status, e.g.
+	// when it was killed by a signal or the connection dropped
+	ExitCode   int   `json:"exit_code"`
+	DurationMS int64 `json:"duration_ms"`
+	// Stdout and Stderr are base64-encoded when Encoding is "base64"
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	Encoding string `json:"encoding,omitempty"`
+	// TimedOut is set when --cmd-timeout stopped the command
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Error explains an exit code of -1
+	Error string `json:"error,omitempty"`
+}
+
+// newCommandResult reports res, the result of a command, and err, the error
+// ssh.NewResult returned with it
+func newCommandResult(host, command string, res ssh.Result, err error, useBase64 bool) commandResult {
+	result := commandResult{
+		Host:       host,
+		Command:    command,
+		ExitCode:   res.ExitCode,
+		DurationMS: res.Duration.Milliseconds(),
+		Stdout:     string(res.Stdout),
+		Stderr:     string(res.Stderr),
+	}
+	if useBase64 {
+		result.Encoding = "base64"
+		result.Stdout = base64.StdEncoding.EncodeToString(res.Stdout)
+		result.Stderr = base64.StdEncoding.EncodeToString(res.Stderr)
+	}
+	switch {
+	case errors.Is(err, errCommandTimeout):
+		result.TimedOut = true
+		result.Error = err.Error()
+	case err != nil:
+		result.Error = err.Error()
+	case res.Signal != "":
+		result.Error = "killed by signal " + res.Signal
+	}
+	return result
+}
+
+// exitStatus returns the local exit status for the result, as exitCode does
+// for the error it was made from
+func (r commandResult) exitStatus() int {
+	switch {
+	case r.TimedOut:
+		return exitCommandTimeout
+	case r.ExitCode < 0:
+		return exitRemoteFailure
+	}
+	return r.ExitCode
+}
+
+// runJSON runs command on session within timeout, writes its commandResult
+// to out, with the server's banner, and returns it
+func runJSON(session *cryptossh.Session, host, banner, command string, timeout time.Duration, useBase64 bool, out io.Writer) (commandResult, error) {
+	var stdout, stderr bytes.Buffer
+	session.Stdout = teeTo(&stdout, session.Stdout)
+	session.Stderr = teeTo(&stderr, session.Stderr)
+	start := time.Now()
+	err := runWithTimeout(session, command, timeout)
+	res, err := ssh.NewResult(stdout.Bytes(), stderr.Bytes(), time.Since(start), err)
+	result := newCommandResult(host, command, res, err, useBase64)
+	result.Banner = banner
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return result, encoder.Encode(result)
+}
+
+// exitCode returns the local exit status for a remote command that ended
+// with err, the error of ssh.Session.Run: the command's own status,
+// exitCommandTimeout when it timed out, or exitRemoteFailure when it has none
+func exitCode(err error) int {
+	var exitErr *cryptossh.ExitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errCommandTimeout):
+		return exitCommandTimeout
+	case errors.As(err, &exitErr) && exitErr.Signal() == "":
+		return exitErr.ExitStatus()
+	default:
+		return exitRemoteFailure
+	}
+}
+
+// createTeeFile creates the file a session stream is copied to, for
+// --stdout-file and --stderr-file. An empty path means no file.
+func createTeeFile(path string) (io.Writer, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// teeTo returns a writer that writes to both w and file; either may be nil
+func teeTo(w, file io.Writer) io.Writer {
+	switch {
+	case file == nil:
+		return w
+	case w == nil:
+		return file
+	}
+	return io.MultiWriter(w, file)
+}
+
+// machineOutputAnnotation marks commands whose stdout is always
+// machine-readable
+const machineOutputAnnotation = "machine-output"
+
+// machineOutput reports whether cmd writes machine-readable output to
+// stdout, which decorations must stay out of: --output json, --batch, or
+// commands annotated with machineOutputAnnotation
+func machineOutput(cmd *cobra.Command) bool {
+	if _, ok := cmd.Annotations[machineOutputAnnotation]; ok {
+		return true
+	}
+	if flag := cmd.Flags().Lookup("batch"); flag != nil && flag.Value.String() == "true" {
+		return true
+	}
+	flag := cmd.Flags().Lookup("output")
+	return flag != nil && flag.Value.String() == outputJSON
+}
@@ -0,0 +1,122 @@
+// cmd/output_test.go
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+)
+
+func TestRunJSON(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+	client := srv.Dial(t)
+
+	tests := []struct {
+		command   string
+		useBase64 bool
+		want      commandResult
+	}{
+		{"printf out; printf err >&2", false, commandResult{Stdout: "out", Stderr: "err"}},
+		{"printf out; exit 3", false, commandResult{ExitCode: 3, Stdout: "out"}},
+		{"printf '\\377'", true, commandResult{Stdout: "/w==", Encoding: "base64"}},
+	}
+	for _, tt := range tests {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("NewSession failed: %v", err)
+		}
+		var out bytes.Buffer
+		result, err := runJSON(session, "example.com", "", tt.command, 0, tt.useBase64, &out)
+		session.Close()
+		if err != nil {
+			t.Fatalf("runJSON(%q) failed: %v", tt.command, err)
+		}
+		var decoded commandResult
+		if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+			t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+		}
+		if decoded != result {
+			t.Errorf("written %+v, returned %+v", decoded, result)
+		}
+		tt.want.Host, tt.want.Command, tt.want.DurationMS = "example.com", tt.command, result.DurationMS
+		if result != tt.want {
+			t.Errorf("runJSON(%q) = %+v, want %+v", tt.command, result, tt.want)
+		}
+	}
+
+	result := newCommandResult("example.com", "uptime", ssh.Result{ExitCode: -1, Duration: time.Second}, errors.New("connection lost"), false)
+	if result.ExitCode != -1 || result.Error != "connection lost" || result.DurationMS != 1000 {
+		t.Errorf("failed command result = %+v", result)
+	}
+}
+
+func TestExitCodeAndTee(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+	client := srv.Dial(t)
+
+	tests := []struct {
+		command string
+		want    int
+	}{
+		{"true", 0},
+		{"exit 3", 3},
+		{"kill -9 $$", exitRemoteFailure},
+	}
+	for _, tt := range tests {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("NewSession failed: %v", err)
+		}
+		err = session.Run(tt.command)
+		session.Close()
+		if got := exitCode(err); got != tt.want {
+			t.Errorf("exitCode after %q = %d (%v), want %d", tt.command, got, err, tt.want)
+		}
+	}
+	if got := exitCode(errors.New("connection lost")); got != exitRemoteFailure {
+		t.Errorf("exitCode without a status = %d, want %d", got, exitRemoteFailure)
+	}
+
+	// The streams are copied to the tee files as well as the terminal
+	dir := t.TempDir()
+	stdoutTee, err := createTeeFile(filepath.Join(dir, "stdout.log"))
+	if err != nil {
+		t.Fatalf("createTeeFile failed: %v", err)
+	}
+	stderrTee, _ := createTeeFile(filepath.Join(dir, "stderr.log"))
+	if none, err := createTeeFile(""); none != nil || err != nil {
+		t.Errorf("createTeeFile(\"\") = %v, %v, want no file", none, err)
+	}
+	var terminal bytes.Buffer
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	defer session.Close()
+	session.Stdout = teeTo(&terminal, stdoutTee)
+	session.Stderr = teeTo(&terminal, stderrTee)
+	session.Run("echo out; echo err >&2")
+	for name, want := range map[string]string{"stdout.log": "out\n", "stderr.log": "err\n"} {
+		if data, _ := os.ReadFile(filepath.Join(dir, name)); string(data) != want {
+			t.Errorf("%s = %q, want %q", name, data, want)
+		}
+	}
+	if !strings.Contains(terminal.String(), "out\n") || !strings.Contains(terminal.String(), "err\n") {
+		t.Errorf("terminal got %q, want both streams", terminal.String())
+	}
+}
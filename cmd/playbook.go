@@ -0,0 +1,624 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	cryptossh "golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+var playbookDryRun bool
+
+// Failure policies of playbook steps
+const (
+	// onFailureAbort stops the playbook after the step when it failed on
+	// any host
+	onFailureAbort = "abort"
+	// onFailureContinue carries on with the next step on every host
+	onFailureContinue = "continue"
+	// onFailureRetry runs the step again, aborting when it still fails
+	onFailureRetry = "retry"
+)
+
+// Defaults of playbook steps
+const (
+	defaultStepRetries     = 3
+	defaultStepRetryDelay  = 5 * time.Second
+	defaultWaitForTimeout  = time.Minute
+	defaultWaitForInterval = 2 * time.Second
+)
+
+// shellNamePattern matches the variable names a shell accepts
+var shellNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// runCmd represents the run command
+var runCmd = &cobra.Command{
+	Use:   "run <playbook.yaml>",
+	Short: "Run a playbook of steps on many hosts",
+	Long: `Run the steps of a YAML playbook in order on a set of hosts, a minimal layer
+on top of gossh exec. Every host finishes a step before the next one starts.
+
+  name: Deploy the app
+  hosts: web                  # inventory groups, unless --hosts or --group is given
+  vars:
+    version: "1.4.2"          # defaults for {name} placeholders; host variables win
+  steps:
+    - name: Stop the service
+      command: systemctl stop app
+    - name: Upload the release
+      copy:
+        src: build/app-{version}.tar.gz
+        dest: /opt/app/app.tar.gz
+    - name: Migrate
+      script: scripts/migrate.sh
+      on_failure: continue
+    - name: Start the service
+      command: systemctl start app
+      timeout: 30s
+    - name: Wait until it listens
+      wait_for:
+        port: 8080
+        timeout: 60s
+      on_failure: retry
+      retries: 2
+      retry_delay: 10s
+
+A step is one of command, a shell command; script, a local sh script run on
+each host with the host's variables set as shell variables; copy, a file or
+(with recursive: true) directory to upload; or wait_for, which polls until a
+TCP port opens on the host (address: defaults to localhost), dialled through
+the connection, or until a command succeeds. Relative script and copy paths
+are relative to the playbook.
+
+on_failure decides what a failed step does: abort (the default) stops the
+playbook once the step finished on every host, continue carries on, and retry
+runs the step again, retries times (3) retry_delay (5s) apart, before aborting.
+
+A report of every host's steps ends the run. gossh run exits with 1 when a
+step failed on some host, unless the step's on_failure is continue.
+
+Examples:
+  # Show what the playbook would do on the web group, without connecting
+  gossh run deploy.yaml --group web --dry-run
+
+  # Run it on the hosts of a hosts file, 20 at a time
+  gossh run deploy.yaml --hosts hosts.txt --parallel 20`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		if fleetParallel < 1 {
+			fail(errors.New("--parallel must be at least 1"))
+		}
+		pb, err := loadPlaybook(args[0])
+		if err != nil {
+			fail(err)
+		}
+		if hostsFile == "" && len(inventoryGroups) == 0 {
+			inventoryGroups = pb.groups()
+		}
+		hosts, err := selectFleetHosts()
+		if err != nil {
+			fail(err)
+		}
+
+		r := &playbookRun{pb: pb, parallel: fleetParallel, out: os.Stdout}
+		if playbookDryRun {
+			r.plan(hosts)
+			return
+		}
+		c, err := newFleetConnector(cmd.Flags())
+		if err != nil {
+			fail(err)
+		}
+		r.dial = fleetDialer(c)
+		reports := r.run(hosts)
+		fmt.Println()
+		printPlaybookReport(os.Stdout, reports)
+
+		if r.abortedAt >= 0 {
+			var failed []string
+			for _, report := range reports {
+				if report.fatal {
+					failed = append(failed, report.host)
+				}
+			}
+			fmt.Println(errorColor("✗ ") + fmt.Sprintf("Playbook aborted at step %d, %s, failed on %s", r.abortedAt+1,
+				pb.Steps[r.abortedAt].title(), strings.Join(failed, ", ")))
+			os.Exit(exitFleetCommandFailed)
+		}
+		fmt.Println(successColor("✓ ") + fmt.Sprintf("Playbook finished on all %d hosts", len(reports)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+
+	addHostSelectionFlags(runCmd.Flags())
+	runCmd.Flags().IntVar(&fleetParallel, "parallel", 10, "Number of hosts to run each step on at once")
+	runCmd.Flags().BoolVarP(&playbookDryRun, "dry-run", "n", false, "Print what every step would do on every host, without connecting")
+	runCmd.Flags().StringVarP(&user, "user", "u", "", "SSH username for hosts that don't name one (default: the SSH config's User, or the local user)")
+	addConnectionFlags(runCmd.Flags(), "p", "k")
+}
+
+// playbookDuration is a duration written like 30s or 5m
+type playbookDuration time.Duration
+
+func (d *playbookDuration) UnmarshalYAML(node *yaml.Node) error {
+	duration, err := time.ParseDuration(node.Value)
+	if err != nil || duration < 0 {
+		return fmt.Errorf("line %d: invalid duration %q", node.Line, node.Value)
+	}
+	*d = playbookDuration(duration)
+	return nil
+}
+
+// playbook is an ordered list of steps to run on hosts
+type playbook struct {
+	Name string `yaml:"name"`
+	// Hosts are comma-separated inventory groups
+	Hosts string            `yaml:"hosts"`
+	Vars  map[string]string `yaml:"vars"`
+	Steps []*playbookStep   `yaml:"steps"`
+}
+
+// playbookStep is one step of a playbook, with exactly one of Command,
+// Script, Copy and WaitFor
+type playbookStep struct {
+	Name    string           `yaml:"name"`
+	Command string           `yaml:"command"`
+	Script  string           `yaml:"script"`
+	Copy    *copyStep        `yaml:"copy"`
+	WaitFor *waitForStep     `yaml:"wait_for"`
+	Timeout playbookDuration `yaml:"timeout"`
+
+	OnFailure  string           `yaml:"on_failure"`
+	Retries    int              `yaml:"retries"`
+	RetryDelay playbookDuration `yaml:"retry_delay"`
+
+	// script is the script's content
+	script []byte
+}
+
+// copyStep uploads a file or directory
+type copyStep struct {
+	Src       string `yaml:"src"`
+	Dest      string `yaml:"dest"`
+	Recursive bool   `yaml:"recursive"`
+	Preserve  bool   `yaml:"preserve"`
+}
+
+// waitForStep polls until a port on the host accepts connections or a
+// command succeeds
+type waitForStep struct {
+	Port     int              `yaml:"port"`
+	Address  string           `yaml:"address"`
+	Command  string           `yaml:"command"`
+	Timeout  playbookDuration `yaml:"timeout"`
+	Interval playbookDuration `yaml:"interval"`
+}
+
+// loadPlaybook reads and checks the playbook at path, reading its scripts
+// and resolving its local paths against the playbook's directory
+func loadPlaybook(path string) (*playbook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read playbook: %s", err)
+	}
+	pb, err := parsePlaybook(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return pb, nil
+}
+
+// parsePlaybook decodes a playbook, filling in the steps' defaults. Relative
+// local paths are relative to dir.
+func parsePlaybook(data []byte, dir string) (*playbook, error) {
+	var pb playbook
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&pb); err != nil {
+		return nil, err
+	}
+	if len(pb.Steps) == 0 {
+		return nil, errors.New("the playbook has no steps")
+	}
+	local := func(p string) string {
+		if filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	for i, step := range pb.Steps {
+		if step == nil {
+			return nil, fmt.Errorf("step %d is empty", i+1)
+		}
+		kinds := 0
+		for _, set := range []bool{step.Command != "", step.Script != "", step.Copy != nil, step.WaitFor != nil} {
+			if set {
+				kinds++
+			}
+		}
+		if kinds != 1 {
+			return nil, fmt.Errorf("step %d needs exactly one of command, script, copy and wait_for", i+1)
+		}
+
+		switch {
+		case step.Script != "":
+			step.Script = local(step.Script)
+			var err error
+			if step.script, err = os.ReadFile(step.Script); err != nil {
+				return nil, fmt.Errorf("step %d: failed to read script: %s", i+1, err)
+			}
+		case step.Copy != nil:
+			if step.Copy.Src == "" || step.Copy.Dest == "" {
+				return nil, fmt.Errorf("step %d: copy needs src and dest", i+1)
+			}
+			step.Copy.Src = local(step.Copy.Src)
+		case step.WaitFor != nil:
+			w := step.WaitFor
+			if (w.Port == 0) == (w.Command == "") {
+				return nil, fmt.Errorf("step %d: wait_for needs either port or command", i+1)
+			}
+			if w.Port < 0 || w.Port > 65535 {
+				return nil, fmt.Errorf("step %d: invalid port %d", i+1, w.Port)
+			}
+			if w.Address == "" {
+				w.Address = "localhost"
+			}
+			if w.Timeout == 0 {
+				w.Timeout = playbookDuration(defaultWaitForTimeout)
+			}
+			if w.Interval == 0 {
+				w.Interval = playbookDuration(defaultWaitForInterval)
+			}
+		}
+
+		switch step.OnFailure {
+		case "":
+			step.OnFailure = onFailureAbort
+		case onFailureAbort, onFailureContinue:
+		case onFailureRetry:
+			if step.Retries == 0 {
+				step.Retries = defaultStepRetries
+			}
+			if step.RetryDelay == 0 {
+				step.RetryDelay = playbookDuration(defaultStepRetryDelay)
+			}
+		default:
+			return nil, fmt.Errorf("step %d: invalid on_failure %q (use abort, continue or retry)", i+1, step.OnFailure)
+		}
+		if step.Retries < 0 {
+			return nil, fmt.Errorf("step %d: retries can't be negative", i+1)
+		}
+	}
+	return &pb, nil
+}
+
+// groups returns the inventory groups of the playbook's hosts
+func (pb *playbook) groups() []string {
+	var groups []string
+	for _, g := range strings.Split(pb.Hosts, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// vars returns the variables of a host, over the playbook's
+func (pb *playbook) vars(h fleetHost) map[string]string {
+	vars := maps.Clone(pb.Vars)
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	maps.Copy(vars, h.vars)
+	return vars
+}
+
+// title names the step, by its name or else what it does
+func (s *playbookStep) title() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.describe(nil)
+}
+
+// describe says what the step does, with vars filled in
+func (s *playbookStep) describe(vars map[string]string) string {
+	switch {
+	case s.Command != "":
+		return "run " + expandHostVars(s.Command, vars)
+	case s.Script != "":
+		return "run script " + filepath.Base(s.Script)
+	case s.Copy != nil:
+		return "copy " + expandHostVars(s.Copy.Src, vars) + " to " + expandHostVars(s.Copy.Dest, vars)
+	case s.WaitFor.Command != "":
+		return "wait until " + expandHostVars(s.WaitFor.Command, vars) + " succeeds"
+	default:
+		return fmt.Sprintf("wait for port %d on %s", s.WaitFor.Port, expandHostVars(s.WaitFor.Address, vars))
+	}
+}
+
+// playbookRun runs a playbook on hosts
+type playbookRun struct {
+	pb       *playbook
+	dial     func(fleetHost, *bannerCollector) (*cryptossh.Client, error)
+	parallel int
+	out      io.Writer
+	// abortedAt is the step the playbook was aborted at, or -1
+	abortedAt int
+
+	mu sync.Mutex
+}
+
+// hostReport is how the playbook went on one host
+type hostReport struct {
+	host    string
+	ok      int
+	failed  int
+	skipped int
+	// fatal is set when a step failed whose failures aren't let go
+	fatal    bool
+	failure  string
+	duration time.Duration
+}
+
+// playbookHost is a host of a playbook run, with its connection, which
+// steps share
+type playbookHost struct {
+	fleetHost
+	vars   map[string]string
+	client *cryptossh.Client
+	report hostReport
+}
+
+// plan prints what every step would do on every host
+func (r *playbookRun) plan(hosts []fleetHost) {
+	for i, step := range r.pb.Steps {
+		fmt.Fprintf(r.out, "Step %d/%d: %s (on failure: %s)\n", i+1, len(r.pb.Steps), step.title(), step.OnFailure)
+		for _, h := range hosts {
+			fmt.Fprintf(r.out, "  %s: %s\n", h.name, step.describe(r.pb.vars(h)))
+		}
+	}
+}
+
+// run runs the steps in order, each on all hosts, at most parallel hosts at
+// a time, and returns a report per host
+func (r *playbookRun) run(hosts []fleetHost) []hostReport {
+	infoColor := color.New(color.FgCyan).SprintFunc()
+	width := 0
+	runs := make([]*playbookHost, len(hosts))
+	for i, h := range hosts {
+		width = max(width, len(h.name))
+		runs[i] = &playbookHost{fleetHost: h, vars: r.pb.vars(h), report: hostReport{host: h.name}}
+	}
+	defer func() {
+		for _, h := range runs {
+			if h.client != nil {
+				h.client.Close()
+			}
+		}
+	}()
+
+	r.abortedAt = -1
+	for i, step := range r.pb.Steps {
+		if r.abortedAt >= 0 {
+			for _, h := range runs {
+				h.report.skipped++
+			}
+			continue
+		}
+		fmt.Fprintln(r.out, infoColor("▶ ")+fmt.Sprintf("Step %d/%d: %s", i+1, len(r.pb.Steps), step.title()))
+		abort := false
+		runParallel(len(runs), r.parallel, func(j int) {
+			h := runs[j]
+			start := time.Now()
+			err := r.runStep(h, step, width)
+			h.report.duration += time.Since(start)
+			r.reportStep(h, err, time.Since(start))
+			if err == nil {
+				h.report.ok++
+				return
+			}
+			h.report.failed++
+			// The failure that stopped the playbook outranks earlier ones
+			fatal := step.OnFailure != onFailureContinue
+			if h.report.failure == "" || fatal {
+				h.report.failure = fmt.Sprintf("step %d, %s: %s", i+1, step.title(), err)
+			}
+			if fatal {
+				h.report.fatal = true
+				r.mu.Lock()
+				abort = true
+				r.mu.Unlock()
+			}
+		})
+		if abort {
+			r.abortedAt = i
+		}
+	}
+
+	reports := make([]hostReport, len(runs))
+	for i, h := range runs {
+		reports[i] = h.report
+	}
+	return reports
+}
+
+// runStep runs step on h, again after failures when its policy is retry
+func (r *playbookRun) runStep(h *playbookHost, step *playbookStep, width int) error {
+	warningColor := color.New(color.FgYellow).SprintFunc()
+	attempts := 1
+	if step.OnFailure == onFailureRetry {
+		attempts += step.Retries
+	}
+	for attempt := 1; ; attempt++ {
+		err := r.runStepOnce(h, step, width)
+		if err == nil || attempt == attempts {
+			return err
+		}
+		r.mu.Lock()
+		fmt.Fprintln(r.out, warningColor("⚠ ")+fmt.Sprintf("%s: %s; retrying in %s (%d of %d)", h.name, err, time.Duration(step.RetryDelay), attempt, step.Retries))
+		r.mu.Unlock()
+		time.Sleep(time.Duration(step.RetryDelay))
+	}
+}
+
+// runStepOnce runs step on h, connecting first when h has no connection.
+// A connection that stops answering is dropped, to be made again.
+func (r *playbookRun) runStepOnce(h *playbookHost, step *playbookStep, width int) error {
+	if h.client == nil {
+		client, err := r.dial(h.fleetHost, nil)
+		if err != nil {
+			return err
+		}
+		h.client = client
+	}
+	err := r.runOn(h, step, width)
+	if err != nil {
+		if _, _, aliveErr := h.client.SendRequest("keepalive@openssh.com", true, nil); aliveErr != nil {
+			h.client.Close()
+			h.client = nil
+		}
+	}
+	return err
+}
+
+// runOn runs step on h's connection
+func (r *playbookRun) runOn(h *playbookHost, step *playbookStep, width int) error {
+	switch {
+	case step.Command != "":
+		return r.runCommand(h, expandHostVars(step.Command, h.vars), nil, time.Duration(step.Timeout), width)
+	case step.Script != "":
+		// The host's variables come first, as shell variables
+		var script bytes.Buffer
+		for _, name := range slices.Sorted(maps.Keys(h.vars)) {
+			if shellNamePattern.MatchString(name) {
+				fmt.Fprintf(&script, "%s=%s\n", name, shellQuote(h.vars[name]))
+			}
+		}
+		script.Write(step.script)
+		return r.runCommand(h, "sh -s", &script, time.Duration(step.Timeout), width)
+	case step.Copy != nil:
+		return r.copy(h, step.Copy)
+	default:
+		return r.waitFor(h, step.WaitFor)
+	}
+}
+
+// runCommand runs command on h with stdin, printing its output prefixed
+// with the host
+func (r *playbookRun) runCommand(h *playbookHost, command string, stdin io.Reader, timeout time.Duration, width int) error {
+	session, err := h.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create session: %s", err)
+	}
+	defer session.Close()
+	prefix := color.CyanString("%-*s", width, h.name) + " | "
+	stdout := &prefixWriter{prefix: prefix, w: r.out, mu: &r.mu}
+	stderr := &prefixWriter{prefix: prefix, w: r.out, mu: &r.mu}
+	session.Stdin, session.Stdout, session.Stderr = stdin, stdout, stderr
+	err = runWithTimeout(session, command, timeout)
+	stdout.Flush()
+	stderr.Flush()
+	return err
+}
+
+// copy uploads a copy step's files to h
+func (r *playbookRun) copy(h *playbookHost, step *copyStep) error {
+	client, err := sftp.NewClient(h.client)
+	if err != nil {
+		return fmt.Errorf("failed to start SFTP: %s", err)
+	}
+	defer client.Close()
+	c := &copier{src: localFS{}, dst: remoteFS{client}, recursive: step.Recursive, preserve: step.Preserve}
+	return c.copy(expandHostVars(step.Src, h.vars), expandHostVars(step.Dest, h.vars))
+}
+
+// waitFor polls until the port opens or the command succeeds on h, or the
+// step's timeout passes
+func (r *playbookRun) waitFor(h *playbookHost, step *waitForStep) error {
+	deadline := time.Now().Add(time.Duration(step.Timeout))
+	addr := net.JoinHostPort(expandHostVars(step.Address, h.vars), strconv.Itoa(step.Port))
+	for {
+		var err error
+		if step.Command != "" {
+			// Polls aren't worth printing
+			var session *cryptossh.Session
+			if session, err = h.client.NewSession(); err != nil {
+				return fmt.Errorf("failed to create session: %s", err)
+			}
+			err = runWithTimeout(session, expandHostVars(step.Command, h.vars), time.Until(deadline))
+			session.Close()
+		} else {
+			var conn net.Conn
+			if conn, err = h.client.Dial("tcp", addr); err == nil {
+				conn.Close()
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if errors.Is(err, errCommandTimeout) || time.Now().Add(time.Duration(step.Interval)).After(deadline) {
+			return fmt.Errorf("still waiting after %s: %s", time.Duration(step.Timeout), err)
+		}
+		time.Sleep(time.Duration(step.Interval))
+	}
+}
+
+// reportStep prints how a step went on a host
+func (r *playbookRun) reportStep(h *playbookHost, err error, took time.Duration) {
+	errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+	successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		fmt.Fprintln(r.out, errorColor("  ✗ ")+h.name+": "+err.Error())
+		return
+	}
+	fmt.Fprintln(r.out, successColor("  ✓ ")+fmt.Sprintf("%s (%s)", h.name, took.Round(time.Millisecond)))
+}
+
+// printPlaybookReport writes a table of how the playbook went on each host
+func printPlaybookReport(out io.Writer, reports []hostReport) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tOK\tFAILED\tSKIPPED\tTIME\tDETAIL")
+	for _, r := range reports {
+		status := "ok"
+		if r.fatal {
+			status = "failed"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%s\t%s\n", r.host, status, r.ok, r.failed, r.skipped,
+			r.duration.Round(time.Millisecond), orDash(r.failure))
+	}
+	w.Flush()
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,231 @@
+// cmd/playbook_test.go
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	"github.com/fatih/color"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func TestParsePlaybook(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "setup.sh"), "echo setup\n", 0o644)
+	pb, err := parsePlaybook([]byte(`
+hosts: web, db
+steps:
+  - script: setup.sh
+  - copy: {src: app.conf, dest: /etc/app.conf}
+  - wait_for: {port: 8080}
+    on_failure: retry
+  - command: uptime
+    on_failure: continue
+    timeout: 30s
+`), dir)
+	if err != nil {
+		t.Fatalf("parsePlaybook failed: %v", err)
+	}
+	if got := pb.groups(); len(got) != 2 || got[0] != "web" || got[1] != "db" {
+		t.Errorf("groups = %q", got)
+	}
+	script, cp, wait, command := pb.Steps[0], pb.Steps[1], pb.Steps[2], pb.Steps[3]
+	if string(script.script) != "echo setup\n" || script.OnFailure != onFailureAbort || script.title() != "run script setup.sh" {
+		t.Errorf("script step = %+v", script)
+	}
+	if cp.Copy.Src != filepath.Join(dir, "app.conf") || cp.Copy.Dest != "/etc/app.conf" {
+		t.Errorf("copy step = %+v", cp.Copy)
+	}
+	if wait.WaitFor.Address != "localhost" || time.Duration(wait.WaitFor.Timeout) != defaultWaitForTimeout ||
+		wait.Retries != defaultStepRetries || time.Duration(wait.RetryDelay) != defaultStepRetryDelay {
+		t.Errorf("wait_for step = %+v, %+v", wait, wait.WaitFor)
+	}
+	if command.OnFailure != onFailureContinue || time.Duration(command.Timeout) != 30*time.Second || command.Retries != 0 {
+		t.Errorf("command step = %+v", command)
+	}
+
+	for _, input := range []string{
+		"steps: []",
+		"steps:\n  - name: nothing\n",
+		"steps:\n  - command: a\n    script: setup.sh\n",
+		"steps:\n  - command: a\n    on_failure: ignore\n",
+		"steps:\n  - command: a\n    timeout: soon\n",
+		"steps:\n  - command: a\n    retries: -1\n",
+		"steps:\n  - comand: a\n",
+		"steps:\n  - script: missing.sh\n",
+		"steps:\n  - copy: {src: a}\n",
+		"steps:\n  - wait_for: {port: 80, command: true}\n",
+		"steps:\n  - wait_for: {timeout: 5s}\n",
+		"steps:\n  - wait_for: {port: 70000}\n",
+	} {
+		if _, err := parsePlaybook([]byte(input), dir); err == nil {
+			t.Errorf("parsePlaybook(%q) should fail", input)
+		}
+	}
+}
+
+// playbookHosts are two hosts with different variables
+var playbookHosts = []fleetHost{
+	{name: "web1", host: "web1", vars: map[string]string{"host": "web1", "role": "frontend"}},
+	{name: "web2", host: "web2", vars: map[string]string{"host": "web2", "role": "it's a canary"}},
+}
+
+func TestPlaybookRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh", SFTP: true, AllowLocalForwarding: true})
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "role.sh"), `echo "$host is $role"`+"\n", 0o644)
+	writeFile(t, filepath.Join(dir, "app.conf"), "setting=1\n", 0o644)
+	_, echoPort, _ := net.SplitHostPort(startEcho(t))
+
+	pb, err := parsePlaybook([]byte(fmt.Sprintf(`
+vars:
+  role: default
+  dir: %s
+steps:
+  - command: echo {host} {dir}
+  - script: role.sh
+  - copy: {src: app.conf, dest: "{dir}/{host}.conf"}
+  - wait_for: {port: %s, address: 127.0.0.1}
+  - command: exit 3
+    on_failure: continue
+  - name: Flaky
+    command: test -f {dir}/{host}.tried || { touch {dir}/{host}.tried; exit 1; }
+    on_failure: retry
+    retry_delay: 10ms
+  - name: Fails on web2
+    command: test {host} = web1
+  - command: touch {dir}/skipped
+`, dir, echoPort)), dir)
+	if err != nil {
+		t.Fatalf("parsePlaybook failed: %v", err)
+	}
+	var out bytes.Buffer
+	dials := 0
+	r := &playbookRun{
+		pb: pb,
+		dial: func(fleetHost, *bannerCollector) (*cryptossh.Client, error) {
+			dials++
+			return cryptossh.Dial("tcp", srv.Addr, srv.ClientConfig)
+		},
+		parallel: 1,
+		out:      &out,
+	}
+	reports := r.run(playbookHosts)
+
+	if r.abortedAt != 6 {
+		t.Errorf("aborted at step %d, want 7", r.abortedAt+1)
+	}
+	if dials != 2 {
+		t.Errorf("dialled %d times, want once per host", dials)
+	}
+	want := []hostReport{
+		{host: "web1", ok: 6, failed: 1, skipped: 1, failure: "step 5, run exit 3: Process exited with status 3"},
+		{host: "web2", ok: 5, failed: 2, skipped: 1, fatal: true, failure: "step 7, Fails on web2: Process exited with status 1"},
+	}
+	for i := range want {
+		reports[i].duration = 0
+		if reports[i] != want[i] {
+			t.Errorf("report = %+v, want %+v", reports[i], want[i])
+		}
+	}
+	for _, want := range []string{
+		"web1 | web1 " + dir + "\n",
+		"web1 | web1 is frontend\n",
+		"web2 | web2 is it's a canary\n",
+		"web1: Process exited with status 1; retrying in 10ms (1 of 3)",
+		"✗ web2: Process exited with status 1",
+		"Step 8/8",
+	} {
+		if strings.Contains(want, "Step 8") {
+			if strings.Contains(out.String(), want) {
+				t.Errorf("output shows a step after the abort:\n%s", out.String())
+			}
+		} else if !strings.Contains(out.String(), want) {
+			t.Errorf("output is missing %q:\n%s", want, out.String())
+		}
+	}
+	for _, name := range []string{"web1", "web2"} {
+		if data, err := os.ReadFile(filepath.Join(dir, name+".conf")); err != nil || string(data) != "setting=1\n" {
+			t.Errorf("copied %s.conf = %q, %v", name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "skipped")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the step after the abort ran: %v", err)
+	}
+
+	var table bytes.Buffer
+	printPlaybookReport(&table, want)
+	if !strings.Contains(table.String(), "web2  failed  5   2       1        0s    step 7, Fails on web2") {
+		t.Errorf("report table:\n%s", table.String())
+	}
+}
+
+func TestPlaybookWaitForTimeout(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{AllowLocalForwarding: true})
+	pb, err := parsePlaybook([]byte(fmt.Sprintf(`
+steps:
+  - wait_for: {port: %s, address: 127.0.0.1, timeout: 300ms, interval: 50ms}
+`, strings.TrimPrefix(freeAddr(t), "127.0.0.1:"))), ".")
+	if err != nil {
+		t.Fatalf("parsePlaybook failed: %v", err)
+	}
+	r := &playbookRun{
+		pb:       pb,
+		dial:     func(fleetHost, *bannerCollector) (*cryptossh.Client, error) { return srv.Dial(t), nil },
+		parallel: 2,
+		out:      &bytes.Buffer{},
+	}
+	start := time.Now()
+	reports := r.run(playbookHosts)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("waiting took %s", elapsed)
+	}
+	if !reports[0].fatal || !strings.Contains(reports[0].failure, "still waiting after 300ms") || r.abortedAt != 0 {
+		t.Errorf("report = %+v, aborted at %d", reports[0], r.abortedAt)
+	}
+}
+
+func TestPlaybookPlan(t *testing.T) {
+	pb, err := parsePlaybook([]byte(`
+vars: {version: "1.2"}
+steps:
+  - name: Upload
+    copy:
+      src: /build/app-{version}.tgz
+      dest: /opt/{host}.tgz
+  - wait_for:
+      command: curl -fs localhost/{role}
+    on_failure: continue
+`), ".")
+	if err != nil {
+		t.Fatalf("parsePlaybook failed: %v", err)
+	}
+	var out bytes.Buffer
+	r := &playbookRun{pb: pb, out: &out}
+	r.plan(playbookHosts)
+	want := `Step 1/2: Upload (on failure: abort)
+  web1: copy /build/app-1.2.tgz to /opt/web1.tgz
+  web2: copy /build/app-1.2.tgz to /opt/web2.tgz
+Step 2/2: wait until curl -fs localhost/{role} succeeds (on failure: continue)
+  web1: wait until curl -fs localhost/frontend succeeds
+  web2: wait until curl -fs localhost/it's a canary succeeds
+`
+	if out.String() != want {
+		t.Errorf("plan =\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,319 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// profileNamePattern limits profile names to what YAML keys hold unquoted.
+// Viper lower-cases keys, so names are case-insensitive.
+var profileNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+var (
+	profilesFile string
+	newProfile   profile
+)
+
+// profile is a named set of client connection settings in ~/.gossh/config.yaml
+type profile struct {
+	Host string `mapstructure:"host"`
+	Port string `mapstructure:"port"`
+	User string `mapstructure:"user"`
+	// Keys are identity files, or vault:// key sources, tried in order
+	Keys []string `mapstructure:"keys"`
+	// Jump hosts are [user@]host[:port] entries connected through in order
+	Jump []string `mapstructure:"jump"`
+	// Forwards are local forwards, [bind_address:]port:host:hostport
+	Forwards []string `mapstructure:"forwards"`
+	// Env holds NAME=VALUE variables set in the remote session
+	Env []string `mapstructure:"env"`
+}
+
+// settings returns the profile's non-empty fields keyed as in the file
+func (p profile) settings() map[string]any {
+	settings := map[string]any{}
+	for key, value := range map[string]string{"host": p.Host, "port": p.Port, "user": p.User} {
+		if value != "" {
+			settings[key] = value
+		}
+	}
+	for key, values := range map[string][]string{"keys": p.Keys, "jump": p.Jump, "forwards": p.Forwards, "env": p.Env} {
+		if len(values) > 0 {
+			settings[key] = values
+		}
+	}
+	return settings
+}
+
+// apply fills in the client flags that were not set on the command line
+func (p profile) apply(flags *pflag.FlagSet) {
+	setString := func(name string, target *string, value string) {
+		if value != "" && !flags.Changed(name) {
+			*target = value
+		}
+	}
+	setString("host", &host, p.Host)
+	setString("port", &port, p.Port)
+	setString("user", &user, p.User)
+	setString("jump", &jumpHosts, strings.Join(p.Jump, ","))
+	if len(p.Keys) > 0 && !flags.Changed("key") {
+		clientKeyPaths = p.Keys
+	}
+	// Forwards add up; variables set on the command line win
+	localForwards = append(slices.Clone(p.Forwards), localForwards...)
+	setEnv = append(slices.Clone(p.Env), setEnv...)
+}
+
+// defaultProfilesFile returns the path of ~/.gossh/config.yaml
+func defaultProfilesFile() (string, error) {
+	if profilesFile != "" {
+		return profilesFile, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".gossh", "config.yaml"), nil
+}
+
+// readProfilesConfig reads the config file at path. A missing file reads as
+// empty.
+func readProfilesConfig(path string) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return v, nil
+}
+
+// loadProfiles returns the profiles in the config file at path
+func loadProfiles(path string) (map[string]profile, error) {
+	v, err := readProfilesConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	var config struct {
+		Profiles map[string]profile `mapstructure:"profiles"`
+	}
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = map[string]profile{}
+	}
+	return config.Profiles, nil
+}
+
+// lookupProfile returns the named profile from the config file at path
+func lookupProfile(path, name string) (profile, error) {
+	profiles, err := loadProfiles(path)
+	if err != nil {
+		return profile{}, err
+	}
+	p, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return profile{}, fmt.Errorf("no profile named %q in %s", name, path)
+	}
+	return p, nil
+}
+
+// saveProfiles writes profiles to the config file at path, keeping the
+// file's other settings
+func saveProfiles(path string, profiles map[string]profile) error {
+	current, err := readProfilesConfig(path)
+	if err != nil {
+		return err
+	}
+	// A fresh instance, since the read one still holds removed profiles
+	v := viper.New()
+	for key, value := range current.AllSettings() {
+		if key != "profiles" {
+			v.Set(key, value)
+		}
+	}
+	settings := map[string]any{}
+	for name, p := range profiles {
+		settings[name] = p.settings()
+	}
+	v.Set("profiles", settings)
+	v.SetConfigPermissions(0o600)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return v.WriteConfigAs(path)
+}
+
+// validateProfile checks a profile's name and fields before it is saved
+func validateProfile(name string, p profile) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	if p.Host == "" {
+		return errors.New("a profile needs a host")
+	}
+	for _, spec := range p.Forwards {
+		if _, err := parseLocalForward(spec); err != nil {
+			return err
+		}
+	}
+	if _, err := sessionEnv(nil, p.Env, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
+// profilesCmd represents the profiles command
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Manage named client connection profiles",
+	Long: `Profiles are named connection settings - host, user, keys, jump hosts, forwards
+and environment - kept in ~/.gossh/config.yaml and used with gossh client --profile.
+Flags given to the client override the profile's settings.
+
+Examples:
+  # Save a profile for a database host behind a bastion
+  gossh profiles add prod-db --host db.internal --user admin --key ~/.ssh/id_ed25519 \
+    --jump ops@bastion.example.com -L 5432:localhost:5432 --env APP_ENV=production
+
+  # Connect with it
+  gossh client --profile prod-db
+
+  # List and remove profiles
+  gossh profiles list
+  gossh profiles remove prod-db`,
+}
+
+// profilesListCmd represents the profiles list command
+var profilesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the saved profiles",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		path := requireProfilesFile()
+		profiles, err := loadProfiles(path)
+		if err != nil {
+			fmt.Printf("Failed to read profiles: %s\n", err)
+			os.Exit(1)
+		}
+		if len(profiles) == 0 {
+			fmt.Println("No profiles in " + path)
+			return
+		}
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tHOST\tUSER\tPORT\tJUMP\tFORWARDS")
+		for _, name := range names {
+			p := profiles[name]
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", name, p.Host, orDash(p.User), orDash(p.Port),
+				orDash(strings.Join(p.Jump, ",")), orDash(strings.Join(p.Forwards, " ")))
+		}
+		w.Flush()
+	},
+}
+
+// profilesAddCmd represents the profiles add command
+var profilesAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Save a profile, replacing any profile of the same name",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := strings.ToLower(args[0])
+		if err := validateProfile(name, newProfile); err != nil {
+			fmt.Printf("Invalid profile: %s\n", err)
+			os.Exit(1)
+		}
+		path := requireProfilesFile()
+		profiles, err := loadProfiles(path)
+		if err != nil {
+			fmt.Printf("Failed to read profiles: %s\n", err)
+			os.Exit(1)
+		}
+		_, replaced := profiles[name]
+		profiles[name] = newProfile
+		if err := saveProfiles(path, profiles); err != nil {
+			fmt.Printf("Failed to save profile: %s\n", err)
+			os.Exit(1)
+		}
+		if replaced {
+			fmt.Printf("Updated profile %s in %s\n", name, path)
+		} else {
+			fmt.Printf("Added profile %s to %s\n", name, path)
+		}
+	},
+}
+
+// profilesRemoveCmd represents the profiles remove command
+var profilesRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Delete a saved profile",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := strings.ToLower(args[0])
+		path := requireProfilesFile()
+		profiles, err := loadProfiles(path)
+		if err != nil {
+			fmt.Printf("Failed to read profiles: %s\n", err)
+			os.Exit(1)
+		}
+		if _, ok := profiles[name]; !ok {
+			fmt.Printf("No profile named %s in %s\n", name, path)
+			os.Exit(1)
+		}
+		delete(profiles, name)
+		if err := saveProfiles(path, profiles); err != nil {
+			fmt.Printf("Failed to save profiles: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Removed profile %s\n", name)
+	},
+}
+
+// requireProfilesFile returns the profiles file path, exiting when there is none
+func requireProfilesFile() string {
+	path, err := defaultProfilesFile()
+	if err != nil {
+		fmt.Printf("Failed to locate the profiles file: %s\n", err)
+		os.Exit(1)
+	}
+	return path
+}
+
+// orDash returns s, or "-" when it is empty
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func init() {
+	rootCmd.AddCommand(profilesCmd)
+	profilesCmd.AddCommand(profilesListCmd, profilesAddCmd, profilesRemoveCmd)
+
+	profilesCmd.PersistentFlags().StringVar(&profilesFile, "profiles-file", "", "Profiles file (default: ~/.gossh/config.yaml)")
+
+	profilesAddCmd.Flags().StringVarP(&newProfile.Host, "host", "H", "", "SSH server hostname, or a Host alias from ~/.ssh/config")
+	profilesAddCmd.Flags().StringVarP(&newProfile.Port, "port", "p", "", "SSH server port")
+	profilesAddCmd.Flags().StringVarP(&newProfile.User, "user", "u", "", "SSH username")
+	profilesAddCmd.Flags().StringArrayVarP(&newProfile.Keys, "key", "k", nil, "Private key to log in with (repeatable)")
+	profilesAddCmd.Flags().StringArrayVarP(&newProfile.Jump, "jump", "J", nil, "Jump host, [user@]host[:port] (repeatable, connected through in order)")
+	profilesAddCmd.Flags().StringArrayVarP(&newProfile.Forwards, "local-forward", "L", nil, "Local forward, [bind_address:]port:host:hostport (repeatable)")
+	profilesAddCmd.Flags().StringArrayVar(&newProfile.Env, "env", nil, "Environment variable to set in the remote session, NAME=VALUE (repeatable)")
+	profilesAddCmd.MarkFlagRequired("host")
+}
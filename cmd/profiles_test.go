@@ -0,0 +1,124 @@
+// cmd/profiles_test.go
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestProfilesRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gossh", "config.yaml")
+	if profiles, err := loadProfiles(path); err != nil || len(profiles) != 0 {
+		t.Fatalf("a missing file should hold no profiles, got %v, %v", profiles, err)
+	}
+
+	prod := profile{
+		Host:     "db.internal",
+		Port:     "2222",
+		User:     "admin",
+		Keys:     []string{"~/.ssh/id_ed25519"},
+		Jump:     []string{"ops@bastion:2200", "gateway"},
+		Forwards: []string{"5432:localhost:5432"},
+		Env:      []string{"APP_ENV=production"},
+	}
+	staging := profile{Host: "staging.example.com"}
+	if err := saveProfiles(path, map[string]profile{"prod-db": prod, "staging": staging}); err != nil {
+		t.Fatalf("saveProfiles failed: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("profiles file mode = %v, %v, want 0600", info.Mode(), err)
+	}
+
+	got, err := lookupProfile(path, "PROD-DB")
+	if err != nil {
+		t.Fatalf("lookupProfile failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, prod) {
+		t.Errorf("lookupProfile = %+v, want %+v", got, prod)
+	}
+
+	// Removing a profile keeps the others and the file's other settings
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append([]byte("editor: vim\n"), data...), 0o600)
+	if err := saveProfiles(path, map[string]profile{"staging": staging}); err != nil {
+		t.Fatalf("saveProfiles failed: %v", err)
+	}
+	if _, err := lookupProfile(path, "prod-db"); err == nil {
+		t.Error("a removed profile should not be found")
+	}
+	if got, err := lookupProfile(path, "staging"); err != nil || !reflect.DeepEqual(got, staging) {
+		t.Errorf("lookupProfile(staging) = %+v, %v", got, err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "editor: vim") {
+		t.Errorf("other settings were dropped:\n%s", data)
+	}
+}
+
+func TestValidateProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		p       profile
+		wantErr bool
+	}{
+		{"prod-db", profile{Host: "db"}, false},
+		{"prod db", profile{Host: "db"}, true},
+		{"prod-db", profile{}, true},
+		{"prod-db", profile{Host: "db", Forwards: []string{"5432"}}, true},
+		{"prod-db", profile{Host: "db", Env: []string{"NOVALUE"}}, true},
+	}
+	for _, tt := range tests {
+		if err := validateProfile(tt.name, tt.p); (err != nil) != tt.wantErr {
+			t.Errorf("validateProfile(%q, %+v) error = %v, wantErr %v", tt.name, tt.p, err, tt.wantErr)
+		}
+	}
+}
+
+func TestProfileApply(t *testing.T) {
+	origHost, origPort, origUser, origKeys := host, port, user, clientKeyPaths
+	origJump, origForwards, origEnv := jumpHosts, localForwards, setEnv
+	defer func() {
+		host, port, user, clientKeyPaths = origHost, origPort, origUser, origKeys
+		jumpHosts, localForwards, setEnv = origJump, origForwards, origEnv
+	}()
+
+	flags := pflag.NewFlagSet("client", pflag.ContinueOnError)
+	flags.StringVar(&host, "host", "", "")
+	flags.StringVar(&port, "port", "22", "")
+	flags.StringVar(&user, "user", "", "")
+	flags.StringVar(&jumpHosts, "jump", "", "")
+	flags.StringArrayVar(&clientKeyPaths, "key", nil, "")
+	flags.StringArrayVar(&localForwards, "local-forward", nil, "")
+	flags.StringArrayVar(&setEnv, "set-env", nil, "")
+	if err := flags.Parse([]string{"--user", "root", "--set-env", "APP_ENV=debug", "--local-forward", "8080:web:80"}); err != nil {
+		t.Fatal(err)
+	}
+
+	profile{
+		Host:     "db.internal",
+		Port:     "2222",
+		User:     "admin",
+		Keys:     []string{"id_db"},
+		Jump:     []string{"bastion", "gateway"},
+		Forwards: []string{"5432:localhost:5432"},
+		Env:      []string{"APP_ENV=production"},
+	}.apply(flags)
+
+	if host != "db.internal" || port != "2222" || user != "root" || jumpHosts != "bastion,gateway" {
+		t.Errorf("applied %s@%s:%s via %q, want the flag's user and the profile's rest", user, host, port, jumpHosts)
+	}
+	if !reflect.DeepEqual(clientKeyPaths, []string{"id_db"}) {
+		t.Errorf("keys = %v, want the profile's", clientKeyPaths)
+	}
+	if want := []string{"5432:localhost:5432", "8080:web:80"}; !reflect.DeepEqual(localForwards, want) {
+		t.Errorf("forwards = %v, want %v", localForwards, want)
+	}
+	env, err := sessionEnv(nil, setEnv, nil)
+	if err != nil || len(env) != 1 || env[0].value != "debug" {
+		t.Errorf("env = %+v, %v, want the flag's APP_ENV", env, err)
+	}
+}
@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// proxyHandshakeTimeout bounds the proxy handshake when the connection has
+// no timeout of its own
+const proxyHandshakeTimeout = 30 * time.Second
+
+// defaultProxyPorts are the ports of proxies given without one
+var defaultProxyPorts = map[string]string{
+	"http":    "80",
+	"socks5":  "1080",
+	"socks5h": "1080",
+}
+
+// parseProxy parses a --proxy URL: http://[user:password@]host[:port] for an
+// HTTP CONNECT proxy, or socks5://[user:password@]host[:port] for a SOCKS5
+// proxy. socks5h:// is accepted as a synonym; host names are always resolved
+// by the proxy, which keeps DNS lookups off the local network, as Tor needs.
+func parseProxy(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %s", raw, err)
+	}
+	defaultPort, ok := defaultProxyPorts[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unsupported proxy %q, want http://, socks5:// or socks5h://", raw)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy %q: no host", raw)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("invalid proxy %q: unexpected path %s", raw, u.Path)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u, nil
+}
+
+// dialProxy connects to addr through proxy, reaching the proxy over network
+// as dialTCP does. A timeout of 0 leaves the handshake with
+// proxyHandshakeTimeout.
+func dialProxy(proxy *url.URL, network, addr string, timeout time.Duration) (net.Conn, error) {
+	conn, err := dialTCP(network, proxy.Host, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %s", proxy.Host, err)
+	}
+	if timeout <= 0 {
+		timeout = proxyHandshakeTimeout
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if proxy.Scheme == "http" {
+		conn, err = httpConnect(conn, addr, proxy.User)
+	} else {
+		err = socks5Connect(conn, addr, proxy.User)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %s", proxy.Host, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// httpConnect asks the HTTP proxy on conn to tunnel to addr with CONNECT. The
+// returned connection replaces conn, since the server may have spoken before
+// the proxy's response was read to its end.
+func httpConnect(conn net.Conn, addr string, user *url.Userinfo) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user != nil {
+		password, _ := user.Password()
+		req.SetBasicAuth(user.Username(), password)
+		req.Header["Proxy-Authorization"] = req.Header["Authorization"]
+		delete(req.Header, "Authorization")
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return conn, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return conn, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were read into r
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// SOCKS5 protocol values (RFC 1928 and RFC 1929)
+const (
+	socks5Version         = 5
+	socks5NoAuth          = 0
+	socks5PasswordAuth    = 2
+	socks5NoAcceptable    = 0xff
+	socks5CmdConnect      = 1
+	socks5IPv4            = 1
+	socks5DomainName      = 3
+	socks5IPv6            = 4
+	socks5PasswordVersion = 1
+
+	// Reply codes of gossh tunnel -D
+	socks5Succeeded           = 0
+	socks5HostUnreachable     = 4
+	socks5CommandNotSupported = 7
+	socks5AddressNotSupported = 8
+)
+
+// socks5Replies explains the failure codes of a SOCKS5 reply
+var socks5Replies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect asks the SOCKS5 proxy on conn to connect to addr, logging
+// in with user if it is given
+func socks5Connect(conn net.Conn, addr string, user *url.Userinfo) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+
+	methods := []byte{socks5NoAuth}
+	if user != nil {
+		methods = append(methods, socks5PasswordAuth)
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return err
+	}
+	if choice[0] != socks5Version {
+		return fmt.Errorf("not a SOCKS5 proxy (version %d)", choice[0])
+	}
+	switch choice[1] {
+	case socks5NoAuth:
+	case socks5PasswordAuth:
+		if user == nil {
+			return errors.New("the proxy requires a username and password")
+		}
+		if err := socks5Login(conn, user); err != nil {
+			return err
+		}
+	case socks5NoAcceptable:
+		return errors.New("the proxy accepts none of the offered authentication methods")
+	default:
+		return fmt.Errorf("the proxy chose unsupported authentication method %d", choice[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %s is too long", host)
+		}
+		req = append(req, socks5DomainName, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5IPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5IPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		reason, ok := socks5Replies[reply[1]]
+		if !ok {
+			reason = fmt.Sprintf("error %d", reply[1])
+		}
+		return fmt.Errorf("connect to %s: %s", addr, reason)
+	}
+	// Skip the address the proxy bound
+	var boundLen int
+	switch reply[3] {
+	case socks5IPv4:
+		boundLen = net.IPv4len
+	case socks5IPv6:
+		boundLen = net.IPv6len
+	case socks5DomainName:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return err
+		}
+		boundLen = int(n[0])
+	default:
+		return fmt.Errorf("unknown address type %d in the proxy's reply", reply[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, boundLen+2))
+	return err
+}
+
+// socks5Login authenticates to a SOCKS5 proxy with a username and password
+func socks5Login(conn net.Conn, user *url.Userinfo) error {
+	password, _ := user.Password()
+	if len(user.Username()) > 255 || len(password) > 255 {
+		return errors.New("proxy username and password must be at most 255 bytes")
+	}
+	req := []byte{socks5PasswordVersion, byte(len(user.Username()))}
+	req = append(req, user.Username()...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var resp [2]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		return err
+	}
+	if resp[1] != 0 {
+		return errors.New("the proxy rejected the username and password")
+	}
+	return nil
+}
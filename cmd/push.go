@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// pushCmd represents the push command
+var pushCmd = &cobra.Command{
+	Use:   "push <local-path> <remote-path>",
+	Short: "Copy files to many hosts in parallel",
+	Long: `Copy a file or directory to every host of a --hosts file or of inventory
+--group groups, several hosts at a time, over SFTP. {name} in either path is
+replaced with the host's variable of that name, such as {host}, the host's
+name, so each host can get its own file. A relative remote path is relative
+to the remote home directory.
+
+Each host is reported as it finishes, followed by a summary table. gossh push
+exits with 1 when the copy failed on some host.
+
+Examples:
+  # Distribute a config file to the web servers
+  gossh push app.conf /etc/app/app.conf --group web
+
+  # Give every host its own certificate, 20 hosts at a time
+  gossh push certs/{host}.pem /etc/ssl/host.pem --hosts hosts.txt --parallel 20`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runFleetTransfer(cmd, args[0], args[1], true)
+	},
+}
+
+// pullCmd represents the pull command
+var pullCmd = &cobra.Command{
+	Use:   "pull <remote-path> <local-path>",
+	Short: "Copy files from many hosts in parallel",
+	Long: `Copy a file or directory from every host of a --hosts file or of inventory
+--group groups, several hosts at a time, over SFTP. {name} in either path is
+replaced with the host's variable of that name, such as {host}, the host's
+name; pulling from several hosts needs a local path that differs per host.
+Local directories are created as needed.
+
+Each host is reported as it finishes, followed by a summary table. gossh pull
+exits with 1 when the copy failed on some host.
+
+Examples:
+  # Collect a log from every host
+  gossh pull /var/log/app.log ./logs/{host}.log --hosts hosts.txt
+
+  # Collect whole directories from the database servers
+  gossh pull -r /etc/postgresql ./config/{host}/ --group db`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		runFleetTransfer(cmd, args[0], args[1], false)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pushCmd, pullCmd)
+
+	for _, cmd := range []*cobra.Command{pushCmd, pullCmd} {
+		addFleetTransferFlags(cmd.Flags())
+	}
+}
+
+// addFleetTransferFlags registers the flags of gossh push and pull
+func addFleetTransferFlags(flags *pflag.FlagSet) {
+	addHostSelectionFlags(flags)
+	flags.IntVar(&fleetParallel, "parallel", 10, "Number of hosts to copy to or from at once")
+	flags.DurationVar(&hostTimeout, "host-timeout", 0, "Give up on a host after this long, connecting included (0 disables)")
+	flags.BoolVarP(&scpRecursive, "recursive", "r", false, "Copy directories and their contents")
+	flags.BoolVarP(&scpPreserve, "preserve", "p", false, "Keep the modes and modification times of the source files")
+	flags.StringVarP(&user, "user", "u", "", "SSH username for hosts that don't name one (default: the SSH config's User, or the local user)")
+	addConnectionFlags(flags, "P", "i")
+}
+
+// runFleetTransfer runs gossh push, or gossh pull when upload is false
+func runFleetTransfer(cmd *cobra.Command, src, dst string, upload bool) {
+	errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+	successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+	fail := func(err error) {
+		log.Error(err)
+		fmt.Println(errorColor("✗ ") + err.Error())
+		os.Exit(1)
+	}
+	switch {
+	case fleetParallel < 1:
+		fail(errors.New("--parallel must be at least 1"))
+	case hostTimeout < 0:
+		fail(errors.New("--host-timeout can't be negative"))
+	}
+	hosts, err := selectFleetHosts()
+	if err != nil {
+		fail(err)
+	}
+	if !upload {
+		if err := checkPullDestinations(hosts, dst); err != nil {
+			fail(err)
+		}
+	}
+
+	c, err := newFleetConnector(cmd.Flags())
+	if err != nil {
+		fail(err)
+	}
+
+	t := &fleetTransfer{
+		dial:        fleetDialer(c),
+		upload:      upload,
+		src:         src,
+		dst:         dst,
+		recursive:   scpRecursive,
+		preserve:    scpPreserve,
+		parallel:    fleetParallel,
+		hostTimeout: hostTimeout,
+		out:         os.Stdout,
+	}
+	results := t.run(hosts)
+	fmt.Println()
+	printTransferSummary(os.Stdout, results)
+
+	var failed []string
+	for _, result := range results {
+		if result.err != nil {
+			failed = append(failed, result.host)
+		}
+	}
+	if len(failed) > 0 {
+		fmt.Println(errorColor("✗ ") + fmt.Sprintf("Failed on %d of %d hosts: %s", len(failed), len(results), strings.Join(failed, ", ")))
+		os.Exit(exitFleetCommandFailed)
+	}
+	fmt.Println(successColor("✓ ") + fmt.Sprintf("Copied on all %d hosts", len(results)))
+}
+
+// checkPullDestinations makes sure the local path dst expands differently
+// for every host, so no host's files overwrite another's
+func checkPullDestinations(hosts []fleetHost, dst string) error {
+	seen := map[string]string{}
+	for _, h := range hosts {
+		path := filepath.Clean(expandHostVars(dst, h.vars))
+		if other, ok := seen[path]; ok {
+			return fmt.Errorf("%s and %s would both be copied to %s: put {host} in the local path", other, h.name, path)
+		}
+		seen[path] = h.name
+	}
+	return nil
+}
+
+// fleetTransfer copies files to or from many hosts at once
+type fleetTransfer struct {
+	dial func(fleetHost, *bannerCollector) (*cryptossh.Client, error)
+	// upload copies from this machine to the hosts rather than back
+	upload bool
+	// src and dst are paths with {name} placeholders for host variables
+	src         string
+	dst         string
+	recursive   bool
+	preserve    bool
+	parallel    int
+	hostTimeout time.Duration
+	// out is where hosts are reported as they finish
+	out io.Writer
+
+	mu sync.Mutex
+}
+
+// transferResult is how the copy to or from one host went
+type transferResult struct {
+	host     string
+	dst      string
+	files    int
+	bytes    int64
+	duration time.Duration
+	err      error
+}
+
+// run copies to or from hosts, at most parallel at a time, and returns
+// their results in the order of hosts
+func (t *fleetTransfer) run(hosts []fleetHost) []transferResult {
+	results := make([]transferResult, len(hosts))
+	runParallel(len(hosts), t.parallel, func(i int) {
+		results[i] = t.runHost(hosts[i])
+		t.report(results[i])
+	})
+	return results
+}
+
+// runHost copies to or from h, within --host-timeout
+func (t *fleetTransfer) runHost(h fleetHost) transferResult {
+	ctx := context.Background()
+	if t.hostTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.hostTimeout)
+		defer cancel()
+	}
+	start := time.Now()
+	src, dst := expandHostVars(t.src, h.vars), expandHostVars(t.dst, h.vars)
+	c := &copier{recursive: t.recursive, preserve: t.preserve}
+	err := t.copyHost(ctx, h, c, src, dst)
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("timed out after %s", t.hostTimeout)
+	}
+	return transferResult{host: h.name, dst: dst, files: c.files, bytes: c.bytes, duration: time.Since(start), err: err}
+}
+
+// copyHost connects to h and copies src to dst with c. The connection is
+// closed when ctx is done, which stops the copy.
+func (t *fleetTransfer) copyHost(ctx context.Context, h fleetHost, c *copier, src, dst string) error {
+	if !t.upload {
+		// A trailing separator names a directory to copy into
+		dir := filepath.Dir(dst)
+		if strings.HasSuffix(dst, "/") || strings.HasSuffix(dst, string(filepath.Separator)) {
+			dir = dst
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %s", dir, err)
+		}
+	}
+
+	client, err := dialContext(ctx, func() (*cryptossh.Client, error) { return t.dial(h, nil) })
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return fmt.Errorf("failed to start SFTP: %s", err)
+	}
+	defer sftpClient.Close()
+
+	c.src, c.dst = localFS{}, remoteFS{sftpClient}
+	if !t.upload {
+		c.src, c.dst = c.dst, c.src
+	}
+	return c.copy(src, dst)
+}
+
+// report prints how the copy to or from a host went
+func (t *fleetTransfer) report(result transferResult) {
+	errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+	successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if result.err != nil {
+		fmt.Fprintln(t.out, errorColor("✗ ")+result.host+": "+result.err.Error())
+		return
+	}
+	fmt.Fprintln(t.out, successColor("✓ ")+fmt.Sprintf("%s: copied %d files, %s", result.host, result.files, formatBytes(result.bytes)))
+}
+
+// printTransferSummary writes a table of the hosts' results, with where
+// the files went or why they didn't
+func printTransferSummary(out io.Writer, results []transferResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tFILES\tSIZE\tTIME\tDETAIL")
+	for _, r := range results {
+		status, detail := "ok", r.dst
+		if r.err != nil {
+			status, detail = "failed", r.err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n", r.host, status, r.files, formatBytes(r.bytes),
+			r.duration.Round(time.Millisecond), detail)
+	}
+	w.Flush()
+}
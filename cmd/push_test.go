@@ -0,0 +1,134 @@
+// cmd/push_test.go
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	"github.com/fatih/color"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func TestCheckPullDestinations(t *testing.T) {
+	hosts := []fleetHost{
+		{name: "web1", vars: map[string]string{"host": "web1", "env": "prod"}},
+		{name: "web2", vars: map[string]string{"host": "web2", "env": "prod"}},
+	}
+	tests := []struct {
+		dst     string
+		wantErr bool
+	}{
+		{"logs/{host}.log", false},
+		{"logs/{host}/", false},
+		{"logs/app.log", true},
+		{"logs/{env}/app.log", true},
+		{"logs/{missing}.log", true},
+	}
+	for _, tt := range tests {
+		if err := checkPullDestinations(hosts, tt.dst); (err != nil) != tt.wantErr {
+			t.Errorf("checkPullDestinations(%q) = %v, want error: %v", tt.dst, err, tt.wantErr)
+		}
+	}
+	if err := checkPullDestinations(hosts[:1], "logs/app.log"); err != nil {
+		t.Errorf("pulling from one host = %v", err)
+	}
+}
+
+// startFleetTransfer returns a transfer through a test server serving this
+// machine's file system, for every host but "down", which can't be reached
+func startFleetTransfer(t *testing.T, upload bool, src, dst string) (*fleetTransfer, *bytes.Buffer) {
+	t.Helper()
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{SFTP: true})
+	var out bytes.Buffer
+	return &fleetTransfer{
+		dial: func(h fleetHost, _ *bannerCollector) (*cryptossh.Client, error) {
+			if h.host == "down" {
+				return nil, errors.New("connection refused")
+			}
+			return cryptossh.Dial("tcp", srv.Addr, srv.ClientConfig)
+		},
+		upload:   upload,
+		src:      src,
+		dst:      dst,
+		parallel: 2,
+		out:      &out,
+	}, &out
+}
+
+func TestFleetTransfer(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+	dir := t.TempDir()
+	for _, name := range []string{"web1", "web2"} {
+		for _, sub := range []string{"certs", filepath.Join("remote", name)} {
+			if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		writeFile(t, filepath.Join(dir, "certs", name+".pem"), "cert of "+name, 0o600)
+	}
+	hosts := []fleetHost{
+		{name: "web1", host: "web1", vars: map[string]string{"host": "web1"}},
+		{name: "web2", host: "web2", vars: map[string]string{"host": "web2"}},
+		{name: "down", host: "down", vars: map[string]string{"host": "down"}},
+	}
+
+	// Every host gets its own file
+	push, out := startFleetTransfer(t, true, filepath.Join(dir, "certs", "{host}.pem"), filepath.Join(dir, "remote", "{host}", "host.pem"))
+	results := push.run(hosts)
+	for i, name := range []string{"web1", "web2"} {
+		data, err := os.ReadFile(filepath.Join(dir, "remote", name, "host.pem"))
+		if err != nil || string(data) != "cert of "+name {
+			t.Errorf("pushed %s = %q, %v", name, data, err)
+		}
+		if results[i].err != nil || results[i].files != 1 || results[i].bytes != int64(len(data)) {
+			t.Errorf("result for %s = %+v", name, results[i])
+		}
+	}
+	if results[2].err == nil || !strings.Contains(out.String(), "✗ down: connection refused") {
+		t.Errorf("unreachable host result = %+v, output:\n%s", results[2], out)
+	}
+
+	// And back, into directories created per host
+	pull, _ := startFleetTransfer(t, false, filepath.Join(dir, "remote", "{host}", "host.pem"), filepath.Join(dir, "pulled", "{host}")+"/")
+	results = pull.run(hosts[:2])
+	for i, name := range []string{"web1", "web2"} {
+		data, err := os.ReadFile(filepath.Join(dir, "pulled", name, "host.pem"))
+		if err != nil || string(data) != "cert of "+name || results[i].err != nil {
+			t.Errorf("pulled %s = %q, %v, result %+v", name, data, err, results[i])
+		}
+	}
+
+	var table bytes.Buffer
+	printTransferSummary(&table, []transferResult{
+		{host: "web1", dst: "/etc/app.conf", files: 1, bytes: 2048, duration: 1500 * time.Millisecond},
+		{host: "down", err: errors.New("connection refused"), duration: time.Second},
+	})
+	want := `HOST  STATUS  FILES  SIZE     TIME  DETAIL
+web1  ok      1      2.0 KiB  1.5s  /etc/app.conf
+down  failed  0      0 B      1s    connection refused
+`
+	if table.String() != want {
+		t.Errorf("summary =\n%s\nwant\n%s", table.String(), want)
+	}
+}
+
+func TestFleetTransferHostTimeout(t *testing.T) {
+	transfer, _ := startFleetTransfer(t, true, "a", "b")
+	transfer.hostTimeout = 100 * time.Millisecond
+	transfer.dial = func(fleetHost, *bannerCollector) (*cryptossh.Client, error) {
+		time.Sleep(time.Second)
+		return nil, errors.New("too late")
+	}
+	results := transfer.run([]fleetHost{{name: "slow", host: "slow"}})
+	if results[0].err == nil || results[0].err.Error() != "timed out after 100ms" || results[0].duration > 500*time.Millisecond {
+		t.Errorf("result = %+v, want a timeout after 100ms", results[0])
+	}
+}
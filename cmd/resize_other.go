@@ -0,0 +1,37 @@
+//go:build !unix
+
+package cmd
+
+import (
+	"os"
+	"time"
+)
+
+// resizePollInterval is how often the terminal size is checked where no
+// signal reports resizes
+const resizePollInterval = 250 * time.Millisecond
+
+// resizeEvents reports possible terminal resizes by polling, as there is no
+// SIGWINCH outside Unix
+func resizeEvents() (<-chan os.Signal, func()) {
+	events := make(chan os.Signal, 1)
+	ticker := time.NewTicker(resizePollInterval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				select {
+				case events <- nil:
+				default:
+				}
+			}
+		}
+	}()
+	return events, func() {
+		ticker.Stop()
+		close(done)
+	}
+}
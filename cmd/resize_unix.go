@@ -0,0 +1,16 @@
+//go:build unix
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// resizeEvents reports terminal resizes, signalled by SIGWINCH
+func resizeEvents() (<-chan os.Signal, func()) {
+	events := make(chan os.Signal, 1)
+	signal.Notify(events, syscall.SIGWINCH)
+	return events, func() { signal.Stop(events) }
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+// cmd/resize_unix_test.go
+package cmd
+
+import (
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestResizeEvents(t *testing.T) {
+	events, stop := resizeEvents()
+	defer stop()
+	syscall.Kill(syscall.Getpid(), syscall.SIGWINCH)
+	select {
+	case <-events:
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGWINCH was not reported as a resize")
+	}
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxRetryBackoff caps the wait between connection attempts
+const maxRetryBackoff = 5 * time.Minute
+
+// retryable reports whether a connection failure may be transient. Refused
+// credentials and changed host keys fail the same way on every attempt.
+func retryable(err error) bool {
+	var mismatch *hostKeyMismatchError
+	if errors.As(err, &mismatch) {
+		return false
+	}
+	// x/crypto/ssh reports authentication failures only in the error text
+	return !strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// withRetry calls attempt until it succeeds, fails permanently or has been
+// retried retries times, waiting backoff before the first retry and twice as
+// long before each further one. The final error lists every attempt's error.
+func withRetry[T any](attempt func() (T, error), retries int, backoff time.Duration) (T, error) {
+	var failures []string
+	for i := 0; ; i++ {
+		result, err := attempt()
+		if err == nil {
+			return result, nil
+		}
+		failures = append(failures, fmt.Sprintf("attempt %d: %s", i+1, err))
+		if i >= retries || !retryable(err) {
+			if len(failures) == 1 {
+				return result, err
+			}
+			return result, fmt.Errorf("%d attempts failed: %s", len(failures), strings.Join(failures, "; "))
+		}
+		log.Warnf("Connection attempt %d failed, retrying in %s: %s", i+1, backoff, err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxRetryBackoff)
+	}
+}
@@ -0,0 +1,48 @@
+// cmd/retry_test.go
+package cmd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWithRetry(t *testing.T) {
+	refused := errors.New("dial tcp 192.0.2.1:22: connect: connection refused")
+	denied := errors.New("ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey]")
+
+	tests := []struct {
+		name         string
+		errs         []error
+		retries      int
+		wantAttempts int
+		wantErr      string
+	}{
+		{"first attempt", nil, 3, 1, ""},
+		{"transient failure", []error{refused, refused}, 3, 3, ""},
+		{"retries exhausted", []error{refused, refused, refused}, 2, 3, "3 attempts failed: attempt 1: dial tcp"},
+		{"single failure without retries", []error{refused}, 0, 1, refused.Error()},
+		{"authentication is not retried", []error{refused, denied}, 5, 2, "attempt 2: ssh: handshake failed"},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		got, err := withRetry(func() (int, error) {
+			attempts++
+			if attempts <= len(tt.errs) {
+				return 0, tt.errs[attempts-1]
+			}
+			return attempts, nil
+		}, tt.retries, time.Millisecond)
+		if attempts != tt.wantAttempts {
+			t.Errorf("%s: %d attempts, want %d", tt.name, attempts, tt.wantAttempts)
+		}
+		if tt.wantErr == "" {
+			if err != nil || got != attempts {
+				t.Errorf("%s: withRetry() = %d, %v", tt.name, got, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want it to contain %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/fatih/color"
@@ -23,6 +25,10 @@ automation and DevOps workflows.
 Complete documentation is available at https://github.com/bxtal-lsn/gossh`,
 	// This will run before any subcommand
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Keep machine-readable output clean
+		if machineOutput(cmd) {
+			return
+		}
 		// Print a fancy header
 		color.New(color.FgHiCyan, color.Bold).Println("┌─────────────────────────────┐")
 		color.New(color.FgHiCyan, color.Bold).Println("│        GoSSH Toolset        │")
@@ -88,3 +94,73 @@ func initConfig() {
 		rootCmd.PersistentPreRun = nil
 	}
 }
+
+// logrusHandler is a slog.Handler that writes to a logrus logger, so the SSH
+// server's structured log lines share the CLI's format and --log-level
+type logrusHandler struct {
+	logger *logrus.Logger
+	fields logrus.Fields
+	group  string
+}
+
+// newSlogLogger returns a slog.Logger writing to logger
+func newSlogLogger(logger *logrus.Logger) *slog.Logger {
+	return slog.New(&logrusHandler{logger: logger, fields: logrus.Fields{}})
+}
+
+// logrusLevel maps a slog level to the matching logrus level
+func logrusLevel(level slog.Level) logrus.Level {
+	switch {
+	case level >= slog.LevelError:
+		return logrus.ErrorLevel
+	case level >= slog.LevelWarn:
+		return logrus.WarnLevel
+	case level >= slog.LevelInfo:
+		return logrus.InfoLevel
+	default:
+		return logrus.DebugLevel
+	}
+}
+
+func (h *logrusHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logger.IsLevelEnabled(logrusLevel(level))
+}
+
+func (h *logrusHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := make(logrus.Fields, len(h.fields)+record.NumAttrs())
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	record.Attrs(func(attr slog.Attr) bool {
+		h.addAttr(fields, attr)
+		return true
+	})
+	h.logger.WithFields(fields).Log(logrusLevel(record.Level), record.Message)
+	return nil
+}
+
+func (h *logrusHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := make(logrus.Fields, len(h.fields)+len(attrs))
+	for k, v := range h.fields {
+		fields[k] = v
+	}
+	for _, attr := range attrs {
+		h.addAttr(fields, attr)
+	}
+	return &logrusHandler{logger: h.logger, fields: fields, group: h.group}
+}
+
+func (h *logrusHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &logrusHandler{logger: h.logger, fields: h.fields, group: h.group + name + "."}
+}
+
+// addAttr stores attr in fields under its group-qualified key
+func (h *logrusHandler) addAttr(fields logrus.Fields, attr slog.Attr) {
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+	fields[h.group+attr.Key] = attr.Value.Resolve().Any()
+}
@@ -0,0 +1,33 @@
+// cmd/root_test.go
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// TestSlogLogger tests that server log lines reach logrus with their attributes
+func TestSlogLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.SetOutput(&out)
+	logger.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true, DisableColors: true})
+	logger.SetLevel(logrus.InfoLevel)
+
+	slogger := newSlogLogger(logger).With("session_id", "0123456789abcdef", "user", "alice")
+	slogger.Debug("session request", "type", "shell")
+	slogger.Warn("denied pty-req")
+
+	got := out.String()
+	if strings.Contains(got, "session request") {
+		t.Errorf("debug message logged at info level: %q", got)
+	}
+	for _, want := range []string{"level=warning", `msg="denied pty-req"`, "session_id=0123456789abcdef", "user=alice"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log output %q does not contain %q", got, want)
+		}
+	}
+}
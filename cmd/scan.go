@@ -0,0 +1,422 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	scanTypes    string
+	scanParallel int
+	scanHash     bool
+	scanDiffFile string
+)
+
+// maxScanTargets bounds how many addresses CIDR ranges may expand to
+const maxScanTargets = 65536
+
+// Statuses of scanned keys compared with --diff's known_hosts file
+const (
+	scanKeyUnchanged = "unchanged"
+	scanKeyChanged   = "changed"
+	scanKeyNew       = "new"
+	scanKeyRevoked   = "revoked"
+)
+
+// scanKeyTypes are the host key algorithms offered to the server for each
+// --type, one handshake per type. A server has at most one key per type, so
+// any of the algorithms returns the same key.
+var scanKeyTypes = map[string][]string{
+	"ed25519": {ssh.KeyAlgoED25519},
+	"ecdsa":   {ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521},
+	"rsa":     {ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA},
+	"dsa":     {ssh.KeyAlgoDSA},
+}
+
+// errHostKeyScanned ends a handshake once the server's host key is known
+var errHostKeyScanned = errors.New("host key scanned")
+
+// scanCmd represents the scan command
+var scanCmd = &cobra.Command{
+	Use:   "scan <host|host:port|cidr>...",
+	Short: "Collect the host keys of many hosts",
+	Long: `Collect the SSH host keys of hosts and CIDR ranges, many at a time, like
+ssh-keyscan. Each host is asked for a key of every --type, one handshake per
+type, without logging in. The keys are printed in known_hosts format, ready to
+append to ~/.ssh/known_hosts, or with --output json as one object per key with
+its SHA256 fingerprint.
+
+With --diff the keys are compared with a known_hosts file instead, and each is
+reported as unchanged, new, changed or revoked. gossh scan --diff exits with 1
+when a key changed or is revoked; otherwise it exits with 1 only when no key
+could be collected at all. Unreachable hosts are reported on stderr.
+
+Examples:
+  # Trust the keys of a few hosts
+  gossh scan web1 web2 db1:2222 >> ~/.ssh/known_hosts
+
+  # Collect the ed25519 keys of a subnet, hashing the host names
+  gossh scan 10.0.0.0/24 --type ed25519 --hash
+
+  # Check that no host's key changed since it was trusted
+  gossh scan --diff ~/.ssh/known_hosts web1 web2 db1`,
+	Args: cobra.MinimumNArgs(1),
+	// The keys go to stdout as known_hosts lines
+	Annotations: map[string]string{machineOutputAnnotation: ""},
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Fprintln(os.Stderr, errorColor("✗ ")+err.Error())
+			os.Exit(1)
+		}
+		// Stdout is for the keys alone
+		log.SetOutput(os.Stderr)
+		types, err := parseScanTypes(scanTypes)
+		if err != nil {
+			fail(err)
+		}
+		switch {
+		case scanParallel < 1:
+			fail(errors.New("--parallel must be at least 1"))
+		case outputFormat != outputText && outputFormat != outputJSON:
+			fail(fmt.Errorf("invalid output format %q (use text or json)", outputFormat))
+		}
+		connectTimeout, err := time.ParseDuration(timeout)
+		if err != nil {
+			fail(fmt.Errorf("invalid timeout: %s", err))
+		}
+		targets, err := parseScanTargets(args, port)
+		if err != nil {
+			fail(err)
+		}
+		var known ssh.HostKeyCallback
+		if scanDiffFile != "" {
+			if known, err = knownhosts.New(expandHome(scanDiffFile)); err != nil {
+				fail(fmt.Errorf("could not read known_hosts: %s", err))
+			}
+		}
+
+		results := make([]scanResult, len(targets))
+		runParallel(len(targets), scanParallel, func(i int) {
+			results[i] = scanHost(targets[i], types, connectTimeout)
+			if known != nil {
+				diffHostKeys(known, results[i].keys)
+			}
+		})
+
+		switch {
+		case outputFormat == outputJSON:
+			writeScanJSON(os.Stdout, results)
+		case known != nil:
+			writeScanDiff(os.Stdout, results)
+		default:
+			writeKnownHosts(os.Stdout, results, scanHash)
+		}
+		scanned, changed := 0, false
+		for _, result := range results {
+			if result.err != nil && outputFormat != outputJSON {
+				fmt.Fprintln(os.Stderr, errorColor("✗ ")+result.target.addr()+": "+result.err.Error())
+			}
+			scanned += len(result.keys)
+			for _, key := range result.keys {
+				changed = changed || key.Status == scanKeyChanged || key.Status == scanKeyRevoked
+			}
+		}
+		if scanned == 0 || changed {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scanCmd)
+
+	scanCmd.Flags().StringVarP(&port, "port", "p", "22", "SSH port of hosts and ranges that don't name one")
+	scanCmd.Flags().StringVar(&scanTypes, "type", "ed25519,ecdsa,rsa", "Comma separated key types to collect: ed25519, ecdsa, rsa or dsa")
+	scanCmd.Flags().IntVar(&scanParallel, "parallel", 64, "Number of hosts to scan at once")
+	scanCmd.Flags().StringVarP(&timeout, "timeout", "t", "10s", "Timeout of each connection and handshake")
+	scanCmd.Flags().StringVarP(&outputFormat, "output", "o", outputText, "text for known_hosts lines, or json for one object per key with its fingerprint")
+	scanCmd.Flags().BoolVar(&scanHash, "hash", false, "Hash the host names of the known_hosts lines")
+	scanCmd.Flags().StringVar(&scanDiffFile, "diff", "", "Compare the keys with this known_hosts file rather than printing them")
+}
+
+// parseScanTypes returns the key types of a comma separated --type
+func parseScanTypes(s string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(s, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if _, ok := scanKeyTypes[t]; !ok {
+			return nil, fmt.Errorf("unknown key type %q (use ed25519, ecdsa, rsa or dsa)", t)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// scanTarget is one address to collect host keys from
+type scanTarget struct {
+	host string
+	port int
+}
+
+// addr returns the target as host:port
+func (t scanTarget) addr() string {
+	return net.JoinHostPort(t.host, strconv.Itoa(t.port))
+}
+
+// parseScanTargets turns host, host:port, [ipv6]:port and CIDR arguments
+// into the addresses to scan, on defaultPort unless they name one. A range
+// leaves out its network and broadcast addresses.
+func parseScanTargets(args []string, defaultPort string) ([]scanTarget, error) {
+	p, err := parseScanPort(defaultPort)
+	if err != nil {
+		return nil, err
+	}
+	var targets []scanTarget
+	for _, arg := range args {
+		host, targetPort := arg, p
+		if h, ps, err := net.SplitHostPort(arg); err == nil {
+			if targetPort, err = parseScanPort(ps); err != nil {
+				return nil, fmt.Errorf("%s: %s", arg, err)
+			}
+			host = h
+		}
+		if host == "" {
+			return nil, fmt.Errorf("%s: missing host", arg)
+		}
+		if !strings.Contains(host, "/") {
+			targets = append(targets, scanTarget{host: host, port: targetPort})
+			continue
+		}
+		ips, err := expandCIDR(host)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", arg, err)
+		}
+		for _, ip := range ips {
+			targets = append(targets, scanTarget{host: ip.String(), port: targetPort})
+		}
+	}
+	if len(targets) > maxScanTargets {
+		return nil, fmt.Errorf("too many addresses to scan, at most %d", maxScanTargets)
+	}
+	return targets, nil
+}
+
+// parseScanPort parses a TCP port number
+func parseScanPort(s string) (int, error) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return p, nil
+}
+
+// expandCIDR returns the host addresses of the range cidr, refusing ranges
+// of more than maxScanTargets
+func expandCIDR(cidr string) ([]net.IP, error) {
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	ones, bits := network.Mask.Size()
+	if 1<<min(bits-ones, 30) > maxScanTargets {
+		return nil, fmt.Errorf("range is too large to scan, at most %d addresses", maxScanTargets)
+	}
+	first := new(big.Int).SetBytes(network.IP.To16()[16-len(ip):])
+	count := 1 << (bits - ones)
+	// IPv4 ranges of more than two addresses can't reach their network and
+	// broadcast addresses
+	skip := len(ip) == net.IPv4len && count > 2
+	var ips []net.IP
+	for i := 0; i < count; i++ {
+		if skip && (i == 0 || i == count-1) {
+			continue
+		}
+		n := new(big.Int).Add(first, big.NewInt(int64(i))).FillBytes(make([]byte, len(ip)))
+		ips = append(ips, net.IP(n))
+	}
+	return ips, nil
+}
+
+// scannedKey is a host key collected by gossh scan
+type scannedKey struct {
+	Host        string `json:"host"`
+	Port        int    `json:"port"`
+	Type        string `json:"type"`
+	Key         string `json:"key"`
+	Fingerprint string `json:"fingerprint"`
+	// Status and KnownFingerprint compare the key with --diff's file
+	Status           string `json:"status,omitempty"`
+	KnownFingerprint string `json:"known_fingerprint,omitempty"`
+
+	key    ssh.PublicKey
+	remote net.Addr
+}
+
+// scanResult is the keys collected from a target, and why not all of them
+// were when err is set
+type scanResult struct {
+	target scanTarget
+	keys   []scannedKey
+	err    error
+}
+
+// scanHost collects t's host key of each of types. A type the server has
+// no key of is left out; once t can't be reached the remaining types are
+// too.
+func scanHost(t scanTarget, types []string, timeout time.Duration) scanResult {
+	result := scanResult{target: t}
+	seen := map[string]bool{}
+	for _, keyType := range types {
+		key, remote, err := scanHostKey(t.addr(), scanKeyTypes[keyType], timeout)
+		var netErr net.Error
+		switch {
+		case errors.As(err, &netErr) || errors.Is(err, io.EOF):
+			result.err = err
+			return result
+		case err != nil:
+			// The server has no key of the type, so found no common algorithm
+			log.Debugf("No %s host key from %s: %s", keyType, t.addr(), err)
+			continue
+		}
+		line := string(ssh.MarshalAuthorizedKey(key))
+		if seen[line] {
+			continue
+		}
+		seen[line] = true
+		result.keys = append(result.keys, scannedKey{
+			Host:        t.host,
+			Port:        t.port,
+			Type:        key.Type(),
+			Key:         strings.TrimSpace(line),
+			Fingerprint: ssh.FingerprintSHA256(key),
+			key:         key,
+			remote:      remote,
+		})
+	}
+	return result
+}
+
+// scanHostKey returns the host key the server at addr offers for one of
+// algorithms, and the address it was reached at, ending the handshake
+// before authentication
+func scanHostKey(addr string, algorithms []string, timeout time.Duration) (ssh.PublicKey, net.Addr, error) {
+	conn, err := dialTCP(dialNetwork(), addr, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	var key ssh.PublicKey
+	var remote net.Addr
+	config := &ssh.ClientConfig{
+		User:              "gossh-scan",
+		HostKeyAlgorithms: algorithms,
+		HostKeyCallback: func(_ string, r net.Addr, k ssh.PublicKey) error {
+			key, remote = k, r
+			return errHostKeyScanned
+		},
+	}
+	_, _, _, err = ssh.NewClientConn(conn, addr, config)
+	if key != nil {
+		return key, remote, nil
+	}
+	if err == nil {
+		err = errors.New("no host key offered")
+	}
+	return nil, nil, err
+}
+
+// diffHostKeys sets the Status of keys by checking them with known
+func diffHostKeys(known ssh.HostKeyCallback, keys []scannedKey) {
+	for i := range keys {
+		k := &keys[i]
+		err := known(net.JoinHostPort(k.Host, strconv.Itoa(k.Port)), k.remote, k.key)
+		var keyErr *knownhosts.KeyError
+		var revokedErr *knownhosts.RevokedError
+		switch {
+		case err == nil:
+			k.Status = scanKeyUnchanged
+		case errors.As(err, &revokedErr):
+			k.Status = scanKeyRevoked
+		case errors.As(err, &keyErr):
+			k.Status = scanKeyNew
+			for _, want := range keyErr.Want {
+				if want.Key.Type() == k.Type {
+					k.Status, k.KnownFingerprint = scanKeyChanged, ssh.FingerprintSHA256(want.Key)
+				}
+			}
+		default:
+			log.Warnf("Could not check the %s key of %s: %s", k.Type, k.Host, err)
+		}
+	}
+}
+
+// writeKnownHosts writes the keys of results as known_hosts lines,
+// hashing the host names when hash is set
+func writeKnownHosts(out io.Writer, results []scanResult, hash bool) {
+	for _, result := range results {
+		name := knownhosts.Normalize(result.target.addr())
+		if hash {
+			name = knownhosts.HashHostname(name)
+		}
+		for _, k := range result.keys {
+			fmt.Fprintln(out, knownhosts.Line([]string{name}, k.key))
+		}
+	}
+}
+
+// writeScanJSON writes every key of results as a JSON line, and every
+// target that failed as a line with its error
+func writeScanJSON(out io.Writer, results []scanResult) {
+	enc := json.NewEncoder(out)
+	for _, result := range results {
+		for _, k := range result.keys {
+			enc.Encode(k)
+		}
+		if result.err != nil {
+			enc.Encode(struct {
+				Host  string `json:"host"`
+				Port  int    `json:"port"`
+				Error string `json:"error"`
+			}{result.target.host, result.target.port, result.err.Error()})
+		}
+	}
+}
+
+// writeScanDiff writes a table of the keys of results and how they compare
+// with the known_hosts file
+func writeScanDiff(out io.Writer, results []scanResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tTYPE\tFINGERPRINT\tSTATUS")
+	for _, result := range results {
+		for _, k := range result.keys {
+			status := k.Status
+			if k.Status == scanKeyChanged {
+				status += " (was " + k.KnownFingerprint + ")"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.target.addr(), k.Type, k.Fingerprint, orDash(status))
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,158 @@
+// cmd/scan_test.go
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestParseScanTargets(t *testing.T) {
+	tests := []struct {
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{[]string{"web1", "db1:2222"}, []string{"web1:22", "db1:2222"}, false},
+		{[]string{"[fd00::1]:2200", "fd00::2"}, []string{"[fd00::1]:2200", "[fd00::2]:22"}, false},
+		{[]string{"10.0.0.0/30"}, []string{"10.0.0.1:22", "10.0.0.2:22"}, false},
+		{[]string{"10.0.0.8/31:2222", "10.0.0.9/32"}, []string{"10.0.0.8:2222", "10.0.0.9:2222", "10.0.0.9:22"}, false},
+		{[]string{"fd00::/127"}, []string{"[fd00::]:22", "[fd00::1]:22"}, false},
+		{[]string{"10.0.0.0/15"}, nil, true},
+		{[]string{"fd00::/64"}, nil, true},
+		{[]string{"10.0.0.0/16", "10.1.0.0/16"}, nil, true},
+		{[]string{"10.0.0.0/33"}, nil, true},
+		{[]string{"web1:ssh"}, nil, true},
+		{[]string{":22"}, nil, true},
+	}
+	for _, tt := range tests {
+		targets, err := parseScanTargets(tt.args, "22")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseScanTargets(%q) error = %v, want error: %v", tt.args, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, target := range targets {
+			got = append(got, target.addr())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseScanTargets(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+	if _, err := parseScanTargets([]string{"web1"}, "0"); err == nil {
+		t.Error("an invalid default port should fail")
+	}
+	if _, err := parseScanTypes("ed25519, RSA"); err != nil {
+		t.Errorf("parseScanTypes failed: %v", err)
+	}
+	if _, err := parseScanTypes("ed25519,blowfish"); err == nil {
+		t.Error("an unknown key type should fail")
+	}
+}
+
+func TestScanHost(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{})
+	target, err := parseScanTargets([]string{srv.Addr}, "22")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The test server only has an ed25519 key
+	result := scanHost(target[0], []string{"ed25519", "ecdsa", "rsa"}, 5*time.Second)
+	if result.err != nil || len(result.keys) != 1 {
+		t.Fatalf("scanHost = %+v", result)
+	}
+	key := result.keys[0]
+	if key.Type != cryptossh.KeyAlgoED25519 || key.Fingerprint != cryptossh.FingerprintSHA256(srv.HostKey) ||
+		key.Key != strings.TrimSpace(string(cryptossh.MarshalAuthorizedKey(srv.HostKey))) {
+		t.Errorf("scanned key = %+v", key)
+	}
+
+	var out bytes.Buffer
+	writeKnownHosts(&out, []scanResult{result}, false)
+	if want := knownhosts.Line([]string{knownhosts.Normalize(srv.Addr)}, srv.HostKey) + "\n"; out.String() != want {
+		t.Errorf("known_hosts output = %q, want %q", out.String(), want)
+	}
+	out.Reset()
+	writeKnownHosts(&out, []scanResult{result}, true)
+	if !strings.HasPrefix(out.String(), "|1|") {
+		t.Errorf("hashed known_hosts output = %q", out.String())
+	}
+
+	down := scanHost(scanTarget{host: "127.0.0.1", port: 1}, []string{"ed25519", "rsa"}, 5*time.Second)
+	if down.err == nil || len(down.keys) != 0 {
+		t.Errorf("scanning a closed port = %+v", down)
+	}
+	out.Reset()
+	writeScanJSON(&out, []scanResult{result, down})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var got scannedKey
+	if len(lines) != 2 || json.Unmarshal([]byte(lines[0]), &got) != nil || got.Fingerprint != key.Fingerprint ||
+		!strings.Contains(lines[1], `"host":"127.0.0.1","port":1,"error":`) {
+		t.Errorf("JSON output:\n%s", out.String())
+	}
+}
+
+func TestDiffHostKeys(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{})
+	target, err := parseScanTargets([]string{srv.Addr}, "22")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := cryptossh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := signer.PublicKey()
+	name := knownhosts.Normalize(srv.Addr)
+
+	tests := []struct {
+		knownHosts string
+		want       string
+	}{
+		{knownhosts.Line([]string{name}, srv.HostKey), scanKeyUnchanged},
+		{knownhosts.Line([]string{name}, other), scanKeyChanged},
+		{knownhosts.Line([]string{"[elsewhere]:22"}, srv.HostKey), scanKeyNew},
+		{"@revoked * " + strings.TrimSpace(string(cryptossh.MarshalAuthorizedKey(srv.HostKey))), scanKeyRevoked},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		file := filepath.Join(dir, tt.want)
+		writeFile(t, file, tt.knownHosts+"\n", 0o600)
+		known, err := knownhosts.New(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result := scanHost(target[0], []string{"ed25519"}, 5*time.Second)
+		diffHostKeys(known, result.keys)
+		if len(result.keys) != 1 || result.keys[0].Status != tt.want {
+			t.Errorf("status with %q = %+v, want %s", tt.knownHosts, result.keys, tt.want)
+			continue
+		}
+		if tt.want == scanKeyChanged {
+			if result.keys[0].KnownFingerprint != cryptossh.FingerprintSHA256(other) {
+				t.Errorf("known fingerprint = %s", result.keys[0].KnownFingerprint)
+			}
+			var out bytes.Buffer
+			writeScanDiff(&out, []scanResult{result})
+			if !strings.Contains(out.String(), "changed (was "+cryptossh.FingerprintSHA256(other)+")") {
+				t.Errorf("diff table:\n%s", out.String())
+			}
+		}
+	}
+}
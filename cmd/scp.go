@@ -0,0 +1,449 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var (
+	scpRecursive bool
+	scpPreserve  bool
+	scpResume    bool
+)
+
+// scpBufferSize is the size of the copy buffer, large enough to keep several
+// SFTP requests in flight
+const scpBufferSize = 1 << 20
+
+// scpCmd represents the scp command
+var scpCmd = &cobra.Command{
+	Use:   "scp <source> <destination>",
+	Short: "Copy files to and from an SSH server",
+	Long: `Copy files and directories between this machine and an SSH server over SFTP,
+without OpenSSH installed. One of the paths is remote, [user@]host:path; a
+relative remote path is relative to the remote home directory.
+
+Connections are made as gossh client makes them: hosts, users, ports, keys
+and jump hosts come from ~/.ssh/config, and host keys are checked against
+known_hosts.
+
+Examples:
+  # Upload a file to the home directory
+  gossh scp backup.tar.gz admin@server.example.com:
+
+  # Download a directory, keeping modes and modification times
+  gossh scp -rp admin@server.example.com:/var/log/app ./logs
+
+  # Continue an interrupted download
+  gossh scp --resume server.example.com:images/disk.img .`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+
+		src, srcRemote := parseRemotePath(args[0])
+		dst, dstRemote := parseRemotePath(args[1])
+		switch {
+		case srcRemote && dstRemote:
+			fail(errors.New("copying between two remote hosts isn't supported"))
+		case !srcRemote && !dstRemote:
+			fail(errors.New("one of the paths must be remote, [user@]host:path"))
+		}
+		remote := src
+		if dstRemote {
+			remote = dst
+		}
+		host, user = remote.host, remote.user
+
+		client, err := dialServer(cmd.Flags())
+		if err != nil {
+			fail(err)
+		}
+		defer client.Close()
+		sftpClient, err := sftp.NewClient(client)
+		if err != nil {
+			fail(fmt.Errorf("failed to start SFTP: %s", err))
+		}
+		defer sftpClient.Close()
+
+		c := &copier{src: localFS{}, dst: remoteFS{sftpClient}, recursive: scpRecursive, preserve: scpPreserve, resume: scpResume}
+		if srcRemote {
+			c.src, c.dst = c.dst, c.src
+		}
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		if !quiet && term.IsTerminal(int(os.Stderr.Fd())) {
+			c.progress = os.Stderr
+		}
+		if err := c.copy(src.path, dst.path); err != nil {
+			fail(err)
+		}
+		fmt.Println(successColor("✓ ") + fmt.Sprintf("Copied %d files, %s", c.files, formatBytes(c.bytes)))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(scpCmd)
+
+	addConnectionFlags(scpCmd.Flags(), "P", "i")
+	scpCmd.Flags().BoolVarP(&scpRecursive, "recursive", "r", false, "Copy directories and their contents")
+	scpCmd.Flags().BoolVarP(&scpPreserve, "preserve", "p", false, "Keep the modes and modification times of the source files")
+	scpCmd.Flags().BoolVar(&scpResume, "resume", false, "Continue files a previous copy left shorter than the source, and skip those of the same size")
+}
+
+// remotePath is a source or destination of gossh scp
+type remotePath struct {
+	user string
+	host string
+	path string
+}
+
+// parseRemotePath splits [user@]host:path, as OpenSSH's scp does: a colon
+// before any slash makes the path remote, and an IPv6 host goes in brackets.
+// An empty remote path is the home directory.
+func parseRemotePath(arg string) (remotePath, bool) {
+	var p remotePath
+	userHost, rest := "", ""
+	if strings.HasPrefix(arg, "[") || strings.Contains(arg, "@[") {
+		end := strings.Index(arg, "]:")
+		if end < 0 || strings.Contains(arg[:end], "/") {
+			return remotePath{path: arg}, false
+		}
+		userHost, rest = arg[:end+1], arg[end+2:]
+	} else {
+		colon := strings.Index(arg, ":")
+		if colon <= 0 || strings.Contains(arg[:colon], "/") {
+			return remotePath{path: arg}, false
+		}
+		userHost, rest = arg[:colon], arg[colon+1:]
+	}
+	if at := strings.LastIndex(userHost, "@"); at >= 0 {
+		p.user, userHost = userHost[:at], userHost[at+1:]
+	}
+	p.host = strings.Trim(userHost, "[]")
+	p.path = rest
+	if p.path == "" {
+		p.path = "."
+	}
+	return p, true
+}
+
+// transferFile is an open local or remote file
+type transferFile interface {
+	io.ReadWriteSeeker
+	io.Closer
+}
+
+// fileSystem is the local or remote side of a copy
+type fileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	OpenFile(name string, flag int, perm os.FileMode) (transferFile, error)
+	Mkdir(name string, perm os.FileMode) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Join(elem ...string) string
+	Base(name string) string
+}
+
+// localFS is this machine's file system
+type localFS struct{}
+
+func (localFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (localFS) OpenFile(name string, flag int, perm os.FileMode) (transferFile, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func (localFS) Mkdir(name string, perm os.FileMode) error { return os.Mkdir(name, perm) }
+func (localFS) Chmod(name string, mode os.FileMode) error { return os.Chmod(name, mode) }
+func (localFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+func (localFS) Join(elem ...string) string { return filepath.Join(elem...) }
+func (localFS) Base(name string) string    { return filepath.Base(name) }
+
+// remoteFS is the server's file system over SFTP, which always uses slashes
+type remoteFS struct {
+	client *sftp.Client
+}
+
+func (r remoteFS) Stat(name string) (os.FileInfo, error)      { return r.client.Stat(name) }
+func (r remoteFS) ReadDir(name string) ([]os.FileInfo, error) { return r.client.ReadDir(name) }
+
+// OpenFile sets perm on files it creates, as SFTP's open leaves them to the
+// server's default
+func (r remoteFS) OpenFile(name string, flag int, perm os.FileMode) (transferFile, error) {
+	created := false
+	if flag&os.O_CREATE != 0 {
+		_, err := r.client.Stat(name)
+		created = errors.Is(err, os.ErrNotExist)
+	}
+	file, err := r.client.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		if err := file.Chmod(perm); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return file, nil
+}
+
+func (r remoteFS) Mkdir(name string, perm os.FileMode) error {
+	if err := r.client.Mkdir(name); err != nil {
+		return err
+	}
+	return r.client.Chmod(name, perm)
+}
+
+func (r remoteFS) Chmod(name string, mode os.FileMode) error { return r.client.Chmod(name, mode) }
+func (r remoteFS) Chtimes(name string, atime, mtime time.Time) error {
+	return r.client.Chtimes(name, atime, mtime)
+}
+func (remoteFS) Join(elem ...string) string { return path.Join(elem...) }
+func (remoteFS) Base(name string) string    { return path.Base(name) }
+
+// copier copies files and directory trees from src to dst
+type copier struct {
+	src, dst  fileSystem
+	recursive bool
+	preserve  bool
+	resume    bool
+	// progress is where progress bars are drawn, if anywhere
+	progress io.Writer
+
+	files int
+	bytes int64
+	buf   []byte
+}
+
+// copy copies srcPath to dstPath, or into it when it is a directory
+func (c *copier) copy(srcPath, dstPath string) error {
+	info, err := c.src.Stat(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", srcPath, err)
+	}
+	if dstInfo, err := c.dst.Stat(dstPath); err == nil && dstInfo.IsDir() {
+		dstPath = c.dst.Join(dstPath, c.src.Base(srcPath))
+	}
+	return c.copyTree(srcPath, dstPath, info)
+}
+
+// copyTree copies the file or directory srcPath to dstPath
+func (c *copier) copyTree(srcPath, dstPath string, info os.FileInfo) error {
+	switch {
+	case info.Mode().IsRegular():
+		if err := c.copyFile(srcPath, dstPath, info); err != nil {
+			return err
+		}
+	case info.IsDir():
+		if !c.recursive {
+			return fmt.Errorf("%s is a directory (use -r to copy it)", srcPath)
+		}
+		if err := c.dst.Mkdir(dstPath, info.Mode().Perm()); err != nil {
+			if dstInfo, statErr := c.dst.Stat(dstPath); statErr != nil || !dstInfo.IsDir() {
+				return fmt.Errorf("failed to create %s: %s", dstPath, err)
+			}
+		}
+		entries, err := c.src.ReadDir(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", srcPath, err)
+		}
+		for _, entry := range entries {
+			if !validEntryName(entry.Name()) {
+				return fmt.Errorf("refusing to copy %q from %s: not a plain file name", entry.Name(), srcPath)
+			}
+			if err := c.copyTree(c.src.Join(srcPath, entry.Name()), c.dst.Join(dstPath, entry.Name()), entry); err != nil {
+				return err
+			}
+		}
+	default:
+		log.Warn("Skipping ", srcPath, ": not a regular file or directory")
+		return nil
+	}
+
+	// Directories get their times after their contents are written
+	if c.preserve {
+		if err := c.dst.Chmod(dstPath, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to set the mode of %s: %s", dstPath, err)
+		}
+		if err := c.dst.Chtimes(dstPath, info.ModTime(), info.ModTime()); err != nil {
+			return fmt.Errorf("failed to set the times of %s: %s", dstPath, err)
+		}
+	}
+	return nil
+}
+
+// validEntryName reports whether name, as listed in a directory, names an
+// entry of that directory. Names come from the server on downloads, and one
+// like "../.bashrc" would be written outside the destination.
+func validEntryName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsRune(name, '/') && !strings.ContainsRune(name, filepath.Separator)
+}
+
+// copyFile copies the regular file srcPath to dstPath, continuing a shorter
+// dstPath with --resume
+func (c *copier) copyFile(srcPath, dstPath string, info os.FileInfo) error {
+	var offset int64
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if c.resume {
+		if dstInfo, err := c.dst.Stat(dstPath); err == nil && dstInfo.Mode().IsRegular() {
+			switch {
+			case dstInfo.Size() == info.Size():
+				log.Debug("Skipping ", srcPath, ": already copied")
+				c.files++
+				return nil
+			case dstInfo.Size() < info.Size():
+				offset, flag = dstInfo.Size(), os.O_WRONLY
+			}
+		}
+	}
+
+	in, err := c.src.OpenFile(srcPath, os.O_RDONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", srcPath, err)
+	}
+	defer in.Close()
+	out, err := c.dst.OpenFile(dstPath, flag, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %s", dstPath, err)
+	}
+	if offset > 0 {
+		log.Debug("Resuming ", srcPath, " at byte ", offset)
+		if _, err := in.Seek(offset, io.SeekStart); err != nil {
+			out.Close()
+			return fmt.Errorf("failed to resume %s: %s", srcPath, err)
+		}
+		if _, err := out.Seek(offset, io.SeekStart); err != nil {
+			out.Close()
+			return fmt.Errorf("failed to resume %s: %s", dstPath, err)
+		}
+	}
+
+	var reader io.Reader = in
+	var bar *progressBar
+	if c.progress != nil {
+		bar = newProgressBar(c.progress, c.src.Base(srcPath), info.Size(), offset)
+		reader = io.TeeReader(in, bar)
+	}
+	if c.buf == nil {
+		c.buf = make([]byte, scpBufferSize)
+	}
+	// Keep the reader from being used as an io.WriterTo, which would bypass
+	// the buffer and the progress bar
+	n, err := io.CopyBuffer(out, struct{ io.Reader }{reader}, c.buf)
+	if bar != nil {
+		bar.finish()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to copy %s: %s", srcPath, err)
+	}
+	c.files++
+	c.bytes += n
+	return nil
+}
+
+// progressBar draws one file's progress on a terminal line
+type progressBar struct {
+	w          io.Writer
+	name       string
+	total      int64
+	done       int64
+	start      time.Time
+	lastDrawn  time.Time
+	startBytes int64
+}
+
+// progressInterval is how often a progress bar is redrawn
+const progressInterval = 100 * time.Millisecond
+
+func newProgressBar(w io.Writer, name string, total, done int64) *progressBar {
+	return &progressBar{w: w, name: name, total: total, done: done, startBytes: done, start: time.Now()}
+}
+
+// Write counts the bytes copied
+func (p *progressBar) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if now := time.Now(); now.Sub(p.lastDrawn) >= progressInterval {
+		p.lastDrawn = now
+		p.draw()
+	}
+	return len(b), nil
+}
+
+// finish draws the final state and ends the line
+func (p *progressBar) finish() {
+	p.draw()
+	fmt.Fprintln(p.w)
+}
+
+func (p *progressBar) draw() {
+	const width = 30
+	percent := 100
+	if p.total > 0 {
+		percent = int(p.done * 100 / p.total)
+	}
+	filled := percent * width / 100
+	var rate int64
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = int64(float64(p.done-p.startBytes) / elapsed)
+	}
+	name := p.name
+	if len(name) > 24 {
+		name = name[:21] + "..."
+	}
+	fmt.Fprintf(p.w, "\r%-24s [%s%s] %3d%% %9s %9s/s", name,
+		strings.Repeat("=", filled), strings.Repeat(" ", width-filled),
+		percent, formatBytes(p.done), formatBytes(rate))
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. 1.5 MiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
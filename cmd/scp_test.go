@@ -0,0 +1,222 @@
+// cmd/scp_test.go
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	"github.com/pkg/sftp"
+)
+
+func TestParseRemotePath(t *testing.T) {
+	tests := []struct {
+		arg    string
+		want   remotePath
+		remote bool
+	}{
+		{"backup.tar.gz", remotePath{path: "backup.tar.gz"}, false},
+		{"./odd:name", remotePath{path: "./odd:name"}, false},
+		{"/tmp/a:b", remotePath{path: "/tmp/a:b"}, false},
+		{":path", remotePath{path: ":path"}, false},
+		{"server:", remotePath{host: "server", path: "."}, true},
+		{"server:/var/log", remotePath{host: "server", path: "/var/log"}, true},
+		{"admin@server:logs/app.log", remotePath{user: "admin", host: "server", path: "logs/app.log"}, true},
+		{"a@b@server:x", remotePath{user: "a@b", host: "server", path: "x"}, true},
+		{"[::1]:/tmp", remotePath{host: "::1", path: "/tmp"}, true},
+		{"root@[fe80::1]:", remotePath{user: "root", host: "fe80::1", path: "."}, true},
+		{"[::1]", remotePath{path: "[::1]"}, false},
+	}
+	for _, tt := range tests {
+		got, remote := parseRemotePath(tt.arg)
+		if got != tt.want || remote != tt.remote {
+			t.Errorf("parseRemotePath(%q) = %+v, %v, want %+v, %v", tt.arg, got, remote, tt.want, tt.remote)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 << 20, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+// writeFile writes a test file, failing the test on error
+func writeFile(t *testing.T, name, content string, perm os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCopier(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes differ on Windows")
+	}
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{SFTP: true})
+	client, err := sftp.NewClient(srv.Dial(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	remote := remoteFS{client}
+
+	// The test server's SFTP serves this machine's file system
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "sub"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "run.sh"), "#!/bin/sh\n", 0o755)
+	writeFile(t, filepath.Join(src, "sub", "data.txt"), "data", 0o600)
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "sub", "data.txt"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	upload := &copier{src: localFS{}, dst: remote}
+	if err := upload.copy(src, filepath.Join(dir, "up")); err == nil {
+		t.Error("copying a directory without -r should fail")
+	}
+
+	// Upload into an existing directory, preserving modes and times
+	up := filepath.Join(dir, "up")
+	if err := os.Mkdir(up, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	upload = &copier{src: localFS{}, dst: remote, recursive: true, preserve: true}
+	if err := upload.copy(src, up); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if upload.files != 2 || upload.bytes != 14 {
+		t.Errorf("upload copied %d files, %d bytes, want 2 files, 14 bytes", upload.files, upload.bytes)
+	}
+	info, err := os.Stat(filepath.Join(up, "src", "sub", "data.txt"))
+	if err != nil || info.Mode().Perm() != 0o600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("uploaded data.txt = %v, %v, want mode 0600 and time %s", info, err, mtime)
+	}
+	if info, err := os.Stat(filepath.Join(up, "src", "run.sh")); err != nil || info.Mode().Perm() != 0o755 {
+		t.Errorf("uploaded run.sh = %v, %v, want mode 0755", info, err)
+	}
+
+	// Download a file under a new name, with a progress bar
+	var progress bytes.Buffer
+	download := &copier{src: remote, dst: localFS{}, progress: &progress}
+	back := filepath.Join(dir, "back.sh")
+	if err := download.copy(filepath.Join(up, "src", "run.sh"), back); err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if data, err := os.ReadFile(back); err != nil || string(data) != "#!/bin/sh\n" {
+		t.Errorf("downloaded %q, %v", data, err)
+	}
+	if !bytes.Contains(progress.Bytes(), []byte("100%")) {
+		t.Errorf("progress = %q, want 100%%", progress.String())
+	}
+}
+
+func TestCopierResume(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{SFTP: true})
+	client, err := sftp.NewClient(srv.Dial(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "disk.img")
+	writeFile(t, src, "0123456789", 0o644)
+	// A partial copy with different bytes shows which part was resent
+	dst := filepath.Join(dir, "partial.img")
+	writeFile(t, dst, "abcd", 0o644)
+
+	c := &copier{src: localFS{}, dst: remoteFS{client}, resume: true}
+	if err := c.copy(src, dst); err != nil {
+		t.Fatalf("resume failed: %v", err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "abcd456789" || c.bytes != 6 {
+		t.Errorf("resumed file = %q after %d bytes, want abcd456789 after 6", data, c.bytes)
+	}
+
+	// A complete copy is skipped
+	if err := c.copy(src, dst); err != nil || c.bytes != 6 || c.files != 2 {
+		t.Errorf("copying a complete file = %v, %d bytes, %d files", err, c.bytes, c.files)
+	}
+
+	// Without --resume the file is copied again
+	c = &copier{src: remoteFS{client}, dst: localFS{}}
+	if err := c.copy(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(dst); string(data) != "0123456789" {
+		t.Errorf("copied file = %q", data)
+	}
+}
+
+// renamedFS lists the entries of a local directory under another name, as a
+// hostile server could
+type renamedFS struct {
+	localFS
+	name string
+}
+
+func (r renamedFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := r.localFS.ReadDir(name)
+	for i, entry := range entries {
+		entries[i] = renamedFileInfo{entry, r.name}
+	}
+	return entries, err
+}
+
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (r renamedFileInfo) Name() string { return r.name }
+
+func TestCopierRejectsEntryNames(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src", "tree")
+	if err := os.MkdirAll(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(src, "data.txt"), "data", 0o644)
+	// Lets "../escaped.txt" be read, so only the check stops the copy
+	writeFile(t, filepath.Join(dir, "src", "escaped.txt"), "escaped", 0o644)
+	if err := os.Mkdir(filepath.Join(dir, "dst"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"", ".", "..", "../escaped.txt", "sub/data.txt", string(filepath.Separator) + "escaped.txt"} {
+		dst := filepath.Join(dir, "dst", "tree")
+		c := &copier{src: renamedFS{name: name}, dst: localFS{}, recursive: true}
+		if err := c.copy(src, dst); err == nil {
+			t.Errorf("copying an entry named %q succeeded", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "dst", "escaped.txt")); err == nil {
+			t.Errorf("an entry named %q was written outside the destination", name)
+		}
+	}
+
+	c := &copier{src: renamedFS{name: "data.txt"}, dst: localFS{}, recursive: true}
+	if err := c.copy(src, filepath.Join(dir, "ok")); err != nil {
+		t.Errorf("copying a plain entry name failed: %v", err)
+	}
+}
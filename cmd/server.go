@@ -1,22 +1,111 @@
 package cmd
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/bxtal-lsn/gossh/pkg/ssh"
 	"github.com/fatih/color"
+	_ "github.com/lib/pq"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	_ "modernc.org/sqlite"
 )
 
 var (
 	serverKeyPath string
+	extraHostKeys []string
+	hostKeyDir    string
+	vaultHostRole string
+	vaultHostMnt  string
+	vaultHostPrin []string
 	pubKeyPath    string
+	authKeysDir   string
 	serverPort    string
 	bindAddress   string
+	listenAddrs   []string
 	allowedCmds   string
-	noColor       bool
+	loginShell    string
+	acceptEnv     []string
+	enableSFTP    bool
+	sftpRoot      string
+	sftpReadOnly  bool
+	enableSCP     bool
+	chrootDir     string
+	runAsUser     bool
+	forceCommand  string
+	gitRoot       string
+	honeypot      bool
+	honeypotHost  string
+
+	allowAgentForward  bool
+	allowLocalForward  bool
+	permitOpen         []string
+	denyOpen           []string
+	allowRemoteForward bool
+	disableX11Forward  bool
+	permitListen       []string
+	userPermitListen   []string
+
+	passwordAuth  bool
+	passwordFile  string
+	requireTOTP   bool
+	totpDir       string
+	trustedCAKeys string
+
+	authHTTPURL          string
+	authHTTPTokenFile    string
+	authLDAPURL          string
+	authLDAPBaseDN       string
+	authLDAPBindDN       string
+	authLDAPBindPassFile string
+	authLDAPFilter       string
+	authLDAPKeyAttribute string
+	authLDAPStartTLS     bool
+	authDB               string
+	authDBTable          string
+	authDBRefresh        time.Duration
+
+	allowUsers    []string
+	denyUsers     []string
+	allowFrom     []string
+	proxyProtocol bool
+	trustedProxy  []string
+	geoIPPath     string
+	allowCountry  []string
+	denyCountry   []string
+	cryptoPolicy  string
+	serverVersion string
+	denyClientVer []string
+
+	maxAuthTries       int
+	authFailureDelay   time.Duration
+	maxConnections     int
+	maxSessionsPerUser int
+	idleTimeout        time.Duration
+	rateLimit          string
+	transferQuota      string
+	keepAlive          time.Duration
+	keepAliveCountMax  int
+	tcpKeepAlive       time.Duration
+	auditLogPath       string
+	recordDir          string
+	bannerPath         string
+	motdPath           string
+
+	shutdownTimeout string
+	controlSocket   string
+	noColor         bool
 )
 
 // serverCmd represents the server command
@@ -32,6 +121,105 @@ Examples:
   # Configure server options
   gossh server --key server.pem --authorized-keys authorized_keys --port 2222
 
+  # Authenticate each user against their own key file
+  gossh server --key server.pem --authorized-keys-dir /etc/gossh/authorized_keys.d
+
+  # Listen on IPv4, IPv6 loopback and a local Unix socket at once
+  gossh server --key server.pem --authorized-keys authorized_keys --listen 0.0.0.0:2022 --listen '[::1]:2022' --listen unix:///run/gossh.sock
+
+  # Offer RSA, ECDSA and Ed25519 host keys, generating them on first start
+  gossh server --host-key-dir /etc/gossh/host_keys --authorized-keys authorized_keys
+
+  # Give interactive sessions a real shell on a PTY
+  gossh server --key server.pem --authorized-keys authorized_keys --shell /bin/bash
+
+  # Offer read-only SFTP access to a single directory
+  gossh server --key server.pem --authorized-keys authorized_keys --sftp --sftp-root /srv/files --sftp-read-only
+
+  # Accept scp and rsync transfers into the same directory as SFTP
+  gossh server --key server.pem --authorized-keys authorized_keys --sftp --scp --sftp-root /srv/files
+
+  # Run shells and commands as the local account of each user (requires root)
+  gossh server --key server.pem --authorized-keys authorized_keys --shell /bin/bash --run-as-user
+
+  # Run one command for every connection; the client's request is in $SSH_ORIGINAL_COMMAND
+  gossh server --key server.pem --authorized-keys authorized_keys --shell /bin/sh --force-command /usr/local/bin/backup-receive
+
+  # Let commands use the client's forwarded agent (ssh -A), e.g. to clone from another host
+  gossh server --key server.pem --authorized-keys authorized_keys --shell /bin/bash --allow-agent-forwarding
+
+  # Never forward X11 connections to clients' displays (ssh -X)
+  gossh server --key server.pem --authorized-keys authorized_keys --shell /bin/bash --disable-x11-forwarding
+
+  # Host git repositories; keys push where their git-access="write:team/*" option allows
+  gossh server --key server.pem --authorized-keys authorized_keys --git-root /srv/git
+
+  # Build a file-drop endpoint that confines each user to their own directory
+  gossh server --key server.pem --authorized-keys authorized_keys --sftp --chroot-directory '/srv/drop/%u'
+
+  # Also accept passwords from an htpasswd-style bcrypt file
+  gossh server --key server.pem --authorized-keys authorized_keys --password-auth --password-file passwords
+
+  # Accept the sshPublicKey values of users' LDAP entries
+  gossh server --key server.pem --auth-ldap ldaps://ldap.example.com --auth-ldap-base-dn ou=people,dc=example,dc=com
+
+  # Ask an HTTP service whether each public key may log in
+  gossh server --key server.pem --auth-http https://auth.example.com/ssh --auth-http-token-file token
+
+  # Share centrally managed keys between servers through a Postgres table
+  gossh server --key server.pem --auth-db postgres://gossh@db.example.com/keys --auth-db-refresh 30s
+
+  # Read the host key from Vault and have Vault's SSH secrets engine certify it
+  gossh server --key vault://secret/data/gossh/host --vault-host-role host --vault-host-principals ssh.example.com
+
+  # Accept user certificates issued by a CA (see gossh issue)
+  gossh server --key server.pem --authorized-keys authorized_keys --trusted-user-ca-keys ca.pub
+
+  # Require a TOTP code from an authenticator app as a second factor
+  gossh server --key server.pem --authorized-keys authorized_keys --totp --totp-dir /etc/gossh/totp
+
+  # Reload authorized_keys after editing it, without dropping sessions
+  kill -HUP $(pidof gossh)
+
+  # Only let the deploy user in, and only from the internal network
+  gossh server --key server.pem --authorized-keys authorized_keys --allow-users 'deploy@10.0.0.0/8' --allow-from 10.0.0.0/8
+
+  # Only accept clients located in Germany or Austria according to a GeoLite2 database
+  gossh server --key server.pem --authorized-keys authorized_keys --geoip-db GeoLite2-Country.mmdb --allow-countries DE,AT
+
+  # Run behind HAProxy, taking client addresses from its PROXY protocol headers
+  gossh server --key server.pem --authorized-keys authorized_keys --proxy-protocol --trusted-proxies 10.0.0.5
+
+  # Run a honeypot: accept any login into a fake shell and record credentials and commands
+  gossh server --key server.pem --honeypot --audit-log /var/log/gossh/honeypot.json --port 22
+
+  # Announce a different software version and turn away libssh-based scanners
+  gossh server --key server.pem --authorized-keys authorized_keys --server-version OpenSSH_9.6 --deny-client-versions 'SSH-2.0-libssh*'
+
+  # Slow down password guessing and drop clients after three failures
+  gossh server --key server.pem --authorized-keys authorized_keys --max-auth-tries 3 --auth-failure-delay 2s
+
+  # Keep each session under 1 MiB/s and close it after 500 MiB
+  gossh server --key server.pem --authorized-keys authorized_keys --sftp --rate-limit 1M --transfer-quota 500M
+
+  # Probe clients every 30s and drop those that miss three answers in a row
+  gossh server --key server.pem --authorized-keys authorized_keys --keepalive-interval 30s --keepalive-count-max 3
+
+  # Refuse legacy key exchanges, ciphers and MACs
+  gossh server --key server.pem --authorized-keys authorized_keys --crypto-policy modern
+
+  # Keep a JSON audit trail of logins, commands and file transfers
+  gossh server --key server.pem --authorized-keys authorized_keys --audit-log /var/log/gossh/audit.log
+
+  # Show a legal notice before login and a message of the day after it
+  gossh server --key server.pem --authorized-keys authorized_keys --banner /etc/gossh/banner --motd /etc/motd
+
+  # Run as a systemd service with socket activation (see contrib/systemd)
+  systemctl enable --now gossh.socket
+
+  # Accept gossh serverctl commands on a control socket
+  gossh server --key server.pem --authorized-keys authorized_keys --control-socket /run/gossh/control.sock
+
   # Run with detailed logging
   gossh server --key server.pem --authorized-keys authorized_keys --log-level debug`,
 	Run: func(cmd *cobra.Command, args []string) {
@@ -48,25 +236,134 @@ Examples:
 		// Log the start of server initialization
 		log.Info("Initializing SSH server...")
 
-		// Read the server key
-		log.Debug("Reading private key from: ", serverKeyPath)
-		serverKeyBytes, err := os.ReadFile(serverKeyPath)
-		if err != nil {
-			log.Error("Failed to load server key: ", err)
-			fmt.Println(errorColor("✗ Failed to load server key: ") + err.Error())
-			os.Exit(1)
+		// Read the server key, which is optional when --host-key-dir provides the host keys
+		var serverKeyBytes []byte
+		var err error
+		if hostKeyDir == "" || cmd.Flags().Changed("key") {
+			log.Debug("Reading private key from: ", serverKeyPath)
+			serverKeyBytes, err = readKeySource(serverKeyPath)
+			if err != nil {
+				log.Error("Failed to load server key: ", err)
+				fmt.Println(errorColor("✗ Failed to load server key: ") + err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(successColor("✓ ") + "Server key loaded from " + infoColor(serverKeyPath))
 		}
-		fmt.Println(successColor("✓ ") + "Server key loaded from " + infoColor(serverKeyPath))
 
-		// Read the authorized keys
-		log.Debug("Reading authorized keys from: ", pubKeyPath)
-		authorizedKeysBytes, err := os.ReadFile(pubKeyPath)
-		if err != nil {
-			log.Error("Failed to load authorized keys: ", err)
-			fmt.Println(errorColor("✗ Failed to load authorized keys: ") + err.Error())
+		// Read additional host keys and generate any missing ones in --host-key-dir
+		var hostKeys [][]byte
+		for _, path := range extraHostKeys {
+			key, err := readKeySource(path)
+			if err != nil {
+				log.Error("Failed to load host key: ", err)
+				fmt.Println(errorColor("✗ Failed to load host key: ") + err.Error())
+				os.Exit(1)
+			}
+			hostKeys = append(hostKeys, key)
+			fmt.Println(successColor("✓ ") + "Host key loaded from " + infoColor(path))
+		}
+		if hostKeyDir != "" {
+			keys, err := ssh.EnsureHostKeys(hostKeyDir)
+			if err != nil {
+				log.Error("Failed to prepare host keys: ", err)
+				fmt.Println(errorColor("✗ Failed to prepare host keys: ") + err.Error())
+				os.Exit(1)
+			}
+			hostKeys = append(hostKeys, keys...)
+			fmt.Println(successColor("✓ ") + "RSA, ECDSA and Ed25519 host keys ready in " + infoColor(hostKeyDir))
+		}
+
+		// Have Vault's SSH secrets engine certify the host keys, so clients
+		// that trust its host CA need no known_hosts entries
+		var hostCerts [][]byte
+		if vaultHostRole != "" {
+			principals := vaultHostPrin
+			if len(principals) == 0 {
+				hostname, err := os.Hostname()
+				if err != nil {
+					log.Error("Failed to determine the host name: ", err)
+					fmt.Println(errorColor("✗ Failed to determine the host name: ") + err.Error())
+					os.Exit(1)
+				}
+				principals = []string{hostname}
+			}
+			hostCerts, err = vaultHostCertificates(append([][]byte{serverKeyBytes}, hostKeys...), vaultHostMnt, vaultHostRole, principals)
+			if err != nil {
+				log.Error("Failed to sign host keys with Vault: ", err)
+				fmt.Println(errorColor("✗ Failed to sign host keys with Vault: ") + err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(successColor("✓ ") + fmt.Sprintf("%d host certificates signed by Vault for %s", len(hostCerts), strings.Join(principals, ", ")))
+		}
+
+		// Read the shared authorized keys, which are optional when every
+		// user has a file in --authorized-keys-dir or keys come from a backend
+		var authorizedKeysBytes []byte
+		if (authKeysDir == "" && authHTTPURL == "" && authLDAPURL == "" && authDB == "" && !honeypot) || cmd.Flags().Changed("authorized-keys") {
+			log.Debug("Reading authorized keys from: ", pubKeyPath)
+			authorizedKeysBytes, err = os.ReadFile(pubKeyPath)
+			if err != nil {
+				log.Error("Failed to load authorized keys: ", err)
+				fmt.Println(errorColor("✗ Failed to load authorized keys: ") + err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(successColor("✓ ") + "Authorized keys loaded from " + infoColor(pubKeyPath))
+		}
+		if authKeysDir != "" {
+			fmt.Println(successColor("✓ ") + "Per-user authorized keys read from " + infoColor(authKeysDir))
+		}
+
+		// Read the trusted user CA keys, if certificate logins are enabled
+		var trustedCAKeyBytes []byte
+		if trustedCAKeys != "" {
+			log.Debug("Reading trusted user CA keys from: ", trustedCAKeys)
+			trustedCAKeyBytes, err = os.ReadFile(trustedCAKeys)
+			if err != nil {
+				log.Error("Failed to load trusted user CA keys: ", err)
+				fmt.Println(errorColor("✗ Failed to load trusted user CA keys: ") + err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(successColor("✓ ") + "Trusted user CA keys loaded from " + infoColor(trustedCAKeys))
+		}
+
+		// Read the pre-authentication banner and the message of the day
+		var banner, motd []byte
+		if bannerPath != "" {
+			banner, err = os.ReadFile(bannerPath)
+			if err != nil {
+				log.Error("Failed to load banner: ", err)
+				fmt.Println(errorColor("✗ Failed to load banner: ") + err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(successColor("✓ ") + "Banner loaded from " + infoColor(bannerPath))
+		}
+		if motdPath != "" {
+			motd, err = os.ReadFile(motdPath)
+			if err != nil {
+				log.Error("Failed to load MOTD: ", err)
+				fmt.Println(errorColor("✗ Failed to load MOTD: ") + err.Error())
+				os.Exit(1)
+			}
+			fmt.Println(successColor("✓ ") + "MOTD loaded from " + infoColor(motdPath))
+		}
+
+		// Open the audit log, appending to any existing file
+		if honeypot && auditLogPath == "" {
+			fmt.Println(errorColor("✗ --honeypot requires --audit-log to record what clients do"))
 			os.Exit(1)
 		}
-		fmt.Println(successColor("✓ ") + "Authorized keys loaded from " + infoColor(pubKeyPath))
+		var auditLog io.Writer
+		if auditLogPath != "" {
+			auditFile, err := os.OpenFile(auditLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+			if err != nil {
+				log.Error("Failed to open audit log: ", err)
+				fmt.Println(errorColor("✗ Failed to open audit log: ") + err.Error())
+				os.Exit(1)
+			}
+			defer auditFile.Close()
+			auditLog = auditFile
+			fmt.Println(successColor("✓ ") + "Audit events written to " + infoColor(auditLogPath))
+		}
 
 		// Print allowed commands if specified
 		if allowedCmds != "" {
@@ -75,13 +372,186 @@ Examples:
 			fmt.Println(infoColor("ℹ ") + "No command restrictions applied")
 		}
 
+		if loginShell != "" {
+			fmt.Println(infoColor("ℹ ") + "Interactive sessions run " + loginShell)
+		}
+		if allowAgentForward {
+			fmt.Println(infoColor("ℹ ") + "Agent forwarding enabled")
+		}
+		if allowLocalForward {
+			fmt.Println(infoColor("ℹ ") + "Local port forwarding enabled")
+		}
+		if allowRemoteForward {
+			fmt.Println(infoColor("ℹ ") + "Remote port forwarding enabled")
+		}
+		if disableX11Forward {
+			fmt.Println(infoColor("ℹ ") + "X11 forwarding disabled")
+		}
+		// Password authentication is opt-in and needs a credential file
+		var passwords ssh.PasswordStore
+		if passwordAuth {
+			if passwordFile == "" {
+				log.Error("--password-auth requires --password-file")
+				fmt.Println(errorColor("✗ --password-auth requires --password-file"))
+				os.Exit(1)
+			}
+			log.Debug("Reading password file from: ", passwordFile)
+			store, err := ssh.LoadPasswordFile(passwordFile)
+			if err != nil {
+				log.Error("Failed to load password file: ", err)
+				fmt.Println(errorColor("✗ Failed to load password file: ") + err.Error())
+				os.Exit(1)
+			}
+			passwords = store
+			fmt.Println(infoColor("ℹ ") + fmt.Sprintf("Password authentication enabled for %d users", len(store)))
+		}
+		authenticators, err := authBackends()
+		if err != nil {
+			log.Error("Invalid authentication backend: ", err)
+			fmt.Println(errorColor("✗ Invalid authentication backend: ") + err.Error())
+			os.Exit(1)
+		}
+		if authHTTPURL != "" {
+			fmt.Println(infoColor("ℹ ") + "Public keys also checked with " + authHTTPURL)
+		}
+		if authLDAPURL != "" {
+			fmt.Println(infoColor("ℹ ") + "Public keys also looked up in " + authLDAPURL)
+		}
+		if authDB != "" {
+			fmt.Println(infoColor("ℹ ") + fmt.Sprintf("Public keys also read from the %s table, refreshed every %s", authDBTable, authDBRefresh))
+		}
+		crypto, err := ssh.LookupCryptoPolicy(cryptoPolicy)
+		if err != nil {
+			log.Error("Invalid crypto policy: ", err)
+			fmt.Println(errorColor("✗ Invalid crypto policy: ") + err.Error())
+			os.Exit(1)
+		}
+		if cryptoPolicy != "default" {
+			fmt.Println(infoColor("ℹ ") + "Crypto policy: " + cryptoPolicy)
+		}
+		var geoIP ssh.CountryResolver
+		if geoIPPath != "" {
+			db, err := ssh.OpenGeoIPDatabase(geoIPPath)
+			if err != nil {
+				log.Error("Invalid GeoIP database: ", err)
+				fmt.Println(errorColor("✗ Invalid GeoIP database: ") + err.Error())
+				os.Exit(1)
+			}
+			defer db.Close()
+			geoIP = db
+			fmt.Println(infoColor("ℹ ") + "Client countries looked up in " + geoIPPath)
+		}
+		if len(allowCountry) > 0 {
+			fmt.Println(infoColor("ℹ ") + "Connections accepted from countries " + strings.Join(allowCountry, ", "))
+		}
+		if len(denyCountry) > 0 {
+			fmt.Println(infoColor("ℹ ") + "Connections refused from countries " + strings.Join(denyCountry, ", "))
+		}
+		if len(allowUsers) > 0 {
+			fmt.Println(infoColor("ℹ ") + "Logins limited to users " + strings.Join(allowUsers, ", "))
+		}
+		if len(denyUsers) > 0 {
+			fmt.Println(infoColor("ℹ ") + "Logins denied to users " + strings.Join(denyUsers, ", "))
+		}
+		if len(allowFrom) > 0 {
+			fmt.Println(infoColor("ℹ ") + "Connections accepted from " + strings.Join(allowFrom, ", "))
+		}
+		if len(denyClientVer) > 0 {
+			fmt.Println(infoColor("ℹ ") + "Clients disconnected when their version matches " + strings.Join(denyClientVer, ", "))
+		}
+		if requireTOTP {
+			fmt.Println(infoColor("ℹ ") + "TOTP verification codes required, secrets read from " + totpDir)
+		}
+		if cmd.Flags().Changed("max-auth-tries") || authFailureDelay > 0 {
+			tries := formatLimit(maxAuthTries)
+			if maxAuthTries == 0 {
+				tries = "6"
+			}
+			fmt.Println(infoColor("ℹ ") + fmt.Sprintf("Authentication: %s attempts per connection, %s delay after each failure",
+				tries, authFailureDelay))
+		}
+		if maxConnections > 0 || maxSessionsPerUser > 0 {
+			fmt.Println(infoColor("ℹ ") + fmt.Sprintf("Limits: %s connections, %s sessions per user",
+				formatLimit(maxConnections), formatLimit(maxSessionsPerUser)))
+		}
+		if proxyProtocol {
+			from := "any address"
+			if len(trustedProxy) > 0 {
+				from = strings.Join(trustedProxy, ", ")
+			}
+			fmt.Println(infoColor("ℹ ") + "PROXY protocol headers expected from " + from)
+		}
+		if idleTimeout > 0 {
+			fmt.Println(infoColor("ℹ ") + "Idle sessions closed after " + idleTimeout.String())
+		}
+		if rateLimit != "" || transferQuota != "" {
+			limit, quota := "unlimited", "unlimited"
+			if rateLimit != "" {
+				limit = rateLimit + "B/s"
+			}
+			if transferQuota != "" {
+				quota = transferQuota + "B"
+			}
+			fmt.Println(infoColor("ℹ ") + fmt.Sprintf("Session bandwidth: %s, transfer quota: %s", limit, quota))
+		}
+		if keepAlive > 0 {
+			fmt.Println(infoColor("ℹ ") + fmt.Sprintf("Keepalive requests every %s, disconnecting after %d unanswered", keepAlive, keepAliveCountMax))
+		}
+		if recordDir != "" {
+			fmt.Println(infoColor("ℹ ") + "Interactive sessions recorded to " + recordDir)
+		}
+		if enableSFTP {
+			mode := "read-write"
+			if sftpReadOnly {
+				mode = "read-only"
+			}
+			root := sftpRoot
+			if root == "" {
+				root = "/"
+			}
+			fmt.Println(infoColor("ℹ ") + "SFTP enabled (" + mode + ") rooted at " + root)
+		}
+		if enableSCP {
+			fmt.Println(infoColor("ℹ ") + "scp and rsync transfers enabled, with the SFTP root and mode")
+		}
+
+		if gitRoot != "" {
+			fmt.Println(infoColor("ℹ ") + "Git repositories served from " + gitRoot)
+		}
+		if forceCommand != "" {
+			fmt.Println(infoColor("ℹ ") + "Every session runs " + forceCommand)
+		}
+		if honeypot {
+			fmt.Println(infoColor("ℹ ") + "Honeypot mode: every login is accepted into a fake shell and recorded")
+		}
+		if runAsUser {
+			fmt.Println(infoColor("ℹ ") + "Sessions run as the local account of each user")
+		}
+		if chrootDir != "" {
+			fmt.Println(infoColor("ℹ ") + "Sessions confined to " + chrootDir)
+		}
+
 		// Print server configuration
 		fmt.Println()
 		fmt.Println(successColor("→ ") + "Starting SSH server with configuration:")
-		fmt.Printf("  • Bind Address: %s\n", infoColor(bindAddress))
-		fmt.Printf("  • Port: %s\n", infoColor(serverPort))
-		fmt.Printf("  • Private Key: %s\n", infoColor(serverKeyPath))
-		fmt.Printf("  • Authorized Keys: %s\n", infoColor(pubKeyPath))
+		if len(listenAddrs) > 0 {
+			fmt.Printf("  • Listen: %s\n", infoColor(strings.Join(listenAddrs, ", ")))
+		} else {
+			fmt.Printf("  • Bind Address: %s\n", infoColor(bindAddress))
+			fmt.Printf("  • Port: %s\n", infoColor(serverPort))
+		}
+		if serverKeyBytes != nil {
+			fmt.Printf("  • Private Key: %s\n", infoColor(serverKeyPath))
+		}
+		if len(hostKeys) > 0 {
+			fmt.Printf("  • Additional Host Keys: %d\n", len(hostKeys))
+		}
+		if authorizedKeysBytes != nil {
+			fmt.Printf("  • Authorized Keys: %s\n", infoColor(pubKeyPath))
+		}
+		if authKeysDir != "" {
+			fmt.Printf("  • Authorized Keys Dir: %s\n", infoColor(authKeysDir))
+		}
 		fmt.Println()
 
 		// Simulate server startup countdown for visual appeal
@@ -93,28 +563,432 @@ Examples:
 		fmt.Println(successColor("Launched!"))
 
 		// Actually start the server
-		log.Info("SSH server starting on ", bindAddress, ":", serverPort)
-		if err = ssh.StartServer(serverKeyBytes, authorizedKeysBytes); err != nil {
+		opts := ssh.ServerOptions{
+			BindAddress:     bindAddress,
+			Port:            serverPort,
+			ListenAddrs:     listenAddrs,
+			AllowedCommands: splitCommandList(allowedCmds),
+			Shell:           loginShell,
+			AcceptEnv:       acceptEnv,
+			SFTP:            enableSFTP,
+			SFTPRoot:        sftpRoot,
+			SFTPReadOnly:    sftpReadOnly,
+			SCP:             enableSCP,
+			ChrootDirectory: chrootDir,
+			RunAsUser:       runAsUser,
+			ForceCommand:    forceCommand,
+			GitRoot:         gitRoot,
+
+			Honeypot:         honeypot,
+			HoneypotHostname: honeypotHost,
+
+			AllowAgentForwarding: allowAgentForward,
+			AllowLocalForwarding: allowLocalForward,
+			LocalForwardPolicy: ssh.ForwardPolicy{
+				Allow: permitOpen,
+				Deny:  denyOpen,
+			},
+			AllowRemoteForwarding: allowRemoteForward,
+			RemoteForwardPolicy:   ssh.ForwardPolicy{Allow: permitListen},
+			DisableX11Forwarding:  disableX11Forward,
+
+			PasswordAuth:  passwordAuth,
+			Passwords:     passwords,
+			TOTP:          requireTOTP,
+			TOTPSecretDir: totpDir,
+
+			TrustedUserCAKeys: trustedCAKeyBytes,
+			AuthorizedKeysDir: authKeysDir,
+			Authenticators:    authenticators,
+			AllowUsers:        allowUsers,
+			DenyUsers:         denyUsers,
+			AllowFrom:         allowFrom,
+			ProxyProtocol:     proxyProtocol,
+			TrustedProxies:    trustedProxy,
+			GeoIP:             geoIP,
+			AllowCountries:    allowCountry,
+			DenyCountries:     denyCountry,
+			Crypto:            crypto,
+
+			ServerVersion:      serverVersion,
+			DenyClientVersions: denyClientVer,
+			MaxAuthTries:       maxAuthTries,
+			AuthFailureDelay:   authFailureDelay,
+			MaxConnections:     maxConnections,
+			MaxSessionsPerUser: maxSessionsPerUser,
+			IdleTimeout:        idleTimeout,
+			KeepAliveInterval:  keepAlive,
+			KeepAliveCountMax:  keepAliveCountMax,
+			TCPKeepAlive:       tcpKeepAlive,
+			AuditLog:           auditLog,
+			RecordDir:          recordDir,
+			Banner:             string(banner),
+			MOTD:               string(motd),
+			HostKeys:           hostKeys,
+			HostCertificates:   hostCerts,
+			Logger:             newSlogLogger(log),
+		}
+		opts.UserRemoteForwardPolicies, err = parseUserPolicies(userPermitListen)
+		if err != nil {
+			log.Error("Invalid forwarding policy: ", err)
+			fmt.Println(errorColor("✗ Invalid forwarding policy: ") + err.Error())
+			os.Exit(1)
+		}
+		if opts.RateLimit, err = parseByteSize(rateLimit); err != nil {
+			log.Error("Invalid rate limit: ", err)
+			fmt.Println(errorColor("✗ Invalid rate limit: ") + err.Error())
+			os.Exit(1)
+		}
+		if opts.TransferQuota, err = parseByteSize(transferQuota); err != nil {
+			log.Error("Invalid transfer quota: ", err)
+			fmt.Println(errorColor("✗ Invalid transfer quota: ") + err.Error())
+			os.Exit(1)
+		}
+		server, err := ssh.NewServer(serverKeyBytes, authorizedKeysBytes, opts)
+		if err != nil {
+			log.Error("Server error: ", err)
+			fmt.Println(errorColor("\n✗ Server failed: ") + err.Error())
+			os.Exit(1)
+		}
+
+		// Drain active sessions on SIGINT/SIGTERM instead of dropping them
+		drainTimeout, err := time.ParseDuration(shutdownTimeout)
+		if err != nil {
+			log.Error("Invalid shutdown timeout: ", err)
+			fmt.Println(errorColor("✗ Invalid shutdown timeout: ") + err.Error())
+			os.Exit(1)
+		}
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-sigs
+			log.Info("Received ", sig, ", shutting down")
+			fmt.Println(infoColor("\nℹ ") + "Shutting down, waiting up to " + drainTimeout.String() + " for active sessions")
+			ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer cancel()
+			if err := server.Shutdown(ctx); err != nil {
+				log.Warn("Forced shutdown: ", err)
+			}
+		}()
+
+		// Re-read the shared authorized keys on SIGHUP so keys can be added or
+		// revoked without restarting and dropping active sessions
+		if authorizedKeysBytes != nil {
+			hups := make(chan os.Signal, 1)
+			signal.Notify(hups, syscall.SIGHUP)
+			go func() {
+				for range hups {
+					if err := reloadAuthorizedKeys(server, pubKeyPath); err != nil {
+						log.Error("Failed to reload authorized keys: ", err)
+						continue
+					}
+					log.Info("Reloaded authorized keys from ", pubKeyPath)
+				}
+			}()
+		}
+
+		// Let gossh serverctl and gossh server sessions manage the running server
+		if controlSocket != "" {
+			if authorizedKeysBytes != nil {
+				server.HandleControl("reload", func(req ssh.ControlRequest) (ssh.ControlResponse, error) {
+					if err := reloadAuthorizedKeys(server, pubKeyPath); err != nil {
+						return ssh.ControlResponse{}, fmt.Errorf("could not reload authorized keys: %s", err)
+					}
+					log.Info("Reloaded authorized keys from ", pubKeyPath)
+					return ssh.ControlResponse{Message: "reloaded authorized keys from " + pubKeyPath}, nil
+				})
+			}
+			server.HandleControl("log-level", setLogLevel)
+			listener, err := ssh.ListenControl(controlSocket)
+			if err != nil {
+				log.Error("Failed to open control socket: ", err)
+				fmt.Println(errorColor("✗ Failed to open control socket: ") + err.Error())
+				os.Exit(1)
+			}
+			go server.ServeControl(context.Background(), listener)
+			defer listener.Close()
+			log.Info("Control socket listening on ", controlSocket)
+		}
+
+		log.Info("SSH server starting on ", strings.Join(opts.Endpoints(), ", "))
+		listeners, err := serverListeners(opts.Endpoints())
+		if err != nil {
+			log.Error("Server error: ", err)
+			fmt.Println(errorColor("\n✗ Server failed: ") + err.Error())
+			os.Exit(1)
+		}
+		addrs := make([]string, 0, len(listeners))
+		for _, listener := range listeners {
+			addrs = append(addrs, listener.Addr().String())
+		}
+
+		// Under a Type=notify systemd service, report readiness and keep the watchdog fed
+		if _, err := ssh.SystemdNotify("READY=1\nSTATUS=Accepting connections on " + strings.Join(addrs, ", ")); err != nil {
+			log.Warn("Failed to notify systemd: ", err)
+		}
+		watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+		go func() {
+			if err := ssh.SystemdWatchdog(watchdogCtx); err != nil {
+				log.Warn("Systemd watchdog stopped: ", err)
+			}
+		}()
+
+		if err = server.ServeListeners(context.Background(), listeners...); err != nil && !errors.Is(err, ssh.ErrServerClosed) {
 			log.Error("Server error: ", err)
 			fmt.Println(errorColor("\n✗ Server failed: ") + err.Error())
 			os.Exit(1)
 		}
+		ssh.SystemdNotify("STOPPING=1")
+		// Serve returns once the server stops listening; let a drain finish
+		server.Wait()
+		stopWatchdog()
+		fmt.Println(successColor("✓ ") + "Server stopped")
 	},
 }
 
+// serverListeners returns the sockets systemd passed through socket
+// activation, or listens on endpoints when the server was not socket activated
+func serverListeners(endpoints []string) ([]net.Listener, error) {
+	listeners, err := ssh.SystemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		return ssh.ListenAll(endpoints)
+	}
+	for _, listener := range listeners {
+		log.Info("Using socket passed by systemd: ", listener.Addr())
+	}
+	return listeners, nil
+}
+
+// authBackends builds the authentication backends selected with the
+// --auth-http, --auth-ldap and --auth-db flags, reading their secrets from files
+func authBackends() ([]ssh.Authenticator, error) {
+	var backends []ssh.Authenticator
+	if authHTTPURL != "" {
+		backend := &ssh.HTTPAuthenticator{URL: authHTTPURL}
+		if authHTTPTokenFile != "" {
+			token, err := os.ReadFile(authHTTPTokenFile)
+			if err != nil {
+				return nil, err
+			}
+			backend.Token = strings.TrimSpace(string(token))
+		}
+		backends = append(backends, backend)
+	}
+	if authLDAPURL != "" {
+		if authLDAPBaseDN == "" {
+			return nil, errors.New("--auth-ldap requires --auth-ldap-base-dn")
+		}
+		backend := &ssh.LDAPAuthenticator{
+			URL:          authLDAPURL,
+			BaseDN:       authLDAPBaseDN,
+			BindDN:       authLDAPBindDN,
+			Filter:       authLDAPFilter,
+			KeyAttribute: authLDAPKeyAttribute,
+			StartTLS:     authLDAPStartTLS,
+		}
+		if authLDAPBindPassFile != "" {
+			password, err := os.ReadFile(authLDAPBindPassFile)
+			if err != nil {
+				return nil, err
+			}
+			backend.BindPassword = strings.TrimRight(string(password), "\r\n")
+		}
+		backends = append(backends, backend)
+	}
+	if authDB != "" {
+		if authDBRefresh <= 0 {
+			return nil, errors.New("--auth-db-refresh must be positive")
+		}
+		db, err := openKeyDatabase(authDB)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		backend, err := ssh.NewSQLAuthenticator(ctx, db, authDBTable)
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+		go backend.Watch(context.Background(), authDBRefresh)
+		backends = append(backends, backend)
+	}
+	return backends, nil
+}
+
+// openKeyDatabase opens the --auth-db database: a postgres:// URL, or a
+// SQLite file given as sqlite:///path/keys.db
+func openKeyDatabase(dsn string) (*sql.DB, error) {
+	switch {
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		return sql.Open("postgres", dsn)
+	case strings.HasPrefix(dsn, "sqlite://"):
+		return sql.Open("sqlite", "file:"+strings.TrimPrefix(dsn, "sqlite://")+"?mode=ro")
+	}
+	return nil, fmt.Errorf("unsupported key database %q, want a postgres:// or sqlite:// URL", dsn)
+}
+
+// reloadAuthorizedKeys re-reads the authorized keys file into a running server
+func reloadAuthorizedKeys(server *ssh.Server, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return server.ReloadAuthorizedKeys(data)
+}
+
+// setLogLevel changes the log level of the running server for gossh serverctl log-level
+func setLogLevel(req ssh.ControlRequest) (ssh.ControlResponse, error) {
+	level, err := logrus.ParseLevel(req.Level)
+	if err != nil {
+		return ssh.ControlResponse{}, err
+	}
+	log.SetLevel(level)
+	log.Info("Log level set to ", level)
+	return ssh.ControlResponse{Message: "log level set to " + level.String()}, nil
+}
+
+// formatLimit renders a connection or session limit, where zero means unlimited
+func formatLimit(limit int) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(limit)
+}
+
+// parseByteSize parses a byte count such as 512K, 10M or 1G, with binary
+// multiples; an empty size is zero
+func parseByteSize(size string) (int64, error) {
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	if number == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for i, unit := range []string{"K", "M", "G", "T"} {
+		if trimmed, ok := strings.CutSuffix(number, unit); ok {
+			number, multiplier = trimmed, int64(1)<<(10*(i+1))
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/multiplier {
+		return 0, fmt.Errorf("invalid size %q, expected bytes or a number with K, M, G or T", size)
+	}
+	return n * multiplier, nil
+}
+
+// splitCommandList turns the comma-separated --allowed-commands value into a list
+func splitCommandList(list string) []string {
+	var cmds []string
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			cmds = append(cmds, c)
+		}
+	}
+	return cmds
+}
+
+// parseUserPolicies turns repeated user=host:port flags into per-user forwarding policies
+func parseUserPolicies(entries []string) (map[string]ssh.ForwardPolicy, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	policies := map[string]ssh.ForwardPolicy{}
+	for _, entry := range entries {
+		user, pattern, ok := strings.Cut(entry, "=")
+		if !ok || user == "" || pattern == "" {
+			return nil, fmt.Errorf("expected user=host:port, got %q", entry)
+		}
+		policy := policies[user]
+		policy.Allow = append(policy.Allow, pattern)
+		policies[user] = policy
+	}
+	return policies, nil
+}
+
 func init() {
 	rootCmd.AddCommand(serverCmd)
 
 	// Define flags for the server command
-	serverCmd.Flags().StringVarP(&serverKeyPath, "key", "k", "server.pem", "Path to the server private key")
+	serverCmd.Flags().StringVarP(&serverKeyPath, "key", "k", "server.pem", "Path to the server private key, or vault://<path>#<field> to read it from Vault")
+	serverCmd.Flags().StringSliceVar(&extraHostKeys, "host-key", nil, "Additional host private keys, e.g. an ECDSA key next to an RSA --key; vault:// paths allowed (repeatable)")
+	serverCmd.Flags().StringVar(&hostKeyDir, "host-key-dir", "", "Directory of RSA, ECDSA and Ed25519 host keys, generated on first start if missing")
+	serverCmd.Flags().StringVar(&vaultHostRole, "vault-host-role", "", "Have this role of Vault's SSH secrets engine sign the host keys at startup (uses VAULT_ADDR and VAULT_TOKEN)")
+	serverCmd.Flags().StringVar(&vaultHostMnt, "vault-ssh-mount", ssh.DefaultVaultSSHMount, "Mount path of Vault's SSH secrets engine")
+	serverCmd.Flags().StringSliceVar(&vaultHostPrin, "vault-host-principals", nil, "Host names the Vault host certificates are valid for (defaults to the host name)")
 	serverCmd.Flags().StringVarP(&pubKeyPath, "authorized-keys", "a", "authorized_keys", "Path to the authorized keys file")
+	serverCmd.Flags().StringVar(&authKeysDir, "authorized-keys-dir", "", "Directory with one authorized_keys file per user, e.g. /etc/gossh/authorized_keys.d")
 	serverCmd.Flags().StringVarP(&serverPort, "port", "p", "2022", "Port for the SSH server to listen on")
 	serverCmd.Flags().StringVarP(&bindAddress, "bind", "b", "0.0.0.0", "Address to bind the SSH server to")
+	serverCmd.Flags().StringArrayVar(&listenAddrs, "listen", nil, "Endpoint to listen on, host:port or unix:///path (repeatable, replaces --bind and --port)")
 	serverCmd.Flags().StringVar(&allowedCmds, "allowed-commands", "", "Comma-separated list of allowed commands (empty for unrestricted)")
+	serverCmd.Flags().StringVar(&loginShell, "shell", "", "Shell to run for interactive sessions, e.g. /bin/bash (empty for the built-in prompt)")
+	serverCmd.Flags().StringSliceVar(&acceptEnv, "accept-env", nil, "Environment variables clients may set, wildcards allowed (e.g. LANG,LC_*)")
+	serverCmd.Flags().BoolVar(&enableSFTP, "sftp", false, "Enable the SFTP subsystem")
+	serverCmd.Flags().StringVar(&sftpRoot, "sftp-root", "", "Confine SFTP clients to this directory")
+	serverCmd.Flags().BoolVar(&sftpReadOnly, "sftp-read-only", false, "Reject SFTP operations that modify files")
+	serverCmd.Flags().BoolVar(&enableSCP, "scp", false, "Serve scp (legacy protocol) and rsync transfers, confined like SFTP by --sftp-root and --sftp-read-only")
+	serverCmd.Flags().StringVar(&chrootDir, "chroot-directory", "", "Confine exec, shell and SFTP sessions to this directory; %u is the user name (chroot needs root)")
+	serverCmd.Flags().BoolVar(&runAsUser, "run-as-user", false, "Run shells and commands as the local account named after the SSH user (requires root)")
+	serverCmd.Flags().StringVar(&forceCommand, "force-command", "", "Run this command for every session, with the client's command in SSH_ORIGINAL_COMMAND (internal-sftp serves SFTP)")
+	serverCmd.Flags().StringVar(&gitRoot, "git-root", "", "Serve git fetches and pushes for the repositories under this directory")
+	serverCmd.Flags().BoolVar(&honeypot, "honeypot", false, "Accept any credentials into a fake shell and record logins, commands and forwarding attempts in --audit-log")
+	serverCmd.Flags().StringVar(&honeypotHost, "honeypot-hostname", ssh.DefaultHoneypotHostname, "Host name shown by the honeypot's fake shell")
+	serverCmd.Flags().BoolVar(&allowAgentForward, "allow-agent-forwarding", false, "Allow clients to forward their agent (-A) to their sessions through SSH_AUTH_SOCK")
+	serverCmd.Flags().BoolVar(&allowLocalForward, "allow-local-forwarding", false, "Allow clients to open local (-L) port forwards")
+	serverCmd.Flags().StringSliceVar(&permitOpen, "permit-open", nil, "Destinations local forwards may reach, as host:port patterns (empty for any)")
+	serverCmd.Flags().StringSliceVar(&denyOpen, "deny-open", nil, "Destinations local forwards may never reach, as host:port patterns")
+	serverCmd.Flags().BoolVar(&allowRemoteForward, "allow-remote-forwarding", false, "Allow clients to open remote (-R) port forwards")
+	serverCmd.Flags().StringSliceVar(&permitListen, "permit-listen", nil, "Addresses remote forwards may listen on, as host:port patterns (empty for any)")
+	serverCmd.Flags().StringArrayVar(&userPermitListen, "user-permit-listen", nil, "Per-user remote forward listen pattern as user=host:port (repeatable, replaces --permit-listen for that user)")
+	serverCmd.Flags().BoolVar(&disableX11Forward, "disable-x11-forwarding", false, "Refuse X11 forwarding (-X) to clients' displays")
+	serverCmd.Flags().BoolVar(&passwordAuth, "password-auth", false, "Allow password authentication in addition to public keys")
+	serverCmd.Flags().StringVar(&authHTTPURL, "auth-http", "", "URL asked with a JSON POST whether public keys missing from the authorized keys may log in")
+	serverCmd.Flags().StringVar(&authHTTPTokenFile, "auth-http-token-file", "", "File holding a bearer token sent to --auth-http")
+	serverCmd.Flags().StringVar(&authLDAPURL, "auth-ldap", "", "LDAP server to look up users' sshPublicKey values in, e.g. ldaps://ldap.example.com")
+	serverCmd.Flags().StringVar(&authLDAPBaseDN, "auth-ldap-base-dn", "", "Base DN of the search for user entries")
+	serverCmd.Flags().StringVar(&authLDAPBindDN, "auth-ldap-bind-dn", "", "DN to bind as for the lookup (empty binds anonymously)")
+	serverCmd.Flags().StringVar(&authLDAPBindPassFile, "auth-ldap-bind-password-file", "", "File holding the password of --auth-ldap-bind-dn")
+	serverCmd.Flags().StringVar(&authLDAPFilter, "auth-ldap-filter", ssh.DefaultLDAPFilter, "Filter selecting a user's entry; %u is the user name")
+	serverCmd.Flags().StringVar(&authLDAPKeyAttribute, "auth-ldap-key-attribute", ssh.DefaultLDAPKeyAttribute, "Attribute holding a user's public keys")
+	serverCmd.Flags().BoolVar(&authLDAPStartTLS, "auth-ldap-starttls", false, "Upgrade ldap:// connections with StartTLS")
+	serverCmd.Flags().StringVar(&authDB, "auth-db", "", "Database holding authorized keys: postgres://... or sqlite:///path/keys.db")
+	serverCmd.Flags().StringVar(&authDBTable, "auth-db-table", ssh.DefaultSQLKeyTable, "Table with user, key, options and expiry columns")
+	serverCmd.Flags().DurationVar(&authDBRefresh, "auth-db-refresh", time.Minute, "How often to re-read the keys from --auth-db")
+	serverCmd.Flags().StringVar(&passwordFile, "password-file", "", "File of user:bcrypt-hash lines used for password authentication (htpasswd -B format)")
+	serverCmd.Flags().BoolVar(&requireTOTP, "totp", false, "Require a TOTP verification code after public key or password authentication")
+	serverCmd.Flags().StringVar(&totpDir, "totp-dir", "", "Directory with one file per user holding the user's base32 TOTP secret")
+	serverCmd.Flags().StringVar(&trustedCAKeys, "trusted-user-ca-keys", "", "File of CA public keys whose user certificates are accepted")
+	serverCmd.Flags().StringSliceVar(&allowUsers, "allow-users", nil, "Only these users may log in, as USER or USER@HOST patterns (e.g. deploy@10.0.0.0/8)")
+	serverCmd.Flags().StringSliceVar(&denyUsers, "deny-users", nil, "Users who may never log in, as USER or USER@HOST patterns; checked before --allow-users")
+	serverCmd.Flags().StringSliceVar(&allowFrom, "allow-from", nil, "Client addresses that may connect, as IP wildcards or CIDR blocks, ! to exclude (empty for any)")
+	serverCmd.Flags().BoolVar(&proxyProtocol, "proxy-protocol", false, "Expect a PROXY protocol v1/v2 header on connections and use the client address it names")
+	serverCmd.Flags().StringSliceVar(&trustedProxy, "trusted-proxies", nil, "Addresses of proxies that send PROXY headers, as IP wildcards or CIDR blocks (empty for any)")
+	serverCmd.Flags().StringVar(&geoIPPath, "geoip-db", "", "MaxMind DB file (e.g. GeoLite2-Country.mmdb) for country filters and the audit log's country field")
+	serverCmd.Flags().StringSliceVar(&allowCountry, "allow-countries", nil, "Only accept clients from these ISO country codes, -- for addresses the database cannot place (requires --geoip-db)")
+	serverCmd.Flags().StringSliceVar(&denyCountry, "deny-countries", nil, "Refuse clients from these ISO country codes (requires --geoip-db)")
+	serverCmd.Flags().StringVar(&cryptoPolicy, "crypto-policy", "default", "Algorithms clients may negotiate: "+strings.Join(ssh.CryptoPolicyNames(), ", "))
+	serverCmd.Flags().StringVar(&serverVersion, "server-version", "", "Software version announced to clients, e.g. OpenSSH_9.6 (SSH-2.0- is added)")
+	serverCmd.Flags().StringSliceVar(&denyClientVer, "deny-client-versions", nil, "Disconnect clients whose version string matches one of these patterns, e.g. 'SSH-2.0-libssh*'")
+	serverCmd.Flags().IntVar(&maxAuthTries, "max-auth-tries", 6, "Disconnect clients after this many failed authentication attempts (negative for unlimited)")
+	serverCmd.Flags().DurationVar(&authFailureDelay, "auth-failure-delay", 0, "Wait this long after each failed authentication attempt, e.g. 1s")
+	serverCmd.Flags().IntVar(&maxConnections, "max-connections", 0, "Maximum simultaneous client connections (0 for unlimited)")
+	serverCmd.Flags().IntVar(&maxSessionsPerUser, "max-sessions-per-user", 0, "Maximum open sessions per user across connections (0 for unlimited)")
+	serverCmd.Flags().DurationVar(&idleTimeout, "idle-timeout", 0, "Close sessions without traffic for this long, e.g. 15m (0 to disable)")
+	serverCmd.Flags().StringVar(&rateLimit, "rate-limit", "", "Bytes per second each session may send and receive, e.g. 512K or 10M (empty for unlimited)")
+	serverCmd.Flags().StringVar(&transferQuota, "transfer-quota", "", "Bytes a session may transfer before it is closed, e.g. 1G (empty for unlimited)")
+	serverCmd.Flags().DurationVar(&keepAlive, "keepalive-interval", 0, "Send clients a keepalive request this often, e.g. 30s (0 to disable)")
+	serverCmd.Flags().IntVar(&keepAliveCountMax, "keepalive-count-max", ssh.DefaultKeepAliveCountMax, "Disconnect clients after this many unanswered keepalive requests")
+	serverCmd.Flags().DurationVar(&tcpKeepAlive, "tcp-keepalive", 0, "TCP keepalive probe period on accepted connections (0 for the default of 15s, negative to disable)")
+	serverCmd.Flags().StringVar(&auditLogPath, "audit-log", "", "Append JSON audit events (logins, sessions, commands, file transfers) to this file")
+	serverCmd.Flags().StringVar(&bannerPath, "banner", "", "File shown to clients before authentication; may use {{.RemoteIP}}, {{.User}} and {{.Hostname}}")
+	serverCmd.Flags().StringVar(&motdPath, "motd", "", "Message of the day file printed when an interactive session starts, with the same variables as --banner")
+	serverCmd.Flags().StringVar(&recordDir, "record-dir", "", "Record interactive sessions as asciicast files in this directory (see gossh audit replay)")
+	serverCmd.Flags().StringVar(&shutdownTimeout, "shutdown-timeout", "10s", "How long to wait for active sessions to finish on shutdown")
 	serverCmd.Flags().BoolVar(&noColor, "no-color", false, "Disable color output")
+	serverCmd.PersistentFlags().StringVar(&controlSocket, "control-socket", "", "Unix socket for administration with gossh serverctl and gossh server sessions (empty to disable)")
 
-	// Mark required flags
-	serverCmd.MarkFlagRequired("key")
-	serverCmd.MarkFlagRequired("authorized-keys")
 }
-
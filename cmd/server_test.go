@@ -2,10 +2,16 @@
 package cmd
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
 )
 
 // TestServerValidation tests the server command validation
@@ -103,3 +109,188 @@ func TestKeyFileReadability(t *testing.T) {
 		t.Errorf("Failed to read auth keys file: %v", err)
 	}
 }
+
+// TestSplitCommandList tests parsing of the --allowed-commands flag
+func TestSplitCommandList(t *testing.T) {
+	tests := []struct {
+		name string
+		list string
+		want []string
+	}{
+		{"empty", "", nil},
+		{"single", "whoami", []string{"whoami"}},
+		{"multiple with spaces", "whoami, uptime ,df", []string{"whoami", "uptime", "df"}},
+		{"trailing comma", "whoami,", []string{"whoami"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitCommandList(tt.list)
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("splitCommandList(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseUserPolicies tests parsing of the --user-permit-listen flag
+func TestParseUserPolicies(t *testing.T) {
+	policies, err := parseUserPolicies([]string{"alice=127.0.0.1:8080", "alice=127.0.0.1:9090", "bob=*:*"})
+	if err != nil {
+		t.Fatalf("parseUserPolicies() error = %v", err)
+	}
+	if got := policies["alice"].Allow; len(got) != 2 || got[1] != "127.0.0.1:9090" {
+		t.Errorf("alice's policy = %v, want two patterns", got)
+	}
+	if got := policies["bob"].Allow; len(got) != 1 || got[0] != "*:*" {
+		t.Errorf("bob's policy = %v, want [*:*]", got)
+	}
+
+	for _, bad := range []string{"alice", "=127.0.0.1:80", "alice="} {
+		if _, err := parseUserPolicies([]string{bad}); err == nil {
+			t.Errorf("parseUserPolicies(%q) should fail", bad)
+		}
+	}
+}
+
+func TestFormatLimit(t *testing.T) {
+	tests := []struct {
+		limit int
+		want  string
+	}{
+		{0, "unlimited"},
+		{-1, "unlimited"},
+		{25, "25"},
+	}
+
+	for _, tt := range tests {
+		if got := formatLimit(tt.limit); got != tt.want {
+			t.Errorf("formatLimit(%d) = %q, want %q", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"4096", 4096, false},
+		{"512K", 512 << 10, false},
+		{"10m", 10 << 20, false},
+		{"1GB", 1 << 30, false},
+		{"-1", 0, true},
+		{"1.5M", 0, true},
+		{"10X", 0, true},
+		{"99999999T", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.size)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d, error %v", tt.size, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// TestAuthBackends tests building backends from the --auth-* flags
+func TestAuthBackends(t *testing.T) {
+	defer func() {
+		authHTTPURL, authHTTPTokenFile, authLDAPURL, authLDAPBaseDN, authLDAPBindPassFile, authDB = "", "", "", "", "", ""
+	}()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "token"), []byte("s3cret\n"), 0600)
+	os.WriteFile(filepath.Join(dir, "bindpw"), []byte("hunter2\n"), 0600)
+
+	if backends, err := authBackends(); err != nil || len(backends) != 0 {
+		t.Errorf("authBackends() without flags = %v, %v, want none", backends, err)
+	}
+
+	authHTTPURL, authHTTPTokenFile = "https://auth.example.com/ssh", filepath.Join(dir, "token")
+	authLDAPURL, authLDAPBindPassFile = "ldap://ldap.example.com", filepath.Join(dir, "bindpw")
+	if _, err := authBackends(); err == nil {
+		t.Error("authBackends() should require a base DN for LDAP")
+	}
+	authLDAPBaseDN = "dc=example,dc=com"
+	backends, err := authBackends()
+	if err != nil || len(backends) != 2 {
+		t.Fatalf("authBackends() = %v, %v, want two backends", backends, err)
+	}
+	if http, ok := backends[0].(*ssh.HTTPAuthenticator); !ok || http.Token != "s3cret" {
+		t.Errorf("HTTP backend = %+v, want the token from the file", backends[0])
+	}
+	if ldap, ok := backends[1].(*ssh.LDAPAuthenticator); !ok || ldap.BindPassword != "hunter2" {
+		t.Errorf("LDAP backend = %+v, want the bind password from the file", backends[1])
+	}
+
+	authDB = "mysql://db.example.com/keys"
+	if _, err := authBackends(); err == nil {
+		t.Error("authBackends() should refuse an unsupported key database")
+	}
+}
+
+func TestReadKeySource(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/gossh/host" || r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+			return
+		}
+		w.Write([]byte(`{"data": {"data": {"private_key": "host key", "backup_key": "backup key"}, "metadata": {}}}`))
+	}))
+	defer vault.Close()
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("VAULT_TOKEN", "s.token")
+
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte("file key"), 0600)
+
+	tests := []struct {
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{path, "file key", false},
+		{"vault://secret/data/gossh/host", "host key", false},
+		{"vault://secret/data/gossh/host#backup_key", "backup key", false},
+		{"vault://secret/data/gossh/host#missing", "", true},
+		{"vault://secret/data/gossh/other", "", true},
+	}
+	for _, tt := range tests {
+		got, err := readKeySource(tt.source)
+		if (err != nil) != tt.wantErr || string(got) != tt.want {
+			t.Errorf("readKeySource(%q) = %q, %v, want %q, error %v", tt.source, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestOpenKeyDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.db")
+	setup, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setup.Exec(`CREATE TABLE authorized_keys ("user" TEXT, key TEXT, options TEXT, expiry TIMESTAMP)`)
+	setup.Close()
+
+	db, err := openKeyDatabase("sqlite://" + path)
+	if err != nil {
+		t.Fatalf("openKeyDatabase(sqlite) error = %v", err)
+	}
+	if _, err := ssh.NewSQLAuthenticator(context.Background(), db, ""); err != nil {
+		t.Errorf("NewSQLAuthenticator error = %v", err)
+	}
+	if _, err := db.Exec(`DELETE FROM authorized_keys`); err == nil {
+		t.Error("the key database should be opened read-only")
+	}
+	db.Close()
+
+	if _, err := openKeyDatabase("postgres://gossh@db.example.com/keys"); err != nil {
+		t.Errorf("openKeyDatabase(postgres) error = %v", err)
+	}
+	if _, err := openKeyDatabase("mysql://db.example.com/keys"); err == nil {
+		t.Error("openKeyDatabase should refuse unsupported databases")
+	}
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+var drainTimeout string
+
+// serverctlCmd groups the commands that manage a running server
+var serverctlCmd = &cobra.Command{
+	Use:   "serverctl",
+	Short: "Manage a running SSH server",
+	Long: `The serverctl command manages a gossh server started with --control-socket,
+without restarting it or dropping connections.
+
+Examples:
+  # List connected clients and disconnect one
+  gossh serverctl sessions --control-socket /run/gossh/control.sock
+  gossh serverctl disconnect 9f2c4e1a7b3d5c60 --reason "key revoked" --control-socket /run/gossh/control.sock
+
+  # Re-read the authorized keys file
+  gossh serverctl reload --control-socket /run/gossh/control.sock
+
+  # Turn on debug logging while investigating a problem
+  gossh serverctl log-level debug --control-socket /run/gossh/control.sock
+
+  # Stop accepting connections and exit once the open ones finish, within 10 minutes
+  gossh serverctl drain --timeout 10m --control-socket /run/gossh/control.sock`,
+}
+
+// serverctlSessionsCmd represents the serverctl sessions command
+var serverctlSessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List connected clients",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		resp := sendControl(ssh.ControlRequest{Command: "sessions"})
+		printSessions(resp.Sessions)
+	},
+}
+
+// serverctlDisconnectCmd represents the serverctl disconnect command
+var serverctlDisconnectCmd = &cobra.Command{
+	Use:   "disconnect <session-id>",
+	Short: "Disconnect a client",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		resp := sendControl(ssh.ControlRequest{Command: "disconnect", SessionID: args[0], Reason: disconnectReason})
+		fmt.Println(resp.Message)
+	},
+}
+
+// serverctlReloadCmd represents the serverctl reload command
+var serverctlReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Re-read the authorized keys file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		resp := sendControl(ssh.ControlRequest{Command: "reload"})
+		fmt.Println(resp.Message)
+	},
+}
+
+// serverctlLogLevelCmd represents the serverctl log-level command
+var serverctlLogLevelCmd = &cobra.Command{
+	Use:   "log-level <level>",
+	Short: "Change the log level (debug, info, warn, error)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		resp := sendControl(ssh.ControlRequest{Command: "log-level", Level: args[0]})
+		fmt.Println(resp.Message)
+	},
+}
+
+// serverctlDrainCmd represents the serverctl drain command
+var serverctlDrainCmd = &cobra.Command{
+	Use:   "drain",
+	Short: "Stop accepting connections and exit once the open ones finish",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		resp := sendControl(ssh.ControlRequest{Command: "drain", Timeout: drainTimeout})
+		fmt.Println(resp.Message)
+		printSessions(resp.Sessions)
+	},
+}
+
+// sendControl sends req to the server's control socket, exiting on failure
+func sendControl(req ssh.ControlRequest) ssh.ControlResponse {
+	resp, err := ssh.SendControl(requireControlSocket(), req)
+	if err != nil {
+		fmt.Printf("%s failed: %s\n", req.Command, err)
+		os.Exit(1)
+	}
+	return resp
+}
+
+func init() {
+	rootCmd.AddCommand(serverctlCmd)
+	serverctlCmd.AddCommand(serverctlSessionsCmd, serverctlDisconnectCmd, serverctlReloadCmd, serverctlLogLevelCmd, serverctlDrainCmd)
+
+	// Define flags for the serverctl commands
+	serverctlCmd.PersistentFlags().StringVar(&controlSocket, "control-socket", "", "Control socket of the running server, as passed to gossh server --control-socket")
+	serverctlDisconnectCmd.Flags().StringVar(&disconnectReason, "reason", "", "Reason shown to the disconnected client and logged")
+	serverctlDrainCmd.Flags().StringVar(&drainTimeout, "timeout", "", "Close connections still open after this long, e.g. 10m (empty to wait indefinitely)")
+}
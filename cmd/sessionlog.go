@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// castRecorder writes the output of a client session as an asciicast v2
+// file (https://docs.asciinema.org/manual/asciicast/v2/), for --log-session.
+// Keystrokes are not recorded, so passwords typed at remote prompts stay out
+// of the file; what the terminal echoes is in the output. Events are written
+// as they happen, so the file is complete however gossh exits.
+type castRecorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	started time.Time
+}
+
+// newCastRecorder creates the recording at path for a terminal of the given
+// size and type
+func newCastRecorder(path string, columns, rows int, term, title string) (*castRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	r := &castRecorder{file: file, started: time.Now()}
+	header, _ := json.Marshal(map[string]any{
+		"version":   2,
+		"width":     columns,
+		"height":    rows,
+		"timestamp": r.started.Unix(),
+		"title":     title,
+		"env":       map[string]string{"TERM": term},
+	})
+	if _, err := file.Write(append(header, '\n')); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// event appends an event of the given type ("o" or "r")
+func (r *castRecorder) event(kind, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	line, _ := json.Marshal([]any{time.Since(r.started).Seconds(), kind, data})
+	r.file.Write(append(line, '\n'))
+}
+
+// Write records session output
+func (r *castRecorder) Write(p []byte) (int, error) {
+	r.event("o", string(p))
+	return len(p), nil
+}
+
+// resize records a terminal size change
+func (r *castRecorder) resize(columns, rows int) {
+	r.event("r", fmt.Sprintf("%dx%d", columns, rows))
+}
+
+// Close closes the recording
+func (r *castRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// transcriptWriter writes session output as plain text, for --log-output:
+// terminal escape sequences, such as colors and cursor movement, and
+// carriage returns are dropped
+type transcriptWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	state int
+}
+
+// States of transcriptWriter's escape sequence parser
+const (
+	textState = iota
+	escapeState
+	csiState
+	oscState
+	oscEscapeState
+)
+
+func (t *transcriptWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	text := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch t.state {
+		case textState:
+			switch b {
+			case 0x1b:
+				t.state = escapeState
+			case '\r':
+			default:
+				text = append(text, b)
+			}
+		case escapeState:
+			switch b {
+			case '[':
+				t.state = csiState
+			case ']':
+				t.state = oscState
+			default:
+				// Intermediate bytes, as in ESC ( B, precede the final byte
+				if b < 0x20 || b > 0x2f {
+					t.state = textState
+				}
+			}
+		case csiState:
+			// Parameters and intermediates run until a final byte
+			if b >= 0x40 && b <= 0x7e {
+				t.state = textState
+			}
+		case oscState:
+			// Operating system commands end with BEL or ESC \
+			switch b {
+			case 0x07:
+				t.state = textState
+			case 0x1b:
+				t.state = oscEscapeState
+			}
+		case oscEscapeState:
+			t.state = textState
+		}
+	}
+	if _, err := t.w.Write(text); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
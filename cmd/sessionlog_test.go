@@ -0,0 +1,93 @@
+// cmd/sessionlog_test.go
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+)
+
+func TestCastRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	recorder, err := newCastRecorder(path, 120, 40, "xterm-256color", "admin@example.com")
+	if err != nil {
+		t.Fatalf("newCastRecorder failed: %v", err)
+	}
+	recorder.Write([]byte("$ uptime\r\n"))
+	recorder.resize(100, 30)
+	recorder.Write([]byte(" 12:00:00 up 3 days\r\n"))
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Scan()
+	var header struct {
+		Version       int
+		Width, Height int
+		Title         string
+		Env           map[string]string
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("header is not JSON: %v", err)
+	}
+	if header.Version != 2 || header.Width != 120 || header.Height != 40 || header.Env["TERM"] != "xterm-256color" {
+		t.Errorf("header = %+v", header)
+	}
+	var kinds []string
+	for scanner.Scan() {
+		var event []any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			t.Fatalf("bad event %q: %v", scanner.Text(), err)
+		}
+		kinds = append(kinds, event[1].(string))
+	}
+	if len(kinds) != 3 || kinds[0] != "o" || kinds[1] != "r" || kinds[2] != "o" {
+		t.Errorf("event kinds = %v, want o r o", kinds)
+	}
+
+	// The recording replays like the server's
+	var replayed bytes.Buffer
+	if err := ssh.ReplayRecording(bytes.NewReader(data), &replayed, 1000, 0); err != nil {
+		t.Fatalf("ReplayRecording failed: %v", err)
+	}
+	if replayed.String() != "$ uptime\r\n 12:00:00 up 3 days\r\n" {
+		t.Errorf("replayed %q", replayed.String())
+	}
+}
+
+func TestTranscriptWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"plain", []string{"hello\r\n"}, "hello\n"},
+		{"colors", []string{"\x1b[1;32mok\x1b[0m done\n"}, "ok done\n"},
+		{"title", []string{"\x1b]0;admin@host: ~\x07$ ls\n"}, "$ ls\n"},
+		{"title ended by ST", []string{"\x1b]2;x\x1b\\ready\n"}, "ready\n"},
+		{"split sequence", []string{"a\x1b", "[3", "1mb\x1b[", "0m\n"}, "ab\n"},
+		{"charset", []string{"\x1b(Bline\n"}, "line\n"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		w := &transcriptWriter{w: &out}
+		for _, s := range tt.writes {
+			if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+				t.Errorf("%s: Write = %d, %v", tt.name, n, err)
+			}
+		}
+		if out.String() != tt.want {
+			t.Errorf("%s: transcript = %q, want %q", tt.name, out.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+var disconnectReason string
+
+// sessionsCmd represents the server sessions command
+var sessionsCmd = &cobra.Command{
+	Use:   "sessions",
+	Short: "List the clients connected to a running server",
+	Long: `The sessions command asks a running gossh server, through its control socket,
+for the clients currently logged in.
+
+Examples:
+  # List connected clients
+  gossh server sessions --control-socket /run/gossh/control.sock
+
+  # Disconnect a client, telling it why
+  gossh server sessions kill 9f2c4e1a7b3d5c60 --reason "scheduled maintenance" --control-socket /run/gossh/control.sock`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		resp, err := ssh.SendControl(requireControlSocket(), ssh.ControlRequest{Command: "sessions"})
+		if err != nil {
+			fmt.Printf("Failed to list sessions: %s\n", err)
+			os.Exit(1)
+		}
+		printSessions(resp.Sessions)
+	},
+}
+
+// killCmd represents the server sessions kill command
+var killCmd = &cobra.Command{
+	Use:   "kill <session-id>",
+	Short: "Disconnect a client from a running server",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		req := ssh.ControlRequest{Command: "disconnect", SessionID: args[0], Reason: disconnectReason}
+		if _, err := ssh.SendControl(requireControlSocket(), req); err != nil {
+			fmt.Printf("Failed to disconnect session: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Disconnected session %s\n", args[0])
+	},
+}
+
+// printSessions writes a table of connected clients to stdout
+func printSessions(sessions []ssh.SessionInfo) {
+	if len(sessions) == 0 {
+		fmt.Println("No active sessions")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tUSER\tREMOTE ADDRESS\tCONNECTED\tCHANNELS\tCLIENT")
+	for _, s := range sessions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", s.ID, s.User, s.RemoteAddr,
+			time.Since(s.Started).Round(time.Second), s.Channels, s.ClientVersion)
+	}
+	w.Flush()
+}
+
+// requireControlSocket returns the --control-socket path, exiting when it is unset
+func requireControlSocket() string {
+	if controlSocket == "" {
+		fmt.Println("--control-socket is required to reach the server")
+		os.Exit(1)
+	}
+	return controlSocket
+}
+
+func init() {
+	serverCmd.AddCommand(sessionsCmd)
+	sessionsCmd.AddCommand(killCmd)
+
+	// Define flags for the kill command
+	killCmd.Flags().StringVar(&disconnectReason, "reason", "", "Reason shown to the disconnected client and logged")
+}
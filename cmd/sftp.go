@@ -0,0 +1,558 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+var sftpBatchFile string
+
+// sftpCmd represents the sftp command
+var sftpCmd = &cobra.Command{
+	Use:   "sftp",
+	Short: "Browse and transfer files on an SSH server interactively",
+	Long: `An interactive SFTP client, like OpenSSH's sftp: list, fetch, send and manage
+remote files at an sftp> prompt. Type help for the commands.
+
+Connections are made as gossh client makes them, with the connection flags
+of gossh scp.
+
+Examples:
+  # Browse files on a server
+  gossh sftp --host example.com --user admin
+
+  # Run commands from a file; a command starting with - may fail without stopping the batch
+  gossh sftp --host example.com -b upload.txt
+
+  # Read the batch from stdin
+  printf 'cd /srv/www\nmput dist/*\n' | gossh sftp --host example.com -b -`,
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		if host == "" {
+			fail(errors.New("--host is required"))
+		}
+
+		var batch io.Reader
+		switch sftpBatchFile {
+		case "":
+		case "-":
+			batch = os.Stdin
+		default:
+			file, err := os.Open(sftpBatchFile)
+			if err != nil {
+				fail(fmt.Errorf("failed to open batch file: %s", err))
+			}
+			defer file.Close()
+			batch = file
+		}
+
+		client, err := dialServer(cmd.Flags())
+		if err != nil {
+			fail(err)
+		}
+		defer client.Close()
+		sftpClient, err := sftp.NewClient(client)
+		if err != nil {
+			fail(fmt.Errorf("failed to start SFTP: %s", err))
+		}
+		defer sftpClient.Close()
+
+		shell, err := newSFTPShell(sftpClient, os.Stdout)
+		if err != nil {
+			fail(err)
+		}
+		quiet, _ := cmd.Flags().GetBool("quiet")
+		if !quiet && term.IsTerminal(int(os.Stderr.Fd())) {
+			shell.progress = os.Stderr
+		}
+		if batch != nil {
+			if err := shell.runBatch(batch); err != nil {
+				fail(err)
+			}
+			return
+		}
+		shell.interactive(os.Stdin, term.IsTerminal(int(os.Stdin.Fd())))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sftpCmd)
+
+	sftpCmd.Flags().StringVarP(&host, "host", "H", "", "SSH server hostname, or a Host alias from ~/.ssh/config")
+	sftpCmd.Flags().StringVarP(&user, "user", "u", "", "SSH username (default: the SSH config's User, or the local user)")
+	addConnectionFlags(sftpCmd.Flags(), "P", "i")
+	sftpCmd.Flags().StringVarP(&sftpBatchFile, "batchfile", "b", "", "Run the commands in this file (- for stdin) instead of prompting, stopping at the first failure")
+}
+
+// sftpHelp lists the commands of the sftp> prompt
+const sftpHelp = `Available commands:
+  cd [path]                    Change the remote directory (default: home)
+  chmod mode path...           Change the mode of remote files, e.g. chmod 644 *.txt
+  get [-r] [-p] remote [local] Download a file, or a directory with -r; -p keeps modes and times
+  help                         Show this help
+  lcd path                     Change the local directory
+  lls [path]                   List local files
+  lpwd                         Print the local directory
+  ls [-l] [path]               List remote files; -l shows modes, sizes and times
+  mget [-r] [-p] pattern...    Download the files matching the patterns into the local directory
+  mkdir path                   Create a remote directory
+  mput [-r] [-p] pattern...    Upload the files matching the patterns into the remote directory
+  put [-r] [-p] local [remote] Upload a file, or a directory with -r
+  pwd                          Print the remote directory
+  rm path...                   Remove remote files
+  rmdir path                   Remove an empty remote directory
+  exit, quit, bye              Leave sftp
+`
+
+// errSFTPQuit is returned by sftpShell.run for exit, quit and bye
+var errSFTPQuit = errors.New("quit")
+
+// sftpShell runs the commands of the sftp> prompt, keeping its own remote
+// and local working directories
+type sftpShell struct {
+	client    *sftp.Client
+	home      string
+	remoteDir string
+	localDir  string
+	out       io.Writer
+	// progress is where transfer progress bars are drawn, if anywhere
+	progress io.Writer
+}
+
+// newSFTPShell starts in the remote home directory and the local working
+// directory
+func newSFTPShell(client *sftp.Client, out io.Writer) (*sftpShell, error) {
+	home, err := client.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the remote directory: %s", err)
+	}
+	localDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the local directory: %s", err)
+	}
+	return &sftpShell{client: client, remoteDir: home, home: home, localDir: localDir, out: out}, nil
+}
+
+// interactive reads commands from in until exit or end of input, reporting
+// failed commands and carrying on. prompt says whether to show sftp>.
+func (s *sftpShell) interactive(in io.Reader, prompt bool) {
+	errorColor := color.New(color.FgRed).SprintFunc()
+	scanner := bufio.NewScanner(in)
+	for {
+		if prompt {
+			fmt.Fprint(s.out, "sftp> ")
+		}
+		if !scanner.Scan() {
+			if prompt {
+				fmt.Fprintln(s.out)
+			}
+			return
+		}
+		if err := s.run(scanner.Text()); errors.Is(err, errSFTPQuit) {
+			return
+		} else if err != nil {
+			fmt.Fprintln(s.out, errorColor(err.Error()))
+		}
+	}
+}
+
+// runBatch runs the commands read from r, echoing each as OpenSSH's sftp -b
+// does. The first failure stops the batch unless the command starts with -.
+func (s *sftpShell) runBatch(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		ignoreError := strings.HasPrefix(text, "-")
+		text = strings.TrimPrefix(text, "-")
+		fmt.Fprintln(s.out, "sftp> "+text)
+		err := s.run(text)
+		switch {
+		case errors.Is(err, errSFTPQuit):
+			return nil
+		case err != nil && ignoreError:
+			fmt.Fprintln(s.out, err)
+		case err != nil:
+			return fmt.Errorf("batch line %d: %s", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// run runs one command line
+func (s *sftpShell) run(line string) error {
+	args, err := splitArgs(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	name, args := args[0], args[1:]
+	switch name {
+	case "cd":
+		return s.cd(args)
+	case "chmod":
+		return s.chmod(args)
+	case "get", "mget":
+		return s.transfer(name, args, false)
+	case "put", "mput":
+		return s.transfer(name, args, true)
+	case "help", "?":
+		fmt.Fprint(s.out, sftpHelp)
+	case "lcd":
+		return s.lcd(args)
+	case "lls":
+		return s.lls(args)
+	case "lpwd":
+		fmt.Fprintln(s.out, s.localDir)
+	case "ls", "dir":
+		return s.ls(args)
+	case "mkdir":
+		if len(args) != 1 {
+			return errors.New("usage: mkdir path")
+		}
+		if err := s.client.Mkdir(s.remotePath(args[0])); err != nil {
+			return fmt.Errorf("mkdir %s: %s", args[0], err)
+		}
+	case "pwd":
+		fmt.Fprintln(s.out, s.remoteDir)
+	case "rm":
+		return s.rm(args)
+	case "rmdir":
+		if len(args) != 1 {
+			return errors.New("usage: rmdir path")
+		}
+		if err := s.client.RemoveDirectory(s.remotePath(args[0])); err != nil {
+			return fmt.Errorf("rmdir %s: %s", args[0], err)
+		}
+	case "exit", "quit", "bye":
+		return errSFTPQuit
+	default:
+		return fmt.Errorf("unknown command %q, type help for the commands", name)
+	}
+	return nil
+}
+
+// remotePath resolves p against the remote working directory
+func (s *sftpShell) remotePath(p string) string {
+	if path.IsAbs(p) {
+		return path.Clean(p)
+	}
+	return path.Join(s.remoteDir, p)
+}
+
+// localPath resolves p against the local working directory
+func (s *sftpShell) localPath(p string) string {
+	if filepath.IsAbs(p) {
+		return filepath.Clean(p)
+	}
+	return filepath.Join(s.localDir, p)
+}
+
+// remoteGlob expands a remote pattern, failing when nothing matches
+func (s *sftpShell) remoteGlob(pattern string) ([]string, error) {
+	p := s.remotePath(pattern)
+	if !hasGlobMeta(pattern) {
+		return []string{p}, nil
+	}
+	matches, err := s.client.Glob(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no matches", pattern)
+	}
+	return matches, nil
+}
+
+// localGlob expands a local pattern, failing when nothing matches
+func (s *sftpShell) localGlob(pattern string) ([]string, error) {
+	p := s.localPath(pattern)
+	if !hasGlobMeta(pattern) {
+		return []string{p}, nil
+	}
+	matches, err := filepath.Glob(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no matches", pattern)
+	}
+	return matches, nil
+}
+
+func hasGlobMeta(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+func (s *sftpShell) cd(args []string) error {
+	dir := s.home
+	switch len(args) {
+	case 0:
+	case 1:
+		dir = s.remotePath(args[0])
+	default:
+		return errors.New("usage: cd [path]")
+	}
+	info, err := s.client.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("cd %s: %s", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("cd %s: not a directory", dir)
+	}
+	s.remoteDir = dir
+	return nil
+}
+
+func (s *sftpShell) lcd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: lcd path")
+	}
+	dir := s.localPath(args[0])
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("lcd %s: %s", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("lcd %s: not a directory", dir)
+	}
+	s.localDir = dir
+	return nil
+}
+
+func (s *sftpShell) chmod(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: chmod mode path...")
+	}
+	mode, err := strconv.ParseUint(args[0], 8, 32)
+	if err != nil || mode > 0o7777 {
+		return fmt.Errorf("invalid mode %q, want octal such as 644", args[0])
+	}
+	for _, pattern := range args[1:] {
+		paths, err := s.remoteGlob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			if err := s.client.Chmod(p, os.FileMode(mode)); err != nil {
+				return fmt.Errorf("chmod %s: %s", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sftpShell) rm(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: rm path...")
+	}
+	for _, pattern := range args {
+		paths, err := s.remoteGlob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, p := range paths {
+			// The error names the file
+			if err := s.client.Remove(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *sftpShell) ls(args []string) error {
+	long := len(args) > 0 && args[0] == "-l"
+	if long {
+		args = args[1:]
+	}
+	if len(args) > 1 {
+		return errors.New("usage: ls [-l] [path]")
+	}
+	pattern := "."
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	paths, err := s.remoteGlob(pattern)
+	if err != nil {
+		return err
+	}
+	var infos []os.FileInfo
+	for _, p := range paths {
+		info, err := s.client.Stat(p)
+		if err != nil {
+			return fmt.Errorf("ls %s: %s", p, err)
+		}
+		if info.IsDir() && len(paths) == 1 {
+			if infos, err = s.client.ReadDir(p); err != nil {
+				return fmt.Errorf("ls %s: %s", p, err)
+			}
+			break
+		}
+		infos = append(infos, info)
+	}
+	s.list(infos, long)
+	return nil
+}
+
+func (s *sftpShell) lls(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: lls [path]")
+	}
+	dir := s.localDir
+	if len(args) == 1 {
+		dir = s.localPath(args[0])
+	}
+	infos, err := localFS{}.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("lls %s: %s", dir, err)
+	}
+	s.list(infos, false)
+	return nil
+}
+
+// list prints file names sorted, hiding dot files, with modes, sizes and
+// times when long is set
+func (s *sftpShell) list(infos []os.FileInfo, long bool) {
+	slices.SortFunc(infos, func(a, b os.FileInfo) int { return strings.Compare(a.Name(), b.Name()) })
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		if long {
+			fmt.Fprintf(s.out, "%s %10d %s %s\n", info.Mode(), info.Size(), info.ModTime().Format("Jan _2 15:04 2006"), name)
+		} else {
+			fmt.Fprintln(s.out, name)
+		}
+	}
+}
+
+// transfer runs get, mget, put and mput. get and put take a source and an
+// optional destination; mget and mput take patterns, copied into the working
+// directory.
+func (s *sftpShell) transfer(name string, args []string, upload bool) error {
+	c := &copier{src: remoteFS{s.client}, dst: localFS{}, progress: s.progress}
+	if upload {
+		c.src, c.dst = c.dst, c.src
+	}
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+		for _, flag := range args[0][1:] {
+			switch flag {
+			case 'r':
+				c.recursive = true
+			case 'p':
+				c.preserve = true
+			default:
+				return fmt.Errorf("%s: unknown flag -%c", name, flag)
+			}
+		}
+		args = args[1:]
+	}
+
+	srcGlob, dstPath, dst := s.remoteGlob, s.localPath, s.localDir
+	if upload {
+		srcGlob, dstPath, dst = s.localGlob, s.remotePath, s.remoteDir
+	}
+	patterns := args
+	if name == "get" || name == "put" {
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("usage: %s [-r] [-p] source [destination]", name)
+		}
+		patterns = args[:1]
+		if len(args) == 2 {
+			dst = dstPath(args[1])
+		}
+	} else if len(args) == 0 {
+		return fmt.Errorf("usage: %s [-r] [-p] pattern...", name)
+	}
+
+	for _, pattern := range patterns {
+		sources, err := srcGlob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, src := range sources {
+			if upload {
+				fmt.Fprintf(s.out, "Uploading %s to %s\n", src, dst)
+			} else {
+				fmt.Fprintf(s.out, "Fetching %s to %s\n", src, dst)
+			}
+			if err := c.copy(src, dst); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitArgs splits a command line at spaces, keeping quoted text together
+// and honouring backslash escapes, e.g. put "My Documents/a b.txt"
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
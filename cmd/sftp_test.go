@@ -0,0 +1,151 @@
+// cmd/sftp_test.go
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	"github.com/pkg/sftp"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"  ls   -l  ", []string{"ls", "-l"}, false},
+		{`put "My Documents/a b.txt" remote`, []string{"put", "My Documents/a b.txt", "remote"}, false},
+		{`get 'it''s' x\ y`, []string{"get", "its", "x y"}, false},
+		{`cd ""`, []string{"cd", ""}, false},
+		{`ls "unterminated`, nil, true},
+		{`ls trailing\`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.line)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("splitArgs(%q) = %q, %v, want %q (error: %v)", tt.line, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// startSFTPShell connects a shell to a test server serving this machine's
+// file system, starting in dir locally and remotely
+func startSFTPShell(t *testing.T, dir string) (*sftpShell, *bytes.Buffer) {
+	t.Helper()
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{SFTP: true})
+	client, err := sftp.NewClient(srv.Dial(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	var out bytes.Buffer
+	shell, err := newSFTPShell(client, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shell.localDir, shell.remoteDir = dir, dir
+	return shell, &out
+}
+
+func TestSFTPShellBatch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes differ on Windows")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "notes.txt"} {
+		writeFile(t, filepath.Join(dir, name), name, 0o644)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "back"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	shell, out := startSFTPShell(t, dir)
+
+	batch := `# upload the logs
+mkdir remote
+cd remote
+mput *.log
+put notes.txt renamed.txt
+chmod 600 *.log
+-rm missing.txt
+ls -l
+lcd back
+cd ..
+get -r remote
+rm remote/*.log
+quit
+ls
+`
+	if err := shell.runBatch(strings.NewReader(batch)); err != nil {
+		t.Fatalf("runBatch failed: %v\n%s", err, out)
+	}
+	if shell.remoteDir != dir || shell.localDir != filepath.Join(dir, "back") {
+		t.Errorf("directories = %s, %s", shell.remoteDir, shell.localDir)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "back", "remote", "a.log")); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("downloaded a.log = %v, %v, want mode 0600", info, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "back", "remote", "renamed.txt")); err != nil || string(data) != "notes.txt" {
+		t.Errorf("downloaded renamed.txt = %q, %v", data, err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "remote"))
+	if len(entries) != 1 || entries[0].Name() != "renamed.txt" {
+		t.Errorf("remote files after rm = %v, want renamed.txt only", entries)
+	}
+	for _, want := range []string{"sftp> rm missing.txt\n", "-rw------- ", "a.log\n", "renamed.txt\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("batch output is missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Count(out.String(), "sftp> ls") != 1 {
+		t.Errorf("commands after quit ran:\n%s", out)
+	}
+
+	// Failures stop the batch
+	out.Reset()
+	err := shell.runBatch(strings.NewReader("pwd\nrm missing.txt\npwd\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2") || strings.Count(out.String(), dir+"\n") != 1 {
+		t.Errorf("runBatch = %v with output %q, want a failure at line 2", err, out)
+	}
+}
+
+func TestSFTPShellErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "file"), "x", 0o644)
+	shell, out := startSFTPShell(t, dir)
+
+	for _, line := range []string{
+		"frobnicate",
+		"cd file",
+		"cd missing",
+		"lcd missing",
+		"chmod 999 file",
+		"chmod 644",
+		"get",
+		"get -x file",
+		"mget *.none",
+		"get dir-missing",
+	} {
+		if err := shell.run(line); err == nil {
+			t.Errorf("run(%q) should fail", line)
+		}
+	}
+	if shell.remoteDir != dir || shell.localDir != dir {
+		t.Errorf("failed commands changed directories to %s, %s", shell.remoteDir, shell.localDir)
+	}
+
+	// Interactive sessions report failures and carry on
+	out.Reset()
+	shell.interactive(strings.NewReader("cd missing\npwd\nexit\npwd\n"), false)
+	if got := out.String(); !strings.Contains(got, "missing") || strings.Count(got, dir+"\n") != 1 {
+		t.Errorf("interactive output = %q", got)
+	}
+}
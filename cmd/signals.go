@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// remoteSignals are the signals SSH can deliver, by name without "SIG"
+var remoteSignals = map[string]ssh.Signal{
+	"ABRT": ssh.SIGABRT, "ALRM": ssh.SIGALRM, "FPE": ssh.SIGFPE, "HUP": ssh.SIGHUP,
+	"ILL": ssh.SIGILL, "INT": ssh.SIGINT, "KILL": ssh.SIGKILL, "PIPE": ssh.SIGPIPE,
+	"QUIT": ssh.SIGQUIT, "SEGV": ssh.SIGSEGV, "TERM": ssh.SIGTERM, "USR1": ssh.SIGUSR1,
+	"USR2": ssh.SIGUSR2,
+}
+
+// parseRemoteSignal parses a signal name such as TERM, SIGTERM or term
+func parseRemoteSignal(name string) (ssh.Signal, error) {
+	sig, ok := remoteSignals[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return "", fmt.Errorf("unknown signal %q", name)
+	}
+	return sig, nil
+}
+
+// localToRemote maps a caught local signal to the one sent to the remote
+// command, unless override names another
+func localToRemote(sig os.Signal, override ssh.Signal) ssh.Signal {
+	if override != "" {
+		return override
+	}
+	if sig == syscall.SIGTERM {
+		return ssh.SIGTERM
+	}
+	return ssh.SIGINT
+}
+
+// forwardSignals catches SIGINT and SIGTERM while a command runs and sends
+// them to it as SSH signal requests, or override instead when set. A second
+// signal closes the session, for commands that ignore the first. The
+// returned function stops forwarding.
+func forwardSignals(session *ssh.Session, override ssh.Signal) func() {
+	caught := make(chan os.Signal, 1)
+	signal.Notify(caught, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		forwarded := false
+		for {
+			select {
+			case <-done:
+				return
+			case sig := <-caught:
+				if forwarded {
+					log.Warn("Interrupted again, disconnecting")
+					session.Close()
+					return
+				}
+				remote := localToRemote(sig, override)
+				log.Warn("Sending SIG", remote, " to the remote command; interrupt again to disconnect")
+				if err := session.Signal(remote); err != nil {
+					log.Warn("Could not send the signal: ", err)
+				}
+				forwarded = true
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(caught)
+		close(done)
+	}
+}
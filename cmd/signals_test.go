@@ -0,0 +1,40 @@
+// cmd/signals_test.go
+package cmd
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseRemoteSignal(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    ssh.Signal
+		wantErr bool
+	}{
+		{"TERM", ssh.SIGTERM, false},
+		{"SIGKILL", ssh.SIGKILL, false},
+		{"usr1", ssh.SIGUSR1, false},
+		{"WINCH", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseRemoteSignal(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseRemoteSignal(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+
+	if got := localToRemote(os.Interrupt, ""); got != ssh.SIGINT {
+		t.Errorf("Ctrl+C is sent as %s, want INT", got)
+	}
+	if got := localToRemote(syscall.SIGTERM, ""); got != ssh.SIGTERM {
+		t.Errorf("SIGTERM is sent as %s, want TERM", got)
+	}
+	if got := localToRemote(os.Interrupt, ssh.SIGKILL); got != ssh.SIGKILL {
+		t.Errorf("--signal KILL sends %s", got)
+	}
+}
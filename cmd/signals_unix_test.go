@@ -0,0 +1,57 @@
+//go:build unix
+
+// cmd/signals_unix_test.go
+package cmd
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func TestForwardSignals(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+	client := srv.Dial(t)
+
+	tests := []struct {
+		local    syscall.Signal
+		override cryptossh.Signal
+		want     string
+	}{
+		{syscall.SIGINT, "", "INT"},
+		{syscall.SIGTERM, "", "TERM"},
+		{syscall.SIGINT, cryptossh.SIGKILL, "KILL"},
+	}
+	for _, tt := range tests {
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("NewSession failed: %v", err)
+		}
+		stop := forwardSignals(session, tt.override)
+		if err := session.Start("exec sleep 30"); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		// Give the server a moment to start the process
+		time.Sleep(100 * time.Millisecond)
+		syscall.Kill(syscall.Getpid(), tt.local)
+
+		done := make(chan error, 1)
+		go func() { done <- session.Wait() }()
+		select {
+		case err := <-done:
+			var exitErr *cryptossh.ExitError
+			if !errors.As(err, &exitErr) || exitErr.Signal() != tt.want {
+				t.Errorf("after %s the command ended with %v, want signal %s", tt.local, err, tt.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s did not stop the remote command", tt.local)
+		}
+		stop()
+		session.Close()
+	}
+}
@@ -0,0 +1,398 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxIncludeDepth stops Include loops in the SSH config
+const maxIncludeDepth = 16
+
+// sshConfig is a parsed OpenSSH client configuration, such as ~/.ssh/config
+type sshConfig struct {
+	blocks []sshConfigBlock
+}
+
+// sshConfigBlock holds the options of one Host section. Options before the
+// first Host line apply to every host; Match sections are not supported and
+// apply to none.
+type sshConfigBlock struct {
+	patterns []string
+	options  []sshConfigOption
+}
+
+// sshConfigOption is a keyword, lower-cased, and its value
+type sshConfigOption struct {
+	keyword string
+	value   string
+}
+
+// hostConfig is what the SSH config says about one host alias
+type hostConfig struct {
+	hostName      string
+	user          string
+	port          string
+	identityFiles []string
+	proxyJump     string
+}
+
+// jumpHost is a host the connection is relayed through (ssh -J)
+type jumpHost struct {
+	user string
+	addr string
+}
+
+// defaultSSHConfigFile returns the path of ~/.ssh/config
+func defaultSSHConfigFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "config"), nil
+}
+
+// loadSSHConfig reads an SSH config file. A missing file is an empty config.
+func loadSSHConfig(path string) (*sshConfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &sshConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := parseSSHConfig(data, filepath.Dir(path), 0)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &sshConfig{blocks: blocks}, nil
+}
+
+// parseSSHConfig parses the Host sections of an SSH config. Relative Include
+// paths are resolved against dir.
+func parseSSHConfig(data []byte, dir string, depth int) ([]sshConfigBlock, error) {
+	blocks := []sshConfigBlock{{patterns: []string{"*"}}}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keyword, value, ok := splitSSHConfigLine(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: missing value for %q", n, line)
+		}
+		current := &blocks[len(blocks)-1]
+		switch keyword {
+		case "host":
+			blocks = append(blocks, sshConfigBlock{patterns: strings.Fields(strings.ToLower(value))})
+		case "match":
+			blocks = append(blocks, sshConfigBlock{})
+		case "include":
+			if depth >= maxIncludeDepth {
+				return nil, fmt.Errorf("line %d: too many nested Include directives", n)
+			}
+			patterns := current.patterns
+			for _, pattern := range strings.Fields(value) {
+				pattern = expandHome(pattern)
+				if !filepath.IsAbs(pattern) {
+					pattern = filepath.Join(dir, pattern)
+				}
+				paths, err := filepath.Glob(pattern)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %s", n, err)
+				}
+				for _, path := range paths {
+					included, err := os.ReadFile(path)
+					if err != nil {
+						return nil, fmt.Errorf("line %d: %s", n, err)
+					}
+					parsed, err := parseSSHConfig(included, dir, depth+1)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %s", path, err)
+					}
+					// Options before the included file's first Host line
+					// belong to the section the Include is in
+					parsed[0].patterns = patterns
+					blocks = append(blocks, parsed...)
+				}
+			}
+			// Options after the Include still belong to the same section
+			blocks = append(blocks, sshConfigBlock{patterns: patterns})
+		default:
+			current.options = append(current.options, sshConfigOption{keyword, value})
+		}
+	}
+	return blocks, scanner.Err()
+}
+
+// splitSSHConfigLine splits "Keyword value" or "Keyword=value", removing
+// quotes around the value
+func splitSSHConfigLine(line string) (string, string, bool) {
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return "", "", false
+	}
+	keyword := strings.ToLower(line[:i])
+	value := strings.TrimSpace(line[i:])
+	value = strings.TrimSpace(strings.TrimPrefix(value, "="))
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return keyword, value, value != ""
+}
+
+// lookup returns the options that apply to alias. As with OpenSSH the first
+// value given for an option wins, except IdentityFile, which accumulates.
+func (c *sshConfig) lookup(alias string) hostConfig {
+	var hc hostConfig
+	for _, block := range c.blocks {
+		if !matchHostPatterns(block.patterns, strings.ToLower(alias)) {
+			continue
+		}
+		for _, opt := range block.options {
+			switch opt.keyword {
+			case "hostname":
+				setOnce(&hc.hostName, opt.value)
+			case "user":
+				setOnce(&hc.user, opt.value)
+			case "port":
+				setOnce(&hc.port, opt.value)
+			case "proxyjump":
+				setOnce(&hc.proxyJump, opt.value)
+			case "identityfile":
+				hc.identityFiles = append(hc.identityFiles, opt.value)
+			}
+		}
+	}
+	if hc.hostName == "" {
+		hc.hostName = alias
+	} else {
+		hc.hostName = expandTokens(hc.hostName, map[byte]string{'h': alias})
+	}
+	if strings.EqualFold(hc.proxyJump, "none") {
+		hc.proxyJump = ""
+	}
+	return hc
+}
+
+// setOnce sets *field to value unless an earlier section already set it
+func setOnce(field *string, value string) {
+	if *field == "" {
+		*field = value
+	}
+}
+
+// matchHostPatterns reports whether host matches one of the patterns and
+// none of the negated (!) ones
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		if negated, ok := strings.CutPrefix(pattern, "!"); ok {
+			if wildcardMatch(negated, host) {
+				return false
+			}
+			continue
+		}
+		if wildcardMatch(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// wildcardMatch matches s against a pattern in which * matches any run of
+// characters and ? any single character
+func wildcardMatch(pattern, s string) bool {
+	for pattern != "" {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return s == ""
+}
+
+// expandTokens replaces %-tokens such as %h in s; %% is a literal percent
+func expandTokens(s string, tokens map[byte]string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		if s[i] == '%' {
+			b.WriteByte('%')
+		} else if value, ok := tokens[s[i]]; ok {
+			b.WriteString(value)
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// expandHome replaces a leading ~ with the home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// localUser returns the name of the user running gossh
+func localUser() string {
+	if u, err := osuser.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// identityFilesFor expands the IdentityFile entries of hc for logging in as
+// remoteUser, keeping those that exist
+func (hc hostConfig) identityFilesFor(remoteUser string) []string {
+	home, _ := os.UserHomeDir()
+	tokens := map[byte]string{'d': home, 'h': hc.hostName, 'r': remoteUser, 'u': localUser()}
+	var paths []string
+	for _, file := range hc.identityFiles {
+		path := expandHome(expandTokens(file, tokens))
+		if _, err := os.Stat(path); err != nil {
+			log.Debug("Skipping identity file from SSH config: ", err)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// applySSHConfig resolves the --host alias through config, filling in the
+// user, port and keys the flags left unset, and returns the jump hosts to
+// connect through
+func applySSHConfig(config *sshConfig, portSet bool) ([]jumpHost, error) {
+	hc := config.lookup(host)
+	host = hc.hostName
+	if user == "" {
+		user = hc.user
+	}
+	if !portSet && hc.port != "" {
+		port = hc.port
+	}
+	if len(clientKeyPaths) == 0 {
+		remoteUser := user
+		if remoteUser == "" {
+			remoteUser = localUser()
+		}
+		clientKeyPaths = hc.identityFilesFor(remoteUser)
+	}
+	if hc.proxyJump == "" {
+		return nil, nil
+	}
+	return parseJumpHosts(hc.proxyJump, config)
+}
+
+// parseJumpHosts parses a ProxyJump value, a comma-separated list of
+// [user@]host[:port] entries, resolving each host through config
+func parseJumpHosts(value string, config *sshConfig) ([]jumpHost, error) {
+	var jumps []jumpHost
+	for _, spec := range strings.Split(value, ",") {
+		spec = strings.TrimPrefix(strings.TrimSpace(spec), "ssh://")
+		if spec == "" {
+			return nil, fmt.Errorf("invalid jump host list %q", value)
+		}
+		jumpUser, hostPort, found := strings.Cut(spec, "@")
+		if !found {
+			jumpUser, hostPort = "", spec
+		}
+		alias, jumpPort := hostPort, ""
+		if h, p, err := net.SplitHostPort(hostPort); err == nil {
+			alias, jumpPort = h, p
+		}
+		hc := config.lookup(alias)
+		if jumpPort == "" {
+			jumpPort = hc.port
+		}
+		if jumpPort == "" {
+			jumpPort = "22"
+		}
+		if jumpUser == "" {
+			jumpUser = hc.user
+		}
+		if jumpUser == "" {
+			jumpUser = localUser()
+		}
+		jumps = append(jumps, jumpHost{user: jumpUser, addr: net.JoinHostPort(hc.hostName, jumpPort)})
+	}
+	return jumps, nil
+}
+
+// dialVia connects to addr through each of the jump hosts in turn, logging
+// in to all of them with the credentials and host key checks of config. The
+// first hop is reached through proxy unless it is nil.
+func dialVia(proxy *url.URL, jumps []jumpHost, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if len(jumps) == 0 {
+		return dialSSH(dialNetwork(), proxy, addr, config)
+	}
+	var client *ssh.Client
+	for _, hop := range append(jumps, jumpHost{user: config.User, addr: addr}) {
+		hopConfig := *config
+		hopConfig.User = hop.user
+		if client == nil {
+			first, err := dialSSH(dialNetwork(), proxy, hop.addr, &hopConfig)
+			if err != nil {
+				return nil, fmt.Errorf("jump host %s: %w", hop.addr, err)
+			}
+			client = first
+			continue
+		}
+		conn, err := client.Dial("tcp", hop.addr)
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("could not reach %s through the jump host: %s", hop.addr, err)
+		}
+		c, chans, reqs, err := ssh.NewClientConn(conn, hop.addr, &hopConfig)
+		if err != nil {
+			conn.Close()
+			client.Close()
+			if hop.addr != addr {
+				return nil, fmt.Errorf("jump host %s: %w", hop.addr, err)
+			}
+			return nil, err
+		}
+		// Each jump connection lives as long as the one relayed through it
+		next, prev := ssh.NewClient(c, chans, reqs), client
+		go func() {
+			next.Wait()
+			prev.Close()
+		}()
+		client = next
+	}
+	return client, nil
+}
@@ -0,0 +1,178 @@
+// cmd/sshconfig_test.go
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+const testSSHConfig = `# Defaults come last, as in most configs
+Host web-*  !web-legacy
+    HostName %h.internal.example.com
+    User deploy
+    Port 2222
+
+Host db
+    HostName=10.0.0.5
+    ProxyJump admin@bastion:2200,web-1
+    IdentityFile "~/.ssh/id_db"
+
+Match user root
+    User nobody
+
+Host bastion
+    HostName bastion.example.com
+    ProxyJump none
+
+Host *
+    User fallback
+    Port 22
+    IdentityFile ~/.ssh/id_%r
+`
+
+func TestSSHConfigLookup(t *testing.T) {
+	blocks, err := parseSSHConfig([]byte(testSSHConfig), t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("parseSSHConfig failed: %v", err)
+	}
+	config := &sshConfig{blocks: blocks}
+
+	tests := []struct {
+		alias string
+		want  hostConfig
+	}{
+		{"web-1", hostConfig{hostName: "web-1.internal.example.com", user: "deploy", port: "2222", identityFiles: []string{"~/.ssh/id_%r"}}},
+		{"WEB-2", hostConfig{hostName: "WEB-2.internal.example.com", user: "deploy", port: "2222", identityFiles: []string{"~/.ssh/id_%r"}}},
+		{"web-legacy", hostConfig{hostName: "web-legacy", user: "fallback", port: "22", identityFiles: []string{"~/.ssh/id_%r"}}},
+		{"db", hostConfig{hostName: "10.0.0.5", user: "fallback", port: "22", proxyJump: "admin@bastion:2200,web-1", identityFiles: []string{"~/.ssh/id_db", "~/.ssh/id_%r"}}},
+		{"bastion", hostConfig{hostName: "bastion.example.com", user: "fallback", port: "22", identityFiles: []string{"~/.ssh/id_%r"}}},
+	}
+	for _, tt := range tests {
+		if got := config.lookup(tt.alias); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("lookup(%q) = %+v, want %+v", tt.alias, got, tt.want)
+		}
+	}
+
+	jumps, err := parseJumpHosts("admin@bastion:2200,web-1", config)
+	if err != nil {
+		t.Fatalf("parseJumpHosts failed: %v", err)
+	}
+	want := []jumpHost{{"admin", "bastion.example.com:2200"}, {"deploy", "web-1.internal.example.com:2222"}}
+	if !reflect.DeepEqual(jumps, want) {
+		t.Errorf("parseJumpHosts = %+v, want %+v", jumps, want)
+	}
+	if _, err := parseJumpHosts("bastion,,web-1", config); err == nil {
+		t.Error("an empty jump host should be rejected")
+	}
+}
+
+func TestSSHConfigInclude(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "config.d"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	included := "User from-include\nHost other\n    User other\n"
+	if err := os.WriteFile(filepath.Join(dir, "config.d", "work"), []byte(included), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config")
+	data := "Host work\n    Include config.d/*\n    Port 2022\n"
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := loadSSHConfig(path)
+	if err != nil {
+		t.Fatalf("loadSSHConfig failed: %v", err)
+	}
+	if hc := config.lookup("work"); hc.user != "from-include" || hc.port != "2022" {
+		t.Errorf("lookup(work) = %+v, want the included user and the port after the Include", hc)
+	}
+	if hc := config.lookup("other"); hc.user != "other" || hc.port != "" {
+		t.Errorf("lookup(other) = %+v, want only the included Host section", hc)
+	}
+
+	if config, err := loadSSHConfig(filepath.Join(dir, "missing")); err != nil || len(config.blocks) != 0 {
+		t.Errorf("a missing config should be empty, got %+v, %v", config, err)
+	}
+	os.WriteFile(path, []byte("Include config\n"), 0o600)
+	if _, err := loadSSHConfig(path); err == nil {
+		t.Error("an Include loop should fail")
+	}
+}
+
+func TestApplySSHConfig(t *testing.T) {
+	origHost, origUser, origPort, origKeys := host, user, port, clientKeyPaths
+	defer func() {
+		host, user, port, clientKeyPaths = origHost, origUser, origPort, origKeys
+	}()
+
+	dir := t.TempDir()
+	key := filepath.Join(dir, "id_deploy")
+	os.WriteFile(key, []byte("key"), 0o600)
+	blocks, err := parseSSHConfig([]byte("Host app\n  HostName app.example.com\n  User deploy\n  Port 2022\n  IdentityFile "+dir+"/id_%r\n  IdentityFile "+dir+"/missing\n"), dir, 0)
+	if err != nil {
+		t.Fatalf("parseSSHConfig failed: %v", err)
+	}
+	config := &sshConfig{blocks: blocks}
+
+	host, user, port, clientKeyPaths = "app", "", "22", nil
+	if _, err := applySSHConfig(config, false); err != nil {
+		t.Fatalf("applySSHConfig failed: %v", err)
+	}
+	if host != "app.example.com" || user != "deploy" || port != "2022" || !reflect.DeepEqual(clientKeyPaths, []string{key}) {
+		t.Errorf("resolved %s@%s:%s with keys %v", user, host, port, clientKeyPaths)
+	}
+
+	// Flags take precedence over the config
+	host, user, port, clientKeyPaths = "app", "admin", "2200", []string{"id_rsa"}
+	applySSHConfig(config, true)
+	if host != "app.example.com" || user != "admin" || port != "2200" || !reflect.DeepEqual(clientKeyPaths, []string{"id_rsa"}) {
+		t.Errorf("resolved %s@%s:%s with keys %v, want the flags kept", user, host, port, clientKeyPaths)
+	}
+}
+
+func TestDialVia(t *testing.T) {
+	bastion := sshtest.StartTestServer(t, ssh.ServerOptions{AllowLocalForwarding: true})
+	target := sshtest.StartTestServer(t, ssh.ServerOptions{AllowedCommands: []string{"whoami"}})
+
+	config := &cryptossh.ClientConfig{
+		User: "admin",
+		Auth: []cryptossh.AuthMethod{cryptossh.PublicKeys(bastion.ClientSigner, target.ClientSigner)},
+		HostKeyCallback: func(hostname string, _ net.Addr, key cryptossh.PublicKey) error {
+			want := map[string]cryptossh.PublicKey{bastion.Addr: bastion.HostKey, target.Addr: target.HostKey}[hostname]
+			if want == nil || string(want.Marshal()) != string(key.Marshal()) {
+				return fmt.Errorf("unexpected host key for %s", hostname)
+			}
+			return nil
+		},
+	}
+	client, err := dialVia(nil, []jumpHost{{"jumper", bastion.Addr}}, target.Addr, config)
+	if err != nil {
+		t.Fatalf("dialVia failed: %v", err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	if out, err := session.Output("whoami"); err != nil || string(out) != "You are: admin\n" {
+		t.Errorf("whoami through the jump host = %q, %v", out, err)
+	}
+	if attempts := bastion.AuthAttempts(); len(attempts) == 0 || attempts[len(attempts)-1].User != "jumper" {
+		t.Errorf("bastion attempts = %+v, want a login as jumper", attempts)
+	}
+
+	// A jump host that refuses forwarding fails the connection
+	if _, err := dialVia(nil, []jumpHost{{"jumper", target.Addr}}, bastion.Addr, config); err == nil {
+		t.Error("dialing through a host without forwarding should fail")
+	}
+}
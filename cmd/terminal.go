@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"slices"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Terminal size requested when stdout is not a terminal
+const (
+	defaultColumns = 80
+	defaultRows    = 24
+)
+
+// defaultTerm is the terminal type requested when $TERM is not set
+const defaultTerm = "xterm-256color"
+
+// terminalSize returns the columns and rows of the local terminal
+func terminalSize() (int, int) {
+	columns, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || columns <= 0 || rows <= 0 {
+		return defaultColumns, defaultRows
+	}
+	return columns, rows
+}
+
+// pipeStdin reports whether stdin should be sent to a command: when forced,
+// or when it is not a terminal, such as input piped to gossh
+func pipeStdin(force bool) bool {
+	return force || !term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// commandStdin returns what to send to a --cmd command as its stdin: in
+// when pipeStdin says so, forced by --stdin or a - argument, or else nil
+func commandStdin(in io.Reader, force bool, args []string) io.Reader {
+	if !pipeStdin(force || slices.Contains(args, "-")) {
+		return nil
+	}
+	return in
+}
+
+// terminalType returns the terminal type to request the remote PTY with:
+// override when set, otherwise the local $TERM
+func terminalType(override string) string {
+	if override != "" {
+		return override
+	}
+	if t := os.Getenv("TERM"); t != "" {
+		return t
+	}
+	return defaultTerm
+}
+
+// watchWindowSize sends a window-change request to session whenever the
+// local terminal is resized, until the returned function is called. onResize,
+// if not nil, is told the new size too.
+func watchWindowSize(session *ssh.Session, onResize func(columns, rows int)) func() {
+	events, stopEvents := resizeEvents()
+	done := make(chan struct{})
+	go func() {
+		columns, rows := terminalSize()
+		for {
+			select {
+			case <-done:
+				return
+			case <-events:
+			}
+			newColumns, newRows := terminalSize()
+			if newColumns == columns && newRows == rows {
+				continue
+			}
+			columns, rows = newColumns, newRows
+			if err := session.WindowChange(rows, columns); err != nil {
+				log.Debug("Could not send window size: ", err)
+			}
+			if onResize != nil {
+				onResize(columns, rows)
+			}
+		}
+	}()
+	return func() {
+		stopEvents()
+		close(done)
+	}
+}
@@ -0,0 +1,98 @@
+// cmd/terminal_test.go
+package cmd
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	"github.com/creack/pty"
+)
+
+func TestTerminalType(t *testing.T) {
+	tests := []struct {
+		env      string
+		override string
+		want     string
+	}{
+		{"", "", "xterm-256color"},
+		{"screen-256color", "", "screen-256color"},
+		{"screen-256color", "vt100", "vt100"},
+	}
+	for _, tt := range tests {
+		t.Setenv("TERM", tt.env)
+		if got := terminalType(tt.override); got != tt.want {
+			t.Errorf("terminalType(%q) with TERM=%q = %q, want %q", tt.override, tt.env, got, tt.want)
+		}
+	}
+
+	if columns, rows := terminalSize(); columns <= 0 || rows <= 0 {
+		t.Errorf("terminalSize() = %dx%d, want a usable size", columns, rows)
+	}
+}
+
+func TestPipeStdin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	defer func(orig *os.File) { os.Stdin = orig }(os.Stdin)
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+	client := srv.Dial(t)
+
+	// run runs cat remotely with the stdin commandStdin picks, returning
+	// what reached it
+	run := func(in *bufio.Reader, force bool, args []string) string {
+		t.Helper()
+		session, err := client.NewSession()
+		if err != nil {
+			t.Fatalf("NewSession failed: %v", err)
+		}
+		defer session.Close()
+		if in := commandStdin(in, force, args); in != nil {
+			session.Stdin = in
+		}
+		out, err := session.Output("cat")
+		if err != nil {
+			t.Fatalf("cat failed: %v", err)
+		}
+		return string(out)
+	}
+
+	// Piped stdin is sent without asking
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("piped input\n")
+	w.Close()
+	os.Stdin = r
+	if got := run(bufio.NewReader(r), false, nil); got != "piped input\n" {
+		t.Errorf("piped stdin reached the command as %q, want %q", got, "piped input\n")
+	}
+	r.Close()
+
+	// A terminal's input is only sent when --stdin or a - argument asks
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+	os.Stdin = tty
+	if in := commandStdin(bufio.NewReader(tty), false, []string{"uptime"}); in != nil {
+		t.Error("terminal stdin should not be sent unless forced")
+	}
+	if got := run(bufio.NewReader(strings.NewReader("typed\n")), false, nil); got != "" {
+		t.Errorf("terminal stdin reached the command as %q, want nothing", got)
+	}
+	if got := run(bufio.NewReader(strings.NewReader("typed\n")), false, []string{"-"}); got != "typed\n" {
+		t.Errorf("with a - argument, stdin reached the command as %q, want %q", got, "typed\n")
+	}
+	if got := run(bufio.NewReader(strings.NewReader("typed\n")), true, nil); got != "typed\n" {
+		t.Errorf("with --stdin, stdin reached the command as %q, want %q", got, "typed\n")
+	}
+}
@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	remoteForwards      []string
+	dynamicForwards     []string
+	tunnelAliveInterval time.Duration
+)
+
+// tunnelMaxBackoff caps the wait between reconnection attempts
+const tunnelMaxBackoff = time.Minute
+
+// tunnelCmd represents the tunnel command
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel",
+	Short: "Keep port forwards open without a shell",
+	Long: `Open port forwards through an SSH server and keep them open, without a shell
+or command, like ssh -N. Dropped connections are made again, waiting
+--retry-backoff and then twice as long after each failure, up to a minute;
+local ports stay open meanwhile. Ctrl+C closes the tunnels.
+
+Connections are made as gossh client makes them, with the same flags.
+
+Examples:
+  # Reach a database behind a bastion on localhost:5432
+  gossh tunnel --host bastion -L 5432:db:5432
+
+  # Expose a local web server on the server's port 8080
+  gossh tunnel --host example.com -R 8080:localhost:3000
+
+  # Browse through the server with a SOCKS5 proxy on localhost:1080
+  gossh tunnel --host bastion -D 1080`,
+	Run: func(cmd *cobra.Command, args []string) {
+		errorColor := color.New(color.FgRed, color.Bold).SprintFunc()
+		successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+		fail := func(err error) {
+			log.Error(err)
+			fmt.Println(errorColor("✗ ") + err.Error())
+			os.Exit(1)
+		}
+		if host == "" {
+			fail(errors.New("--host is required"))
+		}
+		if len(localForwards)+len(remoteForwards)+len(dynamicForwards) == 0 {
+			fail(errors.New("at least one -L, -R or -D forward is required"))
+		}
+
+		t := &tunnel{backoff: retryBackoff, aliveInterval: tunnelAliveInterval, out: os.Stdout}
+		for _, spec := range localForwards {
+			fwd, err := parseLocalForward(spec)
+			if err != nil {
+				fail(err)
+			}
+			t.locals = append(t.locals, fwd)
+		}
+		for _, spec := range remoteForwards {
+			fwd, err := parseRemoteForward(spec)
+			if err != nil {
+				fail(err)
+			}
+			t.remotes = append(t.remotes, fwd)
+		}
+		for _, spec := range dynamicForwards {
+			fwd, err := parseDynamicForward(spec)
+			if err != nil {
+				fail(err)
+			}
+			t.dynamics = append(t.dynamics, fwd)
+		}
+
+		dial, err := serverDialer(cmd.Flags())
+		if err != nil {
+			fail(err)
+		}
+		t.dial = dial
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := t.run(ctx); err != nil {
+			fail(err)
+		}
+		fmt.Println(successColor("✓ ") + "Tunnels closed")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tunnelCmd)
+
+	tunnelCmd.Flags().StringVarP(&host, "host", "H", "", "SSH server hostname, or a Host alias from ~/.ssh/config")
+	tunnelCmd.Flags().StringVarP(&user, "user", "u", "", "SSH username (default: the SSH config's User, or the local user)")
+	addConnectionFlags(tunnelCmd.Flags(), "p", "k")
+	tunnelCmd.Flags().StringArrayVarP(&localForwards, "local-forward", "L", nil, "Forward a local port through the server, [bind_address:]port:host:hostport (repeatable)")
+	tunnelCmd.Flags().StringArrayVarP(&remoteForwards, "remote-forward", "R", nil, "Forward a server port to this machine, [bind_address:]port:host:hostport (repeatable)")
+	tunnelCmd.Flags().StringArrayVarP(&dynamicForwards, "dynamic-forward", "D", nil, "Run a SOCKS5 proxy on a local port whose connections are made from the server, [bind_address:]port (repeatable)")
+	tunnelCmd.Flags().DurationVar(&retryBackoff, "retry-backoff", 5*time.Second, "Wait before the first reconnection attempt, doubled after each further failure up to a minute")
+	tunnelCmd.Flags().DurationVar(&tunnelAliveInterval, "server-alive-interval", 15*time.Second, "Probe the server this often and reconnect when it stops answering (0 disables)")
+	tunnelCmd.Flags().IntVar(&serverAliveCountMax, "server-alive-count-max", 3, "Unanswered keepalives before reconnecting")
+}
+
+// tunnelClient is the current connection of a tunnel, which local and
+// dynamic forwards dial through and reconnections replace
+type tunnelClient struct {
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+func (c *tunnelClient) set(client *ssh.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client = client
+}
+
+// Dial connects from the server, failing while the tunnel reconnects
+func (c *tunnelClient) Dial(network, addr string) (net.Conn, error) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	if client == nil {
+		return nil, errors.New("not connected to the server")
+	}
+	return client.Dial(network, addr)
+}
+
+// tunnel keeps forwards open through connections made with dial
+type tunnel struct {
+	dial     func() (*ssh.Client, error)
+	locals   []localForward
+	remotes  []remoteForward
+	dynamics []dynamicForward
+	// backoff is the wait before the first reconnection attempt
+	backoff       time.Duration
+	aliveInterval time.Duration
+	out           io.Writer
+
+	current tunnelClient
+}
+
+// run opens the forwards and reconnects whenever the connection drops,
+// until ctx is done. Failing to connect at first or to open a local port is
+// an error; later connection failures are retried.
+func (t *tunnel) run(ctx context.Context) error {
+	infoColor := color.New(color.FgCyan).SprintFunc()
+	successColor := color.New(color.FgGreen, color.Bold).SprintFunc()
+	warningColor := color.New(color.FgYellow).SprintFunc()
+
+	client, err := t.dial()
+	if err != nil {
+		return err
+	}
+
+	// Local ports stay open across reconnections
+	for _, fwd := range t.locals {
+		listener, err := fwd.listen(&t.current)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("failed to forward %s: %s", fwd.bind, err)
+		}
+		defer listener.Close()
+		fmt.Fprintln(t.out, infoColor("⟹ ")+"Forwarding "+color.CyanString(listener.Addr().String())+" to "+color.CyanString(fwd.dest))
+	}
+	for _, fwd := range t.dynamics {
+		listener, err := fwd.listen(&t.current)
+		if err != nil {
+			client.Close()
+			return fmt.Errorf("failed to forward %s: %s", fwd.bind, err)
+		}
+		defer listener.Close()
+		fmt.Fprintln(t.out, infoColor("⟹ ")+"SOCKS5 proxy on "+color.CyanString(listener.Addr().String()))
+	}
+
+	backoff := t.backoff
+	for {
+		if client != nil {
+			fmt.Fprintln(t.out, successColor("✓ ")+"Connected to "+client.RemoteAddr().String())
+			backoff = t.backoff
+			err = t.serve(ctx, client)
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintln(t.out, warningColor("⚠ ")+"Connection lost: "+err.Error())
+		} else {
+			fmt.Fprintln(t.out, warningColor("⚠ ")+"Reconnecting failed: "+err.Error())
+		}
+
+		fmt.Fprintln(t.out, infoColor("⟹ ")+"Reconnecting in "+backoff.String())
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, tunnelMaxBackoff)
+		client, err = t.dial()
+	}
+}
+
+// serve opens the remote forwards on client and waits until the connection
+// drops, returning why, or until ctx is done
+func (t *tunnel) serve(ctx context.Context, client *ssh.Client) error {
+	infoColor := color.New(color.FgCyan).SprintFunc()
+	warningColor := color.New(color.FgYellow).SprintFunc()
+
+	defer client.Close()
+	t.current.set(client)
+	defer t.current.set(nil)
+
+	// The server closes its listeners with the connection
+	for _, fwd := range t.remotes {
+		listener, err := fwd.listen(client)
+		if err != nil {
+			fmt.Fprintln(t.out, warningColor("⚠ ")+"Server refused to forward "+fwd.bind+": "+err.Error())
+			continue
+		}
+		fmt.Fprintln(t.out, infoColor("⟹ ")+"Forwarding server port "+color.CyanString(listener.Addr().String())+" to "+color.CyanString(fwd.dest))
+	}
+
+	dropped := make(chan error, 2)
+	go func() {
+		err := client.Wait()
+		if err == nil {
+			err = errors.New("the server closed the connection")
+		}
+		dropped <- err
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	if t.aliveInterval > 0 {
+		go func() {
+			if err := serverAlive(client, t.aliveInterval, serverAliveCountMax, done); err != nil {
+				dropped <- err
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-dropped:
+		return err
+	}
+}
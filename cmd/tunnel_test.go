@@ -0,0 +1,128 @@
+// cmd/tunnel_test.go
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// freeAddr returns a local address nothing listens on
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// waitFor polls cond until it holds, failing the test after 5 seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTunnelReconnects(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{AllowLocalForwarding: true})
+
+	var mu sync.Mutex
+	var clients []*cryptossh.Client
+	dial := func() (*cryptossh.Client, error) {
+		client, err := cryptossh.Dial("tcp", srv.Addr, srv.ClientConfig)
+		if err == nil {
+			mu.Lock()
+			clients = append(clients, client)
+			mu.Unlock()
+		}
+		return client, err
+	}
+	dials := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(clients)
+	}
+	local := freeAddr(t)
+	var out bytes.Buffer
+	tn := &tunnel{
+		dial:    dial,
+		locals:  []localForward{{local, startEcho(t)}},
+		backoff: 10 * time.Millisecond,
+		out:     &out,
+	}
+	isConnected := func() bool {
+		tn.current.mu.Lock()
+		defer tn.current.mu.Unlock()
+		return tn.current.client != nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	go func() { result <- tn.run(ctx) }()
+
+	waitFor(t, "the first connection", isConnected)
+	conn, err := net.Dial("tcp", local)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	checkEcho(t, conn)
+	conn.Close()
+
+	// Drop the connection; the local port keeps working once reconnected
+	mu.Lock()
+	clients[0].Close()
+	mu.Unlock()
+	waitFor(t, "the reconnection", func() bool { return dials() == 2 && isConnected() })
+	conn, err = net.Dial("tcp", local)
+	if err != nil {
+		t.Fatalf("Dial after reconnecting failed: %v", err)
+	}
+	checkEcho(t, conn)
+	conn.Close()
+
+	cancel()
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("run = %v after cancelling", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not return after cancelling")
+	}
+	for _, want := range []string{"Forwarding " + local, "Connection lost", "Reconnecting in 10ms"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("status output is missing %q:\n%s", want, out.String())
+		}
+	}
+	if _, err := net.Dial("tcp", local); err == nil {
+		t.Error("the local port is still open after the tunnel closed")
+	}
+}
+
+func TestTunnelFirstConnectionFails(t *testing.T) {
+	refused := errors.New("connection refused")
+	tn := &tunnel{
+		dial:   func() (*cryptossh.Client, error) { return nil, refused },
+		locals: []localForward{{freeAddr(t), "db:5432"}},
+		out:    &bytes.Buffer{},
+	}
+	if err := tn.run(context.Background()); !errors.Is(err, refused) {
+		t.Errorf("run = %v, want the dial error", err)
+	}
+}
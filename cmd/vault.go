@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// vaultScheme prefixes key paths read from Vault's KV store, e.g.
+// vault://secret/data/gossh/host#private_key
+const vaultScheme = "vault://"
+
+// vaultKeyField is the secret field keys are read from when none is given
+const vaultKeyField = "private_key"
+
+// vaultTimeout bounds each Vault request made at startup
+const vaultTimeout = 30 * time.Second
+
+// readKeySource reads a private key from a file, or from Vault when the
+// path is a vault:// reference
+func readKeySource(path string) ([]byte, error) {
+	if !strings.HasPrefix(path, vaultScheme) {
+		return os.ReadFile(path)
+	}
+	secret, field, found := strings.Cut(strings.TrimPrefix(path, vaultScheme), "#")
+	if !found || field == "" {
+		field = vaultKeyField
+	}
+	vault, err := ssh.NewVaultClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	key, err := vault.ReadSecret(ctx, secret, field)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(key), nil
+}
+
+// vaultHostCertificates has Vault's SSH secrets engine sign every host key
+func vaultHostCertificates(keys [][]byte, mount, role string, principals []string) ([][]byte, error) {
+	vault, err := ssh.NewVaultClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	var certs [][]byte
+	for _, key := range keys {
+		if len(key) == 0 {
+			continue
+		}
+		signer, err := cryptossh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+		cert, err := vault.SignKey(ctx, ssh.VaultSignRequest{
+			Mount:      mount,
+			Role:       role,
+			PublicKey:  signer.PublicKey(),
+			Principals: principals,
+			Host:       true,
+		})
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cryptossh.MarshalAuthorizedKey(cert))
+	}
+	return certs, nil
+}
+
+// vaultUserSigner has Vault's SSH secrets engine sign the client key for
+// user and returns a signer presenting the short-lived certificate
+func vaultUserSigner(signer cryptossh.Signer, mount, role, user string, ttl time.Duration) (cryptossh.Signer, *cryptossh.Certificate, error) {
+	vault, err := ssh.NewVaultClientFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), vaultTimeout)
+	defer cancel()
+	cert, err := vault.SignKey(ctx, ssh.VaultSignRequest{
+		Mount:      mount,
+		Role:       role,
+		PublicKey:  signer.PublicKey(),
+		Principals: []string{user},
+		TTL:        ttl,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	certSigner, err := cryptossh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certSigner, cert, nil
+}
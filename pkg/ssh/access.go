@@ -0,0 +1,70 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// matchUserPattern reports whether a login matches an AllowUsers or DenyUsers
+// pattern. Patterns take the form USER or USER@HOST, like OpenSSH: USER may
+// use the wildcards * and ?, and HOST is an address pattern or CIDR block
+// matched against the client's address.
+func matchUserPattern(pattern, user string, addr net.Addr) bool {
+	userPattern, hostPattern, hasHost := strings.Cut(pattern, "@")
+	if ok, _ := path.Match(userPattern, user); !ok {
+		return false
+	}
+	return !hasHost || matchSourceAddress([]string{hostPattern}, addr)
+}
+
+// userAllowed applies DenyUsers and then AllowUsers to a login. Denials take
+// precedence; an empty AllowUsers admits every user not denied.
+func (o ServerOptions) userAllowed(user string, addr net.Addr) error {
+	for _, pattern := range o.DenyUsers {
+		if matchUserPattern(pattern, user, addr) {
+			return fmt.Errorf("user %q from %s is denied", user, addr)
+		}
+	}
+	if len(o.AllowUsers) == 0 {
+		return nil
+	}
+	for _, pattern := range o.AllowUsers {
+		if matchUserPattern(pattern, user, addr) {
+			return nil
+		}
+	}
+	return fmt.Errorf("user %q from %s is not allowed", user, addr)
+}
+
+// sourceAllowed reports whether a client may connect from addr under AllowFrom
+func (o ServerOptions) sourceAllowed(addr net.Addr) bool {
+	return len(o.AllowFrom) == 0 || matchSourceAddress(o.AllowFrom, addr)
+}
+
+// restrictUsers makes every authentication callback of config reject users
+// excluded by AllowUsers or DenyUsers before looking at their credentials
+func (o ServerOptions) restrictUsers(config *ssh.ServerConfig) {
+	if len(o.AllowUsers) == 0 && len(o.DenyUsers) == 0 {
+		return
+	}
+	config.PublicKeyCallback = withUserFilter(o, config.PublicKeyCallback)
+	config.PasswordCallback = withUserFilter(o, config.PasswordCallback)
+	config.KeyboardInteractiveCallback = withUserFilter(o, config.KeyboardInteractiveCallback)
+}
+
+// withUserFilter wraps an authentication callback with the user check
+func withUserFilter[T any](o ServerOptions, callback func(ssh.ConnMetadata, T) (*ssh.Permissions, error)) func(ssh.ConnMetadata, T) (*ssh.Permissions, error) {
+	if callback == nil {
+		return nil
+	}
+	return func(c ssh.ConnMetadata, credential T) (*ssh.Permissions, error) {
+		if err := o.userAllowed(c.User(), c.RemoteAddr()); err != nil {
+			return nil, err
+		}
+		return callback(c, credential)
+	}
+}
@@ -0,0 +1,117 @@
+// pkg/ssh/access_test.go
+package ssh
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMatchUserPattern(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 50000}
+
+	tests := []struct {
+		pattern string
+		user    string
+		want    bool
+	}{
+		{"alice", "alice", true},
+		{"alice", "bob", false},
+		{"deploy-*", "deploy-web", true},
+		{"alice@10.0.0.0/8", "alice", true},
+		{"alice@192.168.0.0/16", "alice", false},
+		{"*@10.1.2.*", "bob", true},
+		{"bob@10.1.2.*", "alice", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchUserPattern(tt.pattern, tt.user, addr); got != tt.want {
+			t.Errorf("matchUserPattern(%q, %q) = %v, want %v", tt.pattern, tt.user, got, tt.want)
+		}
+	}
+}
+
+func TestUserAllowed(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 50000}
+
+	tests := []struct {
+		name string
+		opts ServerOptions
+		user string
+		want bool
+	}{
+		{"no lists", ServerOptions{}, "anyone", true},
+		{"allowed", ServerOptions{AllowUsers: []string{"alice", "bob"}}, "bob", true},
+		{"not allowed", ServerOptions{AllowUsers: []string{"alice"}}, "bob", false},
+		{"denied", ServerOptions{DenyUsers: []string{"root"}}, "root", false},
+		{"deny wins over allow", ServerOptions{AllowUsers: []string{"*"}, DenyUsers: []string{"root"}}, "root", false},
+		{"allowed from network", ServerOptions{AllowUsers: []string{"deploy@10.0.0.0/8"}}, "deploy", true},
+		{"denied from network", ServerOptions{DenyUsers: []string{"*@10.1.0.0/16"}}, "alice", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.opts.userAllowed(tt.user, addr); (err == nil) != tt.want {
+				t.Errorf("userAllowed(%q) error = %v, want allowed %v", tt.user, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerAllowUsers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{AllowUsers: []string{"alice@127.0.0.1"}, DenyUsers: []string{"root"}})
+	dialTestServer(t, addr, "alice", signer).Close()
+
+	for _, user := range []string{"bob", "root"} {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         time.Second,
+		})
+		if err == nil {
+			client.Close()
+			t.Errorf("%s should not be able to log in", user)
+		}
+	}
+}
+
+func TestServerAllowFrom(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowFrom []string
+		wantOK    bool
+	}{
+		{"no restriction", nil, true},
+		{"loopback allowed", []string{"127.0.0.0/8"}, true},
+		{"other network", []string{"10.0.0.0/8"}, false},
+		{"loopback excluded", []string{"*", "!127.0.0.1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{AllowFrom: tt.allowFrom})
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User:            "alice",
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         time.Second,
+			})
+			if client != nil {
+				client.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("Dial error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// agentRequest asks the server to make the client's agent available to
+	// the programs of a session (ssh -A)
+	agentRequest = "auth-agent-req@openssh.com"
+	// agentChannel is the channel type opened back to the client for each
+	// connection to the forwarded agent
+	agentChannel = "auth-agent@openssh.com"
+)
+
+// agentPermitted reports whether the session may forward the client's agent
+func (s *session) agentPermitted() bool {
+	return s.opts.AllowAgentForwarding && permitted(s.conn, "permit-agent-forwarding")
+}
+
+// forwardAgent listens on a Unix socket in a private directory and points
+// SSH_AUTH_SOCK of the session's programs at it. Each connection to the
+// socket is relayed to the client's agent over a new channel. The socket is
+// removed when the session ends.
+func (s *session) forwardAgent() error {
+	if s.agentSocket != "" {
+		return nil
+	}
+	if s.root != "" {
+		// The socket would live outside the chroot directory
+		return errors.New("agent forwarding is not available in chroot sessions")
+	}
+	dir, err := os.MkdirTemp("", "gossh-agent-")
+	if err != nil {
+		return fmt.Errorf("could not create agent socket directory: %s", err)
+	}
+	path := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return fmt.Errorf("could not listen on agent socket: %s", err)
+	}
+	if s.account != nil {
+		// Programs run as the user's account must be able to reach it
+		for _, name := range []string{dir, path} {
+			if err := os.Lchown(name, int(s.account.uid), int(s.account.gid)); err != nil {
+				listener.Close()
+				os.RemoveAll(dir)
+				return fmt.Errorf("could not hand the agent socket to %s: %s", s.account.name, err)
+			}
+		}
+	}
+	go func() {
+		<-s.ctx.Done()
+		listener.Close()
+		os.RemoveAll(dir)
+	}()
+	go s.serveAgent(listener)
+
+	s.agentSocket = path
+	s.env = append(s.env, "SSH_AUTH_SOCK="+path)
+	s.logger.Info("forwarding agent", "socket", path)
+	return nil
+}
+
+// serveAgent relays the connections accepted on the agent socket to the
+// client until the listener is closed
+func (s *session) serveAgent(listener net.Listener) {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			channel, requests, err := s.conn.OpenChannel(agentChannel, nil)
+			if err != nil {
+				s.logger.Warn("could not reach the client's agent", "error", err)
+				local.Close()
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			relay(channel, local)
+		}()
+	}
+}
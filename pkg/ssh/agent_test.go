@@ -0,0 +1,111 @@
+// pkg/ssh/agent_test.go
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentForwarding(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hostKey, _ := newTestKeyPair(t)
+	_, signer := newTestKeyPair(t)
+	_, restricted := newTestKeyPair(t)
+	authorized := string(ssh.MarshalAuthorizedKey(signer.PublicKey())) +
+		"no-agent-forwarding " + string(ssh.MarshalAuthorizedKey(restricted.PublicKey()))
+	server, err := NewServer(hostKey, []byte(authorized), ServerOptions{Shell: "/bin/sh", AllowAgentForwarding: true})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(ctx, listener)
+	addr := listener.Addr().String()
+
+	// The client's agent holds a key the server has never seen
+	keyring := agent.NewKeyring()
+	_, agentKey, _ := ed25519.GenerateKey(rand.Reader)
+	if err := keyring.Add(agent.AddedKey{PrivateKey: agentKey, Comment: "forwarded"}); err != nil {
+		t.Fatalf("keyring.Add failed: %v", err)
+	}
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+	if err := agent.ForwardToAgent(client, keyring); err != nil {
+		t.Fatalf("ForwardToAgent failed: %v", err)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		t.Fatalf("agent forwarding should be granted: %v", err)
+	}
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	// Keep the session open until stdin is closed
+	if err := session.Start(`echo "$SSH_AUTH_SOCK"; cat`); err != nil {
+		t.Fatalf("Failed to start command: %v", err)
+	}
+	socket, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read SSH_AUTH_SOCK: %v", err)
+	}
+	socket = strings.TrimSpace(socket)
+	if socket == "" {
+		t.Fatal("SSH_AUTH_SOCK is not set in the session")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to connect to the agent socket: %v", err)
+	}
+	keys, err := agent.NewClient(conn).List()
+	conn.Close()
+	if err != nil || len(keys) != 1 || keys[0].Comment != "forwarded" {
+		t.Errorf("forwarded agent keys = %v, %v, want the client's key", keys, err)
+	}
+
+	stdin.Close()
+	session.Wait()
+	for i := 0; ; i++ {
+		if _, err := os.Stat(socket); os.IsNotExist(err) {
+			break
+		}
+		if i == 50 {
+			t.Fatal("the agent socket should be removed when the session ends")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// no-agent-forwarding refuses the request
+	limited := dialTestServer(t, addr, "bob", restricted)
+	defer limited.Close()
+	other, err := limited.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer other.Close()
+	if err := agent.RequestAgentForwarding(other); err == nil {
+		t.Error("agent forwarding should be denied to a no-agent-forwarding key")
+	}
+}
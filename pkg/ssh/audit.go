@@ -0,0 +1,149 @@
+package ssh
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuditEvent is a single entry of the audit log, written as one JSON line
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// Event is one of auth_success, auth_failure, connection_rejected,
+	// client_version_rejected, session_open, session_close, exec, sftp,
+	// quota_exceeded, keepalive_timeout and disconnect, or for a honeypot
+	// also honeypot_auth, forward and subsystem
+	Event       string `json:"event"`
+	User        string `json:"user,omitempty"`
+	RemoteAddr  string `json:"remote_addr,omitempty"`
+	Country     string `json:"country,omitempty"`
+	ConnID      string `json:"conn_id,omitempty"`
+	Method      string `json:"method,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	CertKeyID   string `json:"cert_key_id,omitempty"`
+	Command     string `json:"command,omitempty"`
+	Operation   string `json:"operation,omitempty"`
+	Path        string `json:"path,omitempty"`
+	Target      string `json:"target,omitempty"`
+	Duration    string `json:"duration,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Password and PublicKey are only recorded by honeypots, ClientVersion
+	// by honeypots and client_version_rejected events
+	Password      string `json:"password,omitempty"`
+	PublicKey     string `json:"public_key,omitempty"`
+	ClientVersion string `json:"client_version,omitempty"`
+}
+
+// auditLog writes audit events to a writer. A nil *auditLog discards events,
+// so callers never need to check whether auditing is enabled.
+type auditLog struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	logger *slog.Logger
+	// countries adds the client's country to events, nil to leave it out
+	countries CountryResolver
+}
+
+// newAuditLog returns an audit log writing to w, or nil when w is nil.
+// Write failures are reported to logger.
+func newAuditLog(w io.Writer, countries CountryResolver, logger *slog.Logger) *auditLog {
+	if w == nil {
+		return nil
+	}
+	return &auditLog{enc: json.NewEncoder(w), logger: logger, countries: countries}
+}
+
+// record stamps and writes an event
+func (a *auditLog) record(event AuditEvent) {
+	if a == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if a.countries != nil && event.Country == "" {
+		if ip := hostIP(event.RemoteAddr); ip != nil {
+			if country := lookupCountry(a.countries, ip); country != UnknownCountry {
+				event.Country = country
+			}
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(event); err != nil {
+		a.logger.Error("could not write audit event", "event", event.Event, "error", err)
+	}
+}
+
+// connEvent starts an event describing the client behind conn
+func connEvent(conn ssh.ConnMetadata, name string) AuditEvent {
+	event := AuditEvent{
+		Event:      name,
+		User:       conn.User(),
+		RemoteAddr: conn.RemoteAddr().String(),
+	}
+	event.ConnID = sessionID(conn)
+	if serverConn, ok := conn.(*ssh.ServerConn); ok && serverConn.Permissions != nil {
+		event.Fingerprint = serverConn.Permissions.Extensions["pubkey-fp"]
+		event.CertKeyID = serverConn.Permissions.Extensions["cert-key-id"]
+	}
+	return event
+}
+
+// sessionID shortens the SSH session identifier of conn to 16 hex digits
+func sessionID(conn ssh.ConnMetadata) string {
+	id := conn.SessionID()
+	if len(id) < 8 {
+		return ""
+	}
+	return hex.EncodeToString(id[:8])
+}
+
+// instrumentAuth records failed authentication attempts made against config.
+// Public key failures are recorded by a wrapper so that the offered key's
+// fingerprint is known; other methods are recorded by AuthLogCallback.
+func (a *auditLog) instrumentAuth(config *ssh.ServerConfig) {
+	if a == nil {
+		return
+	}
+
+	if next := config.PublicKeyCallback; next != nil {
+		config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			perms, err := next(c, key)
+			if err != nil && !isPartialSuccess(err) {
+				event := connEvent(c, "auth_failure")
+				event.Method = "publickey"
+				event.Fingerprint = ssh.FingerprintSHA256(key)
+				if cert, ok := key.(*ssh.Certificate); ok {
+					event.Fingerprint = ssh.FingerprintSHA256(cert.Key)
+					event.CertKeyID = cert.KeyId
+				}
+				event.Error = err.Error()
+				a.record(event)
+			}
+			return perms, err
+		}
+	}
+
+	config.AuthLogCallback = func(c ssh.ConnMetadata, method string, err error) {
+		if err == nil || method == "none" || method == "publickey" || isPartialSuccess(err) {
+			return
+		}
+		event := connEvent(c, "auth_failure")
+		event.Method = method
+		event.Error = err.Error()
+		a.record(event)
+	}
+}
+
+// isPartialSuccess reports whether err only means another factor is required
+func isPartialSuccess(err error) bool {
+	var partial *ssh.PartialSuccessError
+	return errors.As(err, &partial)
+}
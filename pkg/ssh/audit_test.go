@@ -0,0 +1,131 @@
+// pkg/ssh/audit_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// lockedBuffer is a bytes.Buffer safe to read while the server writes to it
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// events decodes the audit events written so far
+func (b *lockedBuffer) events(t *testing.T) []AuditEvent {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var events []AuditEvent
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var event AuditEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("audit line %q is not JSON: %v", line, err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// waitForEvent polls the audit log until an event matching match appears
+func waitForEvent(t *testing.T, log *lockedBuffer, match func(AuditEvent) bool) AuditEvent {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, event := range log.events(t) {
+			if match(event) {
+				return event
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("no matching audit event in %+v", log.events(t))
+	return AuditEvent{}
+}
+
+func TestServerAuditLog(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit := &lockedBuffer{}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{AuditLog: audit})
+
+	_, stranger := newTestKeyPair(t)
+	if client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "mallory",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(stranger)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	}); err == nil {
+		client.Close()
+		t.Fatal("unknown key should be rejected")
+	}
+	failure := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "auth_failure" })
+	if failure.User != "mallory" || failure.Method != "publickey" || failure.Fingerprint != ssh.FingerprintSHA256(stranger.PublicKey()) {
+		t.Errorf("auth_failure = %+v, want mallory's publickey attempt with its fingerprint", failure)
+	}
+	if failure.RemoteAddr == "" || failure.Time.IsZero() {
+		t.Errorf("auth_failure = %+v, want a source address and timestamp", failure)
+	}
+
+	client := dialTestServer(t, addr, "alice", signer)
+	runTestCommand(t, client, "whoami")
+	client.Close()
+
+	fp := ssh.FingerprintSHA256(signer.PublicKey())
+	for _, name := range []string{"auth_success", "session_open", "exec", "session_close", "disconnect"} {
+		event := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == name && e.User == "alice" })
+		if event.Fingerprint != fp {
+			t.Errorf("%s fingerprint = %q, want %q", name, event.Fingerprint, fp)
+		}
+		if event.ConnID == "" {
+			t.Errorf("%s should carry a connection ID", name)
+		}
+	}
+	exec := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "exec" })
+	if exec.Command != "whoami" {
+		t.Errorf("exec command = %q, want %q", exec.Command, "whoami")
+	}
+}
+
+func TestSFTPAuditLog(t *testing.T) {
+	audit := &lockedBuffer{}
+	root := t.TempDir()
+	client := newTestSFTPClient(t, ServerOptions{SFTP: true, SFTPRoot: root, AuditLog: audit})
+
+	f, err := client.Create("/report.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("data"))
+	f.Close()
+	if err := client.Rename("/report.txt", "/final.txt"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	upload := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "sftp" && e.Operation == "upload" })
+	if upload.Path != "/report.txt" || upload.User != "alice" {
+		t.Errorf("upload event = %+v", upload)
+	}
+	rename := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "sftp" && e.Operation == "rename" })
+	if rename.Path != "/report.txt" || rename.Target != "/final.txt" {
+		t.Errorf("rename event = %+v", rename)
+	}
+}
@@ -0,0 +1,97 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// httpAuthTimeout bounds a callout when HTTPAuthenticator.Client is nil
+const httpAuthTimeout = 10 * time.Second
+
+// HTTPAuthenticator asks an HTTP endpoint whether a public key may log in.
+// The endpoint receives a POST with a JSON object:
+//
+//	{"user": "alice", "fingerprint": "SHA256:...", "public_key": "ssh-ed25519 AAAA...", "remote_addr": "203.0.113.7:51234"}
+//
+// and answers 200 with {"allow": true} or {"allow": false}. An allowed key
+// may carry authorized_keys options, e.g. {"allow": true, "options":
+// ["no-pty", "command=\"backup\""]}. Any other answer rejects the key.
+type HTTPAuthenticator struct {
+	// URL is the endpoint to POST to
+	URL string
+	// Token, when set, is sent as a bearer token in the Authorization header
+	Token string
+	// Client sends the requests; nil uses a client with a 10 second timeout
+	Client *http.Client
+}
+
+// httpAuthRequest is the body POSTed to the endpoint
+type httpAuthRequest struct {
+	User        string `json:"user"`
+	Fingerprint string `json:"fingerprint"`
+	PublicKey   string `json:"public_key"`
+	RemoteAddr  string `json:"remote_addr"`
+}
+
+// httpAuthResponse is the endpoint's decision
+type httpAuthResponse struct {
+	Allow   bool     `json:"allow"`
+	Options []string `json:"options"`
+}
+
+// Authenticate asks the endpoint about key
+func (h *HTTPAuthenticator) Authenticate(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	body, err := json.Marshal(httpAuthRequest{
+		User:        c.User(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		PublicKey:   strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))),
+		RemoteAddr:  c.RemoteAddr().String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("auth callout: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: httpAuthTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("auth callout: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("auth callout: %s returned %s", h.URL, resp.Status)
+	}
+
+	var decision httpAuthResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("auth callout: invalid response: %s", err)
+	}
+	if !decision.Allow {
+		return nil, fmt.Errorf("public key for %q denied by %s", c.User(), h.URL)
+	}
+	options, err := parseKeyOptions(decision.Options)
+	if err != nil {
+		return nil, fmt.Errorf("auth callout: %s", err)
+	}
+	perms, err := options.permissions(c, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("public key for %q rejected: %s", c.User(), err)
+	}
+	return perms, nil
+}
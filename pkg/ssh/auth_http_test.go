@@ -0,0 +1,59 @@
+// pkg/ssh/auth_http_test.go
+package ssh
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHTTPAuthenticator(t *testing.T) {
+	_, signer := newTestKeyPair(t)
+	fingerprint := ssh.FingerprintSHA256(signer.PublicKey())
+
+	var got httpAuthRequest
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		switch got.User {
+		case "alice":
+			w.Write([]byte(`{"allow": true, "options": ["no-pty", "command=\"backup\""]}`))
+		case "bob":
+			w.Write([]byte(`{"allow": false}`))
+		case "carol":
+			w.Write([]byte(`{"allow": true, "options": ["bogus"]}`))
+		default:
+			w.Write([]byte(`not json`))
+		}
+	}))
+	defer endpoint.Close()
+
+	auth := &HTTPAuthenticator{URL: endpoint.URL, Token: "s3cret"}
+	perms, err := auth.Authenticate(&mockSSHConn{user: "alice"}, signer.PublicKey())
+	if err != nil {
+		t.Fatalf("Authenticate(alice) error = %v", err)
+	}
+	if got.Fingerprint != fingerprint || got.RemoteAddr != "127.0.0.1:22" || got.PublicKey == "" {
+		t.Errorf("request = %+v, want the key and client address", got)
+	}
+	if _, ok := perms.Extensions["permit-pty"]; ok || perms.CriticalOptions["force-command"] != "backup" {
+		t.Errorf("permissions = %+v, want the options from the response", perms)
+	}
+
+	for _, user := range []string{"bob", "carol", "dave"} {
+		if _, err := auth.Authenticate(&mockSSHConn{user: user}, signer.PublicKey()); err == nil || errors.Is(err, ErrUnknownKey) {
+			t.Errorf("Authenticate(%s) error = %v, want a rejection", user, err)
+		}
+	}
+	wrongToken := &HTTPAuthenticator{URL: endpoint.URL, Token: "wrong"}
+	if _, err := wrongToken.Authenticate(&mockSSHConn{user: "alice"}, signer.PublicKey()); err == nil {
+		t.Error("a failed callout should reject the key")
+	}
+}
@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultLDAPFilter finds the entry of a POSIX account; %u is the user
+	DefaultLDAPFilter = "(&(objectClass=posixAccount)(uid=%u))"
+	// DefaultLDAPKeyAttribute is the attribute of the openssh-lpk schema
+	// holding a user's public keys
+	DefaultLDAPKeyAttribute = "sshPublicKey"
+	// ldapTimeout bounds a lookup when LDAPAuthenticator.Timeout is zero
+	ldapTimeout = 10 * time.Second
+)
+
+// LDAPAuthenticator accepts the public keys stored in a user's LDAP entry,
+// one authorized_keys line per attribute value, options included
+type LDAPAuthenticator struct {
+	// URL is the directory server, e.g. ldaps://ldap.example.com
+	URL string
+	// BindDN and BindPassword authenticate the lookup; empty binds anonymously
+	BindDN       string
+	BindPassword string
+	// BaseDN is where the search for user entries starts
+	BaseDN string
+	// Filter selects the user's entry, with %u replaced by the escaped user
+	// name (default DefaultLDAPFilter)
+	Filter string
+	// KeyAttribute holds the public keys (default DefaultLDAPKeyAttribute)
+	KeyAttribute string
+	// StartTLS upgrades ldap:// connections to TLS before binding
+	StartTLS bool
+	// TLSConfig configures ldaps:// and StartTLS; nil uses the defaults
+	TLSConfig *tls.Config
+	// Timeout bounds each lookup (default 10 seconds)
+	Timeout time.Duration
+}
+
+// Authenticate looks up the user's entry and checks key against its keys
+func (l *LDAPAuthenticator) Authenticate(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	values, err := l.lookupKeys(c.User())
+	if err != nil {
+		return nil, err
+	}
+	for _, value := range values {
+		keys, err := parseAuthorizedKeys([]byte(value), connLogger(slog.Default(), c))
+		if err != nil {
+			continue
+		}
+		if entry, ok := keys[string(key.Marshal())]; ok {
+			perms, err := entry.options.permissions(c, time.Now())
+			if err != nil {
+				return nil, fmt.Errorf("public key for %q rejected: %s", c.User(), err)
+			}
+			return perms, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+// lookupKeys returns the key attribute values of the user's entry
+func (l *LDAPAuthenticator) lookupKeys(user string) ([]string, error) {
+	timeout := l.Timeout
+	if timeout == 0 {
+		timeout = ldapTimeout
+	}
+	conn, err := ldap.DialURL(l.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(l.TLSConfig))
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %s", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(timeout)
+
+	if l.StartTLS {
+		config := l.TLSConfig
+		if config == nil {
+			u, err := url.Parse(l.URL)
+			if err != nil {
+				return nil, fmt.Errorf("ldap: %s", err)
+			}
+			config = &tls.Config{ServerName: u.Hostname()}
+		}
+		if err := conn.StartTLS(config); err != nil {
+			return nil, fmt.Errorf("ldap: %s", err)
+		}
+	}
+	if l.BindDN != "" {
+		if err := conn.Bind(l.BindDN, l.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap: %s", err)
+		}
+	}
+
+	filter := l.Filter
+	if filter == "" {
+		filter = DefaultLDAPFilter
+	}
+	attribute := l.KeyAttribute
+	if attribute == "" {
+		attribute = DefaultLDAPKeyAttribute
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		l.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(timeout.Seconds()), false,
+		strings.ReplaceAll(filter, "%u", ldap.EscapeFilter(user)), []string{attribute}, nil))
+	if err != nil && (result == nil || !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded)) {
+		return nil, fmt.Errorf("ldap: %s", err)
+	}
+	switch {
+	case len(result.Entries) == 0:
+		return nil, fmt.Errorf("%w: no LDAP entry for %q", ErrUnknownKey, user)
+	case len(result.Entries) > 1:
+		return nil, errors.New("ldap: more than one entry matches " + user)
+	}
+	return result.Entries[0].GetAttributeValues(attribute), nil
+}
@@ -0,0 +1,140 @@
+// pkg/ssh/auth_ldap_test.go
+package ssh
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeLDAP serves simple binds and searches from a map of user names to
+// sshPublicKey values, enough for LDAPAuthenticator
+type fakeLDAP struct {
+	listener net.Listener
+	password string
+	keys     map[string][]string
+}
+
+func startFakeLDAP(t *testing.T, password string, keys map[string][]string) *fakeLDAP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	f := &fakeLDAP{listener: listener, password: password, keys: keys}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeLDAP) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id := packet.Children[0].Value
+		op := packet.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := ldap.LDAPResultSuccess
+			if op.Children[2].Data.String() != f.password {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			for user, values := range f.keys {
+				// A presence filter, as an unescaped "*" would give, matches everyone
+				if !strings.Contains(filter, "(uid="+user+")") && !strings.Contains(filter, "(uid=*)") {
+					continue
+				}
+				entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "entry")
+				entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "uid="+user+",dc=example", "dn"))
+				attrs := ber.NewSequence("attributes")
+				attr := ber.NewSequence("attribute")
+				attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "sshPublicKey", "type"))
+				set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "values")
+				for _, value := range values {
+					set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "value"))
+				}
+				attr.AppendChild(set)
+				attrs.AppendChild(attr)
+				entry.AppendChild(attrs)
+				conn.Write(ldapMessage(id, entry).Bytes())
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+// ldapMessage wraps a protocol operation in an LDAPMessage
+func ldapMessage(id any, op *ber.Packet) *ber.Packet {
+	msg := ber.NewSequence("message")
+	msg.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "id"))
+	msg.AppendChild(op)
+	return msg
+}
+
+// ldapResult builds a response carrying only a result code
+func ldapResult(id any, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "result")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "code"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matched"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "message"))
+	return ldapMessage(id, op)
+}
+
+func TestLDAPAuthenticator(t *testing.T) {
+	_, aliceKey := newTestKeyPair(t)
+	_, otherKey := newTestKeyPair(t)
+	directory := startFakeLDAP(t, "bindpw", map[string][]string{
+		"alice": {"garbage", `no-port-forwarding ` + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(aliceKey.PublicKey())))},
+	})
+	auth := &LDAPAuthenticator{
+		URL:          "ldap://" + directory.listener.Addr().String(),
+		BindDN:       "cn=gossh,dc=example",
+		BindPassword: "bindpw",
+		BaseDN:       "dc=example",
+	}
+
+	perms, err := auth.Authenticate(&mockSSHConn{user: "alice"}, aliceKey.PublicKey())
+	if err != nil {
+		t.Fatalf("Authenticate(alice) error = %v", err)
+	}
+	if _, ok := perms.Extensions["permit-port-forwarding"]; ok {
+		t.Error("no-port-forwarding option from the directory was not applied")
+	}
+	if _, err := auth.Authenticate(&mockSSHConn{user: "alice"}, otherKey.PublicKey()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("another key for alice: error = %v, want ErrUnknownKey", err)
+	}
+	if _, err := auth.Authenticate(&mockSSHConn{user: "bob"}, aliceKey.PublicKey()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("user without an entry: error = %v, want ErrUnknownKey", err)
+	}
+	// Filter metacharacters in user names cannot widen the search
+	if _, err := auth.Authenticate(&mockSSHConn{user: "*"}, aliceKey.PublicKey()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("wildcard user: error = %v, want ErrUnknownKey", err)
+	}
+
+	wrongPassword := *auth
+	wrongPassword.BindPassword = "nope"
+	if _, err := wrongPassword.Authenticate(&mockSSHConn{user: "alice"}, aliceKey.PublicKey()); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("failed bind: error = %v, want a rejection", err)
+	}
+}
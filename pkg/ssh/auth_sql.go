@@ -0,0 +1,180 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultSQLKeyTable is the table SQLAuthenticator reads keys from
+const DefaultSQLKeyTable = "authorized_keys"
+
+// sqlTableName restricts table names, which cannot be query parameters
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQLAuthenticator accepts the public keys stored in a database table, so a
+// fleet of servers can share one centrally managed set of keys. The table
+// has one row per key:
+//
+//	CREATE TABLE authorized_keys (
+//		"user"  TEXT NOT NULL,  -- login name
+//		key     TEXT NOT NULL,  -- e.g. ssh-ed25519 AAAA... alice@laptop
+//		options TEXT,           -- authorized_keys options, e.g. no-pty,from="10.0.0.0/8"
+//		expiry  TIMESTAMP       -- NULL for keys that never expire
+//	);
+//
+// The whole table is held in memory and re-read by Refresh, so logins do
+// not wait on the database and keep working while it is unreachable.
+type SQLAuthenticator struct {
+	db    *sql.DB
+	query string
+	// keys maps a user to their keys; it is swapped atomically by Refresh
+	keys   atomic.Pointer[map[string]map[string]authorizedKey]
+	logger *slog.Logger
+}
+
+// NewSQLAuthenticator creates an Authenticator for the keys in table (default
+// DefaultSQLKeyTable) of db and loads them. Rows that do not parse are
+// logged and skipped.
+func NewSQLAuthenticator(ctx context.Context, db *sql.DB, table string) (*SQLAuthenticator, error) {
+	if table == "" {
+		table = DefaultSQLKeyTable
+	}
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid key table name %q", table)
+	}
+	a := &SQLAuthenticator{
+		db:     db,
+		query:  `SELECT "user", key, options, expiry FROM ` + table,
+		logger: slog.Default(),
+	}
+	if err := a.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Refresh re-reads the key table. On an error the current keys are kept.
+func (a *SQLAuthenticator) Refresh(ctx context.Context) error {
+	rows, err := a.db.QueryContext(ctx, a.query)
+	if err != nil {
+		return fmt.Errorf("read authorized keys: %s", err)
+	}
+	defer rows.Close()
+
+	keys := map[string]map[string]authorizedKey{}
+	for rows.Next() {
+		var user, key string
+		var options sql.NullString
+		var expiry any
+		if err := rows.Scan(&user, &key, &options, &expiry); err != nil {
+			return fmt.Errorf("read authorized keys: %s", err)
+		}
+		entry, err := parseSQLKey(key, options.String, expiry)
+		if err != nil {
+			a.logger.Warn("Skipping authorized key", "user", user, "error", err)
+			continue
+		}
+		if keys[user] == nil {
+			keys[user] = map[string]authorizedKey{}
+		}
+		keys[user][string(entry.key.Marshal())] = entry
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read authorized keys: %s", err)
+	}
+	a.keys.Store(&keys)
+	return nil
+}
+
+// Watch refreshes the keys every interval until ctx is done, logging failed
+// refreshes and keeping the previous keys
+func (a *SQLAuthenticator) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Refresh(ctx); err != nil {
+				a.logger.Error("Failed to refresh authorized keys", "error", err)
+			}
+		}
+	}
+}
+
+// Authenticate looks key up among the user's rows
+func (a *SQLAuthenticator) Authenticate(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	entry, ok := (*a.keys.Load())[c.User()][string(key.Marshal())]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	perms, err := entry.options.permissions(c, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("public key for %q rejected: %s", c.User(), err)
+	}
+	return perms, nil
+}
+
+// parseSQLKey parses the key, options and expiry columns of a row
+func parseSQLKey(key, options string, expiry any) (authorizedKey, error) {
+	line := strings.TrimSpace(key)
+	if options = strings.TrimSpace(options); options != "" {
+		line = options + " " + line
+	}
+	pubKey, _, rawOptions, rest, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return authorizedKey{}, fmt.Errorf("parse authorized key: %s", err)
+	}
+	if len(bytes.TrimSpace(rest)) > 0 {
+		return authorizedKey{}, errors.New("more than one key in a row")
+	}
+	opts, err := parseKeyOptions(rawOptions)
+	if err != nil {
+		return authorizedKey{}, fmt.Errorf("authorized key %s: %s", ssh.FingerprintSHA256(pubKey), err)
+	}
+	expires, err := parseSQLExpiry(expiry)
+	if err != nil {
+		return authorizedKey{}, err
+	}
+	if !expires.IsZero() && (opts.expiry.IsZero() || expires.Before(opts.expiry)) {
+		opts.expiry = expires
+	}
+	return authorizedKey{key: pubKey, options: opts}, nil
+}
+
+// parseSQLExpiry converts an expiry column, which drivers return as a
+// time.Time, a string or a Unix time depending on the column type
+func parseSQLExpiry(value any) (time.Time, error) {
+	switch v := value.(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v, nil
+	case int64:
+		return time.Unix(v, 0), nil
+	case []byte:
+		return parseSQLExpiry(string(v))
+	case string:
+		if v == "" {
+			return time.Time{}, nil
+		}
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05Z07:00", "2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.ParseInLocation(layout, v, time.UTC); err == nil {
+				return t, nil
+			}
+		}
+		return parseExpiryTime(v)
+	}
+	return time.Time{}, fmt.Errorf("unsupported expiry value %v", value)
+}
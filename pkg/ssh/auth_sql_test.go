@@ -0,0 +1,116 @@
+// pkg/ssh/auth_sql_test.go
+package ssh
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	_ "modernc.org/sqlite"
+)
+
+func TestSQLAuthenticator(t *testing.T) {
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "keys.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`CREATE TABLE authorized_keys ("user" TEXT NOT NULL, key TEXT NOT NULL, options TEXT, expiry TIMESTAMP)`); err != nil {
+		t.Fatalf("Failed to create table: %v", err)
+	}
+
+	_, alice := newTestKeyPair(t)
+	_, bob := newTestKeyPair(t)
+	_, carol := newTestKeyPair(t)
+	line := func(signer ssh.Signer) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	}
+	insert := func(user, key string, options, expiry any) {
+		t.Helper()
+		if _, err := db.Exec(`INSERT INTO authorized_keys VALUES (?, ?, ?, ?)`, user, key, options, expiry); err != nil {
+			t.Fatalf("Failed to insert key: %v", err)
+		}
+	}
+	insert("alice", line(alice)+" alice@laptop", `no-pty,command="backup --all"`, nil)
+	insert("bob", line(bob), nil, time.Now().Add(-time.Hour))
+	insert("carol", "not a key", nil, nil)
+	insert("carol", line(carol), "bogus", nil)
+
+	auth, err := NewSQLAuthenticator(context.Background(), db, "")
+	if err != nil {
+		t.Fatalf("NewSQLAuthenticator error = %v", err)
+	}
+
+	perms, err := auth.Authenticate(&mockSSHConn{user: "alice"}, alice.PublicKey())
+	if err != nil {
+		t.Fatalf("Authenticate(alice) error = %v", err)
+	}
+	if _, ok := perms.Extensions["permit-pty"]; ok || perms.CriticalOptions["force-command"] != "backup --all" {
+		t.Errorf("permissions = %+v, want the options of the row", perms)
+	}
+	if _, err := auth.Authenticate(&mockSSHConn{user: "bob"}, alice.PublicKey()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("alice's key for bob error = %v, want ErrUnknownKey", err)
+	}
+	if _, err := auth.Authenticate(&mockSSHConn{user: "bob"}, bob.PublicKey()); err == nil || errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expired key error = %v, want a rejection", err)
+	}
+	if _, err := auth.Authenticate(&mockSSHConn{user: "carol"}, carol.PublicKey()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("key with invalid options error = %v, want it skipped", err)
+	}
+
+	// Refresh picks up new and deleted rows
+	insert("bob", line(bob), "restrict", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	db.Exec(`DELETE FROM authorized_keys WHERE "user" = 'alice'`)
+	if err := auth.Refresh(context.Background()); err != nil {
+		t.Fatalf("Refresh error = %v", err)
+	}
+	if _, err := auth.Authenticate(&mockSSHConn{user: "bob"}, bob.PublicKey()); err != nil {
+		t.Errorf("Authenticate(bob) after refresh error = %v", err)
+	}
+	if _, err := auth.Authenticate(&mockSSHConn{user: "alice"}, alice.PublicKey()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("deleted key error = %v, want ErrUnknownKey", err)
+	}
+
+	// A failed refresh keeps the keys
+	db.Exec(`DROP TABLE authorized_keys`)
+	if err := auth.Refresh(context.Background()); err == nil {
+		t.Error("Refresh of a dropped table should fail")
+	}
+	if _, err := auth.Authenticate(&mockSSHConn{user: "bob"}, bob.PublicKey()); err != nil {
+		t.Errorf("Authenticate(bob) after a failed refresh error = %v", err)
+	}
+
+	if _, err := NewSQLAuthenticator(context.Background(), db, "keys; DROP TABLE users"); err == nil {
+		t.Error("NewSQLAuthenticator should refuse an invalid table name")
+	}
+}
+
+func TestParseSQLExpiry(t *testing.T) {
+	want := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value   any
+		want    time.Time
+		wantErr bool
+	}{
+		{nil, time.Time{}, false},
+		{"", time.Time{}, false},
+		{want, want, false},
+		{want.Unix(), want, false},
+		{"2030-01-02T03:04:05Z", want, false},
+		{[]byte("2030-01-02 03:04:05"), want, false},
+		{"20300102030405Z", want, false},
+		{"next week", time.Time{}, true},
+		{3.5, time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseSQLExpiry(tt.value)
+		if (err != nil) != tt.wantErr || !got.Equal(tt.want) {
+			t.Errorf("parseSQLExpiry(%v) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Authenticator decides whether a client may log in with a public key
+type Authenticator interface {
+	// Authenticate returns the permissions of a session for conn.User()
+	// authenticated with key. It returns an error wrapping ErrUnknownKey
+	// when it does not know the key, so the next Authenticator is asked, and
+	// any other error to reject the key outright.
+	Authenticate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
+}
+
+// AuthenticatorFunc adapts a plain function to the Authenticator interface
+type AuthenticatorFunc func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
+
+// Authenticate calls f(conn, key)
+func (f AuthenticatorFunc) Authenticate(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	return f(conn, key)
+}
+
+// authenticateKey asks each authenticator in turn about key until one
+// accepts or rejects it
+func authenticateKey(authenticators []Authenticator, c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	for _, authenticator := range authenticators {
+		perms, err := authenticator.Authenticate(c, key)
+		if err == nil {
+			if perms == nil {
+				perms = newPermissions()
+			}
+			return perms, nil
+		}
+		if errors.Is(err, ErrUnauthorizedKey) {
+			return nil, err
+		}
+		if !errors.Is(err, ErrUnknownKey) {
+			return nil, fmt.Errorf("%w: %w", ErrUnauthorizedKey, err)
+		}
+	}
+	return nil, fmt.Errorf("%w: %w for %q", ErrUnauthorizedKey, ErrUnknownKey, c.User())
+}
+
+// AuthorizedKeysAuthenticator accepts the keys of an authorized_keys file,
+// with their options, and of per-user files in a directory
+type AuthorizedKeysAuthenticator struct {
+	// keys is swapped atomically by Reload
+	keys atomic.Pointer[map[string]authorizedKey]
+	// dir holds one authorized_keys file per user; empty disables it
+	dir    string
+	logger *slog.Logger
+}
+
+// NewAuthorizedKeysAuthenticator creates an Authenticator for the keys in
+// authorizedKeys, which may be empty, and in dir/<user> when dir is set
+func NewAuthorizedKeysAuthenticator(authorizedKeys []byte, dir string) (*AuthorizedKeysAuthenticator, error) {
+	a := &AuthorizedKeysAuthenticator{dir: dir, logger: slog.Default()}
+	if err := a.Reload(authorizedKeys); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload replaces the shared keys with the parsed contents of
+// authorizedKeys. On a parse error the current keys are kept.
+func (a *AuthorizedKeysAuthenticator) Reload(authorizedKeys []byte) error {
+	keys, err := parseAuthorizedKeys(authorizedKeys, a.logger)
+	if err != nil {
+		return err
+	}
+	a.keys.Store(&keys)
+	return nil
+}
+
+// Authenticate looks key up in the shared keys, then in the user's own file
+func (a *AuthorizedKeysAuthenticator) Authenticate(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	entry, ok := (*a.keys.Load())[string(key.Marshal())]
+	if !ok && a.dir != "" {
+		entry, ok = lookupUserKey(a.dir, c.User(), key, connLogger(a.logger, c))
+	}
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	perms, err := entry.options.permissions(c, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("%w: public key for %q rejected: %s", ErrUnauthorizedKey, c.User(), err)
+	}
+	return perms, nil
+}
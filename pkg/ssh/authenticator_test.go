@@ -0,0 +1,121 @@
+// pkg/ssh/authenticator_test.go
+package ssh
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthenticateKey(t *testing.T) {
+	_, signer := newTestKeyPair(t)
+	key := signer.PublicKey()
+	conn := &mockSSHConn{user: "alice"}
+
+	unknown := AuthenticatorFunc(func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+		return nil, ErrUnknownKey
+	})
+	accept := AuthenticatorFunc(func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+		return &ssh.Permissions{Extensions: map[string]string{"source": "accept"}}, nil
+	})
+	reject := AuthenticatorFunc(func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+		return nil, errors.New("revoked")
+	})
+
+	tests := []struct {
+		name           string
+		authenticators []Authenticator
+		wantErr        bool
+		wantUnknown    bool
+	}{
+		{"none", nil, true, true},
+		{"unknown then accept", []Authenticator{unknown, accept}, false, false},
+		{"reject stops the chain", []Authenticator{reject, accept}, true, false},
+		{"all unknown", []Authenticator{unknown, unknown}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			perms, err := authenticateKey(tt.authenticators, conn, key)
+			if (err != nil) != tt.wantErr || errors.Is(err, ErrUnknownKey) != tt.wantUnknown {
+				t.Fatalf("authenticateKey() error = %v, wantErr %v, wantUnknown %v", err, tt.wantErr, tt.wantUnknown)
+			}
+			if err == nil && perms.Extensions["source"] != "accept" {
+				t.Errorf("authenticateKey() permissions = %v, want those of the accepting authenticator", perms)
+			}
+		})
+	}
+}
+
+func TestAuthorizedKeysAuthenticator(t *testing.T) {
+	_, shared := newTestKeyPair(t)
+	_, own := newTestKeyPair(t)
+	_, stranger := newTestKeyPair(t)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "alice"), []byte("no-pty "+string(ssh.MarshalAuthorizedKey(own.PublicKey()))), 0o600)
+
+	keys, err := NewAuthorizedKeysAuthenticator(ssh.MarshalAuthorizedKey(shared.PublicKey()), dir)
+	if err != nil {
+		t.Fatalf("NewAuthorizedKeysAuthenticator() error = %v", err)
+	}
+	alice := &mockSSHConn{user: "alice"}
+	if _, err := keys.Authenticate(alice, shared.PublicKey()); err != nil {
+		t.Errorf("shared key rejected: %v", err)
+	}
+	perms, err := keys.Authenticate(alice, own.PublicKey())
+	if err != nil {
+		t.Fatalf("per-user key rejected: %v", err)
+	}
+	if _, ok := perms.Extensions["permit-pty"]; ok {
+		t.Error("no-pty option of the per-user key was not applied")
+	}
+	if _, err := keys.Authenticate(&mockSSHConn{user: "bob"}, own.PublicKey()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("alice's key for bob: error = %v, want ErrUnknownKey", err)
+	}
+	if _, err := keys.Authenticate(alice, stranger.PublicKey()); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key: error = %v, want ErrUnknownKey", err)
+	}
+
+	if err := keys.Reload([]byte("not a key")); err == nil {
+		t.Error("Reload should reject invalid data")
+	}
+	if _, err := keys.Authenticate(alice, shared.PublicKey()); err != nil {
+		t.Errorf("a failed reload should keep the current keys: %v", err)
+	}
+}
+
+func TestServerAuthenticators(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, backendSigner := newTestKeyPair(t)
+	backendKey := string(backendSigner.PublicKey().Marshal())
+	backend := AuthenticatorFunc(func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if c.User() == "bob" && string(key.Marshal()) == backendKey {
+			return newPermissions(), nil
+		}
+		return nil, ErrUnknownKey
+	})
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Authenticators: []Authenticator{backend}})
+
+	// The authorized keys still work, and the backend adds its own keys
+	client := dialTestServer(t, addr, "alice", signer)
+	client.Close()
+	client = dialTestServer(t, addr, "bob", backendSigner)
+	if out := runTestCommand(t, client, "whoami"); out != "You are: bob\n" {
+		t.Errorf("whoami = %q", out)
+	}
+	client.Close()
+
+	if _, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "carol",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(backendSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}); err == nil {
+		t.Error("the backend's key should only authenticate bob")
+	}
+}
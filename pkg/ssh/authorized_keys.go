@@ -0,0 +1,283 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keyOptions holds the restrictions set by the options field of an
+// authorized_keys entry (see sshd(8), AUTHORIZED_KEYS FILE FORMAT)
+type keyOptions struct {
+	// command is run instead of whatever the client asks for
+	command string
+	// from lists the source address patterns the key may be used from
+	from []string
+	// expiry is the time after which the key is no longer accepted
+	expiry time.Time
+	// denied lists the permission extensions removed by no-* options
+	denied []string
+	// gitAccess is the git-access= option, a gossh extension limiting which
+	// repositories under GitRoot the key may read or write
+	gitAccess *string
+	// subsystems is the subsystems= option, a gossh extension limiting which
+	// subsystems the key may open
+	subsystems *string
+}
+
+// keyOptionDenials maps the no-* options to the permissions they remove
+var keyOptionDenials = map[string]string{
+	"no-pty":              "permit-pty",
+	"no-port-forwarding":  "permit-port-forwarding",
+	"no-agent-forwarding": "permit-agent-forwarding",
+	"no-x11-forwarding":   "permit-X11-forwarding",
+	"no-user-rc":          "permit-user-rc",
+}
+
+// authorizedKey is a parsed authorized_keys entry
+type authorizedKey struct {
+	key     ssh.PublicKey
+	options keyOptions
+}
+
+// keyOptionGrants maps the options that undo restrict, such as pty, to the
+// permissions they give back
+var keyOptionGrants = map[string]string{
+	"pty":              "permit-pty",
+	"port-forwarding":  "permit-port-forwarding",
+	"agent-forwarding": "permit-agent-forwarding",
+	"x11-forwarding":   "permit-X11-forwarding",
+	"user-rc":          "permit-user-rc",
+}
+
+// parseAuthorizedKeys parses an authorized_keys file into entries keyed by
+// the marshaled public key. Entries with options that cannot be enforced are
+// logged and skipped, like sshd does, so one bad line does not lock out
+// every other key.
+func parseAuthorizedKeys(data []byte, logger *slog.Logger) (map[string]authorizedKey, error) {
+	keys := map[string]authorizedKey{}
+	for len(data) > 0 {
+		pubKey, comment, options, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse authorized keys error: %s", err)
+		}
+		data = rest
+		opts, err := parseKeyOptions(options)
+		if err != nil {
+			logger.Warn("Skipping authorized key", "fingerprint", ssh.FingerprintSHA256(pubKey), "comment", comment, "error", err)
+			continue
+		}
+
+		keys[string(pubKey.Marshal())] = authorizedKey{key: pubKey, options: opts}
+	}
+	return keys, nil
+}
+
+// lookupUserKey finds key in the user's own authorized_keys file inside dir,
+// e.g. /etc/gossh/authorized_keys.d/alice
+func lookupUserKey(dir, user string, key ssh.PublicKey, logger *slog.Logger) (authorizedKey, bool) {
+	p, err := userFilePath(dir, user)
+	if err != nil {
+		return authorizedKey{}, false
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger.Error("could not read authorized keys", "error", err)
+		}
+		return authorizedKey{}, false
+	}
+	keys, err := parseAuthorizedKeys(data, logger)
+	if err != nil {
+		logger.Error("invalid authorized keys file", "path", p, "error", err)
+		return authorizedKey{}, false
+	}
+	entry, ok := keys[string(key.Marshal())]
+	return entry, ok
+}
+
+// userFilePath returns the path of the file named after user inside dir,
+// refusing user names that are not a single path element
+func userFilePath(dir, user string) (string, error) {
+	if user == "" || user != filepath.Base(user) || user == "." || user == ".." {
+		return "", fmt.Errorf("invalid user name %q", user)
+	}
+	return filepath.Join(dir, user), nil
+}
+
+// parseKeyOptions parses the options of an authorized_keys entry in order,
+// so that pty after restrict gives the pty back. Unknown options are an
+// error so that a restriction is never silently ignored.
+func parseKeyOptions(options []string) (keyOptions, error) {
+	var opts keyOptions
+	for _, option := range options {
+		name, value, hasValue := strings.Cut(option, "=")
+		name = strings.ToLower(name)
+		if hasValue {
+			unquoted, err := unquoteOption(value)
+			if err != nil {
+				return opts, fmt.Errorf("option %s: %s", name, err)
+			}
+			value = unquoted
+		}
+
+		switch {
+		case name == "command" && hasValue:
+			opts.command = value
+		case name == "from" && hasValue:
+			opts.from = strings.Split(value, ",")
+		case name == "expiry-time" && hasValue:
+			expiry, err := parseExpiryTime(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.expiry = expiry
+		case name == gitAccessExtension && hasValue:
+			if _, err := parseGitAccess(value); err != nil {
+				return opts, err
+			}
+			opts.gitAccess = &value
+		case name == subsystemsExtension && hasValue:
+			if err := parseSubsystems(value); err != nil {
+				return opts, err
+			}
+			opts.subsystems = &value
+		case name == "restrict" && !hasValue:
+			for _, denied := range keyOptionDenials {
+				opts.denied = append(opts.denied, denied)
+			}
+		case keyOptionDenials[name] != "" && !hasValue:
+			opts.denied = append(opts.denied, keyOptionDenials[name])
+		case keyOptionGrants[name] != "" && !hasValue:
+			opts.denied = slices.DeleteFunc(opts.denied, func(denied string) bool { return denied == keyOptionGrants[name] })
+		default:
+			return opts, fmt.Errorf("unsupported option %q", option)
+		}
+	}
+	return opts, nil
+}
+
+// unquoteOption strips the double quotes around an option value, undoing
+// backslash-escaped quotes inside it
+func unquoteOption(value string) (string, error) {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", fmt.Errorf("value %s is not quoted", value)
+	}
+	return strings.ReplaceAll(value[1:len(value)-1], `\"`, `"`), nil
+}
+
+// parseExpiryTime parses an expiry-time value, YYYYMMDD[HHMM[SS]] in local
+// time or in UTC when suffixed with Z
+func parseExpiryTime(value string) (time.Time, error) {
+	loc := time.Local
+	if strings.HasSuffix(value, "Z") {
+		loc = time.UTC
+		value = strings.TrimSuffix(value, "Z")
+	}
+	layouts := map[int]string{
+		8:  "20060102",
+		12: "200601021504",
+		14: "20060102150405",
+	}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid expiry-time %q", value)
+	}
+	expiry, err := time.ParseInLocation(layout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry-time %q", value)
+	}
+	return expiry, nil
+}
+
+// permissions checks the options against the connecting client and returns
+// the permissions granted to a session authenticated with this key
+func (o keyOptions) permissions(c ssh.ConnMetadata, now time.Time) (*ssh.Permissions, error) {
+	if !o.expiry.IsZero() && now.After(o.expiry) {
+		return nil, fmt.Errorf("key expired at %s", o.expiry.Format(time.RFC3339))
+	}
+	if len(o.from) > 0 && !matchSourceAddress(o.from, c.RemoteAddr()) {
+		return nil, fmt.Errorf("key not allowed from %s", c.RemoteAddr())
+	}
+
+	perms := newPermissions()
+	for _, denied := range o.denied {
+		delete(perms.Extensions, denied)
+	}
+	if o.gitAccess != nil {
+		perms.Extensions[gitAccessExtension] = *o.gitAccess
+	}
+	if o.subsystems != nil {
+		perms.Extensions[subsystemsExtension] = *o.subsystems
+	}
+	if o.command != "" {
+		perms.CriticalOptions = map[string]string{"force-command": o.command}
+	}
+	return perms, nil
+}
+
+// matchSourceAddress reports whether addr matches the from= pattern list.
+// Patterns are IP wildcards or CIDR blocks; a matching pattern prefixed with
+// ! rejects the address even when another pattern allows it.
+func matchSourceAddress(patterns []string, addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		var ok bool
+		if _, network, err := net.ParseCIDR(pattern); err == nil {
+			ok = ip != nil && network.Contains(ip)
+		} else {
+			ok, _ = path.Match(pattern, host)
+		}
+
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// newPermissions returns the permissions of a session without restrictions
+func newPermissions() *ssh.Permissions {
+	extensions := map[string]string{}
+	for name, value := range defaultUserExtensions {
+		extensions[name] = value
+	}
+	return &ssh.Permissions{Extensions: extensions}
+}
+
+// permitted reports whether the connection's authentication granted the
+// named permission extension, such as "permit-pty"
+func permitted(conn *ssh.ServerConn, extension string) bool {
+	if conn.Permissions == nil {
+		return false
+	}
+	_, ok := conn.Permissions.Extensions[extension]
+	return ok
+}
+
+// forcedCommand returns the command the connection is restricted to, if any
+func forcedCommand(conn *ssh.ServerConn) string {
+	if conn.Permissions == nil {
+		return ""
+	}
+	return conn.Permissions.CriticalOptions["force-command"]
+}
@@ -0,0 +1,214 @@
+// pkg/ssh/authorized_keys_test.go
+package ssh
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseKeyOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []string
+		check   func(keyOptions) bool
+		wantErr bool
+	}{
+		{"forced command", []string{`command="echo \"hi\""`}, func(o keyOptions) bool { return o.command == `echo "hi"` }, false},
+		{"source patterns", []string{`from="10.0.0.0/8,!10.0.0.1"`}, func(o keyOptions) bool { return len(o.from) == 2 }, false},
+		{"expiry", []string{`expiry-time="20300101Z"`}, func(o keyOptions) bool { return o.expiry.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) }, false},
+		{"no-pty", []string{"no-pty"}, func(o keyOptions) bool { return len(o.denied) == 1 && o.denied[0] == "permit-pty" }, false},
+		{"case insensitive", []string{"No-Port-Forwarding"}, func(o keyOptions) bool { return len(o.denied) == 1 }, false},
+		{"restrict", []string{"restrict"}, func(o keyOptions) bool { return len(o.denied) == len(keyOptionDenials) }, false},
+		{"restrict then pty", []string{"restrict", "pty", "X11-Forwarding"}, func(o keyOptions) bool {
+			return len(o.denied) == len(keyOptionDenials)-2 && !slices.Contains(o.denied, "permit-pty") && !slices.Contains(o.denied, "permit-X11-forwarding")
+		}, false},
+		{"pty then restrict", []string{"pty", "restrict"}, func(o keyOptions) bool { return len(o.denied) == len(keyOptionDenials) }, false},
+		{"unknown option", []string{"agent-forwarding-please"}, nil, true},
+		{"unquoted value", []string{"command=ls"}, nil, true},
+		{"bad expiry", []string{`expiry-time="tomorrow"`}, nil, true},
+		{"git access", []string{`git-access="write:team/*,read"`}, func(o keyOptions) bool { return o.gitAccess != nil && *o.gitAccess == "write:team/*,read" }, false},
+		{"bad git access", []string{`git-access="admin"`}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := parseKeyOptions(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKeyOptions(%q) error = %v, wantErr %v", tt.options, err, tt.wantErr)
+			}
+			if tt.check != nil && !tt.check(opts) {
+				t.Errorf("parseKeyOptions(%q) = %+v", tt.options, opts)
+			}
+		})
+	}
+}
+
+func TestMatchSourceAddress(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		addr     string
+		want     bool
+	}{
+		{[]string{"10.0.0.0/8"}, "10.1.2.3:5555", true},
+		{[]string{"10.0.0.0/8"}, "192.168.1.1:5555", false},
+		{[]string{"192.168.1.*"}, "192.168.1.20:22", true},
+		{[]string{"10.0.0.0/8", "!10.0.0.1"}, "10.0.0.1:22", false},
+		{[]string{"!10.0.0.1"}, "10.0.0.2:22", false},
+		{[]string{"::1"}, "[::1]:22", true},
+	}
+
+	for _, tt := range tests {
+		addr, err := net.ResolveTCPAddr("tcp", tt.addr)
+		if err != nil {
+			t.Fatalf("ResolveTCPAddr(%q) failed: %v", tt.addr, err)
+		}
+		if got := matchSourceAddress(tt.patterns, addr); got != tt.want {
+			t.Errorf("matchSourceAddress(%q, %s) = %v, want %v", tt.patterns, tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestKeyOptionsPermissions(t *testing.T) {
+	conn := &mockSSHConn{user: "alice"}
+	now := time.Now()
+
+	if _, err := (keyOptions{expiry: now.Add(-time.Minute)}).permissions(conn, now); err == nil {
+		t.Error("expired key should be rejected")
+	}
+	if _, err := (keyOptions{from: []string{"10.0.0.0/8"}}).permissions(conn, now); err == nil {
+		t.Error("key used from outside its from= list should be rejected")
+	}
+
+	perms, err := keyOptions{command: "whoami", denied: []string{"permit-pty"}}.permissions(conn, now)
+	if err != nil {
+		t.Fatalf("permissions() error = %v", err)
+	}
+	if _, ok := perms.Extensions["permit-pty"]; ok {
+		t.Error("no-pty should remove permit-pty")
+	}
+	if _, ok := perms.Extensions["permit-port-forwarding"]; !ok {
+		t.Error("other permissions should be kept")
+	}
+	if got := perms.CriticalOptions["force-command"]; got != "whoami" {
+		t.Errorf("force-command = %q, want %q", got, "whoami")
+	}
+}
+
+func TestServerEnforcesAuthorizedKeyOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hostKey, _ := newTestKeyPair(t)
+	_, clientSigner := newTestKeyPair(t)
+	authorizedKeys := `no-pty,command="whoami" ` + string(ssh.MarshalAuthorizedKey(clientSigner.PublicKey()))
+
+	server, err := NewServer(hostKey, []byte(authorizedKeys), ServerOptions{})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(ctx, listener)
+
+	client := dialTestServer(t, listener.Addr().String(), "alice", clientSigner)
+	defer client.Close()
+
+	if out := runTestCommand(t, client, "uptime"); !strings.Contains(out, "You are: alice") {
+		t.Errorf("forced command output = %q, want the output of whoami", out)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err == nil {
+		t.Error("pty-req should be refused for a no-pty key")
+	}
+}
+
+func TestNewServerSkipsUnsupportedKeyOptions(t *testing.T) {
+	hostKey, _ := newTestKeyPair(t)
+	_, tunnelSigner := newTestKeyPair(t)
+	_, clientSigner := newTestKeyPair(t)
+	authorizedKeys := `tunnel="0" ` + string(ssh.MarshalAuthorizedKey(tunnelSigner.PublicKey())) +
+		"restrict,pty " + string(ssh.MarshalAuthorizedKey(clientSigner.PublicKey()))
+
+	server, err := NewServer(hostKey, []byte(authorizedKeys), ServerOptions{})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	keys := *server.authorizedKeys.keys.Load()
+	if _, ok := keys[string(tunnelSigner.PublicKey().Marshal())]; ok {
+		t.Error("the entry with an unsupported option should be skipped")
+	}
+	entry, ok := keys[string(clientSigner.PublicKey().Marshal())]
+	if !ok {
+		t.Fatal("the other entries should still be accepted")
+	}
+	if slices.Contains(entry.options.denied, "permit-pty") || !slices.Contains(entry.options.denied, "permit-port-forwarding") {
+		t.Errorf("restrict,pty denies %v, want everything but the pty", entry.options.denied)
+	}
+}
+
+func TestUserFilePath(t *testing.T) {
+	if got, err := userFilePath("/etc/keys", "alice"); err != nil || got != filepath.Join("/etc/keys", "alice") {
+		t.Errorf("userFilePath(alice) = %q, %v", got, err)
+	}
+	for _, user := range []string{"", ".", "..", "../alice", "a/b"} {
+		if _, err := userFilePath("/etc/keys", user); err == nil {
+			t.Errorf("userFilePath(%q) should fail", user)
+		}
+	}
+}
+
+func TestServerAuthorizedKeysDir(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, aliceSigner := newTestKeyPair(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "alice"), ssh.MarshalAuthorizedKey(aliceSigner.PublicKey()), 0o600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	_, addr, sharedSigner, _ := startTestServer(t, ctx, ServerOptions{AuthorizedKeysDir: dir})
+
+	tests := []struct {
+		name   string
+		user   string
+		signer ssh.Signer
+		wantOK bool
+	}{
+		{"own key", "alice", aliceSigner, true},
+		{"another user's key", "bob", aliceSigner, false},
+		{"shared key still accepted", "bob", sharedSigner, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User:            tt.user,
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(tt.signer)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         time.Second,
+			})
+			if client != nil {
+				client.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("Dial error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// BannerData holds the variables available to Banner and MOTD templates,
+// e.g. "Connection from {{.RemoteIP}} logged" or "Welcome {{.User}}"
+type BannerData struct {
+	// User is the login name the client asked for
+	User string
+	// RemoteIP is the client's IP address without the port
+	RemoteIP string
+	// RemoteAddr is the client's address including the port
+	RemoteAddr string
+	// LocalAddr is the server address the client connected to
+	LocalAddr string
+	// Hostname is the name of the machine running the server
+	Hostname string
+	// Time is the current local time
+	Time time.Time
+}
+
+// parseBanner compiles a banner or MOTD template. An empty text yields nil.
+// The template is executed once against sample data so references to
+// unknown variables are reported at startup rather than on every login.
+func parseBanner(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %s", name, err)
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, BannerData{}); err != nil {
+		return nil, fmt.Errorf("parse %s: %s", name, err)
+	}
+	return tmpl, nil
+}
+
+// newBannerData describes the client behind conn
+func newBannerData(conn ssh.ConnMetadata) BannerData {
+	data := BannerData{
+		User:       conn.User(),
+		RemoteAddr: conn.RemoteAddr().String(),
+		RemoteIP:   conn.RemoteAddr().String(),
+		LocalAddr:  conn.LocalAddr().String(),
+		Time:       time.Now(),
+	}
+	if host, _, err := net.SplitHostPort(data.RemoteAddr); err == nil {
+		data.RemoteIP = host
+	}
+	data.Hostname, _ = os.Hostname()
+	return data
+}
+
+// renderBanner executes a banner or MOTD template for conn. Rendering
+// failures are logged to logger and produce no text rather than blocking the login.
+func renderBanner(tmpl *template.Template, conn ssh.ConnMetadata, logger *slog.Logger) string {
+	if tmpl == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, newBannerData(conn)); err != nil {
+		logger.Error("could not render template", "template", tmpl.Name(), "error", err)
+		return ""
+	}
+	return buf.String()
+}
+
+// terminalText converts bare line feeds to CRLF, which a terminal needs when
+// text is written to the channel directly rather than through a PTY
+func terminalText(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+}
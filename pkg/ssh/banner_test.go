@@ -0,0 +1,126 @@
+// pkg/ssh/banner_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseBanner(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantNil bool
+		wantErr bool
+	}{
+		{"empty", "", true, false},
+		{"plain text", "Authorized use only\n", false, false},
+		{"variables", "Connection from {{.RemoteIP}} to {{.Hostname}} as {{.User}}\n", false, false},
+		{"unknown variable", "Hello {{.Nope}}\n", false, true},
+		{"syntax error", "Hello {{.User\n", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseBanner("banner", tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBanner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (tmpl == nil) != tt.wantNil {
+				t.Errorf("parseBanner() = %v, want nil %v", tmpl, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestTerminalText(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"one\ntwo\n", "one\r\ntwo\r\n"},
+		{"already\r\ndone\r\n", "already\r\ndone\r\n"},
+		{"no newline", "no newline"},
+	}
+
+	for _, tt := range tests {
+		if got := terminalText(tt.in); got != tt.want {
+			t.Errorf("terminalText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestServerBanner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Banner: "Connection from {{.RemoteIP}} is logged\n"})
+	dialTestServer(t, addr, "alice", signer).Close()
+
+	var banner string
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		BannerCallback: func(message string) error {
+			banner = message
+			return nil
+		},
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	client.Close()
+
+	if banner != "Connection from 127.0.0.1 is logged\n" {
+		t.Errorf("banner = %q, want the template rendered with the client's IP", banner)
+	}
+}
+
+func TestServerMOTD(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{MOTD: "Welcome {{.User}}\nBackups run at 02:00\n"})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("RequestPty failed: %v", err)
+	}
+	var output bytes.Buffer
+	session.Stdin = strings.NewReader("exit\r")
+	session.Stdout = &output
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	session.Wait()
+
+	if !strings.HasPrefix(output.String(), "Welcome alice\r\nBackups run at 02:00\r\n") {
+		t.Errorf("shell output = %q, want it to start with the MOTD", output.String())
+	}
+
+	if out := runTestCommand(t, client, "whoami"); strings.Contains(out, "Welcome") {
+		t.Errorf("exec output = %q, the MOTD is only for interactive sessions", out)
+	}
+}
+
+func TestNewServerRejectsInvalidBanner(t *testing.T) {
+	hostKey, _ := newTestKeyPair(t)
+	for _, opts := range []ServerOptions{{Banner: "{{.Missing}}"}, {MOTD: "{{"}} {
+		if _, err := NewServer(hostKey, nil, opts); err == nil {
+			t.Errorf("NewServer(%+v) should reject the template", opts)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// pkg/ssh/ca_test.go
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSigner creates a throwaway Ed25519 signer for tests
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return signer
+}
+
+func TestSignUserCertificate(t *testing.T) {
+	ca := newTestSigner(t)
+	user := newTestSigner(t)
+
+	cert, err := SignUserCertificate(ca, CertificateRequest{
+		PublicKey:  user.PublicKey(),
+		KeyID:      "deploy-test",
+		Principals: []string{"deploy"},
+		TTL:        15 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("SignUserCertificate failed: %v", err)
+	}
+
+	// The certificate must validate against the CA for the requested principal
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(ca.PublicKey().Marshal())
+		},
+	}
+	if err := checker.CheckCert("deploy", cert); err != nil {
+		t.Errorf("CheckCert(deploy) failed: %v", err)
+	}
+	if err := checker.CheckCert("root", cert); err == nil {
+		t.Error("CheckCert(root) should fail for a cert issued to deploy")
+	}
+
+	if cert.KeyId != "deploy-test" {
+		t.Errorf("KeyId = %q, want %q", cert.KeyId, "deploy-test")
+	}
+	lifetime := time.Unix(int64(cert.ValidBefore), 0).Sub(time.Now())
+	if lifetime > 15*time.Minute || lifetime < 14*time.Minute {
+		t.Errorf("Unexpected remaining lifetime %s", lifetime)
+	}
+}
+
+func TestSignUserCertificateValidation(t *testing.T) {
+	ca := newTestSigner(t)
+	user := newTestSigner(t)
+
+	tests := []struct {
+		name string
+		req  CertificateRequest
+	}{
+		{"missing key", CertificateRequest{Principals: []string{"deploy"}, TTL: time.Minute}},
+		{"missing principals", CertificateRequest{PublicKey: user.PublicKey(), TTL: time.Minute}},
+		{"zero ttl", CertificateRequest{PublicKey: user.PublicKey(), Principals: []string{"deploy"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := SignUserCertificate(ca, tt.req); err == nil {
+				t.Error("SignUserCertificate() should have failed")
+			}
+		})
+	}
+}
+
+func TestSignHostCertificate(t *testing.T) {
+	ca := newTestSigner(t)
+	host := newTestSigner(t)
+
+	cert, err := SignHostCertificate(ca, CertificateRequest{
+		PublicKey:  host.PublicKey(),
+		KeyID:      "web1",
+		Principals: []string{"web1.example.com"},
+		TTL:        24 * time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("SignHostCertificate failed: %v", err)
+	}
+	if cert.CertType != ssh.HostCert || len(cert.Extensions) != 0 {
+		t.Errorf("certificate type %d, extensions %v", cert.CertType, cert.Extensions)
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+			return string(auth.Marshal()) == string(ca.PublicKey().Marshal())
+		},
+	}
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 11), Port: 22}
+	if err := checker.CheckHostKey("web1.example.com:22", addr, cert); err != nil {
+		t.Errorf("CheckHostKey(web1.example.com) failed: %v", err)
+	}
+	if err := checker.CheckHostKey("web2.example.com:22", addr, cert); err == nil {
+		t.Error("CheckHostKey(web2.example.com) should fail for a cert issued to web1")
+	}
+
+	if _, err := SignHostCertificate(ca, CertificateRequest{
+		PublicKey:       host.PublicKey(),
+		Principals:      []string{"web1.example.com"},
+		TTL:             time.Hour,
+		CriticalOptions: map[string]string{"force-command": "true"},
+	}); err == nil {
+		t.Error("a host certificate with critical options should fail")
+	}
+}
+
+func TestGenerateCAKey(t *testing.T) {
+	for _, keyType := range []string{"ed25519", "ecdsa", "rsa"} {
+		private, public, err := GenerateCAKey(keyType, "gossh-ca", "")
+		if err != nil {
+			t.Fatalf("GenerateCAKey(%s) failed: %v", keyType, err)
+		}
+		signer, err := ssh.ParsePrivateKey(private)
+		if err != nil {
+			t.Fatalf("ParsePrivateKey(%s) failed: %v", keyType, err)
+		}
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey(public)
+		if err != nil || comment != "gossh-ca" || string(pub.Marshal()) != string(signer.PublicKey().Marshal()) {
+			t.Errorf("%s public key %q, comment %q, %v", keyType, public, comment, err)
+		}
+	}
+
+	private, _, err := GenerateCAKey("ed25519", "", "secret")
+	if err != nil {
+		t.Fatalf("GenerateCAKey with a passphrase failed: %v", err)
+	}
+	if _, err := ssh.ParsePrivateKey(private); err == nil {
+		t.Error("an encrypted CA key should need its passphrase")
+	}
+	if _, err := ssh.ParsePrivateKeyWithPassphrase(private, []byte("secret")); err != nil {
+		t.Errorf("ParsePrivateKeyWithPassphrase failed: %v", err)
+	}
+	if _, _, err := GenerateCAKey("dsa", "", ""); err == nil {
+		t.Error("GenerateCAKey should reject unsupported key types")
+	}
+}
+
+func TestRequestUserCertificate(t *testing.T) {
+	ca := newTestSigner(t)
+	user := newTestSigner(t)
+
+	// Minimal signing endpoint backed by SignUserCertificate
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+			return
+		}
+		var req signingRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(req.PublicKey))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl, _ := time.ParseDuration(req.TTL)
+		cert, err := SignUserCertificate(ca, CertificateRequest{
+			PublicKey:  pubKey,
+			KeyID:      req.KeyID,
+			Principals: req.Principals,
+			TTL:        ttl,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(signingResponse{Certificate: string(ssh.MarshalAuthorizedKey(cert))})
+	}))
+	defer srv.Close()
+
+	endpoint := &SigningEndpoint{URL: srv.URL, Token: "s3cret"}
+	cert, err := endpoint.RequestUserCertificate(context.Background(), CertificateRequest{
+		PublicKey:  user.PublicKey(),
+		KeyID:      "ci-job",
+		Principals: []string{"deploy"},
+		TTL:        time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("RequestUserCertificate failed: %v", err)
+	}
+	if cert.KeyId != "ci-job" {
+		t.Errorf("KeyId = %q, want %q", cert.KeyId, "ci-job")
+	}
+
+	// Endpoint refusing the request must surface as an error
+	_, err = endpoint.RequestUserCertificate(context.Background(), CertificateRequest{
+		PublicKey: user.PublicKey(),
+		TTL:       time.Minute,
+	})
+	if err == nil {
+		t.Error("RequestUserCertificate should fail when the endpoint refuses to sign")
+	}
+
+	// Requests without the token are refused
+	anonymous := &SigningEndpoint{URL: srv.URL}
+	_, err = anonymous.RequestUserCertificate(context.Background(), CertificateRequest{
+		PublicKey:  user.PublicKey(),
+		Principals: []string{"deploy"},
+		TTL:        time.Minute,
+	})
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("RequestUserCertificate without a token = %v, want a 401 error", err)
+	}
+}
+
+// newTestUserCert issues a certificate for a fresh key and returns a signer using it
+func newTestUserCert(t *testing.T, ca ssh.Signer, principals []string, modify func(*ssh.Certificate)) (*ssh.Certificate, ssh.Signer) {
+	t.Helper()
+	key := newTestSigner(t)
+	cert, err := SignUserCertificate(ca, CertificateRequest{
+		PublicKey:  key.PublicKey(),
+		KeyID:      "alice@laptop",
+		Principals: principals,
+		TTL:        time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("SignUserCertificate failed: %v", err)
+	}
+	if modify != nil {
+		modify(cert)
+		if err := cert.SignCert(rand.Reader, ca); err != nil {
+			t.Fatalf("SignCert failed: %v", err)
+		}
+	}
+	certSigner, err := ssh.NewCertSigner(cert, key)
+	if err != nil {
+		t.Fatalf("NewCertSigner failed: %v", err)
+	}
+	return cert, certSigner
+}
+
+func TestUserCertAuthenticatorPermissions(t *testing.T) {
+	ca := newTestSigner(t)
+	auth, err := newUserCertAuthenticator(ssh.MarshalAuthorizedKey(ca.PublicKey()))
+	if err != nil {
+		t.Fatalf("newUserCertAuthenticator() error = %v", err)
+	}
+	cert, _ := newTestUserCert(t, ca, []string{"alice"}, nil)
+
+	perms, err := auth.authenticate(&mockSSHConn{user: "alice"}, cert)
+	if err != nil {
+		t.Fatalf("authenticate() error = %v", err)
+	}
+	if got := perms.Extensions["cert-key-id"]; got != "alice@laptop" {
+		t.Errorf("cert-key-id = %q, want %q", got, "alice@laptop")
+	}
+	if got := perms.Extensions["pubkey-fp"]; got != ssh.FingerprintSHA256(cert.Key) {
+		t.Errorf("pubkey-fp = %q, want the fingerprint of the certified key", got)
+	}
+	if _, ok := perms.Extensions["permit-pty"]; !ok {
+		t.Error("certificate extensions should be carried over")
+	}
+	if _, ok := cert.Permissions.Extensions["cert-key-id"]; ok {
+		t.Error("authenticate should not modify the certificate")
+	}
+}
+
+func TestServerTrustedUserCAKeys(t *testing.T) {
+	ca := newTestSigner(t)
+	otherCA := newTestSigner(t)
+	expired := func(c *ssh.Certificate) {
+		c.ValidAfter = uint64(time.Now().Add(-2 * time.Hour).Unix())
+		c.ValidBefore = uint64(time.Now().Add(-time.Hour).Unix())
+	}
+
+	tests := []struct {
+		name       string
+		ca         ssh.Signer
+		principals []string
+		modify     func(*ssh.Certificate)
+		wantOK     bool
+	}{
+		{"valid certificate", ca, []string{"alice"}, nil, true},
+		{"principal mismatch", ca, []string{"bob"}, nil, false},
+		{"expired certificate", ca, []string{"alice"}, expired, false},
+		{"untrusted CA", otherCA, []string{"alice"}, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, addr, _, _ := startTestServer(t, ctx, ServerOptions{
+				TrustedUserCAKeys: ssh.MarshalAuthorizedKey(ca.PublicKey()),
+			})
+			_, certSigner := newTestUserCert(t, tt.ca, tt.principals, tt.modify)
+
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User:            "alice",
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(certSigner)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         time.Second,
+			})
+			if client != nil {
+				client.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("Dial error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
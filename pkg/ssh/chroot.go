@@ -0,0 +1,90 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// errChrootUnavailable is returned for programs that cannot be confined to a
+// chroot directory because the server lacks the privilege to chroot
+var errChrootUnavailable = errors.New("programs cannot be confined to the chroot directory without root privileges")
+
+// chrootDir returns the directory the sessions of user are confined to, or ""
+// when ChrootDirectory is unset. %u in ChrootDirectory is replaced with the
+// user name and %% with a literal %.
+func (o ServerOptions) chrootDir(user string) (string, error) {
+	if o.ChrootDirectory == "" {
+		return "", nil
+	}
+	var dir strings.Builder
+	for i := 0; i < len(o.ChrootDirectory); i++ {
+		c := o.ChrootDirectory[i]
+		if c != '%' || i == len(o.ChrootDirectory)-1 {
+			dir.WriteByte(c)
+			continue
+		}
+		i++
+		switch o.ChrootDirectory[i] {
+		case 'u':
+			// The user name becomes a path element, so it may not climb out of it
+			if _, err := userFilePath("/", user); err != nil {
+				return "", err
+			}
+			dir.WriteString(user)
+		case '%':
+			dir.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unknown token %%%c in chroot directory", o.ChrootDirectory[i])
+		}
+	}
+	if !filepath.IsAbs(dir.String()) {
+		return "", fmt.Errorf("chroot directory %s is not an absolute path", dir.String())
+	}
+	return filepath.Clean(dir.String()), nil
+}
+
+// checkChrootDir verifies that dir exists and is a directory. When processes
+// are chrooted into it, every component of the path must also be owned by
+// root and not writable by anyone else, as OpenSSH requires, so a user cannot
+// plant files such as a fake /etc/passwd for setuid programs to trust.
+func checkChrootDir(dir string, privileged bool) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("chroot directory: %s", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("chroot directory %s is not a directory", dir)
+	}
+	if !privileged {
+		return nil
+	}
+	for p := dir; ; p = filepath.Dir(p) {
+		if err := checkRootOwned(p); err != nil {
+			return err
+		}
+		if p == filepath.Dir(p) {
+			return nil
+		}
+	}
+}
+
+// confine makes cmd run inside the session's chroot directory, if any.
+// Without the privilege to chroot the program is refused, because a process
+// outside the chroot cannot be kept to its subtree.
+func (s *session) confine(cmd *exec.Cmd) error {
+	if s.root == "" {
+		return nil
+	}
+	if !isPrivileged() {
+		return errChrootUnavailable
+	}
+	if err := checkChrootDir(s.root, true); err != nil {
+		return err
+	}
+	chrootCommand(cmd, s.root)
+	return nil
+}
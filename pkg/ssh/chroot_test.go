@@ -0,0 +1,146 @@
+// pkg/ssh/chroot_test.go
+package ssh
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestChrootDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		user    string
+		want    string
+		wantErr bool
+	}{
+		{"unset", "", "alice", "", false},
+		{"per user", "/srv/drop/%u", "alice", "/srv/drop/alice", false},
+		{"shared", "/srv/drop", "alice", "/srv/drop", false},
+		{"literal percent", "/srv/100%%/%u", "alice", "/srv/100%/alice", false},
+		{"cleaned", "/srv/drop/%u/", "alice", "/srv/drop/alice", false},
+		{"user climbing out", "/srv/drop/%u", "..", "", true},
+		{"user with slash", "/srv/drop/%u", "a/b", "", true},
+		{"relative", "drop/%u", "alice", "", true},
+		{"unknown token", "/srv/%h", "alice", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ServerOptions{ChrootDirectory: tt.dir}.chrootDir(tt.user)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("chrootDir(%q) error = %v, wantErr %v", tt.user, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("chrootDir(%q) = %q, want %q", tt.user, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckChrootDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0600)
+
+	if err := checkChrootDir(dir, false); err != nil {
+		t.Errorf("checkChrootDir(dir) error = %v", err)
+	}
+	if err := checkChrootDir(filepath.Join(dir, "missing"), false); err == nil {
+		t.Error("a missing directory should be rejected")
+	}
+	if err := checkChrootDir(file, false); err == nil {
+		t.Error("a file should be rejected")
+	}
+	if runtime.GOOS != "windows" {
+		os.Chmod(dir, 0777)
+		if err := checkChrootDir(dir, true); err == nil {
+			t.Error("a directory others can write to should be rejected for chroot")
+		}
+	}
+}
+
+func TestServerChrootSFTP(t *testing.T) {
+	base := t.TempDir()
+	os.MkdirAll(filepath.Join(base, "alice", "files"), 0755)
+	os.WriteFile(filepath.Join(base, "alice", "files", "report.txt"), []byte("alice's"), 0644)
+	os.WriteFile(filepath.Join(base, "secret.txt"), []byte("outside"), 0644)
+
+	client := newTestSFTPClient(t, ServerOptions{SFTP: true, ChrootDirectory: filepath.Join(base, "%u")})
+
+	entries, err := client.ReadDir("/")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "files" {
+		t.Errorf("ReadDir(/) = %v, %v, want the user's directory [files]", entries, err)
+	}
+	if _, err := client.Stat("/files/report.txt"); err != nil {
+		t.Errorf("Stat inside the chroot failed: %v", err)
+	}
+	if _, err := client.Open("/../secret.txt"); err == nil {
+		t.Error("files outside the chroot directory should not be reachable")
+	}
+}
+
+func TestServerChrootWithoutUserDirectory(t *testing.T) {
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{
+		SFTP:            true,
+		ChrootDirectory: filepath.Join(t.TempDir(), "%u"),
+	})
+	defer server.Close()
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	// The user has no directory, so the SFTP session fails rather than
+	// falling back to an unconfined one
+	if sftpClient, err := sftp.NewClient(client); err == nil {
+		defer sftpClient.Close()
+		if _, err := sftpClient.ReadDir("/"); err == nil {
+			t.Error("SFTP should not be served without the user's chroot directory")
+		}
+	}
+}
+
+func TestServerChrootRefusesUnconfinedPrograms(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	// A temporary directory is writable by its owner's group or lives in
+	// a world-writable /tmp, so programs can never be chrooted into it:
+	// without root for lack of privilege, as root for its permissions
+	root := t.TempDir()
+	os.Chmod(root, 0777)
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{
+		Shell:           "/bin/sh",
+		ChrootDirectory: root,
+	})
+	defer server.Close()
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput("echo escaped")
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() == 0 {
+		t.Errorf("exec error = %v, want a failed exit status", err)
+	}
+	if strings.Contains(string(out), "escaped") || !strings.Contains(string(out), "chroot") {
+		t.Errorf("output = %q, want a chroot error instead of the command's output", out)
+	}
+
+	// Built-in commands still work
+	if out := runTestCommand(t, client, "whoami"); out != "You are: alice\n" {
+		t.Errorf("whoami output = %q", out)
+	}
+}
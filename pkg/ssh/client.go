@@ -0,0 +1,408 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const (
+	// DefaultClientPort is the port Connect dials when the address has none
+	DefaultClientPort = "22"
+	// DefaultConnectTimeout bounds connecting and logging in when
+	// ClientOptions has no Timeout
+	DefaultConnectTimeout = 30 * time.Second
+)
+
+// ClientOptions holds the settings Connect uses to reach and log in to a
+// server
+type ClientOptions struct {
+	// Addr is the server's host or host:port, with port DefaultClientPort
+	// when it has none
+	Addr string
+	// User is the login name (default: the local user)
+	User string
+	// Signers are the private keys, or certificates, offered in order
+	Signers []ssh.Signer
+	// Password is tried after the keys when set
+	Password string
+	// HostKeyCallback verifies the server's host key. The default checks it
+	// against ~/.ssh/known_hosts, refusing unknown and changed keys.
+	HostKeyCallback ssh.HostKeyCallback
+	// Timeout bounds connecting and logging in (default
+	// DefaultConnectTimeout), like a deadline on Connect's context
+	Timeout time.Duration
+	// Config selects the ciphers, key exchanges and MACs; the zero value
+	// keeps the golang.org/x/crypto/ssh defaults
+	Config ssh.Config
+}
+
+// Client is a connection to an SSH server, for programs that run commands,
+// shells and file transfers the way gossh client does
+type Client struct {
+	conn *ssh.Client
+}
+
+// Result is the outcome of a command run with Client.Run
+type Result struct {
+	// ExitCode is the command's exit status, or -1 when it ended without
+	// one, e.g. when it was killed by a signal
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+	Duration time.Duration
+	// Signal is the signal that killed the command, e.g. "TERM", if any
+	Signal string
+}
+
+// NewResult describes a command that ran for duration, printed stdout and
+// stderr and ended with err, the error of ssh.Session.Run or Wait. A
+// non-zero exit status or a signal is reported in the Result; other
+// errors, such as a dropped connection, are returned, with an ExitCode of
+// -1.
+func NewResult(stdout, stderr []byte, duration time.Duration, err error) (Result, error) {
+	result := Result{Stdout: stdout, Stderr: stderr, Duration: duration}
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr) && exitErr.Signal() != "":
+		result.ExitCode = -1
+		result.Signal = exitErr.Signal()
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+	default:
+		result.ExitCode = -1
+		return result, err
+	}
+	return result, nil
+}
+
+// Streams are the standard streams of a remote shell
+type Streams struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Term requests a PTY of this terminal type, e.g. "xterm-256color",
+	// sized Width columns by Height rows (default 80x24). Without it the
+	// shell runs without a PTY, as for a script piped to it.
+	Term          string
+	Width, Height int
+}
+
+// clientConfig returns the golang.org/x/crypto/ssh configuration of opts,
+// with the defaults filled in
+func (o ClientOptions) clientConfig() (*ssh.ClientConfig, error) {
+	config := &ssh.ClientConfig{
+		Config:          o.Config,
+		User:            o.User,
+		HostKeyCallback: o.HostKeyCallback,
+		Timeout:         o.Timeout,
+	}
+	if config.User == "" {
+		current, err := osuser.Current()
+		if err != nil {
+			return nil, fmt.Errorf("no user given and the local user is unknown: %s", err)
+		}
+		config.User = current.Username
+	}
+	if len(o.Signers) > 0 {
+		config.Auth = append(config.Auth, ssh.PublicKeys(o.Signers...))
+	}
+	if o.Password != "" {
+		config.Auth = append(config.Auth, ssh.Password(o.Password))
+	}
+	if len(config.Auth) == 0 {
+		return nil, errors.New("no authentication method: set Signers or Password")
+	}
+	if config.HostKeyCallback == nil {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		if config.HostKeyCallback, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts")); err != nil {
+			return nil, fmt.Errorf("no HostKeyCallback and known_hosts is unusable: %s", err)
+		}
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultConnectTimeout
+	}
+	return config, nil
+}
+
+// Connect dials the server in opts and logs in. Cancelling ctx aborts the
+// TCP connect or the handshake in progress.
+func Connect(ctx context.Context, opts ClientOptions) (*Client, error) {
+	config, err := opts.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	addr := opts.Addr
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, DefaultClientPort)
+	}
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	// Interrupt the handshake when ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() {
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Client{conn: ssh.NewClient(c, chans, reqs)}, nil
+}
+
+// stopOnDone sends the remote program of session SIGTERM and closes the
+// session when ctx is done. The returned func reports false once it has.
+func stopOnDone(ctx context.Context, session *ssh.Session) func() bool {
+	return context.AfterFunc(ctx, func() {
+		session.Signal(ssh.SIGTERM)
+		session.Close()
+	})
+}
+
+// runSession runs start on session and waits for it to end. When ctx is
+// done first the remote program is stopped as by stopOnDone, and ctx's error
+// is returned.
+func runSession(ctx context.Context, session *ssh.Session, start func() error) error {
+	stop := stopOnDone(ctx, session)
+	err := start()
+	if err == nil {
+		err = session.Wait()
+	}
+	if !stop() {
+		return ctx.Err()
+	}
+	return err
+}
+
+// SSHClient returns the underlying golang.org/x/crypto/ssh client, for
+// port forwarding and other requests Client has no method for
+func (c *Client) SSHClient() *ssh.Client {
+	return c.conn
+}
+
+// Run runs command and collects its output. A non-zero exit status is not
+// an error; check the Result's ExitCode and Signal. Cancelling ctx stops
+// the command, returning the output so far and ctx's error.
+func (c *Client) Run(ctx context.Context, command string) (Result, error) {
+	if err := ctx.Err(); err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	session, err := c.conn.NewSession()
+	if err != nil {
+		return Result{ExitCode: -1}, err
+	}
+	defer session.Close()
+	var stdout, stderr bytes.Buffer
+	session.Stdout, session.Stderr = &stdout, &stderr
+	start := time.Now()
+	err = runSession(ctx, session, func() error { return session.Start(command) })
+	return NewResult(stdout.Bytes(), stderr.Bytes(), time.Since(start), err)
+}
+
+// Stream starts command and returns its output streams as they arrive, for
+// output too long to buffer or processed as it comes, like tail -f. Read
+// both streams concurrently until EOF, then call wait for the command's
+// error, an *ssh.ExitError for a non-zero status. The server is only sent
+// more output as it is read, so a slow reader slows the command down, and a
+// stream left unread eventually stalls it. Cancelling ctx stops the command,
+// ending both streams, and wait then returns ctx's error. When the command
+// can't be started both streams are empty and wait returns why.
+func (c *Client) Stream(ctx context.Context, command string) (stdout, stderr io.Reader, wait func() error) {
+	failed := func(err error) (io.Reader, io.Reader, func() error) {
+		return strings.NewReader(""), strings.NewReader(""), func() error { return err }
+	}
+	if err := ctx.Err(); err != nil {
+		return failed(err)
+	}
+	session, err := c.conn.NewSession()
+	if err != nil {
+		return failed(err)
+	}
+	if stdout, err = session.StdoutPipe(); err == nil {
+		stderr, err = session.StderrPipe()
+	}
+	if err != nil {
+		session.Close()
+		return failed(err)
+	}
+	stop := stopOnDone(ctx, session)
+	if err := session.Start(command); err != nil {
+		stop()
+		session.Close()
+		return failed(err)
+	}
+	wait = sync.OnceValue(func() error {
+		err := session.Wait()
+		session.Close()
+		if !stop() {
+			return ctx.Err()
+		}
+		return err
+	})
+	return stdout, stderr, wait
+}
+
+// Shell runs the user's login shell on streams until it exits, or until
+// ctx is done
+func (c *Client) Shell(ctx context.Context, streams Streams) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	session, err := c.conn.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdin, session.Stdout, session.Stderr = streams.Stdin, streams.Stdout, streams.Stderr
+	if streams.Term != "" {
+		width, height := streams.Width, streams.Height
+		if width <= 0 || height <= 0 {
+			width, height = 80, 24
+		}
+		modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 14400, ssh.TTY_OP_OSPEED: 14400}
+		if err := session.RequestPty(streams.Term, height, width, modes); err != nil {
+			return fmt.Errorf("request PTY: %s", err)
+		}
+	}
+	return runSession(ctx, session, session.Shell)
+}
+
+// sftp starts an SFTP session that is closed, failing the transfer in
+// progress, when ctx is done
+func (c *Client) sftp(ctx context.Context) (*sftpSession, error) {
+	client, err := sftp.NewClient(c.conn)
+	if err != nil {
+		return nil, fmt.Errorf("start SFTP: %s", err)
+	}
+	return &sftpSession{Client: client, ctx: ctx, stop: context.AfterFunc(ctx, func() { client.Close() })}, nil
+}
+
+// sftpSession is an SFTP client tied to a context
+type sftpSession struct {
+	*sftp.Client
+	ctx  context.Context
+	stop func() bool
+}
+
+// fail describes an operation on path that failed with err, or returns the
+// context's error when the session was closed for it
+func (s *sftpSession) fail(path string, err error) error {
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return fmt.Errorf("%s: %s", path, err)
+}
+
+// Close closes the SFTP session
+func (s *sftpSession) Close() error {
+	s.stop()
+	return s.Client.Close()
+}
+
+// Upload copies the local file at localPath to remotePath over SFTP,
+// replacing any file there and keeping its permission bits
+func (c *Client) Upload(ctx context.Context, localPath, remotePath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	local, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	info, err := local.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", localPath)
+	}
+
+	client, err := c.sftp(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	remote, err := client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return client.fail(remotePath, err)
+	}
+	if _, err := remote.ReadFrom(local); err != nil {
+		remote.Close()
+		return client.fail(remotePath, err)
+	}
+	if err := remote.Close(); err != nil {
+		return client.fail(remotePath, err)
+	}
+	if err := client.Chmod(remotePath, info.Mode().Perm()); err != nil {
+		return client.fail(remotePath, err)
+	}
+	return nil
+}
+
+// Download copies the remote file at remotePath to localPath over SFTP,
+// replacing any file there and keeping its permission bits
+func (c *Client) Download(ctx context.Context, remotePath, localPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	client, err := c.sftp(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	remote, err := client.Open(remotePath)
+	if err != nil {
+		return client.fail(remotePath, err)
+	}
+	defer remote.Close()
+	info, err := remote.Stat()
+	if err != nil {
+		return client.fail(remotePath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", remotePath)
+	}
+
+	local, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := remote.WriteTo(local); err != nil {
+		local.Close()
+		return client.fail(remotePath, err)
+	}
+	if err := local.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(localPath, info.Mode().Perm())
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,229 @@
+// pkg/ssh/client_test.go
+package ssh_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// connect logs in to srv with the library client
+func connect(t *testing.T, srv *sshtest.Server) *ssh.Client {
+	t.Helper()
+	client, err := ssh.Connect(context.Background(), ssh.ClientOptions{
+		Addr:            srv.Addr,
+		User:            sshtest.DefaultUser,
+		Signers:         []cryptossh.Signer{srv.ClientSigner},
+		HostKeyCallback: cryptossh.FixedHostKey(srv.HostKey),
+	})
+	if err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestClientRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+	client := connect(t, srv)
+	ctx := context.Background()
+
+	result, err := client.Run(ctx, "printf out; printf err >&2")
+	if err != nil || string(result.Stdout) != "out" || string(result.Stderr) != "err" || result.ExitCode != 0 {
+		t.Errorf("Run = %+v, %v", result, err)
+	}
+
+	result, err = client.Run(ctx, "sleep 0.1; exit 3")
+	if err != nil || result.ExitCode != 3 || result.Signal != "" || result.Duration < 100*time.Millisecond {
+		t.Errorf("Run(exit 3) = %+v, %v, want exit code 3 after 100ms", result, err)
+	}
+
+	result, err = client.Run(ctx, "echo dying; kill -KILL $$")
+	if err != nil || result.ExitCode != -1 || result.Signal != "KILL" || string(result.Stdout) != "dying\n" {
+		t.Errorf("Run(kill) = %+v, %v, want killed by KILL", result, err)
+	}
+
+	var stdout bytes.Buffer
+	err = client.Shell(ctx, ssh.Streams{Stdin: strings.NewReader("echo hello\nexit\n"), Stdout: &stdout, Stderr: &stdout})
+	if err != nil || !strings.Contains(stdout.String(), "hello") {
+		t.Errorf("Shell = %q, %v", stdout.String(), err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := client.Run(cancelled, "true"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run with a cancelled context = %v", err)
+	}
+}
+
+func TestClientUploadDownload(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{SFTP: true})
+	client := connect(t, srv)
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	local := filepath.Join(dir, "deploy.sh")
+	if err := os.WriteFile(local, []byte("#!/bin/sh\necho deployed\n"), 0o750); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(dir, "remote.sh")
+	if err := client.Upload(ctx, local, remote); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	back := filepath.Join(dir, "back.sh")
+	if err := client.Download(ctx, remote, back); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	data, err := os.ReadFile(back)
+	if err != nil || string(data) != "#!/bin/sh\necho deployed\n" {
+		t.Errorf("downloaded %q, %v", data, err)
+	}
+	if info, err := os.Stat(back); err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0o750) {
+		t.Errorf("downloaded file mode = %v, %v, want 0750", info.Mode(), err)
+	}
+
+	if err := client.Download(ctx, filepath.Join(dir, "missing"), back); err == nil {
+		t.Error("downloading a missing file should fail")
+	}
+	if err := client.Upload(ctx, dir, remote); err == nil {
+		t.Error("uploading a directory should fail")
+	}
+}
+
+func TestConnectErrors(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{})
+	ctx := context.Background()
+
+	if _, err := ssh.Connect(ctx, ssh.ClientOptions{Addr: srv.Addr, User: "test"}); err == nil {
+		t.Error("Connect without credentials should fail")
+	}
+	_, err := ssh.Connect(ctx, ssh.ClientOptions{
+		Addr:            srv.Addr,
+		Signers:         []cryptossh.Signer{srv.ClientSigner},
+		HostKeyCallback: cryptossh.FixedHostKey(srv.ClientSigner.PublicKey()),
+	})
+	if err == nil {
+		t.Error("Connect should refuse a host key other than the expected one")
+	}
+}
+
+func TestClientContextCancellation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	// A server that accepts connections but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = ssh.Connect(ctx, ssh.ClientOptions{
+		Addr:            listener.Addr().String(),
+		Signers:         []cryptossh.Signer{srv.ClientSigner},
+		HostKeyCallback: cryptossh.FixedHostKey(srv.HostKey),
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("Connect to a stuck server = %v after %s, want the context's deadline", err, time.Since(start))
+	}
+
+	client := connect(t, srv)
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	result, err := client.Run(ctx, "echo started; exec sleep 30")
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("Run = %v after %s, want the context's deadline", err, time.Since(start))
+	}
+	if string(result.Stdout) != "started\n" {
+		t.Errorf("output before cancelling = %q", result.Stdout)
+	}
+
+	// The connection stays usable
+	if result, err := client.Run(context.Background(), "echo again"); err != nil || string(result.Stdout) != "again\n" {
+		t.Errorf("Run after a cancelled command = %+v, %v", result, err)
+	}
+}
+
+func TestClientStream(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{Shell: "/bin/sh"})
+	client := connect(t, srv)
+
+	stdout, stderr, wait := client.Stream(context.Background(), "for i in 1 2 3; do echo line $i; done; echo oops >&2; exit 2")
+	errOut := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(stderr)
+		errOut <- data
+	}()
+	var lines []string
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if got := strings.Join(lines, ","); got != "line 1,line 2,line 3" {
+		t.Errorf("streamed stdout = %q", got)
+	}
+	if got := string(<-errOut); got != "oops\n" {
+		t.Errorf("streamed stderr = %q", got)
+	}
+	var exitErr *cryptossh.ExitError
+	if err := wait(); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 2 {
+		t.Errorf("wait = %v, want exit status 2", err)
+	}
+
+	// Output arrives before the command ends, and cancelling stops it
+	ctx, cancel := context.WithCancel(context.Background())
+	stdout, stderr, wait = client.Stream(ctx, "echo first; exec sleep 30")
+	go io.Copy(io.Discard, stderr)
+	reader := bufio.NewReader(stdout)
+	if line, err := reader.ReadString('\n'); err != nil || line != "first\n" {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+	start := time.Now()
+	cancel()
+	io.Copy(io.Discard, reader)
+	if err := wait(); !errors.Is(err, context.Canceled) || time.Since(start) > 5*time.Second {
+		t.Errorf("wait after cancelling = %v after %s", err, time.Since(start))
+	}
+
+	client.Close()
+	if result, err := client.Run(context.Background(), "true"); err == nil || result.ExitCode != -1 {
+		t.Errorf("Run on a closed client = %+v, %v", result, err)
+	}
+	stdout, _, wait = client.Stream(context.Background(), "true")
+	if data, _ := io.ReadAll(stdout); len(data) != 0 || wait() == nil {
+		t.Errorf("Stream on a closed client = %q, %v", data, wait())
+	}
+}
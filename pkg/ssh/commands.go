@@ -0,0 +1,200 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionContext describes the client a command runs for. It is a
+// context.Context derived from the context passed to Server.Serve, cancelled
+// when the session or its connection ends or the server shuts down, so
+// handlers can pass it to work that should stop with the session.
+type SessionContext interface {
+	context.Context
+	// SessionID identifies the connection; it matches the audit log's conn_id
+	SessionID() string
+	// User is the authenticated login name
+	User() string
+	// RemoteAddr is the client's network address
+	RemoteAddr() net.Addr
+	// LocalAddr is the server address the client connected to
+	LocalAddr() net.Addr
+	// Fingerprint is the SHA256 fingerprint of the key the client
+	// authenticated with, or "" after password authentication
+	Fingerprint() string
+	// Env holds the variables the client set with accepted "env" requests,
+	// as "NAME=value" strings
+	Env() []string
+}
+
+// CommandHandler runs a command for a client. It receives the arguments that
+// followed the command name and returns the output and the exit status.
+type CommandHandler func(ctx SessionContext, args []string) (string, int)
+
+// CommandRegistry maps command names to the handlers that serve exec requests
+// and lines typed into the built-in shell. It is safe for concurrent use.
+type CommandRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]CommandHandler
+}
+
+// NewCommandRegistry returns a registry holding the built-in commands
+func NewCommandRegistry() *CommandRegistry {
+	r := &CommandRegistry{handlers: map[string]CommandHandler{}}
+	r.Register("whoami", func(ctx SessionContext, args []string) (string, int) {
+		return fmt.Sprintf("You are: %s\n", ctx.User()), 0
+	})
+	return r
+}
+
+// Register makes handler serve the command name, replacing any existing
+// handler, including built-in ones. A nil handler removes the command.
+func (r *CommandRegistry) Register(name string, handler CommandHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if handler == nil {
+		delete(r.handlers, name)
+		return
+	}
+	r.handlers[name] = handler
+}
+
+// Lookup returns the handler registered for name
+func (r *CommandRegistry) Lookup(name string) (CommandHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	handler, ok := r.handlers[name]
+	return handler, ok
+}
+
+// Names returns the registered command names in sorted order
+func (r *CommandRegistry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.handlers))
+	for name := range r.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// run splits a command line on whitespace and dispatches it to the handler
+// registered for its first word. Unknown commands exit with status 127, like
+// a shell that cannot find the program.
+func (r *CommandRegistry) run(ctx SessionContext, cmdline string) (string, int) {
+	fields := strings.Fields(cmdline)
+	if len(fields) == 0 {
+		return "", 0
+	}
+	handler, ok := r.Lookup(fields[0])
+	if !ok {
+		return fmt.Sprintf("Command Not Found: %s\n", cmdline), 127
+	}
+	return handler(ctx, fields[1:])
+}
+
+// shellWords splits a command line that file transfer clients send, like
+// "git-upload-pack 'team/app.git'", into words, undoing single quotes and
+// backslash escapes. Double quotes, variables and globs are not interpreted.
+func shellWords(command string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(command); i++ {
+		switch c := command[i]; {
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			word.WriteString(command[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		case c == '\\' && i+1 < len(command):
+			i++
+			word.WriteByte(command[i])
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// sessionContextKey is the context key under which a SessionContext finds itself
+type sessionContextKey struct{}
+
+// SessionFromContext returns the SessionContext that ctx was derived from, so
+// code receiving only a context.Context can still identify the client
+func SessionFromContext(ctx context.Context) (SessionContext, bool) {
+	session, ok := ctx.Value(sessionContextKey{}).(SessionContext)
+	return session, ok
+}
+
+// sessionContext is the SessionContext of a connection and its session. During
+// authentication conn is the handshake metadata and perms the granted permissions.
+type sessionContext struct {
+	context.Context
+	conn  ssh.ConnMetadata
+	perms *ssh.Permissions
+	env   []string
+}
+
+// newSessionContext describes an authenticated connection
+func newSessionContext(ctx context.Context, conn *ssh.ServerConn, env []string) *sessionContext {
+	return &sessionContext{Context: ctx, conn: conn, perms: conn.Permissions, env: env}
+}
+
+// Value returns the session itself for sessionContextKey, so that
+// SessionFromContext works on contexts derived from it
+func (c *sessionContext) Value(key any) any {
+	if key == (sessionContextKey{}) {
+		return c
+	}
+	return c.Context.Value(key)
+}
+
+func (c *sessionContext) SessionID() string {
+	return sessionID(c.conn)
+}
+
+func (c *sessionContext) User() string {
+	return c.conn.User()
+}
+
+func (c *sessionContext) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *sessionContext) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *sessionContext) Fingerprint() string {
+	if c.perms == nil {
+		return ""
+	}
+	return c.perms.Extensions["pubkey-fp"]
+}
+
+func (c *sessionContext) Env() []string {
+	return append([]string(nil), c.env...)
+}
@@ -0,0 +1,202 @@
+// pkg/ssh/commands_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCommandRegistry(t *testing.T) {
+	conn := &ssh.ServerConn{Conn: &mockSSHConn{user: "alice"}}
+	ctx := newSessionContext(context.Background(), conn, []string{"LANG=C"})
+
+	commands := NewCommandRegistry()
+	commands.Register("echo", func(ctx SessionContext, args []string) (string, int) {
+		return strings.Join(args, " ") + "\n", 0
+	})
+	commands.Register("fail", func(ctx SessionContext, args []string) (string, int) {
+		return fmt.Sprintf("%s: failed with %v\n", ctx.User(), ctx.Env()), 3
+	})
+
+	tests := []struct {
+		cmdline    string
+		wantOutput string
+		wantStatus int
+	}{
+		{"echo hello  world", "hello world\n", 0},
+		{"fail", "alice: failed with [LANG=C]\n", 3},
+		{"whoami", "You are: alice\n", 0},
+		{"missing arg", "Command Not Found: missing arg\n", 127},
+		{"   ", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cmdline, func(t *testing.T) {
+			out, status := commands.run(ctx, tt.cmdline)
+			if out != tt.wantOutput || status != tt.wantStatus {
+				t.Errorf("run(%q) = %q, %d, want %q, %d", tt.cmdline, out, status, tt.wantOutput, tt.wantStatus)
+			}
+		})
+	}
+
+	if got := fmt.Sprint(commands.Names()); got != "[echo fail whoami]" {
+		t.Errorf("Names() = %s, want [echo fail whoami]", got)
+	}
+
+	commands.Register("whoami", nil)
+	if _, ok := commands.Lookup("whoami"); ok {
+		t.Error("registering a nil handler should remove the command")
+	}
+}
+
+func TestServerRegisterCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, addr, signer, _ := startTestServer(t, ctx, ServerOptions{})
+	server.RegisterCommand("restart-service", func(ctx SessionContext, args []string) (string, int) {
+		if len(args) != 1 {
+			return "usage: restart-service <name>\n", 2
+		}
+		return fmt.Sprintf("restarted %s for %s\n", args[0], ctx.User()), 0
+	})
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	var output bytes.Buffer
+	session.Stdin = strings.NewReader("restart-service nginx\rrestart-service\rexit\r")
+	session.Stdout = &output
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	session.Wait()
+
+	for _, want := range []string{"restarted nginx for alice", "usage: restart-service <name>"} {
+		if !strings.Contains(output.String(), want) {
+			t.Errorf("shell output %q does not contain %q", output.String(), want)
+		}
+	}
+}
+
+func TestSessionContextCancelledOnClose(t *testing.T) {
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{})
+
+	started := make(chan SessionContext, 1)
+	finished := make(chan error, 1)
+	server.RegisterCommand("wait", func(ctx SessionContext, args []string) (string, int) {
+		started <- ctx
+		<-ctx.Done()
+		finished <- ctx.Err()
+		return "", 1
+	})
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader("wait\r")
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+
+	var ctx SessionContext
+	select {
+	case ctx = <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("command did not start")
+	}
+	if ctx.User() != "alice" || len(ctx.SessionID()) != 16 {
+		t.Errorf("context user = %q, session ID = %q", ctx.User(), ctx.SessionID())
+	}
+	derived, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	if got, ok := SessionFromContext(derived); !ok || got.SessionID() != ctx.SessionID() {
+		t.Errorf("SessionFromContext() = %v, %v, want the originating session", got, ok)
+	}
+
+	server.Close()
+	select {
+	case err := <-finished:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ctx.Err() = %v, want context.Canceled", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("closing the server should cancel running commands")
+	}
+}
+
+func TestServerExecCommandLength(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, addr, signer, _ := startTestServer(t, ctx, ServerOptions{})
+	server.RegisterCommand("restart-service", func(ctx SessionContext, args []string) (string, int) {
+		if len(args) != 1 {
+			return "usage: restart-service <name>\n", 2
+		}
+		return fmt.Sprintf("restarted %s\n", args[0]), 0
+	})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	// Command lines of every length must arrive intact, not only those whose
+	// length prefix happens to be 6
+	if out := runTestCommand(t, client, "restart-service nginx"); out != "restarted nginx\n" {
+		t.Errorf("exec output = %q, want %q", out, "restarted nginx\n")
+	}
+	if out := runTestCommand(t, client, "id"); out != "Command Not Found: id\n" {
+		t.Errorf("exec output = %q, want %q", out, "Command Not Found: id\n")
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	var exitErr *ssh.ExitError
+	if _, err := session.Output("restart-service"); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 2 {
+		t.Errorf("exec error = %v, want exit status 2", err)
+	}
+}
+
+func TestShellWords(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr bool
+	}{
+		{"scp -t -- uploads", []string{"scp", "-t", "--", "uploads"}, false},
+		{"git-upload-pack 'team/app.git'", []string{"git-upload-pack", "team/app.git"}, false},
+		{`git-upload-pack 'it'\''s.git'`, []string{"git-upload-pack", "it's.git"}, false},
+		{`rsync --server . my\ file`, []string{"rsync", "--server", ".", "my file"}, false},
+		{"a  ''  b", []string{"a", "", "b"}, false},
+		{"", nil, false},
+		{"echo 'open", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, err := shellWords(tt.command)
+			if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+				t.Errorf("shellWords() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package ssh
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes an authenticated client connection
+type SessionInfo struct {
+	// ID is the connection's session ID, as reported by SessionContext and
+	// logged as the audit log's conn_id
+	ID            string    `json:"id"`
+	User          string    `json:"user"`
+	RemoteAddr    string    `json:"remote_addr"`
+	ClientVersion string    `json:"client_version"`
+	Fingerprint   string    `json:"fingerprint,omitempty"`
+	Started       time.Time `json:"started"`
+	// Channels is the number of open session channels
+	Channels int `json:"channels"`
+}
+
+// disconnectNoticeTimeout bounds how long Disconnect waits for the notice to
+// reach a client before closing its connection anyway
+const disconnectNoticeTimeout = time.Second
+
+// liveConn is an authenticated connection in the server's registry
+type liveConn struct {
+	conn    *ssh.ServerConn
+	started time.Time
+
+	mu       sync.Mutex
+	channels map[ssh.Channel]struct{}
+	// reason is set when an administrator disconnects the connection
+	reason string
+}
+
+// info describes the connection
+func (c *liveConn) info() SessionInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	info := SessionInfo{
+		ID:            sessionID(c.conn),
+		User:          c.conn.User(),
+		RemoteAddr:    c.conn.RemoteAddr().String(),
+		ClientVersion: string(c.conn.ClientVersion()),
+		Started:       c.started,
+		Channels:      len(c.channels),
+	}
+	if c.conn.Permissions != nil {
+		info.Fingerprint = c.conn.Permissions.Extensions["pubkey-fp"]
+	}
+	return info
+}
+
+// addChannel records an open session channel
+func (c *liveConn) addChannel(channel ssh.Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.channels[channel] = struct{}{}
+}
+
+// removeChannel forgets a closed session channel
+func (c *liveConn) removeChannel(channel ssh.Channel) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.channels, channel)
+}
+
+// disconnectReason returns why an administrator disconnected the connection,
+// or "" if none did
+func (c *liveConn) disconnectReason() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reason
+}
+
+// disconnect tells every open session why the connection is being closed
+// and closes it
+func (c *liveConn) disconnect(reason string) {
+	c.mu.Lock()
+	c.reason = reason
+	channels := make([]ssh.Channel, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	c.mu.Unlock()
+
+	// A client that stopped reading could block the notice forever, so it
+	// is only given a moment before the connection is closed under it
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for _, channel := range channels {
+			fmt.Fprintf(channel.Stderr(), "\r\nDisconnected by administrator: %s\r\n", reason)
+		}
+	}()
+	select {
+	case <-sent:
+	case <-time.After(disconnectNoticeTimeout):
+	}
+	c.conn.Close()
+}
+
+// registerConn adds an authenticated connection to the registry
+func (s *Server) registerConn(conn *ssh.ServerConn) *liveConn {
+	c := &liveConn{conn: conn, started: time.Now(), channels: map[ssh.Channel]struct{}{}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.live[sessionID(conn)] = c
+	return c
+}
+
+// unregisterConn removes a closed connection from the registry
+func (s *Server) unregisterConn(c *liveConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.live, sessionID(c.conn))
+}
+
+// Sessions returns the authenticated connections, oldest first
+func (s *Server) Sessions() []SessionInfo {
+	s.mu.Lock()
+	conns := make([]*liveConn, 0, len(s.live))
+	for _, c := range s.live {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	sessions := make([]SessionInfo, 0, len(conns))
+	for _, c := range conns {
+		sessions = append(sessions, c.info())
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.Before(sessions[j].Started) })
+	return sessions
+}
+
+// Disconnect closes the connection with the given session ID. Its open
+// sessions are sent the reason on stderr first, and the reason is logged and
+// recorded in the audit log's disconnect event.
+func (s *Server) Disconnect(id, reason string) error {
+	s.mu.Lock()
+	c, ok := s.live[id]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownSession, id)
+	}
+	if reason == "" {
+		reason = "no reason given"
+	}
+	connLogger(s.logger, c.conn).Warn("disconnected by administrator", "reason", reason)
+	c.disconnect(reason)
+	return nil
+}
@@ -0,0 +1,90 @@
+// pkg/ssh/connections_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForSessions polls server until it lists want connections
+func waitForSessions(t *testing.T, server *Server, want int) []SessionInfo {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		sessions := server.Sessions()
+		if len(sessions) == want || time.Now().After(deadline) {
+			if len(sessions) != want {
+				t.Fatalf("Sessions() = %v, want %d connections", sessions, want)
+			}
+			return sessions
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerSessions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit := &lockedBuffer{}
+	server, addr, signer, _ := startTestServer(t, ctx, ServerOptions{AuditLog: audit})
+	first := dialTestServer(t, addr, "alice", signer)
+	defer first.Close()
+	second := dialTestServer(t, addr, "bob", signer)
+	defer second.Close()
+
+	// Keep a shell open on the first connection so it has a channel to notify
+	session, err := first.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	stdin, _ := session.StdinPipe()
+	defer stdin.Close()
+	var stderr bytes.Buffer
+	session.Stdout = io.Discard
+	session.Stderr = &stderr
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+
+	sessions := waitForSessions(t, server, 2)
+	if sessions[0].User != "alice" || sessions[1].User != "bob" {
+		t.Errorf("Sessions() users = %s, %s, want alice, bob oldest first", sessions[0].User, sessions[1].User)
+	}
+	alice := sessions[0]
+	if len(alice.ID) != 16 || alice.RemoteAddr == "" || alice.Fingerprint == "" || !strings.HasPrefix(alice.ClientVersion, "SSH-2.0-") {
+		t.Errorf("Sessions()[0] = %+v, want the connection's details", alice)
+	}
+	for deadline := time.Now().Add(2 * time.Second); alice.Channels != 1 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		alice = server.Sessions()[0]
+	}
+	if alice.Channels != 1 {
+		t.Errorf("alice has %d open channels, want 1", alice.Channels)
+	}
+
+	if err := server.Disconnect("0000000000000000", "test"); !errors.Is(err, ErrUnknownSession) {
+		t.Errorf("Disconnect(unknown) error = %v, want ErrUnknownSession", err)
+	}
+	if err := server.Disconnect(alice.ID, "scheduled maintenance"); err != nil {
+		t.Fatalf("Disconnect failed: %v", err)
+	}
+	session.Wait()
+	if !strings.Contains(stderr.String(), "Disconnected by administrator: scheduled maintenance") {
+		t.Errorf("client stderr = %q, want the disconnect reason", stderr.String())
+	}
+
+	remaining := waitForSessions(t, server, 1)
+	if remaining[0].User != "bob" {
+		t.Errorf("remaining connection is %s, want bob", remaining[0].User)
+	}
+	event := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "disconnect" && e.User == "alice" })
+	if event.Error != "disconnected by administrator: scheduled maintenance" {
+		t.Errorf("disconnect event error = %q, want the reason", event.Error)
+	}
+}
@@ -0,0 +1,224 @@
+package ssh
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// controlTimeout bounds a single control socket exchange
+const controlTimeout = 10 * time.Second
+
+// ControlRequest is a command sent to a server's control socket as a single
+// JSON object. Every server understands "sessions", "disconnect" and
+// "drain"; others, such as "reload", are added with Server.HandleControl.
+type ControlRequest struct {
+	Command string `json:"command"`
+	// SessionID names the connection to disconnect
+	SessionID string `json:"session_id,omitempty"`
+	// Reason is shown to the disconnected client and logged
+	Reason string `json:"reason,omitempty"`
+	// Timeout bounds a drain, as a duration such as "30s". Connections still
+	// open when it expires are closed; empty waits for them indefinitely.
+	Timeout string `json:"timeout,omitempty"`
+	// Level is the new level of a "log-level" command
+	Level string `json:"level,omitempty"`
+}
+
+// ControlResponse is the server's answer to a ControlRequest
+type ControlResponse struct {
+	Error    string        `json:"error,omitempty"`
+	Message  string        `json:"message,omitempty"`
+	Sessions []SessionInfo `json:"sessions,omitempty"`
+}
+
+// ControlHandler carries out a control command. A returned error is sent to
+// the client in place of the response.
+type ControlHandler func(req ControlRequest) (ControlResponse, error)
+
+// HandleControl makes handler serve the control command name, replacing any
+// existing handler, including built-in ones. A nil handler removes the command.
+func (s *Server) HandleControl(name string, handler ControlHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if handler == nil {
+		delete(s.controlHandlers, name)
+		return
+	}
+	s.controlHandlers[name] = handler
+}
+
+// builtinControlHandlers returns the control commands every server understands
+func (s *Server) builtinControlHandlers() map[string]ControlHandler {
+	return map[string]ControlHandler{
+		"sessions": func(req ControlRequest) (ControlResponse, error) {
+			return ControlResponse{Sessions: s.Sessions()}, nil
+		},
+		"disconnect": func(req ControlRequest) (ControlResponse, error) {
+			if err := s.Disconnect(req.SessionID, req.Reason); err != nil {
+				return ControlResponse{}, err
+			}
+			return ControlResponse{Message: "disconnected " + req.SessionID}, nil
+		},
+		"drain": s.controlDrain,
+	}
+}
+
+// controlDrain stops accepting connections and lets the open ones finish in
+// the background, answering with the connections still open
+func (s *Server) controlDrain(req ControlRequest) (ControlResponse, error) {
+	var timeout time.Duration
+	if req.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil {
+			return ControlResponse{}, fmt.Errorf("invalid drain timeout: %s", err)
+		}
+	}
+	s.stopListening()
+	go func() {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if err := s.Shutdown(ctx); err != nil {
+			s.logger.Warn("drain timed out, closed remaining connections", "timeout", timeout.String())
+		}
+	}()
+
+	sessions := s.Sessions()
+	s.logger.Info("draining", "open_connections", len(sessions))
+	return ControlResponse{Message: fmt.Sprintf("draining, %d connections open", len(sessions)), Sessions: sessions}, nil
+}
+
+// ListenControl creates a Unix control socket at path that only the owner may
+// connect to. A socket left behind by a server that exited is replaced, but
+// one a running server still answers on is not.
+func ListenControl(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, fmt.Errorf("control socket error: %s", err)
+	}
+	// The socket is created in a private directory and only moved to path
+	// once its mode keeps others out, so no one can connect in between
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, fmt.Errorf("control socket error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "socket")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("control socket error: %s", err)
+	}
+	listener.SetUnlinkOnClose(false)
+	err = os.Chmod(tmp, 0600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("control socket error: %s", err)
+	}
+	return &controlListener{UnixListener: listener, path: path}, nil
+}
+
+// controlListener removes its socket, which ListenControl moved to path,
+// once closed
+type controlListener struct {
+	*net.UnixListener
+	path string
+	once sync.Once
+}
+
+func (l *controlListener) Close() error {
+	err := l.UnixListener.Close()
+	l.once.Do(func() { os.Remove(l.path) })
+	return err
+}
+
+func (l *controlListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+// ServeControl answers control requests on listener until ctx is cancelled or
+// the listener is closed. Anyone who can connect to the listener can manage
+// the server, so it should be a socket created with ListenControl.
+func (s *Server) ServeControl(ctx context.Context, listener net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveControlConn(conn)
+	}
+}
+
+// serveControlConn answers the single request sent on a control connection
+func (s *Server) serveControlConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	var req ControlRequest
+	var resp ControlResponse
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		resp.Error = fmt.Sprintf("malformed control request: %s", err)
+	} else {
+		resp = s.control(req)
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		s.logger.Warn("could not answer control request", "command", req.Command, "error", err)
+	}
+}
+
+// control carries out a control request with the handler registered for it
+func (s *Server) control(req ControlRequest) ControlResponse {
+	s.mu.Lock()
+	handler, ok := s.controlHandlers[req.Command]
+	s.mu.Unlock()
+	if !ok {
+		return ControlResponse{Error: fmt.Sprintf("unknown control command %q", req.Command)}
+	}
+	resp, err := handler(req)
+	if err != nil {
+		return ControlResponse{Error: err.Error()}
+	}
+	s.logger.Debug("control command", "command", req.Command)
+	return resp
+}
+
+// SendControl sends req to the control socket of a running server at path
+// and returns its response. Errors reported by the server are returned too.
+func SendControl(path string, req ControlRequest) (ControlResponse, error) {
+	conn, err := net.DialTimeout("unix", path, controlTimeout)
+	if err != nil {
+		return ControlResponse{}, fmt.Errorf("could not reach server: %s", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(controlTimeout))
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return ControlResponse{}, fmt.Errorf("could not send control request: %s", err)
+	}
+	var resp ControlResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return ControlResponse{}, fmt.Errorf("could not read control response: %s", err)
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
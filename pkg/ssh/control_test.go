@@ -0,0 +1,170 @@
+// pkg/ssh/control_test.go
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestControlSocket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, addr, signer, _ := startTestServer(t, ctx, ServerOptions{})
+	path := startTestControl(t, ctx, server)
+
+	if info, err := os.Stat(path); runtime.GOOS != "windows" && (err != nil || info.Mode().Perm() != 0600) {
+		t.Errorf("control socket mode = %v, %v, want 0600", info, err)
+	}
+	if _, err := ListenControl(path); err == nil {
+		t.Error("ListenControl should refuse a socket a server is answering on")
+	}
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+	waitForSessions(t, server, 1)
+
+	resp, err := SendControl(path, ControlRequest{Command: "sessions"})
+	if err != nil {
+		t.Fatalf("sessions request failed: %v", err)
+	}
+	if len(resp.Sessions) != 1 || resp.Sessions[0].User != "alice" {
+		t.Fatalf("sessions = %+v, want alice's connection", resp.Sessions)
+	}
+
+	if _, err := SendControl(path, ControlRequest{Command: "disconnect", SessionID: "nope"}); err == nil || !strings.Contains(err.Error(), "no such session") {
+		t.Errorf("disconnect of unknown session error = %v", err)
+	}
+	if _, err := SendControl(path, ControlRequest{Command: "disconnect", SessionID: resp.Sessions[0].ID, Reason: "test"}); err != nil {
+		t.Errorf("disconnect request failed: %v", err)
+	}
+	waitForSessions(t, server, 0)
+
+	if _, err := SendControl(path, ControlRequest{Command: "reboot"}); err == nil || !strings.Contains(err.Error(), "unknown control command") {
+		t.Errorf("unknown command error = %v", err)
+	}
+}
+
+func TestListenControlCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "control.sock")
+	listener, err := ListenControl(path)
+	if err != nil {
+		t.Fatalf("ListenControl failed: %v", err)
+	}
+	// The private directory the socket was created in is gone
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "control.sock" {
+		t.Errorf("directory holds %v, want only the socket", entries)
+	}
+	if got := listener.Addr().String(); got != path {
+		t.Errorf("Addr() = %s, want %s", got, path)
+	}
+	listener.Close()
+	if _, err := os.Stat(path); err == nil {
+		t.Error("closing the listener should remove the socket")
+	}
+}
+
+func TestListenControlReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := ListenControl(path)
+	if err != nil {
+		t.Fatalf("ListenControl over a stale file failed: %v", err)
+	}
+	listener.Close()
+}
+
+// startTestControl serves server's control socket in a temporary directory
+func startTestControl(t *testing.T, ctx context.Context, server *Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "control.sock")
+	listener, err := ListenControl(path)
+	if err != nil {
+		t.Fatalf("ListenControl failed: %v", err)
+	}
+	go server.ServeControl(ctx, listener)
+	return path
+}
+
+func TestHandleControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, _, _, _ := startTestServer(t, ctx, ServerOptions{})
+	path := startTestControl(t, ctx, server)
+
+	level := "info"
+	server.HandleControl("log-level", func(req ControlRequest) (ControlResponse, error) {
+		if req.Level != "debug" && req.Level != "info" {
+			return ControlResponse{}, fmt.Errorf("invalid level %q", req.Level)
+		}
+		level = req.Level
+		return ControlResponse{Message: "log level set to " + level}, nil
+	})
+
+	resp, err := SendControl(path, ControlRequest{Command: "log-level", Level: "debug"})
+	if err != nil || resp.Message != "log level set to debug" || level != "debug" {
+		t.Errorf("log-level = %+v, %v, level %s", resp, err, level)
+	}
+	if _, err := SendControl(path, ControlRequest{Command: "log-level", Level: "loud"}); err == nil || !strings.Contains(err.Error(), "invalid level") {
+		t.Errorf("invalid log-level error = %v, want the handler's error", err)
+	}
+
+	server.HandleControl("sessions", nil)
+	if _, err := SendControl(path, ControlRequest{Command: "sessions"}); err == nil {
+		t.Error("a removed command should be unknown")
+	}
+}
+
+func TestControlDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, addr, signer, done := startTestServer(t, ctx, ServerOptions{})
+	path := startTestControl(t, ctx, server)
+	client := dialTestServer(t, addr, "alice", signer)
+	waitForSessions(t, server, 1)
+
+	if _, err := SendControl(path, ControlRequest{Command: "drain", Timeout: "soon"}); err == nil {
+		t.Error("drain should reject an invalid timeout")
+	}
+	resp, err := SendControl(path, ControlRequest{Command: "drain"})
+	if err != nil || len(resp.Sessions) != 1 {
+		t.Fatalf("drain = %+v, %v, want the open connection", resp, err)
+	}
+	waitServeResult(t, done)
+
+	// Open connections keep working and the control socket keeps answering
+	if out := runTestCommand(t, client, "whoami"); out != "You are: alice\n" {
+		t.Errorf("whoami during drain = %q", out)
+	}
+	if _, err := SendControl(path, ControlRequest{Command: "sessions"}); err != nil {
+		t.Errorf("sessions during drain failed: %v", err)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		server.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned while a connection was open")
+	case <-time.After(100 * time.Millisecond):
+	}
+	client.Close()
+	select {
+	case <-waited:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Wait did not return after the last connection closed")
+	}
+}
@@ -0,0 +1,162 @@
+package ssh
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// CryptoPolicy lists the algorithms the server negotiates. An empty list
+// keeps the golang.org/x/crypto/ssh defaults for that kind of algorithm.
+// There is no compression setting: golang.org/x/crypto/ssh only implements
+// "none", so zlib@openssh.com cannot be negotiated by the server or client.
+type CryptoPolicy struct {
+	// KeyExchanges are the allowed key exchange algorithms, in preference order
+	KeyExchanges []string
+	// Ciphers are the allowed encryption algorithms, in preference order
+	Ciphers []string
+	// MACs are the allowed message authentication codes, in preference order.
+	// They are not used with AEAD ciphers such as aes128-gcm@openssh.com.
+	MACs []string
+	// PublicKeyAuthAlgorithms are the signature algorithms accepted for
+	// public key authentication, e.g. to refuse SHA-1 "ssh-rsa" signatures
+	PublicKeyAuthAlgorithms []string
+}
+
+// Named crypto policies, selected with LookupCryptoPolicy or --crypto-policy
+var (
+	// ModernCryptoPolicy allows only current algorithms: curve25519 and NIST
+	// ECDH or large-group SHA-2 Diffie-Hellman, AEAD or CTR ciphers, SHA-2
+	// MACs, and no SHA-1 or DSA signatures. OpenSSH 7.4 and later connect.
+	ModernCryptoPolicy = CryptoPolicy{
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+		},
+		Ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512",
+		},
+		PublicKeyAuthAlgorithms: []string{
+			ssh.KeyAlgoED25519, ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256,
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512,
+		},
+	}
+
+	// CompatCryptoPolicy allows every algorithm the server implements,
+	// including SHA-1 key exchange, CBC and RC4 ciphers and SHA-1 MACs, for
+	// old clients and network equipment. Prefer it only where required.
+	CompatCryptoPolicy = CryptoPolicy{
+		KeyExchanges: []string{
+			"curve25519-sha256", "curve25519-sha256@libssh.org",
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+			"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+		},
+		Ciphers: []string{
+			"chacha20-poly1305@openssh.com", "aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+			"aes128-cbc", "3des-cbc", "arcfour256", "arcfour128", "arcfour",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512", "hmac-sha1", "hmac-sha1-96",
+		},
+		PublicKeyAuthAlgorithms: []string{
+			ssh.KeyAlgoED25519, ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256,
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+		},
+	}
+
+	// FIPSCryptoPolicy allows only algorithms approved by FIPS 140-3: NIST
+	// curves and SHA-2 Diffie-Hellman, AES, and SHA-2 MACs. It restricts
+	// negotiation only; it does not make the Go crypto module validated.
+	FIPSCryptoPolicy = CryptoPolicy{
+		KeyExchanges: []string{
+			"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+			"diffie-hellman-group16-sha512", "diffie-hellman-group14-sha256",
+		},
+		Ciphers: []string{
+			"aes256-gcm@openssh.com", "aes128-gcm@openssh.com",
+			"aes256-ctr", "aes192-ctr", "aes128-ctr",
+		},
+		MACs: []string{
+			"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+			"hmac-sha2-256", "hmac-sha2-512",
+		},
+		PublicKeyAuthAlgorithms: []string{
+			ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+			ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSASHA512,
+		},
+	}
+)
+
+// cryptoPolicies maps policy names to policies; "default" keeps the library defaults
+var cryptoPolicies = map[string]CryptoPolicy{
+	"default": {},
+	"modern":  ModernCryptoPolicy,
+	"compat":  CompatCryptoPolicy,
+	"fips":    FIPSCryptoPolicy,
+}
+
+// LookupCryptoPolicy returns the named crypto policy: default, modern,
+// compat or fips
+func LookupCryptoPolicy(name string) (CryptoPolicy, error) {
+	policy, ok := cryptoPolicies[strings.ToLower(name)]
+	if !ok {
+		return CryptoPolicy{}, fmt.Errorf("unknown crypto policy %q (choose from %s)", name, strings.Join(CryptoPolicyNames(), ", "))
+	}
+	return policy, nil
+}
+
+// CryptoPolicyNames returns the names LookupCryptoPolicy accepts, sorted
+func CryptoPolicyNames() []string {
+	names := make([]string, 0, len(cryptoPolicies))
+	for name := range cryptoPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validate rejects algorithm names the server does not implement, which
+// would otherwise only surface as failed handshakes. CompatCryptoPolicy
+// lists everything the server implements.
+func (p CryptoPolicy) validate() error {
+	lists := []struct {
+		kind      string
+		algos     []string
+		supported []string
+	}{
+		{"key exchange", p.KeyExchanges, CompatCryptoPolicy.KeyExchanges},
+		{"cipher", p.Ciphers, CompatCryptoPolicy.Ciphers},
+		{"MAC", p.MACs, CompatCryptoPolicy.MACs},
+		{"public key", p.PublicKeyAuthAlgorithms, CompatCryptoPolicy.PublicKeyAuthAlgorithms},
+	}
+	for _, list := range lists {
+		for _, algo := range list.algos {
+			if !slices.Contains(list.supported, algo) {
+				return fmt.Errorf("unsupported %s algorithm %q", list.kind, algo)
+			}
+		}
+	}
+	return nil
+}
+
+// apply restricts config to the policy's algorithms
+func (p CryptoPolicy) apply(config *ssh.ServerConfig) {
+	config.KeyExchanges = p.KeyExchanges
+	config.Ciphers = p.Ciphers
+	config.MACs = p.MACs
+	config.PublicKeyAuthAlgorithms = p.PublicKeyAuthAlgorithms
+}
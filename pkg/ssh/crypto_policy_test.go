@@ -0,0 +1,110 @@
+// pkg/ssh/crypto_policy_test.go
+package ssh
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLookupCryptoPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"default", false},
+		{"modern", false},
+		{"FIPS", false},
+		{"compat", false},
+		{"legacy", true},
+	}
+
+	for _, tt := range tests {
+		policy, err := LookupCryptoPolicy(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("LookupCryptoPolicy(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil {
+			if err := policy.validate(); err != nil {
+				t.Errorf("policy %q is invalid: %v", tt.name, err)
+			}
+		}
+	}
+}
+
+func TestCryptoPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  CryptoPolicy
+		wantErr bool
+	}{
+		{"empty", CryptoPolicy{}, false},
+		{"subset", CryptoPolicy{Ciphers: []string{"aes256-gcm@openssh.com"}}, false},
+		{"unknown cipher", CryptoPolicy{Ciphers: []string{"blowfish-cbc"}}, true},
+		{"unknown kex", CryptoPolicy{KeyExchanges: []string{"sntrup761x25519-sha512@openssh.com"}}, true},
+		{"unknown MAC", CryptoPolicy{MACs: []string{"hmac-md5"}}, true},
+		{"unknown key algorithm", CryptoPolicy{PublicKeyAuthAlgorithms: []string{"ssh-foo"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerCryptoPolicy(t *testing.T) {
+	// The test client key is Ed25519, which the FIPS policy refuses for
+	// authentication, so transport checks use FIPS without that restriction
+	fipsTransport := FIPSCryptoPolicy
+	fipsTransport.PublicKeyAuthAlgorithms = nil
+
+	tests := []struct {
+		name   string
+		policy CryptoPolicy
+		client ssh.Config
+		wantOK bool
+	}{
+		{"default client, modern server", ModernCryptoPolicy, ssh.Config{}, true},
+		{"SHA-1 kex refused by modern", ModernCryptoPolicy, ssh.Config{KeyExchanges: []string{"diffie-hellman-group14-sha1"}}, false},
+		{"SHA-1 kex accepted by compat", CompatCryptoPolicy, ssh.Config{KeyExchanges: []string{"diffie-hellman-group14-sha1"}}, true},
+		{"chacha20 refused by fips", fipsTransport, ssh.Config{Ciphers: []string{"chacha20-poly1305@openssh.com"}}, false},
+		{"aes-ctr with SHA-1 MAC refused by fips", fipsTransport, ssh.Config{Ciphers: []string{"aes128-ctr"}, MACs: []string{"hmac-sha1"}}, false},
+		{"aes-gcm accepted by fips", fipsTransport, ssh.Config{Ciphers: []string{"aes128-gcm@openssh.com"}}, true},
+		{"ed25519 signatures refused by fips", FIPSCryptoPolicy, ssh.Config{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Crypto: tt.policy})
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				Config:          tt.client,
+				User:            "alice",
+				Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         time.Second,
+			})
+			if client != nil {
+				client.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("Dial error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewServerRejectsUnknownAlgorithms(t *testing.T) {
+	hostKey, _ := newTestKeyPair(t)
+	if _, err := NewServer(hostKey, nil, ServerOptions{Crypto: CryptoPolicy{Ciphers: []string{"none"}}}); err == nil {
+		t.Error("NewServer should reject unsupported algorithms")
+	}
+}
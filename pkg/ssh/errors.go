@@ -0,0 +1,51 @@
+package ssh
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Errors returned, wrapped, by the server, so embedders can tell failures
+// apart with errors.Is instead of matching their text
+var (
+	// ErrServerClosed is returned by Server.Start, Serve and ServeListeners
+	// after Shutdown or Close
+	ErrServerClosed = errors.New("ssh: server closed")
+	// ErrUnknownKey is returned by Authenticators that do not know a key
+	ErrUnknownKey = errors.New("unknown public key")
+	// ErrUnauthorizedKey wraps every refusal of a public key or certificate:
+	// keys no Authenticator knows, keys whose options forbid the login and
+	// keys an Authenticator rejected
+	ErrUnauthorizedKey = errors.New("unauthorized public key")
+	// ErrUnknownSession is returned by Disconnect for IDs of no live connection
+	ErrUnknownSession = errors.New("no such session")
+	// ErrHostKeyParse wraps host keys and host certificates that cannot be
+	// parsed or used
+	ErrHostKeyParse = errors.New("invalid host key")
+	// ErrListen wraps failures to open a listen endpoint
+	ErrListen = errors.New("listen error")
+	// ErrHandshake wraps connections that failed before logging in. It is
+	// passed to the OnHandshakeFailure hook.
+	ErrHandshake = errors.New("ssh handshake failed")
+)
+
+// handshakeError is a failed handshake. It wraps ErrHandshake, the error of
+// the handshake and, when authentication failed, the error each
+// authentication attempt returned.
+type handshakeError struct {
+	err error
+}
+
+func (e handshakeError) Error() string {
+	return ErrHandshake.Error() + ": " + e.err.Error()
+}
+
+func (e handshakeError) Unwrap() []error {
+	errs := []error{ErrHandshake, e.err}
+	var authErr *ssh.ServerAuthError
+	if errors.As(e.err, &authErr) {
+		errs = append(errs, authErr.Errors...)
+	}
+	return errs
+}
@@ -0,0 +1,88 @@
+// pkg/ssh/errors_test.go
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestErrorsWrapSentinels(t *testing.T) {
+	hostKey, signer := newTestKeyPair(t)
+	_, stranger := newTestKeyPair(t)
+	keys, err := NewAuthorizedKeysAuthenticator([]byte(`from="10.0.0.1" `+string(ssh.MarshalAuthorizedKey(signer.PublicKey()))), "")
+	if err != nil {
+		t.Fatalf("NewAuthorizedKeysAuthenticator failed: %v", err)
+	}
+	alice := &mockSSHConn{user: "alice"}
+	revoked := AuthenticatorFunc(func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+		return nil, errors.New("revoked")
+	})
+	certificate := []byte("ssh-ed25519-cert-v01@openssh.com AAAA")
+
+	tests := []struct {
+		name string
+		err  func() error
+		want error
+	}{
+		{"listen", func() error { _, err := Listen("127.0.0.1"); return err }, ErrListen},
+		{"listen without socket path", func() error { _, err := Listen("unix://"); return err }, ErrListen},
+		{"unparsable host key", func() error { _, err := NewServer([]byte("not a key"), nil, ServerOptions{}); return err }, ErrHostKeyParse},
+		{"unparsable host certificate", func() error {
+			_, err := NewServer(hostKey, nil, ServerOptions{HostCertificates: [][]byte{certificate}})
+			return err
+		}, ErrHostKeyParse},
+		{"unknown key", func() error {
+			_, err := authenticateKey([]Authenticator{keys}, alice, stranger.PublicKey())
+			return err
+		}, ErrUnauthorizedKey},
+		{"key options", func() error { _, err := keys.Authenticate(alice, signer.PublicKey()); return err }, ErrUnauthorizedKey},
+		{"rejected by authenticator", func() error {
+			_, err := authenticateKey([]Authenticator{revoked}, alice, signer.PublicKey())
+			return err
+		}, ErrUnauthorizedKey},
+	}
+	for _, tt := range tests {
+		if err := tt.err(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	_, err = authenticateKey([]Authenticator{keys}, alice, stranger.PublicKey())
+	if !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key error = %v, want ErrUnknownKey too", err)
+	}
+}
+
+func TestOnHandshakeFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failures := make(chan error, 1)
+	hooks := Hooks{OnHandshakeFailure: func(remoteAddr net.Addr, err error) { failures <- err }}
+	_, addr, _, _ := startTestServer(t, ctx, ServerOptions{Hooks: hooks})
+
+	_, stranger := newTestKeyPair(t)
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(stranger)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if err == nil {
+		client.Close()
+		t.Fatal("an unknown key should not log in")
+	}
+	select {
+	case err := <-failures:
+		if !errors.Is(err, ErrHandshake) || !errors.Is(err, ErrUnauthorizedKey) {
+			t.Errorf("handshake failure = %v, want ErrHandshake and ErrUnauthorizedKey", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnHandshakeFailure was not called")
+	}
+}
@@ -0,0 +1,366 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// forwardDialTimeout bounds how long the server waits to reach a forwarding destination
+const forwardDialTimeout = 10 * time.Second
+
+// ForwardPolicy decides which host:port destinations a port forward may use.
+// Patterns have the form "host:port", where host is a hostname or IP address
+// (wildcards * and ? allowed) or a CIDR range, and port is a number or "*".
+// Deny patterns take precedence; an empty Allow list permits every
+// destination that is not denied. Local forwards resolve the destination
+// first and check its addresses as well as its name: a destination is
+// denied when any of its addresses is, and only the permitted addresses are
+// dialed, so "localhost" cannot reach a denied 127.0.0.0/8.
+type ForwardPolicy struct {
+	Allow []string
+	Deny  []string
+}
+
+// Permits reports whether the policy allows forwarding to host:port
+func (p ForwardPolicy) Permits(host string, port uint32) bool {
+	for _, pattern := range p.Deny {
+		if matchDestination(pattern, host, port) {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range p.Allow {
+		if matchDestination(pattern, host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// permittedAddrs returns the addresses of host, as resolved to addrs, that
+// the policy allows forwarding to. Patterns match either the name or the
+// address; none are returned when any address is denied.
+func (p ForwardPolicy) permittedAddrs(host string, addrs []net.IPAddr, port uint32) []net.IPAddr {
+	var permitted []net.IPAddr
+	for _, addr := range addrs {
+		ip := addr.IP.String()
+		for _, pattern := range p.Deny {
+			if matchDestination(pattern, host, port) || matchDestination(pattern, ip, port) {
+				return nil
+			}
+		}
+		if len(p.Allow) == 0 {
+			permitted = append(permitted, addr)
+			continue
+		}
+		for _, pattern := range p.Allow {
+			if matchDestination(pattern, host, port) || matchDestination(pattern, ip, port) {
+				permitted = append(permitted, addr)
+				break
+			}
+		}
+	}
+	return permitted
+}
+
+// matchDestination reports whether host:port matches a policy pattern
+func matchDestination(pattern, host string, port uint32) bool {
+	patternHost, patternPort, err := net.SplitHostPort(pattern)
+	if err != nil {
+		slog.Warn("invalid forwarding pattern", "pattern", pattern, "error", err)
+		return false
+	}
+
+	if patternPort != "*" && patternPort != strconv.FormatUint(uint64(port), 10) {
+		return false
+	}
+
+	if strings.Contains(patternHost, "/") {
+		_, network, err := net.ParseCIDR(patternHost)
+		if err != nil {
+			slog.Warn("invalid forwarding pattern", "pattern", pattern, "error", err)
+			return false
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+
+	ok, _ := path.Match(strings.ToLower(patternHost), strings.ToLower(host))
+	return ok
+}
+
+// directTCPIPRequest is the extra data of a "direct-tcpip" channel open (RFC 4254 section 7.2)
+type directTCPIPRequest struct {
+	DestAddr string
+	DestPort uint32
+	OrigAddr string
+	OrigPort uint32
+}
+
+// handleDirectTCPIP services a local port forwarding channel by connecting
+// to the requested destination and relaying data in both directions
+func handleDirectTCPIP(ctx context.Context, conn *ssh.ServerConn, newChannel ssh.NewChannel, opts *ServerOptions, logger *slog.Logger) {
+	if !opts.AllowLocalForwarding || !permitted(conn, "permit-port-forwarding") {
+		newChannel.Reject(ssh.Prohibited, "port forwarding is disabled")
+		return
+	}
+
+	req := &directTCPIPRequest{}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), req); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "malformed direct-tcpip request")
+		return
+	}
+
+	// The policy is checked against the addresses that are dialed, not
+	// only the name the client sent
+	port := strconv.FormatUint(uint64(req.DestPort), 10)
+	dest := net.JoinHostPort(req.DestAddr, port)
+	lookupCtx, cancel := context.WithTimeout(ctx, forwardDialTimeout)
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, req.DestAddr)
+	cancel()
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	addrs = opts.LocalForwardPolicy.permittedAddrs(req.DestAddr, addrs, req.DestPort)
+	if len(addrs) == 0 {
+		logger.Warn("denied forwarding", "dest", dest)
+		newChannel.Reject(ssh.Prohibited, fmt.Sprintf("forwarding to %s:%d is not permitted", req.DestAddr, req.DestPort))
+		return
+	}
+
+	dialer := &net.Dialer{Timeout: forwardDialTimeout}
+	var target net.Conn
+	for _, addr := range addrs {
+		if target, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(addr.String(), port)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		target.Close()
+		logger.Error("could not accept channel", "error", err)
+		return
+	}
+	go ssh.DiscardRequests(requests)
+
+	logger.Info("forwarding", "origin", net.JoinHostPort(req.OrigAddr, strconv.FormatUint(uint64(req.OrigPort), 10)), "dest", dest, "addr", target.RemoteAddr().String())
+	relay(channel, target)
+}
+
+// relay copies data between a channel and a network connection until both
+// directions are finished, propagating half-closes
+func relay(channel ssh.Channel, conn net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(conn, channel)
+		if tcp, ok := conn.(interface{ CloseWrite() error }); ok {
+			tcp.CloseWrite()
+		} else {
+			conn.Close()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+	}()
+	wg.Wait()
+	channel.Close()
+	conn.Close()
+}
+
+// tcpipForwardRequest is the payload of "tcpip-forward" and "cancel-tcpip-forward" global requests
+type tcpipForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+// tcpipForwardReply is the reply to a "tcpip-forward" request that asked for port 0
+type tcpipForwardReply struct {
+	BoundPort uint32
+}
+
+// forwardedTCPIPPayload is the extra data of a "forwarded-tcpip" channel open
+type forwardedTCPIPPayload struct {
+	ConnectedAddr string
+	ConnectedPort uint32
+	OriginAddr    string
+	OriginPort    uint32
+}
+
+// remoteForwards tracks the listeners opened for one connection's remote (ssh -R) forwards
+type remoteForwards struct {
+	conn   *ssh.ServerConn
+	opts   *ServerOptions
+	logger *slog.Logger
+
+	mu        sync.Mutex
+	listeners map[string]net.Listener
+}
+
+// newRemoteForwards creates the remote forward tracker for a connection
+func newRemoteForwards(conn *ssh.ServerConn, opts *ServerOptions, logger *slog.Logger) *remoteForwards {
+	return &remoteForwards{
+		conn:      conn,
+		opts:      opts,
+		logger:    logger,
+		listeners: map[string]net.Listener{},
+	}
+}
+
+// handleRequests services the connection's global requests and closes every
+// forward once the connection ends
+func (f *remoteForwards) handleRequests(reqs <-chan *ssh.Request) {
+	defer f.closeAll()
+	for req := range reqs {
+		switch req.Type {
+		case "tcpip-forward":
+			f.handleForward(req)
+		case "cancel-tcpip-forward":
+			f.handleCancel(req)
+		default:
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// handleForward opens a listener for a "tcpip-forward" request
+func (f *remoteForwards) handleForward(req *ssh.Request) {
+	fwd := &tcpipForwardRequest{}
+	if err := ssh.Unmarshal(req.Payload, fwd); err != nil {
+		req.Reply(false, nil)
+		return
+	}
+	bindAddr := normalizeBindAddr(fwd.BindAddr)
+
+	if !f.opts.AllowRemoteForwarding || !permitted(f.conn, "permit-port-forwarding") ||
+		!f.opts.remoteForwardPolicy(f.conn.User()).Permits(bindAddr, fwd.BindPort) {
+		f.logger.Warn("denied remote forward", "bind", net.JoinHostPort(bindAddr, strconv.FormatUint(uint64(fwd.BindPort), 10)))
+		req.Reply(false, nil)
+		return
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(bindAddr, strconv.FormatUint(uint64(fwd.BindPort), 10)))
+	if err != nil {
+		f.logger.Error("remote forward listen error", "error", err)
+		req.Reply(false, nil)
+		return
+	}
+
+	boundPort := uint32(listener.Addr().(*net.TCPAddr).Port)
+	key := forwardKey(fwd.BindAddr, boundPort)
+
+	f.mu.Lock()
+	f.listeners[key] = listener
+	f.mu.Unlock()
+
+	var reply []byte
+	if fwd.BindPort == 0 {
+		reply = ssh.Marshal(tcpipForwardReply{BoundPort: boundPort})
+	}
+	req.Reply(true, reply)
+
+	f.logger.Info("remote forward listening", "addr", listener.Addr().String())
+	go f.acceptLoop(listener, fwd.BindAddr, boundPort)
+}
+
+// handleCancel closes the listener named by a "cancel-tcpip-forward" request
+func (f *remoteForwards) handleCancel(req *ssh.Request) {
+	fwd := &tcpipForwardRequest{}
+	if err := ssh.Unmarshal(req.Payload, fwd); err != nil {
+		req.Reply(false, nil)
+		return
+	}
+
+	key := forwardKey(fwd.BindAddr, fwd.BindPort)
+	f.mu.Lock()
+	listener, ok := f.listeners[key]
+	delete(f.listeners, key)
+	f.mu.Unlock()
+
+	if !ok {
+		req.Reply(false, nil)
+		return
+	}
+	listener.Close()
+	req.Reply(true, nil)
+}
+
+// acceptLoop opens a "forwarded-tcpip" channel to the client for every
+// connection accepted on a remote forward listener
+func (f *remoteForwards) acceptLoop(listener net.Listener, bindAddr string, boundPort uint32) {
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		origin := c.RemoteAddr().(*net.TCPAddr)
+		payload := ssh.Marshal(forwardedTCPIPPayload{
+			ConnectedAddr: bindAddr,
+			ConnectedPort: boundPort,
+			OriginAddr:    origin.IP.String(),
+			OriginPort:    uint32(origin.Port),
+		})
+
+		go func() {
+			channel, requests, err := f.conn.OpenChannel("forwarded-tcpip", payload)
+			if err != nil {
+				f.logger.Warn("client refused forwarded connection", "error", err)
+				c.Close()
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			relay(channel, c)
+		}()
+	}
+}
+
+// closeAll stops every remote forward listener of the connection
+func (f *remoteForwards) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, listener := range f.listeners {
+		listener.Close()
+		delete(f.listeners, key)
+	}
+}
+
+// normalizeBindAddr maps the special bind addresses of RFC 4254 section 7.1
+// to addresses the server can listen on
+func normalizeBindAddr(addr string) string {
+	switch addr {
+	case "", "*":
+		return "0.0.0.0"
+	case "localhost":
+		return "127.0.0.1"
+	}
+	return addr
+}
+
+// forwardKey identifies a remote forward by the address the client asked for
+func forwardKey(bindAddr string, port uint32) string {
+	return net.JoinHostPort(bindAddr, strconv.FormatUint(uint64(port), 10))
+}
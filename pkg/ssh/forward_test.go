@@ -0,0 +1,233 @@
+// pkg/ssh/forward_test.go
+package ssh
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// startEchoServer starts a TCP server that echoes everything it receives
+func startEchoServer(t *testing.T) net.Listener {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to start echo server: %v", err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				io.Copy(c, c)
+			}()
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestForwardPolicy(t *testing.T) {
+	policy := ForwardPolicy{
+		Allow: []string{"localhost:*", "*.internal:5432", "10.0.0.0/8:22"},
+		Deny:  []string{"secret.internal:*"},
+	}
+
+	tests := []struct {
+		host string
+		port uint32
+		want bool
+	}{
+		{"localhost", 8080, true},
+		{"LOCALHOST", 80, true},
+		{"db.internal", 5432, true},
+		{"db.internal", 3306, false},
+		{"secret.internal", 5432, false},
+		{"10.1.2.3", 22, true},
+		{"10.1.2.3", 23, false},
+		{"192.168.1.1", 22, false},
+		{"example.com", 443, false},
+	}
+	for _, tt := range tests {
+		if got := policy.Permits(tt.host, tt.port); got != tt.want {
+			t.Errorf("Permits(%s, %d) = %v, want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+
+	open := ForwardPolicy{Deny: []string{"*:25"}}
+	if !open.Permits("example.com", 443) {
+		t.Error("empty Allow list should permit destinations that are not denied")
+	}
+	if open.Permits("mail.example.com", 25) {
+		t.Error("denied port should not be permitted")
+	}
+}
+
+func TestForwardPolicyAddrs(t *testing.T) {
+	loopback := []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}, {IP: net.ParseIP("::1")}}
+	tests := []struct {
+		name   string
+		policy ForwardPolicy
+		host   string
+		addrs  []net.IPAddr
+		want   int
+	}{
+		{"denied range by name", ForwardPolicy{Deny: []string{"127.0.0.0/8:*"}}, "localhost", loopback, 0},
+		{"denied address by name", ForwardPolicy{Deny: []string{"[::1]:*"}}, "localhost", loopback, 0},
+		{"denied name", ForwardPolicy{Deny: []string{"localhost:*"}}, "localhost", loopback, 0},
+		{"allowed name", ForwardPolicy{Allow: []string{"localhost:*"}}, "localhost", loopback, 2},
+		{"only allowed addresses", ForwardPolicy{Allow: []string{"127.0.0.1:*"}}, "localhost", loopback, 1},
+		{"rebound name", ForwardPolicy{Allow: []string{"10.0.0.0/8:*"}}, "db.example.com", loopback, 0},
+		{"open policy", ForwardPolicy{}, "localhost", loopback, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.permittedAddrs(tt.host, tt.addrs, 22); len(got) != tt.want {
+				t.Errorf("permittedAddrs() = %v, want %d addresses", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirectTCPIPForwarding(t *testing.T) {
+	echo := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+	port, _ := strconv.Atoi(echoPort)
+
+	opts := ServerOptions{
+		AllowLocalForwarding: true,
+		LocalForwardPolicy:   ForwardPolicy{Allow: []string{"127.0.0.1:" + echoPort}},
+	}
+	server, addr, signer, _ := startTestServer(t, context.Background(), opts)
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	conn, err := client.Dial("tcp", echo.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial through tunnel failed: %v", err)
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("echo through tunnel = %q, %v; want %q", buf, err, "ping")
+	}
+	conn.Close()
+
+	// Destinations outside the policy are refused
+	if _, err := client.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port+1)); err == nil {
+		t.Error("Dial to a destination outside the policy should fail")
+	}
+}
+
+func TestDirectTCPIPDisabled(t *testing.T) {
+	echo := startEchoServer(t)
+
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{})
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	if _, err := client.Dial("tcp", echo.Addr().String()); err == nil {
+		t.Error("local forwarding should be refused unless enabled")
+	}
+}
+
+func TestRemoteForwarding(t *testing.T) {
+	opts := ServerOptions{
+		AllowRemoteForwarding: true,
+		RemoteForwardPolicy:   ForwardPolicy{Allow: []string{"127.0.0.1:*"}},
+		UserRemoteForwardPolicies: map[string]ForwardPolicy{
+			"mallory": {Deny: []string{"*:*"}},
+		},
+	}
+	server, addr, signer, _ := startTestServer(t, context.Background(), opts)
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	// The server listens on our behalf and hands connections back to us
+	remote, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen through server failed: %v", err)
+	}
+	go func() {
+		c, err := remote.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.Copy(c, c)
+	}()
+
+	conn, err := net.Dial("tcp", remote.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to reach remote forward: %v", err)
+	}
+	conn.Write([]byte("pong"))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "pong" {
+		t.Errorf("echo through remote forward = %q, %v; want %q", buf, err, "pong")
+	}
+	conn.Close()
+
+	// Cancelling the forward closes the server-side listener
+	if err := remote.Close(); err != nil {
+		t.Errorf("cancel-tcpip-forward failed: %v", err)
+	}
+
+	// Binding outside the policy is refused
+	if _, err := client.Listen("tcp", "0.0.0.0:0"); err == nil {
+		t.Error("Listen on an address outside the policy should fail")
+	}
+
+	// A per-user policy replaces the default one
+	denied := dialTestServer(t, addr, "mallory", signer)
+	defer denied.Close()
+	if _, err := denied.Listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Error("mallory's policy should deny every remote forward")
+	}
+}
+
+func TestRemoteForwardingDisabled(t *testing.T) {
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{})
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	if _, err := client.Listen("tcp", "127.0.0.1:0"); err == nil {
+		t.Error("remote forwarding should be refused unless enabled")
+	}
+}
+
+func TestDirectTCPIPResolvesDestination(t *testing.T) {
+	echo := startEchoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	opts := ServerOptions{
+		AllowLocalForwarding: true,
+		LocalForwardPolicy:   ForwardPolicy{Deny: []string{"127.0.0.0/8:*"}},
+	}
+	server, addr, signer, _ := startTestServer(t, context.Background(), opts)
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	// Other spellings of a denied address are refused too
+	for _, host := range []string{"127.0.0.1", "localhost", "127.1", "[::ffff:127.0.0.1]"} {
+		if conn, err := client.Dial("tcp", host+":"+echoPort); err == nil {
+			conn.Close()
+			t.Errorf("Dial to %s should be denied", host)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// UnknownCountry matches clients the GeoIP database has no country for,
+// such as private addresses, in AllowCountries and DenyCountries
+const UnknownCountry = "--"
+
+// CountryResolver maps client addresses to ISO 3166-1 alpha-2 country codes
+type CountryResolver interface {
+	// Country returns the country code of ip, e.g. "DE", or "" when unknown
+	Country(ip net.IP) (string, error)
+}
+
+// GeoIPDatabase is a CountryResolver reading a MaxMind DB file, such as
+// GeoLite2-Country.mmdb, GeoLite2-City.mmdb or a DB-IP lite database
+type GeoIPDatabase struct {
+	reader *maxminddb.Reader
+}
+
+// OpenGeoIPDatabase opens the MaxMind DB file at path
+func OpenGeoIPDatabase(path string) (*GeoIPDatabase, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open GeoIP database: %s", err)
+	}
+	return &GeoIPDatabase{reader: reader}, nil
+}
+
+// geoIPRecord holds the fields of a MaxMind country or city record that
+// name a country
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Country returns the country ip is located in, falling back to the country
+// its network is registered in
+func (d *GeoIPDatabase) Country(ip net.IP) (string, error) {
+	var record geoIPRecord
+	if err := d.reader.Lookup(ip, &record); err != nil {
+		return "", fmt.Errorf("GeoIP lookup of %s failed: %s", ip, err)
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode, nil
+	}
+	return record.RegisteredCountry.ISOCode, nil
+}
+
+// Close releases the database file
+func (d *GeoIPDatabase) Close() error {
+	return d.reader.Close()
+}
+
+// hostIP returns the IP address of a TCP client's host:port address, or nil
+// for other connections such as Unix sockets
+func hostIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// lookupCountry returns the country of ip, or UnknownCountry when resolver
+// does not know it
+func lookupCountry(resolver CountryResolver, ip net.IP) string {
+	country, err := resolver.Country(ip)
+	if err != nil || country == "" {
+		return UnknownCountry
+	}
+	return strings.ToUpper(country)
+}
+
+// validateCountries checks that country filtering has a database to use
+func (o ServerOptions) validateCountries() error {
+	if (len(o.AllowCountries) > 0 || len(o.DenyCountries) > 0) && o.GeoIP == nil {
+		return errors.New("allowing or denying countries requires a GeoIP database")
+	}
+	return nil
+}
+
+// countryAllowed applies DenyCountries and then AllowCountries to a client
+// address, returning the country it was judged by. Connections that are not
+// over IP, such as local Unix sockets, are not filtered.
+func (o ServerOptions) countryAllowed(addr net.Addr) (string, bool) {
+	if o.GeoIP == nil || (len(o.AllowCountries) == 0 && len(o.DenyCountries) == 0) {
+		return "", true
+	}
+	ip := hostIP(addr.String())
+	if ip == nil {
+		return "", true
+	}
+	country := lookupCountry(o.GeoIP, ip)
+	if matchCountry(o.DenyCountries, country) {
+		return country, false
+	}
+	return country, len(o.AllowCountries) == 0 || matchCountry(o.AllowCountries, country)
+}
+
+// matchCountry reports whether country is one of codes, ignoring case
+func matchCountry(codes []string, country string) bool {
+	for _, code := range codes {
+		if strings.EqualFold(strings.TrimSpace(code), country) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+// pkg/ssh/geoip_test.go
+package ssh
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeCountries resolves the addresses it lists and knows no others
+type fakeCountries map[string]string
+
+func (f fakeCountries) Country(ip net.IP) (string, error) {
+	return f[ip.String()], nil
+}
+
+func TestCountryAllowed(t *testing.T) {
+	geoIP := fakeCountries{"192.0.2.1": "DE", "198.51.100.1": "cn"}
+	tcp := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000} }
+
+	tests := []struct {
+		name        string
+		allow, deny []string
+		addr        net.Addr
+		wantCountry string
+		wantOK      bool
+	}{
+		{"no filters", nil, nil, tcp("192.0.2.1"), "", true},
+		{"allowed country", []string{"de", "AT"}, nil, tcp("192.0.2.1"), "DE", true},
+		{"other country", []string{"DE"}, nil, tcp("198.51.100.1"), "CN", false},
+		{"denied country", nil, []string{"CN"}, tcp("198.51.100.1"), "CN", false},
+		{"deny wins", []string{"DE"}, []string{"DE"}, tcp("192.0.2.1"), "DE", false},
+		{"unknown refused by allow list", []string{"DE"}, nil, tcp("10.0.0.1"), UnknownCountry, false},
+		{"unknown allowed explicitly", []string{"DE", UnknownCountry}, nil, tcp("10.0.0.1"), UnknownCountry, true},
+		{"unix socket not filtered", []string{"DE"}, nil, &net.UnixAddr{Name: "/run/gossh.sock", Net: "unix"}, "", true},
+	}
+	for _, tt := range tests {
+		opts := ServerOptions{GeoIP: geoIP, AllowCountries: tt.allow, DenyCountries: tt.deny}
+		country, ok := opts.countryAllowed(tt.addr)
+		if country != tt.wantCountry || ok != tt.wantOK {
+			t.Errorf("%s: countryAllowed(%s) = %q, %v, want %q, %v", tt.name, tt.addr, country, ok, tt.wantCountry, tt.wantOK)
+		}
+	}
+
+	if err := (ServerOptions{DenyCountries: []string{"CN"}}).validateCountries(); err == nil {
+		t.Error("country filters without a GeoIP database should be refused")
+	}
+}
+
+func TestOpenGeoIPDatabase(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenGeoIPDatabase(filepath.Join(dir, "missing.mmdb")); err == nil {
+		t.Error("OpenGeoIPDatabase of a missing file should fail")
+	}
+	invalid := filepath.Join(dir, "invalid.mmdb")
+	os.WriteFile(invalid, []byte("not a MaxMind database"), 0600)
+	if _, err := OpenGeoIPDatabase(invalid); err == nil {
+		t.Error("OpenGeoIPDatabase of an invalid file should fail")
+	}
+}
+
+func TestServerCountryPolicy(t *testing.T) {
+	dial := func(addr string, signer ssh.Signer) error {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            "alice",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         time.Second,
+		})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	geoIP := fakeCountries{"127.0.0.1": "NL"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	audit := &lockedBuffer{}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{GeoIP: geoIP, DenyCountries: []string{"NL"}, AuditLog: audit})
+	if err := dial(addr, signer); err == nil {
+		t.Error("a client from a denied country should be disconnected")
+	}
+	rejected := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "connection_rejected" })
+	if rejected.Country != "NL" || rejected.Error != "country not allowed" {
+		t.Errorf("rejection event = %+v", rejected)
+	}
+
+	audit = &lockedBuffer{}
+	_, addr, signer, _ = startTestServer(t, ctx, ServerOptions{GeoIP: geoIP, AllowCountries: []string{"NL"}, AuditLog: audit})
+	if err := dial(addr, signer); err != nil {
+		t.Errorf("a client from an allowed country should connect: %v", err)
+	}
+	login := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "auth_success" })
+	if login.Country != "NL" {
+		t.Errorf("auth_success country = %q, want NL", login.Country)
+	}
+}
@@ -0,0 +1,169 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// gitServices maps the programs git clients run over SSH to whether they
+// write to the repository
+var gitServices = map[string]bool{
+	"git-upload-pack":    false,
+	"git-upload-archive": false,
+	"git-receive-pack":   true,
+}
+
+// gitAccessExtension carries the git-access= option of the client's key, or
+// the extension of the same name in a user certificate
+const gitAccessExtension = "git-access"
+
+// gitRule grants read or write access to the repositories matching a pattern
+type gitRule struct {
+	write bool
+	// pattern is matched against the repository path with path.Match;
+	// empty matches every repository
+	pattern string
+}
+
+// parseGitAccess parses a git-access= key option: a comma-separated list of
+// read, write or none, each optionally limited to repositories matching a
+// pattern, e.g. "write:team/*,read". Write access includes read access.
+func parseGitAccess(value string) ([]gitRule, error) {
+	var rules []gitRule
+	for _, entry := range strings.Split(value, ",") {
+		level, pattern, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid git-access pattern %q", pattern)
+		}
+		switch level {
+		case "read":
+			rules = append(rules, gitRule{pattern: pattern})
+		case "write":
+			rules = append(rules, gitRule{write: true, pattern: pattern})
+		case "none":
+		default:
+			return nil, fmt.Errorf("invalid git-access %q (use read, write or none)", entry)
+		}
+	}
+	return rules, nil
+}
+
+// gitAllowed reports whether the rules grant access to repo. Keys without a
+// git-access option may read every repository.
+func gitAllowed(access string, hasAccess bool, repo string, write bool) bool {
+	if !hasAccess {
+		return !write
+	}
+	rules, err := parseGitAccess(access)
+	if err != nil {
+		return false
+	}
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.pattern, repo); (ok || rule.pattern == "") && (rule.write || !write) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseGitCommand recognizes a git command line such as
+// "git-upload-pack 'team/app.git'", returning the service and repository
+func parseGitCommand(command string) (service, repo string, ok bool) {
+	words, err := shellWords(command)
+	// Some clients run "git upload-pack" instead of "git-upload-pack"
+	if err == nil && len(words) > 1 && words[0] == "git" {
+		words = append([]string{"git-" + words[1]}, words[2:]...)
+	}
+	if err != nil || len(words) != 2 || words[1] == "" {
+		return "", "", false
+	}
+	if _, known := gitServices[words[0]]; !known {
+		return "", "", false
+	}
+	return words[0], words[1], true
+}
+
+// gitRepoName normalizes a client's repository path, such as "/team/app.git"
+// or "~/app", to a path relative to GitRoot, refusing paths that leave it
+func gitRepoName(repo string) (string, error) {
+	repo = strings.TrimPrefix(repo, "~")
+	clean := path.Clean("/" + repo)
+	if clean == "/" {
+		return "", errors.New("no repository given")
+	}
+	return strings.TrimPrefix(clean, "/"), nil
+}
+
+// resolveGitRepo finds the repository directory for name inside root,
+// trying name.git when name itself does not exist. It returns the directory
+// and its path relative to root, which access rules are matched against.
+func resolveGitRepo(root, name string) (string, string, error) {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", "", fmt.Errorf("git root: %s", err)
+	}
+	candidates := []string{name}
+	if !strings.HasSuffix(name, ".git") {
+		candidates = append(candidates, name+".git")
+	}
+	for _, candidate := range candidates {
+		dir, err := filepath.EvalSymlinks(filepath.Join(resolvedRoot, filepath.FromSlash(candidate)))
+		if err != nil {
+			continue
+		}
+		if !withinDir(resolvedRoot, dir) {
+			return "", "", os.ErrPermission
+		}
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			rel, _ := filepath.Rel(resolvedRoot, dir)
+			return dir, filepath.ToSlash(rel), nil
+		}
+	}
+	return "", "", os.ErrNotExist
+}
+
+// runGit serves a git fetch, push or archive request against a repository
+// under GitRoot, after checking the access granted to the client's key.
+// Clients are not told whether a repository they may not use exists.
+func (s *session) runGit(service, repo string) {
+	fail := func(reason error) {
+		s.logger.Warn("git request refused", "service", service, "repo", repo, "reason", reason)
+		fmt.Fprintf(s.channel.Stderr(), "fatal: repository %s not found\n", repo)
+		sendExitStatus(s.channel, 128)
+		s.channel.Close()
+	}
+
+	name, err := gitRepoName(repo)
+	if err != nil {
+		fail(err)
+		return
+	}
+	dir, name, err := resolveGitRepo(s.opts.GitRoot, name)
+	if err != nil {
+		fail(err)
+		return
+	}
+	write := gitServices[service]
+	var access string
+	var hasAccess bool
+	if s.conn.Permissions != nil {
+		access, hasAccess = s.conn.Permissions.Extensions[gitAccessExtension]
+	}
+	if !gitAllowed(access, hasAccess, name, write) {
+		fail(errors.New("access denied"))
+		return
+	}
+
+	s.logger.Info("git request", "service", service, "repo", name, "write", write)
+	cmd := exec.CommandContext(s.ctx, "git", strings.TrimPrefix(service, "git-"), dir)
+	cmd.Env = s.shellEnv()
+	if s.account != nil {
+		runAs(cmd, s.account)
+	}
+	s.runProcess(cmd)
+}
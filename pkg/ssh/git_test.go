@@ -0,0 +1,185 @@
+// pkg/ssh/git_test.go
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseGitCommand(t *testing.T) {
+	tests := []struct {
+		command     string
+		wantService string
+		wantRepo    string
+		wantOK      bool
+	}{
+		{"git-upload-pack 'team/app.git'", "git-upload-pack", "team/app.git", true},
+		{"git-receive-pack '/team/app.git'", "git-receive-pack", "/team/app.git", true},
+		{"git upload-pack 'app'", "git-upload-pack", "app", true},
+		{"git-upload-archive 'app.git'", "git-upload-archive", "app.git", true},
+		{`git-upload-pack 'it'\''s.git'`, "git-upload-pack", "it's.git", true},
+		{"git-upload-pack app.git", "git-upload-pack", "app.git", true},
+		{"git-upload-pack 'a.git' 'b.git'", "", "", false},
+		{"git-upload-pack 'app.git", "", "", false},
+		{"git-upload-pack", "", "", false},
+		{"git-shell -c ls", "", "", false},
+		{"ls -la", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			service, repo, ok := parseGitCommand(tt.command)
+			if ok != tt.wantOK || service != tt.wantService || repo != tt.wantRepo {
+				t.Errorf("parseGitCommand() = %q, %q, %v, want %q, %q, %v", service, repo, ok, tt.wantService, tt.wantRepo, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGitRepoName(t *testing.T) {
+	tests := []struct {
+		repo    string
+		want    string
+		wantErr bool
+	}{
+		{"/team/app.git", "team/app.git", false},
+		{"team/app.git", "team/app.git", false},
+		{"~/app", "app", false},
+		{"../../etc/passwd", "etc/passwd", false},
+		{"/", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.repo, func(t *testing.T) {
+			got, err := gitRepoName(tt.repo)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("gitRepoName(%q) = %q, %v, want %q", tt.repo, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitAllowed(t *testing.T) {
+	tests := []struct {
+		name      string
+		access    string
+		hasAccess bool
+		repo      string
+		write     bool
+		want      bool
+	}{
+		{"default read", "", false, "app.git", false, true},
+		{"default write", "", false, "app.git", true, false},
+		{"write everywhere", "write", true, "app.git", true, true},
+		{"write includes read", "write", true, "app.git", false, true},
+		{"read only", "read", true, "app.git", true, false},
+		{"none", "none", true, "app.git", false, false},
+		{"pattern match", "write:team/*,read", true, "team/app.git", true, true},
+		{"pattern miss", "write:team/*,read", true, "other/app.git", true, false},
+		{"read fallback", "write:team/*,read", true, "other/app.git", false, true},
+		{"pattern only", "read:public/*", true, "private/app.git", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := gitAllowed(tt.access, tt.hasAccess, tt.repo, tt.write); got != tt.want {
+				t.Errorf("gitAllowed(%q, %s, write=%v) = %v, want %v", tt.access, tt.repo, tt.write, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseGitAccess("admin"); err == nil {
+		t.Error("parseGitAccess should reject unknown access levels")
+	}
+}
+
+// runGit runs a git command in dir, connecting to servers with key
+func runGit(t *testing.T, dir, key string, args ...string) (string, error) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_SSH_COMMAND=ssh -i "+key+" -o IdentitiesOnly=yes -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null",
+		"GIT_AUTHOR_NAME=Test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=Test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func TestServerGit(t *testing.T) {
+	for _, tool := range []string{"git", "ssh"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("requires %s", tool)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	root := t.TempDir()
+	if out, err := exec.Command("git", "init", "--bare", "-q", filepath.Join(root, "team", "app.git")).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v: %s", err, out)
+	}
+
+	keyDir := t.TempDir()
+	writerPEM, writer := newTestKeyPair(t)
+	readerPEM, reader := newTestKeyPair(t)
+	os.WriteFile(filepath.Join(keyDir, "writer"), writerPEM, 0600)
+	os.WriteFile(filepath.Join(keyDir, "reader"), readerPEM, 0600)
+	authorizedKeys := `git-access="write:team/*" ` + string(ssh.MarshalAuthorizedKey(writer.PublicKey())) +
+		`git-access="read" ` + string(ssh.MarshalAuthorizedKey(reader.PublicKey()))
+
+	hostKey, _ := newTestKeyPair(t)
+	server, err := NewServer(hostKey, []byte(authorizedKeys), ServerOptions{GitRoot: root})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(ctx, listener)
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	url := fmt.Sprintf("ssh://git@127.0.0.1:%s/team/app", port)
+
+	// The writer clones, commits and pushes
+	work := t.TempDir()
+	if out, err := runGit(t, work, filepath.Join(keyDir, "writer"), "clone", "-q", url, "app"); err != nil {
+		t.Fatalf("clone failed: %v: %s", err, out)
+	}
+	clone := filepath.Join(work, "app")
+	os.WriteFile(filepath.Join(clone, "README"), []byte("hello"), 0644)
+	runGit(t, clone, "", "add", "README")
+	if out, err := runGit(t, clone, "", "commit", "-q", "-m", "first"); err != nil {
+		t.Fatalf("commit failed: %v: %s", err, out)
+	}
+	if out, err := runGit(t, clone, filepath.Join(keyDir, "writer"), "push", "-q", "origin", "HEAD:main"); err != nil {
+		t.Fatalf("push failed: %v: %s", err, out)
+	}
+
+	// The reader can fetch the commit but not push
+	readerWork := t.TempDir()
+	if out, err := runGit(t, readerWork, filepath.Join(keyDir, "reader"), "clone", "-q", "-b", "main", url, "app"); err != nil {
+		t.Fatalf("read-only clone failed: %v: %s", err, out)
+	}
+	if data, err := os.ReadFile(filepath.Join(readerWork, "app", "README")); err != nil || string(data) != "hello" {
+		t.Errorf("cloned README = %q, %v", data, err)
+	}
+	out, err := runGit(t, filepath.Join(readerWork, "app"), filepath.Join(keyDir, "reader"), "push", "-q", "origin", "HEAD:other")
+	if err == nil || !strings.Contains(out, "not found") {
+		t.Errorf("read-only push = %v: %s, want a refusal", err, out)
+	}
+
+	// Repositories outside GitRoot cannot be reached
+	if out, err := runGit(t, t.TempDir(), filepath.Join(keyDir, "writer"), "ls-remote", fmt.Sprintf("ssh://git@127.0.0.1:%s/../../etc", port)); err == nil {
+		t.Errorf("ls-remote outside the git root succeeded: %s", out)
+	}
+}
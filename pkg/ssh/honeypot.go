@@ -0,0 +1,469 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh/internal/wire"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// DefaultHoneypotHostname is the host name the honeypot's fake shell shows
+const DefaultHoneypotHostname = "web01"
+
+// honeypot returns a copy of the options with every feature that would touch
+// the real system turned off, so honeypot sessions only ever reach the fake
+// shell
+func (o ServerOptions) honeypot() ServerOptions {
+	o.Shell = ""
+	o.AllowedCommands = nil
+	o.SFTP = false
+	o.SCP = false
+	o.ChrootDirectory = ""
+	o.RunAsUser = false
+	o.ForceCommand = ""
+	o.GitRoot = ""
+	o.AllowLocalForwarding = false
+	o.AllowRemoteForwarding = false
+	o.PasswordAuth = false
+	o.TOTP = false
+	o.RecordDir = ""
+	if o.HoneypotHostname == "" {
+		o.HoneypotHostname = DefaultHoneypotHostname
+	}
+	if o.ServerVersion == "" {
+		o.ServerVersion = honeypotServerVersion
+	}
+	return o
+}
+
+// honeypotAuth makes config accept every public key, password and
+// keyboard-interactive answer, recording each credential offered
+func (s *Server) honeypotAuth(config *ssh.ServerConfig) {
+	config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		event := honeypotAuthEvent(c, "publickey")
+		event.Fingerprint = ssh.FingerprintSHA256(key)
+		event.PublicKey = strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+		s.audit.record(event)
+		perms := newPermissions()
+		perms.Extensions["pubkey-fp"] = event.Fingerprint
+		return perms, nil
+	}
+	config.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		event := honeypotAuthEvent(c, "password")
+		event.Password = string(password)
+		s.audit.record(event)
+		return newPermissions(), nil
+	}
+	config.KeyboardInteractiveCallback = func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		answers, err := client(c.User(), "", []string{"Password: "}, []bool{false})
+		if err != nil {
+			return nil, err
+		}
+		event := honeypotAuthEvent(c, "keyboard-interactive")
+		event.Password = strings.Join(answers, "\n")
+		s.audit.record(event)
+		return newPermissions(), nil
+	}
+}
+
+// honeypotAuthEvent starts the event recording a credential offered by c
+func honeypotAuthEvent(c ssh.ConnMetadata, method string) AuditEvent {
+	event := connEvent(c, "honeypot_auth")
+	event.Method = method
+	event.ClientVersion = string(c.ClientVersion())
+	return event
+}
+
+// rejectHoneypotForward records a port forwarding attempt and refuses it
+func (s *Server) rejectHoneypotForward(conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	event := connEvent(conn, "forward")
+	req := &directTCPIPRequest{}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), req); err == nil {
+		event.Target = net.JoinHostPort(req.DestAddr, strconv.FormatUint(uint64(req.DestPort), 10))
+	}
+	event.Error = "honeypot"
+	s.audit.record(event)
+	newChannel.Reject(ssh.ConnectionFailed, "connect failed")
+}
+
+// handleHoneypotRequests services a session channel of a honeypot: exec and
+// shell requests only ever reach the fake shell, and everything the client
+// asks for is recorded
+func (s *session) handleHoneypotRequests(in <-chan *ssh.Request) {
+	shell := newHoneypotShell(s.conn.User(), s.opts.HoneypotHostname)
+	for req := range in {
+		switch req.Type {
+		case "pty-req":
+			ptyReq, err := wire.ParsePtyRequest(req.Payload)
+			if err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			s.pty = &ptyReq
+			req.Reply(true, nil)
+		case "window-change":
+			if change, err := wire.ParseWindowChange(req.Payload); err == nil {
+				s.resize(windowSize{Columns: change.Columns, Rows: change.Rows})
+			}
+		case "env":
+			name, value, err := wire.ParseEnv(req.Payload)
+			if err == nil {
+				s.env = append(s.env, name+"="+value)
+			}
+			req.Reply(err == nil, nil)
+		case "exec":
+			command, err := wire.ParseExec(req.Payload)
+			if err != nil {
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			s.recordHoneypotCommand(command)
+			out, status := shell.run(command)
+			s.channel.Write([]byte(out))
+			sendExitStatus(s.channel, status)
+			s.channel.Close()
+		case "shell":
+			req.Reply(true, nil)
+			go s.runHoneypotShell(shell)
+		case "subsystem":
+			subsystem, err := wire.ParseSubsystem(req.Payload)
+			if err == nil {
+				event := connEvent(s.conn, "subsystem")
+				event.Command = subsystem
+				event.Error = "honeypot"
+				s.audit.record(event)
+			}
+			req.Reply(false, nil)
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// recordHoneypotCommand logs a command the client tried to run
+func (s *session) recordHoneypotCommand(command string) {
+	s.logger.Info("honeypot command", "command", command)
+	event := connEvent(s.conn, "exec")
+	event.Command = command
+	s.audit.record(event)
+}
+
+// runHoneypotShell serves the fake shell until the client leaves. With a
+// terminal it behaves like an interactive bash; without one, like bash
+// reading a script piped to it, with no banner or prompts.
+func (s *session) runHoneypotShell(shell *honeypotShell) {
+	defer s.channel.Close()
+	var out io.Writer = s.channel
+	var readLine func() (string, error)
+	if s.pty != nil {
+		terminal := term.NewTerminal(s.channel, shell.prompt())
+		terminal.SetSize(int(s.pty.Columns), int(s.pty.Rows))
+		s.setResizeHandler(func(size windowSize) {
+			if size.Columns > 0 && size.Rows > 0 {
+				terminal.SetSize(int(size.Columns), int(size.Rows))
+			}
+		})
+		fmt.Fprintf(terminal, "Welcome to Ubuntu 22.04.4 LTS (GNU/Linux 5.15.0-105-generic x86_64)\n\nLast login: %s from 10.0.2.2\n",
+			time.Now().Add(-26*time.Hour).Format("Mon Jan _2 15:04:05 2006"))
+		out = terminal
+		readLine = func() (string, error) {
+			terminal.SetPrompt(shell.prompt())
+			return terminal.ReadLine()
+		}
+	} else {
+		reader := bufio.NewReader(s.channel)
+		readLine = func() (string, error) {
+			line, err := reader.ReadString('\n')
+			if err != nil && line != "" {
+				return line, nil
+			}
+			return line, err
+		}
+	}
+
+	for {
+		line, err := readLine()
+		if err != nil {
+			if err != io.EOF {
+				s.logger.Debug("honeypot shell ended", "error", err)
+			}
+			sendExitStatus(s.channel, 0)
+			return
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		s.recordHoneypotCommand(line)
+		text, status := shell.run(line)
+		io.WriteString(out, text)
+		if shell.exited {
+			sendExitStatus(s.channel, status)
+			return
+		}
+	}
+}
+
+// honeypotShell imitates a small Linux host closely enough to keep an
+// intruder typing. It never runs anything.
+type honeypotShell struct {
+	user     string
+	hostname string
+	cwd      string
+	exited   bool
+}
+
+// newHoneypotShell creates a fake shell for user starting in their home
+func newHoneypotShell(user, hostname string) *honeypotShell {
+	h := &honeypotShell{user: user, hostname: hostname}
+	h.cwd = h.home()
+	return h
+}
+
+// home returns the fake home directory of the user
+func (h *honeypotShell) home() string {
+	if h.user == "root" {
+		return "/root"
+	}
+	return "/home/" + h.user
+}
+
+// prompt returns a bash-like prompt for the current directory
+func (h *honeypotShell) prompt() string {
+	dir := h.cwd
+	if dir == h.home() {
+		dir = "~"
+	} else if strings.HasPrefix(dir, h.home()+"/") {
+		dir = "~" + strings.TrimPrefix(dir, h.home())
+	}
+	sign := "$"
+	if h.user == "root" {
+		sign = "#"
+	}
+	return fmt.Sprintf("%s@%s:%s%s ", h.user, h.hostname, dir, sign)
+}
+
+// run interprets a command line, which may chain commands with ;, && and
+// ||, and returns its output and exit status
+func (h *honeypotShell) run(line string) (string, int) {
+	var out strings.Builder
+	status := 0
+	for _, part := range splitCommandList(line) {
+		if (part.op == "&&" && status != 0) || (part.op == "||" && status == 0) {
+			continue
+		}
+		var text string
+		text, status = h.runCommand(part.command)
+		out.WriteString(text)
+		if h.exited {
+			break
+		}
+	}
+	return out.String(), status
+}
+
+// commandPart is one command of a command list and the operator before it
+type commandPart struct {
+	op      string
+	command string
+}
+
+// splitCommandList splits a command line at ;, && and || outside quotes
+func splitCommandList(line string) []commandPart {
+	var parts []commandPart
+	var quote byte
+	op, start := "", 0
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ';' || (i+1 < len(line) && (line[i:i+2] == "&&" || line[i:i+2] == "||")):
+			parts = append(parts, commandPart{op: op, command: strings.TrimSpace(line[start:i])})
+			if c == ';' {
+				op = ";"
+			} else {
+				op = line[i : i+2]
+				i++
+			}
+			start = i + 1
+		}
+	}
+	parts = append(parts, commandPart{op: op, command: strings.TrimSpace(line[start:])})
+	return parts
+}
+
+// honeypotFiles are the contents of the few files the fake shell can show
+var honeypotFiles = map[string]string{
+	"/etc/issue": "Ubuntu 22.04.4 LTS \\n \\l\n\n",
+	"/etc/os-release": `PRETTY_NAME="Ubuntu 22.04.4 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+VERSION="22.04.4 LTS (Jammy Jellyfish)"
+ID=ubuntu
+ID_LIKE=debian
+`,
+	"/etc/passwd": `root:x:0:0:root:/root:/bin/bash
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+bin:x:2:2:bin:/bin:/usr/sbin/nologin
+sys:x:3:3:sys:/dev:/usr/sbin/nologin
+www-data:x:33:33:www-data:/var/www:/usr/sbin/nologin
+sshd:x:110:65534::/run/sshd:/usr/sbin/nologin
+ubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash
+`,
+	"/proc/cpuinfo": `processor	: 0
+vendor_id	: GenuineIntel
+model name	: Intel(R) Xeon(R) CPU E5-2676 v3 @ 2.40GHz
+cpu MHz		: 2400.058
+cache size	: 30720 KB
+cpu cores	: 2
+`,
+	"/proc/version": "Linux version 5.15.0-105-generic (buildd@lcy02-amd64-007) (gcc (Ubuntu 11.4.0-1ubuntu1~22.04) 11.4.0) #115-Ubuntu SMP\n",
+}
+
+// honeypotDirs are the directories the fake shell lists and can cd into
+var honeypotDirs = map[string]string{
+	"/":     "bin  boot  dev  etc  home  lib  lib64  media  mnt  opt  proc  root  run  sbin  srv  sys  tmp  usr  var\n",
+	"/etc":  "hostname  hosts  issue  os-release  passwd  shadow  ssh\n",
+	"/home": "",
+	"/proc": "cpuinfo  meminfo  version\n",
+	"/root": "",
+	"/tmp":  "",
+	"/var":  "backups  cache  lib  log  www\n",
+}
+
+// runCommand fakes a single command
+func (h *honeypotShell) runCommand(command string) (string, int) {
+	words, err := shellWords(command)
+	if err != nil {
+		return "bash: syntax error: unexpected end of file\n", 2
+	}
+	if len(words) == 0 {
+		return "", 0
+	}
+	args := words[1:]
+	switch words[0] {
+	case "exit", "logout":
+		h.exited = true
+		return "logout\n", 0
+	case "whoami":
+		return h.user + "\n", 0
+	case "id":
+		if h.user == "root" {
+			return "uid=0(root) gid=0(root) groups=0(root)\n", 0
+		}
+		return fmt.Sprintf("uid=1000(%[1]s) gid=1000(%[1]s) groups=1000(%[1]s),27(sudo)\n", h.user), 0
+	case "hostname":
+		return h.hostname + "\n", 0
+	case "uname":
+		if len(args) > 0 && (args[0] == "-a" || args[0] == "--all") {
+			return fmt.Sprintf("Linux %s 5.15.0-105-generic #115-Ubuntu SMP Mon Apr 15 09:52:04 UTC 2024 x86_64 x86_64 x86_64 GNU/Linux\n", h.hostname), 0
+		}
+		return "Linux\n", 0
+	case "pwd":
+		return h.cwd + "\n", 0
+	case "cd":
+		dir := h.home()
+		if len(args) > 0 {
+			dir = h.resolve(args[0])
+		}
+		if _, ok := honeypotDirs[dir]; !ok && dir != h.home() {
+			return fmt.Sprintf("bash: cd: %s: No such file or directory\n", args[0]), 1
+		}
+		h.cwd = dir
+		return "", 0
+	case "ls", "dir":
+		dir := h.cwd
+		for _, arg := range args {
+			if !strings.HasPrefix(arg, "-") {
+				dir = h.resolve(arg)
+			}
+		}
+		if dir == "/home" && h.user != "root" {
+			return h.user + "\n", 0
+		}
+		if listing, ok := honeypotDirs[dir]; ok || dir == h.home() {
+			return listing, 0
+		}
+		return fmt.Sprintf("ls: cannot access '%s': No such file or directory\n", dir), 2
+	case "cat", "head", "tail", "more", "less":
+		var out strings.Builder
+		status := 0
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "-") {
+				continue
+			}
+			file := h.resolve(arg)
+			if file == "/etc/hostname" {
+				out.WriteString(h.hostname + "\n")
+				continue
+			}
+			if contents, ok := honeypotFiles[file]; ok {
+				out.WriteString(contents)
+				continue
+			}
+			if _, ok := honeypotDirs[file]; ok {
+				fmt.Fprintf(&out, "%s: %s: Is a directory\n", words[0], arg)
+			} else if file == "/etc/shadow" {
+				fmt.Fprintf(&out, "%s: %s: Permission denied\n", words[0], arg)
+			} else {
+				fmt.Fprintf(&out, "%s: %s: No such file or directory\n", words[0], arg)
+			}
+			status = 1
+		}
+		return out.String(), status
+	case "echo":
+		return strings.Join(args, " ") + "\n", 0
+	case "uptime":
+		return time.Now().Format(" 15:04:05") + " up 41 days,  3:17,  1 user,  load average: 0.08, 0.03, 0.01\n", 0
+	case "w", "who":
+		return fmt.Sprintf("%s  pts/0        %s (10.0.2.2)\n", h.user, time.Now().Format("2006-01-02 15:04")), 0
+	case "ps":
+		return "    PID TTY          TIME CMD\n   2211 pts/0    00:00:00 bash\n   2342 pts/0    00:00:00 ps\n", 0
+	case "free":
+		return "               total        used        free      shared  buff/cache   available\nMem:         4014360      612244     2390836        1028     1011280     3147788\nSwap:              0           0           0\n", 0
+	case "nproc":
+		return "2\n", 0
+	case "history", "export", "unset", "clear", "true", "sleep", "chmod", "mkdir", "touch", "rm", "kill":
+		return "", 0
+	case "false":
+		return "", 1
+	case "sudo", "su":
+		return fmt.Sprintf("[sudo] password for %s: \nSorry, try again.\n", h.user), 1
+	case "wget":
+		if len(args) > 0 {
+			return fmt.Sprintf("--%s--  %s\nResolving host... failed: Temporary failure in name resolution.\n", time.Now().Format("2006-01-02 15:04:05"), args[len(args)-1]), 4
+		}
+		return "wget: missing URL\n", 1
+	case "curl":
+		return "curl: (6) Could not resolve host\n", 6
+	}
+	if strings.Contains(words[0], "/") {
+		return fmt.Sprintf("bash: %s: Permission denied\n", words[0]), 126
+	}
+	return fmt.Sprintf("bash: %s: command not found\n", words[0]), 127
+}
+
+// resolve makes a path given to a fake command absolute
+func (h *honeypotShell) resolve(name string) string {
+	if name == "~" || strings.HasPrefix(name, "~/") {
+		name = h.home() + strings.TrimPrefix(name, "~")
+	}
+	if !path.IsAbs(name) {
+		name = path.Join(h.cwd, name)
+	}
+	return path.Clean(name)
+}
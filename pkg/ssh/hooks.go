@@ -0,0 +1,96 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Hooks are callbacks run at points of the connection and session lifecycle.
+// Every hook is optional. Hooks that return an error veto the step they guard.
+type Hooks struct {
+	// OnAuth runs once a client has completed authentication, having proven
+	// it holds its key; method is the one that completed the login.
+	// Returning an error closes the connection before any channel is opened.
+	// The connection has no context yet, so the one passed here is never
+	// cancelled.
+	OnAuth func(ctx SessionContext, method string) error
+	// OnSessionStart runs when a client opens a session channel. Returning an
+	// error rejects the channel.
+	OnSessionStart func(ctx SessionContext) error
+	// OnExec runs before an exec request or forced command is run, with the
+	// command line that will run. Returning an error refuses the command; the
+	// error text is sent to the client, which sees exit status 1.
+	OnExec func(ctx SessionContext, command string) error
+	// OnSessionEnd runs when a session channel closes
+	OnSessionEnd func(ctx SessionContext, duration time.Duration)
+	// OnDisconnect runs when an authenticated connection closes
+	OnDisconnect func(ctx SessionContext, duration time.Duration)
+	// OnHandshakeFailure runs when a connection closes before logging in, or
+	// when OnAuth rejects its login.
+	// err wraps ErrHandshake and, when the client's keys were refused,
+	// ErrUnauthorizedKey.
+	OnHandshakeFailure func(remoteAddr net.Addr, err error)
+}
+
+// authMethodExtension records in the permissions of a login the method that
+// completed it, for the OnAuth hook and the auth_success audit event
+const authMethodExtension = "auth-method"
+
+// instrumentAuth makes the callbacks of config record their method in the
+// permissions they grant. The OnAuth hook only runs once the handshake is
+// over: a public key callback also answers clients asking whether a key
+// would do, before any signature proves they hold it.
+func (h Hooks) instrumentAuth(config *ssh.ServerConfig) {
+	config.PublicKeyCallback = withAuthMethod("publickey", config.PublicKeyCallback)
+	config.PasswordCallback = withAuthMethod("password", config.PasswordCallback)
+	config.KeyboardInteractiveCallback = withAuthMethod("keyboard-interactive", config.KeyboardInteractiveCallback)
+}
+
+// withAuthMethod wraps an authentication callback so that the permissions it
+// grants record method. Callbacks offered as the next factor after a partial
+// success are wrapped too, so the method that completes the login is recorded.
+func withAuthMethod[T any](method string, callback func(ssh.ConnMetadata, T) (*ssh.Permissions, error)) func(ssh.ConnMetadata, T) (*ssh.Permissions, error) {
+	if callback == nil {
+		return nil
+	}
+	return func(c ssh.ConnMetadata, credential T) (*ssh.Permissions, error) {
+		perms, err := callback(c, credential)
+		var partial *ssh.PartialSuccessError
+		if errors.As(err, &partial) {
+			next := partial.Next
+			next.PublicKeyCallback = withAuthMethod("publickey", next.PublicKeyCallback)
+			next.PasswordCallback = withAuthMethod("password", next.PasswordCallback)
+			next.KeyboardInteractiveCallback = withAuthMethod("keyboard-interactive", next.KeyboardInteractiveCallback)
+			return nil, &ssh.PartialSuccessError{Next: next}
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The permissions may be shared, so the extension goes on a copy
+		granted := &ssh.Permissions{Extensions: map[string]string{}}
+		if perms != nil {
+			granted.CriticalOptions = perms.CriticalOptions
+			maps.Copy(granted.Extensions, perms.Extensions)
+		}
+		granted.Extensions[authMethodExtension] = method
+		return granted, nil
+	}
+}
+
+// authorize runs the OnAuth hook for a connection that has logged in
+func (h Hooks) authorize(conn *ssh.ServerConn) error {
+	if h.OnAuth == nil {
+		return nil
+	}
+	ctx := newSessionContext(context.Background(), conn, nil)
+	if err := h.OnAuth(ctx, conn.Permissions.Extensions[authMethodExtension]); err != nil {
+		return fmt.Errorf("login for %q rejected: %s", conn.User(), err)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+// pkg/ssh/hooks_test.go
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hookRecorder collects the hook calls made by a server
+type hookRecorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (h *hookRecorder) add(call string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.calls = append(h.calls, call)
+}
+
+// waitFor waits until call has been recorded
+func (h *hookRecorder) waitFor(t *testing.T, call string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		h.mu.Lock()
+		for _, c := range h.calls {
+			if c == call {
+				h.mu.Unlock()
+				return
+			}
+		}
+		h.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("hook call %q not seen, got %v", call, h.calls)
+}
+
+func TestServerHooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var recorded hookRecorder
+	hooks := Hooks{
+		OnAuth: func(ctx SessionContext, method string) error {
+			recorded.add("auth " + ctx.User() + " " + method)
+			if ctx.User() == "mallory" {
+				return errors.New("user is suspended")
+			}
+			return nil
+		},
+		OnSessionStart: func(ctx SessionContext) error {
+			recorded.add("session start " + ctx.User())
+			return nil
+		},
+		OnExec: func(ctx SessionContext, command string) error {
+			recorded.add("exec " + command)
+			return nil
+		},
+		OnSessionEnd: func(ctx SessionContext, duration time.Duration) {
+			recorded.add("session end " + ctx.User())
+		},
+		OnDisconnect: func(ctx SessionContext, duration time.Duration) {
+			recorded.add("disconnect " + ctx.User())
+		},
+	}
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Hooks: hooks})
+	client := dialTestServer(t, addr, "alice", signer)
+	if out := runTestCommand(t, client, "whoami"); out != "You are: alice\n" {
+		t.Errorf("whoami output = %q", out)
+	}
+	client.Close()
+
+	for _, call := range []string{"auth alice publickey", "session start alice", "exec whoami", "session end alice", "disconnect alice"} {
+		recorded.waitFor(t, call)
+	}
+
+	// A rejected login is disconnected before it can open a channel
+	mallory, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "mallory",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if err == nil {
+		_, err = mallory.NewSession()
+		mallory.Close()
+	}
+	if err == nil {
+		t.Error("OnAuth returning an error should reject the login")
+	}
+}
+
+// unsignedSigner offers a public key without being able to sign with it,
+// like a client that only knows someone's public key
+type unsignedSigner struct {
+	ssh.Signer
+}
+
+func (unsignedSigner) Sign(io.Reader, []byte) (*ssh.Signature, error) {
+	return nil, errors.New("no private key")
+}
+
+func TestAuthHookNeedsSignature(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var recorded hookRecorder
+	hooks := Hooks{
+		OnAuth: func(ctx SessionContext, method string) error {
+			recorded.add("auth " + method)
+			return nil
+		},
+		OnHandshakeFailure: func(remoteAddr net.Addr, err error) {
+			recorded.add("handshake failure")
+		},
+	}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Hooks: hooks})
+
+	// The server accepts the key when asked about it, but the client can't
+	// prove it holds it
+	_, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(unsignedSigner{signer})},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if err == nil {
+		t.Fatal("login without a signature succeeded")
+	}
+	recorded.waitFor(t, "handshake failure")
+	recorded.mu.Lock()
+	defer recorded.mu.Unlock()
+	for _, call := range recorded.calls {
+		if call == "auth publickey" {
+			t.Error("OnAuth ran for a key whose holder never signed")
+		}
+	}
+}
+
+func TestServerHooksVeto(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hooks := Hooks{
+		OnSessionStart: func(ctx SessionContext) error {
+			if len(ctx.Env()) != 0 {
+				return errors.New("unexpected environment")
+			}
+			return nil
+		},
+		OnExec: func(ctx SessionContext, command string) error {
+			return errors.New("maintenance window, try later")
+		},
+	}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Hooks: hooks})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	out, err := session.CombinedOutput("whoami")
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 1 {
+		t.Errorf("vetoed exec error = %v, want exit status 1", err)
+	}
+	if !strings.Contains(string(out), "maintenance window") {
+		t.Errorf("vetoed exec output = %q, want the hook's error", out)
+	}
+
+	hooks = Hooks{OnSessionStart: func(ctx SessionContext) error { return errors.New("no sessions") }}
+	_, addr, signer, _ = startTestServer(t, ctx, ServerOptions{Hooks: hooks})
+	other := dialTestServer(t, addr, "alice", signer)
+	defer other.Close()
+	if _, err := other.NewSession(); err == nil || !strings.Contains(err.Error(), "no sessions") {
+		t.Errorf("NewSession error = %v, want the OnSessionStart rejection", err)
+	}
+}
+
+func TestAuthHookSeesSecondFactor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var recorded hookRecorder
+	hooks := Hooks{OnAuth: func(ctx SessionContext, method string) error {
+		recorded.add(method + " " + ctx.Fingerprint())
+		return nil
+	}}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{TOTP: true, TOTPSecretDir: newTestTOTPDir(t, "alice"), Hooks: hooks})
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User: "alice",
+		Auth: []ssh.AuthMethod{
+			ssh.PublicKeys(signer),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				return []string{totpCode(rfcSecret, uint64(time.Now().Unix())/totpPeriod)}, nil
+			}),
+		},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	client.Close()
+
+	// The hook runs once, for the factor that completed the login, and sees
+	// the permissions granted by the first factor
+	recorded.waitFor(t, "keyboard-interactive "+ssh.FingerprintSHA256(signer.PublicKey()))
+	if len(recorded.calls) != 1 {
+		t.Errorf("OnAuth calls = %v, want only the completing factor", recorded.calls)
+	}
+}
@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// hostKeyTypes lists the host key algorithms generated by EnsureHostKeys,
+// with the file name each key is stored under
+var hostKeyTypes = []struct {
+	keyType string
+	file    string
+}{
+	{"rsa", "ssh_host_rsa_key"},
+	{"ecdsa", "ssh_host_ecdsa_key"},
+	{"ed25519", "ssh_host_ed25519_key"},
+}
+
+// GenerateHostKey generates a host private key of the given type (rsa,
+// ecdsa or ed25519), with the defaults of GenerateKeys, and returns it PEM
+// encoded in OpenSSH format
+func GenerateHostKey(keyType string) ([]byte, error) {
+	key, err := generateKey(KeyOptions{Type: keyType})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(block), nil
+}
+
+// EnsureHostKeys loads the RSA, ECDSA and Ed25519 host keys from dir,
+// generating and saving any that do not exist yet, and returns all three
+func EnsureHostKeys(dir string) ([][]byte, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	var keys [][]byte
+	for _, t := range hostKeyTypes {
+		path := filepath.Join(dir, t.file)
+		key, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			if key, err = GenerateHostKey(t.keyType); err != nil {
+				return nil, err
+			}
+			if err = writeHostKey(path, key); err != nil {
+				return nil, err
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// writeHostKey saves a private host key and its public half next to it
+func writeHostKey(path string, key []byte) error {
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, key, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(signer.PublicKey()), 0o644)
+}
+
+// parseHostKeys parses PEM encoded host private keys into signers
+func parseHostKeys(keys [][]byte) ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+	for _, key := range keys {
+		if len(key) == 0 {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: ParsePrivateKey error: %s", ErrHostKeyParse, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("%w: no host keys configured", ErrHostKeyParse)
+	}
+	return signers, nil
+}
+
+// addHostCertificates pairs each host certificate with the host key it
+// certifies and adds a signer presenting it
+func addHostCertificates(signers []ssh.Signer, certs [][]byte) ([]ssh.Signer, error) {
+	keys := len(signers)
+	for _, data := range certs {
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: parse host certificate: %s", ErrHostKeyParse, err)
+		}
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.HostCert {
+			return nil, fmt.Errorf("%w: host certificate is not an SSH host certificate", ErrHostKeyParse)
+		}
+		var certSigner ssh.Signer
+		for _, signer := range signers[:keys] {
+			if bytes.Equal(signer.PublicKey().Marshal(), cert.Key.Marshal()) {
+				if certSigner, err = ssh.NewCertSigner(cert, signer); err != nil {
+					return nil, fmt.Errorf("%w: host certificate: %s", ErrHostKeyParse, err)
+				}
+				break
+			}
+		}
+		if certSigner == nil {
+			return nil, fmt.Errorf("%w: no host key for the certificate of %s", ErrHostKeyParse, ssh.FingerprintSHA256(cert.Key))
+		}
+		signers = append(signers, certSigner)
+	}
+	return signers, nil
+}
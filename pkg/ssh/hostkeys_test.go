@@ -0,0 +1,206 @@
+// pkg/ssh/hostkeys_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateHostKey(t *testing.T) {
+	tests := []struct {
+		keyType string
+		want    string
+	}{
+		{"ecdsa", ssh.KeyAlgoECDSA256},
+		{"ed25519", ssh.KeyAlgoED25519},
+	}
+
+	for _, tt := range tests {
+		key, err := GenerateHostKey(tt.keyType)
+		if err != nil {
+			t.Fatalf("GenerateHostKey(%q) error = %v", tt.keyType, err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			t.Fatalf("generated %s key does not parse: %v", tt.keyType, err)
+		}
+		if got := signer.PublicKey().Type(); got != tt.want {
+			t.Errorf("GenerateHostKey(%q) key type = %s, want %s", tt.keyType, got, tt.want)
+		}
+	}
+
+	if _, err := GenerateHostKey("dsa"); err == nil {
+		t.Error("GenerateHostKey should reject unsupported key types")
+	}
+
+	// RSA host keys are the size GenerateKeys makes them
+	key, err := GenerateHostKey("rsa")
+	if err != nil {
+		t.Fatalf("GenerateHostKey(%q) error = %v", "rsa", err)
+	}
+	raw, err := ssh.ParseRawPrivateKey(key)
+	if err != nil {
+		t.Fatalf("generated rsa key does not parse: %v", err)
+	}
+	if bits := raw.(*rsa.PrivateKey).N.BitLen(); bits != defaultRSABits {
+		t.Errorf("GenerateHostKey(%q) key is %d bits, want %d", "rsa", bits, defaultRSABits)
+	}
+}
+
+func TestEnsureHostKeys(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "hostkeys")
+
+	keys, err := EnsureHostKeys(dir)
+	if err != nil {
+		t.Fatalf("EnsureHostKeys() error = %v", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("EnsureHostKeys() returned %d keys, want 3", len(keys))
+	}
+	for _, name := range []string{"ssh_host_rsa_key", "ssh_host_ecdsa_key", "ssh_host_ed25519_key"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("%s was not written: %v", name, err)
+		}
+		if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+			t.Errorf("%s mode = %v, want 0600", name, info.Mode().Perm())
+		}
+		if _, err := os.Stat(filepath.Join(dir, name+".pub")); err != nil {
+			t.Errorf("%s.pub was not written: %v", name, err)
+		}
+	}
+
+	again, err := EnsureHostKeys(dir)
+	if err != nil {
+		t.Fatalf("second EnsureHostKeys() error = %v", err)
+	}
+	for i := range keys {
+		if !bytes.Equal(keys[i], again[i]) {
+			t.Errorf("existing host key %d was regenerated", i)
+		}
+	}
+}
+
+func TestServerOffersEveryHostKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ecdsaKey, _ := GenerateHostKey("ecdsa")
+	ed25519Key, _ := GenerateHostKey("ed25519")
+	_, clientSigner := newTestKeyPair(t)
+
+	server, err := NewServer(nil, ssh.MarshalAuthorizedKey(clientSigner.PublicKey()), ServerOptions{
+		HostKeys: [][]byte{ecdsaKey, ed25519Key},
+	})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(ctx, listener)
+
+	for _, algo := range []string{ssh.KeyAlgoECDSA256, ssh.KeyAlgoED25519} {
+		var seen string
+		client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:              "alice",
+			Auth:              []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+			HostKeyAlgorithms: []string{algo},
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				seen = key.Type()
+				return nil
+			},
+			Timeout: time.Second,
+		})
+		if err != nil {
+			t.Errorf("client limited to %s could not connect: %v", algo, err)
+			continue
+		}
+		client.Close()
+		if seen != algo {
+			t.Errorf("host key type = %s, want %s", seen, algo)
+		}
+	}
+}
+
+func TestNewServerRequiresHostKey(t *testing.T) {
+	if _, err := NewServer(nil, nil, ServerOptions{}); err == nil {
+		t.Error("NewServer should fail without any host key")
+	}
+}
+
+func TestServerPresentsHostCertificate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hostKey, hostSigner := newTestKeyPair(t)
+	_, ca := newTestKeyPair(t)
+	_, clientSigner := newTestKeyPair(t)
+	cert := &ssh.Certificate{
+		Key:             hostSigner.PublicKey(),
+		CertType:        ssh.HostCert,
+		ValidPrincipals: []string{"127.0.0.1"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("SignCert failed: %v", err)
+	}
+
+	_, otherSigner := newTestKeyPair(t)
+	otherCert := *cert
+	otherCert.Key = otherSigner.PublicKey()
+	otherCert.SignCert(rand.Reader, ca)
+	userCert := *cert
+	userCert.CertType = ssh.UserCert
+	userCert.SignCert(rand.Reader, ca)
+	for name, certs := range map[string][][]byte{
+		"garbage":    {[]byte("not a certificate")},
+		"plain key":  {ssh.MarshalAuthorizedKey(clientSigner.PublicKey())},
+		"user cert":  {ssh.MarshalAuthorizedKey(&userCert)},
+		"other host": {ssh.MarshalAuthorizedKey(&otherCert)},
+	} {
+		if _, err := NewServer(hostKey, nil, ServerOptions{HostCertificates: certs}); err == nil {
+			t.Errorf("NewServer accepted the %s host certificate", name)
+		}
+	}
+
+	server, err := NewServer(hostKey, ssh.MarshalAuthorizedKey(clientSigner.PublicKey()), ServerOptions{
+		HostCertificates: [][]byte{ssh.MarshalAuthorizedKey(cert)},
+	})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(ctx, listener)
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, address string) bool {
+			return bytes.Equal(auth.Marshal(), ca.PublicKey().Marshal())
+		},
+	}
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:              "alice",
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+		HostKeyAlgorithms: []string{ssh.CertAlgoED25519v01},
+		HostKeyCallback:   checker.CheckHostKey,
+		Timeout:           time.Second,
+	})
+	if err != nil {
+		t.Fatalf("client trusting the host CA could not connect: %v", err)
+	}
+	client.Close()
+}
@@ -0,0 +1,169 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// idleWarning is how long before an idle disconnect the client is warned
+const idleWarning = time.Minute
+
+// idleNotice is where an idle session is warned before it is closed
+type idleNotice int32
+
+const (
+	// noIdleNotice closes the session without a word, keeping text out of
+	// the protocol stream of subsystems such as sftp
+	noIdleNotice idleNotice = iota
+	// terminalIdleNotice writes to the terminal of a session with a PTY
+	terminalIdleNotice
+	// stderrIdleNotice writes to the stderr of a command without a PTY,
+	// leaving its output intact
+	stderrIdleNotice
+)
+
+// idleChannel wraps a session channel and records when data last flowed in
+// either direction, so sessions without traffic can be closed
+type idleChannel struct {
+	ssh.Channel
+	timeout      time.Duration
+	logger       *slog.Logger
+	lastActivity atomic.Int64
+	// notice is the idleNotice of the session, none until it starts a
+	// shell or command
+	notice atomic.Int32
+}
+
+// newIdleChannel wraps channel, starting the idle clock now
+func newIdleChannel(channel ssh.Channel, timeout time.Duration, logger *slog.Logger) *idleChannel {
+	c := &idleChannel{Channel: channel, timeout: timeout, logger: logger}
+	c.touch()
+	return c
+}
+
+// touch marks the channel as active
+func (c *idleChannel) touch() {
+	c.lastActivity.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the channel has been without traffic
+func (c *idleChannel) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastActivity.Load()))
+}
+
+func (c *idleChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+func (c *idleChannel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+// Stderr returns the extended data stream, also counted as traffic
+func (c *idleChannel) Stderr() io.ReadWriter {
+	return &idleStream{stream: c.Channel.Stderr(), channel: c}
+}
+
+// idleStream records traffic on a channel's stderr stream
+type idleStream struct {
+	stream  io.ReadWriter
+	channel *idleChannel
+}
+
+func (s *idleStream) Read(p []byte) (int, error) {
+	n, err := s.stream.Read(p)
+	if n > 0 {
+		s.channel.touch()
+	}
+	return n, err
+}
+
+func (s *idleStream) Write(p []byte) (int, error) {
+	n, err := s.stream.Write(p)
+	if n > 0 {
+		s.channel.touch()
+	}
+	return n, err
+}
+
+// notify writes message where the session's client will see it, if
+// anywhere. It goes to the underlying channel so the notice itself is not
+// activity.
+func (c *idleChannel) notify(message string) {
+	switch idleNotice(c.notice.Load()) {
+	case terminalIdleNotice:
+		fmt.Fprintf(c.Channel, "\r\n%s\r\n", message)
+	case stderrIdleNotice:
+		fmt.Fprintln(c.Channel.Stderr(), message)
+	}
+}
+
+// startIdleNotices has the idle timeout of a session starting a shell or
+// command warn on its terminal, or on its stderr when it has no PTY.
+// Subsystems are never warned.
+func (s *session) startIdleNotices() {
+	if s.idle == nil {
+		return
+	}
+	notice := stderrIdleNotice
+	if s.pty != nil {
+		notice = terminalIdleNotice
+	}
+	s.idle.notice.Store(int32(notice))
+}
+
+// warnAfter returns the idle time after which the client is warned: one
+// minute before the timeout, or halfway through timeouts shorter than that
+func (c *idleChannel) warnAfter() time.Duration {
+	if c.timeout > 2*idleWarning {
+		return c.timeout - idleWarning
+	}
+	return c.timeout / 2
+}
+
+// watch closes the channel once it has been idle for the timeout, warning the
+// client beforehand where its notice says. It returns when done is closed or the channel is closed.
+func (c *idleChannel) watch(done <-chan struct{}) {
+	interval := c.timeout / 10
+	if interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	warned := false
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		idle := c.idleFor()
+		switch {
+		case idle >= c.timeout:
+			c.logger.Info("closing idle session", "idle", idle.Round(time.Second).String())
+			c.notify(fmt.Sprintf("Session idle for %s, disconnecting.", c.timeout))
+			c.Channel.Close()
+			return
+		case idle >= c.warnAfter() && !warned:
+			warned = true
+			c.notify(fmt.Sprintf("Session idle, disconnecting in %s without activity.", (c.timeout - idle).Round(time.Second)))
+		case idle < c.warnAfter():
+			warned = false
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// pkg/ssh/idle_test.go
+package ssh
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestIdleChannelWarnAfter(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{15 * time.Minute, 14 * time.Minute},
+		{90 * time.Second, 45 * time.Second},
+		{time.Second, 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		c := &idleChannel{timeout: tt.timeout}
+		if got := c.warnAfter(); got != tt.want {
+			t.Errorf("warnAfter() with timeout %s = %s, want %s", tt.timeout, got, tt.want)
+		}
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{IdleTimeout: 400 * time.Millisecond})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("RequestPty failed: %v", err)
+	}
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+
+	// Keep the session busy past the timeout, then go quiet
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		stdin.Write([]byte("\r"))
+	}
+
+	quiet := time.Now()
+	done := make(chan string, 1)
+	go func() {
+		out, _ := io.ReadAll(stdout)
+		done <- string(out)
+	}()
+
+	select {
+	case out := <-done:
+		if time.Since(quiet) < 200*time.Millisecond {
+			t.Error("session was closed while it was still active")
+		}
+		if !strings.Contains(out, "disconnecting in") {
+			t.Errorf("output %q should contain an idle warning", out)
+		}
+		if !strings.Contains(out, "disconnecting.") {
+			t.Errorf("output %q should contain the disconnect notice", out)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle session was not closed")
+	}
+}
+
+func TestServerIdleTimeoutExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Shell: "/bin/sh", IdleTimeout: 400 * time.Millisecond})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	var stdout, stderr strings.Builder
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	stdin, _ := session.StdinPipe()
+	defer stdin.Close()
+
+	// A command waiting on its input, without a PTY
+	done := make(chan struct{})
+	go func() {
+		session.Run("cat")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("idle session was not closed")
+	}
+
+	// The notices go to stderr, leaving the command's output alone
+	if stdout.String() != "" {
+		t.Errorf("stdout = %q, want the command's output only", stdout.String())
+	}
+	if got := stderr.String(); !strings.Contains(got, "disconnecting in") || !strings.Contains(got, "disconnecting.") || strings.Contains(got, "\r") {
+		t.Errorf("stderr = %q, want the idle warning and disconnect notice as lines", got)
+	}
+}
+
+func TestServerIdleTimeoutSubsystem(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{SFTP: true, SFTPRoot: t.TempDir(), IdleTimeout: 400 * time.Millisecond})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	stdout, _ := session.StdoutPipe()
+	stderr, _ := session.StderrPipe()
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		t.Fatalf("RequestSubsystem failed: %v", err)
+	}
+
+	// The SFTP server waits for the client's init packet, which never comes
+	done := make(chan []byte, 2)
+	for _, r := range []io.Reader{stdout, stderr} {
+		go func(r io.Reader) {
+			out, _ := io.ReadAll(r)
+			done <- out
+		}(r)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case out := <-done:
+			if len(out) != 0 {
+				t.Errorf("idle SFTP session was sent %q, which would corrupt its protocol stream", out)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("idle SFTP session was not closed")
+		}
+	}
+}
@@ -0,0 +1,81 @@
+// Package bcryptpbkdf implements bcrypt_pbkdf, the key derivation function
+// OpenSSH encrypts private keys under: PBKDF2 with a bcrypt-based
+// pseudorandom function, whose output bytes are spread across the blocks
+// of the derived key.
+package bcryptpbkdf
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/blowfish"
+)
+
+// hashSize is the size of the output of bcryptHash
+const hashSize = 32
+
+// magic is the text bcryptHash encrypts
+var magic = []byte("OxychromaticBlowfishSwatDynamite")
+
+// Key derives a key of keyLen bytes from password and salt, iterating the
+// bcrypt hash rounds times
+func Key(password, salt []byte, rounds, keyLen int) ([]byte, error) {
+	switch {
+	case rounds < 1:
+		return nil, errors.New("bcrypt_pbkdf: rounds must be at least 1")
+	case len(password) == 0:
+		return nil, errors.New("bcrypt_pbkdf: empty password")
+	case len(salt) == 0 || len(salt) > 1<<20:
+		return nil, errors.New("bcrypt_pbkdf: bad salt length")
+	case keyLen < 1 || keyLen > 1024:
+		return nil, errors.New("bcrypt_pbkdf: bad key length")
+	}
+
+	blocks := (keyLen + hashSize - 1) / hashSize
+	key := make([]byte, blocks*hashSize)
+	sumPassword := sha512.Sum512(password)
+	count := make([]byte, 4)
+	hash := make([]byte, hashSize)
+	for block := 1; block <= blocks; block++ {
+		binary.BigEndian.PutUint32(count, uint32(block))
+		sumSalt := sha512.Sum512(append(append([]byte{}, salt...), count...))
+		bcryptHash(hash, sumPassword[:], sumSalt[:])
+		out := append([]byte{}, hash...)
+		for i := 1; i < rounds; i++ {
+			sumSalt = sha512.Sum512(hash)
+			bcryptHash(hash, sumPassword[:], sumSalt[:])
+			for j := range out {
+				out[j] ^= hash[j]
+			}
+		}
+		// Byte j of the block goes to position j of the blocks-byte stride
+		for j, b := range out {
+			key[j*blocks+block-1] = b
+		}
+	}
+	return key[:keyLen], nil
+}
+
+// bcryptHash writes the bcrypt hash of magic, with the blowfish state
+// expanded from the hashed password and salt, to out
+func bcryptHash(out, sumPassword, sumSalt []byte) {
+	c, err := blowfish.NewSaltedCipher(sumPassword, sumSalt)
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 64; i++ {
+		blowfish.ExpandKey(sumSalt, c)
+		blowfish.ExpandKey(sumPassword, c)
+	}
+	copy(out, magic)
+	for i := 0; i < hashSize; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(out[i:i+8], out[i:i+8])
+		}
+	}
+	// OpenBSD reads the words of the ciphertext little-endian
+	for i := 0; i < hashSize; i += 4 {
+		binary.LittleEndian.PutUint32(out[i:], binary.BigEndian.Uint32(out[i:]))
+	}
+}
@@ -0,0 +1,45 @@
+// pkg/ssh/internal/bcryptpbkdf/bcryptpbkdf_test.go
+package bcryptpbkdf
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestKey(t *testing.T) {
+	// Vectors from OpenBSD's bcrypt_pbkdf
+	tests := []struct {
+		password, salt string
+		rounds         int
+		want           string
+	}{
+		{"password", "salt", 12, "1ae42c05d487bc02f64921a4ebe4ea93bcacfe135fda99974c06b7b01fae149a"},
+		{"passwordy\x00PASSWORD\x00", "salty\x00SALT\x00", 3, "7f310bd3e78c3280c59ce4595211a2928e8d4ec744c1ed2efc9f764e3388e0ad"},
+		{"секретное слово", "посолить немножко", 8, "8df43fc6fe131fc47f0c9e39224bd94c70b6fcc8ee8135faddf61156e6cb2733ea765f315a3e1e4afc35bf8687d189254c1e05a6fe80c0617f9183d67260d6a115c6c94e3603e2303fbb43a76a64523ffda686b1d4518543"},
+	}
+	for _, tt := range tests {
+		want, _ := hex.DecodeString(tt.want)
+		got, err := Key([]byte(tt.password), []byte(tt.salt), tt.rounds, len(want))
+		if err != nil {
+			t.Errorf("Key(%q, %q, %d) failed: %v", tt.password, tt.salt, tt.rounds, err)
+			continue
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("Key(%q, %q, %d) = %x, want %s", tt.password, tt.salt, tt.rounds, got, tt.want)
+		}
+	}
+
+	for _, bad := range []struct {
+		password, salt string
+		rounds, keyLen int
+	}{
+		{"password", "salt", 0, 32},
+		{"", "salt", 16, 32},
+		{"password", "", 16, 32},
+		{"password", "salt", 16, 2048},
+	} {
+		if _, err := Key([]byte(bad.password), []byte(bad.salt), bad.rounds, bad.keyLen); err == nil {
+			t.Errorf("Key(%q, %q, %d, %d) should fail", bad.password, bad.salt, bad.rounds, bad.keyLen)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package wire
+
+import "fmt"
+
+// PtyRequest is the payload of a "pty-req" request (RFC 4254 section 6.2)
+type PtyRequest struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
+	// Modes holds the encoded terminal modes (RFC 4254 section 8)
+	Modes string
+}
+
+// WindowChange is the payload of a "window-change" request (RFC 4254 section 6.7)
+type WindowChange struct {
+	Columns  uint32
+	Rows     uint32
+	WidthPx  uint32
+	HeightPx uint32
+}
+
+// X11Request is the payload of an "x11-req" request (RFC 4254 section 6.3.1)
+type X11Request struct {
+	SingleConnection bool
+	AuthProtocol     string
+	// AuthCookie is the hex encoded cookie of the client's X server
+	AuthCookie   string
+	ScreenNumber uint32
+}
+
+// ParseExec decodes the command line of an "exec" request
+func ParseExec(payload []byte) (string, error) {
+	return parseString("exec", payload)
+}
+
+// ParseSubsystem decodes the subsystem name of a "subsystem" request
+func ParseSubsystem(payload []byte) (string, error) {
+	return parseString("subsystem", payload)
+}
+
+// ParseSignal decodes the signal name, without "SIG", of a "signal" request
+func ParseSignal(payload []byte) (string, error) {
+	return parseString("signal", payload)
+}
+
+// ParseEnv decodes the variable name and value of an "env" request
+func ParseEnv(payload []byte) (name, value string, err error) {
+	r := NewReader(payload)
+	name = r.String()
+	value = r.String()
+	if err := r.Done(); err != nil {
+		return "", "", fmt.Errorf("malformed env request: %s", err)
+	}
+	return name, value, nil
+}
+
+// ParsePtyRequest decodes a "pty-req" request
+func ParsePtyRequest(payload []byte) (PtyRequest, error) {
+	r := NewReader(payload)
+	req := PtyRequest{
+		Term:     r.String(),
+		Columns:  r.Uint32(),
+		Rows:     r.Uint32(),
+		WidthPx:  r.Uint32(),
+		HeightPx: r.Uint32(),
+		Modes:    r.String(),
+	}
+	if err := r.Done(); err != nil {
+		return PtyRequest{}, fmt.Errorf("malformed pty-req: %s", err)
+	}
+	return req, nil
+}
+
+// ParseWindowChange decodes a "window-change" request
+func ParseWindowChange(payload []byte) (WindowChange, error) {
+	r := NewReader(payload)
+	change := WindowChange{
+		Columns:  r.Uint32(),
+		Rows:     r.Uint32(),
+		WidthPx:  r.Uint32(),
+		HeightPx: r.Uint32(),
+	}
+	if err := r.Done(); err != nil {
+		return WindowChange{}, fmt.Errorf("malformed window-change: %s", err)
+	}
+	return change, nil
+}
+
+// ParseX11Request decodes an "x11-req" request
+func ParseX11Request(payload []byte) (X11Request, error) {
+	r := NewReader(payload)
+	req := X11Request{
+		SingleConnection: r.Bool(),
+		AuthProtocol:     r.String(),
+		AuthCookie:       r.String(),
+		ScreenNumber:     r.Uint32(),
+	}
+	if err := r.Done(); err != nil {
+		return X11Request{}, fmt.Errorf("malformed x11-req: %s", err)
+	}
+	return req, nil
+}
+
+// ExitStatus encodes the payload of an "exit-status" request
+func ExitStatus(code uint32) []byte {
+	return new(Writer).Uint32(code).Bytes()
+}
+
+// ExitSignal encodes the payload of an "exit-signal" request. The signal is
+// named without "SIG", e.g. "TERM".
+func ExitSignal(signal string, coreDumped bool, message string) []byte {
+	return new(Writer).String(signal).Bool(coreDumped).String(message).String("").Bytes()
+}
+
+// parseString decodes a payload made of a single string
+func parseString(kind string, payload []byte) (string, error) {
+	r := NewReader(payload)
+	s := r.String()
+	if err := r.Done(); err != nil {
+		return "", fmt.Errorf("malformed %s request: %s", kind, err)
+	}
+	return s, nil
+}
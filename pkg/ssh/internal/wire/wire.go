@@ -0,0 +1,112 @@
+// Package wire decodes and encodes the payloads of SSH channel requests in
+// the binary format of RFC 4251 section 5: big-endian uint32s, single-byte
+// booleans and strings carrying a uint32 length prefix.
+package wire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// errShort is returned when a payload ends in the middle of a field
+var errShort = errors.New("payload too short")
+
+// Reader reads fields from a payload in order
+type Reader struct {
+	buf []byte
+	err error
+}
+
+// NewReader returns a Reader over payload
+func NewReader(payload []byte) *Reader {
+	return &Reader{buf: payload}
+}
+
+// Uint32 reads a big-endian uint32
+func (r *Reader) Uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.buf) < 4 {
+		r.err = errShort
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v
+}
+
+// Bool reads a single-byte boolean
+func (r *Reader) Bool() bool {
+	if r.err != nil {
+		return false
+	}
+	if len(r.buf) < 1 {
+		r.err = errShort
+		return false
+	}
+	v := r.buf[0] != 0
+	r.buf = r.buf[1:]
+	return v
+}
+
+// String reads a length-prefixed string
+func (r *Reader) String() string {
+	n := r.Uint32()
+	if r.err != nil {
+		return ""
+	}
+	if uint64(len(r.buf)) < uint64(n) {
+		r.err = errShort
+		return ""
+	}
+	v := string(r.buf[:n])
+	r.buf = r.buf[n:]
+	return v
+}
+
+// Done returns the first error met while reading, or an error if the
+// payload has bytes left after the last field
+func (r *Reader) Done() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.buf) > 0 {
+		return fmt.Errorf("%d unexpected trailing bytes", len(r.buf))
+	}
+	return nil
+}
+
+// Writer builds a payload field by field
+type Writer struct {
+	buf []byte
+}
+
+// Uint32 appends a big-endian uint32
+func (w *Writer) Uint32(v uint32) *Writer {
+	w.buf = binary.BigEndian.AppendUint32(w.buf, v)
+	return w
+}
+
+// Bool appends a single-byte boolean
+func (w *Writer) Bool(v bool) *Writer {
+	if v {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+	return w
+}
+
+// String appends a length-prefixed string
+func (w *Writer) String(v string) *Writer {
+	w.Uint32(uint32(len(v)))
+	w.buf = append(w.buf, v...)
+	return w
+}
+
+// Bytes returns the payload built so far
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
@@ -0,0 +1,105 @@
+// pkg/ssh/internal/wire/wire_test.go
+package wire
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseString(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    string
+		wantErr bool
+	}{
+		{"short command", ssh.Marshal(struct{ Command string }{"ls"}), "ls", false},
+		{"six byte command", ssh.Marshal(struct{ Command string }{"whoami"}), "whoami", false},
+		{"long command", ssh.Marshal(struct{ Command string }{strings.Repeat("x", 300)}), strings.Repeat("x", 300), false},
+		{"empty command", ssh.Marshal(struct{ Command string }{""}), "", false},
+		{"no length", []byte{0, 0}, "", true},
+		{"truncated", []byte{0, 0, 0, 6, 'w', 'h'}, "", true},
+		{"trailing bytes", append(ssh.Marshal(struct{ Command string }{"ls"}), 0), "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExec(tt.payload)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseExec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseExec() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	payload := ssh.Marshal(struct{ Name, Value string }{"LANG", "en_US.UTF-8"})
+	name, value, err := ParseEnv(payload)
+	if err != nil || name != "LANG" || value != "en_US.UTF-8" {
+		t.Errorf("ParseEnv() = %q, %q, %v", name, value, err)
+	}
+	if _, _, err := ParseEnv(payload[:len(payload)-1]); err == nil {
+		t.Error("ParseEnv() of a truncated payload should fail")
+	}
+}
+
+func TestParsePtyRequest(t *testing.T) {
+	want := PtyRequest{Term: "xterm-256color", Columns: 120, Rows: 40, WidthPx: 960, HeightPx: 640, Modes: "\x00"}
+	got, err := ParsePtyRequest(ssh.Marshal(want))
+	if err != nil {
+		t.Fatalf("ParsePtyRequest() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("ParsePtyRequest() = %+v, want %+v", got, want)
+	}
+	if _, err := ParsePtyRequest(ssh.Marshal(struct{ Term string }{"xterm"})); err == nil {
+		t.Error("ParsePtyRequest() without a window size should fail")
+	}
+}
+
+func TestParseWindowChange(t *testing.T) {
+	want := WindowChange{Columns: 80, Rows: 24}
+	got, err := ParseWindowChange(ssh.Marshal(want))
+	if err != nil || got != want {
+		t.Errorf("ParseWindowChange() = %+v, %v, want %+v", got, err, want)
+	}
+}
+
+func TestParseX11Request(t *testing.T) {
+	want := X11Request{AuthProtocol: "MIT-MAGIC-COOKIE-1", AuthCookie: "0123456789abcdef", ScreenNumber: 1}
+	got, err := ParseX11Request(ssh.Marshal(want))
+	if err != nil || got != want {
+		t.Errorf("ParseX11Request() = %+v, %v, want %+v", got, err, want)
+	}
+	if _, err := ParseX11Request(ssh.Marshal(struct{ Single bool }{true})); err == nil {
+		t.Error("ParseX11Request() without a cookie should fail")
+	}
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	payload := new(Writer).String("hello").Bool(true).Uint32(42).Bytes()
+	want := ssh.Marshal(struct {
+		S string
+		B bool
+		N uint32
+	}{"hello", true, 42})
+	if string(payload) != string(want) {
+		t.Errorf("Writer payload = %x, want %x", payload, want)
+	}
+
+	r := NewReader(payload)
+	if s, b, n := r.String(), r.Bool(), r.Uint32(); s != "hello" || !b || n != 42 {
+		t.Errorf("Reader read %q, %v, %d", s, b, n)
+	}
+	if err := r.Done(); err != nil {
+		t.Errorf("Done() error = %v", err)
+	}
+	if ExitStatus(3)[3] != 3 || len(ExitStatus(3)) != 4 {
+		t.Errorf("ExitStatus(3) = %x", ExitStatus(3))
+	}
+}
@@ -0,0 +1,83 @@
+package ssh
+
+import (
+	"log/slog"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKeepAliveCountMax is how many keepalive requests may go unanswered
+// before a client is disconnected, as in OpenSSH's ClientAliveCountMax
+const DefaultKeepAliveCountMax = 3
+
+// keepAliveRequest is the global request OpenSSH servers probe clients with.
+// Clients answer it, usually with a failure, without acting on it.
+const keepAliveRequest = "keepalive@openssh.com"
+
+// keepAliveCountMax returns KeepAliveCountMax with its default applied
+func (o ServerOptions) keepAliveCountMax() int {
+	if o.KeepAliveCountMax <= 0 {
+		return DefaultKeepAliveCountMax
+	}
+	return o.KeepAliveCountMax
+}
+
+// setTCPKeepAlive applies TCPKeepAlive to an accepted TCP connection; other
+// connections, such as Unix sockets, are left alone
+func (o ServerOptions) setTCPKeepAlive(conn net.Conn) error {
+	tcp, ok := conn.(*net.TCPConn)
+	if !ok || o.TCPKeepAlive == 0 {
+		return nil
+	}
+	if o.TCPKeepAlive < 0 {
+		return tcp.SetKeepAlive(false)
+	}
+	return tcp.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     o.TCPKeepAlive,
+		Interval: o.TCPKeepAlive,
+		Count:    o.keepAliveCountMax(),
+	})
+}
+
+// keepAlive probes the client every KeepAliveInterval and closes the
+// connection once KeepAliveCountMax intervals pass without an answer, so
+// connections to vanished clients do not hold their sessions open. It
+// returns when done is closed or the connection is closed.
+func (s *Server) keepAlive(done <-chan struct{}, conn ssh.Conn, logger *slog.Logger) {
+	ticker := time.NewTicker(s.opts.KeepAliveInterval)
+	defer ticker.Stop()
+
+	answered := make(chan struct{}, 1)
+	pending, missed := false, 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-answered:
+			pending, missed = false, 0
+			continue
+		case <-ticker.C:
+		}
+
+		// Only one request is outstanding at a time; every interval it
+		// stays unanswered counts as a missed response
+		if pending {
+			if missed++; missed >= s.opts.keepAliveCountMax() {
+				logger.Info("closing unresponsive connection", "missed_keepalives", missed)
+				s.audit.record(connEvent(conn, "keepalive_timeout"))
+				conn.Close()
+				return
+			}
+			continue
+		}
+		pending = true
+		go func() {
+			if _, _, err := conn.SendRequest(keepAliveRequest, true, nil); err == nil {
+				answered <- struct{}{}
+			}
+		}()
+	}
+}
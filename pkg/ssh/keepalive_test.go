@@ -0,0 +1,95 @@
+// pkg/ssh/keepalive_test.go
+package ssh
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSetTCPKeepAlive(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer client.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+
+	for _, period := range []time.Duration{0, 30 * time.Second, -1} {
+		if err := (ServerOptions{TCPKeepAlive: period}).setTCPKeepAlive(conn); err != nil {
+			t.Errorf("setTCPKeepAlive(%s) error = %v", period, err)
+		}
+	}
+	pipe, _ := net.Pipe()
+	defer pipe.Close()
+	if err := (ServerOptions{TCPKeepAlive: time.Second}).setTCPKeepAlive(pipe); err != nil {
+		t.Errorf("setTCPKeepAlive on a non-TCP connection error = %v, want it ignored", err)
+	}
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit := &lockedBuffer{}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{
+		KeepAliveInterval: 50 * time.Millisecond,
+		KeepAliveCountMax: 2,
+		TCPKeepAlive:      time.Minute,
+		AuditLog:          audit,
+	})
+
+	// ssh.Client answers keepalive requests, so it stays connected
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	// A client connection whose global requests are never read stops
+	// answering, like a peer that vanished
+	nConn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer nConn.Close()
+	silent, _, _, err := ssh.NewClientConn(nConn, addr, &ssh.ClientConfig{
+		User:            "bob",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		t.Fatalf("NewClientConn failed: %v", err)
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		silent.Wait()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the unresponsive client was not disconnected")
+	}
+	timeout := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "keepalive_timeout" })
+	if timeout.User != "bob" {
+		t.Errorf("keepalive_timeout event = %+v, want the unresponsive user", timeout)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("responsive client should stay connected: %v", err)
+	}
+	session.Close()
+}
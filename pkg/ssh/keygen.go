@@ -1,27 +1,197 @@
 package ssh
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
 
 	"golang.org/x/crypto/ssh"
 )
 
-// GenerateKeys generates a new SSH key pair
-func GenerateKeys() ([]byte, []byte, error) {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
+// DefaultKeyType is the type of key generated when none is asked for
+const DefaultKeyType = "ed25519"
+
+// RSA key sizes GenerateKeys accepts, and uses when none is given
+const (
+	minRSABits     = 2048
+	maxRSABits     = 16384
+	defaultRSABits = 4096
+)
+
+// KeyOptions describes the key pair GenerateKeys creates
+type KeyOptions struct {
+	// Type is ed25519, ecdsa or rsa, DefaultKeyType when empty
+	Type string
+	// Curve is the NIST curve of an ecdsa key: p256, the default, p384 or p521
+	Curve string
+	// Bits is the size of an rsa key, 2048 to 16384 bits and 4096 when zero.
+	// It picks the curve of an ecdsa key given none, and must match the
+	// one given; ed25519 keys are always 256 bits.
+	Bits int
+	// Comment ends the authorized_keys line of the public key, and is kept
+	// in OpenSSH format private keys, to tell keys apart
+	Comment string
+	// Passphrase encrypts the private key unless empty
+	Passphrase string
+	// Rounds is the number of bcrypt rounds deriving the encryption key from
+	// the passphrase, DefaultKDFRounds when zero; more rounds make guessing
+	// the passphrase, and loading the key, slower
+	Rounds int
+	// Format is the encoding of the private key: openssh, the default, pem
+	// for the legacy PKCS#1 (rsa) or SEC 1 (ecdsa) blocks, or pkcs8. Only
+	// openssh keys can be encrypted.
+	Format string
+}
+
+// ecdsaCurves are the curves of ecdsa keys by name
+var ecdsaCurves = map[string]elliptic.Curve{
+	"p256": elliptic.P256(),
+	"p384": elliptic.P384(),
+	"p521": elliptic.P521(),
+}
+
+// GenerateKeys generates a new SSH key pair. The private key is PEM encoded
+// in the format of opts, OpenSSH private key format by default, and the
+// public key is in authorized_keys format, with the comment.
+func GenerateKeys(opts KeyOptions) ([]byte, []byte, error) {
+	if opts.Rounds != 0 && opts.Passphrase == "" {
+		return nil, nil, errors.New("key derivation rounds only apply to keys encrypted with a passphrase")
+	}
+	opts.Format = strings.ToLower(opts.Format)
+	switch opts.Format {
+	case "", "openssh":
+	case "pem", "pkcs8":
+		if opts.Passphrase != "" {
+			return nil, nil, fmt.Errorf("%s keys can't be encrypted: use the openssh format", opts.Format)
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported private key format %q: use openssh, pem or pkcs8", opts.Format)
+	}
+	privateKey, err := generateKey(opts)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	privateKeyPEM := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)}
-
-	pubKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	privateKeyPEM, err := marshalGeneratedKey(privateKey, opts)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return pem.EncodeToMemory(privateKeyPEM), ssh.MarshalAuthorizedKey(pubKey), nil
+	return pem.EncodeToMemory(privateKeyPEM), authorizedKeyLine(signer.PublicKey(), opts.Comment), nil
+}
+
+// generateKey generates the private key of the type, curve and size of opts
+func generateKey(opts KeyOptions) (crypto.PrivateKey, error) {
+	keyType := opts.Type
+	if keyType == "" {
+		keyType = DefaultKeyType
+	}
+	if opts.Curve != "" && keyType != "ecdsa" {
+		return nil, fmt.Errorf("a curve only applies to ecdsa keys, not %s", keyType)
+	}
+	switch keyType {
+	case "ed25519":
+		if opts.Bits != 0 && opts.Bits != 256 {
+			return nil, fmt.Errorf("ed25519 keys are always 256 bits, not %d", opts.Bits)
+		}
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	case "ecdsa":
+		curve, err := parseCurve(opts.Curve, opts.Bits)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case "rsa":
+		bits := opts.Bits
+		if bits == 0 {
+			bits = defaultRSABits
+		}
+		if bits < minRSABits || bits > maxRSABits {
+			return nil, fmt.Errorf("rsa keys must be %d to %d bits, not %d", minRSABits, maxRSABits, bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+	return nil, fmt.Errorf("unsupported key type %q", keyType)
+}
+
+// marshalGeneratedKey encodes a private key GenerateKeys generated in the
+// format of opts, encrypted when opts has a passphrase
+func marshalGeneratedKey(privateKey crypto.PrivateKey, opts KeyOptions) (*pem.Block, error) {
+	switch opts.Format {
+	case "pem":
+		switch key := privateKey.(type) {
+		case *rsa.PrivateKey:
+			return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}, nil
+		case *ecdsa.PrivateKey:
+			der, err := x509.MarshalECPrivateKey(key)
+			if err != nil {
+				return nil, err
+			}
+			return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+		}
+		return nil, errors.New("ed25519 keys have no pem format: use openssh or pkcs8")
+	case "pkcs8":
+		der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	}
+	if opts.Passphrase != "" {
+		rounds := opts.Rounds
+		if rounds == 0 {
+			rounds = DefaultKDFRounds
+		}
+		return marshalEncryptedPrivateKey(privateKey, opts.Comment, []byte(opts.Passphrase), rounds)
+	}
+	return ssh.MarshalPrivateKey(privateKey, opts.Comment)
+}
+
+// authorizedKeyLine returns the authorized_keys line of pub, ending with
+// comment unless it is empty
+func authorizedKeyLine(pub ssh.PublicKey, comment string) []byte {
+	line := bytes.TrimSpace(ssh.MarshalAuthorizedKey(pub))
+	if comment != "" {
+		line = append(append(line, ' '), comment...)
+	}
+	return append(line, '\n')
+}
+
+// parseCurve returns the NIST curve named p256, p384 or p521, also written
+// as P-384 or nistp384, or else the curve of that many bits, defaulting to
+// p256. A curve of a different size than bits is refused.
+func parseCurve(name string, bits int) (elliptic.Curve, error) {
+	if name == "" {
+		switch bits {
+		case 0, 256:
+			return elliptic.P256(), nil
+		case 384:
+			return elliptic.P384(), nil
+		case 521:
+			return elliptic.P521(), nil
+		}
+		return nil, fmt.Errorf("ecdsa keys are 256, 384 or 521 bits, not %d", bits)
+	}
+	key := strings.TrimPrefix(strings.ReplaceAll(strings.ToLower(name), "-", ""), "nist")
+	curve, ok := ecdsaCurves[key]
+	if !ok {
+		return nil, fmt.Errorf("unsupported curve %q: use p256, p384 or p521", name)
+	}
+	if bits != 0 && bits != curve.Params().BitSize {
+		return nil, fmt.Errorf("curve %s is %d bits, not %d", name, curve.Params().BitSize, bits)
+	}
+	return curve, nil
 }
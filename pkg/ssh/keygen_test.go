@@ -12,7 +12,7 @@ import (
 
 func TestGenerateKeys(t *testing.T) {
 	// Test key generation
-	privateKey, publicKey, err := GenerateKeys()
+	privateKey, publicKey, err := GenerateKeys(KeyOptions{Type: "rsa", Format: "pem"})
 	if err != nil {
 		t.Fatalf("Key generation failed: %v", err)
 	}
@@ -46,7 +46,7 @@ func TestGenerateKeys(t *testing.T) {
 
 func TestGenerateKeysMatchingPair(t *testing.T) {
 	// Generate a key pair
-	privateKeyBytes, publicKeyBytes, err := GenerateKeys()
+	privateKeyBytes, publicKeyBytes, err := GenerateKeys(KeyOptions{Type: "rsa", Format: "pem"})
 	if err != nil {
 		t.Fatalf("Key generation failed: %v", err)
 	}
@@ -78,3 +78,101 @@ func TestGenerateKeysMatchingPair(t *testing.T) {
 		t.Error("Public key doesn't match the one derived from private key")
 	}
 }
+
+func TestGenerateKeysTypes(t *testing.T) {
+	tests := []struct {
+		opts      KeyOptions
+		wantPEM   string
+		wantType  string
+		wantError bool
+	}{
+		{opts: KeyOptions{}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoED25519},
+		{opts: KeyOptions{Type: "ed25519"}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoED25519},
+		{opts: KeyOptions{Type: "ecdsa"}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoECDSA256},
+		{opts: KeyOptions{Type: "ecdsa", Curve: "p384"}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoECDSA384},
+		{opts: KeyOptions{Type: "ecdsa", Curve: "P-521"}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoECDSA521},
+		{opts: KeyOptions{Type: "ecdsa", Curve: "nistp256"}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoECDSA256},
+		{opts: KeyOptions{Type: "rsa"}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoRSA},
+		{opts: KeyOptions{Type: "ecdsa", Bits: 384}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoECDSA384},
+		{opts: KeyOptions{Type: "ecdsa", Curve: "p521", Bits: 521}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoECDSA521},
+		{opts: KeyOptions{Type: "rsa", Bits: 2048}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoRSA},
+		{opts: KeyOptions{Type: "rsa", Bits: 2048, Format: "pem"}, wantPEM: "RSA PRIVATE KEY", wantType: ssh.KeyAlgoRSA},
+		{opts: KeyOptions{Type: "rsa", Bits: 2048, Format: "pkcs8"}, wantPEM: "PRIVATE KEY", wantType: ssh.KeyAlgoRSA},
+		{opts: KeyOptions{Type: "ecdsa", Format: "PEM"}, wantPEM: "EC PRIVATE KEY", wantType: ssh.KeyAlgoECDSA256},
+		{opts: KeyOptions{Type: "ecdsa", Format: "pkcs8"}, wantPEM: "PRIVATE KEY", wantType: ssh.KeyAlgoECDSA256},
+		{opts: KeyOptions{Type: "ed25519", Format: "pkcs8"}, wantPEM: "PRIVATE KEY", wantType: ssh.KeyAlgoED25519},
+		{opts: KeyOptions{Type: "ed25519", Format: "openssh"}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoED25519},
+		{opts: KeyOptions{Type: "ed25519", Format: "pem"}, wantError: true},
+		{opts: KeyOptions{Type: "rsa", Format: "pkcs8", Passphrase: "hunter2"}, wantError: true},
+		{opts: KeyOptions{Type: "ed25519", Format: "rfc4716"}, wantError: true},
+		{opts: KeyOptions{Type: "ed25519", Bits: 256}, wantPEM: "OPENSSH PRIVATE KEY", wantType: ssh.KeyAlgoED25519},
+		{opts: KeyOptions{Type: "ecdsa", Curve: "p224"}, wantError: true},
+		{opts: KeyOptions{Type: "ecdsa", Bits: 512}, wantError: true},
+		{opts: KeyOptions{Type: "ecdsa", Curve: "p256", Bits: 384}, wantError: true},
+		{opts: KeyOptions{Type: "rsa", Bits: 1024}, wantError: true},
+		{opts: KeyOptions{Type: "rsa", Bits: 32768}, wantError: true},
+		{opts: KeyOptions{Type: "ed25519", Bits: 4096}, wantError: true},
+		{opts: KeyOptions{Type: "ed25519", Curve: "p384"}, wantError: true},
+		{opts: KeyOptions{Type: "dsa"}, wantError: true},
+	}
+	for _, tt := range tests {
+		privateKey, publicKey, err := GenerateKeys(tt.opts)
+		if (err != nil) != tt.wantError {
+			t.Errorf("GenerateKeys(%+v) error = %v, want error: %v", tt.opts, err, tt.wantError)
+			continue
+		}
+		if tt.wantError {
+			continue
+		}
+		if block, _ := pem.Decode(privateKey); block == nil || block.Type != tt.wantPEM {
+			t.Errorf("GenerateKeys(%+v) private key is not a %s PEM block", tt.opts, tt.wantPEM)
+		}
+		signer, err := ssh.ParsePrivateKey(privateKey)
+		if err != nil {
+			t.Errorf("GenerateKeys(%+v) private key is invalid: %v", tt.opts, err)
+			continue
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+		if err != nil {
+			t.Errorf("GenerateKeys(%+v) public key is invalid: %v", tt.opts, err)
+			continue
+		}
+		if pub.Type() != tt.wantType || !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+			t.Errorf("GenerateKeys(%+v) public key %s doesn't match the private key, want %s", tt.opts, pub.Type(), tt.wantType)
+		}
+	}
+}
+
+func TestGenerateKeysRSABits(t *testing.T) {
+	for _, bits := range []int{0, 2048} {
+		privateKey, _, err := GenerateKeys(KeyOptions{Type: "rsa", Bits: bits, Format: "pem"})
+		if err != nil {
+			t.Fatalf("GenerateKeys with %d bits failed: %v", bits, err)
+		}
+		block, _ := pem.Decode(privateKey)
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("Generated private key is invalid: %v", err)
+		}
+		want := map[int]int{0: 4096, 2048: 2048}[bits]
+		if got := key.N.BitLen(); got != want {
+			t.Errorf("GenerateKeys with %d bits made a %d-bit key, want %d", bits, got, want)
+		}
+	}
+}
+
+func TestGenerateKeysComment(t *testing.T) {
+	for _, comment := range []string{"", "alice@laptop"} {
+		_, publicKey, err := GenerateKeys(KeyOptions{Comment: comment})
+		if err != nil {
+			t.Fatalf("Key generation failed: %v", err)
+		}
+		if fields := bytes.Fields(publicKey); len(fields) != map[bool]int{false: 2, true: 3}[comment != ""] {
+			t.Errorf("public key with comment %q = %q", comment, publicKey)
+		}
+		_, got, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+		if err != nil || got != comment {
+			t.Errorf("public key comment = %q, %v, want %q", got, err, comment)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// unixScheme prefixes listen endpoints that are Unix socket paths
+const unixScheme = "unix://"
+
+// Listen opens a listen endpoint: host:port (optionally tcp://host:port) for
+// TCP, or unix:///path/to/socket for a Unix socket. A socket file left behind
+// by a server that exited is replaced, but one still in use is not.
+func Listen(endpoint string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(endpoint, unixScheme); ok {
+		if path == "" {
+			return nil, fmt.Errorf("%w: %q has no socket path", ErrListen, endpoint)
+		}
+		if err := removeStaleSocket(path); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrListen, err)
+		}
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrListen, err)
+		}
+		return listener, nil
+	}
+	listener, err := net.Listen("tcp", strings.TrimPrefix(endpoint, "tcp://"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrListen, err)
+	}
+	return listener, nil
+}
+
+// ListenAll opens every endpoint with Listen. If one fails, those already
+// opened are closed again.
+func ListenAll(endpoints []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		listener, err := Listen(endpoint)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// removeStaleSocket removes the Unix socket at path unless a server still
+// accepts connections on it
+func removeStaleSocket(path string) error {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is in use", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove stale socket: %s", err)
+	}
+	return nil
+}
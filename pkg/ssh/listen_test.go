@@ -0,0 +1,127 @@
+// pkg/ssh/listen_test.go
+package ssh
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestListen(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		endpoint string
+		network  string
+		wantErr  bool
+	}{
+		{"host and port", "127.0.0.1:0", "tcp", false},
+		{"tcp scheme", "tcp://127.0.0.1:0", "tcp", false},
+		{"unix socket", "unix://" + filepath.Join(dir, "gossh.sock"), "unix", false},
+		{"unix without path", "unix://", "", true},
+		{"invalid address", "127.0.0.1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := Listen(tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Listen(%q) error = %v, wantErr %v", tt.endpoint, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer listener.Close()
+			if got := listener.Addr().Network(); got != tt.network {
+				t.Errorf("Listen(%q) network = %q, want %q", tt.endpoint, got, tt.network)
+			}
+		})
+	}
+}
+
+func TestListenUnixSocketReuse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gossh.sock")
+
+	// A file left behind by a crashed server is replaced
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	listener, err := Listen("unix://" + path)
+	if err != nil {
+		t.Fatalf("Listen over a stale file failed: %v", err)
+	}
+	defer listener.Close()
+
+	// A socket a running server accepts on is not
+	if _, err := Listen("unix://" + path); err == nil {
+		t.Error("Listen should refuse a socket that is in use")
+	}
+}
+
+func TestListenAllClosesOnFailure(t *testing.T) {
+	first := "127.0.0.1:" + freePort(t)
+	if _, err := ListenAll([]string{first, "unix://"}); err == nil {
+		t.Fatal("ListenAll should fail when an endpoint cannot be opened")
+	}
+	// The endpoint opened before the failure was released again
+	listener, err := net.Listen("tcp", first)
+	if err != nil {
+		t.Fatalf("first endpoint still in use: %v", err)
+	}
+	listener.Close()
+}
+
+func TestServerListenAddrs(t *testing.T) {
+	hostKey, _ := newTestKeyPair(t)
+	_, clientSigner := newTestKeyPair(t)
+	tcpAddr := "127.0.0.1:" + freePort(t)
+	socket := filepath.Join(t.TempDir(), "gossh.sock")
+
+	server, err := NewServer(hostKey, ssh.MarshalAuthorizedKey(clientSigner.PublicKey()), ServerOptions{
+		ListenAddrs: []string{tcpAddr, "unix://" + socket},
+	})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.Start(context.Background()) }()
+
+	client := dialTestServer(t, tcpAddr, "alice", clientSigner)
+	defer client.Close()
+	if out := runTestCommand(t, client, "whoami"); out != "You are: alice\n" {
+		t.Errorf("whoami over TCP = %q", out)
+	}
+
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		t.Fatalf("Failed to dial the Unix socket: %v", err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, socket, &ssh.ClientConfig{
+		User:            "bob",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(clientSigner)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("Handshake over the Unix socket failed: %v", err)
+	}
+	unixClient := ssh.NewClient(sshConn, chans, reqs)
+	defer unixClient.Close()
+	if out := runTestCommand(t, unixClient, "whoami"); out != "You are: bob\n" {
+		t.Errorf("whoami over the Unix socket = %q", out)
+	}
+
+	if got := len(server.Addrs()); got != 2 {
+		t.Errorf("Addrs() returned %d addresses, want 2", got)
+	}
+
+	server.Close()
+	waitServeResult(t, done)
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket file should be removed when the server stops, Stat error = %v", err)
+	}
+}
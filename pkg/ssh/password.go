@@ -0,0 +1,93 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordStore verifies the passwords presented during password authentication
+type PasswordStore interface {
+	// CheckPassword reports whether password is valid for user
+	CheckPassword(user string, password []byte) bool
+}
+
+// PasswordFunc adapts a plain function to the PasswordStore interface
+type PasswordFunc func(user string, password []byte) bool
+
+// CheckPassword calls f(user, password)
+func (f PasswordFunc) CheckPassword(user string, password []byte) bool {
+	return f(user, password)
+}
+
+// StaticPasswords is a PasswordStore backed by an in-memory map of
+// plaintext passwords, intended for tests and lab environments
+type StaticPasswords map[string]string
+
+// CheckPassword compares the password in constant time
+func (s StaticPasswords) CheckPassword(user string, password []byte) bool {
+	expected, ok := s[user]
+	if !ok {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), password) == 1
+}
+
+// BcryptPasswords is a PasswordStore of bcrypt hashes keyed by user
+type BcryptPasswords map[string][]byte
+
+// dummyHash is compared against for unknown users so that response timing
+// does not reveal which usernames exist
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("gossh-dummy-password"), bcrypt.DefaultCost)
+
+// CheckPassword verifies the password against the user's bcrypt hash
+func (b BcryptPasswords) CheckPassword(user string, password []byte) bool {
+	hash, ok := b[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, password)
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, password) == nil
+}
+
+// LoadPasswordFile reads a password file of "user:bcrypt-hash" lines, the
+// format produced by `htpasswd -nB`. Blank lines and # comments are ignored.
+func LoadPasswordFile(path string) (BcryptPasswords, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePasswordFile(data)
+}
+
+// ParsePasswordFile parses the contents of a password file
+func ParsePasswordFile(data []byte) (BcryptPasswords, error) {
+	passwords := BcryptPasswords{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("password file line %d: expected user:hash", lineNo)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("password file line %d: %s", lineNo, err)
+		}
+		passwords[user] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return passwords, nil
+}
@@ -0,0 +1,114 @@
+// pkg/ssh/password_test.go
+package ssh
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestStaticPasswords(t *testing.T) {
+	store := StaticPasswords{"alice": "s3cret"}
+
+	tests := []struct {
+		user, password string
+		want           bool
+	}{
+		{"alice", "s3cret", true},
+		{"alice", "wrong", false},
+		{"alice", "", false},
+		{"bob", "s3cret", false},
+	}
+
+	for _, tt := range tests {
+		if got := store.CheckPassword(tt.user, []byte(tt.password)); got != tt.want {
+			t.Errorf("CheckPassword(%q, %q) = %v, want %v", tt.user, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestPasswordFunc(t *testing.T) {
+	store := PasswordFunc(func(user string, password []byte) bool {
+		return user == "svc" && string(password) == "token"
+	})
+	if !store.CheckPassword("svc", []byte("token")) {
+		t.Error("PasswordFunc should accept matching credentials")
+	}
+	if store.CheckPassword("svc", []byte("other")) {
+		t.Error("PasswordFunc should reject other credentials")
+	}
+}
+
+func TestParsePasswordFile(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword failed: %v", err)
+	}
+	data := []byte("# gossh users\n\nalice:" + string(hash) + "\n")
+
+	store, err := ParsePasswordFile(data)
+	if err != nil {
+		t.Fatalf("ParsePasswordFile() error = %v", err)
+	}
+	if !store.CheckPassword("alice", []byte("hunter2")) {
+		t.Error("expected alice's password to be accepted")
+	}
+	if store.CheckPassword("alice", []byte("hunter3")) {
+		t.Error("expected a wrong password to be rejected")
+	}
+	if store.CheckPassword("mallory", []byte("hunter2")) {
+		t.Error("expected an unknown user to be rejected")
+	}
+
+	invalid := []string{
+		"alice",
+		":" + string(hash),
+		"alice:plaintext",
+	}
+	for _, line := range invalid {
+		if _, err := ParsePasswordFile([]byte(line)); err == nil {
+			t.Errorf("ParsePasswordFile(%q) should fail", line)
+		}
+	}
+}
+
+func TestServerPasswordAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		password string
+		wantOK   bool
+	}{
+		{"disabled by default", false, "s3cret", false},
+		{"correct password", true, "s3cret", true},
+		{"wrong password", true, "nope", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, addr, _, _ := startTestServer(t, ctx, ServerOptions{
+				PasswordAuth: tt.enabled,
+				Passwords:    StaticPasswords{"alice": "s3cret"},
+			})
+
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User:            "alice",
+				Auth:            []ssh.AuthMethod{ssh.Password(tt.password)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         time.Second,
+			})
+			if client != nil {
+				client.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("Dial error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh/internal/bcryptpbkdf"
+	"github.com/bxtal-lsn/gossh/pkg/ssh/internal/wire"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKDFRounds is the number of bcrypt rounds passphrase-protected
+// private keys are encrypted with when none is given, as ssh-keygen does
+const DefaultKDFRounds = 16
+
+// openSSHKeyMagic starts the body of an OpenSSH format private key
+const openSSHKeyMagic = "openssh-key-v1\x00"
+
+// openSSHKey is the body of an OpenSSH format private key after the magic
+// (PROTOCOL.key in the OpenSSH sources)
+type openSSHKey struct {
+	CipherName   string
+	KdfName      string
+	KdfOpts      string
+	NumKeys      uint32
+	PubKey       []byte
+	PrivKeyBlock []byte
+}
+
+// privateKeyFieldCounts is the number of private key fields, strings or
+// mpints, following each key type in an OpenSSH format private key
+var privateKeyFieldCounts = map[string]int{
+	ssh.KeyAlgoED25519:  2,
+	ssh.KeyAlgoRSA:      6,
+	ssh.KeyAlgoECDSA256: 3,
+	ssh.KeyAlgoECDSA384: 3,
+	ssh.KeyAlgoECDSA521: 3,
+	ssh.KeyAlgoDSA:      5,
+}
+
+// PrivateKeyComment returns the comment kept in an OpenSSH format private
+// key, decrypting it with passphrase when the key is encrypted with
+// aes256-ctr under bcrypt_pbkdf, as gossh and ssh-keygen encrypt keys. Keys
+// in other formats have no comment, and those that can't be decrypted give
+// an empty one.
+func PrivateKeyComment(pemBytes, passphrase []byte) string {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "OPENSSH PRIVATE KEY" || !bytes.HasPrefix(block.Bytes, []byte(openSSHKeyMagic)) {
+		return ""
+	}
+	var key openSSHKey
+	if err := ssh.Unmarshal(block.Bytes[len(openSSHKeyMagic):], &key); err != nil || key.NumKeys != 1 {
+		return ""
+	}
+	private := key.PrivKeyBlock
+	if key.CipherName != "none" {
+		var err error
+		if private, err = decryptPrivateKeyBlock(key, passphrase); err != nil {
+			return ""
+		}
+	}
+	r := wire.NewReader(private)
+	// The check values only match when decryption worked
+	if r.Uint32() != r.Uint32() {
+		return ""
+	}
+	fields, ok := privateKeyFieldCounts[r.String()]
+	if !ok {
+		return ""
+	}
+	for i := 0; i < fields; i++ {
+		_ = r.String()
+	}
+	// A truncated key reads as an empty comment
+	return r.String()
+}
+
+// decryptPrivateKeyBlock decrypts the private key block of an aes256-ctr
+// encrypted OpenSSH format private key with passphrase
+func decryptPrivateKeyBlock(key openSSHKey, passphrase []byte) ([]byte, error) {
+	if key.CipherName != "aes256-ctr" || key.KdfName != "bcrypt" {
+		return nil, fmt.Errorf("unsupported private key cipher %s with %s", key.CipherName, key.KdfName)
+	}
+	var kdf struct {
+		Salt   string
+		Rounds uint32
+	}
+	if err := ssh.Unmarshal([]byte(key.KdfOpts), &kdf); err != nil {
+		return nil, err
+	}
+	derived, err := bcryptpbkdf.Key(passphrase, []byte(kdf.Salt), int(kdf.Rounds), 32+aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, err
+	}
+	private := make([]byte, len(key.PrivKeyBlock))
+	cipher.NewCTR(block, derived[32:]).XORKeyStream(private, key.PrivKeyBlock)
+	return private, nil
+}
+
+// marshalEncryptedPrivateKey encodes key in OpenSSH private key format,
+// with comment, encrypted with aes256-ctr under a key bcrypt_pbkdf derives
+// from passphrase in the given number of rounds
+func marshalEncryptedPrivateKey(key crypto.PrivateKey, comment string, passphrase []byte, rounds int) (*pem.Block, error) {
+	if rounds < 1 {
+		return nil, fmt.Errorf("key derivation rounds must be at least 1, not %d", rounds)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := privateKeyFields(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Two copies of a random check value tell a wrong passphrase apart
+	// from a corrupt key when decrypting
+	check := make([]byte, 4)
+	salt := make([]byte, 16)
+	if _, err := rand.Read(check); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	checkValue := binary.BigEndian.Uint32(check)
+	private := ssh.Marshal(struct{ Check1, Check2 uint32 }{checkValue, checkValue})
+	private = append(private, fields...)
+	private = append(private, ssh.Marshal(struct{ Comment string }{comment})...)
+	for i := 1; len(private)%aes.BlockSize != 0; i++ {
+		private = append(private, byte(i))
+	}
+
+	derived, err := bcryptpbkdf.Key(passphrase, salt, rounds, 32+aes.BlockSize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, err
+	}
+	cipher.NewCTR(block, derived[32:]).XORKeyStream(private, private)
+
+	body := ssh.Marshal(openSSHKey{
+		CipherName: "aes256-ctr",
+		KdfName:    "bcrypt",
+		KdfOpts: string(ssh.Marshal(struct {
+			Salt   string
+			Rounds uint32
+		}{string(salt), uint32(rounds)})),
+		NumKeys:      1,
+		PubKey:       signer.PublicKey().Marshal(),
+		PrivKeyBlock: private,
+	})
+	return &pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: append([]byte(openSSHKeyMagic), body...)}, nil
+}
+
+// privateKeyFields encodes the key type and private key fields of key as
+// they appear in an OpenSSH format private key
+func privateKeyFields(key crypto.PrivateKey) ([]byte, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return ssh.Marshal(struct {
+			KeyType string
+			Pub     []byte
+			Priv    []byte
+		}{ssh.KeyAlgoED25519, k.Public().(ed25519.PublicKey), k}), nil
+	case *ed25519.PrivateKey:
+		return privateKeyFields(*k)
+	case *rsa.PrivateKey:
+		if len(k.Primes) != 2 {
+			return nil, fmt.Errorf("rsa keys with %d primes are not supported", len(k.Primes))
+		}
+		k.Precompute()
+		return ssh.Marshal(struct {
+			KeyType string
+			N, E    *big.Int
+			D, Iqmp *big.Int
+			P, Q    *big.Int
+		}{ssh.KeyAlgoRSA, k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1]}), nil
+	case *ecdsa.PrivateKey:
+		pub, err := ssh.NewPublicKey(&k.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		// The public key is the key type, the curve name and the point
+		var point struct {
+			KeyType, Curve string
+			Q              []byte
+		}
+		if err := ssh.Unmarshal(pub.Marshal(), &point); err != nil {
+			return nil, err
+		}
+		return ssh.Marshal(struct {
+			KeyType, Curve string
+			Q              []byte
+			D              *big.Int
+		}{point.KeyType, point.Curve, point.Q, k.D}), nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
@@ -0,0 +1,110 @@
+// pkg/ssh/privatekey_test.go
+package ssh
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestGenerateKeysPassphrase(t *testing.T) {
+	tests := []struct {
+		opts      KeyOptions
+		wantError bool
+	}{
+		{opts: KeyOptions{Type: "ed25519", Passphrase: "hunter2", Comment: "alice@laptop"}},
+		{opts: KeyOptions{Type: "ecdsa", Curve: "p384", Passphrase: "hunter2"}},
+		{opts: KeyOptions{Type: "rsa", Bits: 2048, Passphrase: "hunter2", Rounds: 4}},
+		{opts: KeyOptions{Type: "ed25519", Rounds: 4}, wantError: true},
+		{opts: KeyOptions{Type: "ed25519", Passphrase: "hunter2", Rounds: -1}, wantError: true},
+	}
+	for _, tt := range tests {
+		privateKey, publicKey, err := GenerateKeys(tt.opts)
+		if (err != nil) != tt.wantError {
+			t.Errorf("GenerateKeys(%+v) error = %v, want error: %v", tt.opts, err, tt.wantError)
+			continue
+		}
+		if tt.wantError {
+			continue
+		}
+		if block, _ := pem.Decode(privateKey); block == nil || block.Type != "OPENSSH PRIVATE KEY" {
+			t.Errorf("GenerateKeys(%+v) private key is not in OpenSSH format", tt.opts)
+		}
+
+		var missing *ssh.PassphraseMissingError
+		if _, err := ssh.ParsePrivateKey(privateKey); !errors.As(err, &missing) {
+			t.Errorf("GenerateKeys(%+v) private key parsed without a passphrase: %v", tt.opts, err)
+		}
+		if _, err := ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte("wrong")); err == nil {
+			t.Errorf("GenerateKeys(%+v) private key parsed with the wrong passphrase", tt.opts)
+		}
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(privateKey, []byte(tt.opts.Passphrase))
+		if err != nil {
+			t.Errorf("GenerateKeys(%+v) private key doesn't decrypt: %v", tt.opts, err)
+			continue
+		}
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(publicKey)
+		if err != nil || !bytes.Equal(pub.Marshal(), signer.PublicKey().Marshal()) {
+			t.Errorf("GenerateKeys(%+v) public key doesn't match the private key: %v", tt.opts, err)
+		}
+	}
+}
+
+func TestMarshalEncryptedPrivateKeyRounds(t *testing.T) {
+	key, err := generateKey(KeyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := marshalEncryptedPrivateKey(key, "", []byte("hunter2"), 100)
+	if err != nil {
+		t.Fatalf("marshalEncryptedPrivateKey failed: %v", err)
+	}
+	var body openSSHKey
+	if err := ssh.Unmarshal(block.Bytes[len(openSSHKeyMagic):], &body); err != nil {
+		t.Fatal(err)
+	}
+	var kdf struct {
+		Salt   string
+		Rounds uint32
+	}
+	if err := ssh.Unmarshal([]byte(body.KdfOpts), &kdf); err != nil {
+		t.Fatal(err)
+	}
+	if body.CipherName != "aes256-ctr" || body.KdfName != "bcrypt" || kdf.Rounds != 100 || len(kdf.Salt) != 16 {
+		t.Errorf("cipher %s, kdf %s with %d rounds and a %d byte salt", body.CipherName, body.KdfName, kdf.Rounds, len(kdf.Salt))
+	}
+}
+
+func TestPrivateKeyComment(t *testing.T) {
+	tests := []struct {
+		opts       KeyOptions
+		passphrase string
+		want       string
+	}{
+		{opts: KeyOptions{Type: "ed25519", Comment: "alice@laptop"}, want: "alice@laptop"},
+		{opts: KeyOptions{Type: "ecdsa", Curve: "p521", Comment: "alice@laptop"}, want: "alice@laptop"},
+		{opts: KeyOptions{Type: "rsa", Bits: 2048, Comment: "alice@laptop"}, want: "alice@laptop"},
+		{opts: KeyOptions{Type: "ed25519"}, want: ""},
+		// The comment of an encrypted key is encrypted too
+		{opts: KeyOptions{Type: "ed25519", Comment: "alice@laptop", Passphrase: "hunter2", Rounds: 1}, passphrase: "hunter2", want: "alice@laptop"},
+		{opts: KeyOptions{Type: "rsa", Bits: 2048, Comment: "alice@laptop", Passphrase: "hunter2", Rounds: 1}, passphrase: "hunter2", want: "alice@laptop"},
+		{opts: KeyOptions{Type: "ed25519", Comment: "alice@laptop", Passphrase: "hunter2", Rounds: 1}, passphrase: "wrong", want: ""},
+		{opts: KeyOptions{Type: "ed25519", Comment: "alice@laptop", Passphrase: "hunter2", Rounds: 1}, want: ""},
+		{opts: KeyOptions{Type: "rsa", Bits: 2048, Comment: "alice@laptop", Format: "pem"}, want: ""},
+	}
+	for _, tt := range tests {
+		privateKey, _, err := GenerateKeys(tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := PrivateKeyComment(privateKey, []byte(tt.passphrase)); got != tt.want {
+			t.Errorf("PrivateKeyComment of %+v with %q = %q, want %q", tt.opts, tt.passphrase, got, tt.want)
+		}
+	}
+	if got := PrivateKeyComment([]byte("ssh-ed25519 AAAA"), nil); got != "" {
+		t.Errorf("PrivateKeyComment of a public key = %q", got)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+package ssh
+
+import "os/exec"
+
+// isPrivileged reports whether the server may chroot the processes it starts
+// or switch their user. Only Unix systems can, so chrooted sessions here are
+// limited to built-in commands and SFTP, which stays inside the directory on
+// its own.
+func isPrivileged() bool {
+	return false
+}
+
+// chrootCommand is never called on platforms without chroot
+func chrootCommand(cmd *exec.Cmd, dir string) {}
+
+// checkRootOwned has nothing to check on platforms without chroot
+func checkRootOwned(path string) error {
+	return nil
+}
+
+// runAs is never called on platforms without Unix accounts; NewServer
+// refuses RunAsUser there
+func runAs(cmd *exec.Cmd, account *osAccount) {}
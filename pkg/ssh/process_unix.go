@@ -0,0 +1,52 @@
+//go:build unix
+
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// isPrivileged reports whether the server runs as root, which chrooting and
+// switching the user of the processes it starts require
+func isPrivileged() bool {
+	return os.Geteuid() == 0
+}
+
+// chrootCommand makes cmd start with dir as its root directory and working
+// directory, keeping any other process attributes already set
+func chrootCommand(cmd *exec.Cmd, dir string) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Chroot = dir
+	cmd.Dir = "/"
+}
+
+// checkRootOwned refuses a chroot path component that is not owned by root
+// or that group or others may write to
+func checkRootOwned(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("chroot directory: %s", err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 {
+		return fmt.Errorf("chroot directory component %s is not owned by root", path)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("chroot directory component %s is writable by group or others", path)
+	}
+	return nil
+}
+
+// runAs makes cmd run with the identity of account, starting in its home
+// directory when it has one
+func runAs(cmd *exec.Cmd, account *osAccount) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: account.uid, Gid: account.gid, Groups: account.groups}
+	cmd.Dir = account.workDir()
+}
@@ -0,0 +1,181 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyHeaderTimeout bounds how long a proxy may take to send the PROXY
+// protocol header of a connection
+const proxyHeaderTimeout = 10 * time.Second
+
+// proxyV1MaxLength is the longest PROXY protocol v1 header, CRLF included
+const proxyV1MaxLength = 107
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxiedConn is a connection whose addresses were replaced with those of the
+// client and destination named in its PROXY protocol header
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+	local  net.Addr
+}
+
+// RemoteAddr returns the address of the client behind the proxy
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// LocalAddr returns the address the client connected to on the proxy
+func (c *proxiedConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// expectsProxyHeader reports whether a connection from addr must start with a
+// PROXY protocol header
+func (o ServerOptions) expectsProxyHeader(addr net.Addr) bool {
+	if !o.ProxyProtocol {
+		return false
+	}
+	return len(o.TrustedProxies) == 0 || matchSourceAddress(o.TrustedProxies, addr)
+}
+
+// readProxyHeader consumes the PROXY protocol v1 or v2 header at the start of
+// conn and returns conn with the client addresses it names. Headers for
+// health checks (v1 UNKNOWN, v2 LOCAL) keep the connection's own addresses.
+// Nothing past the header is read, so the SSH handshake can follow.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	start := make([]byte, len(proxyV1Prefix))
+	if _, err := io.ReadFull(conn, start); err != nil {
+		return nil, fmt.Errorf("could not read PROXY header: %s", err)
+	}
+	var remote, local net.Addr
+	var err error
+	switch {
+	case bytes.Equal(start, proxyV1Prefix):
+		remote, local, err = readProxyV1(conn)
+	case bytes.HasPrefix(proxyV2Signature, start):
+		remote, local, err = readProxyV2(conn)
+	default:
+		return nil, errors.New("connection did not start with a PROXY header")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if remote == nil {
+		return conn, nil
+	}
+	return &proxiedConn{Conn: conn, remote: remote, local: local}, nil
+}
+
+// readProxyV1 parses the rest of a text header after "PROXY ", e.g.
+// "TCP4 203.0.113.7 10.0.0.1 51234 22\r\n". It reads a byte at a time so
+// that no data past the header is consumed.
+func readProxyV1(conn net.Conn) (net.Addr, net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= proxyV1MaxLength-len(proxyV1Prefix) {
+			return nil, nil, errors.New("PROXY v1 header too long")
+		}
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil, nil, fmt.Errorf("could not read PROXY header: %s", err)
+		}
+		line = append(line, b[0])
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) > 0 && fields[0] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, nil, fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	remote, err := parseProxyV1Addr(fields[1], fields[3])
+	if err != nil {
+		return nil, nil, err
+	}
+	local, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	return remote, local, nil
+}
+
+// parseProxyV1Addr parses an address and port of a v1 header
+func parseProxyV1Addr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address %q in PROXY header", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q in PROXY header", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+// readProxyV2 parses the rest of a binary header after its first bytes
+func readProxyV2(conn net.Conn) (net.Addr, net.Addr, error) {
+	header := make([]byte, 16)
+	copy(header, proxyV2Signature[:len(proxyV1Prefix)])
+	if _, err := io.ReadFull(conn, header[len(proxyV1Prefix):]); err != nil {
+		return nil, nil, fmt.Errorf("could not read PROXY header: %s", err)
+	}
+	if !bytes.Equal(header[:12], proxyV2Signature) {
+		return nil, nil, errors.New("connection did not start with a PROXY header")
+	}
+	if version := header[12] >> 4; version != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version %d", version)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(conn, body); err != nil {
+		return nil, nil, fmt.Errorf("could not read PROXY header: %s", err)
+	}
+
+	switch command := header[12] & 0x0f; command {
+	case 0x0: // LOCAL: a health check by the proxy itself
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY v2 command %d", command)
+	}
+
+	// Only TCP over IPv4 and IPv6 carry addresses the server can use; TLVs
+	// after the addresses are ignored
+	var size int
+	switch header[13] {
+	case 0x11:
+		size = net.IPv4len
+	case 0x21:
+		size = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, errors.New("PROXY v2 address block too short")
+	}
+	remote := &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(body[:size])),
+		Port: int(binary.BigEndian.Uint16(body[2*size:])),
+	}
+	local := &net.TCPAddr{
+		IP:   net.IP(bytes.Clone(body[size : 2*size])),
+		Port: int(binary.BigEndian.Uint16(body[2*size+2:])),
+	}
+	return remote, local, nil
+}
@@ -0,0 +1,180 @@
+// pkg/ssh/proxyproto_test.go
+package ssh
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// proxyV2Header builds a binary header for a TCP connection from src to dst
+func proxyV2Header(command byte, src, dst *net.TCPAddr) []byte {
+	header := append([]byte{}, proxyV2Signature...)
+	family, ip := byte(0x11), src.IP.To4()
+	if ip == nil {
+		family = 0x21
+	}
+	header = append(header, 0x20|command, family)
+	var body []byte
+	if family == 0x11 {
+		body = append(body, src.IP.To4()...)
+		body = append(body, dst.IP.To4()...)
+	} else {
+		body = append(body, src.IP.To16()...)
+		body = append(body, dst.IP.To16()...)
+	}
+	body = binary.BigEndian.AppendUint16(body, uint16(src.Port))
+	body = binary.BigEndian.AppendUint16(body, uint16(dst.Port))
+	header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+	return append(header, body...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	client := &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}
+	server := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	client6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::7"), Port: 51234}
+	server6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 22}
+
+	tests := []struct {
+		name       string
+		header     []byte
+		wantRemote string // empty keeps the connection's own address
+		wantLocal  string
+		wantErr    bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 51234 22\r\n"), "203.0.113.7:51234", "10.0.0.1:22", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::7 2001:db8::1 51234 22\r\n"), "[2001:db8::7]:51234", "[2001:db8::1]:22", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", "", false},
+		{"v2 tcp4", proxyV2Header(0x1, client, server), "203.0.113.7:51234", "10.0.0.1:22", false},
+		{"v2 tcp6", proxyV2Header(0x1, client6, server6), "[2001:db8::7]:51234", "[2001:db8::1]:22", false},
+		{"v2 local", proxyV2Header(0x0, client, server), "", "", false},
+		{"no header", []byte("SSH-2.0-OpenSSH_9.6\r\n"), "", "", true},
+		{"v1 bad address", []byte("PROXY TCP4 example.com 10.0.0.1 51234 22\r\n"), "", "", true},
+		{"v1 bad port", []byte("PROXY TCP4 203.0.113.7 10.0.0.1 99999 22\r\n"), "", "", true},
+		{"v1 missing fields", []byte("PROXY TCP4 203.0.113.7\r\n"), "", "", true},
+		{"v1 too long", append([]byte("PROXY TCP4 "), make([]byte, 120)...), "", "", true},
+	}
+
+	const payload = "SSH-2.0-Test\r\n"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local, remote := net.Pipe()
+			defer local.Close()
+			defer remote.Close()
+			go func() {
+				remote.Write(tt.header)
+				remote.Write([]byte(payload))
+			}()
+
+			conn, err := readProxyHeader(local)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readProxyHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			wantRemote, wantLocal := tt.wantRemote, tt.wantLocal
+			if wantRemote == "" {
+				wantRemote, wantLocal = local.RemoteAddr().String(), local.LocalAddr().String()
+			}
+			if got := conn.RemoteAddr().String(); got != wantRemote {
+				t.Errorf("RemoteAddr() = %s, want %s", got, wantRemote)
+			}
+			if got := conn.LocalAddr().String(); got != wantLocal {
+				t.Errorf("LocalAddr() = %s, want %s", got, wantLocal)
+			}
+
+			// The data after the header is left for the SSH handshake
+			rest := make([]byte, len(payload))
+			if _, err := io.ReadFull(conn, rest); err != nil || string(rest) != payload {
+				t.Errorf("data after the header = %q, %v, want %q", rest, err, payload)
+			}
+		})
+	}
+}
+
+func TestExpectsProxyHeader(t *testing.T) {
+	proxy := &net.TCPAddr{IP: net.ParseIP("10.0.0.5"), Port: 40000}
+	direct := &net.TCPAddr{IP: net.ParseIP("192.0.2.9"), Port: 40000}
+	tests := []struct {
+		name string
+		opts ServerOptions
+		addr net.Addr
+		want bool
+	}{
+		{"disabled", ServerOptions{}, proxy, false},
+		{"every source", ServerOptions{ProxyProtocol: true}, direct, true},
+		{"trusted proxy", ServerOptions{ProxyProtocol: true, TrustedProxies: []string{"10.0.0.0/8"}}, proxy, true},
+		{"direct client", ServerOptions{ProxyProtocol: true, TrustedProxies: []string{"10.0.0.0/8"}}, direct, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.expectsProxyHeader(tt.addr); got != tt.want {
+				t.Errorf("expectsProxyHeader(%s) = %v, want %v", tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
+// dialThroughProxy connects to addr and sends header before the SSH handshake
+func dialThroughProxy(t *testing.T, addr, header string, signer ssh.Signer) (*ssh.Client, error) {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if _, err := conn.Write([]byte(header)); err != nil {
+		t.Fatalf("Failed to send PROXY header: %v", err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit := &lockedBuffer{}
+	server, addr, signer, _ := startTestServer(t, ctx, ServerOptions{
+		ProxyProtocol: true,
+		AllowFrom:     []string{"203.0.113.0/24"},
+		AuditLog:      audit,
+	})
+
+	client, err := dialThroughProxy(t, addr, "PROXY TCP4 203.0.113.7 10.0.0.1 51234 22\r\n", signer)
+	if err != nil {
+		t.Fatalf("connection through the proxy failed: %v", err)
+	}
+	defer client.Close()
+	if sessions := waitForSessions(t, server, 1); sessions[0].RemoteAddr != "203.0.113.7:51234" {
+		t.Errorf("session remote address = %s, want the client's 203.0.113.7:51234", sessions[0].RemoteAddr)
+	}
+	event := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "auth_success" })
+	if event.RemoteAddr != "203.0.113.7:51234" {
+		t.Errorf("audit remote_addr = %s, want 203.0.113.7:51234", event.RemoteAddr)
+	}
+
+	// AllowFrom applies to the client named in the header, not the proxy
+	if _, err := dialThroughProxy(t, addr, "PROXY TCP4 198.51.100.1 10.0.0.1 51234 22\r\n", signer); err == nil {
+		t.Error("a client outside AllowFrom should be rejected")
+	}
+	// Connections without a header are closed
+	if _, err := dialThroughProxy(t, addr, "", signer); err == nil {
+		t.Error("a connection without a PROXY header should be rejected")
+	}
+}
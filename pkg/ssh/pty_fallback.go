@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// errPTYUnavailable is returned when the platform cannot allocate a native PTY
+var errPTYUnavailable = errors.New("native PTY allocation is not available")
+
+// fallbackShell is a portable, line-based interactive session built on
+// term.Terminal. It needs nothing from the operating system, so it works on
+// every platform gossh compiles for, including containers without /dev/ptmx.
+type fallbackShell struct {
+	conn    *ssh.ServerConn
+	term    *term.Terminal
+	allowed func(cmdline string) bool
+	// commands serves each line unless program is set
+	commands *CommandRegistry
+	ctx      SessionContext
+	// program, when set, runs each line as "program -c line" instead of
+	// using the registered commands
+	program string
+	env     []string
+	// prepare, when set, sets up each program to run, e.g. in a chroot
+	prepare func(*exec.Cmd) error
+}
+
+// newFallbackShell creates a fallback shell reading from and writing to rw
+func newFallbackShell(conn *ssh.ServerConn, rw io.ReadWriter, size *windowSize) *fallbackShell {
+	shell := &fallbackShell{
+		conn:     conn,
+		term:     term.NewTerminal(rw, fmt.Sprintf("%s> ", conn.User())),
+		commands: NewCommandRegistry(),
+		ctx:      newSessionContext(context.Background(), conn, nil),
+	}
+	if size != nil {
+		shell.resize(*size)
+	}
+	return shell
+}
+
+// resize updates the emulated terminal dimensions
+func (f *fallbackShell) resize(size windowSize) {
+	if size.Columns == 0 || size.Rows == 0 {
+		return
+	}
+	f.term.SetSize(int(size.Columns), int(size.Rows))
+}
+
+// run reads lines until the client quits or disconnects
+func (f *fallbackShell) run() error {
+	for {
+		line, err := f.term.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		switch line {
+		case "":
+			// Do nothing for empty lines
+		case "quit", "exit":
+			f.term.Write([]byte("Goodbye!\n"))
+			return nil
+		default:
+			if f.allowed != nil && !f.allowed(line) {
+				f.term.Write([]byte(fmt.Sprintf("Command Not Allowed: %s\n", line)))
+				continue
+			}
+			f.term.Write(f.execLine(line))
+		}
+	}
+}
+
+// execLine runs a single command line and returns its output
+func (f *fallbackShell) execLine(line string) []byte {
+	if f.program == "" {
+		out, _ := f.commands.run(f.ctx, line)
+		return []byte(out)
+	}
+	cmd := exec.CommandContext(f.ctx, f.program, "-c", line)
+	cmd.Env = f.env
+	if f.prepare != nil {
+		if err := f.prepare(cmd); err != nil {
+			return []byte(fmt.Sprintf("%s: %s\n", f.program, err))
+		}
+	}
+	out, err := cmd.CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		out = append(out, []byte(fmt.Sprintf("%s: %s\n", f.program, err))...)
+	}
+	return out
+}
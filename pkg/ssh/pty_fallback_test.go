@@ -0,0 +1,90 @@
+// pkg/ssh/pty_fallback_test.go
+package ssh
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// scriptedTerminal feeds canned keystrokes to a shell and records its output
+type scriptedTerminal struct {
+	input  *bytes.Buffer
+	output bytes.Buffer
+}
+
+func (s *scriptedTerminal) Read(p []byte) (int, error) {
+	return s.input.Read(p)
+}
+
+func (s *scriptedTerminal) Write(p []byte) (int, error) {
+	return s.output.Write(p)
+}
+
+func TestFallbackShell(t *testing.T) {
+	conn := &ssh.ServerConn{Conn: &mockSSHConn{user: "testuser"}}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"whoami", "whoami\r", []string{"You are: testuser"}},
+		{"unknown command", "frobnicate\r", []string{"Command Not Found: frobnicate"}},
+		{"quit", "quit\rwhoami\r", []string{"Goodbye!"}},
+		{"exit alias", "exit\r", []string{"Goodbye!"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := &scriptedTerminal{input: bytes.NewBufferString(tt.input)}
+			shell := newFallbackShell(conn, rw, &windowSize{Columns: 120, Rows: 40})
+
+			if err := shell.run(); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+
+			out := rw.output.String()
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("output %q does not contain %q", out, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFallbackShellStopsAfterQuit(t *testing.T) {
+	conn := &ssh.ServerConn{Conn: &mockSSHConn{user: "testuser"}}
+	rw := &scriptedTerminal{input: bytes.NewBufferString("quit\rwhoami\r")}
+
+	if err := newFallbackShell(conn, rw, nil).run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if strings.Contains(rw.output.String(), "You are:") {
+		t.Error("commands after quit should not be executed")
+	}
+}
+
+func TestFallbackShellRunsConfiguredProgram(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	conn := &ssh.ServerConn{Conn: &mockSSHConn{user: "testuser"}}
+	rw := &scriptedTerminal{input: bytes.NewBufferString("echo from-$GOSSH_TEST\r")}
+
+	shell := newFallbackShell(conn, rw, nil)
+	shell.program = "/bin/sh"
+	shell.env = []string{"GOSSH_TEST=fallback"}
+
+	if err := shell.run(); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if !strings.Contains(rw.output.String(), "from-fallback") {
+		t.Errorf("output %q should contain the program's output", rw.output.String())
+	}
+}
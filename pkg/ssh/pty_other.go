@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package ssh
+
+// startNativeShell attaches the session to a real shell on a native PTY.
+// This platform has no PTY support, so interactive sessions always use the
+// line-based fallback shell.
+func startNativeShell(s *session) error {
+	return errPTYUnavailable
+}
@@ -0,0 +1,156 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package ssh
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os/exec"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+// startNativeShell runs the configured shell on a freshly allocated PTY,
+// applying the client's terminal modes and window size
+func startNativeShell(s *session) error {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errPTYUnavailable, err)
+	}
+	defer tty.Close()
+
+	if err := pty.Setsize(ptmx, ptyWinsize(windowSize{Columns: s.pty.Columns, Rows: s.pty.Rows})); err != nil {
+		s.logger.Warn("could not set initial window size", "error", err)
+	}
+	if err := applyTerminalModes(int(tty.Fd()), s.pty.Modes); err != nil {
+		s.logger.Warn("could not apply terminal modes", "error", err)
+	}
+
+	cmd := exec.CommandContext(s.ctx, s.opts.Shell)
+	cmd.Env = s.shellEnv()
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	// Make the PTY the controlling terminal of a new session so job control
+	// and terminal-generated signals (Ctrl+C, Ctrl+Z) reach the shell
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	err = s.prepareCommand(cmd)
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		ptmx.Close()
+		return err
+	}
+	s.setProcess(cmd.Process)
+	s.setResizeHandler(func(size windowSize) {
+		if err := pty.Setsize(ptmx, ptyWinsize(size)); err != nil {
+			s.logger.Warn("could not resize PTY", "error", err)
+		}
+	})
+
+	go io.Copy(ptmx, s.channel)
+	go func() {
+		defer s.channel.Close()
+		defer ptmx.Close()
+		// Reading the PTY fails with EIO once the shell and its children exit
+		io.Copy(s.channel, ptmx)
+		err := cmd.Wait()
+		s.setProcess(nil)
+		sendExit(s.channel, err)
+	}()
+	return nil
+}
+
+// ptyWinsize converts an SSH window size to a PTY window size
+func ptyWinsize(size windowSize) *pty.Winsize {
+	return &pty.Winsize{Cols: uint16(size.Columns), Rows: uint16(size.Rows)}
+}
+
+// terminalModeSetters apply encoded terminal modes (RFC 4254 section 8) to termios
+var terminalModeSetters = map[byte]func(t *unix.Termios, value uint32){
+	ssh.VINTR:   func(t *unix.Termios, v uint32) { t.Cc[unix.VINTR] = uint8(v) },
+	ssh.VQUIT:   func(t *unix.Termios, v uint32) { t.Cc[unix.VQUIT] = uint8(v) },
+	ssh.VERASE:  func(t *unix.Termios, v uint32) { t.Cc[unix.VERASE] = uint8(v) },
+	ssh.VKILL:   func(t *unix.Termios, v uint32) { t.Cc[unix.VKILL] = uint8(v) },
+	ssh.VEOF:    func(t *unix.Termios, v uint32) { t.Cc[unix.VEOF] = uint8(v) },
+	ssh.VSTART:  func(t *unix.Termios, v uint32) { t.Cc[unix.VSTART] = uint8(v) },
+	ssh.VSTOP:   func(t *unix.Termios, v uint32) { t.Cc[unix.VSTOP] = uint8(v) },
+	ssh.VSUSP:   func(t *unix.Termios, v uint32) { t.Cc[unix.VSUSP] = uint8(v) },
+	ssh.IGNPAR:  func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.IGNPAR, v) },
+	ssh.PARMRK:  func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.PARMRK, v) },
+	ssh.INPCK:   func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.INPCK, v) },
+	ssh.ISTRIP:  func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.ISTRIP, v) },
+	ssh.INLCR:   func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.INLCR, v) },
+	ssh.IGNCR:   func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.IGNCR, v) },
+	ssh.ICRNL:   func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.ICRNL, v) },
+	ssh.IXON:    func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.IXON, v) },
+	ssh.IXANY:   func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.IXANY, v) },
+	ssh.IXOFF:   func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.IXOFF, v) },
+	ssh.IMAXBEL: func(t *unix.Termios, v uint32) { setFlag(&t.Iflag, unix.IMAXBEL, v) },
+	ssh.ISIG:    func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.ISIG, v) },
+	ssh.ICANON:  func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.ICANON, v) },
+	ssh.ECHO:    func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.ECHO, v) },
+	ssh.ECHOE:   func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.ECHOE, v) },
+	ssh.ECHOK:   func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.ECHOK, v) },
+	ssh.ECHONL:  func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.ECHONL, v) },
+	ssh.NOFLSH:  func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.NOFLSH, v) },
+	ssh.TOSTOP:  func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.TOSTOP, v) },
+	ssh.IEXTEN:  func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.IEXTEN, v) },
+	ssh.ECHOCTL: func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.ECHOCTL, v) },
+	ssh.ECHOKE:  func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.ECHOKE, v) },
+	ssh.PENDIN:  func(t *unix.Termios, v uint32) { setFlag(&t.Lflag, unix.PENDIN, v) },
+	ssh.OPOST:   func(t *unix.Termios, v uint32) { setFlag(&t.Oflag, unix.OPOST, v) },
+	ssh.ONLCR:   func(t *unix.Termios, v uint32) { setFlag(&t.Oflag, unix.ONLCR, v) },
+	ssh.OCRNL:   func(t *unix.Termios, v uint32) { setFlag(&t.Oflag, unix.OCRNL, v) },
+	ssh.ONOCR:   func(t *unix.Termios, v uint32) { setFlag(&t.Oflag, unix.ONOCR, v) },
+	ssh.ONLRET:  func(t *unix.Termios, v uint32) { setFlag(&t.Oflag, unix.ONLRET, v) },
+	ssh.PARENB:  func(t *unix.Termios, v uint32) { setFlag(&t.Cflag, unix.PARENB, v) },
+	ssh.PARODD:  func(t *unix.Termios, v uint32) { setFlag(&t.Cflag, unix.PARODD, v) },
+}
+
+// setFlag sets or clears a termios flag bit depending on value
+func setFlag[T uint32 | uint64](flags *T, bit T, value uint32) {
+	if value != 0 {
+		*flags |= bit
+	} else {
+		*flags &^= bit
+	}
+}
+
+// applyTerminalModes decodes the encoded terminal modes of a pty-req and
+// applies the ones this platform understands to the terminal at fd
+func applyTerminalModes(fd int, modes string) error {
+	if modes == "" {
+		return nil
+	}
+	t, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return err
+	}
+
+	data := []byte(modes)
+	for len(data) > 0 {
+		opcode := data[0]
+		// TTY_OP_END, or an opcode without arguments (160 and up), ends parsing
+		if opcode == 0 || opcode >= 160 {
+			break
+		}
+		if len(data) < 5 {
+			return fmt.Errorf("truncated terminal mode %d", opcode)
+		}
+		value := binary.BigEndian.Uint32(data[1:5])
+		data = data[5:]
+
+		if set, ok := terminalModeSetters[opcode]; ok {
+			set(t, value)
+		}
+	}
+
+	return unix.IoctlSetTermios(fd, ioctlSetTermios, t)
+}
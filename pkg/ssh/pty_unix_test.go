@@ -0,0 +1,106 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+// pkg/ssh/pty_unix_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/sys/unix"
+)
+
+func TestApplyTerminalModes(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("PTY not available: %v", err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+
+	modes := ssh.Marshal(struct {
+		EchoOp byte
+		Echo   uint32
+		IntrOp byte
+		Intr   uint32
+		EndOp  byte
+	}{ssh.ECHO, 0, ssh.VINTR, 3, 0})
+
+	if err := applyTerminalModes(int(tty.Fd()), string(modes)); err != nil {
+		t.Fatalf("applyTerminalModes() error = %v", err)
+	}
+
+	termios, err := unix.IoctlGetTermios(int(tty.Fd()), ioctlGetTermios)
+	if err != nil {
+		t.Fatalf("Failed to read termios: %v", err)
+	}
+	if termios.Lflag&unix.ECHO != 0 {
+		t.Error("ECHO should be disabled")
+	}
+	if termios.Cc[unix.VINTR] != 3 {
+		t.Errorf("VINTR = %d, want 3", termios.Cc[unix.VINTR])
+	}
+
+	if err := applyTerminalModes(int(tty.Fd()), string([]byte{ssh.ECHO, 0, 0})); err == nil {
+		t.Error("truncated modes should be rejected")
+	}
+}
+
+func TestNativeShellSession(t *testing.T) {
+	if _, tty, err := pty.Open(); err != nil {
+		t.Skipf("PTY not available: %v", err)
+	} else {
+		tty.Close()
+	}
+
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{Shell: "/bin/sh"})
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	var out bytes.Buffer
+	session.Stdout = &out
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		t.Fatalf("Failed to get stdin: %v", err)
+	}
+
+	if err := session.RequestPty("xterm", 40, 100, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+		t.Fatalf("RequestPty failed: %v", err)
+	}
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+
+	stdin.Write([]byte("tty >/dev/null && echo on-a-tty $TERM\n"))
+	stdin.Write([]byte("exit 3\n"))
+
+	waitErr := make(chan error, 1)
+	go func() { waitErr <- session.Wait() }()
+	select {
+	case err = <-waitErr:
+	case <-time.After(10 * time.Second):
+		t.Fatal("shell session did not exit")
+	}
+
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("Wait() error = %v, want exit status 3", err)
+	}
+	if !strings.Contains(out.String(), "on-a-tty xterm") {
+		t.Errorf("output %q should show the shell running on a tty with TERM=xterm", out.String())
+	}
+}
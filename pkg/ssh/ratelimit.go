@@ -0,0 +1,170 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/time/rate"
+)
+
+// errQuotaExceeded fails reads and writes of a session past its TransferQuota
+var errQuotaExceeded = errors.New("transfer quota exceeded")
+
+// limitedChannel wraps a session channel, throttling the data flowing in
+// each direction to RateLimit and closing the channel once TransferQuota
+// bytes have passed through it. Exec output, shell I/O and SFTP transfers
+// all flow through the session channel, so all of them are limited.
+type limitedChannel struct {
+	ssh.Channel
+	ctx context.Context
+	// in and out throttle received and sent data, nil when unlimited
+	in, out *rate.Limiter
+	quota   int64
+	used    atomic.Int64
+	// exceeded is run once when the quota is used up
+	exceeded func()
+	once     sync.Once
+}
+
+// newLimitedChannel wraps channel with the limits of opts. Waits for the
+// rate limiters end when ctx is cancelled.
+func newLimitedChannel(ctx context.Context, channel ssh.Channel, opts *ServerOptions, exceeded func()) *limitedChannel {
+	c := &limitedChannel{Channel: channel, ctx: ctx, quota: opts.TransferQuota, exceeded: exceeded}
+	if opts.RateLimit > 0 {
+		c.in = newByteLimiter(opts.RateLimit)
+		c.out = newByteLimiter(opts.RateLimit)
+	}
+	return c
+}
+
+// newByteLimiter returns a limiter of bytesPerSecond that allows bursts of
+// up to one second of data
+func newByteLimiter(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), int(bytesPerSecond))
+}
+
+// waitBytes blocks until limiter allows n more bytes, in steps no larger
+// than its burst
+func waitBytes(ctx context.Context, limiter *rate.Limiter, n int) error {
+	if limiter == nil {
+		return nil
+	}
+	for n > 0 {
+		step := min(n, limiter.Burst())
+		if err := limiter.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+// reserve counts n bytes against the quota and returns how many of them
+// fit. When some do not, the quota is reported as exceeded.
+func (c *limitedChannel) reserve(n int) int {
+	if c.quota <= 0 {
+		return n
+	}
+	used := c.used.Add(int64(n))
+	if used <= c.quota {
+		return n
+	}
+	c.once.Do(c.exceeded)
+	return max(0, n-int(used-c.quota))
+}
+
+// quotaUsed reports whether the whole quota has been transferred
+func (c *limitedChannel) quotaUsed() bool {
+	return c.quota > 0 && c.used.Load() >= c.quota
+}
+
+func (c *limitedChannel) Read(p []byte) (int, error) {
+	return c.limitRead(c.Channel, p)
+}
+
+func (c *limitedChannel) Write(p []byte) (int, error) {
+	return c.limitWrite(c.Channel, p)
+}
+
+// Stderr limits the channel's stderr stream together with its data
+func (c *limitedChannel) Stderr() io.ReadWriter {
+	return &limitedStream{stream: c.Channel.Stderr(), channel: c}
+}
+
+// limitRead reads from r, a stream of the channel, within the limits
+func (c *limitedChannel) limitRead(r io.Reader, p []byte) (int, error) {
+	if c.quotaUsed() {
+		return 0, errQuotaExceeded
+	}
+	n, err := r.Read(p)
+	if n > 0 {
+		allowed := c.reserve(n)
+		if waitErr := waitBytes(c.ctx, c.in, allowed); waitErr != nil && err == nil {
+			err = waitErr
+		}
+		if allowed < n {
+			return allowed, errQuotaExceeded
+		}
+	}
+	return n, err
+}
+
+// limitWrite writes p to w, a stream of the channel, within the limits.
+// Data is written in bursts so the rate holds for large writes too.
+func (c *limitedChannel) limitWrite(w io.Writer, p []byte) (int, error) {
+	if c.quotaUsed() {
+		return 0, errQuotaExceeded
+	}
+	allowed := c.reserve(len(p))
+	written := 0
+	for written < allowed {
+		step := allowed - written
+		if c.out != nil {
+			step = min(step, c.out.Burst())
+		}
+		if err := waitBytes(c.ctx, c.out, step); err != nil {
+			return written, err
+		}
+		n, err := w.Write(p[written : written+step])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	if allowed < len(p) {
+		return written, errQuotaExceeded
+	}
+	return written, nil
+}
+
+// limitedStream applies a channel's limits to its stderr stream
+type limitedStream struct {
+	stream  io.ReadWriter
+	channel *limitedChannel
+}
+
+func (s *limitedStream) Read(p []byte) (int, error) {
+	return s.channel.limitRead(s.stream, p)
+}
+
+func (s *limitedStream) Write(p []byte) (int, error) {
+	return s.channel.limitWrite(s.stream, p)
+}
+
+// closeOverQuota tells the client why its session ends and closes it. It is
+// the exceeded callback of a session's limitedChannel.
+func closeOverQuota(channel ssh.Channel, quota int64, logger *slog.Logger) {
+	logger.Warn("closing session: transfer quota exceeded", "quota_bytes", quota)
+	// Closing from another goroutine keeps the notice from waiting on the
+	// write that used up the quota
+	go func() {
+		fmt.Fprintf(channel.Stderr(), "\r\nTransfer quota of %d bytes exceeded, closing session.\r\n", quota)
+		channel.Close()
+	}()
+}
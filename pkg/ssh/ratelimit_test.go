@@ -0,0 +1,83 @@
+// pkg/ssh/ratelimit_test.go
+package ssh
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimitedChannelReserve(t *testing.T) {
+	exceeded := 0
+	c := &limitedChannel{quota: 10, exceeded: func() { exceeded++ }}
+
+	tests := []struct {
+		n    int
+		want int
+	}{
+		{6, 6},
+		{6, 4},
+		{1, 0},
+	}
+	for _, tt := range tests {
+		if got := c.reserve(tt.n); got != tt.want {
+			t.Errorf("reserve(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+	if exceeded != 1 || !c.quotaUsed() {
+		t.Errorf("exceeded called %d times, quotaUsed() = %v, want once and true", exceeded, c.quotaUsed())
+	}
+
+	unlimited := &limitedChannel{}
+	if got := unlimited.reserve(1 << 20); got != 1<<20 || unlimited.quotaUsed() {
+		t.Errorf("reserve without a quota = %d, want everything", got)
+	}
+}
+
+func TestServerRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Shell: "/bin/sh", RateLimit: 20000})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	// The first second's worth is sent at once, the rest at 20000 bytes/s
+	start := time.Now()
+	out := runTestCommand(t, client, "head -c 30000 /dev/zero")
+	if len(out) != 30000 {
+		t.Fatalf("output length = %d, want 30000", len(out))
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("30000 bytes took %s, want at least 400ms at 20000 bytes/s", elapsed)
+	}
+}
+
+func TestServerTransferQuota(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit := &lockedBuffer{}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Shell: "/bin/sh", TransferQuota: 10000, AuditLog: audit})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	defer session.Close()
+	out, _ := session.Output("head -c 100000 /dev/zero")
+	if len(out) > 10000 {
+		t.Errorf("output length = %d, want at most the 10000 byte quota", len(out))
+	}
+	exceeded := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "quota_exceeded" })
+	if exceeded.User != "alice" {
+		t.Errorf("quota_exceeded event = %+v", exceeded)
+	}
+
+	// The quota applies per session
+	if out := runTestCommand(t, client, "echo more"); out != "more\n" {
+		t.Errorf("next session output = %q, want a fresh quota", out)
+	}
+}
@@ -0,0 +1,216 @@
+package ssh
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh/internal/wire"
+	"golang.org/x/crypto/ssh"
+)
+
+// castHeader is the first line of an asciicast v2 recording
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     uint32            `json:"width"`
+	Height    uint32            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes the terminal I/O of a session as an asciicast v2 file
+// (https://docs.asciinema.org/manual/asciicast/v2/)
+type recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	started time.Time
+	closed  bool
+}
+
+// newRecorder creates a recording for the session in dir. The file name
+// combines the start time, the user and a random suffix.
+func newRecorder(dir string, conn ssh.ConnMetadata, pty *wire.PtyRequest) (*recorder, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	name := fmt.Sprintf("%s-%s-%s.cast", started.UTC().Format("20060102T150405Z"), filepath.Base(conn.User()), hex.EncodeToString(suffix))
+
+	file, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create session recording: %s", err)
+	}
+
+	header := castHeader{
+		Version:   2,
+		Width:     80,
+		Height:    24,
+		Timestamp: started.Unix(),
+		Title:     fmt.Sprintf("%s@%s", conn.User(), conn.RemoteAddr()),
+	}
+	if pty != nil {
+		header.Width, header.Height = pty.Columns, pty.Rows
+		if pty.Term != "" {
+			header.Env = map[string]string{"TERM": pty.Term}
+		}
+	}
+
+	r := &recorder{file: file, w: bufio.NewWriter(file), started: started}
+	data, err := json.Marshal(header)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	r.w.Write(append(data, '\n'))
+	return r, nil
+}
+
+// event appends an event of the given type ("o", "i" or "r") to the recording
+func (r *recorder) event(kind string, data string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	line, _ := json.Marshal([]any{time.Since(r.started).Seconds(), kind, data})
+	r.w.Write(append(line, '\n'))
+}
+
+// resize records a terminal size change
+func (r *recorder) resize(size windowSize) {
+	r.event("r", fmt.Sprintf("%dx%d", size.Columns, size.Rows))
+}
+
+// Close flushes and closes the recording file
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.w.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// recordingChannel copies everything read from and written to a session
+// channel into a recorder
+type recordingChannel struct {
+	ssh.Channel
+	rec *recorder
+}
+
+func (c *recordingChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	if n > 0 {
+		c.rec.event("i", string(p[:n]))
+	}
+	return n, err
+}
+
+func (c *recordingChannel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	if n > 0 {
+		c.rec.event("o", string(p[:n]))
+	}
+	return n, err
+}
+
+// Stderr returns the extended data stream; output written to it is recorded
+func (c *recordingChannel) Stderr() io.ReadWriter {
+	return &recordingStream{ReadWriter: c.Channel.Stderr(), rec: c.rec}
+}
+
+// Close finishes the recording and closes the channel. The recording is
+// complete on disk by the time the client sees the channel close.
+func (c *recordingChannel) Close() error {
+	recErr := c.rec.Close()
+	if err := c.Channel.Close(); err != nil {
+		return err
+	}
+	return recErr
+}
+
+// recordingStream records output written to a channel's stderr stream
+type recordingStream struct {
+	io.ReadWriter
+	rec *recorder
+}
+
+func (s *recordingStream) Write(p []byte) (int, error) {
+	n, err := s.ReadWriter.Write(p)
+	if n > 0 {
+		s.rec.event("o", string(p[:n]))
+	}
+	return n, err
+}
+
+// ReplayRecording plays the output of an asciicast v2 recording to w. speed
+// scales playback (2 plays twice as fast) and pauses longer than maxIdle are
+// shortened to maxIdle; zero leaves pauses unchanged.
+func ReplayRecording(r io.Reader, w io.Writer, speed float64, maxIdle time.Duration) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid playback speed %v", speed)
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return errors.New("empty recording")
+	}
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return fmt.Errorf("invalid recording header: %s", err)
+	}
+	if header.Version != 2 {
+		return fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+
+	var last float64
+	for line := 2; scanner.Scan(); line++ {
+		var event []any
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("invalid event on line %d", line)
+		}
+		at, ok1 := event[0].(float64)
+		kind, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("invalid event on line %d", line)
+		}
+		if kind != "o" {
+			continue
+		}
+
+		delay := time.Duration((at - last) / speed * float64(time.Second))
+		if maxIdle > 0 && delay > maxIdle {
+			delay = maxIdle
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+		last = at
+
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
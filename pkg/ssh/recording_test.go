@@ -0,0 +1,124 @@
+// pkg/ssh/recording_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServerRecordsInteractiveSessions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{RecordDir: dir})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	if err := session.RequestPty("xterm", 30, 100, ssh.TerminalModes{}); err != nil {
+		t.Fatalf("RequestPty failed: %v", err)
+	}
+	session.Stdin = strings.NewReader("whoami\rexit\r")
+	session.Stdout = io.Discard
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	session.Wait()
+
+	var files []string
+	deadline := time.Now().Add(2 * time.Second)
+	for len(files) == 0 && time.Now().Before(deadline) {
+		files, _ = filepath.Glob(filepath.Join(dir, "*-alice-*.cast"))
+		time.Sleep(20 * time.Millisecond)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected one recording, found %v", files)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var header castHeader
+	if err := json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatalf("invalid header %q: %v", lines[0], err)
+	}
+	if header.Version != 2 || header.Width != 100 || header.Height != 30 || header.Env["TERM"] != "xterm" {
+		t.Errorf("header = %+v, want version 2 with the requested terminal", header)
+	}
+
+	var input, output strings.Builder
+	for _, line := range lines[1:] {
+		var event []any
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		switch event[1] {
+		case "i":
+			input.WriteString(event[2].(string))
+		case "o":
+			output.WriteString(event[2].(string))
+		}
+	}
+	if !strings.Contains(input.String(), "whoami") {
+		t.Errorf("recorded input %q should contain the typed command", input.String())
+	}
+	if !strings.Contains(output.String(), "You are: alice") {
+		t.Errorf("recorded output %q should contain the command output", output.String())
+	}
+}
+
+func TestReplayRecording(t *testing.T) {
+	recording := `{"version":2,"width":80,"height":24,"timestamp":1700000000}
+[0.1,"o","hello "]
+[0.2,"i","x"]
+[0.3,"r","100x30"]
+[100.0,"o","world\r\n"]
+`
+	var out bytes.Buffer
+	start := time.Now()
+	if err := ReplayRecording(strings.NewReader(recording), &out, 1, 10*time.Millisecond); err != nil {
+		t.Fatalf("ReplayRecording() error = %v", err)
+	}
+	if out.String() != "hello world\r\n" {
+		t.Errorf("replayed output = %q, want only the output events", out.String())
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("replay took %s, long pauses should be capped", elapsed)
+	}
+
+	out.Reset()
+	if err := ReplayRecording(strings.NewReader(recording), &out, 1000, 0); err != nil {
+		t.Fatalf("ReplayRecording() at high speed error = %v", err)
+	}
+
+	invalid := []string{
+		"",
+		`{"version":1}`,
+		"{\"version\":2}\nnot json\n",
+		"{\"version\":2}\n[1,\"o\"]\n",
+	}
+	for _, input := range invalid {
+		if err := ReplayRecording(strings.NewReader(input), io.Discard, 1, 0); err == nil {
+			t.Errorf("ReplayRecording(%q) should fail", input)
+		}
+	}
+	if err := ReplayRecording(strings.NewReader(recording), io.Discard, 0, 0); err == nil {
+		t.Error("ReplayRecording should reject a zero speed")
+	}
+}
@@ -0,0 +1,131 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// rsyncLongOptions are the long options a client's rsync may pass to the
+// server. Options naming other server-side paths, like --temp-dir,
+// --link-dest or --log-file, are refused since they would escape the root.
+var rsyncLongOptions = map[string]bool{
+	"sender": true, "append": true, "append-verify": true,
+	"bwlimit": true, "checksum-choice": true, "checksum-seed": true,
+	"compress-choice": true, "compress-level": true, "delay-updates": true,
+	"delete": true, "delete-after": true, "delete-before": true,
+	"delete-delay": true, "delete-during": true, "delete-excluded": true,
+	"delete-missing-args": true, "existing": true, "fake-super": true,
+	"force": true, "ignore-errors": true, "ignore-existing": true,
+	"ignore-times": true, "inplace": true, "list-only": true,
+	"max-delete": true, "max-size": true, "min-size": true,
+	"modify-window": true, "munge-links": true, "no-implied-dirs": true,
+	"numeric-ids": true, "partial": true, "remove-source-files": true,
+	"safe-links": true, "size-only": true, "timeout": true,
+}
+
+// rsyncShortOptions are the short options a client's rsync combines into
+// its first argument in --server mode. Short options taking a value, like
+// -T (--temp-dir), -B or -f, are not among them.
+const rsyncShortOptions = "vqnlLkKWHdDogptUNOJrRxSIbumcCzEAXyi"
+
+// rsyncArgs checks the command line of an "rsync --server" invocation and
+// maps its paths into fs, returning the arguments to run rsync with
+func rsyncArgs(words []string, fs *rootedFS) ([]string, error) {
+	if len(words) < 2 || words[0] != "rsync" || words[1] != "--server" {
+		return nil, errors.New("not an rsync server command")
+	}
+	args := []string{"--server"}
+	sender := false
+	i := 2
+	for ; i < len(words) && words[i] != "."; i++ {
+		arg := words[i]
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name, _, _ := strings.Cut(arg[2:], "=")
+			if !rsyncLongOptions[name] {
+				return nil, fmt.Errorf("rsync option %s is not allowed", arg)
+			}
+			if name == "sender" {
+				sender = true
+			}
+			if name == "remove-source-files" && fs.readOnly {
+				return nil, fmt.Errorf("rsync option %s is not allowed: read-only", arg)
+			}
+		case strings.HasPrefix(arg, "-"):
+			// The short options end at "e", which introduces the
+			// capabilities of the client rather than the --rsh option
+			flags, _, _ := strings.Cut(arg[1:], "e")
+			// With -s (--protect-args) the paths are sent inside the
+			// protocol, where they cannot be checked
+			if strings.ContainsRune(flags, 's') {
+				return nil, errors.New("rsync --protect-args is not supported")
+			}
+			for _, flag := range flags {
+				if !strings.ContainsRune(rsyncShortOptions, flag) {
+					return nil, fmt.Errorf("rsync option -%c is not allowed", flag)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unexpected rsync argument %q", arg)
+		}
+		args = append(args, arg)
+	}
+	if i+1 >= len(words) {
+		return nil, errors.New("rsync command has no paths")
+	}
+	if !sender && fs.readOnly {
+		return nil, errors.New("uploads are not allowed: read-only")
+	}
+	args = append(args, ".")
+	for _, name := range words[i+1:] {
+		p, err := fs.hostPath(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, clientError(err))
+		}
+		// A trailing slash makes rsync copy a directory's contents rather
+		// than the directory itself
+		if strings.HasSuffix(name, "/") && !strings.HasSuffix(p, "/") {
+			p += "/"
+		}
+		args = append(args, p)
+	}
+	return args, nil
+}
+
+// runRsync runs the local rsync as the server side of a client's transfer,
+// with its paths confined to the same root as SFTP. rsync follows symbolic
+// links already inside the root like any local program; ChrootDirectory
+// gives a hard boundary.
+func (s *session) runRsync(words []string) {
+	fail := func(err error) {
+		s.logger.Warn("rsync request refused", "error", err)
+		fmt.Fprintf(s.channel.Stderr(), "rsync: %s\n", err)
+		sendExitStatus(s.channel, 1)
+		s.channel.Close()
+	}
+
+	root := s.fileRoot()
+	if root == "" {
+		root = "/"
+	}
+	fs, err := newRootedFS(root, s.opts.SFTPReadOnly)
+	if err != nil {
+		fail(err)
+		return
+	}
+	args, err := rsyncArgs(words, fs)
+	if err != nil {
+		fail(err)
+		return
+	}
+	s.logger.Info("rsync request", "args", strings.Join(args, " "))
+	cmd := exec.CommandContext(s.ctx, "rsync", args...)
+	cmd.Env = s.shellEnv()
+	if s.account != nil {
+		runAs(cmd, s.account)
+	}
+	cmd.Dir = fs.root
+	s.runProcess(cmd)
+}
@@ -0,0 +1,64 @@
+// pkg/ssh/rsync_test.go
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRsyncArgs(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "backups"), 0o755)
+	os.Symlink("/etc", filepath.Join(root, "etc"))
+	resolved, _ := filepath.EvalSymlinks(root)
+
+	tests := []struct {
+		name     string
+		command  string
+		readOnly bool
+		want     []string
+		wantErr  bool
+	}{
+		{"upload", "rsync --server -vlogDtpre.iLsfxCIvu . backups", false,
+			[]string{"--server", "-vlogDtpre.iLsfxCIvu", ".", filepath.Join(resolved, "backups")}, false},
+		{"download contents", "rsync --server --sender -vlogDtpre.iLsfxCIvu . /backups/", false,
+			[]string{"--server", "--sender", "-vlogDtpre.iLsfxCIvu", ".", filepath.Join(resolved, "backups") + "/"}, false},
+		{"escape with dots", "rsync --server --sender -r . ../../backups/db", false,
+			[]string{"--server", "--sender", "-r", ".", filepath.Join(resolved, "backups", "db")}, false},
+		{"read-only download", "rsync --server --sender -r . backups", true,
+			[]string{"--server", "--sender", "-r", ".", filepath.Join(resolved, "backups")}, false},
+		{"read-only upload", "rsync --server -r . backups", true, nil, true},
+		{"read-only remove", "rsync --server --sender --remove-source-files -r . backups", true, nil, true},
+		{"symlink outside", "rsync --server --sender -r . etc/passwd", false, nil, true},
+		{"path option", "rsync --server --log-file=/tmp/x -r . backups", false, nil, true},
+		{"protect args", "rsync --server -vse.iLsfxCIvu . backups", false, nil, true},
+		{"temp dir", "rsync --server -vlT/tmp . backups", false, nil, true},
+		{"separate temp dir", "rsync --server -T /tmp . backups", false, nil, true},
+		{"block size", "rsync --server -rB1024 . backups", false, nil, true},
+		{"filter", "rsync --server -rf . backups", false, nil, true},
+		{"archive flags", "rsync --server -vvnlHogDtprxSzcumiEAXe.iLsfxCIvu . backups", false,
+			[]string{"--server", "-vvnlHogDtprxSzcumiEAXe.iLsfxCIvu", ".", filepath.Join(resolved, "backups")}, false},
+		{"no paths", "rsync --server -r .", false, nil, true},
+		{"not a server", "rsync -r . backups", false, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, err := newRootedFS(root, tt.readOnly)
+			if err != nil {
+				t.Fatalf("newRootedFS failed: %v", err)
+			}
+			words, _ := shellWords(tt.command)
+			got, err := rsyncArgs(words, fs)
+			if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+				t.Errorf("rsyncArgs() = %q, %v, want %q", got, err, tt.want)
+			}
+			if err != nil && strings.Contains(err.Error(), resolved) {
+				t.Errorf("rsyncArgs() error %q reveals the root directory", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+)
+
+// defaultPath is the PATH of programs run as a local account, which do not
+// inherit the server's environment
+const defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+// osAccount is the local account whose identity session programs run with
+type osAccount struct {
+	name   string
+	uid    uint32
+	gid    uint32
+	groups []uint32
+	home   string
+}
+
+// lookupAccount finds the local account named after an SSH user in the
+// passwd database, along with its supplementary groups
+func lookupAccount(name string) (*osAccount, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, fmt.Errorf("no local account for %q: %s", name, err)
+	}
+	account := &osAccount{name: u.Username, home: u.HomeDir}
+	if account.uid, err = parseID(u.Uid); err != nil {
+		return nil, err
+	}
+	if account.gid, err = parseID(u.Gid); err != nil {
+		return nil, err
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("could not list groups of %q: %s", name, err)
+	}
+	for _, group := range groups {
+		gid, err := parseID(group)
+		if err != nil {
+			return nil, err
+		}
+		account.groups = append(account.groups, gid)
+	}
+	return account, nil
+}
+
+// parseID parses a numeric user or group ID
+func parseID(id string) (uint32, error) {
+	n, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user or group ID %q", id)
+	}
+	return uint32(n), nil
+}
+
+// environ returns the login environment of the account for a session
+// running shell, in place of the server's own environment
+func (a *osAccount) environ(shell string) []string {
+	env := []string{"HOME=" + a.home, "USER=" + a.name, "LOGNAME=" + a.name, "PATH=" + defaultPath}
+	if shell != "" {
+		env = append(env, "SHELL="+shell)
+	}
+	return env
+}
+
+// workDir returns the directory programs start in: the home directory, or /
+// when the account has none, as with system accounts like nobody
+func (a *osAccount) workDir() string {
+	if info, err := os.Stat(a.home); err == nil && info.IsDir() {
+		return a.home
+	}
+	return "/"
+}
+
+// prepareCommand sets up cmd to run for the session: as its local account
+// when RunAsUser is on, and inside its chroot directory when one is set
+func (s *session) prepareCommand(cmd *exec.Cmd) error {
+	if s.account != nil {
+		runAs(cmd, s.account)
+	}
+	return s.confine(cmd)
+}
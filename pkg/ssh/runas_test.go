@@ -0,0 +1,105 @@
+// pkg/ssh/runas_test.go
+package ssh
+
+import (
+	"context"
+	"os/user"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLookupAccount(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("no current user: %v", err)
+	}
+	account, err := lookupAccount(current.Username)
+	if err != nil {
+		t.Fatalf("lookupAccount(%q) error = %v", current.Username, err)
+	}
+	if current.Uid != "" && runtime.GOOS != "windows" {
+		if uid, _ := parseID(current.Uid); account.uid != uid {
+			t.Errorf("uid = %d, want %s", account.uid, current.Uid)
+		}
+	}
+	if account.home != current.HomeDir {
+		t.Errorf("home = %q, want %q", account.home, current.HomeDir)
+	}
+
+	if _, err := lookupAccount("gossh-no-such-user"); err == nil {
+		t.Error("lookupAccount should fail for a user without a local account")
+	}
+}
+
+func TestOSAccountEnviron(t *testing.T) {
+	account := &osAccount{name: "alice", home: "/home/alice"}
+	env := account.environ("/bin/bash")
+	for _, want := range []string{"HOME=/home/alice", "USER=alice", "LOGNAME=alice", "SHELL=/bin/bash", "PATH=" + defaultPath} {
+		if !slices.Contains(env, want) {
+			t.Errorf("environ() = %v, missing %s", env, want)
+		}
+	}
+
+	if dir := (&osAccount{home: "/nonexistent"}).workDir(); dir != "/" {
+		t.Errorf("workDir() without a home directory = %q, want /", dir)
+	}
+}
+
+func TestNewServerRunAsUserConfinesTransfers(t *testing.T) {
+	if !isPrivileged() {
+		t.Skip("running sessions as another user requires root")
+	}
+	hostKey, _ := newTestKeyPair(t)
+	tests := []struct {
+		name    string
+		opts    ServerOptions
+		wantErr bool
+	}{
+		{"sftp unconfined", ServerOptions{SFTP: true}, true},
+		{"scp unconfined", ServerOptions{SCP: true}, true},
+		{"sftp root", ServerOptions{SFTP: true, SCP: true, SFTPRoot: t.TempDir()}, false},
+		{"chroot directory", ServerOptions{SFTP: true, ChrootDirectory: "/srv/drop/%u"}, false},
+		{"no transfers", ServerOptions{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.RunAsUser = true
+			_, err := NewServer(hostKey, nil, tt.opts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServerRunAsUser(t *testing.T) {
+	if !isPrivileged() {
+		if _, err := NewServer(nil, nil, ServerOptions{RunAsUser: true}); err == nil {
+			t.Error("NewServer should refuse RunAsUser without root privileges")
+		}
+		t.Skip("running sessions as another user requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("no nobody account")
+	}
+
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{Shell: "/bin/sh", RunAsUser: true})
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "nobody", signer)
+	defer client.Close()
+	out := runTestCommand(t, client, "id -u; echo $USER")
+	if want := nobody.Uid + "\nnobody\n"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+
+	// Users without a local account cannot open sessions
+	stranger := dialTestServer(t, addr, "gossh-no-such-user", signer)
+	defer stranger.Close()
+	if _, err := stranger.NewSession(); err == nil || !strings.Contains(err.Error(), "no session environment") {
+		t.Errorf("NewSession() error = %v, want a rejection", err)
+	}
+}
@@ -0,0 +1,450 @@
+package ssh
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scpCommand is a parsed "scp -t" (upload) or "scp -f" (download) command,
+// which scp clients run on the server when they use the legacy protocol
+type scpCommand struct {
+	sink      bool
+	recursive bool
+	preserve  bool
+	targetDir bool
+	paths     []string
+}
+
+// parseSCPCommand recognizes the command line an scp client runs on the
+// server, e.g. "scp -r -t -- uploads"
+func parseSCPCommand(command string) (*scpCommand, bool) {
+	words, err := shellWords(command)
+	if err != nil || len(words) < 2 || words[0] != "scp" {
+		return nil, false
+	}
+	cmd := &scpCommand{}
+	var to, from bool
+	args := words[1:]
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") && len(args[0]) > 1 {
+		flags := args[0]
+		args = args[1:]
+		if flags == "--" {
+			break
+		}
+		for _, flag := range flags[1:] {
+			switch flag {
+			case 't':
+				to = true
+			case 'f':
+				from = true
+			case 'r':
+				cmd.recursive = true
+			case 'p':
+				cmd.preserve = true
+			case 'd':
+				cmd.targetDir = true
+			case 'v', 'q':
+			default:
+				return nil, false
+			}
+		}
+	}
+	cmd.sink, cmd.paths = to, args
+	if to == from || len(args) == 0 || (to && len(args) != 1) {
+		return nil, false
+	}
+	return cmd, true
+}
+
+// runSCP serves an scp upload or download in the server process, with
+// paths confined and restricted like SFTP
+func (s *session) runSCP(cmd *scpCommand) {
+	defer s.channel.Close()
+	ok, err := serveSCP(s.channel, s.fileRoot(), s.opts.SFTPReadOnly, s.fileAudit("scp"), cmd)
+	if err != nil {
+		s.logger.Warn("scp session error", "error", err)
+	}
+	if !ok {
+		sendExitStatus(s.channel, 1)
+		return
+	}
+	sendExitStatus(s.channel, 0)
+}
+
+// scpRemoteError is an error the other side reported instead of an
+// acknowledgement. Fatal errors end the transfer; others skip one file.
+type scpRemoteError struct {
+	message string
+	fatal   bool
+}
+
+func (e *scpRemoteError) Error() string { return e.message }
+
+// scpTransfer runs one side of a legacy scp transfer on a channel, with
+// paths resolved inside a rootedFS like SFTP paths
+type scpTransfer struct {
+	fs     *rootedFS
+	cmd    *scpCommand
+	in     *bufio.Reader
+	out    io.Writer
+	failed bool
+}
+
+// serveSCP runs an scp upload or download on the channel. It reports
+// whether every file was transferred.
+func serveSCP(channel io.ReadWriter, root string, readOnly bool, audit sftpAuditFunc, cmd *scpCommand) (bool, error) {
+	if root == "" {
+		root = "/"
+	}
+	fs, err := newRootedFS(root, readOnly)
+	if err != nil {
+		return false, err
+	}
+	fs.audit = audit
+	t := &scpTransfer{fs: fs, cmd: cmd, in: bufio.NewReader(channel), out: channel}
+	if cmd.sink {
+		err = t.sink(cmd.paths[0])
+	} else {
+		err = t.source(cmd.paths)
+	}
+	if err != nil {
+		// Tell the client why the transfer stopped, unless it was the client
+		// that stopped it
+		var remote *scpRemoteError
+		if !errors.As(err, &remote) && !errors.Is(err, io.EOF) {
+			fmt.Fprintf(t.out, "\x02scp: %s\n", clientError(err))
+		}
+		return false, err
+	}
+	return !t.failed, nil
+}
+
+// ack tells the other side the last message was handled
+func (t *scpTransfer) ack() error {
+	_, err := t.out.Write([]byte{0})
+	return err
+}
+
+// warn reports a file that could not be transferred and carries on
+func (t *scpTransfer) warn(name string, err error) error {
+	t.failed = true
+	_, werr := fmt.Fprintf(t.out, "\x01scp: %s: %s\n", name, clientError(err))
+	return werr
+}
+
+// clientError strips the host path from filesystem errors, which would
+// reveal where the root directory is
+func clientError(err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err
+	}
+	return err
+}
+
+// readAck waits for the other side to acknowledge a message
+func (t *scpTransfer) readAck() error {
+	b, err := t.in.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		message, _ := t.in.ReadString('\n')
+		return &scpRemoteError{message: strings.TrimSuffix(message, "\n"), fatal: b == 2}
+	}
+	return fmt.Errorf("unexpected scp response %q", b)
+}
+
+// sink receives files into target, a directory or, for a single file, the
+// file to write
+func (t *scpTransfer) sink(target string) error {
+	if t.fs.readOnly {
+		return os.ErrPermission
+	}
+	p, err := t.fs.hostPath(target)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(p)
+	isDir := err == nil && info.IsDir()
+	if t.cmd.targetDir && !isDir {
+		return fmt.Errorf("%s: not a directory", target)
+	}
+	if err := t.ack(); err != nil {
+		return err
+	}
+	err = t.receive(target, isDir)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// receive handles the messages for the contents of dir until the client
+// ends the directory or the transfer
+func (t *scpTransfer) receive(dir string, isDir bool) error {
+	var mtime time.Time
+	for {
+		line, err := t.in.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return errors.New("empty scp message")
+		}
+		switch line[0] {
+		case 1, 2:
+			t.failed = true
+			if line[0] == 2 {
+				return &scpRemoteError{message: line[1:], fatal: true}
+			}
+			continue
+		case 'E':
+			return t.ack()
+		case 'T':
+			var sec, usec, asec, ausec int64
+			if _, err := fmt.Sscanf(line, "T%d %d %d %d", &sec, &usec, &asec, &ausec); err != nil {
+				return fmt.Errorf("invalid scp times %q", line)
+			}
+			mtime = time.Unix(sec, usec*1000)
+			if err := t.ack(); err != nil {
+				return err
+			}
+			continue
+		case 'C', 'D':
+		default:
+			return fmt.Errorf("invalid scp message %q", line)
+		}
+
+		mode, size, name, err := parseSCPHeader(line)
+		if err != nil {
+			return err
+		}
+		target := dir
+		if isDir {
+			target = path.Join(dir, name)
+		}
+		if line[0] == 'D' {
+			if !t.cmd.recursive {
+				return errors.New("received a directory without -r")
+			}
+			err = t.receiveDir(target, mode)
+		} else {
+			err = t.receiveFile(target, mode, size)
+		}
+		if err != nil {
+			return err
+		}
+		if t.cmd.preserve && !mtime.IsZero() {
+			if p, err := t.fs.hostPath(target); err == nil {
+				os.Chtimes(p, mtime, mtime)
+			}
+		}
+		mtime = time.Time{}
+	}
+}
+
+// parseSCPHeader parses a "C0644 12 name" or "D0755 0 name" message
+func parseSCPHeader(line string) (os.FileMode, int64, string, error) {
+	fields := strings.SplitN(line[1:], " ", 3)
+	if len(fields) != 3 {
+		return 0, 0, "", fmt.Errorf("invalid scp header %q", line)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid scp file mode %q", fields[0])
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("invalid scp file size %q", fields[1])
+	}
+	name := fields[2]
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return 0, 0, "", fmt.Errorf("invalid scp file name %q", name)
+	}
+	return os.FileMode(mode).Perm(), size, name, nil
+}
+
+// receiveDir creates a directory, unless it exists, and receives its contents
+func (t *scpTransfer) receiveDir(dir string, mode os.FileMode) error {
+	p, err := t.fs.hostPath(dir)
+	if err == nil {
+		if info, statErr := os.Stat(p); statErr != nil || !info.IsDir() {
+			err = os.Mkdir(p, mode|0o700)
+			t.fs.recordPath("mkdir", dir, err)
+		}
+	}
+	if err != nil {
+		return t.warn(dir, err)
+	}
+	if err := t.ack(); err != nil {
+		return err
+	}
+	return t.receive(dir, true)
+}
+
+// receiveFile writes size bytes from the client to name. The contents are
+// read even when the file cannot be written, to stay in step with the client.
+func (t *scpTransfer) receiveFile(name string, mode os.FileMode, size int64) error {
+	if err := t.ack(); err != nil {
+		return err
+	}
+	var f *os.File
+	p, err := t.fs.hostPath(name)
+	if err == nil {
+		f, err = os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	}
+	var dst io.Writer = io.Discard
+	if f != nil {
+		dst = f
+	}
+	if _, copyErr := io.CopyN(dst, t.in, size); copyErr != nil {
+		if f != nil {
+			f.Close()
+		}
+		return copyErr
+	}
+	if f != nil {
+		err = f.Close()
+	}
+	t.fs.recordPath("upload", name, err)
+	if ackErr := t.readAck(); ackErr != nil {
+		return ackErr
+	}
+	if err != nil {
+		return t.warn(name, err)
+	}
+	return t.ack()
+}
+
+// source sends the files and, with -r, directories named by paths, which
+// may contain the glob patterns a remote shell would expand
+func (t *scpTransfer) source(paths []string) error {
+	if err := t.readAck(); err != nil {
+		return err
+	}
+	for _, pattern := range paths {
+		for _, name := range t.glob(pattern) {
+			if err := t.send(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// glob expands a pattern inside the root, returning it unchanged when it
+// matches nothing so the client is told the file does not exist
+func (t *scpTransfer) glob(pattern string) []string {
+	clean := path.Clean("/" + pattern)
+	if !strings.ContainsAny(clean, "*?[") {
+		return []string{clean}
+	}
+	matches, _ := filepath.Glob(filepath.Join(t.fs.root, filepath.FromSlash(clean)))
+	if len(matches) == 0 {
+		return []string{clean}
+	}
+	names := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if rel, err := filepath.Rel(t.fs.root, match); err == nil {
+			names = append(names, "/"+filepath.ToSlash(rel))
+		}
+	}
+	return names
+}
+
+// send transfers one file or directory to the client
+func (t *scpTransfer) send(name string) error {
+	p, err := t.fs.hostPath(name)
+	if err != nil {
+		return t.warn(name, err)
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return t.warn(name, err)
+	}
+	if info.IsDir() && t.cmd.recursive {
+		return t.sendDir(name, p, info)
+	}
+	if !info.Mode().IsRegular() {
+		return t.warn(name, errors.New("not a regular file"))
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return t.warn(name, t.fs.recordPath("download", name, err))
+	}
+	defer f.Close()
+
+	if err := t.sendTimes(info); err != nil {
+		return err
+	}
+	fmt.Fprintf(t.out, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), path.Base(name))
+	if err := t.readAck(); err != nil {
+		return t.skip(err)
+	}
+	if _, err := io.CopyN(t.out, f, info.Size()); err != nil {
+		// The client is waiting for exactly this many bytes, so a file that
+		// shrank mid-transfer cannot be recovered from
+		return t.fs.recordPath("download", name, err)
+	}
+	t.fs.recordPath("download", name, nil)
+	if err := t.ack(); err != nil {
+		return err
+	}
+	return t.skip(t.readAck())
+}
+
+// sendDir transfers a directory and everything below it
+func (t *scpTransfer) sendDir(name, p string, info os.FileInfo) error {
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return t.warn(name, err)
+	}
+	if err := t.sendTimes(info); err != nil {
+		return err
+	}
+	fmt.Fprintf(t.out, "D%04o 0 %s\n", info.Mode().Perm(), path.Base(name))
+	if err := t.readAck(); err != nil {
+		return t.skip(err)
+	}
+	for _, entry := range entries {
+		if err := t.send(path.Join(name, entry.Name())); err != nil {
+			return err
+		}
+	}
+	fmt.Fprint(t.out, "E\n")
+	return t.skip(t.readAck())
+}
+
+// sendTimes sends the modification time of the next file with -p
+func (t *scpTransfer) sendTimes(info os.FileInfo) error {
+	if !t.cmd.preserve {
+		return nil
+	}
+	mtime := info.ModTime().Unix()
+	fmt.Fprintf(t.out, "T%d 0 %d 0\n", mtime, mtime)
+	return t.skip(t.readAck())
+}
+
+// skip ends the transfer of one file when the client reported a non-fatal
+// error for it, and the whole transfer otherwise
+func (t *scpTransfer) skip(err error) error {
+	var remote *scpRemoteError
+	if errors.As(err, &remote) && !remote.fatal {
+		t.failed = true
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+// pkg/ssh/scp_test.go
+package ssh
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseSCPCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    *scpCommand
+	}{
+		{"scp -t -- uploads", &scpCommand{sink: true, paths: []string{"uploads"}}},
+		{"scp -v -r -p -d -t -- dir", &scpCommand{sink: true, recursive: true, preserve: true, targetDir: true, paths: []string{"dir"}}},
+		{"scp -rf a.txt 'b c.txt'", &scpCommand{recursive: true, paths: []string{"a.txt", "b c.txt"}}},
+		{"scp -f -- -odd", &scpCommand{paths: []string{"-odd"}}},
+		{"scp -t a b", nil},
+		{"scp -t -f a", nil},
+		{"scp -x -t a", nil},
+		{"scp a b", nil},
+		{"sftp -t a", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			got, ok := parseSCPCommand(tt.command)
+			if ok != (tt.want != nil) {
+				t.Fatalf("parseSCPCommand() ok = %v, want %v", ok, tt.want != nil)
+			}
+			if ok && (got.sink != tt.want.sink || got.recursive != tt.want.recursive ||
+				got.preserve != tt.want.preserve || got.targetDir != tt.want.targetDir ||
+				len(got.paths) != len(tt.want.paths) || got.paths[len(got.paths)-1] != tt.want.paths[len(tt.want.paths)-1]) {
+				t.Errorf("parseSCPCommand() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// startSCPServer starts a server with opts for the OpenSSH scp client,
+// returning its address and the path of the client's private key
+func startSCPServer(t *testing.T, opts ServerOptions) (string, string) {
+	t.Helper()
+	for _, tool := range []string{"scp", "ssh"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("requires %s", tool)
+		}
+	}
+	keyPEM, signer := newTestKeyPair(t)
+	key := filepath.Join(t.TempDir(), "id")
+	os.WriteFile(key, keyPEM, 0600)
+
+	hostKey, _ := newTestKeyPair(t)
+	server, err := NewServer(hostKey, ssh.MarshalAuthorizedKey(signer.PublicKey()), opts)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go server.Serve(ctx, listener)
+	return listener.Addr().String(), key
+}
+
+// runSCP copies with the legacy scp protocol to or from the server at addr
+func runSCP(t *testing.T, addr, key string, args ...string) (string, error) {
+	t.Helper()
+	_, port, _ := net.SplitHostPort(addr)
+	args = append([]string{"-O", "-P", port, "-i", key, "-o", "IdentitiesOnly=yes",
+		"-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null", "-o", "LogLevel=ERROR"}, args...)
+	out, err := exec.Command("scp", args...).CombinedOutput()
+	return string(out), err
+}
+
+func TestServerSCP(t *testing.T) {
+	root := t.TempDir()
+	os.MkdirAll(filepath.Join(root, "uploads"), 0o755)
+	os.Symlink("/etc", filepath.Join(root, "etc"))
+	addr, key := startSCPServer(t, ServerOptions{SCP: true, SFTPRoot: root})
+
+	local := t.TempDir()
+	os.WriteFile(filepath.Join(local, "report.txt"), []byte("quarterly numbers"), 0o644)
+	os.MkdirAll(filepath.Join(local, "site", "css"), 0o755)
+	os.WriteFile(filepath.Join(local, "site", "css", "main.css"), []byte("body {}"), 0o644)
+
+	// Uploads of single files and directory trees land inside the root
+	if out, err := runSCP(t, addr, key, "-p", filepath.Join(local, "report.txt"), "alice@127.0.0.1:/uploads/"); err != nil {
+		t.Fatalf("upload failed: %v: %s", err, out)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "uploads", "report.txt")); err != nil || string(data) != "quarterly numbers" {
+		t.Errorf("uploaded file = %q, %v", data, err)
+	}
+	if out, err := runSCP(t, addr, key, "-r", filepath.Join(local, "site"), "alice@127.0.0.1:uploads"); err != nil {
+		t.Fatalf("recursive upload failed: %v: %s", err, out)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "uploads", "site", "css", "main.css")); err != nil || string(data) != "body {}" {
+		t.Errorf("uploaded tree file = %q, %v", data, err)
+	}
+
+	// Downloads, including globs and whole directories
+	download := t.TempDir()
+	if out, err := runSCP(t, addr, key, "alice@127.0.0.1:uploads/*.txt", download); err != nil {
+		t.Fatalf("download failed: %v: %s", err, out)
+	}
+	if data, err := os.ReadFile(filepath.Join(download, "report.txt")); err != nil || string(data) != "quarterly numbers" {
+		t.Errorf("downloaded file = %q, %v", data, err)
+	}
+	if out, err := runSCP(t, addr, key, "-r", "alice@127.0.0.1:/uploads/site", download); err != nil {
+		t.Fatalf("recursive download failed: %v: %s", err, out)
+	}
+	if _, err := os.Stat(filepath.Join(download, "site", "css", "main.css")); err != nil {
+		t.Errorf("recursive download missing file: %v", err)
+	}
+
+	// Paths outside the root cannot be read or written
+	if out, err := runSCP(t, addr, key, "alice@127.0.0.1:etc/hostname", download); err == nil {
+		t.Errorf("download through a symlink out of the root succeeded: %s", out)
+	}
+	if out, err := runSCP(t, addr, key, filepath.Join(local, "report.txt"), "alice@127.0.0.1:etc/"); err == nil {
+		t.Errorf("upload through a symlink out of the root succeeded: %s", out)
+	}
+	if _, err := os.Stat("/etc/report.txt"); err == nil {
+		t.Error("upload escaped the root")
+	}
+}
+
+func TestServerSCPReadOnly(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "notes.txt"), []byte("read me"), 0o644)
+	addr, key := startSCPServer(t, ServerOptions{SCP: true, SFTPRoot: root, SFTPReadOnly: true})
+
+	download := t.TempDir()
+	if out, err := runSCP(t, addr, key, "alice@127.0.0.1:notes.txt", download); err != nil {
+		t.Fatalf("download failed: %v: %s", err, out)
+	}
+	if out, err := runSCP(t, addr, key, filepath.Join(download, "notes.txt"), "alice@127.0.0.1:copy.txt"); err == nil {
+		t.Errorf("upload to a read-only server succeeded: %s", out)
+	}
+	if _, err := os.Stat(filepath.Join(root, "copy.txt")); err == nil {
+		t.Error("read-only server accepted a file")
+	}
+}
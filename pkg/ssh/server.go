@@ -1,154 +1,1023 @@
 package ssh
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/term"
 )
 
-// StartServer starts an SSH server with the given private key and authorized keys
-func StartServer(privateKey []byte, authorizedKeys []byte) error {
-	authorizedKeysMap := map[string]bool{}
-	for len(authorizedKeys) > 0 {
-		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeys)
-		if err != nil {
-			return fmt.Errorf("parse authorized keys error: %s", err)
+const (
+	// DefaultBindAddress is the address the server listens on when none is configured
+	DefaultBindAddress = "0.0.0.0"
+	// DefaultPort is the port the server listens on when none is configured
+	DefaultPort = "2022"
+)
+
+// InternalSFTP as ForceCommand or a key's command= option serves SFTP to
+// every request, as in OpenSSH
+const InternalSFTP = "internal-sftp"
+
+// ServerOptions holds the settings that control how the SSH server runs
+type ServerOptions struct {
+	// BindAddress is the local address to listen on (default DefaultBindAddress)
+	BindAddress string
+	// Port is the TCP port to listen on (default DefaultPort)
+	Port string
+	// ListenAddrs are the endpoints Start listens on, all served at once:
+	// host:port for TCP or unix:///path for a Unix socket, e.g.
+	// "[::1]:2022". When set, BindAddress and Port are ignored.
+	ListenAddrs []string
+	// AllowedCommands restricts exec and shell commands to the listed names.
+	// An empty list leaves commands unrestricted.
+	AllowedCommands []string
+	// Shell is the program started for interactive sessions, e.g. /bin/bash.
+	// It runs on a native PTY when the client requests one. Exec requests for
+	// commands that are not registered run as "Shell -c command". When empty,
+	// or when AllowedCommands is set, sessions get the built-in line-based
+	// shell and exec requests only run registered commands.
+	Shell string
+	// AcceptEnv lists the environment variables clients may set with "env"
+	// requests. Entries may use the wildcards * and ?, e.g. "LC_*".
+	AcceptEnv []string
+	// SFTP enables the "sftp" subsystem
+	SFTP bool
+	// SFTPRoot confines SFTP clients to a directory. When empty, clients see
+	// the whole filesystem with the permissions of the server process.
+	SFTPRoot string
+	// SFTPReadOnly rejects every SFTP operation that would modify files
+	SFTPReadOnly bool
+	// SCP serves scp uploads and downloads with the legacy protocol (scp -O)
+	// and rsync transfers, confined and restricted like SFTP by SFTPRoot,
+	// SFTPReadOnly and ChrootDirectory. rsync transfers run the local rsync,
+	// which must be on the server's PATH.
+	SCP bool
+	// ChrootDirectory confines exec, shell and SFTP sessions to a directory,
+	// like the OpenSSH option of the same name; %u is replaced with the user
+	// name, e.g. "/srv/drop/%u". SFTP paths are resolved inside it, with
+	// SFTPRoot relative to it. Programs are chrooted into it, which needs
+	// root and a root-owned path that no one else can write to; without
+	// root only built-in commands and SFTP are available.
+	ChrootDirectory string
+	// RunAsUser runs shells and commands as the local account named after
+	// the SSH user, with its UID, GID, groups, home directory and a login
+	// environment, instead of as the server process. The server must run as
+	// root, and users without a local account cannot open sessions. Built-in
+	// commands, SFTP and SCP still run in the server process, so with SFTP or
+	// SCP enabled SFTPRoot or ChrootDirectory must be set.
+	RunAsUser bool
+	// ForceCommand is run for every exec, shell and subsystem request in
+	// place of what the client asked for, which is passed to it in
+	// SSH_ORIGINAL_COMMAND, e.g. for git or backup endpoints. It runs like
+	// an exec request, overriding command= key options; InternalSFTP serves
+	// SFTP instead.
+	ForceCommand string
+	// GitRoot serves git fetches and pushes (git-upload-pack,
+	// git-receive-pack) for the repositories below it, so clients can use
+	// URLs like ssh://host:2022/team/app.git. Keys may read every repository
+	// unless their git-access= option says otherwise, e.g.
+	// git-access="write:team/*,read". Requires git on the server's PATH.
+	GitRoot string
+	// AllowLocalForwarding enables "direct-tcpip" channels (ssh -L)
+	AllowLocalForwarding bool
+	// LocalForwardPolicy restricts the destinations local forwarding may reach
+	LocalForwardPolicy ForwardPolicy
+	// AllowAgentForwarding lets clients forward their agent (ssh -A), so the
+	// programs of their sessions can use it through SSH_AUTH_SOCK. Keys with
+	// the no-agent-forwarding option, and certificates without
+	// permit-agent-forwarding, are refused.
+	AllowAgentForwarding bool
+	// DisableX11Forwarding refuses X11 forwarding (ssh -X). Otherwise programs
+	// of a session that asked for it display on the client through DISPLAY,
+	// unless the key has the no-x11-forwarding option or is a certificate
+	// without permit-X11-forwarding.
+	DisableX11Forwarding bool
+	// AllowRemoteForwarding enables "tcpip-forward" requests (ssh -R)
+	AllowRemoteForwarding bool
+	// RemoteForwardPolicy restricts the addresses remote forwards may listen on
+	RemoteForwardPolicy ForwardPolicy
+	// UserRemoteForwardPolicies overrides RemoteForwardPolicy for specific users
+	UserRemoteForwardPolicies map[string]ForwardPolicy
+	// PasswordAuth enables password authentication. It is off by default and
+	// has no effect unless Passwords is also set.
+	PasswordAuth bool
+	// Passwords is the credential store consulted for password authentication
+	Passwords PasswordStore
+	// TOTP requires a time-based one-time code, asked for with
+	// keyboard-interactive authentication, after public key or password auth
+	TOTP bool
+	// TOTPSecretDir holds one file per user, named after the user, containing
+	// the user's base32 TOTP secret. Users without a file cannot log in.
+	TOTPSecretDir string
+	// TrustedUserCAKeys holds CA public keys in authorized_keys format. User
+	// certificates signed by one of them are accepted when they list the
+	// login name as a principal and are within their validity window.
+	TrustedUserCAKeys []byte
+	// AuthorizedKeysDir holds one authorized_keys file per user, named after
+	// the user, e.g. /etc/gossh/authorized_keys.d/alice. Keys in a user's file
+	// only authenticate that user; the shared authorized keys still apply.
+	AuthorizedKeysDir string
+	// Authenticators are asked, in order, about public keys that are not in
+	// the authorized keys or AuthorizedKeysDir, e.g. an HTTPAuthenticator or
+	// LDAPAuthenticator. The first to accept or reject a key decides.
+	Authenticators []Authenticator
+	// MaxConnections caps the number of simultaneous client connections.
+	// Further clients are shown a banner explaining the limit and
+	// disconnected. Zero means unlimited.
+	MaxConnections int
+	// MaxSessionsPerUser caps the number of open session channels per user
+	// across all of the user's connections. Zero means unlimited.
+	MaxSessionsPerUser int
+	// RateLimit caps the bytes per second each session channel may send
+	// and, separately, receive, covering exec output, shell I/O and SFTP
+	// transfers. Zero means unlimited.
+	RateLimit int64
+	// TransferQuota is the total number of bytes, in both directions, a
+	// session channel may carry before it is closed. Zero means unlimited.
+	TransferQuota int64
+	// IdleTimeout closes session channels, interactive or exec, that carry no
+	// data in either direction for this long. Terminals are warned beforehand,
+	// commands without a PTY on their stderr; subsystems such as sftp are
+	// closed without warning. Zero disables the timeout.
+	IdleTimeout time.Duration
+	// KeepAliveInterval sends authenticated clients a keepalive@openssh.com
+	// request this often, like OpenSSH's ClientAliveInterval, and
+	// disconnects those that leave KeepAliveCountMax of them unanswered.
+	// Zero disables keepalive requests.
+	KeepAliveInterval time.Duration
+	// KeepAliveCountMax is how many keepalive intervals may pass without an
+	// answer (default DefaultKeepAliveCountMax). It also bounds the
+	// unanswered probes of TCPKeepAlive.
+	KeepAliveCountMax int
+	// TCPKeepAlive enables TCP keepalive probes on accepted TCP connections
+	// after this long without traffic, so the kernel notices peers that
+	// vanished without closing the connection. Zero keeps the Go default of
+	// 15 seconds; a negative value disables TCP keepalives.
+	TCPKeepAlive time.Duration
+	// AuditLog receives one JSON object per line for every authentication
+	// attempt, session, command and SFTP file operation. Nil disables auditing.
+	AuditLog io.Writer
+	// RecordDir enables recording of interactive sessions. Each shell session
+	// is saved there as an asciicast v2 file that ReplayRecording can play.
+	RecordDir string
+	// HostKeys are additional PEM encoded host private keys, so clients that
+	// negotiate different host key algorithms (RSA, ECDSA, Ed25519) can all
+	// connect. See EnsureHostKeys for generating a standard set.
+	HostKeys [][]byte
+	// HostCertificates are host certificates in authorized_keys format, such
+	// as those signed by Vault's SSH secrets engine. Each is offered next to
+	// the host key it certifies, so clients trusting the CA need no
+	// known_hosts entry.
+	HostCertificates [][]byte
+	// AllowUsers, when set, limits logins to users matching one of its
+	// patterns, USER or USER@HOST as in OpenSSH, e.g. "deploy@10.0.0.0/8".
+	// Users are checked before their credentials.
+	AllowUsers []string
+	// DenyUsers rejects logins matching one of its patterns, even when
+	// AllowUsers matches too
+	DenyUsers []string
+	// AllowFrom limits the client addresses that may connect, using the
+	// syntax of the from= key option: IP wildcards or CIDR blocks, with ! to
+	// exclude. Other clients are disconnected before the SSH handshake.
+	AllowFrom []string
+	// ProxyProtocol expects every connection to start with a PROXY protocol
+	// v1 or v2 header, as sent by HAProxy and most load balancers, and uses
+	// the client address it names for AllowFrom, from= key options, logs
+	// and the audit log. Connections without a valid header are closed.
+	ProxyProtocol bool
+	// TrustedProxies limits ProxyProtocol to connections from these
+	// addresses, using the syntax of AllowFrom. Other clients connect
+	// directly and must not send a header. Empty trusts every source.
+	TrustedProxies []string
+	// GeoIP resolves client addresses to countries, e.g. an
+	// OpenGeoIPDatabase, for AllowCountries and DenyCountries. When set,
+	// audit events also carry the client's country.
+	GeoIP CountryResolver
+	// AllowCountries limits TCP clients to these ISO country codes, e.g.
+	// "DE"; UnknownCountry admits addresses GeoIP cannot place, such as
+	// private ones. Other clients are disconnected before the SSH handshake.
+	AllowCountries []string
+	// DenyCountries disconnects TCP clients from these country codes, even
+	// when AllowCountries lists them too
+	DenyCountries []string
+	// ServerVersion is the software version announced to clients, e.g.
+	// "OpenSSH_9.6"; SSH-2.0- is prepended when missing. Empty uses the
+	// golang.org/x/crypto/ssh default.
+	ServerVersion string
+	// DenyClientVersions disconnects clients whose identification string,
+	// e.g. "SSH-2.0-libssh_0.9.6", matches one of these path.Match patterns,
+	// before key exchange. Rejections are logged and audited as
+	// client_version_rejected events.
+	DenyClientVersions []string
+	// MaxAuthTries disconnects a client after this many failed
+	// authentication attempts on one connection. Zero means 6, like OpenSSH;
+	// a negative value allows unlimited attempts.
+	MaxAuthTries int
+	// AuthFailureDelay is waited after every failed authentication attempt
+	// before the client is answered, slowing down password and key guessing
+	AuthFailureDelay time.Duration
+	// Crypto restricts the key exchange, cipher, MAC and public key
+	// algorithms clients may negotiate, e.g. ModernCryptoPolicy. The zero
+	// value keeps the golang.org/x/crypto/ssh defaults.
+	Crypto CryptoPolicy
+	// Hooks are callbacks run on login, session start and end, exec and
+	// disconnect, for custom policy, notification or accounting
+	Hooks Hooks
+	// Banner is shown to clients before they authenticate, e.g. a legal
+	// notice. It is a text/template with the fields of BannerData, so
+	// "Connection from {{.RemoteIP}} is logged." names the client's address.
+	Banner string
+	// MOTD is printed at the start of every interactive shell session. It is
+	// a template like Banner. Exec, SFTP and forced command sessions skip it.
+	MOTD string
+	// Honeypot turns the server into a research and intrusion detection
+	// trap: every password, public key and keyboard-interactive answer is
+	// accepted, and sessions get a fake shell that imitates a Linux host
+	// without running anything. Credentials, commands and forwarding
+	// attempts are written to AuditLog. Shells, SFTP, scp, git, forwarding,
+	// chroot and the other features that reach the real system are off.
+	Honeypot bool
+	// HoneypotHostname is the host name the fake shell shows (default
+	// DefaultHoneypotHostname)
+	HoneypotHostname string
+	// Logger receives the server's log messages. Messages about a client
+	// carry its session_id, user and remote_addr attributes, so concurrent
+	// connections can be told apart. Nil uses slog.Default().
+	Logger *slog.Logger
+}
+
+// Addr returns the host:port the server listens on, applying defaults
+func (o ServerOptions) Addr() string {
+	bind := o.BindAddress
+	if bind == "" {
+		bind = DefaultBindAddress
+	}
+	port := o.Port
+	if port == "" {
+		port = DefaultPort
+	}
+	return net.JoinHostPort(bind, port)
+}
+
+// Endpoints returns the endpoints Start listens on: ListenAddrs, or Addr
+// when none are listed
+func (o ServerOptions) Endpoints() []string {
+	if len(o.ListenAddrs) > 0 {
+		return o.ListenAddrs
+	}
+	return []string{o.Addr()}
+}
+
+// commandAllowed reports whether the command line may be run under the options
+func (o ServerOptions) commandAllowed(cmdline string) bool {
+	if len(o.AllowedCommands) == 0 {
+		return true
+	}
+	fields := strings.Fields(cmdline)
+	if len(fields) == 0 {
+		return false
+	}
+	for _, allowed := range o.AllowedCommands {
+		if fields[0] == allowed {
+			return true
 		}
+	}
+	return false
+}
+
+// Server is an SSH server that can be started, drained and stopped
+type Server struct {
+	opts   ServerOptions
+	config *ssh.ServerConfig
+	// rejectConfig completes the handshake with clients over MaxConnections
+	// only to show them a banner; it never authenticates anyone
+	rejectConfig *ssh.ServerConfig
+	audit        *auditLog
+	logger       *slog.Logger
+	// motd is the parsed MOTD template, nil when none is configured
+	motd *template.Template
+	// commands serves exec requests and the built-in shell
+	commands *CommandRegistry
+	// subsystems serves the subsystems registered with RegisterSubsystem
+	subsystems subsystemRegistry
+
+	// authorizedKeys holds the shared authorized keys and AuthorizedKeysDir,
+	// consulted before opts.Authenticators
+	authorizedKeys *AuthorizedKeysAuthenticator
 
-		authorizedKeysMap[string(pubKey.Marshal())] = true
-		authorizedKeys = rest
+	mu        sync.Mutex
+	listeners []net.Listener
+	conns     map[net.Conn]struct{}
+	sessions  map[string]int
+	live      map[string]*liveConn // authenticated connections by session ID
+	// controlHandlers serve the commands of the control socket
+	controlHandlers map[string]ControlHandler
+	closing         bool
+	active          sync.WaitGroup
+}
+
+// NewServer creates a server from a host private key, authorized keys and options.
+// privateKey may be empty when opts.HostKeys provides the host keys.
+// The server does not listen until Start or Serve is called.
+func NewServer(privateKey []byte, authorizedKeys []byte, opts ServerOptions) (*Server, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if opts.Honeypot {
+		opts = opts.honeypot()
+	}
+	s := &Server{
+		opts:     opts,
+		conns:    map[net.Conn]struct{}{},
+		sessions: map[string]int{},
+		live:     map[string]*liveConn{},
+		audit:    newAuditLog(opts.AuditLog, opts.GeoIP, logger),
+		logger:   logger,
+		commands: NewCommandRegistry(),
+	}
+	s.controlHandlers = s.builtinControlHandlers()
+	keys, err := NewAuthorizedKeysAuthenticator(authorizedKeys, opts.AuthorizedKeysDir)
+	if err != nil {
+		return nil, err
 	}
+	keys.logger = logger
+	s.authorizedKeys = keys
+	authenticators := append([]Authenticator{keys}, opts.Authenticators...)
 
+	var certAuth *userCertAuthenticator
+	if len(opts.TrustedUserCAKeys) > 0 {
+		if certAuth, err = newUserCertAuthenticator(opts.TrustedUserCAKeys); err != nil {
+			return nil, err
+		}
+	}
+
+	version, err := opts.serverVersion()
+	if err != nil {
+		return nil, err
+	}
 	config := &ssh.ServerConfig{
+		ServerVersion: version,
+		MaxAuthTries:  opts.MaxAuthTries,
 		PublicKeyCallback: func(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-			if authorizedKeysMap[string(pubKey.Marshal())] {
-				return &ssh.Permissions{
-					// Record the public key used for authentication.
-					Extensions: map[string]string{
-						"pubkey-fp": ssh.FingerprintSHA256(pubKey),
-					},
-				}, nil
+			if cert, ok := pubKey.(*ssh.Certificate); ok && certAuth != nil {
+				return certAuth.authenticate(c, cert)
+			}
+			perms, err := authenticateKey(authenticators, c, pubKey)
+			if err != nil {
+				return nil, err
 			}
-			return nil, fmt.Errorf("unknown public key for %q", c.User())
+			// Record the public key used for authentication.
+			perms.Extensions["pubkey-fp"] = ssh.FingerprintSHA256(pubKey)
+			return perms, nil
 		},
 	}
 
-	private, err := ssh.ParsePrivateKey(privateKey)
+	if opts.PasswordAuth && opts.Passwords != nil {
+		config.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if opts.Passwords.CheckPassword(c.User(), password) {
+				return newPermissions(), nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", c.User())
+		}
+	}
+
+	if opts.Honeypot {
+		s.honeypotAuth(config)
+	}
+
+	if opts.TOTP {
+		verifier, err := newTOTPVerifier(opts.TOTPSecretDir)
+		if err != nil {
+			return nil, err
+		}
+		config.PublicKeyCallback = requireTOTP(verifier, config.PublicKeyCallback)
+		config.PasswordCallback = requireTOTP(verifier, config.PasswordCallback)
+	}
+	if err := opts.Crypto.validate(); err != nil {
+		return nil, err
+	}
+	if err := opts.validateCountries(); err != nil {
+		return nil, err
+	}
+	if opts.RunAsUser && !isPrivileged() {
+		return nil, errors.New("running sessions as the local user requires root privileges")
+	}
+	// File transfers are served by the root server process itself, and
+	// would give every user the whole filesystem
+	if opts.RunAsUser && (opts.SFTP || opts.SCP) && opts.SFTPRoot == "" && opts.ChrootDirectory == "" {
+		return nil, errors.New("running sessions as the local user with SFTP or SCP requires SFTPRoot or ChrootDirectory")
+	}
+	opts.Crypto.apply(config)
+	opts.restrictUsers(config)
+	opts.Hooks.instrumentAuth(config)
+	s.audit.instrumentAuth(config)
+	delayAuthFailures(config, opts.AuthFailureDelay)
+
+	banner, err := parseBanner("banner", opts.Banner)
+	if err != nil {
+		return nil, err
+	}
+	if banner != nil {
+		config.BannerCallback = func(c ssh.ConnMetadata) string {
+			return renderBanner(banner, c, connLogger(s.logger, c))
+		}
+	}
+	if s.motd, err = parseBanner("motd", opts.MOTD); err != nil {
+		return nil, err
+	}
+
+	hostKeys, err := parseHostKeys(append([][]byte{privateKey}, opts.HostKeys...))
 	if err != nil {
-		return fmt.Errorf("ParsePrivateKey error: %s", err)
+		return nil, err
+	}
+	if hostKeys, err = addHostCertificates(hostKeys, opts.HostCertificates); err != nil {
+		return nil, err
+	}
+	for _, hostKey := range hostKeys {
+		config.AddHostKey(hostKey)
 	}
 
-	config.AddHostKey(private)
+	rejectConfig := &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(ssh.ConnMetadata) (*ssh.Permissions, error) {
+			return nil, errTooManyConnections
+		},
+		BannerCallback: func(ssh.ConnMetadata) string {
+			return fmt.Sprintf("Too many connections (limit %d), try again later\n", opts.MaxConnections)
+		},
+		MaxAuthTries:  1,
+		ServerVersion: version,
+	}
+	opts.Crypto.apply(rejectConfig)
+	for _, hostKey := range hostKeys {
+		rejectConfig.AddHostKey(hostKey)
+	}
+
+	s.config = config
+	s.rejectConfig = rejectConfig
+	return s, nil
+}
+
+// ReloadAuthorizedKeys replaces the shared authorized keys with the parsed
+// contents of authorizedKeys. New logins use the new keys immediately; active
+// sessions are not affected. On a parse error the current keys are kept.
+func (s *Server) ReloadAuthorizedKeys(authorizedKeys []byte) error {
+	return s.authorizedKeys.Reload(authorizedKeys)
+}
 
-	// Accept connections on port 2022
-	listener, err := net.Listen("tcp", "0.0.0.0:2022")
+// RegisterCommand makes handler serve the command name in exec requests and
+// the built-in shell, replacing any existing handler. Commands can be
+// registered while the server runs; AllowedCommands still applies to them.
+func (s *Server) RegisterCommand(name string, handler CommandHandler) {
+	s.commands.Register(name, handler)
+}
+
+// Commands returns the registry of commands the server runs
+func (s *Server) Commands() *CommandRegistry {
+	return s.commands
+}
+
+// realShell reports whether commands may run in the configured shell. A real
+// shell cannot enforce AllowedCommands, so restricted servers always use the
+// built-in command registry and line-based shell, which check every command.
+func (o ServerOptions) realShell() bool {
+	return o.Shell != "" && len(o.AllowedCommands) == 0
+}
+
+// envAccepted reports whether a client-supplied environment variable may be
+// set. Git servers accept GIT_PROTOCOL so clients can use protocol v2.
+func (o ServerOptions) envAccepted(name string) bool {
+	if o.GitRoot != "" && name == "GIT_PROTOCOL" {
+		return true
+	}
+	for _, pattern := range o.AcceptEnv {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteForwardPolicy returns the remote forwarding policy that applies to user
+func (o ServerOptions) remoteForwardPolicy(user string) ForwardPolicy {
+	if policy, ok := o.UserRemoteForwardPolicies[user]; ok {
+		return policy
+	}
+	return o.RemoteForwardPolicy
+}
+
+// StartServer starts an SSH server with the given private key, authorized keys and options.
+// It blocks until the server fails; use NewServer for control over shutdown.
+func StartServer(privateKey []byte, authorizedKeys []byte, opts ServerOptions) error {
+	server, err := NewServer(privateKey, authorizedKeys, opts)
+	if err != nil {
+		return err
+	}
+	return server.Start(context.Background())
+}
+
+// Start listens on the configured endpoints and serves connections until the
+// context is cancelled or the server is shut down
+func (s *Server) Start(ctx context.Context) error {
+	listeners, err := ListenAll(s.opts.Endpoints())
 	if err != nil {
-		return fmt.Errorf("listen error: %s", err)
+		return err
+	}
+	return s.ServeListeners(ctx, listeners...)
+}
+
+// ServeListeners accepts connections on all of the listeners concurrently,
+// as Serve does for one. It returns once every listener has stopped; if one
+// fails, the others are closed and its error is returned.
+func (s *Server) ServeListeners(ctx context.Context, listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("no listeners to serve")
+	}
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() { errs <- s.Serve(ctx, listener) }()
+	}
+
+	var failure error
+	for range listeners {
+		err := <-errs
+		if failure == nil && !errors.Is(err, ErrServerClosed) {
+			failure = err
+			for _, listener := range listeners {
+				listener.Close()
+			}
+		}
+	}
+	if failure != nil {
+		return failure
+	}
+	return ErrServerClosed
+}
+
+// Serve accepts connections on an existing listener until the context is
+// cancelled or the server is shut down. The listener is closed on return.
+// Serve may be called for several listeners at once, from separate goroutines.
+// Connection and session contexts, including the SessionContext passed to
+// commands and hooks, derive from ctx.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
 	}
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
+	defer s.removeListener(listener)
 
-	log.Println("SSH server started on port 2022")
+	s.logger.Info("SSH server started", "addr", listener.Addr().String())
+
+	// Cancelling the context stops the server immediately
+	stop := context.AfterFunc(ctx, func() { s.Close() })
+	defer stop()
 
 	for {
 		nConn, err := listener.Accept()
 		if err != nil {
-			fmt.Printf("listener accept error: %s\n", err)
+			if s.isClosing() {
+				return ErrServerClosed
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.logger.Error("listener accept error", "error", err)
 			continue
 		}
+		if err := s.opts.setTCPKeepAlive(nConn); err != nil {
+			s.logger.Warn("could not set TCP keepalive", "remote_addr", nConn.RemoteAddr().String(), "error", err)
+		}
 
-		// Handshake must be performed on the incoming net.Conn
-		conn, chans, reqs, err := ssh.NewServerConn(nConn, config)
-		if err != nil {
-			fmt.Printf("new server conn error: %s\n", err)
+		// Reading the PROXY header must not hold up the accept loop
+		if s.opts.expectsProxyHeader(nConn.RemoteAddr()) {
+			go s.admitProxied(ctx, nConn)
 			continue
 		}
+		s.admit(ctx, nConn)
+	}
+}
+
+// admitProxied replaces the proxy's address on nConn with the client's from
+// its PROXY protocol header, then admits it
+func (s *Server) admitProxied(ctx context.Context, nConn net.Conn) {
+	conn, err := readProxyHeader(nConn)
+	if err != nil {
+		s.logger.Warn("rejecting connection: invalid PROXY header", "proxy_addr", nConn.RemoteAddr().String(), "error", err)
+		s.audit.record(AuditEvent{
+			Event:      "connection_rejected",
+			RemoteAddr: nConn.RemoteAddr().String(),
+			Error:      err.Error(),
+		})
+		nConn.Close()
+		return
+	}
+	s.admit(ctx, conn)
+}
+
+// admit checks an accepted connection against AllowFrom, the country
+// filters and MaxConnections and starts serving it
+func (s *Server) admit(ctx context.Context, nConn net.Conn) {
+	if !s.opts.sourceAllowed(nConn.RemoteAddr()) {
+		s.logger.Warn("rejecting connection: source address not allowed", "remote_addr", nConn.RemoteAddr().String())
+		s.audit.record(AuditEvent{
+			Event:      "connection_rejected",
+			RemoteAddr: nConn.RemoteAddr().String(),
+			Error:      "source address not allowed",
+		})
+		nConn.Close()
+		return
+	}
+
+	if country, ok := s.opts.countryAllowed(nConn.RemoteAddr()); !ok {
+		s.logger.Warn("rejecting connection: country not allowed", "remote_addr", nConn.RemoteAddr().String(), "country", country)
+		s.audit.record(AuditEvent{
+			Event:      "connection_rejected",
+			RemoteAddr: nConn.RemoteAddr().String(),
+			Country:    country,
+			Error:      "country not allowed",
+		})
+		nConn.Close()
+		return
+	}
+
+	count, ok := s.trackConn(nConn)
+	if !ok {
+		nConn.Close()
+		return
+	}
+	if s.opts.MaxConnections > 0 && count > s.opts.MaxConnections {
+		go s.rejectConn(nConn)
+		return
+	}
+	go s.serveConn(ctx, nConn)
+}
+
+// Addr returns the address of the first listener the server serves, or nil
+// before it starts
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of every listener the server serves
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]net.Addr, 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// removeListener forgets a listener Serve has stopped serving
+func (s *Server) removeListener(listener net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = slices.DeleteFunc(s.listeners, func(l net.Listener) bool { return l == listener })
+}
+
+// Shutdown stops accepting new connections and waits for active connections
+// to finish. If the context expires first, the remaining connections are
+// closed forcibly and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopListening()
+
+	drained := make(chan struct{})
+	go func() {
+		s.active.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		s.closeConns()
+		<-drained
+		return ctx.Err()
+	}
+}
+
+// Wait blocks until every connection has closed. Start and Serve return as
+// soon as the server stops listening, so call Wait afterwards to let a drain
+// started with Shutdown or the control socket finish.
+func (s *Server) Wait() {
+	s.active.Wait()
+}
+
+// Close stops the listener and closes every active connection immediately
+func (s *Server) Close() error {
+	err := s.stopListening()
+	s.closeConns()
+	return err
+}
+
+// stopListening marks the server as closing and closes its listeners
+func (s *Server) stopListening() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closing = true
+	var errs []error
+	for _, listener := range s.listeners {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeConns closes every tracked connection
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// isClosing reports whether Shutdown or Close has been called
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+// trackConn registers an accepted connection, refusing it once the server is closing
+func (s *Server) trackConn(c net.Conn) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return 0, false
+	}
+	s.conns[c] = struct{}{}
+	s.active.Add(1)
+	return len(s.conns), true
+}
+
+// untrackConn removes a finished connection
+func (s *Server) untrackConn(c net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+	s.active.Done()
+}
+
+// delayAuthFailures makes config pause for delay after each failed
+// authentication attempt. The free initial "none" probe and partial
+// successes are not delayed.
+func delayAuthFailures(config *ssh.ServerConfig, delay time.Duration) {
+	if delay <= 0 {
+		return
+	}
+	next := config.AuthLogCallback
+	config.AuthLogCallback = func(c ssh.ConnMetadata, method string, err error) {
+		if next != nil {
+			next(c, method, err)
+		}
+		if err != nil && method != "none" && !isPartialSuccess(err) {
+			time.Sleep(delay)
+		}
+	}
+}
+
+// errTooManyConnections fails authentication for clients over MaxConnections
+var errTooManyConnections = errors.New("too many connections")
 
-		if conn != nil && conn.Permissions != nil {
-			log.Printf("logged in with key %s", conn.Permissions.Extensions["pubkey-fp"])
+// rejectHandshakeTimeout bounds how long a rejected client may hold its connection
+const rejectHandshakeTimeout = 10 * time.Second
+
+// rejectConn tells a client over MaxConnections why it is being turned away
+// and disconnects it
+func (s *Server) rejectConn(nConn net.Conn) {
+	defer s.untrackConn(nConn)
+	defer nConn.Close()
+
+	s.logger.Warn("rejecting connection: too many connections", "remote_addr", nConn.RemoteAddr().String())
+	s.audit.record(AuditEvent{
+		Event:      "connection_rejected",
+		RemoteAddr: nConn.RemoteAddr().String(),
+		Error:      errTooManyConnections.Error(),
+	})
+	nConn.SetDeadline(time.Now().Add(rejectHandshakeTimeout))
+	ssh.NewServerConn(nConn, s.rejectConfig)
+}
+
+// acquireSession reserves a session slot for user, reporting false when the
+// user already has MaxSessionsPerUser sessions open
+func (s *Server) acquireSession(user string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.opts.MaxSessionsPerUser > 0 && s.sessions[user] >= s.opts.MaxSessionsPerUser {
+		return false
+	}
+	s.sessions[user]++
+	return true
+}
+
+// releaseSession frees a session slot reserved by acquireSession
+func (s *Server) releaseSession(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[user]--; s.sessions[user] <= 0 {
+		delete(s.sessions, user)
+	}
+}
+
+// serveConn performs the SSH handshake and services the connection until it
+// closes. The connection's context, derived from ctx, is cancelled on return.
+func (s *Server) serveConn(ctx context.Context, nConn net.Conn) {
+	defer s.untrackConn(nConn)
+	defer nConn.Close()
+
+	// Handshake must be performed on the incoming net.Conn
+	var transport net.Conn = nConn
+	var watched *versionConn
+	if len(s.opts.DenyClientVersions) > 0 {
+		watched = &versionConn{Conn: nConn, opts: &s.opts}
+		transport = watched
+	}
+	conn, chans, reqs, err := ssh.NewServerConn(transport, s.config)
+	if err != nil {
+		err = handshakeError{err}
+		if watched != nil && watched.denied != "" {
+			s.logger.Warn("rejecting connection: client version denied", "remote_addr", nConn.RemoteAddr().String(), "client_version", watched.denied)
+			s.audit.record(AuditEvent{
+				Event:         "client_version_rejected",
+				RemoteAddr:    nConn.RemoteAddr().String(),
+				ClientVersion: watched.denied,
+			})
+		} else {
+			s.logger.Info("handshake failed", "remote_addr", nConn.RemoteAddr().String(), "error", err)
+		}
+		if s.opts.Hooks.OnHandshakeFailure != nil {
+			s.opts.Hooks.OnHandshakeFailure(nConn.RemoteAddr(), err)
 		}
+		return
+	}
 
-		// The incoming Request channel must be serviced.
-		go ssh.DiscardRequests(reqs)
+	logger := connLogger(s.logger, conn)
+	if err := s.opts.Hooks.authorize(conn); err != nil {
+		logger.Warn("login rejected by the OnAuth hook", "error", err)
+		event := connEvent(conn, "auth_failure")
+		event.Method = conn.Permissions.Extensions[authMethodExtension]
+		event.Error = err.Error()
+		s.audit.record(event)
+		conn.Close()
+		if s.opts.Hooks.OnHandshakeFailure != nil {
+			s.opts.Hooks.OnHandshakeFailure(nConn.RemoteAddr(), handshakeError{err})
+		}
+		return
+	}
+	if keyID, ok := conn.Permissions.Extensions["cert-key-id"]; ok {
+		logger.Info("logged in with certificate", "key_id", keyID,
+			"serial", conn.Permissions.Extensions["cert-serial"], "fingerprint", conn.Permissions.Extensions["pubkey-fp"])
+	} else if fp := conn.Permissions.Extensions["pubkey-fp"]; fp != "" {
+		logger.Info("logged in with key", "fingerprint", fp)
+	} else {
+		logger.Info("logged in with password")
+	}
+	success := connEvent(conn, "auth_success")
+	success.Method = conn.Permissions.Extensions[authMethodExtension]
+	s.audit.record(success)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	live := s.registerConn(conn)
+	defer s.unregisterConn(live)
+	connected := live.started
+	defer func() {
+		event := connEvent(conn, "disconnect")
+		event.Duration = time.Since(connected).Round(time.Millisecond).String()
+		if reason := live.disconnectReason(); reason != "" {
+			event.Error = "disconnected by administrator: " + reason
+		}
+		s.audit.record(event)
+		if s.opts.Hooks.OnDisconnect != nil {
+			s.opts.Hooks.OnDisconnect(newSessionContext(ctx, conn, nil), time.Since(connected))
+		}
+	}()
 
-		go handleConnection(conn, chans)
+	// The incoming Request channel must be serviced. It carries the
+	// global requests that set up remote port forwards.
+	go newRemoteForwards(conn, &s.opts, logger).handleRequests(reqs)
+	if s.opts.KeepAliveInterval > 0 {
+		go s.keepAlive(ctx.Done(), conn, logger)
 	}
+
+	s.handleConnection(ctx, live, chans, logger)
+}
+
+// connLogger returns logger with the attributes identifying the client behind conn
+func connLogger(logger *slog.Logger, conn ssh.ConnMetadata) *slog.Logger {
+	return logger.With("session_id", sessionID(conn), "user", conn.User(), "remote_addr", conn.RemoteAddr().String())
 }
 
-func handleConnection(conn *ssh.ServerConn, chans <-chan ssh.NewChannel) {
+// handleConnection services the channels a client opens. Each session gets a
+// context derived from the connection's ctx that is cancelled when it ends.
+func (s *Server) handleConnection(ctx context.Context, live *liveConn, chans <-chan ssh.NewChannel, logger *slog.Logger) {
+	conn := live.conn
+	opts := &s.opts
+	// Every session of the connection runs in the same chroot and account
+	root, setupErr := opts.chrootDir(conn.User())
+	var account *osAccount
+	if setupErr == nil && opts.RunAsUser {
+		account, setupErr = lookupAccount(conn.User())
+	}
 	// Service the incoming Channel channel.
 	for newChannel := range chans {
 		// Channels have a type, depending on the application level
 		// protocol intended. In the case of a shell, the type is
 		// "session" and ServerShell may be used to present a simple
 		// terminal interface.
-		if newChannel.ChannelType() != "session" {
+		switch newChannel.ChannelType() {
+		case "session":
+			if setupErr != nil {
+				logger.Warn("rejecting session", "error", setupErr)
+				newChannel.Reject(ssh.Prohibited, "no session environment for "+conn.User())
+				continue
+			}
+			if !s.acquireSession(conn.User()) {
+				logger.Warn("rejecting session: session limit reached")
+				newChannel.Reject(ssh.ResourceShortage, fmt.Sprintf("too many sessions for %s (limit %d)", conn.User(), opts.MaxSessionsPerUser))
+				continue
+			}
+			if opts.Hooks.OnSessionStart != nil {
+				if err := opts.Hooks.OnSessionStart(newSessionContext(ctx, conn, nil)); err != nil {
+					logger.Warn("rejecting session", "error", err)
+					newChannel.Reject(ssh.Prohibited, err.Error())
+					s.releaseSession(conn.User())
+					continue
+				}
+			}
+		case "direct-tcpip":
+			if opts.Honeypot {
+				go s.rejectHoneypotForward(conn, newChannel)
+				continue
+			}
+			go handleDirectTCPIP(ctx, conn, newChannel, opts, logger)
+			continue
+		default:
 			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
 			continue
 		}
 		channel, requests, err := newChannel.Accept()
 		if err != nil {
-			fmt.Printf("could not accept channel: %v\n", err)
+			logger.Error("could not accept channel", "error", err)
+			s.releaseSession(conn.User())
 			continue
 		}
 
 		// Sessions have out-of-band requests such as "shell",
-		// "pty-req" and "env".  Here we handle only the
-		// "shell" request.
-		go func(in <-chan *ssh.Request) {
-			for req := range in {
-				fmt.Printf("request type made by client: %s\n", req.Type)
-				switch req.Type {
-				case "exec":
-					payload := bytes.TrimPrefix(req.Payload, []byte{0, 0, 0, 6})
-					channel.Write([]byte(execSomething(conn, payload)))
-					channel.SendRequest("exit-status", false, []byte{0, 0, 0, 0})
-					req.Reply(true, nil)
-					channel.Close()
-				case "shell":
-					req.Reply(true, nil)
-				case "pty-req":
-					createTerminal(conn, channel)
-				default:
-					req.Reply(false, nil)
-				}
-			}
-		}(requests)
-	}
-}
-
-func createTerminal(conn *ssh.ServerConn, channel ssh.Channel) {
-	termInstance := term.NewTerminal(channel, "> ")
-	go func() {
-		defer channel.Close()
-		for {
-			line, err := termInstance.ReadLine()
-			if err != nil {
-				fmt.Printf("ReadLine error: %s", err)
-				break
-			}
-			switch line {
-			case "whoami":
-				termInstance.Write([]byte(execSomething(conn, []byte("whoami"))))
-			case "":
-				// Do nothing for empty lines
-			case "quit":
-				termInstance.Write([]byte("Goodbye!\n"))
-				channel.Close()
-			default:
-				termInstance.Write([]byte("Command not found\n"))
-			}
+		// "pty-req" and "env". They are handled by the session, which
+		// holds its slot until the channel closes.
+		sessCtx, cancelSession := context.WithCancel(ctx)
+		var idle *idleChannel
+		if opts.IdleTimeout > 0 {
+			idle = newIdleChannel(channel, opts.IdleTimeout, logger)
+			go idle.watch(sessCtx.Done())
+			channel = idle
 		}
-	}()
-}
-
-func execSomething(conn *ssh.ServerConn, payload []byte) string {
-	switch string(payload) {
-	case "whoami":
-		return fmt.Sprintf("You are: %s\n", conn.Conn.User())
-	default:
-		return fmt.Sprintf("Command Not Found: %s\n", string(payload))
+		if opts.RateLimit > 0 || opts.TransferQuota > 0 {
+			unlimited := channel
+			channel = newLimitedChannel(sessCtx, channel, opts, func() {
+				s.audit.record(connEvent(conn, "quota_exceeded"))
+				closeOverQuota(unlimited, opts.TransferQuota, logger)
+			})
+		}
+		sess := &session{ctx: sessCtx, conn: conn, channel: channel, opts: opts, audit: s.audit, logger: logger, motd: s.motd, commands: s.commands, subsystems: &s.subsystems, root: root, account: account, idle: idle}
+		live.addChannel(channel)
+		go func() {
+			defer s.releaseSession(conn.User())
+			defer cancelSession()
+			defer live.removeChannel(channel)
+			s.audit.record(connEvent(conn, "session_open"))
+			opened := time.Now()
+			sess.handleRequests(requests)
+			event := connEvent(conn, "session_close")
+			event.Duration = time.Since(opened).Round(time.Millisecond).String()
+			s.audit.record(event)
+			if opts.Hooks.OnSessionEnd != nil {
+				opts.Hooks.OnSessionEnd(sess.context(), time.Since(opened))
+			}
+		}()
 	}
 }
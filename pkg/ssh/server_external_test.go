@@ -0,0 +1,22 @@
+// pkg/ssh/server_external_test.go
+package ssh_test
+
+import (
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	"github.com/bxtal-lsn/gossh/pkg/sshtest"
+)
+
+func TestStartServer_ConnectionBasics(t *testing.T) {
+	srv := sshtest.StartTestServer(t, ssh.ServerOptions{})
+	client := srv.Dial(t)
+
+	if got := string(client.ServerVersion()); got == "" {
+		t.Error("the server should announce a version")
+	}
+	attempts := srv.AuthAttempts()
+	if len(attempts) != 1 || !attempts[0].Success || attempts[0].User != sshtest.DefaultUser {
+		t.Errorf("AuthAttempts() = %+v, want one successful login", attempts)
+	}
+}
@@ -2,160 +2,183 @@
 package ssh
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
 	"net"
-	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
-// mockSSHServer is a struct to help with testing the SSH server
-type mockSSHServer struct {
-	listener net.Listener
-	done     chan bool
-}
-
-// setupMockSSHServer creates a temporary SSH server for testing
-func setupMockSSHServer(t *testing.T) (*mockSSHServer, []byte, []byte) {
-	// Generate key pair for testing
-	privateKey, publicKey, err := GenerateKeys()
+// newTestKeyPair generates an Ed25519 key, returning its PEM encoding and signer
+func newTestKeyPair(t *testing.T) ([]byte, ssh.Signer) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		t.Fatalf("Failed to generate test keys: %v", err)
+		t.Fatalf("Failed to generate key: %v", err)
 	}
-
-	// Create a listener on a random port
-	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	block, err := ssh.MarshalPrivateKey(priv, "")
 	if err != nil {
-		t.Fatalf("Failed to create listener: %v", err)
+		t.Fatalf("Failed to marshal key: %v", err)
 	}
-
-	// Create mock server
-	mockServer := &mockSSHServer{
-		listener: listener,
-		done:     make(chan bool),
-	}
-
-	// Start server in a goroutine (will be stopped in teardown)
-	go func() {
-		// This is a simplified version that won't actually start the full server
-		// but it will listen for connections so we can test basic connectivity
-		conn, err := listener.Accept()
-		if err != nil {
-			select {
-			case <-mockServer.done:
-				// Server was stopped, this is expected
-				return
-			default:
-				t.Logf("Accept error: %v", err)
-			}
-		} else {
-			defer conn.Close()
-			// Just read some data to simulate interaction
-			buffer := make([]byte, 1024)
-			conn.Read(buffer)
-		}
-	}()
-
-	return mockServer, privateKey, publicKey
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	return pem.EncodeToMemory(block), signer
 }
 
-// teardownMockSSHServer cleans up the mock server
-func teardownMockSSHServer(mock *mockSSHServer) {
-	close(mock.done)
-	mock.listener.Close()
+// freePort reserves and releases a local TCP port for a test server
+func freePort(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
 }
 
-func TestStartServer_ConnectionBasics(t *testing.T) {
-	// This is a basic connectivity test
-	// Setup mock server
-	mock, privateKey, publicKey := setupMockSSHServer(t)
-	defer teardownMockSSHServer(mock)
+// dialTestServer connects to addr as user, retrying while the server starts up
+func dialTestServer(t *testing.T, addr, user string, signer ssh.Signer) *ssh.Client {
+	t.Helper()
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	}
+	var lastErr error
+	for i := 0; i < 50; i++ {
+		client, err := ssh.Dial("tcp", addr, config)
+		if err == nil {
+			return client
+		}
+		lastErr = err
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("Failed to connect to %s: %v", addr, lastErr)
+	return nil
+}
 
-	// Create temporary files for the keys
-	privateKeyFile, err := os.CreateTemp("", "ssh_test_private_key")
+// runTestCommand executes cmd in a new session and returns its output
+func runTestCommand(t *testing.T, client *ssh.Client, cmd string) string {
+	t.Helper()
+	session, err := client.NewSession()
 	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
+		t.Fatalf("Failed to create session: %v", err)
 	}
-	defer os.Remove(privateKeyFile.Name())
-
-	publicKeyFile, err := os.CreateTemp("", "ssh_test_public_key")
+	defer session.Close()
+	stdout, err := session.StdoutPipe()
 	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
+		t.Fatalf("Failed to get stdout: %v", err)
 	}
-	defer os.Remove(publicKeyFile.Name())
-
-	// Write the keys to the files
-	if _, err := privateKeyFile.Write(privateKey); err != nil {
-		t.Fatalf("Failed to write private key: %v", err)
+	if err := session.Start(cmd); err != nil {
+		t.Fatalf("Failed to start %q: %v", cmd, err)
 	}
-	if _, err := publicKeyFile.Write(publicKey); err != nil {
-		t.Fatalf("Failed to write public key: %v", err)
+	out, _ := io.ReadAll(stdout)
+	session.Wait()
+	return string(out)
+}
+
+func TestStartServerUsesConfiguredAddress(t *testing.T) {
+	hostKey, _ := newTestKeyPair(t)
+	_, clientSigner := newTestKeyPair(t)
+	authorizedKeys := ssh.MarshalAuthorizedKey(clientSigner.PublicKey())
+
+	opts := ServerOptions{
+		BindAddress:     "127.0.0.1",
+		Port:            freePort(t),
+		AllowedCommands: []string{"whoami"},
 	}
+	go StartServer(hostKey, authorizedKeys, opts)
 
-	// Close the files
-	privateKeyFile.Close()
-	publicKeyFile.Close()
+	client := dialTestServer(t, opts.Addr(), "alice", clientSigner)
+	defer client.Close()
 
-	// Test key loading (partial test of StartServer)
-	serverKeyBytes, err := os.ReadFile(privateKeyFile.Name())
-	if err != nil {
-		t.Fatalf("Failed to read server key: %v", err)
+	if out := runTestCommand(t, client, "whoami"); out != "You are: alice\n" {
+		t.Errorf("whoami output = %q, want %q", out, "You are: alice\n")
 	}
-	authorizedKeysBytes, err := os.ReadFile(publicKeyFile.Name())
-	if err != nil {
-		t.Fatalf("Failed to read authorized keys: %v", err)
+	if out := runTestCommand(t, client, "reboot"); !strings.Contains(out, "Command Not Allowed") {
+		t.Errorf("reboot output = %q, want a not allowed message", out)
 	}
+}
 
-	// Validate the server can parse keys
-	// This is testing the beginning of StartServer without actually starting it
-	authorizedKeysMap := map[string]bool{}
-	var rest []byte = authorizedKeysBytes
-	for len(rest) > 0 {
-		pubKey, _, _, newRest, err := ssh.ParseAuthorizedKey(rest)
-		if err != nil {
-			t.Fatalf("Parse authorized keys error: %v", err)
-		}
-		authorizedKeysMap[string(pubKey.Marshal())] = true
-		rest = newRest
+func TestServerOptionsAddr(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ServerOptions
+		want string
+	}{
+		{"defaults", ServerOptions{}, "0.0.0.0:2022"},
+		{"custom port", ServerOptions{Port: "2222"}, "0.0.0.0:2222"},
+		{"custom bind", ServerOptions{BindAddress: "127.0.0.1", Port: "22"}, "127.0.0.1:22"},
+		{"ipv6 bind", ServerOptions{BindAddress: "::1", Port: "2022"}, "[::1]:2022"},
 	}
 
-	private, err := ssh.ParsePrivateKey(serverKeyBytes)
-	if err != nil {
-		t.Fatalf("ParsePrivateKey error: %v", err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opts.Addr(); got != tt.want {
+				t.Errorf("Addr() = %q, want %q", got, tt.want)
+			}
+		})
 	}
+}
 
-	// Verify we parsed at least one key
-	if len(authorizedKeysMap) == 0 {
-		t.Fatal("No authorized keys were parsed")
+func TestCommandAllowed(t *testing.T) {
+	unrestricted := ServerOptions{}
+	if !unrestricted.commandAllowed("anything goes") {
+		t.Error("empty AllowedCommands should allow every command")
 	}
 
-	// Verify private key was parsed
-	if private == nil {
-		t.Fatal("Private key couldn't be parsed")
+	restricted := ServerOptions{AllowedCommands: []string{"whoami", "uptime"}}
+	tests := []struct {
+		cmdline string
+		want    bool
+	}{
+		{"whoami", true},
+		{"uptime -p", true},
+		{"rm -rf /", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := restricted.commandAllowed(tt.cmdline); got != tt.want {
+			t.Errorf("commandAllowed(%q) = %v, want %v", tt.cmdline, got, tt.want)
+		}
 	}
 }
 
-// TestExecSomething tests the execSomething function
-func TestExecSomething(t *testing.T) {
+// TestBuiltinCommands tests the commands every registry starts with
+func TestBuiltinCommands(t *testing.T) {
 	// Create a mock connection for testing
 	mockConn := &ssh.ServerConn{
 		Conn: &mockSSHConn{
 			user: "testuser",
 		},
 	}
+	ctx := newSessionContext(context.Background(), mockConn, nil)
+	commands := NewCommandRegistry()
 
 	// Test whoami command
-	result := execSomething(mockConn, []byte("whoami"))
+	result, status := commands.run(ctx, "whoami")
 	expected := "You are: testuser\n"
-	if result != expected {
-		t.Errorf("execSomething(whoami) = %q, want %q", result, expected)
+	if result != expected || status != 0 {
+		t.Errorf("run(whoami) = %q, %d, want %q, 0", result, status, expected)
 	}
 
 	// Test unknown command
-	result = execSomething(mockConn, []byte("unknown"))
-	if !strings.Contains(result, "Command Not Found") {
-		t.Errorf("execSomething(unknown) should return 'Command Not Found', got %q", result)
+	result, status = commands.run(ctx, "unknown")
+	if !strings.Contains(result, "Command Not Found") || status != 127 {
+		t.Errorf("run(unknown) should return 'Command Not Found' and 127, got %q, %d", result, status)
 	}
 }
 
@@ -203,3 +226,319 @@ func (m *mockSSHConn) SendRequest(name string, wantReply bool, payload []byte) (
 func (m *mockSSHConn) Wait() error {
 	return nil
 }
+
+// startTestServer serves a new Server on a random local port
+func startTestServer(t *testing.T, ctx context.Context, opts ServerOptions) (*Server, string, ssh.Signer, chan error) {
+	t.Helper()
+	hostKey, _ := newTestKeyPair(t)
+	_, clientSigner := newTestKeyPair(t)
+
+	server, err := NewServer(hostKey, ssh.MarshalAuthorizedKey(clientSigner.PublicKey()), opts)
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, listener) }()
+	return server, listener.Addr().String(), clientSigner, done
+}
+
+// waitServeResult waits for Serve to return and checks it reported ErrServerClosed
+func waitServeResult(t *testing.T, done chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrServerClosed) {
+			t.Errorf("Serve() error = %v, want ErrServerClosed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve() did not return after the server was stopped")
+	}
+}
+
+func TestServerShutdownDrainsConnections(t *testing.T) {
+	server, addr, signer, done := startTestServer(t, context.Background(), ServerOptions{})
+
+	client := dialTestServer(t, addr, "alice", signer)
+
+	// Shutdown waits for the connected client and gives up at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want context.DeadlineExceeded", err)
+	}
+	waitServeResult(t, done)
+
+	// The lingering connection was force-closed at the deadline
+	if _, _, err := client.SendRequest("ping", true, nil); err == nil {
+		t.Error("connection should be closed after a forced shutdown")
+	}
+}
+
+func TestServerShutdownWithoutConnections(t *testing.T) {
+	server, addr, signer, done := startTestServer(t, context.Background(), ServerOptions{})
+
+	client := dialTestServer(t, addr, "alice", signer)
+	client.Close()
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown() error = %v, want nil", err)
+	}
+	waitServeResult(t, done)
+
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Error("server should stop accepting connections after Shutdown")
+	}
+}
+
+func TestServerStopsOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	_, addr, signer, done := startTestServer(t, ctx, ServerOptions{})
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	cancel()
+	waitServeResult(t, done)
+}
+
+func TestEnvAccepted(t *testing.T) {
+	opts := ServerOptions{AcceptEnv: []string{"LANG", "LC_*", "GOSSH_?"}}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"LANG", true},
+		{"LC_ALL", true},
+		{"GOSSH_X", true},
+		{"GOSSH_XY", false},
+		{"PATH", false},
+		{"LD_PRELOAD", false},
+	}
+	for _, tt := range tests {
+		if got := opts.envAccepted(tt.name); got != tt.want {
+			t.Errorf("envAccepted(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	if (ServerOptions{}).envAccepted("LANG") {
+		t.Error("no variables should be accepted by default")
+	}
+}
+
+func TestReloadAuthorizedKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server, addr, oldSigner, _ := startTestServer(t, ctx, ServerOptions{})
+	active := dialTestServer(t, addr, "alice", oldSigner)
+	defer active.Close()
+
+	_, newSigner := newTestKeyPair(t)
+	if err := server.ReloadAuthorizedKeys(ssh.MarshalAuthorizedKey(newSigner.PublicKey())); err != nil {
+		t.Fatalf("ReloadAuthorizedKeys() error = %v", err)
+	}
+
+	dial := func(signer ssh.Signer) error {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            "alice",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         time.Second,
+		})
+		if client != nil {
+			client.Close()
+		}
+		return err
+	}
+	if err := dial(oldSigner); err == nil {
+		t.Error("revoked key should no longer authenticate")
+	}
+	if err := dial(newSigner); err != nil {
+		t.Errorf("added key should authenticate: %v", err)
+	}
+	if out := runTestCommand(t, active, "whoami"); !strings.Contains(out, "You are: alice") {
+		t.Errorf("active session should survive a reload, got %q", out)
+	}
+
+	if err := server.ReloadAuthorizedKeys([]byte("not a key")); err == nil {
+		t.Error("ReloadAuthorizedKeys should reject invalid data")
+	}
+	if err := dial(newSigner); err != nil {
+		t.Errorf("a failed reload should keep the current keys: %v", err)
+	}
+}
+
+func TestServerMaxConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{MaxConnections: 1})
+	first := dialTestServer(t, addr, "alice", signer)
+	defer first.Close()
+
+	var banner string
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		BannerCallback: func(message string) error {
+			banner = message
+			return nil
+		},
+		Timeout: time.Second,
+	})
+	if err == nil {
+		client.Close()
+		t.Fatal("second connection should be rejected")
+	}
+	if !strings.Contains(banner, "Too many connections") {
+		t.Errorf("banner = %q, want an explanation of the limit", banner)
+	}
+}
+
+func TestServerMaxSessionsPerUser(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{MaxSessionsPerUser: 1})
+	first := dialTestServer(t, addr, "alice", signer)
+	defer first.Close()
+	second := dialTestServer(t, addr, "alice", signer)
+	defer second.Close()
+
+	session, err := first.NewSession()
+	if err != nil {
+		t.Fatalf("first session failed: %v", err)
+	}
+
+	if _, err := second.NewSession(); err == nil || !strings.Contains(err.Error(), "too many sessions") {
+		t.Errorf("NewSession over the limit error = %v, want a session limit rejection", err)
+	}
+
+	session.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s, err := second.NewSession()
+		if err == nil {
+			s.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("closing a session should free its slot: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestServerMaxAuthTries(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxAuthTries int
+		wantAttempts int
+	}{
+		{"limit of two", 2, 2},
+		{"default of six", 0, 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, addr, _, _ := startTestServer(t, ctx, ServerOptions{
+				PasswordAuth: true,
+				Passwords:    StaticPasswords{"alice": "s3cret"},
+				MaxAuthTries: tt.maxAuthTries,
+			})
+
+			attempts := 0
+			_, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User: "alice",
+				Auth: []ssh.AuthMethod{ssh.RetryableAuthMethod(ssh.PasswordCallback(func() (string, error) {
+					attempts++
+					return "guess", nil
+				}), 20)},
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         time.Second,
+			})
+			if err == nil {
+				t.Fatal("wrong passwords should not log in")
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("client made %d attempts before being disconnected, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestServerAuthFailureDelay(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const delay = 150 * time.Millisecond
+	_, addr, _, _ := startTestServer(t, ctx, ServerOptions{
+		PasswordAuth:     true,
+		Passwords:        StaticPasswords{"alice": "s3cret"},
+		MaxAuthTries:     2,
+		AuthFailureDelay: delay,
+	})
+
+	start := time.Now()
+	_, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.RetryableAuthMethod(ssh.Password("guess"), 2)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if err == nil {
+		t.Fatal("wrong passwords should not log in")
+	}
+	if elapsed := time.Since(start); elapsed < 2*delay {
+		t.Errorf("two failures took %s, want at least %s", elapsed, 2*delay)
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logs := &lockedBuffer{}
+	logger := slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Logger: logger})
+
+	// Two concurrent connections of the same user must be told apart
+	first := dialTestServer(t, addr, "alice", signer)
+	defer first.Close()
+	second := dialTestServer(t, addr, "alice", signer)
+	defer second.Close()
+	runTestCommand(t, first, "whoami")
+	runTestCommand(t, second, "whoami")
+
+	sessions := map[string]bool{}
+	logs.mu.Lock()
+	lines := strings.Split(strings.TrimSpace(logs.buf.String()), "\n")
+	logs.mu.Unlock()
+	for _, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		if entry["msg"] != "session request" {
+			continue
+		}
+		id, _ := entry["session_id"].(string)
+		if len(id) != 16 || entry["user"] != "alice" || entry["remote_addr"] == "" {
+			t.Errorf("log entry %v lacks the connection attributes", entry)
+		}
+		sessions[id] = true
+	}
+	if len(sessions) != 2 {
+		t.Errorf("session requests logged for %d session IDs, want 2", len(sessions))
+	}
+}
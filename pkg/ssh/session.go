@@ -0,0 +1,543 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh/internal/wire"
+	"golang.org/x/crypto/ssh"
+)
+
+// windowSize is the terminal size carried by "pty-req" and "window-change" requests
+type windowSize struct {
+	Columns uint32
+	Rows    uint32
+}
+
+// session holds the state of a single "session" channel
+type session struct {
+	// ctx is cancelled when the session ends or its connection closes
+	ctx     context.Context
+	conn    *ssh.ServerConn
+	channel ssh.Channel
+	opts    *ServerOptions
+	audit   *auditLog
+	logger  *slog.Logger
+	motd    *template.Template // MOTD template shown before the shell starts
+	pty     *wire.PtyRequest
+	env     []string
+	// commands serves exec requests and the built-in shell
+	commands *CommandRegistry
+	// subsystems serves the subsystems registered on the server
+	subsystems *subsystemRegistry
+	// recorder captures the terminal I/O of the shell when recording is enabled
+	recorder *recorder
+	// root is the chroot directory the session is confined to, if any
+	root string
+	// account is the local account programs run as when RunAsUser is on
+	account *osAccount
+	// agentSocket is the path of the forwarded agent's socket, if any
+	agentSocket string
+	// x11Display is the DISPLAY of forwarded X11 connections, if any
+	x11Display string
+	// idle watches the session's traffic when IdleTimeout is set
+	idle *idleChannel
+
+	mu       sync.Mutex
+	onResize func(windowSize)
+	process  *os.Process
+}
+
+// handleRequests services the out-of-band requests of a session channel
+func (s *session) handleRequests(in <-chan *ssh.Request) {
+	if s.opts.Honeypot {
+		s.handleHoneypotRequests(in)
+		return
+	}
+	for req := range in {
+		s.logger.Debug("session request", "type", req.Type)
+		switch req.Type {
+		case "exec":
+			command, err := wire.ParseExec(req.Payload)
+			if err != nil {
+				s.logger.Warn(err.Error())
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			s.startIdleNotices()
+			event := connEvent(s.conn, "exec")
+			event.Command = command
+			if !s.opts.commandAllowed(command) {
+				event.Error = "command not allowed"
+			}
+			s.audit.record(event)
+			if forced := s.forcedCommand(); forced != "" {
+				s.env = append(s.env, "SSH_ORIGINAL_COMMAND="+command)
+				s.runForced(forced)
+				continue
+			}
+			if s.opts.commandAllowed(command) {
+				s.exec(command)
+			} else {
+				s.channel.Write([]byte(fmt.Sprintf("Command Not Allowed: %s\n", command)))
+				sendExitStatus(s.channel, 126)
+				s.channel.Close()
+			}
+		case "pty-req":
+			if !permitted(s.conn, "permit-pty") {
+				s.logger.Warn("denied pty-req")
+				req.Reply(false, nil)
+				continue
+			}
+			ptyReq, err := wire.ParsePtyRequest(req.Payload)
+			if err != nil {
+				s.logger.Warn(err.Error())
+				req.Reply(false, nil)
+				continue
+			}
+			s.pty = &ptyReq
+			req.Reply(true, nil)
+		case "window-change":
+			change, err := wire.ParseWindowChange(req.Payload)
+			if err != nil {
+				s.logger.Warn(err.Error())
+				continue
+			}
+			s.resize(windowSize{Columns: change.Columns, Rows: change.Rows})
+		case "env":
+			name, value, err := wire.ParseEnv(req.Payload)
+			if err != nil {
+				s.logger.Warn(err.Error())
+				req.Reply(false, nil)
+				continue
+			}
+			if !s.opts.envAccepted(name) {
+				s.logger.Warn("rejected environment variable", "name", name)
+				req.Reply(false, nil)
+				continue
+			}
+			s.env = append(s.env, name+"="+value)
+			req.Reply(true, nil)
+		case agentRequest:
+			if !s.agentPermitted() {
+				s.logger.Warn("denied agent forwarding")
+				req.Reply(false, nil)
+				continue
+			}
+			if err := s.forwardAgent(); err != nil {
+				s.logger.Warn("could not forward agent", "error", err)
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+		case x11Request:
+			if !s.x11Permitted() {
+				s.logger.Warn("denied X11 forwarding")
+				req.Reply(false, nil)
+				continue
+			}
+			x11, err := wire.ParseX11Request(req.Payload)
+			if err != nil {
+				s.logger.Warn(err.Error())
+				req.Reply(false, nil)
+				continue
+			}
+			if err := s.forwardX11(x11); err != nil {
+				s.logger.Warn("could not forward X11", "error", err)
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+		case "signal":
+			sig, err := wire.ParseSignal(req.Payload)
+			if err != nil {
+				s.logger.Warn(err.Error())
+				continue
+			}
+			s.signal(sig)
+		case "shell":
+			req.Reply(true, nil)
+			s.startIdleNotices()
+			if forced := s.forcedCommand(); forced != "" {
+				s.runForced(forced)
+				continue
+			}
+			s.startShell()
+		case "subsystem":
+			subsystem, err := wire.ParseSubsystem(req.Payload)
+			if err != nil {
+				s.logger.Warn(err.Error())
+				req.Reply(false, nil)
+				continue
+			}
+			if forced := s.forcedCommand(); forced != "" {
+				req.Reply(true, nil)
+				s.env = append(s.env, "SSH_ORIGINAL_COMMAND="+subsystem)
+				s.runForced(forced)
+				continue
+			}
+			if !subsystemPermitted(s.conn, subsystem) {
+				s.logger.Warn("denied subsystem", "subsystem", subsystem)
+				req.Reply(false, nil)
+				continue
+			}
+			if handler, ok := s.subsystems.lookup(subsystem); ok {
+				req.Reply(true, nil)
+				go s.runSubsystem(subsystem, handler)
+				continue
+			}
+			if subsystem != "sftp" || !s.opts.SFTP {
+				s.logger.Warn("rejected subsystem", "subsystem", subsystem)
+				req.Reply(false, nil)
+				continue
+			}
+			req.Reply(true, nil)
+			go s.runSFTP()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// forcedCommand returns the command every request of the session runs in
+// place of the client's: ForceCommand, or the command= option of the key
+func (s *session) forcedCommand() string {
+	if s.opts.ForceCommand != "" {
+		return s.opts.ForceCommand
+	}
+	return forcedCommand(s.conn)
+}
+
+// runForced runs a forced command, serving SFTP for internal-sftp
+func (s *session) runForced(command string) {
+	if command == InternalSFTP {
+		go s.runSFTP()
+		return
+	}
+	s.exec(command)
+}
+
+// exec runs a single command, reports its exit status and closes the channel
+func (s *session) exec(command string) {
+	if hook := s.opts.Hooks.OnExec; hook != nil {
+		if err := hook(s.context(), command); err != nil {
+			s.logger.Warn("refusing command", "command", command, "error", err)
+			fmt.Fprintf(s.channel.Stderr(), "%s\n", err)
+			sendExitStatus(s.channel, 1)
+			s.channel.Close()
+			return
+		}
+	}
+	if s.opts.GitRoot != "" {
+		if service, repo, ok := parseGitCommand(command); ok {
+			s.runGit(service, repo)
+			return
+		}
+	}
+	if s.opts.SCP {
+		if cmd, ok := parseSCPCommand(command); ok {
+			go s.runSCP(cmd)
+			return
+		}
+		if words, err := shellWords(command); err == nil && len(words) > 1 && words[0] == "rsync" && words[1] == "--server" {
+			s.runRsync(words)
+			return
+		}
+	}
+	if fields := strings.Fields(command); s.opts.realShell() && len(fields) > 0 {
+		if _, ok := s.commands.Lookup(fields[0]); !ok {
+			s.runProgram(command)
+			return
+		}
+	}
+	out, status := s.commands.run(s.context(), command)
+	s.channel.Write([]byte(out))
+	sendExitStatus(s.channel, status)
+	s.channel.Close()
+}
+
+// runProgram runs a command line with the configured shell and reports how
+// the process ended, so clients see its real exit status or signal
+func (s *session) runProgram(command string) {
+	cmd := exec.CommandContext(s.ctx, s.opts.Shell, "-c", command)
+	cmd.Env = s.shellEnv()
+	if err := s.prepareCommand(cmd); err != nil {
+		s.startFailed(cmd, err)
+		return
+	}
+	s.runProcess(cmd)
+}
+
+// runProcess runs cmd on the session's channel and reports how it ended
+func (s *session) runProcess(cmd *exec.Cmd) {
+	cmd.Stdout = s.channel
+	cmd.Stderr = s.channel.Stderr()
+	// Copy stdin ourselves: Wait would otherwise block until the client
+	// closes its side of the channel, even after the process has exited
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		s.startFailed(cmd, err)
+		return
+	}
+	s.setProcess(cmd.Process)
+	go func() {
+		io.Copy(stdin, s.channel)
+		stdin.Close()
+	}()
+
+	go func() {
+		defer s.channel.Close()
+		err := cmd.Wait()
+		s.setProcess(nil)
+		sendExit(s.channel, err)
+	}()
+}
+
+// startFailed reports a program that could not be started with the exit
+// status shells use for commands they cannot run
+func (s *session) startFailed(cmd *exec.Cmd, err error) {
+	s.logger.Error("could not run command", "command", strings.Join(cmd.Args, " "), "error", err)
+	fmt.Fprintf(s.channel.Stderr(), "%s: %s\n", cmd.Args[0], err)
+	sendExitStatus(s.channel, 127)
+	s.channel.Close()
+}
+
+// context describes the session to command handlers
+func (s *session) context() SessionContext {
+	return newSessionContext(s.ctx, s.conn, s.env)
+}
+
+// resize forwards a window size change to the running shell, if any
+func (s *session) resize(size windowSize) {
+	if s.recorder != nil {
+		s.recorder.resize(size)
+	}
+	s.mu.Lock()
+	onResize := s.onResize
+	s.mu.Unlock()
+	if onResize != nil {
+		onResize(size)
+	}
+}
+
+// setResizeHandler registers the callback invoked on window size changes
+func (s *session) setResizeHandler(fn func(windowSize)) {
+	s.mu.Lock()
+	s.onResize = fn
+	s.mu.Unlock()
+}
+
+// signal delivers a named SSH signal (e.g. "INT", "TERM") to the running process
+func (s *session) signal(name string) {
+	s.mu.Lock()
+	process := s.process
+	s.mu.Unlock()
+	if process == nil {
+		return
+	}
+	sig, ok := sshSignals[name]
+	if !ok {
+		s.logger.Warn("ignoring unsupported signal", "signal", name)
+		return
+	}
+	if err := process.Signal(sig); err != nil {
+		s.logger.Error("could not deliver signal", "signal", name, "error", err)
+	}
+}
+
+// setProcess records the process that receives signals for this session
+func (s *session) setProcess(p *os.Process) {
+	s.mu.Lock()
+	s.process = p
+	s.mu.Unlock()
+}
+
+// startShell starts an interactive shell. When a shell program is configured,
+// it runs on a native PTY if one was requested and the platform provides one,
+// or over plain pipes otherwise. Without a configured shell, or when the native
+// PTY is unavailable, the session falls back to line-based emulation.
+func (s *session) startShell() {
+	if s.opts.RecordDir != "" {
+		// Recording is an audit control, so a session that cannot be
+		// recorded is refused rather than run unrecorded
+		rec, err := newRecorder(s.opts.RecordDir, s.conn, s.pty)
+		if err != nil {
+			s.logger.Error("refusing shell", "error", err)
+			fmt.Fprintf(s.channel.Stderr(), "session recording unavailable\r\n")
+			sendExitStatus(s.channel, 1)
+			s.channel.Close()
+			return
+		}
+		s.recorder = rec
+		s.channel = &recordingChannel{Channel: s.channel, rec: rec}
+	}
+
+	if motd := renderBanner(s.motd, s.conn, s.logger); motd != "" {
+		if s.pty != nil {
+			motd = terminalText(motd)
+		}
+		io.WriteString(s.channel, motd)
+	}
+
+	realShell := s.opts.realShell()
+	if realShell && s.pty != nil {
+		err := startNativeShell(s)
+		if err == nil {
+			return
+		}
+		if !errors.Is(err, errPTYUnavailable) {
+			s.logger.Warn("native PTY failed, using fallback session", "error", err)
+		}
+	} else if realShell {
+		if err := s.startPipedShell(); err == nil {
+			return
+		} else {
+			s.logger.Warn("could not start shell, using fallback session", "error", err)
+		}
+	}
+
+	var size *windowSize
+	if s.pty != nil {
+		size = &windowSize{Columns: s.pty.Columns, Rows: s.pty.Rows}
+	}
+
+	shell := newFallbackShell(s.conn, s.channel, size)
+	shell.allowed = s.opts.commandAllowed
+	shell.commands = s.commands
+	shell.ctx = s.context()
+	// Lines only run in the shell program when they can be confined too
+	if s.root == "" || isPrivileged() {
+		shell.program = s.opts.Shell
+		shell.prepare = s.prepareCommand
+	}
+	shell.env = s.shellEnv()
+	s.setResizeHandler(shell.resize)
+
+	go func() {
+		defer s.channel.Close()
+		if err := shell.run(); err != nil {
+			s.logger.Error("fallback shell error", "error", err)
+		}
+		sendExitStatus(s.channel, 0)
+	}()
+}
+
+// runSFTP serves the SFTP subsystem on the session channel
+func (s *session) runSFTP() {
+	defer s.channel.Close()
+	if err := serveSFTP(s.channel, s.fileRoot(), s.opts.SFTPReadOnly, s.fileAudit("sftp")); err != nil {
+		s.logger.Error("sftp session error", "error", err)
+		sendExitStatus(s.channel, 1)
+		return
+	}
+	sendExitStatus(s.channel, 0)
+}
+
+// fileRoot returns the directory SFTP and other file transfers are confined
+// to. They run in the server process, so the chroot directory is enforced by
+// resolving every path inside it rather than with chroot.
+func (s *session) fileRoot() string {
+	if s.root != "" {
+		return filepath.Join(s.root, s.opts.SFTPRoot)
+	}
+	return s.opts.SFTPRoot
+}
+
+// fileAudit returns the audit function for file operations, recorded as
+// events of the given name, or nil when auditing is off
+func (s *session) fileAudit(name string) sftpAuditFunc {
+	if s.audit == nil {
+		return nil
+	}
+	return func(operation, path, target string, err error) {
+		event := connEvent(s.conn, name)
+		event.Operation = operation
+		event.Path = path
+		event.Target = target
+		if err != nil {
+			event.Error = err.Error()
+		}
+		s.audit.record(event)
+	}
+}
+
+// startPipedShell runs the configured shell without a terminal, wiring its
+// standard streams directly to the channel
+func (s *session) startPipedShell() error {
+	cmd := exec.CommandContext(s.ctx, s.opts.Shell)
+	cmd.Env = s.shellEnv()
+	cmd.Stdin = s.channel
+	cmd.Stdout = s.channel
+	cmd.Stderr = s.channel.Stderr()
+
+	if err := s.prepareCommand(cmd); err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.setProcess(cmd.Process)
+
+	go func() {
+		defer s.channel.Close()
+		err := cmd.Wait()
+		s.setProcess(nil)
+		sendExit(s.channel, err)
+	}()
+	return nil
+}
+
+// shellEnv builds the environment for processes started by the session:
+// the server's own, or the login environment of the local account programs
+// run as. Variables accepted from "env" requests come last so they take
+// precedence.
+func (s *session) shellEnv() []string {
+	env := os.Environ()
+	if s.account != nil {
+		env = s.account.environ(s.opts.Shell)
+	}
+	if s.pty != nil && s.pty.Term != "" {
+		env = append(env, "TERM="+s.pty.Term)
+	}
+	return append(env, s.env...)
+}
+
+// sendExitStatus reports a process exit code to the client
+func sendExitStatus(channel ssh.Channel, code int) {
+	channel.SendRequest("exit-status", false, wire.ExitStatus(uint32(code)))
+}
+
+// sendExit reports how a process ended: with "exit-signal" when a signal
+// killed it, and with its exit status otherwise
+func sendExit(channel ssh.Channel, err error) {
+	if name, coreDumped, ok := exitSignal(err); ok {
+		channel.SendRequest("exit-signal", false, wire.ExitSignal(name, coreDumped, ""))
+		return
+	}
+	sendExitStatus(channel, exitCode(err))
+}
+
+// exitCode extracts a process exit code from the error returned by Wait
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode()
+	}
+	return 255
+}
@@ -0,0 +1,226 @@
+// pkg/ssh/session_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPipedShellSession(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{Shell: "/bin/sh"})
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	var out bytes.Buffer
+	session.Stdout = &out
+	session.Stdin = bytes.NewBufferString("echo piped\nexit 7\n")
+
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	err = session.Wait()
+
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitStatus() != 7 {
+		t.Errorf("Wait() error = %v, want exit status 7", err)
+	}
+	if out.String() != "piped\n" {
+		t.Errorf("output = %q, want %q", out.String(), "piped\n")
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"exit status", exec.Command("/bin/sh", "-c", "exit 42").Run(), 42},
+		{"not an exit error", errors.New("boom"), 255},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecExitStatus(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{Shell: "/bin/sh"})
+	defer server.Close()
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	tests := []struct {
+		command    string
+		wantOutput string
+		wantStatus int
+		wantSignal string
+	}{
+		{"echo ok", "ok\n", 0, ""},
+		{"echo failing; exit 3", "failing\n", 3, ""},
+		{"kill -TERM $$", "", -1, "TERM"},
+		{"whoami", "You are: alice\n", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatalf("Failed to create session: %v", err)
+			}
+			defer session.Close()
+			out, err := session.Output(tt.command)
+			if string(out) != tt.wantOutput {
+				t.Errorf("output = %q, want %q", out, tt.wantOutput)
+			}
+
+			var exitErr *ssh.ExitError
+			switch {
+			case tt.wantStatus == 0:
+				if err != nil {
+					t.Errorf("Output() error = %v, want success", err)
+				}
+			case !errors.As(err, &exitErr):
+				t.Errorf("Output() error = %v, want an exit error", err)
+			case tt.wantSignal != "" && exitErr.Signal() != tt.wantSignal:
+				t.Errorf("exit signal = %q, want %q", exitErr.Signal(), tt.wantSignal)
+			case tt.wantSignal == "" && exitErr.ExitStatus() != tt.wantStatus:
+				t.Errorf("exit status = %d, want %d", exitErr.ExitStatus(), tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestExecNotAllowedStatus(t *testing.T) {
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{AllowedCommands: []string{"whoami"}})
+	defer server.Close()
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	var exitErr *ssh.ExitError
+	if _, err := session.Output("reboot"); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 126 {
+		t.Errorf("Output() error = %v, want exit status 126", err)
+	}
+}
+
+func TestEnvRequests(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	opts := ServerOptions{Shell: "/bin/sh", AcceptEnv: []string{"LANG", "LC_*"}}
+	server, addr, signer, _ := startTestServer(t, context.Background(), opts)
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+
+	if err := session.Setenv("LANG", "de_DE.UTF-8"); err != nil {
+		t.Errorf("Setenv(LANG) should be accepted: %v", err)
+	}
+	if err := session.Setenv("LC_TIME", "C"); err != nil {
+		t.Errorf("Setenv(LC_TIME) should be accepted: %v", err)
+	}
+	if err := session.Setenv("LD_PRELOAD", "/tmp/evil.so"); err == nil {
+		t.Error("Setenv(LD_PRELOAD) should be rejected")
+	}
+
+	var out bytes.Buffer
+	session.Stdout = &out
+	session.Stdin = bytes.NewBufferString("echo $LANG $LC_TIME ${LD_PRELOAD:-unset}\n")
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	session.Wait()
+
+	if out.String() != "de_DE.UTF-8 C unset\n" {
+		t.Errorf("output = %q, want %q", out.String(), "de_DE.UTF-8 C unset\n")
+	}
+}
+
+func TestServerForceCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+
+	opts := ServerOptions{Shell: "/bin/sh", ForceCommand: `echo "forced:$SSH_ORIGINAL_COMMAND"`}
+	server, addr, signer, _ := startTestServer(t, context.Background(), opts)
+	defer server.Close()
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	if out := runTestCommand(t, client, "git-upload-pack 'repo.git'"); out != "forced:git-upload-pack 'repo.git'\n" {
+		t.Errorf("exec output = %q, want the forced command with the original command", out)
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	var out bytes.Buffer
+	session.Stdout = &out
+	if err := session.Shell(); err != nil {
+		t.Fatalf("Shell failed: %v", err)
+	}
+	session.Wait()
+	if out.String() != "forced:\n" {
+		t.Errorf("shell output = %q, want %q", out.String(), "forced:\n")
+	}
+}
+
+func TestServerForceInternalSFTP(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "drop.txt"), []byte("dropped"), 0644)
+
+	// Clients get SFTP even though the subsystem is not enabled on its own
+	client := newTestSFTPClient(t, ServerOptions{ForceCommand: InternalSFTP, SFTPRoot: root})
+	entries, err := client.ReadDir("/")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "drop.txt" {
+		t.Errorf("ReadDir(/) = %v, %v, want [drop.txt]", entries, err)
+	}
+}
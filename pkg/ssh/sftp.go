@@ -0,0 +1,386 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// sftpAuditFunc is told about every file transfer and filesystem change
+type sftpAuditFunc func(operation, path, target string, err error)
+
+// serveSFTP runs an SFTP server on the channel until the client disconnects.
+// Without a root directory the client sees the whole filesystem; with one,
+// every path is resolved inside that directory. audit may be nil.
+func serveSFTP(channel ssh.Channel, root string, readOnly bool, audit sftpAuditFunc) error {
+	if root == "" {
+		root = "/"
+	}
+	handler, err := newRootedFS(root, readOnly)
+	if err != nil {
+		return err
+	}
+	handler.audit = audit
+	server := sftp.NewRequestServer(channel, sftp.Handlers{
+		FileGet:  handler,
+		FilePut:  handler,
+		FileCmd:  handler,
+		FileList: handler,
+	})
+	return ignoreEOF(server.Serve())
+}
+
+// ignoreEOF treats a client closing the channel as a clean exit
+func ignoreEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// rootedFS implements the pkg/sftp request handlers on top of the local
+// filesystem, confined to a root directory
+type rootedFS struct {
+	root     string
+	readOnly bool
+	audit    sftpAuditFunc
+}
+
+// record passes an operation to the audit function, if any, and returns err
+func (fs *rootedFS) record(operation string, r *sftp.Request, err error) error {
+	if fs.audit != nil {
+		fs.audit(operation, r.Filepath, r.Target, err)
+	}
+	return err
+}
+
+// recordPath passes an operation on a single path to the audit function,
+// if any, and returns err
+func (fs *rootedFS) recordPath(operation, path string, err error) error {
+	if fs.audit != nil {
+		fs.audit(operation, path, "", err)
+	}
+	return err
+}
+
+// newRootedFS creates a handler confined to root, which must be an existing directory
+func newRootedFS(root string, readOnly bool) (*rootedFS, error) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, fmt.Errorf("SFTP root: %s", err)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("SFTP root: %s", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("SFTP root %s is not a directory", root)
+	}
+	return &rootedFS{root: resolved, readOnly: readOnly}, nil
+}
+
+// hostPath maps a client path to a path on the local filesystem, refusing
+// paths that would resolve outside the root through symbolic links
+func (fs *rootedFS) hostPath(clientPath string) (string, error) {
+	full := filepath.Join(fs.root, filepath.FromSlash(path.Clean("/"+clientPath)))
+
+	resolved, err := resolveExisting(full)
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(fs.root, resolved) {
+		return "", os.ErrPermission
+	}
+	return full, nil
+}
+
+// linkPath maps a client path like hostPath, but only resolves its parent
+// directory, for operations that act on a symlink itself rather than its target
+func (fs *rootedFS) linkPath(clientPath string) (string, error) {
+	clean := path.Clean("/" + clientPath)
+	if clean == "/" {
+		return fs.root, nil
+	}
+	dir, err := fs.hostPath(path.Dir(clean))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, path.Base(clean)), nil
+}
+
+// resolveExisting evaluates symlinks in the longest existing prefix of p
+func resolveExisting(p string) (string, error) {
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return "", err
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = parent
+	}
+}
+
+// withinDir reports whether p is dir itself or lies below it
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// Fileread opens a file for download
+func (fs *rootedFS) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	p, err := fs.hostPath(r.Filepath)
+	if err != nil {
+		return nil, fs.record("download", r, err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fs.record("download", r, err)
+	}
+	fs.record("download", r, nil)
+	return f, nil
+}
+
+// Filewrite opens a file for upload
+func (fs *rootedFS) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if fs.readOnly {
+		return nil, fs.record("upload", r, os.ErrPermission)
+	}
+	p, err := fs.hostPath(r.Filepath)
+	if err != nil {
+		return nil, fs.record("upload", r, err)
+	}
+	f, err := os.OpenFile(p, openFlags(r.Pflags()), 0o644)
+	if err != nil {
+		return nil, fs.record("upload", r, err)
+	}
+	fs.record("upload", r, nil)
+	return f, nil
+}
+
+// openFlags translates SFTP open flags into os.OpenFile flags
+func openFlags(pflags sftp.FileOpenFlags) int {
+	var flags int
+	switch {
+	case pflags.Read && pflags.Write:
+		flags = os.O_RDWR
+	case pflags.Write:
+		flags = os.O_WRONLY
+	default:
+		flags = os.O_RDONLY
+	}
+	if pflags.Append {
+		flags |= os.O_APPEND
+	}
+	if pflags.Creat {
+		flags |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flags |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flags |= os.O_EXCL
+	}
+	return flags
+}
+
+// Filecmd handles metadata and namespace changes
+func (fs *rootedFS) Filecmd(r *sftp.Request) error {
+	return fs.record(strings.ToLower(r.Method), r, fs.filecmd(r))
+}
+
+// filecmd performs a Filecmd request
+func (fs *rootedFS) filecmd(r *sftp.Request) error {
+	if fs.readOnly {
+		return os.ErrPermission
+	}
+
+	switch r.Method {
+	case "Setstat":
+		p, err := fs.hostPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		return setstat(p, r)
+	case "Rename":
+		src, err := fs.linkPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		dst, err := fs.linkPath(r.Target)
+		if err != nil {
+			return err
+		}
+		return os.Rename(src, dst)
+	case "Rmdir", "Remove":
+		p, err := fs.linkPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		return os.Remove(p)
+	case "Mkdir":
+		p, err := fs.linkPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		return os.Mkdir(p, 0o755)
+	case "Link":
+		p, err := fs.hostPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		link, err := fs.linkPath(r.Target)
+		if err != nil {
+			return err
+		}
+		return os.Link(p, link)
+	case "Symlink":
+		// r.Filepath is the link target and r.Target the new link; the link
+		// is created with a relative target so it cannot point outside
+		p, err := fs.hostPath(r.Filepath)
+		if err != nil {
+			return err
+		}
+		link, err := fs.linkPath(r.Target)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Dir(link), p)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(rel, link)
+	}
+	return fmt.Errorf("unsupported SFTP command %q", r.Method)
+}
+
+// setstat applies the attributes of a Setstat request to a file
+func setstat(p string, r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.Permissions {
+		if err := os.Chmod(p, attrs.FileMode().Perm()); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		atime := time.Unix(int64(attrs.Atime), 0)
+		mtime := time.Unix(int64(attrs.Mtime), 0)
+		if err := os.Chtimes(p, atime, mtime); err != nil {
+			return err
+		}
+	}
+	if flags.Size {
+		if err := os.Truncate(p, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Filelist handles directory listings, stat and readlink
+func (fs *rootedFS) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	switch r.Method {
+	case "List":
+		p, err := fs.hostPath(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]os.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			infos = append(infos, info)
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		p, err := fs.hostPath(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	case "Readlink":
+		p, err := fs.linkPath(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		target, err := os.Readlink(p)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{namedFileInfo{name: filepath.ToSlash(target)}}, nil
+	}
+	return nil, fmt.Errorf("unsupported SFTP list method %q", r.Method)
+}
+
+// Lstat reports information about a file without following symlinks
+func (fs *rootedFS) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	p, err := fs.linkPath(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	return listerAt{info}, nil
+}
+
+// listerAt serves a fixed slice of file infos to the SFTP server
+type listerAt []os.FileInfo
+
+// ListAt copies entries starting at offset into f
+func (l listerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(f, l[offset:])
+	if n < len(f) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// namedFileInfo is a minimal os.FileInfo carrying only a name, used for readlink replies
+type namedFileInfo struct {
+	name string
+}
+
+func (n namedFileInfo) Name() string       { return n.name }
+func (n namedFileInfo) Size() int64        { return 0 }
+func (n namedFileInfo) Mode() os.FileMode  { return 0 }
+func (n namedFileInfo) ModTime() time.Time { return time.Time{} }
+func (n namedFileInfo) IsDir() bool        { return false }
+func (n namedFileInfo) Sys() any           { return nil }
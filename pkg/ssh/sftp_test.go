@@ -0,0 +1,148 @@
+// pkg/ssh/sftp_test.go
+package ssh
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// newTestSFTPClient starts a server with the given options and opens an SFTP client to it
+func newTestSFTPClient(t *testing.T, opts ServerOptions) *sftp.Client {
+	t.Helper()
+	server, addr, signer, _ := startTestServer(t, context.Background(), opts)
+	t.Cleanup(func() { server.Close() })
+
+	client := dialTestServer(t, addr, "alice", signer)
+	t.Cleanup(func() { client.Close() })
+
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		t.Fatalf("Failed to start SFTP client: %v", err)
+	}
+	t.Cleanup(func() { sftpClient.Close() })
+	return sftpClient
+}
+
+func TestSFTPRootedTransfer(t *testing.T) {
+	root := t.TempDir()
+	client := newTestSFTPClient(t, ServerOptions{SFTP: true, SFTPRoot: root})
+
+	// Upload a file and check it landed inside the root
+	f, err := client.Create("/upload.txt")
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	f.Write([]byte("hello sftp"))
+	f.Close()
+
+	data, err := os.ReadFile(filepath.Join(root, "upload.txt"))
+	if err != nil || string(data) != "hello sftp" {
+		t.Fatalf("uploaded file = %q, %v; want %q", data, err, "hello sftp")
+	}
+
+	// Download it again
+	r, err := client.Open("/upload.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != "hello sftp" {
+		t.Errorf("downloaded %q, want %q", got, "hello sftp")
+	}
+
+	// Directory operations
+	if err := client.Mkdir("/sub"); err != nil {
+		t.Errorf("Mkdir failed: %v", err)
+	}
+	if err := client.Rename("/upload.txt", "/sub/moved.txt"); err != nil {
+		t.Errorf("Rename failed: %v", err)
+	}
+	entries, err := client.ReadDir("/sub")
+	if err != nil || len(entries) != 1 || entries[0].Name() != "moved.txt" {
+		t.Errorf("ReadDir(/sub) = %v, %v; want [moved.txt]", entries, err)
+	}
+	if err := client.Chmod("/sub/moved.txt", 0o600); err != nil {
+		t.Errorf("Chmod failed: %v", err)
+	}
+	if err := client.Remove("/sub/moved.txt"); err != nil {
+		t.Errorf("Remove failed: %v", err)
+	}
+}
+
+func TestSFTPRootConfinement(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("top secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	client := newTestSFTPClient(t, ServerOptions{SFTP: true, SFTPRoot: root})
+
+	// Dot-dot paths are clamped to the root
+	if _, err := client.Stat("/../../" + filepath.Base(outside) + "/secret"); err == nil {
+		t.Error("dot-dot traversal should not reach files outside the root")
+	}
+
+	// Symlinks pointing outside the root cannot be followed
+	if _, err := client.Open("/escape/secret"); err == nil {
+		t.Error("symlink escape should be refused")
+	}
+	if _, err := client.Create("/escape/planted"); err == nil {
+		t.Error("writing through a symlink escape should be refused")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "planted")); err == nil {
+		t.Error("file was planted outside the root")
+	}
+
+	// The link itself is still visible and removable
+	if _, err := client.Lstat("/escape"); err != nil {
+		t.Errorf("Lstat(/escape) failed: %v", err)
+	}
+}
+
+func TestSFTPReadOnly(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "existing.txt"), []byte("read me"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := newTestSFTPClient(t, ServerOptions{SFTP: true, SFTPRoot: root, SFTPReadOnly: true})
+
+	r, err := client.Open("/existing.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	got, _ := io.ReadAll(r)
+	r.Close()
+	if string(got) != "read me" {
+		t.Errorf("read %q, want %q", got, "read me")
+	}
+
+	if _, err := client.Create("/new.txt"); err == nil {
+		t.Error("Create should fail on a read-only server")
+	}
+	if err := client.Remove("/existing.txt"); err == nil {
+		t.Error("Remove should fail on a read-only server")
+	}
+}
+
+func TestSFTPDisabled(t *testing.T) {
+	server, addr, signer, _ := startTestServer(t, context.Background(), ServerOptions{})
+	defer server.Close()
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	if _, err := sftp.NewClient(client); err == nil {
+		t.Error("SFTP subsystem should be rejected when not enabled")
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package ssh
+
+import "os"
+
+// sshSignals maps RFC 4254 signal names to the signals delivered to processes.
+// Only the portable signals are available outside Unix.
+var sshSignals = map[string]os.Signal{
+	"INT":  os.Interrupt,
+	"KILL": os.Kill,
+}
+
+// exitSignal reports the signal that killed a process. Processes are not
+// killed by signals outside Unix, so it never reports one.
+func exitSignal(err error) (name string, coreDumped bool, ok bool) {
+	return "", false, false
+}
@@ -0,0 +1,46 @@
+//go:build unix
+
+package ssh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// sshSignals maps RFC 4254 signal names to the signals delivered to processes
+var sshSignals = map[string]os.Signal{
+	"ABRT": syscall.SIGABRT,
+	"ALRM": syscall.SIGALRM,
+	"FPE":  syscall.SIGFPE,
+	"HUP":  syscall.SIGHUP,
+	"ILL":  syscall.SIGILL,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"PIPE": syscall.SIGPIPE,
+	"QUIT": syscall.SIGQUIT,
+	"SEGV": syscall.SIGSEGV,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// exitSignal returns the RFC 4254 name of the signal that killed the process
+// whose Wait returned err. Signals without an RFC 4254 name are not reported.
+func exitSignal(err error) (name string, coreDumped bool, ok bool) {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return "", false, false
+	}
+	status, isWaitStatus := exitErr.Sys().(syscall.WaitStatus)
+	if !isWaitStatus || !status.Signaled() {
+		return "", false, false
+	}
+	for name, sig := range sshSignals {
+		if sig == status.Signal() {
+			return name, status.CoreDump(), true
+		}
+	}
+	return "", false, false
+}
@@ -0,0 +1,99 @@
+package ssh
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// subsystemsExtension carries the subsystems= option of the client's key, or
+// the extension of the same name in a user certificate
+const subsystemsExtension = "subsystems"
+
+// SubsystemHandler serves a subsystem, such as netconf or a custom RPC
+// protocol, on a session channel. The client's requests are read from
+// channel and responses written to it. The client is sent exit status 0 when
+// the handler returns nil and 1 otherwise, and the channel is then closed.
+type SubsystemHandler func(ctx SessionContext, channel ssh.Channel) error
+
+// subsystemRegistry maps subsystem names to their handlers. It is safe for
+// concurrent use.
+type subsystemRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]SubsystemHandler
+}
+
+// register makes handler serve the subsystem name; a nil handler removes it
+func (r *subsystemRegistry) register(name string, handler SubsystemHandler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if handler == nil {
+		delete(r.handlers, name)
+		return
+	}
+	if r.handlers == nil {
+		r.handlers = map[string]SubsystemHandler{}
+	}
+	r.handlers[name] = handler
+}
+
+// lookup returns the handler registered for name
+func (r *subsystemRegistry) lookup(name string) (SubsystemHandler, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	handler, ok := r.handlers[name]
+	return handler, ok
+}
+
+// RegisterSubsystem makes handler serve "subsystem" requests for name,
+// replacing any existing handler, including the built-in sftp. A nil handler
+// removes the subsystem. Subsystems can be registered while the server runs.
+func (s *Server) RegisterSubsystem(name string, handler SubsystemHandler) {
+	s.subsystems.register(name, handler)
+}
+
+// parseSubsystems parses a subsystems= key option: a comma-separated list of
+// subsystem names, each of which may use the wildcards * and ?
+func parseSubsystems(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return fmt.Errorf("invalid subsystems pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// subsystemPermitted reports whether the connection's key or certificate may
+// open the named subsystem. Keys without a subsystems option may open every
+// subsystem the server offers.
+func subsystemPermitted(conn *ssh.ServerConn, name string) bool {
+	if conn.Permissions == nil {
+		return true
+	}
+	allowed, ok := conn.Permissions.Extensions[subsystemsExtension]
+	if !ok {
+		return true
+	}
+	for _, pattern := range strings.Split(allowed, ",") {
+		if ok, _ := path.Match(strings.TrimSpace(pattern), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runSubsystem serves a registered subsystem on the session channel
+func (s *session) runSubsystem(name string, handler SubsystemHandler) {
+	defer s.channel.Close()
+	s.logger.Info("subsystem started", "subsystem", name)
+	if err := handler(s.context(), s.channel); err != nil {
+		s.logger.Error("subsystem error", "subsystem", name, "error", err)
+		sendExitStatus(s.channel, 1)
+		return
+	}
+	sendExitStatus(s.channel, 0)
+}
@@ -0,0 +1,128 @@
+// pkg/ssh/subsystems_test.go
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSubsystemPermitted(t *testing.T) {
+	tests := []struct {
+		perms *ssh.Permissions
+		name  string
+		want  bool
+	}{
+		{nil, "netconf", true},
+		{newPermissions(), "netconf", true},
+		{&ssh.Permissions{Extensions: map[string]string{subsystemsExtension: "netconf, rpc-*"}}, "rpc-billing", true},
+		{&ssh.Permissions{Extensions: map[string]string{subsystemsExtension: "netconf"}}, "sftp", false},
+	}
+	for _, tt := range tests {
+		conn := &ssh.ServerConn{Permissions: tt.perms}
+		if got := subsystemPermitted(conn, tt.name); got != tt.want {
+			t.Errorf("subsystemPermitted(%v, %q) = %v, want %v", tt.perms, tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := parseKeyOptions([]string{`subsystems="netconf,rpc-*"`}); err != nil {
+		t.Errorf("valid subsystems option error = %v", err)
+	}
+	for _, bad := range []string{`subsystems="["`, `subsystems="netconf,"`} {
+		if _, err := parseKeyOptions([]string{bad}); err == nil {
+			t.Errorf("parseKeyOptions(%s) should fail", bad)
+		}
+	}
+}
+
+func TestServerRegisterSubsystem(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hostKey, _ := newTestKeyPair(t)
+	_, signer := newTestKeyPair(t)
+	_, restricted := newTestKeyPair(t)
+	authorized := string(ssh.MarshalAuthorizedKey(signer.PublicKey())) +
+		`subsystems="echo" ` + string(ssh.MarshalAuthorizedKey(restricted.PublicKey()))
+	server, err := NewServer(hostKey, []byte(authorized), ServerOptions{SFTP: true})
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	go server.Serve(ctx, listener)
+	addr := listener.Addr().String()
+
+	server.RegisterSubsystem("echo", func(ctx SessionContext, channel ssh.Channel) error {
+		data, err := io.ReadAll(channel)
+		if err != nil {
+			return err
+		}
+		_, err = channel.Write(bytes.ToUpper(data))
+		return err
+	})
+	server.RegisterSubsystem("broken", func(ctx SessionContext, channel ssh.Channel) error {
+		return errors.New("backend unavailable")
+	})
+
+	// subsystem opens a session for name, sends input and returns the output
+	// and exit status
+	subsystem := func(client *ssh.Client, name, input string) (string, uint32, error) {
+		channel, requests, err := client.OpenChannel("session", nil)
+		if err != nil {
+			t.Fatalf("OpenChannel failed: %v", err)
+		}
+		defer channel.Close()
+		ok, err := channel.SendRequest("subsystem", true, ssh.Marshal(struct{ Name string }{name}))
+		if err != nil || !ok {
+			return "", 0, fmt.Errorf("subsystem %s rejected: %v", name, err)
+		}
+		io.WriteString(channel, input)
+		channel.CloseWrite()
+		out, err := io.ReadAll(channel)
+		var status uint32
+		for req := range requests {
+			if req.Type == "exit-status" {
+				status = binary.BigEndian.Uint32(req.Payload)
+			}
+		}
+		return string(out), status, err
+	}
+
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+	if out, status, err := subsystem(client, "echo", "hello"); err != nil || out != "HELLO" || status != 0 {
+		t.Errorf("echo subsystem = %q, %d, %v, want HELLO", out, status, err)
+	}
+	if _, status, err := subsystem(client, "broken", ""); err != nil || status != 1 {
+		t.Errorf("failing subsystem = %d, %v, want exit status 1", status, err)
+	}
+	if _, _, err := subsystem(client, "netconf", ""); err == nil {
+		t.Error("an unregistered subsystem should be rejected")
+	}
+
+	// A key limited to echo cannot open the built-in sftp
+	limited := dialTestServer(t, addr, "bob", restricted)
+	defer limited.Close()
+	if out, _, err := subsystem(limited, "echo", "hi"); err != nil || out != "HI" {
+		t.Errorf("permitted subsystem = %q, %v", out, err)
+	}
+	if _, _, err := subsystem(limited, "sftp", ""); err == nil {
+		t.Error("sftp should be denied to a key limited to echo")
+	}
+
+	// Removing a subsystem rejects later requests
+	server.RegisterSubsystem("echo", nil)
+	if _, _, err := subsystem(client, "echo", ""); err == nil {
+		t.Error("a removed subsystem should be rejected")
+	}
+}
@@ -0,0 +1,115 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemdFirstFD is the first file descriptor systemd passes to an activated service
+const systemdFirstFD = 3
+
+// SystemdListeners returns the sockets systemd passed to the process through
+// socket activation (LISTEN_FDS), in the order of the .socket unit, or nil
+// when the process was not socket activated. The activation variables are
+// removed from the environment so shells started for clients do not see them.
+func SystemdListeners() ([]net.Listener, error) {
+	return systemdListeners(systemdFirstFD)
+}
+
+// systemdListeners turns the LISTEN_FDS descriptors starting at firstFD into listeners
+func systemdListeners(firstFD int) ([]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(firstFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(firstFD+i), name)
+		// FileListener duplicates the descriptor, so the original is closed
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd socket %s: %s", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// SystemdNotify sends a service state such as "READY=1" or "STOPPING=1" to
+// systemd, as sd_notify does. It reports false without error when the process
+// was not started by a Type=notify service (NOTIFY_SOCKET is unset).
+func SystemdNotify(state string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// Abstract sockets are given with a leading @
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("systemd notify error: %s", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("systemd notify error: %s", err)
+	}
+	return true, nil
+}
+
+// SystemdWatchdogInterval returns the watchdog timeout systemd expects
+// keep-alive pings within (WatchdogSec=), or zero when the watchdog is off
+func SystemdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// SystemdWatchdog pings the systemd watchdog at half its interval until ctx
+// is cancelled. It returns immediately when the watchdog is off.
+func SystemdWatchdog(ctx context.Context) error {
+	interval := SystemdWatchdogInterval()
+	if interval == 0 {
+		return nil
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		if _, err := SystemdNotify("WATCHDOG=1"); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,123 @@
+//go:build unix
+
+// pkg/ssh/systemd_test.go
+package ssh
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// listenNotifySocket points NOTIFY_SOCKET at a datagram socket and returns it
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("ListenUnixgram failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotification reads the next state sent to the notify socket
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 256)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("no notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestSystemdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := SystemdNotify("READY=1"); sent || err != nil {
+		t.Errorf("SystemdNotify() without a socket = %v, %v, want false, nil", sent, err)
+	}
+
+	conn := listenNotifySocket(t)
+	if sent, err := SystemdNotify("READY=1\nSTATUS=Serving"); !sent || err != nil {
+		t.Fatalf("SystemdNotify() = %v, %v", sent, err)
+	}
+	if got := readNotification(t, conn); got != "READY=1\nSTATUS=Serving" {
+		t.Errorf("notification = %q", got)
+	}
+}
+
+func TestSystemdWatchdog(t *testing.T) {
+	conn := listenNotifySocket(t)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if got := SystemdWatchdogInterval(); got != 100*time.Millisecond {
+		t.Errorf("SystemdWatchdogInterval() = %s, want 100ms", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- SystemdWatchdog(ctx) }()
+	for i := 0; i < 2; i++ {
+		if got := readNotification(t, conn); got != "WATCHDOG=1" {
+			t.Errorf("notification = %q, want WATCHDOG=1", got)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("SystemdWatchdog() error = %v", err)
+	}
+
+	t.Setenv("WATCHDOG_PID", "1")
+	if got := SystemdWatchdogInterval(); got != 0 {
+		t.Errorf("watchdog meant for another process = %s, want 0", got)
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := SystemdListeners(); listeners != nil || err != nil {
+		t.Errorf("SystemdListeners() for another process = %v, %v", listeners, err)
+	}
+
+	// Pass a real listening socket as if systemd had opened it
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File failed: %v", err)
+	}
+	defer f.Close()
+	// systemdListeners closes the descriptor it is given, so hand it a copy
+	fd, err := unix.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("Dup failed: %v", err)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "gossh.socket")
+	listeners, err := systemdListeners(fd)
+	if err != nil || len(listeners) != 1 {
+		t.Fatalf("systemdListeners() = %v, %v, want one listener", listeners, err)
+	}
+	defer listeners[0].Close()
+	if listeners[0].Addr().String() != l.Addr().String() {
+		t.Errorf("listener address = %s, want %s", listeners[0].Addr(), l.Addr())
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("the activation variables should be removed from the environment")
+	}
+}
@@ -0,0 +1,9 @@
+package ssh
+
+import "golang.org/x/sys/unix"
+
+// ioctl requests used to read and write terminal attributes
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
@@ -0,0 +1,154 @@
+package ssh
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// totpPeriod is the lifetime of a TOTP code in seconds (RFC 6238 default)
+	totpPeriod = 30
+	// totpDigits is the number of digits in a TOTP code
+	totpDigits = 6
+	// totpSkew is how many periods before and after the current one are accepted
+	totpSkew = 1
+)
+
+// totpVerifier checks TOTP codes against per-user secret files and rejects
+// codes that were already used
+type totpVerifier struct {
+	dir string
+	now func() time.Time
+
+	mu       sync.Mutex
+	lastStep map[string]uint64
+}
+
+// newTOTPVerifier creates a verifier reading secrets from dir, which holds one
+// file per user named after the user and containing a base32 secret
+func newTOTPVerifier(dir string) (*totpVerifier, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("TOTP secret directory: %s", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("TOTP secret directory %s is not a directory", dir)
+	}
+	return &totpVerifier{dir: dir, now: time.Now, lastStep: map[string]uint64{}}, nil
+}
+
+// secret reads and decodes the user's TOTP secret
+func (v *totpVerifier) secret(user string) ([]byte, error) {
+	p, err := userFilePath(v.dir, user)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTOTPSecret(string(data))
+}
+
+// decodeTOTPSecret decodes a base32 secret as shown by authenticator apps,
+// tolerating spaces, lower case and missing padding
+func decodeTOTPSecret(s string) ([]byte, error) {
+	s = strings.ToUpper(strings.Join(strings.Fields(s), ""))
+	s = strings.TrimRight(s, "=")
+	secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("decode TOTP secret: %s", err)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("empty TOTP secret")
+	}
+	return secret, nil
+}
+
+// verify reports whether code is valid for user right now. Each time step
+// can be used only once per user, so an observed code cannot be replayed.
+func (v *totpVerifier) verify(user, code string) bool {
+	secret, err := v.secret(user)
+	if err != nil {
+		return false
+	}
+
+	current := uint64(v.now().Unix()) / totpPeriod
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for offset := -totpSkew; offset <= totpSkew; offset++ {
+		step := current + uint64(offset)
+		if step <= v.lastStep[user] {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			v.lastStep[user] = step
+			return true
+		}
+	}
+	return false
+}
+
+// totpCode computes the TOTP code for a time step (RFC 4226 section 5.3)
+func totpCode(secret []byte, step uint64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], step)
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// challenge is a keyboard-interactive callback that asks for a TOTP code and,
+// when it is valid, grants the permissions of the preceding authentication step
+func (v *totpVerifier) challenge(perms *ssh.Permissions) func(ssh.ConnMetadata, ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	return func(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		answers, err := client(c.User(), "Two-factor authentication", []string{"Verification code: "}, []bool{false})
+		if err != nil {
+			return nil, err
+		}
+		if len(answers) != 1 || !v.verify(c.User(), strings.TrimSpace(answers[0])) {
+			return nil, fmt.Errorf("invalid verification code for %q", c.User())
+		}
+		return perms, nil
+	}
+}
+
+// requireTOTP wraps a first-factor callback so that its success only
+// partially authenticates the client, which must then pass the TOTP challenge
+func requireTOTP[T any](v *totpVerifier, callback func(ssh.ConnMetadata, T) (*ssh.Permissions, error)) func(ssh.ConnMetadata, T) (*ssh.Permissions, error) {
+	if callback == nil {
+		return nil
+	}
+	return func(c ssh.ConnMetadata, credential T) (*ssh.Permissions, error) {
+		perms, err := callback(c, credential)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &ssh.PartialSuccessError{
+			Next: ssh.ServerAuthCallbacks{
+				KeyboardInteractiveCallback: v.challenge(perms),
+			},
+		}
+	}
+}
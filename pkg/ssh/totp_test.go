@@ -0,0 +1,158 @@
+// pkg/ssh/totp_test.go
+package ssh
+
+import (
+	"context"
+	"encoding/base32"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// rfcSecret is the SHA-1 test key from RFC 6238 appendix B
+var rfcSecret = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 vectors, truncated to the last six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		if got := totpCode(rfcSecret, uint64(tt.unix)/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	encoded := base32.StdEncoding.EncodeToString(rfcSecret)
+
+	for _, input := range []string{
+		encoded,
+		encoded + "\n",
+		"gezd gnbv gy3t qojq gezd gnbv gy3t qojq",
+	} {
+		secret, err := decodeTOTPSecret(input)
+		if err != nil {
+			t.Errorf("decodeTOTPSecret(%q) error = %v", input, err)
+			continue
+		}
+		if string(secret) != string(rfcSecret) {
+			t.Errorf("decodeTOTPSecret(%q) = %q", input, secret)
+		}
+	}
+
+	for _, input := range []string{"", "not base32!"} {
+		if _, err := decodeTOTPSecret(input); err == nil {
+			t.Errorf("decodeTOTPSecret(%q) should fail", input)
+		}
+	}
+}
+
+// newTestTOTPDir writes an RFC 6238 secret for each user into a temporary directory
+func newTestTOTPDir(t *testing.T, users ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, user := range users {
+		secret := base32.StdEncoding.EncodeToString(rfcSecret)
+		if err := os.WriteFile(filepath.Join(dir, user), []byte(secret+"\n"), 0o600); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestTOTPVerifier(t *testing.T) {
+	v, err := newTOTPVerifier(newTestTOTPDir(t, "alice"))
+	if err != nil {
+		t.Fatalf("newTOTPVerifier() error = %v", err)
+	}
+	now := time.Unix(1111111109, 0)
+	v.now = func() time.Time { return now }
+	step := uint64(now.Unix()) / totpPeriod
+
+	if v.verify("bob", totpCode(rfcSecret, step)) {
+		t.Error("user without a secret file should be rejected")
+	}
+	if v.verify("../alice", totpCode(rfcSecret, step)) {
+		t.Error("user names with path elements should be rejected")
+	}
+	if v.verify("alice", "000000") {
+		t.Error("wrong code should be rejected")
+	}
+	if v.verify("alice", totpCode(rfcSecret, step+2)) {
+		t.Error("code outside the skew window should be rejected")
+	}
+	if !v.verify("alice", totpCode(rfcSecret, step-1)) {
+		t.Error("code from the previous period should be accepted")
+	}
+	if !v.verify("alice", totpCode(rfcSecret, step)) {
+		t.Error("current code should be accepted")
+	}
+	if v.verify("alice", totpCode(rfcSecret, step)) {
+		t.Error("a used code should not be accepted again")
+	}
+	if v.verify("alice", totpCode(rfcSecret, step-1)) {
+		t.Error("a code older than the last used one should be rejected")
+	}
+}
+
+func TestNewServerTOTPRequiresSecretDir(t *testing.T) {
+	hostKey, _ := newTestKeyPair(t)
+	if _, err := NewServer(hostKey, nil, ServerOptions{TOTP: true}); err == nil {
+		t.Error("NewServer should fail when TOTP is enabled without a secret directory")
+	}
+}
+
+func TestServerTOTPAfterPublicKey(t *testing.T) {
+	dir := newTestTOTPDir(t, "alice")
+
+	tests := []struct {
+		name   string
+		code   func() string
+		wantOK bool
+	}{
+		{"valid code", func() string { return totpCode(rfcSecret, uint64(time.Now().Unix())/totpPeriod) }, true},
+		{"wrong code", func() string { return "not-a-code" }, false},
+		{"no second factor", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{TOTP: true, TOTPSecretDir: dir})
+
+			auth := []ssh.AuthMethod{ssh.PublicKeys(signer)}
+			if tt.code != nil {
+				auth = append(auth, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+					return []string{tt.code()}, nil
+				}))
+			}
+			client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+				User:            "alice",
+				Auth:            auth,
+				HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				Timeout:         time.Second,
+			})
+			if client != nil {
+				client.Close()
+			}
+			if (err == nil) != tt.wantOK {
+				t.Errorf("Dial error = %v, want success %v", err, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultVaultSSHMount is the path Vault's SSH secrets engine is usually mounted at
+const DefaultVaultSSHMount = "ssh"
+
+// VaultClient talks to the HTTP API of a HashiCorp Vault server, to read
+// keys from its KV store and to have keys signed by its SSH secrets engine
+type VaultClient struct {
+	// Address is the server URL, e.g. https://vault.example.com:8200
+	Address string
+	// Token authenticates every request
+	Token string
+	// Namespace is the Vault Enterprise namespace, if any
+	Namespace string
+	// HTTPClient sends the requests; nil uses a client with a 30 second timeout
+	HTTPClient *http.Client
+}
+
+// NewVaultClientFromEnv configures a client the way the vault CLI does: from
+// VAULT_ADDR, VAULT_TOKEN (or ~/.vault-token) and VAULT_NAMESPACE
+func NewVaultClientFromEnv() (*VaultClient, error) {
+	v := &VaultClient{
+		Address:   os.Getenv("VAULT_ADDR"),
+		Token:     os.Getenv("VAULT_TOKEN"),
+		Namespace: os.Getenv("VAULT_NAMESPACE"),
+	}
+	if v.Address == "" {
+		return nil, errors.New("VAULT_ADDR is not set")
+	}
+	if v.Token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			token, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			v.Token = strings.TrimSpace(string(token))
+		}
+	}
+	if v.Token == "" {
+		return nil, errors.New("no Vault token: set VAULT_TOKEN or run vault login")
+	}
+	return v, nil
+}
+
+// vaultResponse is the envelope of every Vault API response
+type vaultResponse struct {
+	Data   map[string]any `json:"data"`
+	Errors []string       `json:"errors"`
+}
+
+// do sends a request to the Vault API path, e.g. "secret/data/gossh", and
+// returns the data of the response
+func (v *VaultClient) do(ctx context.Context, method, path string, body any) (map[string]any, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	url := strings.TrimRight(v.Address, "/") + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := v.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var decoded vaultResponse
+	decodeErr := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&decoded)
+	if resp.StatusCode != http.StatusOK {
+		if len(decoded.Errors) > 0 {
+			return nil, fmt.Errorf("vault: %s %s: %s", method, path, strings.Join(decoded.Errors, "; "))
+		}
+		return nil, fmt.Errorf("vault: %s %s returned %s", method, path, resp.Status)
+	}
+	if decodeErr != nil {
+		return nil, fmt.Errorf("vault: invalid response: %s", decodeErr)
+	}
+	return decoded.Data, nil
+}
+
+// ReadSecret returns a string field of the secret at path, e.g. the
+// private_key field of secret/data/gossh/host. Both KV version 1 and 2
+// paths work.
+func (v *VaultClient) ReadSecret(ctx context.Context, path, field string) (string, error) {
+	data, err := v.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return "", err
+	}
+	// KV version 2 nests the secret inside data.data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, isMetadata := data["metadata"]; isMetadata {
+			data = nested
+		}
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault: secret %s has no %q field", path, field)
+	}
+	return value, nil
+}
+
+// VaultSignRequest asks Vault's SSH secrets engine to sign a public key
+type VaultSignRequest struct {
+	// Mount is where the SSH secrets engine is mounted (default DefaultVaultSSHMount)
+	Mount string
+	// Role is the signing role, which decides what Vault allows
+	Role string
+	// PublicKey is the key to certify
+	PublicKey ssh.PublicKey
+	// Principals are the user names or host names the certificate is valid for
+	Principals []string
+	// TTL is the certificate lifetime; zero uses the role's default
+	TTL time.Duration
+	// KeyID is recorded in the certificate when the role allows it
+	KeyID string
+	// Host requests a host certificate instead of a user certificate
+	Host bool
+}
+
+// SignKey has Vault's SSH secrets engine sign a public key and returns the
+// certificate
+func (v *VaultClient) SignKey(ctx context.Context, req VaultSignRequest) (*ssh.Certificate, error) {
+	if req.PublicKey == nil {
+		return nil, errors.New("vault sign request has no public key")
+	}
+	mount := req.Mount
+	if mount == "" {
+		mount = DefaultVaultSSHMount
+	}
+	body := map[string]string{
+		"public_key": strings.TrimSpace(string(ssh.MarshalAuthorizedKey(req.PublicKey))),
+		"cert_type":  "user",
+	}
+	if req.Host {
+		body["cert_type"] = "host"
+	}
+	if len(req.Principals) > 0 {
+		body["valid_principals"] = strings.Join(req.Principals, ",")
+	}
+	if req.TTL > 0 {
+		body["ttl"] = req.TTL.String()
+	}
+	if req.KeyID != "" {
+		body["key_id"] = req.KeyID
+	}
+	data, err := v.do(ctx, http.MethodPost, strings.Trim(mount, "/")+"/sign/"+req.Role, body)
+	if err != nil {
+		return nil, err
+	}
+	signed, _ := data["signed_key"].(string)
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signed))
+	if err != nil {
+		return nil, fmt.Errorf("vault: parse signed key: %s", err)
+	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("vault: signed key is not a certificate")
+	}
+	if !bytes.Equal(cert.Key.Marshal(), req.PublicKey.Marshal()) {
+		return nil, errors.New("vault: certificate does not match the requested public key")
+	}
+	return cert, nil
+}
@@ -0,0 +1,177 @@
+// pkg/ssh/vault_test.go
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newFakeVault serves the KV and SSH signing endpoints of a Vault server,
+// signing with ca and recording the last signing request in signed
+func newFakeVault(t *testing.T, ca ssh.Signer, signed map[string]string) *httptest.Server {
+	t.Helper()
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.token" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors": ["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/kv/gossh":
+			w.Write([]byte(`{"data": {"private_key": "v1 key"}}`))
+		case "/v1/secret/data/gossh":
+			w.Write([]byte(`{"data": {"data": {"private_key": "v2 key"}, "metadata": {"version": 3}}}`))
+		case "/v1/ssh/sign/dev", "/v1/ssh-hosts/sign/host":
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			for k, v := range body {
+				signed[k] = v
+			}
+			pubKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(body["public_key"]))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"errors": ["invalid public key"]}`))
+				return
+			}
+			cert := &ssh.Certificate{
+				Key:             pubKey,
+				CertType:        ssh.UserCert,
+				ValidPrincipals: strings.Split(body["valid_principals"], ","),
+				ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+			}
+			if body["cert_type"] == "host" {
+				cert.CertType = ssh.HostCert
+			}
+			if err := cert.SignCert(rand.Reader, ca); err != nil {
+				t.Errorf("SignCert failed: %v", err)
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]string{"signed_key": string(ssh.MarshalAuthorizedKey(cert))},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors": []}`))
+		}
+	}))
+	t.Cleanup(vault.Close)
+	return vault
+}
+
+func TestVaultReadSecret(t *testing.T) {
+	_, ca := newTestKeyPair(t)
+	vault := newFakeVault(t, ca, map[string]string{})
+	client := &VaultClient{Address: vault.URL + "/", Token: "s.token"}
+
+	tests := []struct {
+		path, field string
+		want        string
+		wantErr     bool
+	}{
+		{"kv/gossh", "private_key", "v1 key", false},
+		{"secret/data/gossh", "private_key", "v2 key", false},
+		{"/secret/data/gossh", "private_key", "v2 key", false},
+		{"secret/data/gossh", "password", "", true},
+		{"secret/data/missing", "private_key", "", true},
+	}
+	for _, tt := range tests {
+		got, err := client.ReadSecret(context.Background(), tt.path, tt.field)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ReadSecret(%q, %q) = %q, %v, want %q, error %v", tt.path, tt.field, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	denied := &VaultClient{Address: vault.URL, Token: "wrong"}
+	if _, err := denied.ReadSecret(context.Background(), "kv/gossh", "private_key"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("ReadSecret with a bad token error = %v, want Vault's error message", err)
+	}
+}
+
+func TestVaultSignKey(t *testing.T) {
+	_, ca := newTestKeyPair(t)
+	_, signer := newTestKeyPair(t)
+	signed := map[string]string{}
+	client := &VaultClient{Address: newFakeVault(t, ca, signed).URL, Token: "s.token"}
+
+	cert, err := client.SignKey(context.Background(), VaultSignRequest{
+		Role:       "dev",
+		PublicKey:  signer.PublicKey(),
+		Principals: []string{"alice", "backup"},
+		TTL:        5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("SignKey error = %v", err)
+	}
+	if cert.CertType != ssh.UserCert || cert.ValidPrincipals[1] != "backup" {
+		t.Errorf("certificate = %+v, want a user certificate for alice and backup", cert)
+	}
+	if signed["cert_type"] != "user" || signed["ttl"] != "5m0s" || signed["valid_principals"] != "alice,backup" {
+		t.Errorf("sign request = %v", signed)
+	}
+
+	clear(signed)
+	cert, err = client.SignKey(context.Background(), VaultSignRequest{
+		Mount:      "/ssh-hosts/",
+		Role:       "host",
+		PublicKey:  signer.PublicKey(),
+		Principals: []string{"ssh.example.com"},
+		Host:       true,
+	})
+	if err != nil {
+		t.Fatalf("SignKey(host) error = %v", err)
+	}
+	if cert.CertType != ssh.HostCert {
+		t.Errorf("certificate type = %d, want a host certificate", cert.CertType)
+	}
+	if _, ok := signed["ttl"]; ok || signed["cert_type"] != "host" {
+		t.Errorf("sign request = %v, want a host certificate without a ttl", signed)
+	}
+
+	if _, err := client.SignKey(context.Background(), VaultSignRequest{Role: "ops", PublicKey: signer.PublicKey()}); err == nil {
+		t.Error("SignKey with an unknown role should fail")
+	}
+	if _, err := client.SignKey(context.Background(), VaultSignRequest{Role: "dev"}); err == nil {
+		t.Error("SignKey without a public key should fail")
+	}
+}
+
+func TestNewVaultClientFromEnv(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("VAULT_ADDR", "")
+	t.Setenv("VAULT_TOKEN", "")
+	t.Setenv("VAULT_NAMESPACE", "team")
+	if _, err := NewVaultClientFromEnv(); err == nil {
+		t.Error("NewVaultClientFromEnv should fail without VAULT_ADDR")
+	}
+
+	t.Setenv("VAULT_ADDR", "https://vault.example.com:8200")
+	if _, err := NewVaultClientFromEnv(); err == nil {
+		t.Error("NewVaultClientFromEnv should fail without a token")
+	}
+
+	if err := os.WriteFile(filepath.Join(home, ".vault-token"), []byte("s.file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewVaultClientFromEnv()
+	if err != nil {
+		t.Fatalf("NewVaultClientFromEnv error = %v", err)
+	}
+	if client.Token != "s.file" || client.Namespace != "team" {
+		t.Errorf("client = %+v, want the token from ~/.vault-token", client)
+	}
+
+	t.Setenv("VAULT_TOKEN", "s.env")
+	if client, _ := NewVaultClientFromEnv(); client.Token != "s.env" {
+		t.Errorf("token = %q, want VAULT_TOKEN to win", client.Token)
+	}
+}
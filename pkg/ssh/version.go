@@ -0,0 +1,99 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+)
+
+// versionPrefix starts every SSH 2.0 identification string
+const versionPrefix = "SSH-2.0-"
+
+// honeypotServerVersion is what honeypots announce unless configured
+// otherwise, matching the Ubuntu host their fake shell imitates
+const honeypotServerVersion = "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.6"
+
+// maxVersionLength is the longest identification line RFC 4253 allows,
+// CR LF included
+const maxVersionLength = 255
+
+// errClientVersionDenied fails the handshake of clients matching DenyClientVersions
+var errClientVersionDenied = errors.New("client version denied")
+
+// serverVersion returns the identification string announced to clients,
+// adding the SSH-2.0- prefix to a bare software version, or "" for the default
+func (o ServerOptions) serverVersion() (string, error) {
+	version := o.ServerVersion
+	if version == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(version, versionPrefix) {
+		version = versionPrefix + version
+	}
+	if len(version) > maxVersionLength-2 || strings.ContainsAny(version, "\r\n") {
+		return "", fmt.Errorf("invalid server version %q", o.ServerVersion)
+	}
+	return version, nil
+}
+
+// clientVersionDenied reports whether a client identification string
+// matches one of the DenyClientVersions patterns
+func (o ServerOptions) clientVersionDenied(version string) bool {
+	for _, pattern := range o.DenyClientVersions {
+		if ok, _ := path.Match(pattern, version); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// versionConn watches the identification line a client sends before the key
+// exchange, and fails the connection as soon as it is read when it matches
+// DenyClientVersions. The server still announces its own version first, so
+// scanners that wait for it are identified too.
+type versionConn struct {
+	net.Conn
+	opts *ServerOptions
+	// line collects the identification line until its newline arrives
+	line    []byte
+	checked bool
+	// denied is the client's version once it has been refused
+	denied string
+}
+
+// Read passes data through until a denied identification line is seen
+func (c *versionConn) Read(p []byte) (int, error) {
+	if c.denied != "" {
+		return 0, errClientVersionDenied
+	}
+	n, err := c.Conn.Read(p)
+	if !c.checked && n > 0 {
+		c.inspect(p[:n])
+		if c.denied != "" {
+			return 0, errClientVersionDenied
+		}
+	}
+	return n, err
+}
+
+// inspect accumulates the identification line and checks it once complete
+func (c *versionConn) inspect(data []byte) {
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		c.line = append(c.line, data...)
+		if len(c.line) > maxVersionLength {
+			c.checked = true
+		}
+		return
+	}
+	c.line = append(c.line, data[:end]...)
+	c.checked = true
+	version := strings.TrimSuffix(string(c.line), "\r")
+	if c.opts.clientVersionDenied(version) {
+		c.denied = version
+	}
+	c.line = nil
+}
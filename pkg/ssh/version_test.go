@@ -0,0 +1,111 @@
+// pkg/ssh/version_test.go
+package ssh
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestServerVersionOption(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{"OpenSSH_9.6", "SSH-2.0-OpenSSH_9.6", false},
+		{"SSH-2.0-gossh", "SSH-2.0-gossh", false},
+		{"evil\r\nSSH-2.0-x", "", true},
+		{strings.Repeat("x", 250), "", true},
+	}
+	for _, tt := range tests {
+		got, err := ServerOptions{ServerVersion: tt.version}.serverVersion()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("serverVersion(%q) = %q, %v, want %q, error %v", tt.version, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestClientVersionDenied(t *testing.T) {
+	opts := ServerOptions{DenyClientVersions: []string{"SSH-2.0-libssh*", "*Nmap*", "SSH-2.0-Go"}}
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"SSH-2.0-libssh_0.9.6", true},
+		{"SSH-2.0-Nmap-SSH2-Hostkey", true},
+		{"SSH-2.0-Go", true},
+		{"SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13", false},
+		{"SSH-2.0-PuTTY_Release_0.80", false},
+	}
+	for _, tt := range tests {
+		if got := opts.clientVersionDenied(tt.version); got != tt.want {
+			t.Errorf("clientVersionDenied(%q) = %v, want %v", tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestVersionConnSplitLine(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &versionConn{Conn: server, opts: &ServerOptions{DenyClientVersions: []string{"SSH-2.0-libssh*"}}}
+	go func() {
+		client.Write([]byte("SSH-2.0-lib"))
+		client.Write([]byte("ssh_0.9.6\r\n"))
+	}()
+
+	buf := make([]byte, 64)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "SSH-2.0-lib" {
+		t.Fatalf("first Read = %q, %v, want the partial line", buf[:n], err)
+	}
+	if _, err := conn.Read(buf); err != errClientVersionDenied {
+		t.Errorf("second Read error = %v, want errClientVersionDenied", err)
+	}
+	if conn.denied != "SSH-2.0-libssh_0.9.6" {
+		t.Errorf("denied = %q", conn.denied)
+	}
+}
+
+func TestServerDeniesClientVersions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	audit := &lockedBuffer{}
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{
+		ServerVersion:      "OpenSSH_9.6",
+		DenyClientVersions: []string{"SSH-2.0-libssh*"},
+		AuditLog:           audit,
+	})
+
+	dial := func(version string) (*ssh.Client, error) {
+		return ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            "alice",
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			ClientVersion:   version,
+			Timeout:         time.Second,
+		})
+	}
+	if client, err := dial("SSH-2.0-libssh_0.10.6"); err == nil {
+		client.Close()
+		t.Fatal("a denied client version should not connect")
+	}
+	rejected := waitForEvent(t, audit, func(e AuditEvent) bool { return e.Event == "client_version_rejected" })
+	if rejected.ClientVersion != "SSH-2.0-libssh_0.10.6" || rejected.RemoteAddr == "" {
+		t.Errorf("rejection event = %+v", rejected)
+	}
+
+	client, err := dial("SSH-2.0-OpenSSH_9.6")
+	if err != nil {
+		t.Fatalf("other clients should connect: %v", err)
+	}
+	defer client.Close()
+	if got := string(client.ServerVersion()); got != "SSH-2.0-OpenSSH_9.6" {
+		t.Errorf("server version = %q, want SSH-2.0-OpenSSH_9.6", got)
+	}
+}
@@ -0,0 +1,224 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh/internal/wire"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// x11Request asks the server to forward X11 connections of a session to
+	// the client's display (ssh -X)
+	x11Request = "x11-req"
+	// x11Channel is the channel type opened back to the client for each X11
+	// connection
+	x11Channel = "x11"
+	// x11AuthProtocol is the only X11 authorization protocol forwarded
+	x11AuthProtocol = "MIT-MAGIC-COOKIE-1"
+	// x11DisplayOffset is the first display number given to sessions,
+	// leaving the lower ones to local X servers
+	x11DisplayOffset = 10
+	// x11MaxDisplays bounds the display numbers tried
+	x11MaxDisplays = 1000
+	// x11BasePort is the TCP port of display 0
+	x11BasePort = 6000
+	// x11FamilyWild marks an Xauthority entry that matches any host
+	x11FamilyWild = 0xffff
+)
+
+// x11ChannelData is the extra data of an "x11" channel open (RFC 4254 section 6.3.2)
+type x11ChannelData struct {
+	OriginatorAddress string
+	OriginatorPort    uint32
+}
+
+// x11Permitted reports whether the session may forward X11 connections
+func (s *session) x11Permitted() bool {
+	return !s.opts.DisableX11Forwarding && permitted(s.conn, "permit-X11-forwarding")
+}
+
+// forwardX11 listens on the loopback port of a free display and points
+// DISPLAY and XAUTHORITY of the session's programs at it. Programs are given
+// a random cookie in place of the client's, which is substituted when their
+// connections are relayed, so the client's cookie never reaches the server.
+// The listener and the Xauthority file are removed when the session ends.
+func (s *session) forwardX11(req wire.X11Request) error {
+	if s.x11Display != "" {
+		return errors.New("X11 forwarding is already set up")
+	}
+	if s.root != "" {
+		// The Xauthority file would live outside the chroot directory
+		return errors.New("X11 forwarding is not available in chroot sessions")
+	}
+	if req.AuthProtocol != x11AuthProtocol {
+		return fmt.Errorf("unsupported X11 authorization protocol %q", req.AuthProtocol)
+	}
+	cookie, err := hex.DecodeString(req.AuthCookie)
+	if err != nil || len(cookie) == 0 {
+		return errors.New("invalid X11 authorization cookie")
+	}
+	fake := make([]byte, len(cookie))
+	if _, err := rand.Read(fake); err != nil {
+		return fmt.Errorf("could not generate X11 cookie: %s", err)
+	}
+
+	listener, display, err := listenX11()
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "gossh-x11-")
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("could not create Xauthority directory: %s", err)
+	}
+	authority := filepath.Join(dir, "Xauthority")
+	if err := os.WriteFile(authority, xauthorityEntry(display, fake), 0o600); err != nil {
+		listener.Close()
+		os.RemoveAll(dir)
+		return fmt.Errorf("could not write Xauthority: %s", err)
+	}
+	if s.account != nil {
+		// Programs run as the user's account must be able to read it
+		for _, name := range []string{dir, authority} {
+			if err := os.Lchown(name, int(s.account.uid), int(s.account.gid)); err != nil {
+				listener.Close()
+				os.RemoveAll(dir)
+				return fmt.Errorf("could not hand the Xauthority file to %s: %s", s.account.name, err)
+			}
+		}
+	}
+	go func() {
+		<-s.ctx.Done()
+		listener.Close()
+		os.RemoveAll(dir)
+	}()
+	go s.serveX11(listener, req.SingleConnection, fake, cookie)
+
+	s.x11Display = fmt.Sprintf("localhost:%d.%d", display, req.ScreenNumber)
+	s.env = append(s.env, "DISPLAY="+s.x11Display, "XAUTHORITY="+authority)
+	s.logger.Info("forwarding X11", "display", s.x11Display)
+	return nil
+}
+
+// listenX11 listens on the loopback port of the first free display number
+func listenX11() (net.Listener, int, error) {
+	for display := x11DisplayOffset; display < x11DisplayOffset+x11MaxDisplays; display++ {
+		listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(x11BasePort+display)))
+		if err == nil {
+			return listener, display, nil
+		}
+	}
+	return nil, 0, errors.New("no free X11 display")
+}
+
+// xauthorityEntry encodes an Xauthority file entry granting cookie for the
+// display on any host
+func xauthorityEntry(display int, cookie []byte) []byte {
+	var buf bytes.Buffer
+	field := func(data []byte) {
+		binary.Write(&buf, binary.BigEndian, uint16(len(data)))
+		buf.Write(data)
+	}
+	binary.Write(&buf, binary.BigEndian, uint16(x11FamilyWild))
+	field(nil)
+	field([]byte(strconv.Itoa(display)))
+	field([]byte(x11AuthProtocol))
+	field(cookie)
+	return buf.Bytes()
+}
+
+// serveX11 relays the connections accepted on the display to the client
+// until the listener is closed, or after the first one when the client asked
+// for a single connection
+func (s *session) serveX11(listener net.Listener, single bool, fake, cookie []byte) {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		if single {
+			listener.Close()
+		}
+		go s.relayX11(local, fake, cookie)
+	}
+}
+
+// relayX11 checks the cookie of an X11 connection, swaps in the client's and
+// relays the connection over a new channel
+func (s *session) relayX11(local net.Conn, fake, cookie []byte) {
+	setup, err := replaceX11Cookie(local, fake, cookie)
+	if err != nil {
+		s.logger.Warn("refusing X11 connection", "error", err)
+		local.Close()
+		return
+	}
+	origin := local.RemoteAddr().(*net.TCPAddr)
+	channel, requests, err := s.conn.OpenChannel(x11Channel, ssh.Marshal(x11ChannelData{
+		OriginatorAddress: origin.IP.String(),
+		OriginatorPort:    uint32(origin.Port),
+	}))
+	if err != nil {
+		s.logger.Warn("could not reach the client's X server", "error", err)
+		local.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	if _, err := channel.Write(setup); err != nil {
+		channel.Close()
+		local.Close()
+		return
+	}
+	relay(channel, local)
+}
+
+// replaceX11Cookie reads the connection setup an X11 client sends first and
+// returns it with the client's cookie in place of fake. Connections that do
+// not present fake are refused.
+func replaceX11Cookie(r io.Reader, fake, cookie []byte) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("could not read X11 setup: %s", err)
+	}
+	var order binary.ByteOrder
+	switch header[0] {
+	case 'B':
+		order = binary.BigEndian
+	case 'l':
+		order = binary.LittleEndian
+	default:
+		return nil, fmt.Errorf("invalid X11 byte order %q", header[0])
+	}
+	nameLen := int(order.Uint16(header[6:]))
+	dataLen := int(order.Uint16(header[8:]))
+	auth := make([]byte, pad4(nameLen)+pad4(dataLen))
+	if _, err := io.ReadFull(r, auth); err != nil {
+		return nil, fmt.Errorf("could not read X11 authorization: %s", err)
+	}
+	name := auth[:nameLen]
+	data := auth[pad4(nameLen) : pad4(nameLen)+dataLen]
+	if string(name) != x11AuthProtocol || !bytes.Equal(data, fake) {
+		return nil, errors.New("X11 connection presented the wrong cookie")
+	}
+
+	setup := append([]byte(nil), header...)
+	order.PutUint16(setup[8:], uint16(len(cookie)))
+	setup = append(setup, auth[:pad4(nameLen)]...)
+	setup = append(setup, cookie...)
+	return append(setup, make([]byte, pad4(len(cookie))-len(cookie))...), nil
+}
+
+// pad4 rounds n up to a multiple of four, as X11 pads its fields
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,166 @@
+// pkg/ssh/x11_test.go
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// x11Setup encodes the connection setup of an X11 client presenting cookie
+func x11Setup(order binary.ByteOrder, orderByte byte, cookie []byte) []byte {
+	header := make([]byte, 12)
+	header[0] = orderByte
+	order.PutUint16(header[2:], 11)
+	order.PutUint16(header[6:], uint16(len(x11AuthProtocol)))
+	order.PutUint16(header[8:], uint16(len(cookie)))
+	name := append([]byte(x11AuthProtocol), make([]byte, pad4(len(x11AuthProtocol))-len(x11AuthProtocol))...)
+	data := append(append([]byte(nil), cookie...), make([]byte, pad4(len(cookie))-len(cookie))...)
+	return append(append(header, name...), data...)
+}
+
+func TestReplaceX11Cookie(t *testing.T) {
+	fake := bytes.Repeat([]byte{0xaa}, 16)
+	clientCookie := bytes.Repeat([]byte{0x55}, 16)
+	tests := []struct {
+		name    string
+		setup   []byte
+		want    []byte
+		wantErr bool
+	}{
+		{"big endian", x11Setup(binary.BigEndian, 'B', fake), x11Setup(binary.BigEndian, 'B', clientCookie), false},
+		{"little endian", x11Setup(binary.LittleEndian, 'l', fake), x11Setup(binary.LittleEndian, 'l', clientCookie), false},
+		{"wrong cookie", x11Setup(binary.BigEndian, 'B', clientCookie), nil, true},
+		{"invalid byte order", append([]byte{'x'}, x11Setup(binary.BigEndian, 'B', fake)[1:]...), nil, true},
+		{"truncated", x11Setup(binary.BigEndian, 'B', fake)[:20], nil, true},
+	}
+	for _, tt := range tests {
+		got, err := replaceX11Cookie(bytes.NewReader(tt.setup), fake, clientCookie)
+		if (err != nil) != tt.wantErr || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: replaceX11Cookie() = %x, %v, want %x", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestX11Forwarding(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires /bin/sh")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, addr, signer, _ := startTestServer(t, ctx, ServerOptions{Shell: "/bin/sh"})
+	client := dialTestServer(t, addr, "alice", signer)
+	defer client.Close()
+
+	// The client's X server checks the cookie of each connection it is sent
+	cookie := bytes.Repeat([]byte{0x42}, 16)
+	displayed := make(chan []byte, 1)
+	channels := client.HandleChannelOpen(x11Channel)
+	go func() {
+		for newChannel := range channels {
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			setup := make([]byte, len(x11Setup(binary.BigEndian, 'B', cookie)))
+			io.ReadFull(channel, setup)
+			displayed <- setup
+			channel.Close()
+		}
+	}()
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer session.Close()
+	ok, err := session.SendRequest(x11Request, true, ssh.Marshal(struct {
+		SingleConnection bool
+		AuthProtocol     string
+		AuthCookie       string
+		ScreenNumber     uint32
+	}{false, x11AuthProtocol, hex.EncodeToString(cookie), 0}))
+	if err != nil || !ok {
+		t.Fatalf("x11-req should be granted: %v", err)
+	}
+	stdin, _ := session.StdinPipe()
+	stdout, _ := session.StdoutPipe()
+	if err := session.Start(`echo "$DISPLAY $XAUTHORITY"; cat`); err != nil {
+		t.Fatalf("Failed to start command: %v", err)
+	}
+	defer stdin.Close()
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("Failed to read DISPLAY: %v", err)
+	}
+	display, authority, _ := strings.Cut(strings.TrimSpace(line), " ")
+	host, screen, _ := strings.Cut(strings.TrimPrefix(display, "localhost:"), ".")
+	if !strings.HasPrefix(display, "localhost:") || screen != "0" {
+		t.Fatalf("DISPLAY = %q, want localhost:N.0", display)
+	}
+
+	// Programs are given a cookie of their own, swapped for the client's
+	entry, err := os.ReadFile(authority)
+	if err != nil {
+		t.Fatalf("Failed to read XAUTHORITY: %v", err)
+	}
+	fake := entry[len(entry)-len(cookie):]
+	if bytes.Equal(fake, cookie) {
+		t.Error("the client's cookie should not be written on the server")
+	}
+	number, err := strconv.Atoi(host)
+	if err != nil {
+		t.Fatalf("DISPLAY = %q has no display number", display)
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(x11BasePort+number)))
+	if err != nil {
+		t.Fatalf("Failed to connect to the display: %v", err)
+	}
+	defer conn.Close()
+	conn.Write(x11Setup(binary.BigEndian, 'B', fake))
+	select {
+	case setup := <-displayed:
+		if want := x11Setup(binary.BigEndian, 'B', cookie); !bytes.Equal(setup, want) {
+			t.Errorf("client's X server got setup %x, want %x", setup, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the X11 connection was not forwarded to the client")
+	}
+
+	// Connections without the cookie are refused before reaching the client
+	intruder, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(x11BasePort+number)))
+	if err != nil {
+		t.Fatalf("Failed to connect to the display: %v", err)
+	}
+	defer intruder.Close()
+	intruder.Write(x11Setup(binary.BigEndian, 'B', bytes.Repeat([]byte{1}, 16)))
+	intruder.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := intruder.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection with a wrong cookie read error = %v, want EOF", err)
+	}
+
+	_, addr, signer, _ = startTestServer(t, ctx, ServerOptions{Shell: "/bin/sh", DisableX11Forwarding: true})
+	disabled := dialTestServer(t, addr, "alice", signer)
+	defer disabled.Close()
+	other, err := disabled.NewSession()
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	defer other.Close()
+	if ok, _ := other.SendRequest(x11Request, true, nil); ok {
+		t.Error("x11-req should be refused when X11 forwarding is disabled")
+	}
+}
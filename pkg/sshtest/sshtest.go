@@ -0,0 +1,227 @@
+// Package sshtest starts gossh servers for tests, so code that talks to a
+// gossh server, or embeds one, can be tested against the real thing
+package sshtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+// DefaultUser is the user ClientConfig logs in as
+const DefaultUser = "test"
+
+// AuthAttempt is an authentication attempt the server saw
+type AuthAttempt struct {
+	User string
+	// Method is publickey, password or keyboard-interactive
+	Method      string
+	Fingerprint string
+	Success     bool
+	// Error is why a failed attempt was rejected
+	Error string
+}
+
+// Command is a command line a client asked the server to run
+type Command struct {
+	User    string
+	Command string
+	// Allowed is false when AllowedCommands refused the command
+	Allowed bool
+}
+
+// Server is a gossh server listening on a loopback port for the duration of
+// a test. It is closed when the test ends.
+type Server struct {
+	*ssh.Server
+	// Addr is the host:port the server listens on
+	Addr string
+	// ClientConfig logs in as DefaultUser with ClientSigner and verifies
+	// the host key. Copy it to change the user or authentication methods.
+	ClientConfig *cryptossh.ClientConfig
+	// ClientSigner is a key the server accepts for every user
+	ClientSigner cryptossh.Signer
+	// HostKey is the server's host key
+	HostKey cryptossh.PublicKey
+
+	mu       sync.Mutex
+	attempts []AuthAttempt
+	commands []Command
+	events   []ssh.AuditEvent
+	// partial holds an audit line until its newline is written
+	partial []byte
+}
+
+// StartTestServer starts a server with opts on 127.0.0.1, generating its
+// host key and a client key it authorizes. Authentication attempts and
+// commands are recorded for inspection; the options' AuditLog, when set,
+// still receives everything.
+func StartTestServer(t testing.TB, opts ssh.ServerOptions) *Server {
+	t.Helper()
+	hostKey, hostSigner := generateKey(t)
+	_, clientSigner := generateKey(t)
+
+	s := &Server{
+		ClientSigner: clientSigner,
+		HostKey:      hostSigner.PublicKey(),
+		ClientConfig: &cryptossh.ClientConfig{
+			User:            DefaultUser,
+			Auth:            []cryptossh.AuthMethod{cryptossh.PublicKeys(clientSigner)},
+			HostKeyCallback: cryptossh.FixedHostKey(hostSigner.PublicKey()),
+			Timeout:         5 * time.Second,
+		},
+	}
+	if opts.AuditLog != nil {
+		opts.AuditLog = io.MultiWriter(opts.AuditLog, auditWriter{s})
+	} else {
+		opts.AuditLog = auditWriter{s}
+	}
+	server, err := ssh.NewServer(hostKey, cryptossh.MarshalAuthorizedKey(clientSigner.PublicKey()), opts)
+	if err != nil {
+		t.Fatalf("sshtest: NewServer failed: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("sshtest: could not listen: %v", err)
+	}
+	s.Server = server
+	s.Addr = listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- server.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, ssh.ErrServerClosed) {
+			t.Errorf("sshtest: Serve failed: %v", err)
+		}
+		server.Wait()
+	})
+	return s
+}
+
+// Dial connects to the server with ClientConfig. The client is closed when
+// the test ends.
+func (s *Server) Dial(t testing.TB) *cryptossh.Client {
+	t.Helper()
+	client, err := cryptossh.Dial("tcp", s.Addr, s.ClientConfig)
+	if err != nil {
+		t.Fatalf("sshtest: could not connect to %s: %v", s.Addr, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	s.waitForLogin(client)
+	return client
+}
+
+// loginRecordTimeout bounds how long Dial waits for the server to record a
+// login
+const loginRecordTimeout = 5 * time.Second
+
+// waitForLogin waits until the server has recorded the login of client,
+// which it does just after the client sees it succeed
+func (s *Server) waitForLogin(client *cryptossh.Client) {
+	id := hex.EncodeToString(client.SessionID()[:8])
+	for deadline := time.Now().Add(loginRecordTimeout); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		s.mu.Lock()
+		for _, event := range s.events {
+			if event.Event == "auth_success" && event.ConnID == id {
+				s.mu.Unlock()
+				return
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// AuthAttempts returns the authentication attempts made so far, in order.
+// The free "none" probe clients start with is not included. A login is
+// recorded once the server has verified it, just after the client sees it
+// succeed; Dial waits for that.
+func (s *Server) AuthAttempts() []AuthAttempt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuthAttempt(nil), s.attempts...)
+}
+
+// Commands returns the exec requests made so far, in order
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Command(nil), s.commands...)
+}
+
+// Events returns every audit event the server has recorded so far
+func (s *Server) Events() []ssh.AuditEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ssh.AuditEvent(nil), s.events...)
+}
+
+// auditWriter receives the audit log of a Server, one JSON event per line
+type auditWriter struct {
+	s *Server
+}
+
+func (w auditWriter) Write(p []byte) (int, error) {
+	s := w.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partial = append(s.partial, p...)
+	for {
+		line, rest, found := bytes.Cut(s.partial, []byte("\n"))
+		if !found {
+			break
+		}
+		s.partial = rest
+		var event ssh.AuditEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return len(p), err
+		}
+		s.recordEvent(event)
+	}
+	return len(p), nil
+}
+
+// recordEvent keeps an audit event and the login or command it describes.
+// Logins are only recorded once the server has decided them, so a key the
+// client offers but can't sign with is no successful attempt. s.mu must be
+// held.
+func (s *Server) recordEvent(event ssh.AuditEvent) {
+	s.events = append(s.events, event)
+	switch event.Event {
+	case "auth_success", "auth_failure":
+		s.attempts = append(s.attempts, AuthAttempt{
+			User:        event.User,
+			Method:      event.Method,
+			Fingerprint: event.Fingerprint,
+			Success:     event.Event == "auth_success",
+			Error:       event.Error,
+		})
+	case "exec":
+		s.commands = append(s.commands, Command{User: event.User, Command: event.Command, Allowed: event.Error == ""})
+	}
+}
+
+// generateKey returns a new Ed25519 key in PEM format and its signer
+func generateKey(t testing.TB) ([]byte, cryptossh.Signer) {
+	t.Helper()
+	key, err := ssh.GenerateHostKey("ed25519")
+	if err != nil {
+		t.Fatalf("sshtest: could not generate key: %v", err)
+	}
+	signer, err := cryptossh.ParsePrivateKey(key)
+	if err != nil {
+		t.Fatalf("sshtest: could not parse key: %v", err)
+	}
+	return key, signer
+}
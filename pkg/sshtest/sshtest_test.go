@@ -0,0 +1,110 @@
+// pkg/sshtest/sshtest_test.go
+package sshtest
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/bxtal-lsn/gossh/pkg/ssh"
+	cryptossh "golang.org/x/crypto/ssh"
+)
+
+func TestStartTestServer(t *testing.T) {
+	srv := StartTestServer(t, ssh.ServerOptions{AllowedCommands: []string{"whoami"}})
+	client := srv.Dial(t)
+
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	out, err := session.Output("whoami")
+	if err != nil || string(out) != "You are: "+DefaultUser+"\n" {
+		t.Errorf("whoami = %q, %v", out, err)
+	}
+	session, err = client.NewSession()
+	if err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	session.Run("rm -rf /")
+
+	want := []Command{{DefaultUser, "whoami", true}, {DefaultUser, "rm -rf /", false}}
+	got := srv.Commands()
+	if len(got) != len(want) {
+		t.Fatalf("Commands() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("command %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestAuthAttempts(t *testing.T) {
+	hookErr := errors.New("mallory is locked out")
+	srv := StartTestServer(t, ssh.ServerOptions{Hooks: ssh.Hooks{
+		OnAuth: func(ctx ssh.SessionContext, method string) error {
+			if ctx.User() == "mallory" {
+				return hookErr
+			}
+			return nil
+		},
+	}})
+
+	_, stranger := generateKey(t)
+	config := *srv.ClientConfig
+	config.User = "alice"
+	config.Auth = []cryptossh.AuthMethod{cryptossh.PublicKeys(stranger, srv.ClientSigner)}
+	client, err := cryptossh.Dial("tcp", srv.Addr, &config)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	client.Close()
+
+	config.User = "mallory"
+	config.Auth = []cryptossh.AuthMethod{cryptossh.PublicKeys(srv.ClientSigner)}
+	// A login the hook rejects is disconnected before it can open a channel
+	if client, err := cryptossh.Dial("tcp", srv.Addr, &config); err == nil {
+		_, err = client.NewSession()
+		client.Close()
+		if err == nil {
+			t.Error("the OnAuth hook of the options should still apply")
+		}
+	}
+
+	// A client that offers the authorized key but cannot sign never logs in
+	config.User = "bob"
+	config.Auth = []cryptossh.AuthMethod{cryptossh.PublicKeys(unsignedSigner{srv.ClientSigner})}
+	if client, err := cryptossh.Dial("tcp", srv.Addr, &config); err == nil {
+		client.Close()
+		t.Error("Dial without a signature succeeded")
+	}
+
+	attempts := srv.AuthAttempts()
+	for _, a := range attempts {
+		if a.User == "bob" && a.Success {
+			t.Errorf("attempt %+v recorded a login without a signature", a)
+		}
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("AuthAttempts() = %+v, want 3 attempts", attempts)
+	}
+	if a := attempts[0]; a.User != "alice" || a.Success || a.Fingerprint != cryptossh.FingerprintSHA256(stranger.PublicKey()) {
+		t.Errorf("first attempt = %+v, want the unknown key rejected", a)
+	}
+	if a := attempts[1]; a.User != "alice" || !a.Success || a.Method != "publickey" || a.Fingerprint != cryptossh.FingerprintSHA256(srv.ClientSigner.PublicKey()) {
+		t.Errorf("second attempt = %+v, want the authorized key accepted", a)
+	}
+	if a := attempts[2]; a.User != "mallory" || a.Success || a.Error == "" {
+		t.Errorf("third attempt = %+v, want the hook's rejection", a)
+	}
+}
+
+// unsignedSigner offers a public key without holding its private key
+type unsignedSigner struct {
+	cryptossh.Signer
+}
+
+func (unsignedSigner) Sign(io.Reader, []byte) (*cryptossh.Signature, error) {
+	return nil, errors.New("no private key")
+}